@@ -0,0 +1,293 @@
+// Package block defines blocks, the Merkle trees committing to their transactions, and the hash functions
+// and consensus engines that seal them.
+package block
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// Block represents a single block in the blockchain containing metadata and a list of transactions.
+type Block struct {
+	timestamp    int64
+	nonce        int
+	previousHash [32]byte
+	merkleRoot   [32]byte
+	stateRoot    *[32]byte // commits to the contracts after the block; nil while there are none
+	accountsRoot *[32]byte // the root of the accounts trie after the block; nil on chains from before it
+	transactions []*transaction.Transaction
+
+	// Set by consensus engines that sign blocks (ProofOfAuthority); not part of the header hash.
+	signerPublicKey *ecdsa.PublicKey
+	signature       []byte
+
+	// pruned marks a header whose transactions were left out because a snapshot holds their effect.
+	pruned bool
+
+	// hash is the header's hash, stored when the block joins a chain; see CacheHash.
+	hash blockHash
+}
+
+// blockHash is a block's hash under the hasher named hasher; an empty name means none is stored.
+type blockHash struct {
+	hasher string
+	sum    [32]byte
+}
+
+// NewBlock constructs a new Block with the given nonce, previous hash, and transactions.
+func NewBlock(nonce int, previousHash [32]byte, transactions []*transaction.Transaction) *Block {
+	return &Block{
+		nonce:        nonce,
+		previousHash: previousHash,
+		timestamp:    time.Now().UnixNano(),
+		merkleRoot:   MerkleRoot(transactions),
+		transactions: transactions,
+	}
+}
+
+// Timestamp returns when the block was created, in nanoseconds since the Unix epoch.
+func (b *Block) Timestamp() int64 {
+	return b.timestamp
+}
+
+// SetTimestamp sets when the block was created, in nanoseconds since the Unix epoch.
+func (b *Block) SetTimestamp(timestamp int64) {
+	b.timestamp = timestamp
+	b.hash = blockHash{}
+}
+
+// Nonce returns the nonce that seals the block under proof of work.
+func (b *Block) Nonce() int {
+	return b.nonce
+}
+
+// SetNonce sets the nonce that seals the block, forgetting any hash stored for the old one.
+func (b *Block) SetNonce(nonce int) {
+	b.nonce = nonce
+	b.hash = blockHash{}
+}
+
+// PreviousHash returns the hash of the block before this one.
+func (b *Block) PreviousHash() [32]byte {
+	return b.previousHash
+}
+
+// StateRoot returns the hash committing to the contracts after the block, or nil while there are none.
+func (b *Block) StateRoot() *[32]byte {
+	return b.stateRoot
+}
+
+// AccountsRoot returns the root of the accounts trie after the block, or nil on chains from before it.
+func (b *Block) AccountsRoot() *[32]byte {
+	return b.accountsRoot
+}
+
+// SetRoots sets the state and accounts roots the block commits to.
+func (b *Block) SetRoots(stateRoot, accountsRoot *[32]byte) {
+	b.stateRoot, b.accountsRoot = stateRoot, accountsRoot
+	b.hash = blockHash{}
+}
+
+// Transactions returns the block's transactions, or none if it is a pruned header.
+func (b *Block) Transactions() []*transaction.Transaction {
+	return b.transactions
+}
+
+// SetTransactions replaces the block's transactions without touching its header, to drop them from a
+// copy sent as a header or to fill them back in.
+func (b *Block) SetTransactions(transactions []*transaction.Transaction) {
+	b.transactions = transactions
+}
+
+// Pruned reports whether the block is a header whose transactions were left out because a snapshot
+// holds their effect.
+func (b *Block) Pruned() bool {
+	return b.pruned
+}
+
+// Prune drops the block's transactions and marks it a pruned header.
+func (b *Block) Prune() {
+	b.transactions = nil
+	b.pruned = true
+}
+
+// Print outputs the block details and all contained transactions to stdout.
+func (b *Block) Print() {
+	fmt.Printf("timestamp: %d\n", b.timestamp)
+	fmt.Printf("nonce: %d\n", b.nonce)
+	fmt.Printf("previousHash: %x\n", b.previousHash)
+	fmt.Printf("merkleRoot: %x\n", b.merkleRoot)
+	if b.signerPublicKey != nil {
+		fmt.Printf("signer: %s\n", transaction.NewAddress(b.signerPublicKey))
+	}
+	for _, t := range b.transactions {
+		t.Print()
+	}
+}
+
+// Hash returns the SHA-256 hash of the block header's JSON representation, the stored one if there is.
+// The header commits to the transactions through the Merkle root rather than the raw list.
+func (b *Block) Hash() [32]byte {
+	return b.HashWithCache(SHA256Hasher{})
+}
+
+// HashWithCache returns the hash stored by CacheHash if it was taken with h, and computes it otherwise.
+func (b *Block) HashWithCache(h Hasher) [32]byte {
+	if b.hash.hasher != "" && b.hash.hasher == h.Name() {
+		return b.hash.sum
+	}
+	return b.HashWith(h)
+}
+
+// CacheHash returns the block's hash under h, computing and storing it first unless it is stored, so the
+// chain's lookups stop marshaling the header. The header must not change afterwards; callers store it once
+// the block is sealed and joins a chain, before other goroutines can read it.
+func (b *Block) CacheHash(h Hasher) [32]byte {
+	if b.hash.hasher != h.Name() {
+		b.hash = blockHash{hasher: h.Name(), sum: b.HashWith(h)}
+	}
+	return b.hash.sum
+}
+
+// CheckHash recomputes the block's hash under h, the on-demand check behind ValidChain. A stored hash
+// that no longer matches means the header was changed after it was sealed; a block without one gets it.
+func (b *Block) CheckHash(h Hasher) error {
+	if b.hash.hasher == h.Name() {
+		if b.HashWith(h) != b.hash.sum {
+			return errors.New("block hash does not match its header")
+		}
+		return nil
+	}
+	if b.hash.hasher == "" {
+		b.CacheHash(h)
+	}
+	return nil
+}
+
+// HashWith hashes the block header's JSON representation with h; Blockchain.BlockHash uses the chain's hasher.
+func (b *Block) HashWith(h Hasher) [32]byte {
+	m := b.header()
+	// Hash runs once per nonce guess, so only build the log record when debug logging is on.
+	if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		slog.Debug("block_hash", "hasher", h.Name(), "header", string(m))
+	}
+	return h.Sum256(m)
+}
+
+// ProofHash returns the hash proof of work is checked against: that of the header without its timestamp.
+func (b *Block) ProofHash(h Hasher) [32]byte {
+	guess := Block{nonce: b.nonce, previousHash: b.previousHash, merkleRoot: b.merkleRoot, stateRoot: b.stateRoot, accountsRoot: b.accountsRoot}
+	return guess.HashWith(h)
+}
+
+// header returns the JSON representation of the block header that HashWith hashes.
+func (b *Block) header() []byte {
+	m, _ := json.Marshal(struct {
+		Timestamp    int64     `json:"timestamp"`
+		Nonce        int       `json:"nonce"`
+		PreviousHash [32]byte  `json:"previous_hash"`
+		MerkleRoot   [32]byte  `json:"merkle_root"`
+		StateRoot    *[32]byte `json:"state_root,omitempty"`
+		AccountsRoot *[32]byte `json:"accounts_root,omitempty"`
+	}{
+		Timestamp:    b.timestamp,
+		Nonce:        b.nonce,
+		PreviousHash: b.previousHash,
+		MerkleRoot:   b.merkleRoot,
+		StateRoot:    b.stateRoot,
+		AccountsRoot: b.accountsRoot,
+	})
+	return m
+}
+
+// MarshalJSON provides a custom JSON representation for Block fields.
+func (b *Block) MarshalJSON() ([]byte, error) {
+	var publicKey string
+	if b.signerPublicKey != nil {
+		k, _ := b.signerPublicKey.Bytes()
+		publicKey = fmt.Sprintf("%x", k)
+	}
+	return json.Marshal(struct {
+		Timestamp       int64                      `json:"timestamp"`
+		Nonce           int                        `json:"nonce"`
+		PreviousHash    [32]byte                   `json:"previous_hash"`
+		MerkleRoot      [32]byte                   `json:"merkle_root"`
+		StateRoot       *[32]byte                  `json:"state_root,omitempty"`
+		AccountsRoot    *[32]byte                  `json:"accounts_root,omitempty"`
+		Transactions    []*transaction.Transaction `json:"transactions"`
+		SignerPublicKey string                     `json:"signer_public_key,omitempty"`
+		Signature       string                     `json:"signature,omitempty"`
+		Pruned          bool                       `json:"pruned,omitempty"`
+	}{
+		Timestamp:       b.timestamp,
+		Nonce:           b.nonce,
+		PreviousHash:    b.previousHash,
+		MerkleRoot:      b.merkleRoot,
+		StateRoot:       b.stateRoot,
+		AccountsRoot:    b.accountsRoot,
+		Transactions:    b.transactions,
+		SignerPublicKey: publicKey,
+		Signature:       fmt.Sprintf("%x", b.signature),
+		Pruned:          b.pruned,
+	})
+}
+
+// UnmarshalJSON restores a Block from the representation produced by MarshalJSON.
+func (b *Block) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Timestamp       *int64                      `json:"timestamp"`
+		Nonce           *int                        `json:"nonce"`
+		PreviousHash    *[32]byte                   `json:"previous_hash"`
+		MerkleRoot      *[32]byte                   `json:"merkle_root"`
+		StateRoot       *[32]byte                   `json:"state_root"`
+		AccountsRoot    *[32]byte                   `json:"accounts_root"`
+		Transactions    *[]*transaction.Transaction `json:"transactions"`
+		SignerPublicKey string                      `json:"signer_public_key"`
+		Signature       string                      `json:"signature"`
+		Pruned          bool                        `json:"pruned"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Timestamp == nil || v.Nonce == nil || v.PreviousHash == nil {
+		return errors.New("block is missing timestamp, nonce, or previous_hash")
+	}
+	b.timestamp = *v.Timestamp
+	b.nonce = *v.Nonce
+	b.previousHash = *v.PreviousHash
+	b.transactions = nil
+	if v.Transactions != nil {
+		b.transactions = *v.Transactions
+	}
+	b.merkleRoot = MerkleRoot(b.transactions)
+	if v.MerkleRoot != nil {
+		b.merkleRoot = *v.MerkleRoot
+	}
+	b.stateRoot = v.StateRoot
+	b.accountsRoot = v.AccountsRoot
+	b.signerPublicKey = nil
+	b.signature = nil
+	b.pruned = v.Pruned
+	if v.SignerPublicKey != "" {
+		publicKey, err := transaction.PublicKeyFromString(v.SignerPublicKey)
+		if err != nil {
+			return err
+		}
+		b.signerPublicKey = publicKey
+	}
+	if v.Signature != "" {
+		signature, err := transaction.SignatureFromString(v.Signature)
+		if err != nil {
+			return err
+		}
+		b.signature = signature
+	}
+	return nil
+}
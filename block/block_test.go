@@ -0,0 +1,73 @@
+package block
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNonceGuessesAreLoggedOnlyAtDebugLevel(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var buf bytes.Buffer
+	pow := NewProofOfWork(1, nil)
+	b := NewBlock(0, [32]byte{}, testTransactions(1))
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	pow.ValidProof(b.Nonce(), b.PreviousHash(), b.MerkleRoot(), nil, nil)
+	if buf.Len() != 0 {
+		t.Fatalf("logged at info level: %s", buf.String())
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	pow.ValidProof(b.Nonce(), b.PreviousHash(), b.MerkleRoot(), nil, nil)
+	for _, want := range []string{"level=DEBUG msg=block_hash hasher=" + HASHER_SHA256, "level=DEBUG msg=valid_proof nonce=0 hash="} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("debug log %q lacks %q", buf.String(), want)
+		}
+	}
+}
+
+func TestCachedHashesAreForgottenWhenTheHeaderChanges(t *testing.T) {
+	b := NewBlock(0, [32]byte{}, testTransactions(2))
+	h := b.CacheHash(SHA256Hasher{})
+	if h != b.HashWith(SHA256Hasher{}) || b.Hash() != h {
+		t.Fatal("the cached hash is not the header's")
+	}
+	if b.HashWithCache(SHA3Hasher{}) != b.HashWith(SHA3Hasher{}) {
+		t.Fatal("HashWithCache returned the hash of another hasher")
+	}
+	// The cached hash is returned without marshaling the header again.
+	defer slog.SetDefault(slog.Default())
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	b.Hash()
+	if buf.Len() != 0 {
+		t.Fatalf("Hash of a cached block hashed the header: %s", buf.String())
+	}
+
+	root := [32]byte{1}
+	for name, change := range map[string]func(){
+		"SetNonce":     func() { b.SetNonce(b.Nonce() + 1) },
+		"SetTimestamp": func() { b.SetTimestamp(b.Timestamp() + 1) },
+		"SetRoots":     func() { b.SetRoots(&root, nil) },
+	} {
+		b.CacheHash(SHA256Hasher{})
+		change()
+		if b.Hash() != b.HashWith(SHA256Hasher{}) {
+			t.Errorf("Hash after %s is the old header's", name)
+		}
+	}
+
+	// CheckHash catches a header changed behind the cache's back, and caches the hash of a block without one.
+	if err := b.CheckHash(SHA256Hasher{}); err != nil {
+		t.Fatalf("CheckHash of an uncached block = %v", err)
+	}
+	if b.hash.hasher != HASHER_SHA256 {
+		t.Fatal("CheckHash did not cache the hash")
+	}
+	b.nonce++
+	if err := b.CheckHash(SHA256Hasher{}); err == nil {
+		t.Fatal("CheckHash of a changed header succeeded")
+	}
+}
@@ -0,0 +1,187 @@
+package block
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dikako/how-blockchain-works/internal/trace"
+)
+
+var ErrInvalidProofOfWork = errors.New("invalid proof of work")
+
+// Consensus decides how blocks are sealed, which sealed blocks are acceptable, and which of two
+// valid chains a node should follow. Blockchain calls it for every mined block, for every
+// non-genesis block of a chain being validated, and when comparing a neighbor's chain with its own.
+type Consensus interface {
+	// Name identifies the engine in logs.
+	Name() string
+	// Seal completes a candidate block at height (for example by finding its nonce) so that Verify
+	// accepts it. It returns ctx.Err() if ctx is cancelled first.
+	Seal(ctx context.Context, height int, b *Block) error
+	// Verify reports why the block at height is not validly sealed, or nil if it is.
+	Verify(height int, b *Block) error
+	// ChooseChain reports whether candidate should replace local. Both chains are already valid.
+	ChooseChain(local, candidate []*Block) bool
+}
+
+// ProofOfWork is the default Consensus: a block is sealed by a nonce that makes the hash of its
+// header (without the timestamp) at most the target of its difficulty, and the longest chain wins.
+type ProofOfWork struct {
+	difficulty float64
+	target     *big.Int
+	hasher     Hasher
+
+	mux     sync.Mutex
+	workers int
+	hashes  atomic.Uint64
+}
+
+// NewProofOfWork constructs a ProofOfWork engine at difficulty (see DifficultyTarget; 0 or less for the
+// hasher's DefaultDifficulty) that hashes with hasher (nil for SHA-256) and searches for nonces on
+// GOMAXPROCS goroutines.
+func NewProofOfWork(difficulty float64, hasher Hasher) *ProofOfWork {
+	if hasher == nil {
+		hasher = SHA256Hasher{}
+	}
+	if difficulty <= 0 {
+		difficulty = DefaultDifficulty(hasher)
+	}
+	return &ProofOfWork{difficulty: difficulty, target: DifficultyTarget(difficulty), hasher: hasher, workers: runtime.GOMAXPROCS(0)}
+}
+
+// Name returns "pow".
+func (p *ProofOfWork) Name() string {
+	return "pow"
+}
+
+// Difficulty returns how hard blocks are to seal; a whole difficulty is the number of leading hex zeros a
+// block hash needs.
+func (p *ProofOfWork) Difficulty() float64 {
+	return p.difficulty
+}
+
+// Target returns the largest block hash the difficulty accepts.
+func (p *ProofOfWork) Target() *big.Int {
+	return new(big.Int).Set(p.target)
+}
+
+// Hasher returns the hash function proofs are computed with.
+func (p *ProofOfWork) Hasher() Hasher {
+	return p.hasher
+}
+
+// SetWorkers sets how many goroutines search for a nonce in parallel; zero or less means GOMAXPROCS.
+func (p *ProofOfWork) SetWorkers(n int) {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.workers = n
+}
+
+// Hashes returns how many nonces Seal has tried so far; sampling it twice gives the hashrate.
+func (p *ProofOfWork) Hashes() uint64 {
+	return p.hashes.Load()
+}
+
+// ValidProof checks if the hash of a block with the given nonce, previousHash, Merkle root, state root, and
+// accounts root meets the difficulty target.
+func (p *ProofOfWork) ValidProof(nonce int, previousHash [32]byte, merkleRoot [32]byte, stateRoot, accountsRoot *[32]byte) bool {
+	guessBlock := Block{
+		nonce:        nonce,
+		previousHash: previousHash,
+		merkleRoot:   merkleRoot,
+		stateRoot:    stateRoot,
+		accountsRoot: accountsRoot,
+	}
+	guessHash := guessBlock.HashWith(p.hasher)
+	if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		slog.Debug("valid_proof", "nonce", nonce, "hash", fmt.Sprintf("%x", guessHash))
+	}
+	return MeetsTarget(guessHash, p.target)
+}
+
+// Seal searches for a nonce satisfying ValidProof and stores it in the block.
+func (p *ProofOfWork) Seal(ctx context.Context, height int, b *Block) error {
+	_, span := trace.StartSpan(ctx, "pow_seal", trace.SPAN_KIND_INTERNAL)
+	defer span.Finish()
+	p.mux.Lock()
+	workers := p.workers
+	p.mux.Unlock()
+	var tried atomic.Uint64
+	nonce, err := searchNonce(ctx, workers, func(nonce int) bool {
+		p.hashes.Add(1)
+		tried.Add(1)
+		return p.ValidProof(nonce, b.previousHash, b.merkleRoot, b.stateRoot, b.accountsRoot)
+	})
+	span.SetAttribute("block.height", height)
+	span.SetAttribute("pow.difficulty", p.difficulty)
+	span.SetAttribute("pow.workers", workers)
+	span.SetAttribute("pow.hashes", tried.Load())
+	if err != nil {
+		span.SetError(err)
+		return err
+	}
+	span.SetAttribute("pow.nonce", nonce)
+	b.nonce = nonce
+	return nil
+}
+
+// Verify re-checks the block's nonce against the difficulty target.
+func (p *ProofOfWork) Verify(height int, b *Block) error {
+	if !p.ValidProof(b.nonce, b.previousHash, b.merkleRoot, b.stateRoot, b.accountsRoot) {
+		return ErrInvalidProofOfWork
+	}
+	return nil
+}
+
+// ChooseChain prefers the longer chain.
+func (p *ProofOfWork) ChooseChain(local, candidate []*Block) bool {
+	return len(candidate) > len(local)
+}
+
+// searchNonce splits the nonce space across workers goroutines, worker i trying i, i+workers, i+2*workers, ...,
+// and returns the first nonce any of them finds valid. It returns ctx.Err() if ctx is cancelled first, and
+// always waits for every worker to stop before returning.
+func searchNonce(ctx context.Context, workers int, valid func(nonce int) bool) (int, error) {
+	workers = max(workers, 1)
+	search, cancel := context.WithCancel(ctx)
+	defer cancel()
+	found := make(chan int, workers)
+	var wg sync.WaitGroup
+	for start := range workers {
+		wg.Go(func() {
+			for nonce := start; search.Err() == nil; nonce += workers {
+				if valid(nonce) {
+					found <- nonce
+					return
+				}
+			}
+		})
+	}
+	defer wg.Wait()
+	select {
+	case nonce := <-found:
+		cancel()
+		return nonce, nil
+	case <-search.Done():
+		return 0, ctx.Err()
+	}
+}
+
+const MINING_DIFFICULTY = 3
+
+// ProofHeader returns the header ValidProof hashes for b, split around its nonce.
+func (p *ProofOfWork) ProofHeader(b *Block) (prefix, suffix []byte) {
+	guess := Block{previousHash: b.previousHash, merkleRoot: b.merkleRoot, stateRoot: b.stateRoot, accountsRoot: b.accountsRoot}
+	before, after, _ := bytes.Cut(guess.header(), []byte(`"nonce":0`))
+	return append(before, `"nonce":`...), after
+}
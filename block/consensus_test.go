@@ -0,0 +1,66 @@
+package block
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSealStopsWhenTheContextIsCancelled(t *testing.T) {
+	pow := NewProofOfWork(64, nil)
+	b := NewBlock(0, [32]byte{}, testTransactions(1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := pow.Seal(ctx, 1, b); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Seal with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := pow.Seal(ctx, 1, b); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Seal past its deadline = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Seal took %v to notice its deadline", elapsed)
+	}
+	if b.Nonce() != 0 {
+		t.Fatalf("cancelled Seal set the nonce to %d", b.Nonce())
+	}
+}
+
+func TestSealSearchesOnEveryWorker(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		pow := NewProofOfWork(2, nil)
+		pow.SetWorkers(workers)
+		b := NewBlock(0, [32]byte{}, testTransactions(3))
+		if err := pow.Seal(context.Background(), 1, b); err != nil {
+			t.Fatalf("Seal on %d workers: %v", workers, err)
+		}
+		if err := pow.Verify(1, b); err != nil {
+			t.Fatalf("Verify after Seal on %d workers = %v", workers, err)
+		}
+		if pow.Hashes() == 0 {
+			t.Fatalf("Hashes after Seal on %d workers = 0", workers)
+		}
+	}
+
+	// No nonce is valid until every worker has tried one, so searchNonce only returns if all three searched.
+	tried := make(map[int]bool)
+	var mux sync.Mutex
+	nonce, err := searchNonce(context.Background(), 3, func(nonce int) bool {
+		mux.Lock()
+		defer mux.Unlock()
+		tried[nonce%3] = true
+		return len(tried) == 3
+	})
+	if err != nil {
+		t.Fatalf("searchNonce: %v", err)
+	}
+	if !tried[nonce%3] {
+		t.Fatalf("searchNonce = %d, a nonce no worker tried", nonce)
+	}
+}
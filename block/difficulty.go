@@ -0,0 +1,50 @@
+package block
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+const (
+	// MAX_DIFFICULTY is the difficulty of a target only the all-zero hash meets: 64 hex digits of zeros.
+	MAX_DIFFICULTY = 64
+	// SCRYPT_DIFFICULTY is the default difficulty under scrypt. A scrypt hash costs about 16^2 SHA-256
+	// block hashes, so two hex digits less than MINING_DIFFICULTY seal blocks about as fast.
+	SCRYPT_DIFFICULTY = MINING_DIFFICULTY - 2
+)
+
+// DefaultDifficulty returns the difficulty proof-of-work with hasher is calibrated to by default, so that
+// blocks take about as long to seal whatever the algorithm.
+func DefaultDifficulty(hasher Hasher) float64 {
+	if hasher != nil && hasher.Name() == HASHER_SCRYPT {
+		return SCRYPT_DIFFICULTY
+	}
+	return MINING_DIFFICULTY
+}
+
+// DifficultyTarget returns the largest hash, read as a 256-bit big-endian number, that meets difficulty:
+// 2^256 / 16^difficulty - 1. A whole difficulty d is the old rule of d leading hex zeros, and every step
+// of 1 makes blocks 16 times harder; fractional difficulties fall in between, so 4.25 is twice as hard as
+// 4. Difficulties are clamped to [0, MAX_DIFFICULTY].
+func DifficultyTarget(difficulty float64) *big.Int {
+	difficulty = min(max(difficulty, 0), MAX_DIFFICULTY)
+	whole, frac := math.Modf(256 - 4*difficulty)
+	f := new(big.Float).SetMantExp(big.NewFloat(math.Exp2(frac)), int(whole))
+	target, _ := f.Int(nil)
+	target.Sub(target, big.NewInt(1))
+	if target.Sign() < 0 {
+		target.SetInt64(0)
+	}
+	return target
+}
+
+// MeetsTarget reports whether hash, read as a big-endian number, is at most target.
+func MeetsTarget(hash [32]byte, target *big.Int) bool {
+	return new(big.Int).SetBytes(hash[:]).Cmp(target) <= 0
+}
+
+// FormatTarget writes target as 64 hex digits, the form hashes are shown in, so the two compare as strings.
+func FormatTarget(target *big.Int) string {
+	return fmt.Sprintf("%064x", target)
+}
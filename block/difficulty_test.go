@@ -0,0 +1,58 @@
+package block
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestWholeDifficultiesAreLeadingHexZeros(t *testing.T) {
+	for _, d := range []int{0, 1, 4, 63, MAX_DIFFICULTY} {
+		want := strings.Repeat("0", d) + strings.Repeat("f", 64-d)
+		if got := FormatTarget(DifficultyTarget(float64(d))); got != want {
+			t.Errorf("DifficultyTarget(%d) = %s, want %s", d, got, want)
+		}
+	}
+	// Out of range difficulties are clamped.
+	if DifficultyTarget(-1).Cmp(DifficultyTarget(0)) != 0 || DifficultyTarget(MAX_DIFFICULTY+1).Sign() != 0 {
+		t.Fatal("DifficultyTarget does not clamp to [0, MAX_DIFFICULTY]")
+	}
+}
+
+func TestFractionalDifficultiesFallBetweenWholeOnes(t *testing.T) {
+	// A quarter step halves the number of hashes that meet the target.
+	four, quarter := DifficultyTarget(4), DifficultyTarget(4.25)
+	half := new(big.Int).Rsh(new(big.Int).Add(four, big.NewInt(1)), 1)
+	if got := new(big.Int).Add(quarter, big.NewInt(1)); got.Cmp(half) != 0 {
+		t.Fatalf("DifficultyTarget(4.25) + 1 = %x, want half of DifficultyTarget(4) + 1, %x", got, half)
+	}
+	if five := DifficultyTarget(5); !(five.Cmp(quarter) < 0 && quarter.Cmp(four) < 0) {
+		t.Fatal("DifficultyTarget(4.25) is not between DifficultyTarget(5) and DifficultyTarget(4)")
+	}
+
+	var hash [32]byte
+	target := DifficultyTarget(1)
+	if !MeetsTarget([32]byte(target.FillBytes(hash[:])), target) {
+		t.Fatal("the target itself does not meet the target")
+	}
+	if MeetsTarget([32]byte(new(big.Int).Add(target, big.NewInt(1)).FillBytes(hash[:])), target) {
+		t.Fatal("the hash after the target meets it")
+	}
+
+	pow := NewProofOfWork(1.5, nil)
+	b := NewBlock(0, [32]byte{}, testTransactions(2))
+	if err := pow.Seal(context.Background(), 1, b); err != nil {
+		t.Fatal(err)
+	}
+	if !MeetsTarget(b.ProofHash(pow.Hasher()), DifficultyTarget(1.5)) || pow.Verify(1, b) != nil {
+		t.Fatal("a block sealed at difficulty 1.5 does not verify")
+	}
+	for pow.Verify(1, b) == nil {
+		b.SetNonce(b.Nonce() + 1)
+	}
+	if err := pow.Verify(1, b); !errors.Is(err, ErrInvalidProofOfWork) {
+		t.Fatalf("Verify of a nonce above the target = %v, want %v", err, ErrInvalidProofOfWork)
+	}
+}
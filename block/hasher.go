@@ -0,0 +1,71 @@
+package block
+
+import (
+	"crypto/sha256"
+	"crypto/sha3"
+	"fmt"
+)
+
+const (
+	HASHER_SHA256  = "sha256"
+	HASHER_SHA3    = "sha3"
+	HASHER_BLAKE2B = "blake2b"
+	HASHER_SCRYPT  = "scrypt"
+)
+
+// Hasher is the 256-bit hash function used for block header hashes and proof-of-work.
+type Hasher interface {
+	// Name identifies the algorithm in configuration and in exported chains.
+	Name() string
+	Sum256(data []byte) [32]byte
+}
+
+// SHA256Hasher hashes with SHA-256, the default.
+type SHA256Hasher struct{}
+
+// Name returns "sha256".
+func (SHA256Hasher) Name() string { return HASHER_SHA256 }
+
+// Sum256 returns the SHA-256 digest of data.
+func (SHA256Hasher) Sum256(data []byte) [32]byte { return sha256.Sum256(data) }
+
+// SHA3Hasher hashes with SHA3-256.
+type SHA3Hasher struct{}
+
+// Name returns "sha3".
+func (SHA3Hasher) Name() string { return HASHER_SHA3 }
+
+// Sum256 returns the SHA3-256 digest of data.
+func (SHA3Hasher) Sum256(data []byte) [32]byte { return sha3.Sum256(data) }
+
+// Blake2bHasher hashes with BLAKE2b-256.
+type Blake2bHasher struct{}
+
+// Name returns "blake2b".
+func (Blake2bHasher) Name() string { return HASHER_BLAKE2B }
+
+// Sum256 returns the BLAKE2b-256 digest of data.
+func (Blake2bHasher) Sum256(data []byte) [32]byte { return Blake2b256(data) }
+
+// HasherByName returns the hasher registered under name; an empty name selects SHA-256.
+func HasherByName(name string) (Hasher, error) {
+	switch name {
+	case "", HASHER_SHA256:
+		return SHA256Hasher{}, nil
+	case HASHER_SHA3:
+		return SHA3Hasher{}, nil
+	case HASHER_BLAKE2B:
+		return Blake2bHasher{}, nil
+	default:
+		return nil, fmt.Errorf("unknown hasher %q", name)
+	}
+}
+
+// ProofOfWorkHasherByName returns the proof-of-work hasher registered under name: any block hasher, or the
+// memory-hard scrypt, which only hashes the proof because it is too slow to hash every block link with.
+func ProofOfWorkHasherByName(name string) (Hasher, error) {
+	if name == HASHER_SCRYPT {
+		return ScryptHasher{}, nil
+	}
+	return HasherByName(name)
+}
@@ -0,0 +1,77 @@
+package block
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestHashersMatchTheirReferenceVectors(t *testing.T) {
+	long := make([]byte, 512)
+	for i := range long {
+		long[i] = byte(i)
+	}
+	tests := []struct {
+		hasher string
+		data   []byte
+		want   string
+	}{
+		{HASHER_SHA256, nil, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{HASHER_SHA256, []byte("abc"), "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{HASHER_SHA3, nil, "a7ffc6f8bf1ed76651c14756a061d662f580ff4de43b49fa82d80a4b80f8434a"},
+		{HASHER_SHA3, []byte("abc"), "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532"},
+		{HASHER_BLAKE2B, nil, "0e5751c026e543b2e8ab2eb06099daa1d1e5df47778f7787faab45cdf12fe3a8"},
+		{HASHER_BLAKE2B, []byte("abc"), "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+		{HASHER_BLAKE2B, long, "540b20132d8aeae54057cb69c24f95d26a1c472cc700dd450defe9bb796d4f14"},
+	}
+	for _, tt := range tests {
+		h, err := HasherByName(tt.hasher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Name() != tt.hasher {
+			t.Fatalf("HasherByName(%q).Name() = %q", tt.hasher, h.Name())
+		}
+		if sum := h.Sum256(tt.data); hex.EncodeToString(sum[:]) != tt.want {
+			t.Errorf("%s(%d bytes) = %x, want %s", tt.hasher, len(tt.data), sum, tt.want)
+		}
+	}
+	if h, err := HasherByName(""); err != nil || h.Name() != HASHER_SHA256 {
+		t.Fatalf(`HasherByName("") = %v, %v, want %s`, h, err, HASHER_SHA256)
+	}
+	if _, err := HasherByName("md5"); err == nil {
+		t.Fatal(`HasherByName("md5") succeeded`)
+	}
+}
+
+// constantHasher hashes everything to the same sum.
+type constantHasher [32]byte
+
+func (constantHasher) Name() string { return "constant" }
+
+func (h constantHasher) Sum256([]byte) [32]byte { return h }
+
+func TestProofOfWorkHashesWithItsHasher(t *testing.T) {
+	b := NewBlock(0, [32]byte{}, testTransactions(2))
+	if err := NewProofOfWork(1, constantHasher{}).Seal(context.Background(), 1, b); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.HashWith(constantHasher{}), (constantHasher{}); got != want {
+		t.Fatalf("HashWith = %x, want the hasher's %x", got, want)
+	}
+
+	var high constantHasher
+	for i := range high {
+		high[i] = 0xff
+	}
+	if err := NewProofOfWork(1, high).Verify(1, b); !errors.Is(err, ErrInvalidProofOfWork) {
+		t.Fatalf("Verify under a hasher missing the target = %v, want %v", err, ErrInvalidProofOfWork)
+	}
+	if b.Hash() != b.HashWith(SHA256Hasher{}) {
+		t.Fatal("Hash does not default to SHA-256")
+	}
+	if b.HashWith(SHA3Hasher{}) == b.HashWith(Blake2bHasher{}) {
+		t.Fatal("SHA3 and BLAKE2b hash the header alike")
+	}
+}
@@ -0,0 +1,115 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// MerkleProofStep is one sibling on the path from a leaf to the Merkle root.
+// Left reports whether the sibling sits to the left of the running hash.
+type MerkleProofStep struct {
+	Hash [32]byte
+	Left bool
+}
+
+// MarshalJSON provides a custom JSON representation for MerkleProofStep fields.
+func (s MerkleProofStep) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Hash string `json:"hash"`
+		Left bool   `json:"left"`
+	}{
+		Hash: fmt.Sprintf("%x", s.Hash),
+		Left: s.Left,
+	})
+}
+
+// MerkleRoot computes the root of a binary Merkle tree over the transaction hashes.
+// When a level has an odd number of nodes the last one is paired with itself, as in Bitcoin.
+// An empty transaction list has the zero root.
+func MerkleRoot(transactions []*transaction.Transaction) [32]byte {
+	levels := merkleLevels(transactions)
+	if len(levels) == 0 {
+		return [32]byte{}
+	}
+	return levels[len(levels)-1][0]
+}
+
+// MerkleRoot returns the Merkle root stored in the block header.
+func (b *Block) MerkleRoot() [32]byte {
+	return b.merkleRoot
+}
+
+// MerkleProof returns the sibling hashes proving that the transaction with the given hash is in the block.
+// The second result is false if the block does not contain the transaction.
+func (b *Block) MerkleProof(txHash [32]byte) ([]MerkleProofStep, bool) {
+	levels := merkleLevels(b.transactions)
+	if len(levels) == 0 {
+		return nil, false
+	}
+	index := -1
+	for i, h := range levels[0] {
+		if h == txHash {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, false
+	}
+	proof := make([]MerkleProofStep, 0, len(levels)-1)
+	for _, level := range levels[:len(levels)-1] {
+		if index%2 == 0 {
+			proof = append(proof, MerkleProofStep{Hash: level[index+1], Left: false})
+		} else {
+			proof = append(proof, MerkleProofStep{Hash: level[index-1], Left: true})
+		}
+		index /= 2
+	}
+	return proof, true
+}
+
+// VerifyMerkleProof recomputes the root from a leaf hash and its proof and compares it with root.
+func VerifyMerkleProof(txHash [32]byte, proof []MerkleProofStep, root [32]byte) bool {
+	h := txHash
+	for _, step := range proof {
+		if step.Left {
+			h = merkleParent(step.Hash, h)
+		} else {
+			h = merkleParent(h, step.Hash)
+		}
+	}
+	return h == root
+}
+
+// merkleLevels builds every level of the tree, leaves first and root last. Odd levels are padded
+// by duplicating their last node, so each level except the root has an even length.
+func merkleLevels(transactions []*transaction.Transaction) [][][32]byte {
+	if len(transactions) == 0 {
+		return nil
+	}
+	level := make([][32]byte, len(transactions))
+	for i, t := range transactions {
+		level[i] = t.Hash()
+	}
+	levels := make([][][32]byte, 0)
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		levels = append(levels, level)
+		next := make([][32]byte, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			next = append(next, merkleParent(level[i], level[i+1]))
+		}
+		level = next
+	}
+	return append(levels, level)
+}
+
+// merkleParent hashes the concatenation of two child nodes.
+func merkleParent(left, right [32]byte) [32]byte {
+	return sha256.Sum256(append(left[:], right[:]...))
+}
@@ -0,0 +1,67 @@
+package block
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// testTransactions returns n distinct unsigned transfers.
+func testTransactions(n int) []*transaction.Transaction {
+	transactions := make([]*transaction.Transaction, n)
+	for i := range transactions {
+		transactions[i] = transaction.NewTransaction("sender", "recipient", transaction.Amount(i+1), 0)
+	}
+	return transactions
+}
+
+func TestMerkleRootPairsTheLastNodeOfOddLevelsWithItself(t *testing.T) {
+	if got := MerkleRoot(nil); got != [32]byte{} {
+		t.Fatalf("MerkleRoot of no transactions = %x, want zero", got)
+	}
+	transactions := testTransactions(3)
+	if got := MerkleRoot(transactions[:1]); got != transactions[0].Hash() {
+		t.Fatalf("MerkleRoot of one transaction = %x, want its hash", got)
+	}
+	parent := func(l, r [32]byte) [32]byte { return sha256.Sum256(append(l[:], r[:]...)) }
+	a, b, c := transactions[0].Hash(), transactions[1].Hash(), transactions[2].Hash()
+	if got, want := MerkleRoot(transactions), parent(parent(a, b), parent(c, c)); got != want {
+		t.Fatalf("MerkleRoot of three transactions = %x, want %x", got, want)
+	}
+
+	block := NewBlock(0, [32]byte{}, transactions)
+	if block.MerkleRoot() != MerkleRoot(transactions) {
+		t.Fatal("NewBlock did not commit to its transactions")
+	}
+	reordered := NewBlock(0, [32]byte{}, []*transaction.Transaction{transactions[1], transactions[0], transactions[2]})
+	reordered.SetTimestamp(block.Timestamp())
+	if reordered.MerkleRoot() == block.MerkleRoot() || reordered.Hash() == block.Hash() {
+		t.Fatal("reordering the transactions kept the root or the block hash")
+	}
+}
+
+func TestMerkleProofsVerifyAgainstTheRoot(t *testing.T) {
+	for n := 1; n <= 7; n++ {
+		transactions := testTransactions(n)
+		block := NewBlock(0, [32]byte{}, transactions)
+		for i, tx := range transactions {
+			proof, ok := block.MerkleProof(tx.Hash())
+			if !ok {
+				t.Fatalf("%d transactions: no proof for transaction %d", n, i)
+			}
+			if !VerifyMerkleProof(tx.Hash(), proof, block.MerkleRoot()) {
+				t.Fatalf("%d transactions: the proof of transaction %d does not verify", n, i)
+			}
+			if VerifyMerkleProof(tx.Hash(), proof, [32]byte{1}) {
+				t.Fatalf("%d transactions: the proof of transaction %d verifies against another root", n, i)
+			}
+			if other := transactions[(i+1)%n].Hash(); n > 1 && VerifyMerkleProof(other, proof, block.MerkleRoot()) {
+				t.Fatalf("%d transactions: the proof of transaction %d verifies another transaction", n, i)
+			}
+		}
+		if _, ok := block.MerkleProof(testTransactions(n + 1)[n].Hash()); ok {
+			t.Fatalf("%d transactions: a proof for a transaction not in the block", n)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package block
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+var (
+	ErrNotAuthority          = errors.New("node has no authority key")
+	ErrNotInTurn             = errors.New("not this authority's turn")
+	ErrUnsignedBlock         = errors.New("block is not signed")
+	ErrOutOfTurnBlock        = errors.New("block signed by an authority out of turn")
+	ErrInvalidBlockSignature = errors.New("invalid block signature")
+)
+
+// ProofOfAuthority is a Consensus where a fixed list of authority addresses take turns producing
+// blocks: the block at height h must be signed by authorities[(h-1) % len(authorities)]. There is
+// no nonce search, so blocks are sealed instantly and deterministically.
+type ProofOfAuthority struct {
+	authorities []string
+	signer      *ecdsa.PrivateKey
+	hasher      Hasher
+}
+
+// NewProofOfAuthority constructs a ProofOfAuthority engine for the given turn order. signer is this
+// node's authority key, or nil for a node that only validates. hasher (nil for SHA-256) hashes the
+// header that gets signed and must match the chain's hasher.
+func NewProofOfAuthority(authorities []string, signer *ecdsa.PrivateKey, hasher Hasher) (*ProofOfAuthority, error) {
+	if len(authorities) == 0 {
+		return nil, errors.New("proof of authority needs at least one authority")
+	}
+	for _, a := range authorities {
+		if !transaction.ValidateAddress(a) {
+			return nil, errors.New("invalid authority address " + a)
+		}
+	}
+	if hasher == nil {
+		hasher = SHA256Hasher{}
+	}
+	return &ProofOfAuthority{authorities: append([]string(nil), authorities...), signer: signer, hasher: hasher}, nil
+}
+
+// Name returns "poa".
+func (p *ProofOfAuthority) Name() string {
+	return "poa"
+}
+
+// Authorities returns the authority addresses in turn order.
+func (p *ProofOfAuthority) Authorities() []string {
+	return append([]string(nil), p.authorities...)
+}
+
+// InTurn returns the authority address expected to sign the block at height.
+func (p *ProofOfAuthority) InTurn(height int) string {
+	return p.authorities[(height-1)%len(p.authorities)]
+}
+
+// Seal signs the block header if this node holds the key of the authority in turn at height.
+func (p *ProofOfAuthority) Seal(ctx context.Context, height int, b *Block) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if p.signer == nil {
+		return ErrNotAuthority
+	}
+	if transaction.NewAddress(&p.signer.PublicKey) != p.InTurn(height) {
+		return ErrNotInTurn
+	}
+	h := b.HashWith(p.hasher)
+	signature, err := ecdsa.SignASN1(rand.Reader, p.signer, h[:])
+	if err != nil {
+		return err
+	}
+	b.signerPublicKey = &p.signer.PublicKey
+	b.signature = signature
+	return nil
+}
+
+// Verify checks that the block is signed by the authority in turn at height.
+func (p *ProofOfAuthority) Verify(height int, b *Block) error {
+	if b.signerPublicKey == nil || len(b.signature) == 0 {
+		return ErrUnsignedBlock
+	}
+	if transaction.NewAddress(b.signerPublicKey) != p.InTurn(height) {
+		return ErrOutOfTurnBlock
+	}
+	h := b.HashWith(p.hasher)
+	if !ecdsa.VerifyASN1(b.signerPublicKey, h[:], b.signature) {
+		return ErrInvalidBlockSignature
+	}
+	return nil
+}
+
+// ChooseChain prefers the longer chain; with a fixed turn order every valid chain of the same
+// length was produced by the same authorities.
+func (p *ProofOfAuthority) ChooseChain(local, candidate []*Block) bool {
+	return len(candidate) > len(local)
+}
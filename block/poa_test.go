@@ -0,0 +1,74 @@
+package block
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// newAuthority returns a fresh P-256 key and the address derived from it.
+func newAuthority(t *testing.T) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, transaction.NewAddress(&key.PublicKey)
+}
+
+func TestAuthoritiesSealOnlyInTurn(t *testing.T) {
+	keyA, a := newAuthority(t)
+	keyB, b := newAuthority(t)
+	authorities := []string{a, b}
+	sealerA, err := NewProofOfAuthority(authorities, keyA, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealerB, err := NewProofOfAuthority(authorities, keyB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator, err := NewProofOfAuthority(authorities, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validator.InTurn(1) != a || validator.InTurn(2) != b || validator.InTurn(3) != a {
+		t.Fatalf("turns %s, %s, %s, want %s, %s, %s", validator.InTurn(1), validator.InTurn(2), validator.InTurn(3), a, b, a)
+	}
+
+	blk := NewBlock(0, [32]byte{}, testTransactions(1))
+	if err := validator.Verify(1, blk); !errors.Is(err, ErrUnsignedBlock) {
+		t.Fatalf("Verify unsigned = %v, want %v", err, ErrUnsignedBlock)
+	}
+	if err := validator.Seal(context.Background(), 1, blk); !errors.Is(err, ErrNotAuthority) {
+		t.Fatalf("Seal without a key = %v, want %v", err, ErrNotAuthority)
+	}
+	if err := sealerB.Seal(context.Background(), 1, blk); !errors.Is(err, ErrNotInTurn) {
+		t.Fatalf("Seal out of turn = %v, want %v", err, ErrNotInTurn)
+	}
+	if err := sealerA.Seal(context.Background(), 1, blk); err != nil {
+		t.Fatal(err)
+	}
+	if err := validator.Verify(1, blk); err != nil {
+		t.Fatalf("Verify in turn = %v", err)
+	}
+	if err := validator.Verify(2, blk); !errors.Is(err, ErrOutOfTurnBlock) {
+		t.Fatalf("Verify at B's height = %v, want %v", err, ErrOutOfTurnBlock)
+	}
+	blk.SetNonce(1)
+	if err := validator.Verify(1, blk); !errors.Is(err, ErrInvalidBlockSignature) {
+		t.Fatalf("Verify after changing the header = %v, want %v", err, ErrInvalidBlockSignature)
+	}
+
+	if _, err := NewProofOfAuthority(nil, nil, nil); err == nil {
+		t.Fatal("NewProofOfAuthority accepted no authorities")
+	}
+	if _, err := NewProofOfAuthority([]string{"not an address"}, nil, nil); err == nil {
+		t.Fatal("NewProofOfAuthority accepted an invalid address")
+	}
+}
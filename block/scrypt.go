@@ -0,0 +1,128 @@
+package block
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+)
+
+// The standard library does not ship scrypt, so this file carries a small implementation of it
+// (RFC 7914) for the memory-hard proof-of-work choice.
+
+const (
+	// SCRYPT_N, SCRYPT_R, and SCRYPT_P are the scrypt costs of the proof-of-work, Litecoin's: every hash
+	// fills and reads back 128 * SCRYPT_R * SCRYPT_N bytes (128 KiB) of memory.
+	SCRYPT_N = 1024
+	SCRYPT_R = 1
+	SCRYPT_P = 1
+)
+
+// ScryptHasher hashes with scrypt, using the data as both password and salt as Litecoin does. Each hash
+// needs its own scratch memory, so hardware that computes many in parallel needs memory for each, which
+// is what made scrypt resist the SHA-256 ASICs of its time.
+type ScryptHasher struct{}
+
+// Name returns "scrypt".
+func (ScryptHasher) Name() string { return HASHER_SCRYPT }
+
+// Sum256 returns the 32-byte scrypt digest of data.
+func (ScryptHasher) Sum256(data []byte) [32]byte {
+	var sum [32]byte
+	copy(sum[:], Scrypt(data, data, SCRYPT_N, SCRYPT_R, SCRYPT_P, len(sum)))
+	return sum
+}
+
+// Scrypt derives keyLen bytes from password and salt with CPU/memory cost n (a power of two greater than 1),
+// block size r, and parallelism p.
+func Scrypt(password, salt []byte, n, r, p, keyLen int) []byte {
+	b, _ := pbkdf2.Key(sha256.New, string(password), salt, 1, p*128*r)
+	x := make([]uint32, 32*r)
+	y := make([]uint32, 32*r)
+	v := make([]uint32, 32*r*n)
+	for i := range p {
+		scryptROMix(b[i*128*r:(i+1)*128*r], x, y, v, n, r)
+	}
+	key, _ := pbkdf2.Key(sha256.New, string(password), b, 1, keyLen)
+	return key
+}
+
+// scryptROMix mixes the 128*r bytes of block in place through the n*32*r words of v, using x and y as scratch.
+func scryptROMix(block []byte, x, y, v []uint32, n, r int) {
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(block[4*i:])
+	}
+	words := 32 * r
+	for i := range n {
+		copy(v[i*words:], x)
+		scryptBlockMix(x, y, r)
+	}
+	for range n {
+		j := int(x[words-16]) & (n - 1)
+		for k, w := range v[j*words : (j+1)*words] {
+			x[k] ^= w
+		}
+		scryptBlockMix(x, y, r)
+	}
+	for i, w := range x {
+		binary.LittleEndian.PutUint32(block[4*i:], w)
+	}
+}
+
+// scryptBlockMix runs Salsa20/8 over the 2*r 64-byte blocks of b, reordering the outputs even blocks first,
+// with y, as long as b, as scratch.
+func scryptBlockMix(b, y []uint32, r int) {
+	var t [16]uint32
+	copy(t[:], b[(2*r-1)*16:])
+	for i := range 2 * r {
+		for k := range t {
+			t[k] ^= b[i*16+k]
+		}
+		salsa208(&t)
+		copy(y[(i/2+(i%2)*r)*16:], t[:])
+	}
+	copy(b, y)
+}
+
+// salsa208 applies the Salsa20/8 core to the 16 words of b.
+func salsa208(b *[16]uint32) {
+	x := *b
+	for range 4 {
+		x[4] ^= bits.RotateLeft32(x[0]+x[12], 7)
+		x[8] ^= bits.RotateLeft32(x[4]+x[0], 9)
+		x[12] ^= bits.RotateLeft32(x[8]+x[4], 13)
+		x[0] ^= bits.RotateLeft32(x[12]+x[8], 18)
+		x[9] ^= bits.RotateLeft32(x[5]+x[1], 7)
+		x[13] ^= bits.RotateLeft32(x[9]+x[5], 9)
+		x[1] ^= bits.RotateLeft32(x[13]+x[9], 13)
+		x[5] ^= bits.RotateLeft32(x[1]+x[13], 18)
+		x[14] ^= bits.RotateLeft32(x[10]+x[6], 7)
+		x[2] ^= bits.RotateLeft32(x[14]+x[10], 9)
+		x[6] ^= bits.RotateLeft32(x[2]+x[14], 13)
+		x[10] ^= bits.RotateLeft32(x[6]+x[2], 18)
+		x[3] ^= bits.RotateLeft32(x[15]+x[11], 7)
+		x[7] ^= bits.RotateLeft32(x[3]+x[15], 9)
+		x[11] ^= bits.RotateLeft32(x[7]+x[3], 13)
+		x[15] ^= bits.RotateLeft32(x[11]+x[7], 18)
+
+		x[1] ^= bits.RotateLeft32(x[0]+x[3], 7)
+		x[2] ^= bits.RotateLeft32(x[1]+x[0], 9)
+		x[3] ^= bits.RotateLeft32(x[2]+x[1], 13)
+		x[0] ^= bits.RotateLeft32(x[3]+x[2], 18)
+		x[6] ^= bits.RotateLeft32(x[5]+x[4], 7)
+		x[7] ^= bits.RotateLeft32(x[6]+x[5], 9)
+		x[4] ^= bits.RotateLeft32(x[7]+x[6], 13)
+		x[5] ^= bits.RotateLeft32(x[4]+x[7], 18)
+		x[11] ^= bits.RotateLeft32(x[10]+x[9], 7)
+		x[8] ^= bits.RotateLeft32(x[11]+x[10], 9)
+		x[9] ^= bits.RotateLeft32(x[8]+x[11], 13)
+		x[10] ^= bits.RotateLeft32(x[9]+x[8], 18)
+		x[12] ^= bits.RotateLeft32(x[15]+x[14], 7)
+		x[13] ^= bits.RotateLeft32(x[12]+x[15], 9)
+		x[14] ^= bits.RotateLeft32(x[13]+x[12], 13)
+		x[15] ^= bits.RotateLeft32(x[14]+x[13], 18)
+	}
+	for i := range b {
+		b[i] += x[i]
+	}
+}
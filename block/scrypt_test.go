@@ -0,0 +1,50 @@
+package block
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+)
+
+func TestScryptMatchesRFC7914(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		n, r, p        int
+		want           string
+	}{
+		{"", "", 16, 1, 1, "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 1024, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(Scrypt([]byte(tt.password), []byte(tt.salt), tt.n, tt.r, tt.p, 64)); got != tt.want {
+			t.Errorf("Scrypt(%q, %q, %d, %d, %d) = %s, want %s", tt.password, tt.salt, tt.n, tt.r, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestScryptIsAProofOfWorkHasherOnly(t *testing.T) {
+	h, err := ProofOfWorkHasherByName(HASHER_SCRYPT)
+	if err != nil || h.Name() != HASHER_SCRYPT {
+		t.Fatalf("ProofOfWorkHasherByName(%q) = %v, %v", HASHER_SCRYPT, h, err)
+	}
+	// Too slow to hash every block link with, scrypt cannot be a chain's block hasher.
+	if _, err := HasherByName(HASHER_SCRYPT); err == nil {
+		t.Fatalf("HasherByName(%q) succeeded", HASHER_SCRYPT)
+	}
+	data := []byte("header")
+	if sum := h.Sum256(data); hex.EncodeToString(sum[:]) != hex.EncodeToString(Scrypt(data, data, SCRYPT_N, SCRYPT_R, SCRYPT_P, 32)) {
+		t.Fatal("ScryptHasher does not hash with the data as both password and salt")
+	}
+	if DefaultDifficulty(h) != SCRYPT_DIFFICULTY || DefaultDifficulty(SHA256Hasher{}) != MINING_DIFFICULTY {
+		t.Fatal("DefaultDifficulty does not calibrate scrypt apart from the fast hashers")
+	}
+
+	pow := NewProofOfWork(1, h)
+	b := NewBlock(0, [32]byte{}, testTransactions(2))
+	if err := pow.Seal(context.Background(), 1, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := pow.Verify(1, b); err != nil {
+		t.Fatalf("Verify of a block sealed with scrypt = %v", err)
+	}
+}
@@ -0,0 +1,1256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/internal/httputil"
+	"github.com/dikako/how-blockchain-works/node"
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+const CLI_USAGE = `usage: blockchain <command> [flags]
+
+commands:
+  node start        run a blockchain node (same flags as -mode node)
+  wallet serve      run the wallet web server (same flags as -mode wallet)
+  wallet new        generate a wallet and print its keys and address
+  wallet send       sign a transaction and submit it to a node
+  wallet anchor     anchor data or a file's hash in the chain with a data-carrier transaction
+  multisig address  print the address of an m-of-n multisig condition
+  multisig sign     create or cosign a transaction from a multisig address
+  multisig submit   submit a multisig transaction once enough keys signed
+  escrow address    print the 2-of-3 address of a buyer, a seller, and an arbiter
+  escrow fund       fund an escrow as the buyer
+  escrow release    create or cosign the release of an escrow
+  escrow submit     submit an escrow release once two parties signed
+  script address    print the address of coins locked by a script
+  script spend      spend from a script address with an unlocking script
+  htlc secret       print a random preimage and its hashlock
+  htlc lock         send coins to an HTLC address for a hashlock and a timeout
+  htlc claim        claim an HTLC by revealing its preimage
+  htlc refund       take an HTLC's coins back after its timeout
+  contract deploy   deploy a contract written in the VM's assembly
+  contract call     call a deployed contract with input words
+  contract show     print a contract's code, balance, and storage
+  contract receipt  print the outcome of a confirmed contract transaction
+  token create      register a token with a symbol and a supply held by the issuer
+  token transfer    send units of a token to another address
+  token show        print a token's issuer, supply, and holders
+  asset mint        mint a unique asset with an ID and a metadata hash
+  asset transfer    hand an asset to another address
+  asset show        print an asset's creator, owner, and metadata hash
+  asset list        print the assets an address owns
+  mempool list      print a node's pending transactions with their fees and ages
+  mempool show      print one pending transaction
+  mempool evict     drop a pending transaction, with its sender's later nonces
+  mempool clear     drop every pending transaction
+  stratum worker    mine shares for a node's stratum server
+  token new         issue a JWT for nodes started with -jwt_secret
+  chain print       print a node's chain, or an exported chain file
+  chain validate    validate a node's chain, or an exported chain file
+  repl              interactive shell over an in-memory chain with named wallets
+  dashboard         live terminal view of one or more running nodes
+
+Run "blockchain <command> -h" for a command's flags.`
+
+// runCommand dispatches a subcommand given as its words followed by its flags, e.g. ["wallet", "send", "-to", ...].
+func runCommand(args []string) {
+	switch args[0] {
+	case "repl":
+		replCommand(args[1:])
+		return
+	case "dashboard":
+		dashboardCommand(args[1:])
+		return
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, CLI_USAGE)
+		os.Exit(2)
+	}
+	command, flags := args[0]+" "+args[1], args[2:]
+	switch command {
+	case "node start":
+		runServer(append([]string{"-mode", "node"}, flags...))
+	case "wallet serve":
+		runServer(append([]string{"-mode", "wallet"}, flags...))
+	case "wallet new":
+		walletNewCommand(flags)
+	case "wallet send":
+		walletSendCommand(flags)
+	case "wallet anchor":
+		walletAnchorCommand(flags)
+	case "multisig address":
+		multisigAddressCommand(flags)
+	case "multisig sign":
+		multisigSignCommand(flags)
+	case "multisig submit":
+		multisigSubmitCommand(flags)
+	case "escrow address":
+		escrowAddressCommand(flags)
+	case "escrow fund":
+		escrowFundCommand(flags)
+	case "escrow release":
+		escrowReleaseCommand(flags)
+	case "escrow submit":
+		escrowSubmitCommand(flags)
+	case "script address":
+		scriptAddressCommand(flags)
+	case "script spend":
+		scriptSpendCommand(flags)
+	case "htlc secret":
+		htlcSecretCommand(flags)
+	case "htlc lock":
+		htlcLockCommand(flags)
+	case "htlc claim":
+		htlcClaimCommand(flags)
+	case "htlc refund":
+		htlcRefundCommand(flags)
+	case "contract deploy":
+		contractDeployCommand(flags)
+	case "contract call":
+		contractCallCommand(flags)
+	case "contract show":
+		contractShowCommand(flags)
+	case "contract receipt":
+		contractReceiptCommand(flags)
+	case "token create":
+		tokenCreateCommand(flags)
+	case "token transfer":
+		tokenTransferCommand(flags)
+	case "token show":
+		tokenShowCommand(flags)
+	case "asset mint":
+		assetMintCommand(flags)
+	case "asset transfer":
+		assetTransferCommand(flags)
+	case "asset show":
+		assetShowCommand(flags)
+	case "asset list":
+		assetListCommand(flags)
+	case "mempool list":
+		mempoolCommand("mempool list", http.MethodGet, false, flags)
+	case "mempool show":
+		mempoolCommand("mempool show", http.MethodGet, true, flags)
+	case "mempool evict":
+		mempoolCommand("mempool evict", http.MethodDelete, true, flags)
+	case "mempool clear":
+		mempoolCommand("mempool clear", http.MethodDelete, false, flags)
+	case "stratum worker":
+		stratumWorkerCommand(flags)
+	case "token new":
+		tokenNewCommand(flags)
+	case "chain print":
+		chainPrintCommand(flags)
+	case "chain validate":
+		chainValidateCommand(flags)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", command, CLI_USAGE)
+		os.Exit(2)
+	}
+}
+
+// walletNewCommand prints the keys and address of a freshly generated wallet.
+func walletNewCommand(args []string) {
+	fs := flag.NewFlagSet("wallet new", flag.ExitOnError)
+	fs.Parse(args)
+	w, err := wallet.NewWallet()
+	if err != nil {
+		log.Fatalf("action=new_wallet, status=fail, err=%v", err)
+	}
+	fmt.Printf("private_key        %s\n", w.PrivateKeyStr())
+	fmt.Printf("public_key         %s\n", w.PublicKeyStr())
+	fmt.Printf("blockchain_address %s\n", w.BlockchainAddress())
+}
+
+// walletSendCommand signs a transaction with -private_key and submits it to the node's POST /transactions.
+func walletSendCommand(args []string) {
+	fs := flag.NewFlagSet("wallet send", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	privateKeyStr := fs.String("private_key", "", "hex private key of the sending wallet")
+	recipient := fs.String("to", "", "recipient blockchain address")
+	valueStr := fs.String("amount", "", "coins to send, e.g. 1.5")
+	feeStr := fs.String("fee", "0", "fee in coins paid to the miner, or low, medium, or high for the node's GET /fees suggestion")
+	memo := fs.String("memo", "", "optional message for the recipient, such as an invoice reference")
+	lockTime := fs.Uint64("lock_time", 0, "block height, or Unix time from 500000000 on, before which the transaction cannot be confirmed")
+	token := fs.String("token", "", "API key or JWT for a node that requires authentication")
+	fs.Parse(args)
+
+	privateKey, err := transaction.PrivateKeyFromString(*privateKeyStr)
+	if err != nil {
+		log.Fatalf("action=wallet_send, status=fail, err=invalid -private_key: %v", err)
+	}
+	if !transaction.ValidateAddress(*recipient) {
+		log.Fatalf("action=wallet_send, status=fail, err=invalid -to address %q", *recipient)
+	}
+	value, err := transaction.ParseAmount(*valueStr)
+	if err != nil {
+		log.Fatalf("action=wallet_send, status=fail, err=invalid -amount: %v", err)
+	}
+	fee, err := feeFlag(*gateway, *feeStr)
+	if err != nil {
+		log.Fatalf("action=wallet_send, status=fail, err=invalid -fee: %v", err)
+	}
+	w := wallet.WalletFromPrivateKey(privateKey)
+	t := transaction.NewTransaction(w.BlockchainAddress(), *recipient, value, fee)
+	t.SetMemo(*memo)
+	t.SetLockTime(*lockTime)
+	setNodeNonce("wallet_send", *gateway, t)
+	if err := w.SignTransaction(t); err != nil {
+		log.Fatalf("action=wallet_send, status=fail, err=%v", err)
+	}
+	submitTransaction("wallet_send", *gateway, *token, t)
+}
+
+// walletAnchorCommand signs a data-carrier transaction with -private_key that anchors -hex, or the SHA-256
+// of -file, and submits it to the node's POST /transactions.
+func walletAnchorCommand(args []string) {
+	fs := flag.NewFlagSet("wallet anchor", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	privateKeyStr := fs.String("private_key", "", "hex private key of the paying wallet")
+	hexData := fs.String("hex", "", "hex data to anchor")
+	file := fs.String("file", "", "file whose SHA-256 to anchor, to timestamp a document")
+	feeStr := fs.String("fee", "", "fee in coins paid to the miner (default: the least for the data's size)")
+	token := fs.String("token", "", "API key or JWT for a node that requires authentication")
+	fs.Parse(args)
+
+	privateKey, err := transaction.PrivateKeyFromString(*privateKeyStr)
+	if err != nil {
+		log.Fatalf("action=wallet_anchor, status=fail, err=invalid -private_key: %v", err)
+	}
+	var data []byte
+	switch {
+	case *hexData != "" && *file != "":
+		log.Fatal("action=wallet_anchor, status=fail, err=give -hex or -file, not both")
+	case *file != "":
+		content, err := os.ReadFile(*file)
+		if err != nil {
+			log.Fatalf("action=wallet_anchor, status=fail, err=%v", err)
+		}
+		sum := sha256.Sum256(content)
+		data = sum[:]
+	default:
+		if data, err = hex.DecodeString(*hexData); err != nil || len(data) == 0 {
+			log.Fatalf("action=wallet_anchor, status=fail, err=invalid -hex %q", *hexData)
+		}
+	}
+	fee := transaction.DataCarrierFee(len(data))
+	if *feeStr != "" {
+		if fee, err = transaction.ParseAmount(*feeStr); err != nil {
+			log.Fatalf("action=wallet_anchor, status=fail, err=invalid -fee: %v", err)
+		}
+	}
+	w := wallet.WalletFromPrivateKey(privateKey)
+	nonce, chainID := nodeNonce("wallet_anchor", *gateway, w.BlockchainAddress())
+	t, err := w.NewDataCarrier(data, fee, nonce, chainID)
+	if err != nil {
+		log.Fatalf("action=wallet_anchor, status=fail, err=%v", err)
+	}
+	fmt.Printf("data %x\n", data)
+	submitTransaction("wallet_anchor", *gateway, *token, t)
+}
+
+// feeFlag parses a -fee in coins, or asks the node's GET /fees for its "low", "medium", or "high" suggestion.
+func feeFlag(gateway, fee string) (transaction.Amount, error) {
+	if fee != "low" && fee != "medium" && fee != "high" {
+		return transaction.ParseAmount(fee)
+	}
+	var e node.FeeEstimate
+	if err := getJSON(http.DefaultClient, strings.TrimSuffix(gateway, "/")+"/fees", &e); err != nil {
+		return 0, err
+	}
+	return map[string]transaction.Amount{"low": e.Low, "medium": e.Medium, "high": e.High}[fee], nil
+}
+
+// submitTransaction posts signed transaction t to the node's POST /transactions and prints its answer,
+// exiting with a log line keyed by action if the node refuses it.
+func submitTransaction(action, gateway, token string, t *transaction.Transaction) {
+	m, _ := t.MarshalJSON()
+	req, _ := http.NewRequest(http.MethodPost, strings.TrimSuffix(gateway, "/")+"/transactions", bytes.NewReader(m))
+	req.Header.Set("Content-Type", "application/json")
+	httputil.SetBearerToken(req, token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("action=%s, status=fail, err=%v", action, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		log.Fatalf("action=%s, status=fail, http_status=%d, body=%s", action, resp.StatusCode, bytes.TrimSpace(body))
+	}
+	fmt.Println(string(bytes.TrimSpace(body)))
+}
+
+// nodeNonce asks the node's GET /nonce for the nonce of the address's next transaction and the chain ID to
+// sign it for, exiting with a log line keyed by action if it cannot.
+func nodeNonce(action, gateway, address string) (uint64, string) {
+	resp, err := http.Get(strings.TrimSuffix(gateway, "/") + "/nonce?blockchain_address=" + url.QueryEscape(address))
+	if err != nil {
+		log.Fatalf("action=%s, status=fail, err=%v", action, err)
+	}
+	defer resp.Body.Close()
+	var v struct {
+		Nonce   uint64 `json:"nonce"`
+		ChainID string `json:"chain_id"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&v) != nil {
+		log.Fatalf("action=%s, status=fail, http_status=%d, err=no nonce from %s", action, resp.StatusCode, gateway)
+	}
+	return v.Nonce, v.ChainID
+}
+
+// setNodeNonce gives t the nonce and chain ID nodeNonce returns for its sender.
+func setNodeNonce(action, gateway string, t *transaction.Transaction) {
+	nonce, chainID := nodeNonce(action, gateway, t.SenderBlockchainAddress())
+	t.SetNonce(nonce)
+	t.SetChainID(chainID)
+}
+
+// setFlagNonce gives t -nonce and -chain_id if the nonce was given, so cosigners can build a transaction
+// offline, and otherwise asks the node with setNodeNonce.
+func setFlagNonce(action string, nonce int64, chainID string, gateway string, t *transaction.Transaction) {
+	if nonce < 0 {
+		setNodeNonce(action, gateway, t)
+		return
+	}
+	t.SetNonce(uint64(nonce))
+	t.SetChainID(chainID)
+}
+
+// multisigFlags parses -required and the comma-separated hex -public_keys into a condition.
+func multisigFlags(action string, required int, publicKeys string) *transaction.Multisig {
+	keys, err := transaction.ParsePublicKeys(strings.Split(publicKeys, ","))
+	if err != nil {
+		log.Fatalf("action=%s, status=fail, err=%v", action, err)
+	}
+	m, err := transaction.NewMultisig(required, keys)
+	if err != nil {
+		log.Fatalf("action=%s, status=fail, err=%v", action, err)
+	}
+	return m
+}
+
+// multisigAddressCommand prints the address of the condition that -required of -public_keys sign.
+func multisigAddressCommand(args []string) {
+	fs := flag.NewFlagSet("multisig address", flag.ExitOnError)
+	required := fs.Int("required", 2, "signatures needed to spend")
+	publicKeys := fs.String("public_keys", "", "comma-separated hex public keys of the cosigners, in order")
+	fs.Parse(args)
+	fmt.Println(multisigFlags("multisig_address", *required, *publicKeys).Address())
+}
+
+// multisigSignCommand adds the signature of -private_key to the multisig transaction in -in, or to a new one
+// built from the condition and transfer flags, and writes it to -out for the next cosigner or for submit.
+func multisigSignCommand(args []string) {
+	fs := flag.NewFlagSet("multisig sign", flag.ExitOnError)
+	privateKeyStr := fs.String("private_key", "", "hex private key of a cosigner")
+	in := fs.String("in", "", "partially signed transaction file to cosign (default: create a new transaction)")
+	out := fs.String("out", "-", "file to write the signed transaction to, - for stdout")
+	required := fs.Int("required", 2, "signatures needed to spend, for a new transaction")
+	publicKeys := fs.String("public_keys", "", "comma-separated hex public keys of the cosigners, for a new transaction")
+	recipient := fs.String("to", "", "recipient blockchain address, for a new transaction")
+	valueStr := fs.String("amount", "", "coins to send, for a new transaction")
+	feeStr := fs.String("fee", "0", "fee in coins paid to the miner, for a new transaction")
+	memo := fs.String("memo", "", "optional message for the recipient, for a new transaction")
+	nonce := fs.Int64("nonce", -1, "nonce of the multisig address's next transaction, for a new transaction (default: ask -gateway)")
+	chainID := fs.String("chain_id", "", "chain ID of the network, with -nonce (see GET /nonce or /status)")
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL asked for the nonce and chain ID of a new transaction")
+	fs.Parse(args)
+
+	privateKey, err := transaction.PrivateKeyFromString(*privateKeyStr)
+	if err != nil {
+		log.Fatalf("action=multisig_sign, status=fail, err=invalid -private_key: %v", err)
+	}
+	var t *transaction.Transaction
+	if *in != "" {
+		t = readTransactionFile("multisig_sign", *in)
+	} else {
+		if !transaction.ValidateAddress(*recipient) {
+			log.Fatalf("action=multisig_sign, status=fail, err=invalid -to address %q", *recipient)
+		}
+		value, err := transaction.ParseAmount(*valueStr)
+		if err != nil {
+			log.Fatalf("action=multisig_sign, status=fail, err=invalid -amount: %v", err)
+		}
+		fee, err := transaction.ParseAmount(*feeStr)
+		if err != nil {
+			log.Fatalf("action=multisig_sign, status=fail, err=invalid -fee: %v", err)
+		}
+		t = transaction.NewMultisigTransaction(multisigFlags("multisig_sign", *required, *publicKeys), *recipient, value, fee)
+		t.SetMemo(*memo)
+		setFlagNonce("multisig_sign", *nonce, *chainID, *gateway, t)
+		if err := t.CheckVersion(); err != nil {
+			log.Fatalf("action=multisig_sign, status=fail, err=%v", err)
+		}
+	}
+	if err := t.SignMultisig(privateKey); err != nil {
+		log.Fatalf("action=multisig_sign, status=fail, err=%v", err)
+	}
+	m, _ := json.MarshalIndent(t, "", "  ")
+	if *out == "-" {
+		fmt.Println(string(m))
+	} else if err := os.WriteFile(*out, append(m, '\n'), 0o644); err != nil {
+		log.Fatalf("action=multisig_sign, status=fail, err=%v", err)
+	}
+	fmt.Fprintf(os.Stderr, "signed %d of %d required\n", t.Multisig().Signed(), t.Multisig().Required())
+}
+
+// multisigSubmitCommand submits the multisig transaction in -in to the node's POST /transactions.
+func multisigSubmitCommand(args []string) {
+	fs := flag.NewFlagSet("multisig submit", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	in := fs.String("in", "", "signed transaction file written by multisig sign")
+	token := fs.String("token", "", "API key or JWT for a node that requires authentication")
+	fs.Parse(args)
+	t := readTransactionFile("multisig_submit", *in)
+	if t.Multisig() == nil {
+		log.Fatal("action=multisig_submit, status=fail, err=not a multisig transaction")
+	}
+	if err := t.Verify(); err != nil {
+		log.Fatalf("action=multisig_submit, status=fail, err=%v", err)
+	}
+	submitTransaction("multisig_submit", *gateway, *token, t)
+}
+
+// escrowFlags parses the hex public keys -buyer, -seller, and -arbiter into an escrow.
+func escrowFlags(action, buyer, seller, arbiter string) *transaction.Escrow {
+	keys, err := transaction.ParsePublicKeys([]string{buyer, seller, arbiter})
+	if err != nil {
+		log.Fatalf("action=%s, status=fail, err=%v", action, err)
+	}
+	e := &transaction.Escrow{Buyer: keys[0], Seller: keys[1], Arbiter: keys[2]}
+	if _, err := e.Multisig(); err != nil {
+		log.Fatalf("action=%s, status=fail, err=%v", action, err)
+	}
+	return e
+}
+
+// escrowAddressCommand prints the address of the escrow between -buyer, -seller, and -arbiter.
+func escrowAddressCommand(args []string) {
+	fs := flag.NewFlagSet("escrow address", flag.ExitOnError)
+	buyer := fs.String("buyer", "", "hex public key of the buyer")
+	seller := fs.String("seller", "", "hex public key of the seller")
+	arbiter := fs.String("arbiter", "", "hex public key of the arbiter")
+	fs.Parse(args)
+	address, _ := escrowFlags("escrow_address", *buyer, *seller, *arbiter).Address()
+	fmt.Println(address)
+}
+
+// escrowFundCommand sends -amount from the buyer's -private_key wallet to the escrow with -seller and
+// -arbiter, and prints its address.
+func escrowFundCommand(args []string) {
+	fs := flag.NewFlagSet("escrow fund", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	privateKeyStr := fs.String("private_key", "", "hex private key of the buyer")
+	seller := fs.String("seller", "", "hex public key of the seller")
+	arbiter := fs.String("arbiter", "", "hex public key of the arbiter")
+	valueStr := fs.String("amount", "", "coins to hold in escrow, e.g. 1.5")
+	feeStr := fs.String("fee", "0", "fee in coins paid to the miner")
+	token := fs.String("token", "", "API key or JWT for a node that requires authentication")
+	fs.Parse(args)
+
+	privateKey, err := transaction.PrivateKeyFromString(*privateKeyStr)
+	if err != nil {
+		log.Fatalf("action=escrow_fund, status=fail, err=invalid -private_key: %v", err)
+	}
+	w := wallet.WalletFromPrivateKey(privateKey)
+	address, _ := escrowFlags("escrow_fund", w.PublicKeyStr(), *seller, *arbiter).Address()
+	value, err := transaction.ParseAmount(*valueStr)
+	if err != nil {
+		log.Fatalf("action=escrow_fund, status=fail, err=invalid -amount: %v", err)
+	}
+	fee, err := transaction.ParseAmount(*feeStr)
+	if err != nil {
+		log.Fatalf("action=escrow_fund, status=fail, err=invalid -fee: %v", err)
+	}
+	t := transaction.NewTransaction(w.BlockchainAddress(), address, value, fee)
+	setNodeNonce("escrow_fund", *gateway, t)
+	if err := w.SignTransaction(t); err != nil {
+		log.Fatalf("action=escrow_fund, status=fail, err=%v", err)
+	}
+	fmt.Printf("escrow %s\n", address)
+	submitTransaction("escrow_fund", *gateway, *token, t)
+}
+
+// escrowReleaseCommand adds the signature of -private_key, a party to the escrow, to the release in -in,
+// or to a new release of -amount to -to, by default the seller, and writes it to -out.
+func escrowReleaseCommand(args []string) {
+	fs := flag.NewFlagSet("escrow release", flag.ExitOnError)
+	privateKeyStr := fs.String("private_key", "", "hex private key of the buyer, the seller, or the arbiter")
+	in := fs.String("in", "", "release file signed by another party (default: create a new release)")
+	out := fs.String("out", "-", "file to write the signed release to, - for stdout")
+	buyer := fs.String("buyer", "", "hex public key of the buyer, for a new release")
+	seller := fs.String("seller", "", "hex public key of the seller, for a new release")
+	arbiter := fs.String("arbiter", "", "hex public key of the arbiter, for a new release")
+	recipient := fs.String("to", "", "address the escrow is released to, for a new release (default: the seller; the buyer's for a refund)")
+	valueStr := fs.String("amount", "", "coins to release, for a new release")
+	feeStr := fs.String("fee", "0", "fee in coins paid to the miner, for a new release")
+	nonce := fs.Int64("nonce", -1, "nonce of the escrow address's next transaction, for a new release (default: ask -gateway)")
+	chainID := fs.String("chain_id", "", "chain ID of the network, with -nonce (see GET /nonce or /status)")
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL asked for the nonce and chain ID of a new release")
+	fs.Parse(args)
+
+	privateKey, err := transaction.PrivateKeyFromString(*privateKeyStr)
+	if err != nil {
+		log.Fatalf("action=escrow_release, status=fail, err=invalid -private_key: %v", err)
+	}
+	var t *transaction.Transaction
+	if *in != "" {
+		t = readTransactionFile("escrow_release", *in)
+		if t.Multisig() == nil || t.Multisig().Required() != transaction.ESCROW_REQUIRED || len(t.Multisig().PublicKeys()) != 3 {
+			log.Fatal("action=escrow_release, status=fail, err=not an escrow release")
+		}
+	} else {
+		e := escrowFlags("escrow_release", *buyer, *seller, *arbiter)
+		if *recipient == "" {
+			*recipient = transaction.NewAddress(e.Seller)
+		}
+		if !transaction.ValidateAddress(*recipient) {
+			log.Fatalf("action=escrow_release, status=fail, err=invalid -to address %q", *recipient)
+		}
+		value, err := transaction.ParseAmount(*valueStr)
+		if err != nil {
+			log.Fatalf("action=escrow_release, status=fail, err=invalid -amount: %v", err)
+		}
+		fee, err := transaction.ParseAmount(*feeStr)
+		if err != nil {
+			log.Fatalf("action=escrow_release, status=fail, err=invalid -fee: %v", err)
+		}
+		t, _ = transaction.NewEscrowRelease(e, *recipient, value, fee)
+		setFlagNonce("escrow_release", *nonce, *chainID, *gateway, t)
+	}
+	keys := t.Multisig().PublicKeys()
+	role, err := (&transaction.Escrow{Buyer: keys[0], Seller: keys[1], Arbiter: keys[2]}).Role(&privateKey.PublicKey)
+	if err != nil {
+		log.Fatalf("action=escrow_release, status=fail, err=%v", err)
+	}
+	if err := t.SignMultisig(privateKey); err != nil {
+		log.Fatalf("action=escrow_release, status=fail, err=%v", err)
+	}
+	m, _ := json.MarshalIndent(t, "", "  ")
+	if *out == "-" {
+		fmt.Println(string(m))
+	} else if err := os.WriteFile(*out, append(m, '\n'), 0o644); err != nil {
+		log.Fatalf("action=escrow_release, status=fail, err=%v", err)
+	}
+	fmt.Fprintf(os.Stderr, "signed as %s, %d of %d required\n", role, t.Multisig().Signed(), t.Multisig().Required())
+}
+
+// escrowSubmitCommand submits the release in -in to the node's POST /transactions.
+func escrowSubmitCommand(args []string) {
+	fs := flag.NewFlagSet("escrow submit", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	in := fs.String("in", "", "release file signed by two parties with escrow release")
+	token := fs.String("token", "", "API key or JWT for a node that requires authentication")
+	fs.Parse(args)
+	t := readTransactionFile("escrow_submit", *in)
+	if t.Multisig() == nil {
+		log.Fatal("action=escrow_submit, status=fail, err=not an escrow release")
+	}
+	if err := t.Verify(); err != nil {
+		log.Fatalf("action=escrow_submit, status=fail, err=%v", err)
+	}
+	submitTransaction("escrow_submit", *gateway, *token, t)
+}
+
+// readTransactionFile decodes the transaction in file, - for stdin.
+func readTransactionFile(action, file string) *transaction.Transaction {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		log.Fatalf("action=%s, status=fail, err=%v", action, err)
+	}
+	t := new(transaction.Transaction)
+	if err := json.Unmarshal(data, t); err != nil {
+		log.Fatalf("action=%s, status=fail, err=%v", action, err)
+	}
+	return t
+}
+
+// scriptAddressCommand prints the address that coins locked by -script are sent to.
+func scriptAddressCommand(args []string) {
+	fs := flag.NewFlagSet("script address", flag.ExitOnError)
+	asm := fs.String("script", "", `locking script, e.g. "OP_SHA256 <hex> OP_EQUAL"`)
+	fs.Parse(args)
+	locking, err := transaction.ParseScript(*asm)
+	if err != nil {
+		log.Fatalf("action=script_address, status=fail, err=%v", err)
+	}
+	fmt.Println(transaction.ScriptAddress(locking))
+}
+
+// scriptSpendCommand sends coins from the address of -script, unlocked by -unlock, and submits the transaction
+// to the node's POST /transactions. In -unlock, SIG and PUBKEY stand for the transaction's signature by
+// -private_key and its public key.
+func scriptSpendCommand(args []string) {
+	fs := flag.NewFlagSet("script spend", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	asm := fs.String("script", "", "locking script of the sending address")
+	unlockAsm := fs.String("unlock", "", "unlocking script: hex data to push, SIG, and PUBKEY")
+	privateKeyStr := fs.String("private_key", "", "hex private key that SIG and PUBKEY stand for")
+	recipient := fs.String("to", "", "recipient blockchain address")
+	valueStr := fs.String("amount", "", "coins to send, e.g. 1.5")
+	feeStr := fs.String("fee", "0", "fee in coins paid to the miner")
+	token := fs.String("token", "", "API key or JWT for a node that requires authentication")
+	fs.Parse(args)
+
+	locking, err := transaction.ParseScript(*asm)
+	if err != nil {
+		log.Fatalf("action=script_spend, status=fail, err=invalid -script: %v", err)
+	}
+	if !transaction.ValidateAddress(*recipient) {
+		log.Fatalf("action=script_spend, status=fail, err=invalid -to address %q", *recipient)
+	}
+	value, err := transaction.ParseAmount(*valueStr)
+	if err != nil {
+		log.Fatalf("action=script_spend, status=fail, err=invalid -amount: %v", err)
+	}
+	fee, err := transaction.ParseAmount(*feeStr)
+	if err != nil {
+		log.Fatalf("action=script_spend, status=fail, err=invalid -fee: %v", err)
+	}
+	t := transaction.NewScriptTransaction(locking, *recipient, value, fee)
+	setNodeNonce("script_spend", *gateway, t)
+	words := strings.Fields(*unlockAsm)
+	for i, word := range words {
+		if word != "SIG" && word != "PUBKEY" {
+			continue
+		}
+		privateKey, err := transaction.PrivateKeyFromString(*privateKeyStr)
+		if err != nil {
+			log.Fatalf("action=script_spend, status=fail, err=%s needs -private_key: %v", word, err)
+		}
+		if word == "PUBKEY" {
+			words[i] = wallet.WalletFromPrivateKey(privateKey).PublicKeyStr()
+			continue
+		}
+		h := t.SigningHash()
+		signature, err := transaction.SignLowS(privateKey, h[:])
+		if err != nil {
+			log.Fatalf("action=script_spend, status=fail, err=%v", err)
+		}
+		words[i] = hex.EncodeToString(signature)
+	}
+	unlocking, err := transaction.ParseScript(strings.Join(words, " "))
+	if err != nil {
+		log.Fatalf("action=script_spend, status=fail, err=invalid -unlock: %v", err)
+	}
+	t.SetUnlockingScript(unlocking)
+	if err := t.Verify(); err != nil {
+		log.Fatalf("action=script_spend, status=fail, err=%v", err)
+	}
+	submitTransaction("script_spend", *gateway, *token, t)
+}
+
+// htlcSecretCommand prints a random 32-byte preimage and its SHA-256, the hashlock of a swap.
+func htlcSecretCommand(args []string) {
+	fs := flag.NewFlagSet("htlc secret", flag.ExitOnError)
+	fs.Parse(args)
+	preimage := make([]byte, 32)
+	if _, err := rand.Read(preimage); err != nil {
+		log.Fatalf("action=htlc_secret, status=fail, err=%v", err)
+	}
+	fmt.Printf("preimage %x\nhashlock %x\n", preimage, sha256.Sum256(preimage))
+}
+
+// htlcLockCommand sends -amount from the -private_key wallet to the address of the HTLC that pays -recipient
+// for the preimage of -hashlock, or refunds the wallet from -timeout on, and prints the address.
+func htlcLockCommand(args []string) {
+	fs := flag.NewFlagSet("htlc lock", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	privateKeyStr := fs.String("private_key", "", "hex private key of the locking wallet, which gets the refund")
+	hashlockStr := fs.String("hashlock", "", "hex SHA-256 of the preimage")
+	recipient := fs.String("recipient", "", "blockchain address that can claim with the preimage")
+	timeout := fs.Uint64("timeout", 0, "height, or Unix time from 500000000 on, from which the refund is possible")
+	valueStr := fs.String("amount", "", "coins to lock, e.g. 1.5")
+	feeStr := fs.String("fee", "0", "fee in coins paid to the miner")
+	token := fs.String("token", "", "API key or JWT for a node that requires authentication")
+	fs.Parse(args)
+
+	privateKey, err := transaction.PrivateKeyFromString(*privateKeyStr)
+	if err != nil {
+		log.Fatalf("action=htlc_lock, status=fail, err=invalid -private_key: %v", err)
+	}
+	hashlock, err := transaction.HashFromString(*hashlockStr)
+	if err != nil {
+		log.Fatalf("action=htlc_lock, status=fail, err=invalid -hashlock: %v", err)
+	}
+	w := wallet.WalletFromPrivateKey(privateKey)
+	h, err := transaction.NewHTLC(hashlock, *recipient, w.BlockchainAddress(), *timeout)
+	if err != nil {
+		log.Fatalf("action=htlc_lock, status=fail, err=%v", err)
+	}
+	value, err := transaction.ParseAmount(*valueStr)
+	if err != nil {
+		log.Fatalf("action=htlc_lock, status=fail, err=invalid -amount: %v", err)
+	}
+	fee, err := transaction.ParseAmount(*feeStr)
+	if err != nil {
+		log.Fatalf("action=htlc_lock, status=fail, err=invalid -fee: %v", err)
+	}
+	t := transaction.NewTransaction(w.BlockchainAddress(), h.Address(), value, fee)
+	setNodeNonce("htlc_lock", *gateway, t)
+	if err := w.SignTransaction(t); err != nil {
+		log.Fatalf("action=htlc_lock, status=fail, err=%v", err)
+	}
+	fmt.Printf("htlc %s\n", h.Address())
+	submitTransaction("htlc_lock", *gateway, *token, t)
+}
+
+// htlcClaimCommand sends -amount from the HTLC that pays the -private_key wallet for -preimage to -to,
+// revealing the preimage.
+func htlcClaimCommand(args []string) {
+	fs := flag.NewFlagSet("htlc claim", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	privateKeyStr := fs.String("private_key", "", "hex private key of the HTLC's recipient")
+	preimageStr := fs.String("preimage", "", "hex preimage of the hashlock")
+	refund := fs.String("refund", "", "refund blockchain address of the HTLC")
+	timeout := fs.Uint64("timeout", 0, "timeout of the HTLC")
+	to := fs.String("to", "", "recipient blockchain address (default: the claiming wallet)")
+	valueStr := fs.String("amount", "", "coins to claim, e.g. 1.5")
+	feeStr := fs.String("fee", "0", "fee in coins paid to the miner")
+	token := fs.String("token", "", "API key or JWT for a node that requires authentication")
+	fs.Parse(args)
+
+	preimage, err := hex.DecodeString(*preimageStr)
+	if err != nil || len(preimage) == 0 {
+		log.Fatalf("action=htlc_claim, status=fail, err=invalid -preimage %q", *preimageStr)
+	}
+	privateKey, err := transaction.PrivateKeyFromString(*privateKeyStr)
+	if err != nil {
+		log.Fatalf("action=htlc_claim, status=fail, err=invalid -private_key: %v", err)
+	}
+	h, err := transaction.NewHTLC(sha256.Sum256(preimage), wallet.WalletFromPrivateKey(privateKey).BlockchainAddress(), *refund, *timeout)
+	if err != nil {
+		log.Fatalf("action=htlc_claim, status=fail, err=%v", err)
+	}
+	htlcSpend("htlc_claim", *gateway, *token, privateKey, h.Claim(preimage), *to, *valueStr, *feeStr)
+}
+
+// htlcRefundCommand sends -amount from the HTLC that refunds the -private_key wallet to -to, locked until
+// the timeout.
+func htlcRefundCommand(args []string) {
+	fs := flag.NewFlagSet("htlc refund", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	privateKeyStr := fs.String("private_key", "", "hex private key of the HTLC's refund address")
+	hashlockStr := fs.String("hashlock", "", "hex hashlock of the HTLC")
+	recipient := fs.String("recipient", "", "recipient blockchain address of the HTLC")
+	timeout := fs.Uint64("timeout", 0, "timeout of the HTLC")
+	to := fs.String("to", "", "recipient blockchain address (default: the refunded wallet)")
+	valueStr := fs.String("amount", "", "coins to take back, e.g. 1.5")
+	feeStr := fs.String("fee", "0", "fee in coins paid to the miner")
+	token := fs.String("token", "", "API key or JWT for a node that requires authentication")
+	fs.Parse(args)
+
+	hashlock, err := transaction.HashFromString(*hashlockStr)
+	if err != nil {
+		log.Fatalf("action=htlc_refund, status=fail, err=invalid -hashlock: %v", err)
+	}
+	privateKey, err := transaction.PrivateKeyFromString(*privateKeyStr)
+	if err != nil {
+		log.Fatalf("action=htlc_refund, status=fail, err=invalid -private_key: %v", err)
+	}
+	h, err := transaction.NewHTLC(hashlock, *recipient, wallet.WalletFromPrivateKey(privateKey).BlockchainAddress(), *timeout)
+	if err != nil {
+		log.Fatalf("action=htlc_refund, status=fail, err=%v", err)
+	}
+	htlcSpend("htlc_refund", *gateway, *token, privateKey, h, *to, *valueStr, *feeStr)
+}
+
+// htlcSpend signs a transaction of valueStr coins from the address of h to to, or to privateKey's own
+// address, and submits it to the node's POST /transactions.
+func htlcSpend(action, gateway, token string, privateKey *ecdsa.PrivateKey, h *transaction.HTLC, to, valueStr, feeStr string) {
+	if to == "" {
+		to = wallet.WalletFromPrivateKey(privateKey).BlockchainAddress()
+	}
+	value, err := transaction.ParseAmount(valueStr)
+	if err != nil {
+		log.Fatalf("action=%s, status=fail, err=invalid -amount: %v", action, err)
+	}
+	fee, err := transaction.ParseAmount(feeStr)
+	if err != nil {
+		log.Fatalf("action=%s, status=fail, err=invalid -fee: %v", action, err)
+	}
+	t := transaction.NewHTLCTransaction(h, to, value, fee)
+	setNodeNonce(action, gateway, t)
+	if err := t.Sign(privateKey); err != nil {
+		log.Fatalf("action=%s, status=fail, err=%v", action, err)
+	}
+	if err := t.Verify(); err != nil {
+		log.Fatalf("action=%s, status=fail, err=%v", action, err)
+	}
+	submitTransaction(action, gateway, token, t)
+}
+
+// contractDeployCommand assembles -code, or reads the WebAssembly module -wasm, signs its deployment with -private_key, submits it to the node's
+// POST /transactions, and prints the address the contract will have.
+func contractDeployCommand(args []string) {
+	fs := flag.NewFlagSet("contract deploy", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	privateKeyStr := fs.String("private_key", "", "hex private key of the deploying wallet")
+	asm := fs.String("code", "", `contract assembly, e.g. "PUSH 0 SLOAD PUSH 1 ADD DUP PUSH 0 SSTORE RETURN"`)
+	wasm := fs.String("wasm", "", "WebAssembly module file to deploy instead of -code")
+	valueStr := fs.String("amount", "0", "coins the contract starts with")
+	feeStr := fs.String("fee", "", "fee in coins paid to the miner (default: the intrinsic gas)")
+	token := fs.String("token", "", "API key or JWT for a node that requires authentication")
+	fs.Parse(args)
+
+	privateKey, err := transaction.PrivateKeyFromString(*privateKeyStr)
+	if err != nil {
+		log.Fatalf("action=contract_deploy, status=fail, err=invalid -private_key: %v", err)
+	}
+	var code []byte
+	if *wasm != "" {
+		if code, err = os.ReadFile(*wasm); err != nil {
+			log.Fatalf("action=contract_deploy, status=fail, err=%v", err)
+		}
+		if _, err := node.ParseWasm(code); err != nil {
+			log.Fatalf("action=contract_deploy, status=fail, err=invalid -wasm: %v", err)
+		}
+	} else if code, err = node.AssembleContract(*asm); err != nil {
+		log.Fatalf("action=contract_deploy, status=fail, err=invalid -code: %v", err)
+	}
+	value, err := transaction.ParseAmount(*valueStr)
+	if err != nil {
+		log.Fatalf("action=contract_deploy, status=fail, err=invalid -amount: %v", err)
+	}
+	w := wallet.WalletFromPrivateKey(privateKey)
+	t := transaction.NewContractDeployment(w.BlockchainAddress(), code, value, 0)
+	t.SetFee(transaction.Amount(t.IntrinsicGas()) * transaction.GAS_PRICE)
+	if *feeStr != "" {
+		fee, err := transaction.ParseAmount(*feeStr)
+		if err != nil {
+			log.Fatalf("action=contract_deploy, status=fail, err=invalid -fee: %v", err)
+		}
+		t.SetFee(fee)
+	}
+	setNodeNonce("contract_deploy", *gateway, t)
+	if err := w.SignTransaction(t); err != nil {
+		log.Fatalf("action=contract_deploy, status=fail, err=%v", err)
+	}
+	fmt.Printf("contract %s\n", t.ContractAddress())
+	submitTransaction("contract_deploy", *gateway, *token, t)
+}
+
+// contractCallCommand signs a call of the contract at -address with -input and submits it to the node's
+// POST /transactions.
+func contractCallCommand(args []string) {
+	fs := flag.NewFlagSet("contract call", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	privateKeyStr := fs.String("private_key", "", "hex private key of the calling wallet")
+	address := fs.String("address", "", "address of the contract")
+	inputStr := fs.String("input", "", "comma-separated input words, e.g. 1,42")
+	valueStr := fs.String("amount", "0", "coins to send to the contract")
+	feeStr := fs.String("fee", "0.001", "fee in coins paid to the miner; it buys the call's gas")
+	token := fs.String("token", "", "API key or JWT for a node that requires authentication")
+	fs.Parse(args)
+
+	privateKey, err := transaction.PrivateKeyFromString(*privateKeyStr)
+	if err != nil {
+		log.Fatalf("action=contract_call, status=fail, err=invalid -private_key: %v", err)
+	}
+	input, err := parseContractInput(*inputStr)
+	if err != nil {
+		log.Fatalf("action=contract_call, status=fail, err=invalid -input: %v", err)
+	}
+	value, err := transaction.ParseAmount(*valueStr)
+	if err != nil {
+		log.Fatalf("action=contract_call, status=fail, err=invalid -amount: %v", err)
+	}
+	fee, err := transaction.ParseAmount(*feeStr)
+	if err != nil {
+		log.Fatalf("action=contract_call, status=fail, err=invalid -fee: %v", err)
+	}
+	w := wallet.WalletFromPrivateKey(privateKey)
+	t := transaction.NewContractCall(w.BlockchainAddress(), *address, input, value, fee)
+	setNodeNonce("contract_call", *gateway, t)
+	if err := w.SignTransaction(t); err != nil {
+		log.Fatalf("action=contract_call, status=fail, err=%v", err)
+	}
+	submitTransaction("contract_call", *gateway, *token, t)
+}
+
+// contractShowCommand prints the node's GET /contract for the contract at -address.
+func contractShowCommand(args []string) {
+	fs := flag.NewFlagSet("contract show", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	address := fs.String("address", "", "address of the contract")
+	fs.Parse(args)
+	printNodeAnswer("contract_show", strings.TrimSuffix(*gateway, "/")+"/contract?"+url.Values{"address": {*address}}.Encode())
+}
+
+// contractReceiptCommand prints the node's GET /receipt for the contract transaction with -id.
+func contractReceiptCommand(args []string) {
+	fs := flag.NewFlagSet("contract receipt", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	id := fs.String("id", "", "ID of the contract transaction")
+	fs.Parse(args)
+	printNodeAnswer("contract_receipt", strings.TrimSuffix(*gateway, "/")+"/receipt?"+url.Values{"id": {*id}}.Encode())
+}
+
+// printNodeAnswer prints the body of a GET of u, exiting with a log line keyed by action unless it is 200 OK.
+func printNodeAnswer(action, u string) {
+	resp, err := http.Get(u)
+	if err != nil {
+		log.Fatalf("action=%s, status=fail, err=%v", action, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("action=%s, status=fail, http_status=%d, body=%s", action, resp.StatusCode, bytes.TrimSpace(body))
+	}
+	fmt.Println(string(bytes.TrimSpace(body)))
+}
+
+// tokenCreateCommand signs the registration of -symbol with -supply units, all held by the -private_key
+// wallet, and submits it to the node's POST /transactions.
+func tokenCreateCommand(args []string) {
+	fs := flag.NewFlagSet("token create", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	privateKeyStr := fs.String("private_key", "", "hex private key of the issuing wallet")
+	symbol := fs.String("symbol", "", "token symbol, 3 to 8 upper-case letters and digits")
+	supply := fs.Int64("supply", 0, "units of the token to create")
+	feeStr := fs.String("fee", transaction.TOKEN_CREATE_FEE.String(), "fee in coins paid to the miner")
+	token := fs.String("token", "", "API key or JWT for a node that requires authentication")
+	fs.Parse(args)
+
+	privateKey, err := transaction.PrivateKeyFromString(*privateKeyStr)
+	if err != nil {
+		log.Fatalf("action=token_create, status=fail, err=invalid -private_key: %v", err)
+	}
+	fee, err := transaction.ParseAmount(*feeStr)
+	if err != nil {
+		log.Fatalf("action=token_create, status=fail, err=invalid -fee: %v", err)
+	}
+	w := wallet.WalletFromPrivateKey(privateKey)
+	t := transaction.NewTokenCreation(w.BlockchainAddress(), *symbol, *supply, fee)
+	if err := t.CheckToken(); err != nil {
+		log.Fatalf("action=token_create, status=fail, err=%v", err)
+	}
+	setNodeNonce("token_create", *gateway, t)
+	if err := w.SignTransaction(t); err != nil {
+		log.Fatalf("action=token_create, status=fail, err=%v", err)
+	}
+	submitTransaction("token_create", *gateway, *token, t)
+}
+
+// tokenTransferCommand signs a transfer of -amount units of -symbol to -to and submits it to the node's
+// POST /transactions.
+func tokenTransferCommand(args []string) {
+	fs := flag.NewFlagSet("token transfer", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	privateKeyStr := fs.String("private_key", "", "hex private key of the sending wallet")
+	symbol := fs.String("symbol", "", "token symbol")
+	to := fs.String("to", "", "recipient blockchain address")
+	amount := fs.Int64("amount", 0, "units of the token to send")
+	feeStr := fs.String("fee", "0.001", "fee in coins paid to the miner")
+	token := fs.String("token", "", "API key or JWT for a node that requires authentication")
+	fs.Parse(args)
+
+	privateKey, err := transaction.PrivateKeyFromString(*privateKeyStr)
+	if err != nil {
+		log.Fatalf("action=token_transfer, status=fail, err=invalid -private_key: %v", err)
+	}
+	fee, err := transaction.ParseAmount(*feeStr)
+	if err != nil {
+		log.Fatalf("action=token_transfer, status=fail, err=invalid -fee: %v", err)
+	}
+	w := wallet.WalletFromPrivateKey(privateKey)
+	t := transaction.NewTokenTransfer(w.BlockchainAddress(), *to, *symbol, *amount, fee)
+	if err := t.CheckToken(); err != nil {
+		log.Fatalf("action=token_transfer, status=fail, err=%v", err)
+	}
+	setNodeNonce("token_transfer", *gateway, t)
+	if err := w.SignTransaction(t); err != nil {
+		log.Fatalf("action=token_transfer, status=fail, err=%v", err)
+	}
+	submitTransaction("token_transfer", *gateway, *token, t)
+}
+
+// tokenShowCommand prints the node's GET /token for -symbol.
+func tokenShowCommand(args []string) {
+	fs := flag.NewFlagSet("token show", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	symbol := fs.String("symbol", "", "token symbol")
+	fs.Parse(args)
+	printNodeAnswer("token_show", strings.TrimSuffix(*gateway, "/")+"/token?"+url.Values{"symbol": {*symbol}}.Encode())
+}
+
+// assetMintCommand signs the mint of asset -id, with the SHA-256 of -metadata_file or the hash -metadata,
+// owned by the -private_key wallet, and submits it to the node's POST /transactions.
+func assetMintCommand(args []string) {
+	fs := flag.NewFlagSet("asset mint", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	privateKeyStr := fs.String("private_key", "", "hex private key of the minting wallet")
+	id := fs.String("id", "", "unique asset ID")
+	metadataFile := fs.String("metadata_file", "", "file whose SHA-256 becomes the metadata hash")
+	metadataStr := fs.String("metadata", "", "hex SHA-256 of the metadata, instead of -metadata_file")
+	feeStr := fs.String("fee", transaction.ASSET_MINT_FEE.String(), "fee in coins paid to the miner")
+	token := fs.String("token", "", "API key or JWT for a node that requires authentication")
+	fs.Parse(args)
+
+	privateKey, err := transaction.PrivateKeyFromString(*privateKeyStr)
+	if err != nil {
+		log.Fatalf("action=asset_mint, status=fail, err=invalid -private_key: %v", err)
+	}
+	var metadata [32]byte
+	if *metadataFile != "" {
+		content, err := os.ReadFile(*metadataFile)
+		if err != nil {
+			log.Fatalf("action=asset_mint, status=fail, err=%v", err)
+		}
+		metadata = sha256.Sum256(content)
+	} else if metadata, err = transaction.HashFromString(*metadataStr); err != nil {
+		log.Fatalf("action=asset_mint, status=fail, err=invalid -metadata: %v", err)
+	}
+	fee, err := transaction.ParseAmount(*feeStr)
+	if err != nil {
+		log.Fatalf("action=asset_mint, status=fail, err=invalid -fee: %v", err)
+	}
+	w := wallet.WalletFromPrivateKey(privateKey)
+	t := transaction.NewAssetMint(w.BlockchainAddress(), *id, metadata, fee)
+	if err := t.CheckAsset(); err != nil {
+		log.Fatalf("action=asset_mint, status=fail, err=%v", err)
+	}
+	setNodeNonce("asset_mint", *gateway, t)
+	if err := w.SignTransaction(t); err != nil {
+		log.Fatalf("action=asset_mint, status=fail, err=%v", err)
+	}
+	submitTransaction("asset_mint", *gateway, *token, t)
+}
+
+// assetTransferCommand signs the transfer of asset -id to -to and submits it to the node's POST /transactions.
+func assetTransferCommand(args []string) {
+	fs := flag.NewFlagSet("asset transfer", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	privateKeyStr := fs.String("private_key", "", "hex private key of the owning wallet")
+	id := fs.String("id", "", "asset ID")
+	to := fs.String("to", "", "recipient blockchain address")
+	feeStr := fs.String("fee", "0.001", "fee in coins paid to the miner")
+	token := fs.String("token", "", "API key or JWT for a node that requires authentication")
+	fs.Parse(args)
+
+	privateKey, err := transaction.PrivateKeyFromString(*privateKeyStr)
+	if err != nil {
+		log.Fatalf("action=asset_transfer, status=fail, err=invalid -private_key: %v", err)
+	}
+	fee, err := transaction.ParseAmount(*feeStr)
+	if err != nil {
+		log.Fatalf("action=asset_transfer, status=fail, err=invalid -fee: %v", err)
+	}
+	w := wallet.WalletFromPrivateKey(privateKey)
+	t := transaction.NewAssetTransfer(w.BlockchainAddress(), *to, *id, fee)
+	if err := t.CheckAsset(); err != nil {
+		log.Fatalf("action=asset_transfer, status=fail, err=%v", err)
+	}
+	setNodeNonce("asset_transfer", *gateway, t)
+	if err := w.SignTransaction(t); err != nil {
+		log.Fatalf("action=asset_transfer, status=fail, err=%v", err)
+	}
+	submitTransaction("asset_transfer", *gateway, *token, t)
+}
+
+// assetShowCommand prints the node's GET /asset for -id.
+func assetShowCommand(args []string) {
+	fs := flag.NewFlagSet("asset show", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	id := fs.String("id", "", "asset ID")
+	fs.Parse(args)
+	printNodeAnswer("asset_show", strings.TrimSuffix(*gateway, "/")+"/asset?"+url.Values{"id": {*id}}.Encode())
+}
+
+// assetListCommand prints the node's GET /assets for -owner.
+func assetListCommand(args []string) {
+	fs := flag.NewFlagSet("asset list", flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	owner := fs.String("owner", "", "blockchain address of the owner")
+	fs.Parse(args)
+	printNodeAnswer("asset_list", strings.TrimSuffix(*gateway, "/")+"/assets?"+url.Values{"owner": {*owner}}.Encode())
+}
+
+// mempoolCommand sends the node's /mempool the request of a mempool command, for the transaction -id when
+// withID, and prints the answer.
+func mempoolCommand(name, method string, withID bool, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL")
+	token := fs.String("token", "", "API key or JWT for a node that requires authentication")
+	var id *string
+	if withID {
+		id = fs.String("id", "", "ID of the pending transaction")
+	}
+	fs.Parse(args)
+
+	action := strings.ReplaceAll(name, " ", "_")
+	u := strings.TrimSuffix(*gateway, "/") + "/mempool"
+	if withID {
+		if *id == "" {
+			log.Fatalf("action=%s, status=fail, err=-id is required", action)
+		}
+		u += "?" + url.Values{"id": {*id}}.Encode()
+	}
+	req, _ := http.NewRequest(method, u, nil)
+	httputil.SetBearerToken(req, *token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("action=%s, status=fail, err=%v", action, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("action=%s, status=fail, http_status=%d, body=%s", action, resp.StatusCode, bytes.TrimSpace(body))
+	}
+	fmt.Println(string(bytes.TrimSpace(body)))
+}
+
+// stratumWorkerCommand mines for the stratum server at -server as -worker until interrupted.
+func stratumWorkerCommand(args []string) {
+	fs := flag.NewFlagSet("stratum worker", flag.ExitOnError)
+	server := fs.String("server", "127.0.0.1:3333", "host:port of the node's stratum server")
+	worker := fs.String("worker", "", "worker name: the address the shares are credited to, optionally followed by .NAME")
+	threads := fs.Int("threads", runtime.GOMAXPROCS(0), "goroutines hashing in parallel")
+	fs.Parse(args)
+
+	if *worker == "" {
+		log.Fatal("action=stratum_worker, status=fail, err=-worker is required")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := node.RunStratumWorker(ctx, *server, *worker, *threads); err != nil {
+		log.Fatalf("action=stratum_worker, status=fail, err=%v", err)
+	}
+}
+
+// tokenNewCommand prints an HS256 JWT signed with -secret, for nodes started with the same -jwt_secret.
+func tokenNewCommand(args []string) {
+	fs := flag.NewFlagSet("token new", flag.ExitOnError)
+	secret := fs.String("secret", "", "the nodes' -jwt_secret")
+	subject := fs.String("subject", "", "who the token is for (the sub claim)")
+	ttl := fs.Duration("ttl", 24*time.Hour, "how long the token stays valid (0 never expires)")
+	fs.Parse(args)
+
+	if *secret == "" {
+		log.Fatal("action=token_new, status=fail, err=-secret is required")
+	}
+	token, err := node.NewJWT([]byte(*secret), *subject, *ttl, time.Now())
+	if err != nil {
+		log.Fatalf("action=token_new, status=fail, err=%v", err)
+	}
+	fmt.Println(token)
+}
+
+// chainFlags registers the flags that choose where chain commands read the chain from.
+func chainFlags(fs *flag.FlagSet) (gateway, file *string) {
+	gateway = fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL whose GET /chain is read")
+	file = fs.String("file", "", "read a chain written by Blockchain.Export instead of asking a node")
+	return gateway, file
+}
+
+// loadChain reads a blockchain from an exported file, or from the node at gateway when file is empty.
+// Neither source is validated.
+func loadChain(gateway, file string) (*node.Blockchain, error) {
+	var m []byte
+	var err error
+	if file != "" {
+		m, err = os.ReadFile(file)
+	} else {
+		var resp *http.Response
+		resp, err = http.Get(strings.TrimSuffix(gateway, "/") + "/chain")
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		m, err = io.ReadAll(resp.Body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return node.DecodeBlockchain(m)
+}
+
+// chainPrintCommand prints every block of a chain.
+func chainPrintCommand(args []string) {
+	fs := flag.NewFlagSet("chain print", flag.ExitOnError)
+	gateway, file := chainFlags(fs)
+	fs.Parse(args)
+	bc, err := loadChain(*gateway, *file)
+	if err != nil {
+		log.Fatalf("action=chain_print, status=fail, err=%v", err)
+	}
+	bc.Print()
+}
+
+// chainValidateCommand checks a chain with ValidChain under -pow proof-of-work at -difficulty and exits with
+// status 1 if it is invalid. Transactions must be signed for -chain_id, by default the chain's own.
+func chainValidateCommand(args []string) {
+	fs := flag.NewFlagSet("chain validate", flag.ExitOnError)
+	gateway, file := chainFlags(fs)
+	difficulty := fs.Float64("difficulty", 0, "leading hex zeros every block hash needs, fractions allowed (0 for the -pow algorithm's default)")
+	powName := fs.String("pow", "", "proof-of-work hash function the chain was mined with (empty for the chain's hasher)")
+	chainID := fs.String("chain_id", "", "chain ID the transactions are signed for (default: the one the chain's JSON names)")
+	fs.Parse(args)
+	bc, err := loadChain(*gateway, *file)
+	if err != nil {
+		log.Fatalf("action=chain_validate, status=fail, err=%v", err)
+	}
+	powHasher := bc.Hasher()
+	if *powName != "" {
+		if powHasher, err = block.ProofOfWorkHasherByName(*powName); err != nil {
+			log.Fatalf("action=chain_validate, status=fail, err=invalid -pow: %v", err)
+		}
+	}
+	bc.SetConsensus(block.NewProofOfWork(*difficulty, powHasher))
+	chain := bc.Chain()
+	if *chainID != "" {
+		bc.SetChainID(*chainID)
+	}
+	if bc.ChainID() != "" && (len(chain) == 0 || chain[0].PreviousHash() != bc.Hasher().Sum256([]byte(bc.ChainID()))) {
+		// A genesis config hashes its chain ID into the genesis block, so a wrong one shows there.
+		log.Fatalf("action=chain_validate, status=fail, err=the genesis block is not of chain %q", bc.ChainID())
+	}
+	if !bc.ValidChain(chain) {
+		fmt.Printf("chain invalid, length=%d\n", len(chain))
+		os.Exit(1)
+	}
+	fmt.Printf("chain valid, length=%d, tip=%x\n", len(chain), bc.BlockHash(chain[len(chain)-1]))
+}
+
+// parseContractInput parses comma-separated decimal words, as the CLI takes a call's input.
+func parseContractInput(s string) ([]int64, error) {
+	input := make([]int64, 0)
+	if s == "" {
+		return input, nil
+	}
+	for _, w := range strings.Split(s, ",") {
+		word, err := strconv.ParseInt(strings.TrimSpace(w), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input word %q", w)
+		}
+		input = append(input, word)
+	}
+	return input, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/node"
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+// captureStdout returns what f prints to standard output.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan []byte)
+	go func() {
+		m, _ := io.ReadAll(r)
+		out <- m
+	}()
+	f()
+	w.Close()
+	return string(<-out)
+}
+
+// newWallet returns a freshly generated wallet.
+func newWallet(t *testing.T) *wallet.Wallet {
+	t.Helper()
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestWalletNewPrintsAUsableKey(t *testing.T) {
+	out := captureStdout(t, func() { runCommand([]string{"wallet", "new"}) })
+	fields := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		name, value, _ := strings.Cut(line, " ")
+		fields[name] = strings.TrimSpace(value)
+	}
+	privateKey, err := transaction.PrivateKeyFromString(fields["private_key"])
+	if err != nil {
+		t.Fatalf("wallet new printed %q: %v", out, err)
+	}
+	w := wallet.WalletFromPrivateKey(privateKey)
+	if fields["public_key"] != w.PublicKeyStr() || fields["blockchain_address"] != w.BlockchainAddress() {
+		t.Fatalf("wallet new printed %q, whose keys do not match", out)
+	}
+}
+
+func TestWalletSendSubmitsASignedTransaction(t *testing.T) {
+	miner := newWallet(t)
+	bcs := node.NewBlockchainServer(0, node.NeighborRange{}, "", 0, nil)
+	bcs.SetMinerWallet(miner)
+	bcs.SetConsensus(block.NewProofOfWork(1, nil))
+	bc := bcs.GetBlockchain()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nonce", bcs.Nonce)
+	mux.HandleFunc("/transactions", bcs.Transactions)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	recipient := newWallet(t).BlockchainAddress()
+	out := captureStdout(t, func() {
+		runCommand([]string{"wallet", "send", "-gateway", srv.URL, "-private_key", miner.PrivateKeyStr(),
+			"-to", recipient, "-amount", "0.25", "-fee", "0.01", "-memo", "invoice 7"})
+	})
+	if !strings.Contains(out, "success") {
+		t.Fatalf("wallet send printed %q", out)
+	}
+	pool := bc.TransactionPool()
+	if len(pool) != 1 {
+		t.Fatalf("pool holds %d transactions, want 1", len(pool))
+	}
+	tx := pool[0]
+	if tx.RecipientBlockchainAddress() != recipient || tx.Value() != transaction.COIN/4 || tx.Fee() != transaction.COIN/100 || tx.Memo() != "invoice 7" {
+		t.Fatalf("pooled %s, want 0.25 to %s with fee 0.01 and the memo", tx.ID(), recipient)
+	}
+	if err := tx.Verify(); err != nil {
+		t.Fatalf("pooled transaction does not verify: %v", err)
+	}
+}
+
+func TestChainCommandsReadAnExportedChain(t *testing.T) {
+	miner := newWallet(t)
+	bc := node.NewBlockchain(miner.BlockchainAddress(), 0, nil, nil, nil, nil)
+	tx, err := miner.NewTransaction(newWallet(t).BlockchainAddress(), transaction.COIN/2, 0, bc.NextNonce(miner.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	path := filepath.Join(t.TempDir(), "chain.json")
+	if err := bc.Export(path); err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t, func() { runCommand([]string{"chain", "validate", "-file", path}) })
+	if want := "chain valid, length=2"; !strings.HasPrefix(out, want) {
+		t.Fatalf("chain validate printed %q, want %q...", out, want)
+	}
+	out = captureStdout(t, func() { runCommand([]string{"chain", "print", "-file", path}) })
+	if !strings.Contains(out, tx.RecipientBlockchainAddress()) {
+		t.Fatalf("chain print output lacks the confirmed transfer:\n%s", out)
+	}
+}
+
+func TestEscrowCommandsCollectTwoSignatures(t *testing.T) {
+	buyer, seller, arbiter := newWallet(t), newWallet(t), newWallet(t)
+	parties := []string{"-buyer", buyer.PublicKeyStr(), "-seller", seller.PublicKeyStr(), "-arbiter", arbiter.PublicKeyStr()}
+	e := &transaction.Escrow{Buyer: buyer.PublicKey(), Seller: seller.PublicKey(), Arbiter: arbiter.PublicKey()}
+	want, err := e.Address()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := captureStdout(t, func() { runCommand(append([]string{"escrow", "address"}, parties...)) }); strings.TrimSpace(out) != want {
+		t.Fatalf("escrow address printed %q, want %s", out, want)
+	}
+
+	// The arbiter sides with the buyer: a refund to the buyer, signed by both.
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "arbiter.json"), filepath.Join(dir, "both.json")
+	runCommand(append([]string{"escrow", "release", "-private_key", arbiter.PrivateKeyStr(), "-out", first,
+		"-to", buyer.BlockchainAddress(), "-amount", "0.5", "-nonce", "0", "-chain_id", "testnet"}, parties...))
+	runCommand([]string{"escrow", "release", "-private_key", buyer.PrivateKeyStr(), "-in", first, "-out", second})
+	tx := readTransactionFile("test", second)
+	if tx.SenderBlockchainAddress() != want || tx.RecipientBlockchainAddress() != buyer.BlockchainAddress() || tx.Value() != transaction.COIN/2 || tx.ChainID() != "testnet" {
+		t.Fatalf("release of %s from %s to %s, want 0.5 from the escrow to the buyer", tx.Value(), tx.SenderBlockchainAddress(), tx.RecipientBlockchainAddress())
+	}
+	if err := tx.Verify(); err != nil {
+		t.Fatalf("Verify of the cosigned release = %v", err)
+	}
+}
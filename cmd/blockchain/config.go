@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CONFIG_ENV_PREFIX prefixes the environment variable that overrides each flag, e.g. BLOCKCHAIN_PORT for -port.
+const CONFIG_ENV_PREFIX = "BLOCKCHAIN_"
+
+// LoadConfigFile reads a node configuration written in a flat subset of TOML: one `name = value` per line,
+// where name is a command-line flag name and value is a quoted string, a number, or a boolean.
+// Blank lines and # comments are ignored; tables and arrays are not supported.
+func LoadConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected name = value", path, n)
+		}
+		name = strings.TrimSpace(name)
+		value, err = parseConfigValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", path, n, name, err)
+		}
+		values[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// parseConfigValue unquotes a TOML string or returns a bare number or boolean, dropping any trailing comment.
+func parseConfigValue(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) {
+		quoted, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return "", err
+		}
+		if rest := strings.TrimSpace(s[len(quoted):]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %q after string", rest)
+		}
+		return strconv.Unquote(quoted)
+	}
+	value, _, _ := strings.Cut(s, "#")
+	value = strings.TrimSpace(value)
+	if value == "" || strings.ContainsAny(value, "[{") {
+		return "", fmt.Errorf("unsupported value %q", s)
+	}
+	return value, nil
+}
+
+// ApplyConfig fills every flag of fs that was not given on the command line, first from values (a config
+// file) and then from CONFIG_ENV_PREFIX environment variables, so the command line overrides the environment,
+// which overrides the file, which overrides the defaults.
+func ApplyConfig(fs *flag.FlagSet, values map[string]string) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	for name, value := range values {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown config setting %q", name)
+		}
+		if explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("config setting %s: %w", name, err)
+		}
+	}
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(CONFIG_ENV_PREFIX + strings.ToUpper(f.Name))
+		if !ok || explicit[f.Name] || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s%s: %w", CONFIG_ENV_PREFIX, strings.ToUpper(f.Name), setErr)
+		}
+	})
+	return err
+}
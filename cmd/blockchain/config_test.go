@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeConfig writes content to a config file and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "node.toml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFileReadsFlatTOML(t *testing.T) {
+	values, err := LoadConfigFile(writeConfig(t, `# a node
+port = 5001
+gateway = "http://127.0.0.1:5000" # the default
+auto_mine = true
+
+mining_interval = "5s"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"port": "5001", "gateway": "http://127.0.0.1:5000", "auto_mine": "true", "mining_interval": "5s"}
+	if len(values) != len(want) {
+		t.Fatalf("LoadConfigFile = %v, want %v", values, want)
+	}
+	for name, value := range want {
+		if values[name] != value {
+			t.Errorf("%s = %q, want %q", name, values[name], value)
+		}
+	}
+
+	for _, content := range []string{"port 5001", "peers = [\"a\", \"b\"]", "[node]\nport = 1", `gateway = "x" y`, "port ="} {
+		if _, err := LoadConfigFile(writeConfig(t, content)); err == nil {
+			t.Errorf("LoadConfigFile(%q) succeeded", content)
+		}
+	}
+}
+
+func TestCommandLineOverridesEnvironmentOverridesConfigFile(t *testing.T) {
+	fs := flag.NewFlagSet("blockchain", flag.ContinueOnError)
+	port := fs.Uint("port", 5000, "")
+	interval := fs.Duration("mining_interval", 20*time.Second, "")
+	dataDir := fs.String("data_dir", "data", "")
+	autoMine := fs.Bool("auto_mine", false, "")
+	if err := fs.Parse([]string{"-port", "5003"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(CONFIG_ENV_PREFIX+"PORT", "5002")
+	t.Setenv(CONFIG_ENV_PREFIX+"MINING_INTERVAL", "1s")
+	values := map[string]string{"port": "5001", "mining_interval": "5s", "data_dir": "/var/lib/node"}
+	if err := ApplyConfig(fs, values); err != nil {
+		t.Fatal(err)
+	}
+	if *port != 5003 || *interval != time.Second || *dataDir != "/var/lib/node" || *autoMine {
+		t.Fatalf("port %d, mining_interval %v, data_dir %s, auto_mine %t; want 5003, 1s, /var/lib/node, false", *port, *interval, *dataDir, *autoMine)
+	}
+
+	if err := ApplyConfig(fs, map[string]string{"difficulty": "3"}); err == nil {
+		t.Fatal("ApplyConfig accepted an unknown setting")
+	}
+	if err := ApplyConfig(fs, map[string]string{"auto_mine": "maybe"}); err == nil {
+		t.Fatal("ApplyConfig accepted a malformed value")
+	}
+	t.Setenv(CONFIG_ENV_PREFIX+"AUTO_MINE", "often")
+	if err := ApplyConfig(fs, nil); err == nil {
+		t.Fatal("ApplyConfig accepted a malformed environment variable")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/node"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+const (
+	DASHBOARD_POOL_ROWS = 8
+	// ANSI escape sequences: move the cursor home and clear the screen.
+	ANSI_CLEAR_SCREEN = "\033[H\033[2J"
+	ANSI_BOLD         = "\033[1m"
+	ANSI_RESET        = "\033[0m"
+)
+
+// dashboardNode is the last state fetched from one node; hashes and fetched let the next poll compute the hashrate.
+type dashboardNode struct {
+	gateway  string
+	status   node.NodeStatus
+	pool     []*transaction.Transaction
+	hashrate float64
+	fetched  time.Time
+	err      error
+}
+
+// poll refreshes the node's status and pending transactions from its HTTP API.
+func (n *dashboardNode) poll(client *http.Client) {
+	var status node.NodeStatus
+	if n.err = getJSON(client, n.gateway+"/status", &status); n.err != nil {
+		return
+	}
+	var pool struct {
+		Transactions []*transaction.Transaction `json:"transactions"`
+	}
+	if n.err = getJSON(client, n.gateway+"/transactions", &pool); n.err != nil {
+		return
+	}
+	now := time.Now()
+	if !n.fetched.IsZero() && status.Hashes >= n.status.Hashes {
+		n.hashrate = float64(status.Hashes-n.status.Hashes) / now.Sub(n.fetched).Seconds()
+	}
+	n.status, n.pool, n.fetched = status, pool.Transactions, now
+}
+
+// getJSON decodes the JSON body of GET url into v.
+func getJSON(client *http.Client, url string, v any) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// renderDashboard draws one frame: a row per node, then each node's pending transactions.
+func renderDashboard(out io.Writer, nodes []*dashboardNode, refresh time.Duration) {
+	var b strings.Builder
+	b.WriteString(ANSI_CLEAR_SCREEN)
+	fmt.Fprintf(&b, "%sBlockchain dashboard%s  %s  (refresh %s, Ctrl-C to quit)\n\n", ANSI_BOLD, ANSI_RESET,
+		time.Now().Format(time.TimeOnly), refresh)
+	fmt.Fprintf(&b, "%-24s %7s  %-16s  %5s  %5s  %-6s  %-9s  %s\n", "NODE", "HEIGHT", "TIP", "POOL", "PEERS", "MINING", "CONSENSUS", "HASHRATE")
+	for _, n := range nodes {
+		if n.err != nil {
+			fmt.Fprintf(&b, "%-24s unreachable: %v\n", n.gateway, n.err)
+			continue
+		}
+		mining := "no"
+		if n.status.Mining {
+			mining = "yes"
+		}
+		fmt.Fprintf(&b, "%-24s %7d  %-16.16s  %5d  %5d  %-6s  %-9s  %s\n", n.gateway, n.status.Height, n.status.TipHash,
+			n.status.TransactionPoolSize, len(n.status.Neighbors), mining, n.status.Consensus, formatHashrate(n.hashrate))
+	}
+	for _, n := range nodes {
+		if n.err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%sMempool of %s%s (%d)\n", ANSI_BOLD, n.gateway, ANSI_RESET, len(n.pool))
+		for i, t := range n.pool {
+			if i == DASHBOARD_POOL_ROWS {
+				fmt.Fprintf(&b, "  ... %d more\n", len(n.pool)-i)
+				break
+			}
+			fmt.Fprintf(&b, "  %.8s  %s -> %s  %s  fee %s\n", t.ID(), t.SenderBlockchainAddress(), t.RecipientBlockchainAddress(), t.Value(), t.Fee())
+		}
+	}
+	io.WriteString(out, b.String())
+}
+
+// formatHashrate scales hashes per second to H/s, kH/s, or MH/s.
+func formatHashrate(h float64) string {
+	switch {
+	case h >= 1e6:
+		return fmt.Sprintf("%.1f MH/s", h/1e6)
+	case h >= 1e3:
+		return fmt.Sprintf("%.1f kH/s", h/1e3)
+	default:
+		return fmt.Sprintf("%.0f H/s", h)
+	}
+}
+
+// dashboardCommand redraws the state of one or more nodes in the terminal until interrupted.
+func dashboardCommand(args []string) {
+	fs := flag.NewFlagSet("dashboard", flag.ExitOnError)
+	gateways := fs.String("gateway", "http://127.0.0.1:5000", "comma-separated blockchain node URLs to watch")
+	refresh := fs.Duration("refresh", time.Second, "time between redraws")
+	fs.Parse(args)
+
+	var nodes []*dashboardNode
+	for _, g := range strings.Split(*gateways, ",") {
+		nodes = append(nodes, &dashboardNode{gateway: strings.TrimSuffix(strings.TrimSpace(g), "/")})
+	}
+	client := &http.Client{Timeout: node.NEIGHBOR_REQUEST_TIMEOUT}
+	ticker := time.NewTicker(*refresh)
+	defer ticker.Stop()
+	for {
+		for _, n := range nodes {
+			n.poll(client)
+		}
+		renderDashboard(os.Stdout, nodes, *refresh)
+		<-ticker.C
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/node"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+func TestDashboardShowsEachNodesStateAndHashrate(t *testing.T) {
+	sender, recipient := newWallet(t), newWallet(t)
+	var pool []*transaction.Transaction
+	for i := range DASHBOARD_POOL_ROWS + 2 {
+		tx, err := sender.NewTransaction(recipient.BlockchainAddress(), transaction.COIN, transaction.COIN/100, uint64(i), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool = append(pool, tx)
+	}
+	status := node.NodeStatus{Height: 7, TipHash: "00abcdef0123456789", TransactionPoolSize: len(pool),
+		Neighbors: []string{"10.0.0.2:5000", "10.0.0.3:5000"}, Mining: true, Consensus: "pow"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(status)
+		status.Hashes += 5000
+	})
+	mux.HandleFunc("/transactions", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"transactions": pool})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	up := &dashboardNode{gateway: srv.URL}
+	down := &dashboardNode{gateway: "http://127.0.0.1:1"}
+	client := &http.Client{Timeout: time.Second}
+	up.poll(client)
+	if up.err != nil {
+		t.Fatal(up.err)
+	}
+	up.fetched = up.fetched.Add(-time.Second)
+	up.poll(client)
+	down.poll(client)
+	if up.hashrate <= 0 || up.hashrate > 5000 {
+		t.Fatalf("hashrate %.0f H/s, want at most 5000 for 5000 hashes over more than a second", up.hashrate)
+	}
+
+	var out strings.Builder
+	renderDashboard(&out, []*dashboardNode{up, down}, time.Second)
+	frame := out.String()
+	if !strings.HasPrefix(frame, ANSI_CLEAR_SCREEN) {
+		t.Fatal("the frame does not start by clearing the screen")
+	}
+	for _, want := range []string{
+		srv.URL, "      7  00abcdef01234567 ", "    10      2  yes     pow", "kH/s",
+		"http://127.0.0.1:1       unreachable: ",
+		"(10)\n", pool[0].ID()[:8], "  ... 2 more\n",
+	} {
+		if !strings.Contains(frame, want) {
+			t.Errorf("frame lacks %q:\n%s", want, frame)
+		}
+	}
+	if strings.Contains(frame, pool[DASHBOARD_POOL_ROWS].ID()[:8]) {
+		t.Errorf("frame lists more than %d pending transactions", DASHBOARD_POOL_ROWS)
+	}
+
+	for h, want := range map[float64]string{12: "12 H/s", 2500: "2.5 kH/s", 3.2e6: "3.2 MH/s"} {
+		if got := formatHashrate(h); got != want {
+			t.Errorf("formatHashrate(%g) = %q, want %q", h, got, want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/node"
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+// runDemo walks through wallets, signed transactions, mining, and balances on an in-memory blockchain.
+func runDemo() {
+	// Wallets demo
+	walletMiner, err := wallet.NewWallet()
+	if err != nil {
+		log.Fatalf("action=new_wallet, status=fail, err=%v", err)
+	}
+	walletA, err := wallet.NewWallet()
+	if err != nil {
+		log.Fatalf("action=new_wallet, status=fail, err=%v", err)
+	}
+	walletB, err := wallet.NewWallet()
+	if err != nil {
+		log.Fatalf("action=new_wallet, status=fail, err=%v", err)
+	}
+
+	// Mining demo
+	bc := node.NewBlockchain(walletMiner.BlockchainAddress(), 0, nil, nil, nil, nil)
+	bc.Print()
+
+	// A has no coins yet, so spending is rejected until the miner (funded by the genesis block) pays A.
+	overdraft, err := walletA.NewTransaction(walletB.BlockchainAddress(), 1*transaction.COIN, 0, bc.NextNonce(walletA.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		log.Fatalf("action=sign_transaction, status=fail, err=%v", err)
+	}
+	if err := bc.AddTransaction(overdraft); err != nil {
+		fmt.Printf("overdraft transaction rejected: %v\n", err)
+	}
+	funding, err := walletMiner.NewTransaction(walletA.BlockchainAddress(), transaction.COIN/2, transaction.COIN/100, bc.NextNonce(walletMiner.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		log.Fatalf("action=sign_transaction, status=fail, err=%v", err)
+	}
+	if err := bc.AddTransaction(funding); err != nil {
+		log.Fatalf("action=add_transaction, status=fail, err=%v", err)
+	}
+	bc.Mining(context.Background())
+
+	t, err := walletA.NewTransaction(walletB.BlockchainAddress(), transaction.COIN/5, transaction.COIN/10, bc.NextNonce(walletA.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		log.Fatalf("action=sign_transaction, status=fail, err=%v", err)
+	}
+	if err := bc.AddTransaction(t); err != nil {
+		log.Fatalf("action=add_transaction, status=fail, err=%v", err)
+	}
+	bc.Mining(context.Background())
+	bc.Print()
+
+	// A transaction signed by A but claiming to come from B is rejected.
+	forged := transaction.NewTransaction(walletB.BlockchainAddress(), walletA.BlockchainAddress(), 2*transaction.COIN, 0)
+	if err := forged.Sign(walletA.PrivateKey()); err != nil {
+		log.Fatalf("action=sign_transaction, status=fail, err=%v", err)
+	}
+	if err := bc.AddTransaction(forged); err != nil {
+		fmt.Printf("forged transaction rejected: %v\n", err)
+	}
+
+	// Replaying the already-mined A -> B transaction is rejected by its ID.
+	if err := bc.AddTransaction(t); err != nil {
+		fmt.Printf("replayed transaction %s rejected: %v\n", t.ID(), err)
+	}
+
+	fmt.Printf("miner %s\n", bc.CalculateTotalAmount(walletMiner.BlockchainAddress()))
+	fmt.Printf("A %s\n", bc.CalculateTotalAmount(walletA.BlockchainAddress()))
+	fmt.Printf("B %s\n", bc.CalculateTotalAmount(walletB.BlockchainAddress()))
+
+	// Merkle proof demo: prove the user transaction is in block 2 using only sibling hashes.
+	minedBlock := bc.Chain()[2]
+	proof, ok := minedBlock.MerkleProof(t.Hash())
+	fmt.Printf("merkle proof found=%t verified=%t\n", ok, block.VerifyMerkleProof(t.Hash(), proof, minedBlock.MerkleRoot()))
+
+	// Export/import demo
+	exportPath := filepath.Join(os.TempDir(), "blockchain_demo.json")
+	if err := bc.Export(exportPath); err != nil {
+		log.Fatalf("action=export, status=fail, err=%v", err)
+	}
+	imported, err := node.ImportBlockchain(exportPath)
+	if err != nil {
+		log.Fatalf("action=import, status=fail, err=%v", err)
+	}
+	fmt.Printf("imported %d blocks from %s\n", len(imported.Chain()), exportPath)
+
+	// Tampering demo: rewriting a mined transaction no longer matches the block's Merkle root.
+	fmt.Printf("chain valid=%t\n", bc.ValidChain(bc.Chain()))
+	bc.Chain()[1].Transactions()[0].SetValue(100 * transaction.COIN)
+	fmt.Printf("chain valid after tampering=%t\n", bc.ValidChain(bc.Chain()))
+
+	// Issuance demo: the reward halves every REWARD_HALVING_INTERVAL blocks and total issuance converges.
+	var issued transaction.Amount
+	for height := 0; height < 64*node.REWARD_HALVING_INTERVAL; height++ {
+		issued += node.BlockReward(height, node.REWARD_HALVING_INTERVAL)
+		if height%node.REWARD_HALVING_INTERVAL == 0 && height <= 3*node.REWARD_HALVING_INTERVAL {
+			fmt.Printf("height %d reward %s\n", height, node.BlockReward(height, node.REWARD_HALVING_INTERVAL))
+		}
+	}
+	fmt.Printf("total issuance converges to %s\n", issued)
+	fmt.Printf("issued supply=%s of max %s\n", bc.IssuedSupply(), node.MAX_SUPPLY)
+
+	// Once the supply cap is reached, mined blocks pay only the fees they collect.
+	capped := node.NewBlockchain(walletMiner.BlockchainAddress(), 0, nil, nil, nil, nil)
+	capped.SetMaxSupply(node.MINING_REWARD) // the genesis block already issued this much
+	payment, err := walletMiner.NewTransaction(walletA.BlockchainAddress(), transaction.COIN/10, transaction.COIN/100, capped.NextNonce(walletMiner.BlockchainAddress()), capped.ChainID())
+	if err != nil {
+		log.Fatalf("action=sign_transaction, status=fail, err=%v", err)
+	}
+	if err := capped.AddTransaction(payment); err != nil {
+		log.Fatalf("action=add_transaction, status=fail, err=%v", err)
+	}
+	capped.Mining(context.Background())
+	fmt.Printf("capped chain issued=%s, miner balance=%s, valid=%t\n", capped.IssuedSupply(),
+		capped.CalculateTotalAmount(walletMiner.BlockchainAddress()), capped.ValidChain(capped.Chain()))
+
+	// Genesis demo: nodes sharing a GenesisConfig build the same first block, including its premine.
+	genesis := &node.GenesisConfig{
+		ChainID:     "demo-net",
+		Timestamp:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Allocations: []node.GenesisAllocation{{Address: walletA.BlockchainAddress(), Amount: 10 * transaction.COIN}},
+	}
+	node1 := node.NewBlockchain(walletMiner.BlockchainAddress(), 0, nil, nil, nil, genesis)
+	node2 := node.NewBlockchain(walletB.BlockchainAddress(), 0, nil, nil, nil, genesis)
+	fmt.Printf("genesis %x shared=%t, A premine=%s\n", node1.GenesisHash(), node1.GenesisHash() == node2.GenesisHash(),
+		node2.CalculateTotalAmount(walletA.BlockchainAddress()))
+
+	// A transaction signed for demo-net cannot be replayed on a network with another chain ID.
+	testnet := node.NewBlockchain(walletMiner.BlockchainAddress(), 0, nil, nil, nil, &node.GenesisConfig{ChainID: "demo-testnet", Timestamp: genesis.Timestamp, Allocations: genesis.Allocations})
+	signed, err := walletA.NewTransaction(walletB.BlockchainAddress(), transaction.COIN, 0, 0, node1.ChainID())
+	if err != nil {
+		log.Fatalf("action=sign_transaction, status=fail, err=%v", err)
+	}
+	fmt.Printf("demo-net accepts=%t, demo-testnet rejects: %v\n", node1.AddTransaction(signed) == nil, testnet.AddTransaction(signed))
+
+	// Proof-of-authority demo: A and B take turns signing blocks, so there is no nonce search.
+	poa, err := block.NewProofOfAuthority([]string{walletA.BlockchainAddress(), walletB.BlockchainAddress()}, walletA.PrivateKey(), nil)
+	if err != nil {
+		log.Fatalf("action=new_poa, status=fail, err=%v", err)
+	}
+	poaChain := node.NewBlockchain(walletA.BlockchainAddress(), 0, nil, nil, poa, nil)
+	for i := 0; i < 2; i++ {
+		payment, err := walletA.NewTransaction(walletB.BlockchainAddress(), transaction.COIN/10, 0, poaChain.NextNonce(walletA.BlockchainAddress()), poaChain.ChainID())
+		if err != nil {
+			log.Fatalf("action=sign_transaction, status=fail, err=%v", err)
+		}
+		if err := poaChain.AddTransaction(payment); err != nil {
+			log.Fatalf("action=add_transaction, status=fail, err=%v", err)
+		}
+		// Height 1 is A's turn and gets sealed; height 2 is B's turn, so A cannot seal it.
+		fmt.Printf("poa height %d in turn %s sealed=%t\n", len(poaChain.Chain()), poa.InTurn(len(poaChain.Chain())), poaChain.Mining(context.Background()))
+	}
+	fmt.Printf("poa chain valid=%t\n", poaChain.ValidChain(poaChain.Chain()))
+
+	// Address demo
+	fmt.Printf("%s valid=%t\n", walletA.BlockchainAddress(), transaction.ValidateAddress(walletA.BlockchainAddress()))
+	fmt.Printf("%s valid=%t\n", transaction.MINING_SENDER, transaction.ValidateAddress(transaction.MINING_SENDER))
+}
@@ -0,0 +1,300 @@
+// Command blockchain runs a node or a wallet server, or a subcommand that talks to a node.
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/internal/httputil"
+	"github.com/dikako/how-blockchain-works/internal/trace"
+	"github.com/dikako/how-blockchain-works/node"
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+// init configures the logger prefix for the application.
+func init() {
+	log.SetPrefix("Blockchain: ")
+}
+
+// main is the entry point of the application: it runs a subcommand such as `node start` or `wallet new`,
+// or, when the first argument is a flag, the original flag-only interface (-mode, -demo).
+func main() {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		runCommand(os.Args[1:])
+		return
+	}
+	runServer(os.Args[1:])
+}
+
+// runServer parses the node and wallet server flags from args and starts the selected process,
+// or runs the scripted demo with -demo.
+func runServer(args []string) {
+	fs := flag.NewFlagSet("blockchain", flag.ExitOnError)
+	configPath := fs.String("config", "", "TOML file of flag settings; BLOCKCHAIN_<FLAG> environment variables override it")
+	mode := fs.String("mode", "node", "process to run: node or wallet")
+	port := fs.Uint("port", 0, "TCP port number (default 5000 for node, 8080 for wallet)")
+	gateway := fs.String("gateway", "http://127.0.0.1:5000", "blockchain node URL used by the wallet server")
+	neighborIPStart := fs.Uint("neighbor_ip_start", node.NEIGHBOR_IP_RANGE_START, "first offset added to the host's last IPv4 octet when scanning for neighbors")
+	neighborIPEnd := fs.Uint("neighbor_ip_end", node.NEIGHBOR_IP_RANGE_END, "last offset added to the host's last IPv4 octet when scanning for neighbors")
+	neighborPortStart := fs.Uint("neighbor_port_start", node.BLOCKCHAIN_PORT_RANGE_START, "first port scanned for neighbors")
+	peers := fs.String("peers", "", "comma-separated host:port addresses of nodes to connect to besides the scanned range")
+	dnsSeeds := fs.String("dns_seeds", "", "comma-separated DNS names (name or name:port, default port 5000) whose A/AAAA records list nodes to connect to")
+	transport := fs.String("transport", node.TRANSPORT_TCP, "how nodes connect to each other: tcp, or a transport registered with RegisterTransport")
+	wireFormat := fs.String("wire_format", node.WIRE_FORMAT_CBOR, "format of messages to neighbors: cbor (binary), or json for networks with nodes that only read JSON")
+	fastSync := fs.String("fast_sync", "", "host:port of a node to bootstrap a fresh node from, starting at the -snapshot_checkpoint snapshot")
+	snapshotCheckpoint := fs.String("snapshot_checkpoint", "", "height:hash of the trusted snapshot for -fast_sync, as GET /snapshot?height=... reports it")
+	checkpoints := fs.String("checkpoints", "", "comma-separated height:hash block checkpoints; chains with another block at a checkpoint height are refused")
+	dht := fs.Bool("dht", false, "find peers through the Kademlia-style DHT over node IDs")
+	advertiseAddress := fs.String("advertise_address", "", "host:port other nodes reach this node at, which its DHT node ID derives from (default this host's address and -port)")
+	neighborPortEnd := fs.Uint("neighbor_port_end", node.BLOCKCHAIN_PORT_RANGE_END, "last port scanned for neighbors")
+	dataDir := fs.String("data_dir", "data", "directory where the node persists its chain (empty keeps it in memory)")
+	blockStore := fs.String("block_store", node.BLOCK_STORE_BOLT, "where the chain is kept under -data_dir: bolt (a Bolt database), file, kv (a key-value log), or memory (a store starts empty, so switching resyncs the chain; bolt imports the block file)")
+	storageCompression := fs.String("storage_compression", node.STORAGE_COMPRESSION_SNAPPY, "how stored blocks are compressed: snappy or none (a block file in the other format is converted on start)")
+	miningInterval := fs.Duration("mining_interval", node.MINING_TIMER_SEC*time.Second, "interval between blocks when background mining is running")
+	difficulty := fs.Float64("difficulty", 0, "leading hex zeros a block hash needs, fractions allowed: 4.5 is between 4 and 5 (0 for the genesis config's, or the -pow algorithm's default; -consensus pow)")
+	powName := fs.String("pow", "", "proof-of-work hash function: sha256, sha3, blake2b, or the memory-hard scrypt (empty for the genesis config's, or -hasher; -consensus pow)")
+	miningWorkers := fs.Int("mining_workers", 0, "goroutines searching for a nonce in parallel (0 for GOMAXPROCS)")
+	autoMine := fs.Bool("auto_mine", false, "start background mining as soon as the node starts")
+	halvingInterval := fs.Int("halving_interval", node.REWARD_HALVING_INTERVAL, "blocks between halvings of the mining reward (0 never halves)")
+	maxSupply := fs.String("max_supply", node.MAX_SUPPLY.String(), "coins mining may ever issue; past it blocks pay only fees (0 for no cap)")
+	signatureCacheSize := fs.Int("signature_cache_size", node.SIGNATURE_CACHE_SIZE, "verified transactions remembered so their signatures are not verified again (0 to verify every time)")
+	verifyWorkers := fs.Int("verify_workers", 0, "goroutines verifying the signatures of a block's transactions in parallel (0 for GOMAXPROCS)")
+	maxPoolSize := fs.Int("max_pool_size", node.MAX_TRANSACTION_POOL_SIZE, "maximum number of pending transactions (0 for unbounded)")
+	poolTTL := fs.Duration("pool_ttl", node.TRANSACTION_POOL_TTL, "how long a transaction may wait in the pool before it is dropped (0 keeps it until mined)")
+	maxBlockTxs := fs.Int("max_block_transactions", node.MAX_BLOCK_TRANSACTIONS, "maximum transactions per block, coinbase included (0 for no limit)")
+	stratumPort := fs.Uint("stratum_port", 0, "TCP port serving mining jobs to stratum workers, e.g. 3333 (0 for none; -consensus pow)")
+	stratumShareDifficulty := fs.Float64("stratum_share_difficulty", 0, "leading hex zeros a stratum share needs, fractions allowed (0 for one less than -difficulty)")
+	stratumPoolFee := fs.Int("stratum_pool_fee", node.MINING_POOL_FEE_PERCENT, "percent of each stratum block's reward the node keeps before paying its workers")
+	stratumMinPayout := fs.String("stratum_min_payout", node.MINING_POOL_MIN_PAYOUT.String(), "balance at which the stratum pool pays a worker's address; keep it above the block reward")
+	maxBlockSize := fs.Int("max_block_size", node.MAX_BLOCK_SIZE, "maximum bytes of JSON-serialized transactions per block (0 for no limit)")
+	demo := fs.Bool("demo", false, "run the scripted in-memory demo instead of a server")
+	genesisPath := fs.String("genesis", "", "genesis config file shared by every node of the network (empty creates a node-local genesis)")
+	hasherName := fs.String("hasher", block.HASHER_SHA256, "block hash function: sha256, sha3, or blake2b")
+	consensusName := fs.String("consensus", "pow", "consensus engine: pow or poa")
+	authorities := fs.String("authorities", "", "comma-separated authority addresses in turn order (-consensus poa)")
+	minerPrivateKey := fs.String("miner_private_key", "", "hex private key of the miner wallet, which signs blocks under -consensus poa (random if empty)")
+	tlsCert := fs.String("tls_cert", "", "PEM certificate file; with -tls_key the server speaks HTTPS")
+	tlsKey := fs.String("tls_key", "", "PEM private key file for -tls_cert")
+	tlsSelfSigned := fs.Bool("tls_self_signed", false, "serve HTTPS with a self-signed certificate generated at startup (demos only)")
+	tlsInsecure := fs.Bool("tls_insecure_skip_verify", false, "accept any certificate from neighbors and the gateway, as self-signed ones require (demos only)")
+	apiKeys := fs.String("api_keys", "", "comma-separated API keys accepted on mutating endpoints (empty with no -jwt_secret leaves them public)")
+	jwtSecret := fs.String("jwt_secret", "", "HMAC secret; HS256 JWTs signed with it are accepted on mutating endpoints")
+	authToken := fs.String("auth_token", "", "bearer token sent to neighbors, or to the gateway by the wallet server (default the first -api_keys entry)")
+	rateLimit := fs.Float64("rate_limit", node.RATE_LIMIT_PER_SEC, "transaction submissions and mining requests allowed per second per client IP (0 for no limit)")
+	rateBurst := fs.Int("rate_burst", node.RATE_LIMIT_BURST, "requests a client IP may make at once before -rate_limit applies")
+	corsOrigins := fs.String("cors_origins", "", "comma-separated origins whose browser pages may call the node API, or * for any (empty for none)")
+	traceExporter := fs.String("trace_exporter", trace.TRACE_EXPORTER_NONE, "where tracing spans go: none, log, or otlp (OTLP/HTTP JSON)")
+	traceEndpoint := fs.String("trace_endpoint", trace.TRACE_DEFAULT_OTLP_ENDPOINT, "OTLP/HTTP traces URL for -trace_exporter otlp")
+	traceService := fs.String("trace_service", "", "service.name of exported spans (default blockchain-node-<port>)")
+	logLevel := fs.String("log_level", "info", "minimum log level: debug, info, warn, or error (debug traces every proof-of-work guess)")
+	fs.Parse(args)
+
+	var config map[string]string
+	if *configPath != "" {
+		var err error
+		if config, err = LoadConfigFile(*configPath); err != nil {
+			log.Fatalf("action=main, status=fail, err=invalid -config: %v", err)
+		}
+	}
+	if err := ApplyConfig(fs, config); err != nil {
+		log.Fatalf("action=main, status=fail, err=%v", err)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		log.Fatalf("action=main, status=fail, err=invalid -log_level %q", *logLevel)
+	}
+	slog.SetLogLoggerLevel(level)
+
+	if *demo {
+		runDemo()
+		return
+	}
+
+	cert, err := httputil.LoadTLSCertificate(*tlsCert, *tlsKey, *tlsSelfSigned)
+	if err != nil {
+		log.Fatalf("action=main, status=fail, err=invalid TLS settings: %v", err)
+	}
+	if *authToken == "" && *apiKeys != "" {
+		*authToken = strings.TrimSpace(strings.Split(*apiKeys, ",")[0])
+	}
+
+	switch *mode {
+	case "node":
+		if *port == 0 {
+			*port = 5000
+		}
+		neighborRange := node.NeighborRange{
+			StartIP:   uint8(*neighborIPStart),
+			EndIP:     uint8(*neighborIPEnd),
+			StartPort: uint16(*neighborPortStart),
+			EndPort:   uint16(*neighborPortEnd),
+		}
+		hasher, err := block.HasherByName(*hasherName)
+		if err != nil {
+			log.Fatalf("action=main, status=fail, err=%v", err)
+		}
+		bcs := node.NewBlockchainServer(uint16(*port), neighborRange, *dataDir, *miningInterval, hasher)
+		bcs.SetBlockStore(*blockStore)
+		bcs.SetStorageCompression(*storageCompression)
+		bcs.SetTLS(cert)
+		bcs.SetAuth(node.NewAuthenticator(strings.Split(*apiKeys, ","), *jwtSecret))
+		bcs.SetRateLimiter(node.NewRateLimiter(*rateLimit, *rateBurst))
+		bcs.SetCORS(node.NewCORS(strings.Split(*corsOrigins, ",")))
+		powHasher, powDifficulty := hasher, *difficulty
+		if *powName != "" {
+			if powHasher, err = block.ProofOfWorkHasherByName(*powName); err != nil {
+				log.Fatalf("action=main, status=fail, err=invalid -pow: %v", err)
+			}
+		}
+		if *genesisPath != "" {
+			genesis, err := node.LoadGenesisConfig(*genesisPath)
+			if err != nil {
+				log.Fatalf("action=main, status=fail, err=invalid -genesis: %v", err)
+			}
+			bcs.SetGenesis(genesis)
+			// The network's proof-of-work settings win over the node's defaults, but not over a conflicting flag.
+			if genesis.ProofOfWork != "" {
+				if *powName != "" && *powName != genesis.ProofOfWork {
+					log.Fatalf("action=main, status=fail, err=-pow %s conflicts with the network's proof of work %s", *powName, genesis.ProofOfWork)
+				}
+				powHasher, _ = block.ProofOfWorkHasherByName(genesis.ProofOfWork)
+			}
+			if powDifficulty == 0 {
+				powDifficulty = genesis.Difficulty
+			}
+		}
+		var minersWallet *wallet.Wallet
+		if *minerPrivateKey != "" {
+			privateKey, err := transaction.PrivateKeyFromString(*minerPrivateKey)
+			if err != nil {
+				log.Fatalf("action=main, status=fail, err=invalid -miner_private_key: %v", err)
+			}
+			minersWallet = wallet.WalletFromPrivateKey(privateKey)
+			bcs.SetMinerWallet(minersWallet)
+		}
+		switch *consensusName {
+		case "pow":
+			bcs.SetConsensus(block.NewProofOfWork(powDifficulty, powHasher))
+		case "poa":
+			var signer *ecdsa.PrivateKey
+			if minersWallet != nil {
+				signer = minersWallet.PrivateKey()
+			}
+			poa, err := block.NewProofOfAuthority(strings.Split(*authorities, ","), signer, hasher)
+			if err != nil {
+				log.Fatalf("action=main, status=fail, err=%v", err)
+			}
+			bcs.SetConsensus(poa)
+		default:
+			log.Fatalf("action=main, status=fail, err=unknown consensus %q", *consensusName)
+		}
+		bcs.GetBlockchain().SetMaxTransactionPoolSize(*maxPoolSize)
+		bcs.GetBlockchain().SetSignatureCacheSize(*signatureCacheSize)
+		bcs.GetBlockchain().SetVerifyWorkers(*verifyWorkers)
+		bcs.GetBlockchain().SetTransactionPoolTTL(*poolTTL)
+		minPayout, err := transaction.ParseAmount(*stratumMinPayout)
+		if err != nil {
+			log.Fatalf("action=main, status=fail, err=invalid -stratum_min_payout: %v", err)
+		}
+		bcs.SetStratum(uint16(*stratumPort), *stratumShareDifficulty, *stratumPoolFee, minPayout)
+		bcs.GetBlockchain().SetMaxBlockTransactions(*maxBlockTxs)
+		bcs.GetBlockchain().SetMaxBlockSize(*maxBlockSize)
+		bcs.GetBlockchain().SetMiningWorkers(*miningWorkers)
+		bcs.GetBlockchain().SetHalvingInterval(*halvingInterval)
+		supply, err := transaction.ParseAmount(*maxSupply)
+		if err != nil {
+			log.Fatalf("action=main, status=fail, err=invalid -max_supply: %v", err)
+		}
+		bcs.GetBlockchain().SetMaxSupply(supply)
+		bcs.GetBlockchain().SetNeighborTLS(cert != nil, *tlsInsecure)
+		bcs.GetBlockchain().SetNeighborToken(*authToken)
+		bootstrapPeers, err := node.ParsePeers(*peers)
+		if err != nil {
+			log.Fatalf("action=main, status=fail, err=invalid -peers: %v", err)
+		}
+		bcs.GetBlockchain().SetBootstrapPeers(bootstrapPeers)
+		var seeds []string
+		for _, s := range strings.Split(*dnsSeeds, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				seeds = append(seeds, s)
+			}
+		}
+		bcs.GetBlockchain().SetDNSSeeds(seeds)
+		t, err := node.NewTransportByName(*transport)
+		if err != nil {
+			log.Fatalf("action=main, status=fail, err=%v", err)
+		}
+		bcs.GetBlockchain().SetTransport(t)
+		if err := bcs.GetBlockchain().SetWireFormat(*wireFormat); err != nil {
+			log.Fatalf("action=main, status=fail, err=invalid -wire_format: %v", err)
+		}
+		pinned, err := node.ParseCheckpoints(*checkpoints)
+		if err != nil {
+			log.Fatalf("action=main, status=fail, err=invalid -checkpoints: %v", err)
+		}
+		bcs.GetBlockchain().SetCheckpoints(pinned)
+		if *fastSync != "" {
+			height, hash, err := node.ParseSnapshotCheckpoint(*snapshotCheckpoint)
+			if err != nil {
+				log.Fatalf("action=main, status=fail, err=-fast_sync needs -snapshot_checkpoint: %v", err)
+			}
+			if err := bcs.GetBlockchain().FastSync(context.Background(), *fastSync, height, hash); err != nil {
+				log.Printf("action=fast_sync, status=fail, err=%v", err)
+			}
+		}
+		if *dht {
+			if *advertiseAddress == "" {
+				*advertiseAddress = fmt.Sprintf("%s:%d", httputil.GetHost(), *port)
+			}
+			bcs.GetBlockchain().EnableDHT(*advertiseAddress)
+		}
+		if *autoMine {
+			bcs.GetBlockchain().StartMining(*miningInterval)
+		}
+		// The first SIGINT or SIGTERM shuts the node down gracefully; stop() restores the default handling
+		// so that a second one kills it at once.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		context.AfterFunc(ctx, stop)
+		if *traceService == "" {
+			*traceService = fmt.Sprintf("blockchain-node-%d", *port)
+		}
+		tracer, err := trace.NewTracerByName(*traceExporter, *traceEndpoint, *traceService)
+		if err != nil {
+			log.Fatalf("action=main, status=fail, err=%v", err)
+		}
+		trace.SetTracer(tracer)
+		bcs.Run(ctx)
+		trace.SetTracer(nil)
+		flushCtx, cancel := context.WithTimeout(context.Background(), node.SHUTDOWN_TIMEOUT)
+		defer cancel()
+		if err := tracer.Shutdown(flushCtx); err != nil {
+			log.Printf("action=trace_shutdown, status=fail, err=%v", err)
+		}
+	case "wallet":
+		if *port == 0 {
+			*port = 8080
+		}
+		log.SetPrefix("Wallet Server: ")
+		ws := wallet.NewWalletServer(uint16(*port), *gateway)
+		ws.SetTLS(cert)
+		ws.SetGatewayToken(*authToken)
+		if *tlsInsecure {
+			ws.SetGatewayClient(httputil.NewHTTPClient(node.NEIGHBOR_REQUEST_TIMEOUT, true))
+		}
+		ws.Run()
+	default:
+		log.Fatalf("action=main, status=fail, err=unknown mode %q", *mode)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/node"
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+const REPL_HELP = `commands:
+  wallet <name>                 create (or show) the wallet called name
+  tx <from> <to> <amount> [fee] sign and submit a transaction between named wallets
+  mine                          mine the pending transactions into a block
+  balance <name> [height]       confirmed balance of a wallet, after the block at height if given
+  pool                          pending transactions
+  print                         print the whole chain
+  wallets                       list the named wallets
+  help                          show this help
+  quit                          leave the shell
+Wallets are created on first use; "miner" receives the genesis coins and every mining reward.`
+
+// REPL is an interactive shell over an in-memory blockchain whose wallets are referred to by name,
+// so a chain can be built one transaction and one block at a time.
+type REPL struct {
+	blockchain *node.Blockchain
+	wallets    map[string]*wallet.Wallet
+	out        io.Writer
+}
+
+// NewREPL creates a shell with a fresh chain mined by the wallet named "miner" under consensus
+// (nil selects ProofOfWork).
+func NewREPL(out io.Writer, consensus block.Consensus) (*REPL, error) {
+	miner, err := wallet.NewWallet()
+	if err != nil {
+		return nil, err
+	}
+	r := &REPL{wallets: map[string]*wallet.Wallet{"miner": miner}, out: out}
+	r.blockchain = node.NewBlockchain(miner.BlockchainAddress(), 0, nil, nil, consensus, nil)
+	return r, nil
+}
+
+// Run reads commands from in until it ends or the user types quit.
+func (r *REPL) Run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	fmt.Fprint(r.out, "> ")
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 {
+			if fields[0] == "quit" || fields[0] == "exit" {
+				return
+			}
+			if err := r.Execute(fields); err != nil {
+				fmt.Fprintf(r.out, "error: %v\n", err)
+			}
+		}
+		fmt.Fprint(r.out, "> ")
+	}
+}
+
+// Execute runs one command given as its words.
+func (r *REPL) Execute(fields []string) error {
+	switch cmd, args := fields[0], fields[1:]; {
+	case cmd == "help":
+		fmt.Fprintln(r.out, REPL_HELP)
+	case cmd == "wallet" && len(args) == 1:
+		w, err := r.wallet(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(r.out, "%s: address %s\n", args[0], w.BlockchainAddress())
+	case cmd == "wallets" && len(args) == 0:
+		names := make([]string, 0, len(r.wallets))
+		for name := range r.wallets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(r.out, "%-10s %s\n", name, r.wallets[name].BlockchainAddress())
+		}
+	case cmd == "tx" && (len(args) == 3 || len(args) == 4):
+		return r.transaction(args)
+	case cmd == "mine" && len(args) == 0:
+		if len(r.blockchain.TransactionPool()) == 0 {
+			return node.ErrEmptyTransactionPool
+		}
+		if !r.blockchain.Mining(context.Background()) {
+			return errors.New("mining failed; see the log")
+		}
+		chain := r.blockchain.Chain()
+		fmt.Fprintf(r.out, "mined block %d with %d transactions\n", len(chain)-1, len(chain[len(chain)-1].Transactions()))
+	case cmd == "balance" && len(args) == 1:
+		w, err := r.wallet(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(r.out, "%s: %s\n", args[0], r.blockchain.CalculateTotalAmount(w.BlockchainAddress()))
+	case cmd == "balance" && len(args) == 2:
+		w, err := r.wallet(args[0])
+		if err != nil {
+			return err
+		}
+		height, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid height %q", args[1])
+		}
+		amount, err := r.blockchain.BalanceAt(w.BlockchainAddress(), height)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(r.out, "%s at height %d: %s\n", args[0], height, amount)
+	case cmd == "pool" && len(args) == 0:
+		pool := r.blockchain.TransactionPool()
+		for _, t := range pool {
+			fmt.Fprintf(r.out, "%s %s -> %s %s (fee %s)\n", t.ID()[:8], r.name(t.SenderBlockchainAddress()),
+				r.name(t.RecipientBlockchainAddress()), t.Value(), t.Fee())
+		}
+		fmt.Fprintf(r.out, "%d pending\n", len(pool))
+	case cmd == "print" && len(args) == 0:
+		r.blockchain.Print()
+	default:
+		return fmt.Errorf("unknown command %q; type help", strings.Join(fields, " "))
+	}
+	return nil
+}
+
+// transaction handles `tx <from> <to> <amount> [fee]`.
+func (r *REPL) transaction(args []string) error {
+	from, err := r.wallet(args[0])
+	if err != nil {
+		return err
+	}
+	to, err := r.wallet(args[1])
+	if err != nil {
+		return err
+	}
+	value, err := transaction.ParseAmount(args[2])
+	if err != nil {
+		return err
+	}
+	var fee transaction.Amount
+	if len(args) == 4 {
+		if fee, err = transaction.ParseAmount(args[3]); err != nil {
+			return err
+		}
+	}
+	t, err := from.NewTransaction(to.BlockchainAddress(), value, fee, r.blockchain.NextNonce(from.BlockchainAddress()), r.blockchain.ChainID())
+	if err != nil {
+		return err
+	}
+	if err := r.blockchain.AddTransaction(t); err != nil {
+		return err
+	}
+	fmt.Fprintf(r.out, "pending %s: %s -> %s %s\n", t.ID()[:8], args[0], args[1], value)
+	return nil
+}
+
+// wallet returns the wallet called name, creating it on first use.
+func (r *REPL) wallet(name string) (*wallet.Wallet, error) {
+	if w, ok := r.wallets[name]; ok {
+		return w, nil
+	}
+	w, err := wallet.NewWallet()
+	if err != nil {
+		return nil, err
+	}
+	r.wallets[name] = w
+	return w, nil
+}
+
+// name returns the wallet name owning address, or the address itself.
+func (r *REPL) name(address string) string {
+	for name, w := range r.wallets {
+		if w.BlockchainAddress() == address {
+			return name
+		}
+	}
+	return address
+}
+
+// replCommand starts the interactive shell on stdin.
+func replCommand(args []string) {
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
+	difficulty := fs.Float64("difficulty", block.MINING_DIFFICULTY, "leading hex zeros a block hash needs, fractions allowed")
+	fs.Parse(args)
+	r, err := NewREPL(os.Stdout, block.NewProofOfWork(*difficulty, nil))
+	if err != nil {
+		log.Fatalf("action=repl, status=fail, err=%v", err)
+	}
+	fmt.Println(`blockchain shell; type "help" for commands`)
+	r.Run(os.Stdin)
+}
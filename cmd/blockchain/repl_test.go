@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/block"
+)
+
+func TestREPLBuildsAChainStepByStep(t *testing.T) {
+	var out bytes.Buffer
+	r, err := NewREPL(&out, block.NewProofOfWork(1, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Run(strings.NewReader(`tx miner alice 0.5
+tx alice bob 9
+pool
+mine
+mine
+tx alice bob 0.2 0.01
+mine
+balance alice
+balance bob
+balance alice 1
+frobnicate
+quit
+balance bob
+`))
+	for _, want := range []string{
+		"pending ",
+		": miner -> alice 0.50000000\n",
+		"error: insufficient balance", // alice has nothing confirmed to send yet
+		"1 pending\n",
+		"mined block 1 with 2 transactions\n",
+		"error: transaction pool is empty",
+		"mined block 2 with 2 transactions\n",
+		"alice: 0.29000000\n",
+		"bob: 0.20000000\n",
+		"alice at height 1: 0.50000000\n",
+		`error: unknown command "frobnicate"; type help`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("REPL output lacks %q:\n%s", want, out.String())
+		}
+	}
+	if n := strings.Count(out.String(), "bob: "); n != 1 {
+		t.Fatalf("bob's balance printed %d times, want once as the shell quits first", n)
+	}
+}
@@ -0,0 +1,74 @@
+// Package httputil holds the HTTP helpers the node and the wallet server share: JSON responses, bearer
+// tokens, and TLS.
+package httputil
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+)
+
+// JsonStatus returns a small JSON document of the form {"message": message}.
+func JsonStatus(message string) []byte {
+	m, _ := json.Marshal(struct {
+		Message string `json:"message"`
+	}{
+		Message: message,
+	})
+	return m
+}
+
+// WriteJSON writes v as a JSON response body with the given status code.
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	m, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(m)
+}
+
+// WriteStatus writes a {"message": ...} JSON response with the given status code.
+func WriteStatus(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(JsonStatus(message))
+}
+
+// SetBearerToken adds token to req as "Authorization: Bearer <token>", unless it is empty.
+func SetBearerToken(req *http.Request, token string) {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// GetHost returns the IPv4 address of this machine, falling back to the loopback address.
+func GetHost() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "127.0.0.1"
+	}
+	addresses, err := net.LookupHost(hostname)
+	if err != nil {
+		return "127.0.0.1"
+	}
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+			return address
+		}
+	}
+	return "127.0.0.1"
+}
+
+// ClientIP is the key requests are limited by: the remote IP address, without the port. Forwarding headers
+// are ignored because any client can set them.
+func ClientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,104 @@
+package httputil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+)
+
+const TLS_SELF_SIGNED_VALIDITY = 365 * 24 * time.Hour
+
+// LoadTLSCertificate returns the certificate a server should present: the certFile/keyFile PEM pair if given,
+// a freshly generated self-signed one if selfSigned, or nil to serve plain HTTP.
+func LoadTLSCertificate(certFile, keyFile string, selfSigned bool) (*tls.Certificate, error) {
+	switch {
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("a TLS certificate needs both a cert and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	case selfSigned:
+		cert, err := SelfSignedCertificate()
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	default:
+		return nil, nil
+	}
+}
+
+// SelfSignedCertificate generates an ECDSA P-256 certificate for localhost, the loopback addresses, and the
+// host's address, valid for TLS_SELF_SIGNED_VALIDITY. Clients cannot verify it, so it is only fit for demos.
+func SelfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"how-blockchain-works"}, CommonName: "localhost"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(TLS_SELF_SIGNED_VALIDITY),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if ip := net.ParseIP(GetHost()); ip != nil && !ip.IsLoopback() {
+		template.IPAddresses = append(template.IPAddresses, ip)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// NewHTTPClient returns a client for node-to-node and wallet-to-node requests. insecureSkipVerify accepts any
+// server certificate, which is needed to talk to nodes serving self-signed certificates.
+func NewHTTPClient(timeout time.Duration, insecureSkipVerify bool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// NewHTTPServer returns a server for handler on addr that speaks HTTPS when cert is non-nil and plain HTTP otherwise.
+func NewHTTPServer(addr string, handler http.Handler, cert *tls.Certificate) *http.Server {
+	server := &http.Server{Addr: addr, Handler: handler}
+	if cert != nil {
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{*cert}, MinVersion: tls.VersionTLS12}
+	}
+	return server
+}
+
+// ServeHTTP runs a server built by NewHTTPServer on ln. It returns nil once the server is shut down.
+func ServeHTTP(server *http.Server, ln net.Listener) error {
+	var err error
+	if server.TLSConfig != nil {
+		err = server.ServeTLS(ln, "", "")
+	} else {
+		err = server.Serve(ln)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
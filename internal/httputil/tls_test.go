@@ -0,0 +1,91 @@
+package httputil
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServersSpeakHTTPSWithACertificate(t *testing.T) {
+	cert, err := SelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewHTTPServer(ln.Addr().String(), http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		WriteStatus(w, http.StatusOK, "hello")
+	}), &cert)
+	served := make(chan error, 1)
+	go func() { served <- ServeHTTP(server, ln) }()
+	url := "https://" + ln.Addr().String() + "/"
+
+	if _, err := NewHTTPClient(time.Second, false).Get(url); err == nil {
+		t.Fatal("a verifying client accepted the self-signed certificate")
+	}
+	resp, err := NewHTTPClient(time.Second, true).Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.TLS == nil || string(body) != string(JsonStatus("hello")) {
+		t.Fatalf("GET %s: TLS %v, body %s", url, resp.TLS != nil, body)
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("ServeHTTP after Shutdown = %v, want nil", err)
+	}
+}
+
+func TestLoadTLSCertificateReadsPEMFiles(t *testing.T) {
+	if cert, err := LoadTLSCertificate("", "", false); cert != nil || err != nil {
+		t.Fatalf("LoadTLSCertificate without settings = %v, %v, want plain HTTP", cert, err)
+	}
+	if cert, err := LoadTLSCertificate("", "", true); cert == nil || err != nil {
+		t.Fatalf("LoadTLSCertificate self-signed = %v, %v", cert, err)
+	}
+
+	generated, err := SelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(generated.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: generated.Certificate[0]}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadTLSCertificate(certFile, keyFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cert.Certificate[0]) != string(generated.Certificate[0]) {
+		t.Fatal("the loaded certificate differs from the one written")
+	}
+	if _, err := LoadTLSCertificate(certFile, "", false); err == nil {
+		t.Fatal("LoadTLSCertificate accepted a certificate without its key")
+	}
+	if _, err := LoadTLSCertificate(keyFile, certFile, false); err == nil {
+		t.Fatal("LoadTLSCertificate accepted swapped files")
+	}
+}
@@ -0,0 +1,370 @@
+// Package trace records spans of work and exports them to the log or an OTLP collector.
+package trace
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/internal/httputil"
+)
+
+const (
+	TRACE_EXPORTER_NONE = "none"
+	TRACE_EXPORTER_LOG  = "log"
+	TRACE_EXPORTER_OTLP = "otlp"
+
+	TRACE_DEFAULT_OTLP_ENDPOINT = "http://127.0.0.1:4318/v1/traces"
+	TRACE_BATCH_SIZE            = 256
+	TRACE_BATCH_INTERVAL        = 5 * time.Second
+	TRACE_QUEUE_SIZE            = 4096
+	TRACE_EXPORT_TIMEOUT        = 5 * time.Second
+
+	TRACEPARENT_HEADER = "traceparent"
+)
+
+// OTLP span kinds and status codes.
+const (
+	SPAN_KIND_INTERNAL = 1
+	SPAN_KIND_SERVER   = 2
+	SPAN_KIND_CLIENT   = 3
+
+	spanStatusError = 2
+)
+
+// SpanContext identifies a span across processes, as carried by the W3C traceparent header.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// Span is one timed operation of a trace. All methods are no-ops on a nil *Span, which StartSpan
+// returns while tracing is off, so instrumented code needs no checks.
+type Span struct {
+	SpanContext
+	ParentID   [8]byte
+	Name       string
+	Kind       int
+	Start, End time.Time
+	Attributes map[string]any
+	Err        error
+}
+
+// SetAttribute records a string, bool, or integer attribute on the span.
+func (s *Span) SetAttribute(key string, value any) {
+	if s != nil {
+		s.Attributes[key] = value
+	}
+}
+
+// SetError marks the span as failed with err; a nil err leaves it unchanged.
+func (s *Span) SetError(err error) {
+	if s != nil && err != nil {
+		s.Err = err
+	}
+}
+
+// Finish ends the span and queues it for export.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	if t := activeTracer.Load(); t != nil {
+		t.enqueue(s)
+	}
+}
+
+type spanContextKey struct{}
+
+// StartSpan starts a span named name as a child of the span in ctx (or of a remote parent from
+// ContextWithRemoteParent), or as the root of a new trace, and returns a context carrying it.
+// While tracing is off it returns ctx unchanged and a nil span.
+func StartSpan(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if activeTracer.Load() == nil {
+		return ctx, nil
+	}
+	s := &Span{Name: name, Kind: kind, Start: time.Now(), Attributes: make(map[string]any)}
+	if parent, ok := ctx.Value(spanContextKey{}).(SpanContext); ok {
+		s.TraceID = parent.TraceID
+		s.ParentID = parent.SpanID
+	} else {
+		rand.Read(s.TraceID[:])
+	}
+	rand.Read(s.SpanID[:])
+	return context.WithValue(ctx, spanContextKey{}, s.SpanContext), s
+}
+
+// ContextWithRemoteParent returns ctx carrying the parent span from req's traceparent header, if it has a valid one.
+func ContextWithRemoteParent(ctx context.Context, req *http.Request) context.Context {
+	parts := strings.Split(req.Header.Get(TRACEPARENT_HEADER), "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return ctx
+	}
+	var sc SpanContext
+	if hex.DecodedLen(len(parts[1])) != len(sc.TraceID) || hex.DecodedLen(len(parts[2])) != len(sc.SpanID) {
+		return ctx
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// InjectTraceparent sets req's traceparent header to the span in ctx, so the receiving node's spans join the trace.
+func InjectTraceparent(ctx context.Context, req *http.Request) {
+	if sc, ok := ctx.Value(spanContextKey{}).(SpanContext); ok {
+		req.Header.Set(TRACEPARENT_HEADER, fmt.Sprintf("00-%x-%x-01", sc.TraceID, sc.SpanID))
+	}
+}
+
+// TraceHandler wraps next so every request runs in a server span joined to the caller's trace.
+func TraceHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if activeTracer.Load() == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		ctx, span := StartSpan(ContextWithRemoteParent(req.Context(), req), req.Method+" "+req.URL.Path, SPAN_KIND_SERVER)
+		span.SetAttribute("http.method", req.Method)
+		span.SetAttribute("http.target", req.URL.RequestURI())
+		span.SetAttribute("net.peer.ip", httputil.ClientIP(req))
+		defer span.Finish()
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// SpanExporter sends finished spans to a trace backend.
+type SpanExporter interface {
+	ExportSpans(spans []*Span) error
+}
+
+// Tracer batches finished spans and hands them to its exporter in the background. At most one tracer is
+// active at a time; see SetTracer.
+type Tracer struct {
+	exporter SpanExporter
+	queue    chan *Span
+	flush    chan chan struct{}
+	dropped  atomic.Uint64
+	once     sync.Once
+	done     chan struct{}
+}
+
+var activeTracer atomic.Pointer[Tracer]
+
+// NewTracer starts a tracer that exports through exporter every TRACE_BATCH_INTERVAL or TRACE_BATCH_SIZE spans.
+func NewTracer(exporter SpanExporter) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		queue:    make(chan *Span, TRACE_QUEUE_SIZE),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// SetTracer makes t receive every span finished from now on; nil turns tracing off.
+func SetTracer(t *Tracer) {
+	activeTracer.Store(t)
+}
+
+// NewTracerByName builds the tracer selected by -trace_exporter, or nil for "none".
+func NewTracerByName(exporter, endpoint, service string) (*Tracer, error) {
+	switch exporter {
+	case "", TRACE_EXPORTER_NONE:
+		return nil, nil
+	case TRACE_EXPORTER_LOG:
+		return NewTracer(LogSpanExporter{}), nil
+	case TRACE_EXPORTER_OTLP:
+		return NewTracer(NewOTLPExporter(endpoint, service)), nil
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+}
+
+// enqueue queues a finished span, dropping it when the exporter has fallen TRACE_QUEUE_SIZE spans behind
+// rather than slowing the node down.
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case t.queue <- s:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(TRACE_BATCH_INTERVAL)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, TRACE_BATCH_SIZE)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.ExportSpans(batch); err != nil {
+			log.Printf("action=export_spans, status=fail, spans=%d, err=%v", len(batch), err)
+		}
+		batch = make([]*Span, 0, TRACE_BATCH_SIZE)
+	}
+	for {
+		select {
+		case s := <-t.queue:
+			if batch = append(batch, s); len(batch) >= TRACE_BATCH_SIZE {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-t.flush:
+			for len(t.queue) > 0 {
+				batch = append(batch, <-t.queue)
+			}
+			export()
+			close(flushed)
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// Shutdown exports the queued spans and stops the tracer, giving up when ctx is done.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	var err error
+	t.once.Do(func() {
+		flushed := make(chan struct{})
+		select {
+		case t.flush <- flushed:
+			select {
+			case <-flushed:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		close(t.done)
+		if n := t.dropped.Load(); n > 0 {
+			log.Printf("action=trace_shutdown, dropped_spans=%d", n)
+		}
+	})
+	return err
+}
+
+// LogSpanExporter writes each span as a log line, for looking at traces without a backend.
+type LogSpanExporter struct{}
+
+// ExportSpans logs spans.
+func (LogSpanExporter) ExportSpans(spans []*Span) error {
+	for _, s := range spans {
+		log.Printf("action=span, name=%q, trace_id=%x, span_id=%x, parent_id=%x, duration=%s, attributes=%v, err=%v",
+			s.Name, s.TraceID, s.SpanID, s.ParentID, s.End.Sub(s.Start), s.Attributes, s.Err)
+	}
+	return nil
+}
+
+// OTLPExporter posts spans in the OTLP/HTTP JSON encoding, which the OpenTelemetry Collector, Jaeger, and
+// most trace backends accept on port 4318. It does without the OpenTelemetry SDK and its dependencies.
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+}
+
+// NewOTLPExporter exports to endpoint (such as TRACE_DEFAULT_OTLP_ENDPOINT) under the service.name service.
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	return &OTLPExporter{endpoint: endpoint, service: service, client: &http.Client{Timeout: TRACE_EXPORT_TIMEOUT}}
+}
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            map[string]any `json:"status,omitempty"`
+}
+
+// otlpValue encodes an attribute value as an OTLP AnyValue; 64-bit integers travel as strings.
+func otlpValue(v any) map[string]any {
+	switch v := v.(type) {
+	case bool:
+		return map[string]any{"boolValue": v}
+	case int:
+		return map[string]any{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case uint64:
+		return map[string]any{"intValue": strconv.FormatUint(v, 10)}
+	default:
+		return map[string]any{"stringValue": fmt.Sprint(v)}
+	}
+}
+
+// ExportSpans posts spans as one ExportTraceServiceRequest.
+func (e *OTLPExporter) ExportSpans(spans []*Span) error {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.SpanID[:]),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if s.ParentID != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.ParentID[:])
+		}
+		for k, v := range s.Attributes {
+			o.Attributes = append(o.Attributes, otlpKeyValue{k, otlpValue(v)})
+		}
+		if s.Err != nil {
+			o.Status = map[string]any{"code": spanStatusError, "message": s.Err.Error()}
+		}
+		encoded = append(encoded, o)
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": []otlpKeyValue{{"service.name", otlpValue(e.service)}}},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "how-blockchain-works"},
+				"spans": encoded,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingExporter keeps every span it is given.
+type recordingExporter struct {
+	mux   sync.Mutex
+	spans []*Span
+}
+
+func (e *recordingExporter) ExportSpans(spans []*Span) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestSpansJoinTheirParentsTraceAcrossNodes(t *testing.T) {
+	if ctx, span := StartSpan(context.Background(), "off", SPAN_KIND_INTERNAL); span != nil || ctx != context.Background() {
+		t.Fatal("StartSpan recorded a span with tracing off")
+	}
+
+	exporter := &recordingExporter{}
+	tracer := NewTracer(exporter)
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	ctx, parent := StartSpan(context.Background(), "mining", SPAN_KIND_INTERNAL)
+	_, child := StartSpan(ctx, "pow_seal", SPAN_KIND_INTERNAL)
+	child.SetAttribute("pow.nonce", 42)
+	child.SetError(errors.New("cancelled"))
+	child.SetError(nil)
+	child.Finish()
+
+	// The neighbor's server span joins the trace through the traceparent header.
+	var remote *Span
+	handler := TraceHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, remote = StartSpan(req.Context(), "receive_block", SPAN_KIND_INTERNAL)
+		remote.Finish()
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/blocks/compact", nil)
+	InjectTraceparent(ctx, req)
+	if want := fmt.Sprintf("00-%x-%x-01", parent.TraceID, parent.SpanID); req.Header.Get(TRACEPARENT_HEADER) != want {
+		t.Fatalf("traceparent %q, want %q", req.Header.Get(TRACEPARENT_HEADER), want)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	parent.Finish()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(exporter.spans) != 4 {
+		t.Fatalf("exported %d spans, want 4", len(exporter.spans))
+	}
+	server := exporter.spans[2]
+	if server.Name != "POST /blocks/compact" || server.Kind != SPAN_KIND_SERVER || server.Attributes["http.method"] != http.MethodPost {
+		t.Fatalf("server span %+v", server)
+	}
+	for _, s := range exporter.spans {
+		if s.TraceID != parent.TraceID {
+			t.Errorf("span %s is in trace %x, want %x", s.Name, s.TraceID, parent.TraceID)
+		}
+	}
+	if child.ParentID != parent.SpanID || server.ParentID != parent.SpanID || remote.ParentID != server.SpanID {
+		t.Fatal("spans do not point at their parents")
+	}
+	if child.Err == nil || child.Attributes["pow.nonce"] != 42 {
+		t.Fatalf("child span %+v lost its error or attribute", child)
+	}
+}
+
+func TestOTLPExporterPostsSpansAsJSON(t *testing.T) {
+	var body map[string]any
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		json.NewDecoder(req.Body).Decode(&body)
+	}))
+	defer collector.Close()
+
+	span := &Span{Name: "mine_block", Kind: SPAN_KIND_INTERNAL, Attributes: map[string]any{"block.height": 3, "pow.hashes": uint64(1 << 40)}, Err: errors.New("stale")}
+	span.TraceID[0], span.SpanID[0] = 1, 2
+	if err := NewOTLPExporter(collector.URL, "node-5000").ExportSpans([]*Span{span}); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := json.Marshal(body)
+	for _, want := range []string{
+		`"service.name","value":{"stringValue":"node-5000"}`,
+		`"traceId":"01000000000000000000000000000000"`,
+		`"spanId":"0200000000000000"`,
+		`"key":"block.height","value":{"intValue":"3"}`,
+		`"key":"pow.hashes","value":{"intValue":"1099511627776"}`,
+		`"status":{"code":2,"message":"stale"}`,
+	} {
+		if !json.Valid(m) || !strings.Contains(string(m), want) {
+			t.Errorf("OTLP body lacks %s:\n%s", want, m)
+		}
+	}
+	if strings.Contains(string(m), "parentSpanId") {
+		t.Error("a root span has a parent span ID")
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	if err := NewOTLPExporter(failing.URL, "node").ExportSpans([]*Span{span}); err == nil {
+		t.Fatal("ExportSpans ignored a 503 from the collector")
+	}
+}
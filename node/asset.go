@@ -0,0 +1,115 @@
+package node
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+var (
+	ErrUnknownAsset  = errors.New("no such asset")
+	ErrAssetExists   = errors.New("asset ID already minted")
+	ErrNotAssetOwner = errors.New("sender does not own the asset")
+)
+
+// Asset is a minted asset: who minted it, who owns it now, and the hash of its metadata.
+type Asset struct {
+	ID       string `json:"id"`
+	Creator  string `json:"creator"`
+	Owner    string `json:"owner"`
+	Metadata string `json:"metadata"`
+}
+
+// cloneAssets copies assets, so applying blocks to the copy leaves them as they are.
+func cloneAssets(assets map[string]*Asset) map[string]*Asset {
+	clone := make(map[string]*Asset, len(assets))
+	for id, a := range assets {
+		c := *a
+		clone[id] = &c
+	}
+	return clone
+}
+
+// applyAsset applies asset transaction t to assets.
+func applyAsset(assets map[string]*Asset, t *transaction.Transaction) {
+	op := t.Asset()
+	if op.Kind() == transaction.ASSET_MINT {
+		metadata := op.Metadata()
+		assets[op.ID()] = &Asset{ID: op.ID(), Creator: t.SenderBlockchainAddress(), Owner: t.SenderBlockchainAddress(), Metadata: hex.EncodeToString(metadata[:])}
+		return
+	}
+	assets[op.ID()].Owner = t.RecipientBlockchainAddress()
+}
+
+// assetMoves tracks the assets that earlier transactions of a block, or of the pool, mint or transfer, on
+// top of the confirmed assets. An asset minted or received in the same block cannot move until it is
+// confirmed, and each may move only once per block.
+type assetMoves struct {
+	assets map[string]*Asset
+	moved  map[string]bool
+}
+
+func newAssetMoves(assets map[string]*Asset) *assetMoves {
+	return &assetMoves{assets: assets, moved: make(map[string]bool)}
+}
+
+// admit returns why asset transaction t cannot follow the ones admitted before, or records it and returns nil.
+func (m *assetMoves) admit(t *transaction.Transaction) error {
+	op := t.Asset()
+	a, ok := m.assets[op.ID()]
+	if op.Kind() == transaction.ASSET_MINT {
+		if ok || m.moved[op.ID()] {
+			return fmt.Errorf("%w: %s", ErrAssetExists, op.ID())
+		}
+		m.moved[op.ID()] = true
+		return nil
+	}
+	switch {
+	case !ok:
+		return fmt.Errorf("%w: %s", ErrUnknownAsset, op.ID())
+	case a.Owner != t.SenderBlockchainAddress() || m.moved[op.ID()]:
+		return fmt.Errorf("%w: %s", ErrNotAssetOwner, op.ID())
+	}
+	m.moved[op.ID()] = true
+	return nil
+}
+
+// admitAsset returns why asset transaction t cannot join the pool after the asset transactions already in
+// it, or nil. Callers must hold mux.
+func (bc *Blockchain) admitAsset(t *transaction.Transaction) error {
+	moves := newAssetMoves(bc.assets)
+	for _, p := range bc.transactionPool {
+		if p.Asset() != nil {
+			moves.admit(p)
+		}
+	}
+	return moves.admit(t)
+}
+
+// Asset returns a copy of the asset minted as id, or false if there is none.
+func (bc *Blockchain) Asset(id string) (Asset, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	a, ok := bc.assets[id]
+	if !ok {
+		return Asset{}, false
+	}
+	return *a, true
+}
+
+// AssetsOf returns the assets the address owns, by ID.
+func (bc *Blockchain) AssetsOf(blockchainAddress string) []Asset {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	owned := make([]Asset, 0)
+	for _, id := range slices.Sorted(maps.Keys(bc.assets)) {
+		if a := bc.assets[id]; a.Owner == blockchainAddress {
+			owned = append(owned, *a)
+		}
+	}
+	return owned
+}
@@ -0,0 +1,61 @@
+package node
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+func TestAssetsAreMintedOnceAndMoveOncePerBlock(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	alice := fund(t, bc, miner, transaction.COIN)
+	bob, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := sha256.Sum256([]byte("artwork.png"))
+	if err := bc.AddTransaction(signed(t, bc, alice, transaction.NewAssetMint(alice.BlockchainAddress(), "art:1", metadata, transaction.ASSET_MINT_FEE))); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(signed(t, bc, miner, transaction.NewAssetMint(miner.BlockchainAddress(), "art:1", metadata, transaction.ASSET_MINT_FEE))); !errors.Is(err, ErrAssetExists) {
+		t.Fatalf("AddTransaction minting a pending ID = %v, want %v", err, ErrAssetExists)
+	}
+	if err := bc.AddTransaction(signed(t, bc, alice, transaction.NewAssetTransfer(alice.BlockchainAddress(), bob.BlockchainAddress(), "art:1", 0))); !errors.Is(err, ErrUnknownAsset) {
+		t.Fatalf("AddTransaction moving an unconfirmed asset = %v, want %v", err, ErrUnknownAsset)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+
+	if err := bc.AddTransaction(signed(t, bc, miner, transaction.NewAssetTransfer(miner.BlockchainAddress(), bob.BlockchainAddress(), "art:1", 0))); !errors.Is(err, ErrNotAssetOwner) {
+		t.Fatalf("AddTransaction by another address = %v, want %v", err, ErrNotAssetOwner)
+	}
+	if err := bc.AddTransaction(signed(t, bc, alice, transaction.NewAssetTransfer(alice.BlockchainAddress(), bob.BlockchainAddress(), "art:1", 0))); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(signed(t, bc, alice, transaction.NewAssetTransfer(alice.BlockchainAddress(), miner.BlockchainAddress(), "art:1", 0))); !errors.Is(err, ErrNotAssetOwner) {
+		t.Fatalf("AddTransaction moving a pending asset again = %v, want %v", err, ErrNotAssetOwner)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+
+	want := Asset{ID: "art:1", Creator: alice.BlockchainAddress(), Owner: bob.BlockchainAddress(), Metadata: hex.EncodeToString(metadata[:])}
+	if a, ok := bc.Asset("art:1"); !ok || a != want {
+		t.Fatalf("Asset = %+v, %v, want %+v", a, ok, want)
+	}
+	if owned := bc.AssetsOf(bob.BlockchainAddress()); len(owned) != 1 || owned[0] != want {
+		t.Fatalf("AssetsOf(bob) = %+v, want the asset", owned)
+	}
+	if owned := bc.AssetsOf(alice.BlockchainAddress()); len(owned) != 0 {
+		t.Fatalf("AssetsOf(alice) = %+v after the transfer", owned)
+	}
+	if _, ok := bc.Asset("art:2"); ok {
+		t.Fatal("Asset of an unminted ID = true")
+	}
+}
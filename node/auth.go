@@ -0,0 +1,142 @@
+package node
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const API_KEY_HEADER = "X-API-Key"
+
+var (
+	ErrMissingToken = errors.New("missing API key or bearer token")
+	ErrInvalidToken = errors.New("invalid API key or bearer token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Authenticator checks the credentials of requests to mutating endpoints: a static API key, sent as
+// "Authorization: Bearer <key>" or in the X-API-Key header, or an HS256 JSON Web Token signed with the
+// shared secret and sent as a bearer token. A nil *Authenticator accepts every request.
+type Authenticator struct {
+	apiKeys   [][]byte
+	jwtSecret []byte
+}
+
+// NewAuthenticator accepts any of apiKeys and any JWT signed with jwtSecret. It returns nil, which disables
+// authentication, when both are empty.
+func NewAuthenticator(apiKeys []string, jwtSecret string) *Authenticator {
+	a := &Authenticator{}
+	for _, k := range apiKeys {
+		if k = strings.TrimSpace(k); k != "" {
+			a.apiKeys = append(a.apiKeys, []byte(k))
+		}
+	}
+	if jwtSecret != "" {
+		a.jwtSecret = []byte(jwtSecret)
+	}
+	if len(a.apiKeys) == 0 && a.jwtSecret == nil {
+		return nil
+	}
+	return a
+}
+
+// Authenticate reports why req carries no acceptable credential, or nil if it does.
+func (a *Authenticator) Authenticate(req *http.Request) error {
+	if a == nil {
+		return nil
+	}
+	token := req.Header.Get(API_KEY_HEADER)
+	if bearer, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+		token = strings.TrimSpace(bearer)
+	}
+	if token == "" {
+		return ErrMissingToken
+	}
+	// Compare against every key so the response time does not reveal which one nearly matched.
+	matched := 0
+	for _, k := range a.apiKeys {
+		matched |= subtle.ConstantTimeCompare([]byte(token), k)
+	}
+	if matched == 1 {
+		return nil
+	}
+	if a.jwtSecret != nil && strings.Count(token, ".") == 2 {
+		_, err := VerifyJWT(a.jwtSecret, token, time.Now())
+		return err
+	}
+	return ErrInvalidToken
+}
+
+// JWTClaims are the registered claims this node reads; exp and nbf are Unix seconds and zero means unset.
+type JWTClaims struct {
+	Subject   string `json:"sub,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// NewJWT issues an HS256 token for subject that expires after ttl (never, if ttl is zero or less).
+func NewJWT(secret []byte, subject string, ttl time.Duration, now time.Time) (string, error) {
+	claims := JWTClaims{Subject: subject, IssuedAt: now.Unix()}
+	if ttl > 0 {
+		claims.ExpiresAt = now.Add(ttl).Unix()
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(jwtSignature(secret, signingInput)), nil
+}
+
+// VerifyJWT checks the token's HS256 signature and its validity window at now and returns its claims.
+// Tokens using any other algorithm, including "none", are rejected.
+func VerifyJWT(secret []byte, token string, now time.Time) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(header, &h) != nil || h.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, jwtSignature(secret, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims JWTClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.ExpiresAt != 0 && now.Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Unix() < claims.NotBefore {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+func jwtSignature(secret []byte, signingInput string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,92 @@
+package node
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticatorAcceptsAPIKeysAndSignedTokens(t *testing.T) {
+	if NewAuthenticator([]string{" ", ""}, "") != nil {
+		t.Fatal("an authenticator without credentials is not nil")
+	}
+	var open *Authenticator
+	if err := open.Authenticate(httptest.NewRequest(http.MethodPost, "/mine", nil)); err != nil {
+		t.Fatalf("nil authenticator = %v", err)
+	}
+
+	secret := []byte("s3cret")
+	now := time.Now()
+	valid, err := NewJWT(secret, "ops", time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := NewJWT(secret, "ops", time.Minute, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := NewJWT([]byte("guess"), "ops", time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, ".")
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + "."
+	promoted := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2]
+
+	a := NewAuthenticator([]string{"key-1", "key-2"}, string(secret))
+	tests := []struct {
+		name, header, value string
+		want                error
+	}{
+		{"no credential", "", "", ErrMissingToken},
+		{"API key header", API_KEY_HEADER, "key-2", nil},
+		{"API key as bearer", "Authorization", "Bearer key-1", nil},
+		{"unknown API key", API_KEY_HEADER, "key-3", ErrInvalidToken},
+		{"JWT", "Authorization", "Bearer " + valid, nil},
+		{"expired JWT", "Authorization", "Bearer " + expired, ErrTokenExpired},
+		{"JWT signed with another secret", "Authorization", "Bearer " + forged, ErrInvalidToken},
+		{"unsigned JWT", "Authorization", "Bearer " + unsigned, ErrInvalidToken},
+		{"JWT with changed claims", "Authorization", "Bearer " + promoted, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/mine", nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		if err := a.Authenticate(req); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Authenticate = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if claims, err := VerifyJWT(secret, valid, now); err != nil || claims.Subject != "ops" || claims.ExpiresAt != now.Add(time.Hour).Unix() {
+		t.Fatalf("VerifyJWT = %+v, %v", claims, err)
+	}
+}
+
+func TestMutatingEndpointsRequireACredential(t *testing.T) {
+	ok := func(w http.ResponseWriter, req *http.Request) { w.WriteHeader(http.StatusOK) }
+	bcs := &BlockchainServer{auth: NewAuthenticator([]string{"key"}, "")}
+	handler := bcs.requireAuth("transactions", ok, http.MethodGet)
+	status := func(method, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/transactions", nil)
+		if key != "" {
+			req.Header.Set(API_KEY_HEADER, key)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	if rec := status(http.MethodGet, ""); rec.Code != http.StatusOK {
+		t.Fatalf("GET without a credential: %d, want 200", rec.Code)
+	}
+	rec := status(http.MethodPost, "")
+	if rec.Code != http.StatusUnauthorized || !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Bearer") {
+		t.Fatalf("POST without a credential: %d %q, want 401 with a Bearer challenge", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+	if rec := status(http.MethodPost, "key"); rec.Code != http.StatusOK {
+		t.Fatalf("POST with the API key: %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,81 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+func TestBalanceAtReadsTheAccountsAfterEachBlock(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	alice := fund(t, bc, miner, transaction.COIN/2)
+	fund(t, bc, alice, transaction.COIN/8)
+	fund(t, bc, miner, transaction.COIN/4)
+
+	for height, want := range []transaction.Amount{0, transaction.COIN / 2, transaction.COIN/2 - transaction.COIN/8, transaction.COIN/2 - transaction.COIN/8} {
+		if got, err := bc.BalanceAt(alice.BlockchainAddress(), height); err != nil || got != want {
+			t.Errorf("BalanceAt(alice, %d) = %v, %v; want %v", height, got, err, want)
+		}
+	}
+	// The miner's balance at each height is what a replay of the chain up to it gives.
+	for height := range bc.Chain() {
+		want := replayBalances(bc.Chain()[:height+1])[miner.BlockchainAddress()]
+		if got, err := bc.BalanceAt(miner.BlockchainAddress(), height); err != nil || got != want {
+			t.Errorf("BalanceAt(miner, %d) = %v, %v; want %v", height, got, err, want)
+		}
+	}
+	for _, height := range []int{-1, len(bc.Chain())} {
+		if _, err := bc.BalanceAt(alice.BlockchainAddress(), height); err == nil {
+			t.Errorf("BalanceAt(alice, %d) succeeded", height)
+		}
+	}
+
+	var got struct {
+		Height      int                `json:"height"`
+		AmountUnits transaction.Amount `json:"amount_units"`
+	}
+	target := fmt.Sprintf("/balance_at?blockchain_address=%s&height=1", alice.BlockchainAddress())
+	if rec := serve(t, bcs.BalanceAt, http.MethodGet, target, nil, &got); rec.Code != http.StatusOK || got.Height != 1 || got.AmountUnits != transaction.COIN/2 {
+		t.Fatalf("GET %s = %d, %+v", target, rec.Code, got)
+	}
+	query := fmt.Sprintf(`{ then: balance(address: "%[1]s", height: 1) now: balance(address: "%[1]s") }`, alice.BlockchainAddress())
+	if got := graphQL(t, bcs, query, nil); got != `{"data":{"then":"0.50000000","now":"0.37500000"}}` {
+		t.Fatalf("balance at height 1 through GraphQL = %s", got)
+	}
+	for target, want := range map[string]int{
+		"/balance_at?height=1": http.StatusBadRequest,
+		"/balance_at?blockchain_address=" + alice.BlockchainAddress() + "&height=tip": http.StatusBadRequest,
+		"/balance_at?blockchain_address=" + alice.BlockchainAddress() + "&height=99":  http.StatusNotFound,
+	} {
+		if rec := serve(t, bcs.BalanceAt, http.MethodGet, target, nil, nil); rec.Code != want {
+			t.Errorf("GET %s = %d, want %d", target, rec.Code, want)
+		}
+	}
+}
+
+func TestBalanceAtIsUnavailableBelowAFastSyncSnapshot(t *testing.T) {
+	full, miner := newTestBlockchain(t)
+	alice := fund(t, full, miner, transaction.COIN)
+	fund(t, full, alice, transaction.COIN/4)
+	s, err := full.SnapshotAt(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, _ := newTestBlockchain(t)
+	if err := fresh.FastSync(context.Background(), serveSnapshots(t, full), 1, s.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fresh.BalanceAt(alice.BlockchainAddress(), 0); err == nil {
+		t.Fatal("BalanceAt below the snapshot succeeded")
+	}
+	for height := 1; height < len(full.Chain()); height++ {
+		want, _ := full.BalanceAt(alice.BlockchainAddress(), height)
+		if got, err := fresh.BalanceAt(alice.BlockchainAddress(), height); err != nil || got != want {
+			t.Fatalf("BalanceAt(alice, %d) after fast sync = %v, %v; want %v", height, got, err, want)
+		}
+	}
+}
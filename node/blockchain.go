@@ -0,0 +1,1838 @@
+// Package node runs a blockchain node: the chain and its state, the transaction pool, mining, storage, the
+// peer-to-peer protocol, and the HTTP API.
+package node
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/internal/httputil"
+	"github.com/dikako/how-blockchain-works/internal/trace"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+const (
+	MINING_REWARD = 1 * transaction.COIN // reward of the first halving era; see BlockReward
+
+	MINING_TIMER_SEC = 20
+
+	MAX_TRANSACTION_POOL_SIZE = 1000
+
+	// MAX_BLOCK_TRANSACTIONS and MAX_BLOCK_SIZE limit a block to that many transactions, coinbase included,
+	// and to that many bytes of JSON-serialized transactions. Pending transactions beyond them wait for a
+	// later block, so miners pick the highest fees first.
+	MAX_BLOCK_TRANSACTIONS = 500
+	MAX_BLOCK_SIZE         = 100_000
+
+	// MAX_FUTURE_BLOCK_TIME is how far ahead of the node's clock a block's timestamp may be, as in Bitcoin.
+	MAX_FUTURE_BLOCK_TIME = 2 * time.Hour
+	// MEDIAN_TIME_SPAN is how many recent blocks the median time past is taken over. A block must be later
+	// than that median, so one miner with a wrong clock cannot drag the chain's time backwards.
+	MEDIAN_TIME_SPAN = 11
+
+	NEIGHBOR_REQUEST_TIMEOUT = 5 * time.Second
+
+	BLOCKS_PAGE_DEFAULT_LIMIT = 20
+	BLOCKS_PAGE_MAX_LIMIT     = 100
+
+	TOP_ADDRESSES_DEFAULT_LIMIT = 10
+	TOP_ADDRESSES_MAX_LIMIT     = 100
+)
+
+// Blockchain holds the chain of blocks, a pool of pending transactions, and the node's known neighbors.
+// It is safe for concurrent use: mux guards chain, the indexes, and transactionPool, and muxMine serializes mining.
+// blockIndex maps each block's hash to its height, txIndex each confirmed transaction's hash to its block's
+// height, balances holds every address's confirmed balance, contracts and receipts the deployed contracts
+// and the outcome of each contract transaction, tokens and assets the registered tokens and minted assets, and
+// nonces every account's next nonce; all are kept in step with chain.
+type Blockchain struct {
+	mux     sync.RWMutex
+	muxMine sync.Mutex
+
+	transactionPool   []*transaction.Transaction
+	maxPoolSize       int
+	poolTTL           time.Duration
+	pooledAt          map[[32]byte]time.Time // when each pooled transaction arrived
+	expired           map[[32]byte]ExpiredTransaction
+	expiredOrder      [][32]byte // keys of expired, oldest first
+	halvingInterval   int
+	maxSupply         transaction.Amount
+	maxBlockTxs       int
+	maxBlockSize      int
+	issued            transaction.Amount
+	chain             []*block.Block
+	blockIndex        map[[32]byte]int
+	txIndex           map[[32]byte]int
+	balances          map[string]transaction.Amount
+	contracts         map[string]*Contract
+	receipts          map[[32]byte]Receipt
+	tokens            map[string]*Token
+	assets            map[string]*Asset
+	nonces            map[string]uint64
+	accounts          *stateTrie   // every account's balance and nonce after the tip, which accountsRoot commits to
+	accountHistory    []*stateTrie // accounts after the block at each height; nil below a pruned snapshot
+	blockchainAddress string
+	hasher            block.Hasher
+	consensus         block.Consensus
+	genesis           *GenesisConfig
+	chainID           string
+	port              uint16
+	storage           *FileStorage
+
+	neighbors      []string
+	neighborRange  NeighborRange
+	bootstrapPeers []string
+	dnsSeeds       []string
+	knownPeers     []string // every peer seen, most recent first; persisted in storage
+	neighborScheme string
+	neighborClient *http.Client
+	neighborToken  string
+	wireFormat     string // WIRE_FORMAT_CBOR or WIRE_FORMAT_JSON, for messages to neighbors
+	insecureTLS    bool
+	transport      PeerTransport
+	dht            *DHT // nil unless EnableDHT was called
+	muxNeighbors   sync.Mutex
+
+	miningStop   context.CancelFunc
+	muxMining    sync.Mutex
+	miningCancel context.CancelFunc // cancels the block sealing in progress; guarded by mux
+	closed       bool               // set by Close; guarded by mux
+
+	events *EventHub
+
+	seenTransactions *seenCache      // IDs of recently gossiped transactions
+	signatures       *SignatureCache // IDs of transactions whose signatures verified; set before Run
+	verifyWorkers    int             // goroutines verifying a block's signatures, 0 for GOMAXPROCS; set before Run
+	orphans          *orphanPool
+	orphanTxs        *orphanTransactionPool
+
+	snapshot *Snapshot // the state fast sync started from, if any; guarded by mux
+
+	checkpoints map[int][32]byte // block hashes pinned by height; set before Run, then only read
+}
+
+// NewBlockchain initializes a Blockchain for a node listening on port. If storage is non-nil and holds
+// a valid chain, that chain is reloaded; otherwise a new genesis block is created (and persisted).
+// hasher hashes block headers (nil selects SHA-256) and consensus seals and verifies blocks
+// (nil selects ProofOfWork at MINING_DIFFICULTY with the same hasher). genesis fixes the first block for the
+// whole network and makes the node refuse stored or neighbor chains that start differently; with nil, the
+// node creates its own genesis paying MINING_REWARD to blockchainAddress and follows any neighbor's chain.
+func NewBlockchain(blockchainAddress string, port uint16, storage *FileStorage, hasher block.Hasher, consensus block.Consensus, genesis *GenesisConfig) *Blockchain {
+	if hasher == nil {
+		hasher = block.SHA256Hasher{}
+	}
+	if consensus == nil {
+		consensus = block.NewProofOfWork(block.MINING_DIFFICULTY, hasher)
+	}
+	bc := new(Blockchain)
+	bc.blockchainAddress = blockchainAddress
+	bc.hasher = hasher
+	bc.consensus = consensus
+	bc.genesis = genesis
+	if genesis != nil {
+		bc.chainID = genesis.ChainID
+	}
+	bc.port = port
+	bc.storage = storage
+	bc.neighborRange = DefaultNeighborRange()
+	bc.neighborScheme = "http"
+	bc.wireFormat = WIRE_FORMAT_CBOR
+	bc.transport = &TCPTransport{}
+	bc.neighborClient = newPeerClient(bc.transport, false)
+	bc.maxPoolSize = MAX_TRANSACTION_POOL_SIZE
+	bc.poolTTL = TRANSACTION_POOL_TTL
+	bc.pooledAt = make(map[[32]byte]time.Time)
+	bc.expired = make(map[[32]byte]ExpiredTransaction)
+	bc.halvingInterval = REWARD_HALVING_INTERVAL
+	bc.maxSupply = MAX_SUPPLY
+	bc.maxBlockTxs = MAX_BLOCK_TRANSACTIONS
+	bc.maxBlockSize = MAX_BLOCK_SIZE
+	bc.blockIndex = make(map[[32]byte]int)
+	bc.txIndex = make(map[[32]byte]int)
+	bc.balances = make(map[string]transaction.Amount)
+	bc.contracts = make(map[string]*Contract)
+	bc.receipts = make(map[[32]byte]Receipt)
+	bc.tokens = make(map[string]*Token)
+	bc.assets = make(map[string]*Asset)
+	bc.nonces = make(map[string]uint64)
+	bc.events = NewEventHub()
+	bc.seenTransactions = newSeenCache()
+	bc.signatures = NewSignatureCache(SIGNATURE_CACHE_SIZE)
+	bc.orphans = newOrphanPool()
+	bc.orphanTxs = newOrphanTransactionPool()
+
+	if storage != nil {
+		snapshot, err := storage.LoadSnapshot()
+		if err != nil {
+			log.Printf("action=load_snapshot, status=fail, err=%v", err)
+		}
+		bc.snapshot = snapshot
+		peers, err := storage.LoadPeers()
+		if err != nil {
+			log.Printf("action=load_peers, status=fail, err=%v", err)
+		}
+		bc.knownPeers = peers
+		chain, err := readStoredChain(storage.Blocks())
+		switch {
+		case err != nil:
+			log.Printf("action=load_chain, status=fail, path=%s, err=%v", storage.Blocks().Path(), err)
+		case len(chain) > 0 && genesis != nil && bc.BlockHash(chain[0]) != bc.BlockHash(genesis.Block(hasher)):
+			log.Printf("action=load_chain, status=fail, path=%s, err=stored chain has a different genesis", storage.Blocks().Path())
+		case len(chain) > 0 && bc.ValidChain(chain):
+			bc.setChain(chain)
+			log.Printf("action=load_chain, status=success, path=%s, length=%d", storage.Blocks().Path(), len(chain))
+			bc.loadTransactionPool()
+			return bc
+		case len(chain) > 0:
+			log.Printf("action=load_chain, status=fail, path=%s, err=stored chain is invalid", storage.Blocks().Path())
+		}
+	}
+
+	if genesis != nil {
+		bc.appendBlock(genesis.Block(hasher))
+	} else {
+		// Like Bitcoin's genesis block, the first block pays the initial reward to the node's miner so
+		// that coins exist before any transaction can pass the balance check.
+		bc.transactionPool = append(bc.transactionPool, transaction.NewTransaction(transaction.MINING_SENDER, blockchainAddress, MINING_REWARD, 0))
+		b := &block.Block{}
+		bc.createBlock(0, bc.BlockHash(b))
+	}
+	if storage != nil && len(bc.chain) == 1 {
+		// Overwrite whatever unusable data was on disk with the fresh genesis block.
+		if err := storage.Blocks().ReplaceChain(bc.chain); err != nil {
+			log.Printf("action=save_chain, status=fail, err=%v", err)
+		}
+	}
+	return bc
+}
+
+// Run starts the node's background routines and adopts the longest valid chain among its neighbors.
+func (bc *Blockchain) Run() {
+	bc.StartSyncNeighbors()
+	bc.StartExpireTransactions()
+	bc.ResolveConflicts(context.Background())
+}
+
+// SetNeighborRange changes the host/port range scanned for neighbors on the next sync.
+func (bc *Blockchain) SetNeighborRange(r NeighborRange) {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	bc.neighborRange = r
+}
+
+// SetNeighborTLS makes the node reach its neighbors over HTTPS, which is what they serve when the whole network
+// runs with TLS. insecureSkipVerify accepts their certificates unchecked, as self-signed ones require.
+func (bc *Blockchain) SetNeighborTLS(enabled, insecureSkipVerify bool) {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	bc.neighborScheme = "http"
+	if enabled {
+		bc.neighborScheme = "https"
+	}
+	bc.insecureTLS = insecureSkipVerify
+	bc.neighborClient = newPeerClient(bc.transport, insecureSkipVerify)
+}
+
+// SetTransport makes the node reach its neighbors, and serve them, through t instead of plain TCP.
+func (bc *Blockchain) SetTransport(t PeerTransport) {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	bc.transport = t
+	bc.neighborClient = newPeerClient(t, bc.insecureTLS)
+}
+
+// Transport returns the transport the node reaches its neighbors through.
+func (bc *Blockchain) Transport() PeerTransport {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	return bc.transport
+}
+
+// SetNeighborToken sets the bearer token sent with every request to a neighbor, which neighbors that require
+// authentication check before accepting relayed transactions and consensus notifications.
+func (bc *Blockchain) SetNeighborToken(token string) {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	bc.neighborToken = token
+}
+
+// neighborRequest builds a request for path on neighbor n, carrying the node's token and the trace of ctx,
+// and returns it with the client to send it with.
+func (bc *Blockchain) neighborRequest(ctx context.Context, method, n, path string, body io.Reader) (*http.Request, *http.Client) {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	req, _ := http.NewRequest(method, fmt.Sprintf("%s://%s%s", bc.neighborScheme, n, path), body)
+	httputil.SetBearerToken(req, bc.neighborToken)
+	if bc.wireFormat == WIRE_FORMAT_CBOR {
+		req.Header.Set("Accept", MIME_CBOR+", "+MIME_JSON)
+	}
+	trace.InjectTraceparent(ctx, req)
+	return req, bc.neighborClient
+}
+
+// SetWireFormat sets the format of the messages the node sends its neighbors: WIRE_FORMAT_CBOR, the default,
+// or WIRE_FORMAT_JSON for networks with nodes that only read JSON. Neighbors answer in CBOR only when asked
+// to, and the node reads both, so the formats mix on one network as long as pushed messages are understood.
+func (bc *Blockchain) SetWireFormat(format string) error {
+	if err := ValidateWireFormat(format); err != nil {
+		return err
+	}
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	bc.wireFormat = format
+	return nil
+}
+
+// marshalPeer encodes v, a message to a neighbor, in the node's wire format and returns it with its content type.
+func (bc *Blockchain) marshalPeer(v any) ([]byte, string, error) {
+	bc.muxNeighbors.Lock()
+	format := bc.wireFormat
+	bc.muxNeighbors.Unlock()
+	return marshalPeer(format, v)
+}
+
+// Neighbors returns a copy of the currently known neighbor addresses.
+func (bc *Blockchain) Neighbors() []string {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	return append([]string(nil), bc.neighbors...)
+}
+
+// SetBootstrapPeers sets host:port addresses of nodes to connect to on every sync in addition to the scanned
+// range, so a node can join a network on another subnet.
+func (bc *Blockchain) SetBootstrapPeers(peers []string) {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	bc.bootstrapPeers = append([]string(nil), peers...)
+}
+
+// SetDNSSeeds sets DNS names ("name" or "name:port") whose address records list nodes of the network,
+// the way Bitcoin nodes bootstrap. They are resolved again on every sync.
+func (bc *Blockchain) SetDNSSeeds(seeds []string) {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	bc.dnsSeeds = append([]string(nil), seeds...)
+}
+
+// EnableDHT makes the node join the DHT under address, the host:port other nodes reach its API at. From then
+// on every sync also bootstraps and refreshes the DHT, and takes the nodes in its routing table as neighbors.
+func (bc *Blockchain) EnableDHT(address string) {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	bc.dht = NewDHT(address, bc.neighborRequest)
+}
+
+// DHT returns the node's DHT, or nil when it is not enabled.
+func (bc *Blockchain) DHT() *DHT {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	return bc.dht
+}
+
+// SyncNeighbors replaces the neighbor list with the reachable nodes among the scanned range, the bootstrap
+// peers, the DNS seeds' addresses, the peers remembered from earlier runs, the peers those neighbors
+// report through GET /peers, and the contacts in the DHT routing table.
+// Every neighbor found is remembered, and persisted when the node has storage.
+func (bc *Blockchain) SyncNeighbors() {
+	bc.muxNeighbors.Lock()
+	r := bc.neighborRange
+	candidates := mergePeers(bc.bootstrapPeers, bc.knownPeers)
+	seeds := bc.dnsSeeds
+	dial := bc.transport.DialContext
+	bc.muxNeighbors.Unlock()
+	if len(seeds) > 0 {
+		candidates = mergePeers(candidates, ResolveSeeds(seeds))
+	}
+
+	host := httputil.GetHost()
+	neighbors := mergePeers(FindNeighbors(host, bc.port, r), ReachablePeers(candidates, host, bc.port, dial))
+	var learned []string
+	for _, n := range neighbors {
+		learned = mergePeers(learned, bc.fetchPeers(n))
+	}
+	neighbors = mergePeers(neighbors, ReachablePeers(slices.DeleteFunc(learned, func(p string) bool {
+		return slices.Contains(neighbors, p)
+	}), host, bc.port, dial))
+	if d := bc.DHT(); d != nil {
+		d.Bootstrap(context.Background(), neighbors)
+		d.Refresh(context.Background())
+		var found []string
+		for _, c := range d.Contacts() {
+			if !slices.Contains(neighbors, c.Address) {
+				found = append(found, c.Address)
+			}
+		}
+		neighbors = mergePeers(neighbors, ReachablePeers(found, host, bc.port, dial))
+	}
+
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	bc.neighbors = neighbors
+	known := mergePeers(neighbors, bc.knownPeers)
+	if len(known) > MAX_KNOWN_PEERS {
+		known = known[:MAX_KNOWN_PEERS]
+	}
+	if !slices.Equal(known, bc.knownPeers) {
+		bc.knownPeers = known
+		if bc.storage != nil {
+			if err := bc.storage.SavePeers(known); err != nil {
+				log.Printf("action=save_peers, status=fail, err=%v", err)
+			}
+		}
+	}
+	log.Printf("action=sync_neighbors, neighbors=%v", bc.neighbors)
+}
+
+// fetchPeers asks neighbor n for its neighbors through GET /peers; errors yield none.
+func (bc *Blockchain) fetchPeers(n string) []string {
+	req, client := bc.neighborRequest(context.Background(), http.MethodGet, n, "/peers", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Peers []string `json:"peers"`
+	}
+	if resp.StatusCode != http.StatusOK || decodePeer(resp.Body, resp.Header.Get("Content-Type"), WIRE_MAX_RESPONSE_SIZE, &body) != nil {
+		return nil
+	}
+	peers, err := ParsePeers(strings.Join(body.Peers, ","))
+	if err != nil {
+		log.Printf("action=fetch_peers, neighbor=%s, err=%v", n, err)
+		return nil
+	}
+	return peers
+}
+
+// StartSyncNeighbors syncs neighbors now and reschedules itself every BLOCKCHAIN_NEIGHBOR_SYNC_TIME_SEC seconds.
+func (bc *Blockchain) StartSyncNeighbors() {
+	bc.SyncNeighbors()
+	_ = time.AfterFunc(time.Second*BLOCKCHAIN_NEIGHBOR_SYNC_TIME_SEC, bc.StartSyncNeighbors)
+}
+
+// CreateBlock creates a new block from the current transaction pool, ordered by descending fee, and appends it to the chain.
+// Transactions beyond the block limits stay in the pool.
+func (bc *Blockchain) CreateBlock(nonce int, previousHash [32]byte) *block.Block {
+	_, span := trace.StartSpan(context.Background(), "create_block", trace.SPAN_KIND_INTERNAL)
+	defer span.Finish()
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	span.SetAttribute("block.height", len(bc.chain))
+	span.SetAttribute("block.transactions", len(bc.transactionPool)+1)
+	return bc.createBlock(nonce, previousHash)
+}
+
+// createBlock is CreateBlock without locking; callers must hold mux.
+func (bc *Blockchain) createBlock(nonce int, previousHash [32]byte) *block.Block {
+	b := block.NewBlock(nonce, previousHash, bc.selectTransactions())
+	b.SetRoots(blockStateRoot(bc.balances, bc.contracts, b.Transactions()), blockAccountsRoot(bc.accounts, bc.balances, bc.nonces, bc.contracts, b.Transactions()))
+	bc.appendBlock(b)
+	bc.removeFromPool(b.Transactions())
+	return b
+}
+
+// appendBlock adds b at the tip, updates the indexes, persists it, and publishes a block event. Callers must hold mux.
+func (bc *Blockchain) appendBlock(b *block.Block) {
+	bc.chain = append(bc.chain, b)
+	bc.indexBlock(len(bc.chain)-1, b)
+	bc.events.Publish(Event{Type: EVENT_NEW_BLOCK, Data: BlockInfo{Height: len(bc.chain) - 1, Hash: fmt.Sprintf("%x", bc.BlockHash(b)), Block: b}})
+	if bc.storage != nil && len(bc.chain) > 1 {
+		if err := bc.storage.Blocks().PutBlock(b); err != nil {
+			log.Printf("action=save_block, status=fail, err=%v", err)
+		}
+	}
+	// The block may fund transactions held as orphans; retry them once the caller releases mux.
+	go bc.retryOrphanTransactions()
+}
+
+// setChain replaces the chain and discards the indexes, rebuilding them from the new blocks.
+// Callers must hold mux.
+func (bc *Blockchain) setChain(chain []*block.Block) {
+	bc.chain = chain
+	bc.blockIndex = make(map[[32]byte]int, len(chain))
+	bc.txIndex = make(map[[32]byte]int)
+	bc.balances = make(map[string]transaction.Amount)
+	bc.contracts = make(map[string]*Contract)
+	bc.receipts = make(map[[32]byte]Receipt)
+	bc.tokens = make(map[string]*Token)
+	bc.assets = make(map[string]*Asset)
+	bc.nonces = make(map[string]uint64)
+	bc.accounts = nil
+	bc.accountHistory = make([]*stateTrie, 0, len(chain))
+	bc.issued = 0
+	if s := bc.snapshot; s != nil && len(chain) > s.Height && chain[s.Height].Pruned() {
+		// The pruned headers carry no transactions; the snapshot holds the state they led to.
+		maps.Copy(bc.balances, s.Balances)
+		bc.contracts = cloneContracts(s.Contracts)
+		bc.tokens = cloneTokens(s.Tokens)
+		bc.assets = cloneAssets(s.Assets)
+		maps.Copy(bc.nonces, s.Nonces)
+		bc.accounts = newAccountsTrie(bc.balances, bc.nonces)
+		bc.issued = s.Issued
+	}
+	for height, b := range chain {
+		bc.indexBlock(height, b)
+	}
+	if s := bc.snapshot; s != nil && len(chain) > s.Height && chain[s.Height].Pruned() {
+		// The state after the pruned headers below the snapshot's block is gone.
+		clear(bc.accountHistory[:s.Height])
+	}
+}
+
+// indexBlock stores and records the block's hash, applies its transactions to the balances, contracts, tokens, assets, and accounts
+// trie, keeping the receipts and the trie for the height, and adds its coinbase to the issued supply. Callers must hold mux.
+func (bc *Blockchain) indexBlock(height int, b *block.Block) {
+	bc.blockIndex[b.CacheHash(bc.Hasher())] = height
+	for _, t := range b.Transactions() {
+		bc.txIndex[t.Hash()] = height
+	}
+	bc.issued += blockIssuance(b)
+	applyNonces(bc.nonces, b.Transactions())
+	for _, r := range applyTransactions(bc.balances, bc.contracts, bc.tokens, bc.assets, b.Transactions()) {
+		h, _ := transaction.HashFromString(r.TransactionID)
+		bc.receipts[h] = r
+	}
+	bc.accounts = bc.accounts.withAccounts(bc.balances, bc.nonces, touchedAccounts(b.Transactions()))
+	bc.accountHistory = append(bc.accountHistory, bc.accounts)
+}
+
+// transactionSize returns the length of t's JSON encoding, what it adds to the size of a block.
+func transactionSize(t *transaction.Transaction) int {
+	m, _ := json.Marshal(t)
+	return len(m)
+}
+
+// transactionsSize returns the total transactionSize of transactions.
+func transactionsSize(transactions []*transaction.Transaction) int {
+	size := 0
+	for _, t := range transactions {
+		size += transactionSize(t)
+	}
+	return size
+}
+
+// applyTransactions debits each sender, value plus fee, and credits each recipient in balances, except that
+// contract transactions are applied to contracts by applyContract, token transactions to tokens by applyToken,
+// and asset transactions to assets by applyAsset, unless tokens and assets are nil. It returns the contract
+// transactions' receipts.
+func applyTransactions(balances map[string]transaction.Amount, contracts map[string]*Contract, tokens map[string]*Token, assets map[string]*Asset, transactions []*transaction.Transaction) []Receipt {
+	var receipts []Receipt
+	for _, t := range transactions {
+		balances[t.SenderBlockchainAddress()] -= t.Value() + t.Fee()
+		if t.Token() != nil {
+			if tokens != nil {
+				applyToken(tokens, t)
+			}
+		}
+		if t.Asset() != nil && assets != nil {
+			applyAsset(assets, t)
+		}
+		if t.ContractType() != "" {
+			receipts = append(receipts, applyContract(balances, contracts, t))
+			continue
+		}
+		balances[t.RecipientBlockchainAddress()] += t.Value()
+	}
+	return receipts
+}
+
+// TransactionPool returns the pending transactions that have not been mined yet.
+func (bc *Blockchain) TransactionPool() []*transaction.Transaction {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return append([]*transaction.Transaction(nil), bc.transactionPool...)
+}
+
+// MarshalJSON provides a custom JSON representation for the Blockchain's chain, pending pool, miner address, and hasher name.
+func (bc *Blockchain) MarshalJSON() ([]byte, error) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return json.Marshal(struct {
+		Blocks            []*block.Block             `json:"chain"`
+		TransactionPool   []*transaction.Transaction `json:"transaction_pool"`
+		BlockchainAddress string                     `json:"blockchain_address"`
+		Hasher            string                     `json:"hasher"`
+		ChainID           string                     `json:"chain_id,omitempty"`
+	}{
+		Blocks:            bc.chain,
+		TransactionPool:   bc.transactionPool,
+		BlockchainAddress: bc.blockchainAddress,
+		Hasher:            bc.Hasher().Name(),
+		ChainID:           bc.chainID,
+	})
+}
+
+// UnmarshalJSON restores the chain, pending pool, miner address, hasher, and chain ID from the representation produced by MarshalJSON.
+// A missing hasher name means SHA-256, and a missing chain ID a node using its own genesis block.
+func (bc *Blockchain) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Blocks            *[]*block.Block            `json:"chain"`
+		TransactionPool   []*transaction.Transaction `json:"transaction_pool"`
+		BlockchainAddress string                     `json:"blockchain_address"`
+		Hasher            string                     `json:"hasher"`
+		ChainID           string                     `json:"chain_id"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Blocks == nil {
+		return errors.New("blockchain is missing chain")
+	}
+	hasher, err := block.HasherByName(v.Hasher)
+	if err != nil {
+		return err
+	}
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.hasher = hasher
+	bc.chainID = v.ChainID
+	bc.setChain(*v.Blocks)
+	bc.transactionPool = v.TransactionPool
+	if bc.transactionPool == nil {
+		bc.transactionPool = []*transaction.Transaction{}
+	}
+	bc.blockchainAddress = v.BlockchainAddress
+	return nil
+}
+
+// Hasher returns the hash function used for block headers and proof-of-work.
+func (bc *Blockchain) Hasher() block.Hasher {
+	if bc.hasher == nil {
+		return block.SHA256Hasher{}
+	}
+	return bc.hasher
+}
+
+// Consensus returns the engine that seals and verifies blocks; chains built without one use ProofOfWork.
+func (bc *Blockchain) Consensus() block.Consensus {
+	if bc.consensus == nil {
+		return block.NewProofOfWork(block.MINING_DIFFICULTY, bc.Hasher())
+	}
+	return bc.consensus
+}
+
+// SetConsensus replaces the consensus engine that seals and verifies blocks.
+func (bc *Blockchain) SetConsensus(c block.Consensus) {
+	bc.consensus = c
+}
+
+// ChainID returns the chain ID of the configured genesis, or "" for a node using its own genesis block.
+func (bc *Blockchain) ChainID() string {
+	return bc.chainID
+}
+
+// SetChainID sets the chain ID transactions must be signed for, as a chain decoded without a genesis
+// config does not know it.
+func (bc *Blockchain) SetChainID(chainID string) {
+	bc.chainID = chainID
+}
+
+// GenesisHash returns the hash of the first block of the local chain.
+func (bc *Blockchain) GenesisHash() [32]byte {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.BlockHash(bc.chain[0])
+}
+
+// BlockHash returns the hash of the block header under the chain's hasher, the stored one for blocks in a chain.
+func (bc *Blockchain) BlockHash(b *block.Block) [32]byte {
+	return b.HashWithCache(bc.Hasher())
+}
+
+// LastBlock returns the most recently added block in the chain.
+func (bc *Blockchain) LastBlock() *block.Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.lastBlock()
+}
+
+// lastBlock is LastBlock without locking; callers must hold mux.
+func (bc *Blockchain) lastBlock() *block.Block {
+	return bc.chain[len(bc.chain)-1]
+}
+
+// MedianTimePast returns the median timestamp of the last MEDIAN_TIME_SPAN blocks; the next block must be later.
+func (bc *Blockchain) MedianTimePast() int64 {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return medianTimePast(bc.chain)
+}
+
+// medianTimePast returns the median timestamp of the last MEDIAN_TIME_SPAN blocks of chain, or of all of them
+// when it is shorter, as Bitcoin does, and 0 for the empty chain the genesis block is mined on.
+func medianTimePast(chain []*block.Block) int64 {
+	if len(chain) == 0 {
+		return 0
+	}
+	recent := chain[max(len(chain)-MEDIAN_TIME_SPAN, 0):]
+	timestamps := make([]int64, len(recent))
+	for i, b := range recent {
+		timestamps[i] = b.Timestamp()
+	}
+	slices.Sort(timestamps)
+	return timestamps[len(timestamps)/2]
+}
+
+// Subscribe returns a channel of block, transaction, and chain replacement events and a function that
+// unsubscribes. Events published while the subscriber's buffer is full are dropped.
+func (bc *Blockchain) Subscribe() (<-chan Event, func()) {
+	return bc.events.Subscribe()
+}
+
+// BlockByHeight returns the block at height, where the genesis block has height 0.
+func (bc *Blockchain) BlockByHeight(height int) (*block.Block, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	if height < 0 || height >= len(bc.chain) {
+		return nil, false
+	}
+	return bc.chain[height], true
+}
+
+// Blocks returns up to limit blocks starting at height offset, genesis first, together with the chain length.
+// Out-of-range offsets yield an empty page.
+func (bc *Blockchain) Blocks(offset, limit int) ([]*block.Block, int) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	length := len(bc.chain)
+	if offset < 0 || offset >= length || limit <= 0 {
+		return []*block.Block{}, length
+	}
+	end := min(offset+limit, length)
+	return append([]*block.Block(nil), bc.chain[offset:end]...), length
+}
+
+// BlockByHash returns the block with the given header hash and its height, using the block index.
+func (bc *Blockchain) BlockByHash(hash [32]byte) (*block.Block, int, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	height, ok := bc.blockIndex[hash]
+	if !ok {
+		return nil, 0, false
+	}
+	return bc.chain[height], height, true
+}
+
+// Print outputs the entire blockchain to stdout in a readable format.
+func (bc *Blockchain) Print() {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	for i, block := range bc.chain {
+		fmt.Printf("%s Chain %d %s\n", strings.Repeat("=", 25), i, strings.Repeat("=", 25))
+		block.Print()
+	}
+	fmt.Printf("%s\n", strings.Repeat("*", 25))
+}
+
+// AddTransaction verifies the transaction's signature against its sender address and adds it to the transaction pool.
+// A transaction whose ID is already pooled or confirmed is rejected with ErrDuplicateTransaction. The sender's confirmed balance, minus what its pending transactions already spend, must cover the value and fee.
+func (bc *Blockchain) AddTransaction(t *transaction.Transaction) (err error) {
+	_, span := trace.StartSpan(context.Background(), "add_transaction", trace.SPAN_KIND_INTERNAL)
+	defer func() {
+		span.SetError(err)
+		span.Finish()
+	}()
+	span.SetAttribute("transaction.id", t.ID())
+	if err := t.CheckAmounts(); err != nil {
+		log.Printf("action=add_transaction, status=rejected, err=%v", err)
+		return err
+	}
+	if err := checkContractCode(t); err != nil {
+		log.Printf("action=add_transaction, status=rejected, err=%v", err)
+		return err
+	}
+	if err := t.CheckVersion(); err != nil {
+		log.Printf("action=add_transaction, status=rejected, err=%v", err)
+		return err
+	}
+	if err := t.CheckReplayProtected(bc.ChainID()); err != nil {
+		log.Printf("action=add_transaction, status=rejected, err=%v", err)
+		return err
+	}
+	if err := t.CheckChainID(bc.ChainID()); err != nil {
+		log.Printf("action=add_transaction, status=rejected, err=%v", err)
+		return err
+	}
+	if err := bc.signatures.Verify(t); err != nil {
+		log.Printf("action=add_transaction, status=rejected, err=%v", err)
+		return err
+	}
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	if bc.hasTransaction(t.Hash()) {
+		log.Printf("action=add_transaction, status=rejected, id=%s, err=%v", t.ID(), ErrDuplicateTransaction)
+		return ErrDuplicateTransaction
+	}
+	if _, ok := bc.contracts[t.RecipientBlockchainAddress()]; t.ContractType() == transaction.CONTRACT_CALL && !ok {
+		// A block could confirm the call, but it would only burn the fee.
+		log.Printf("action=add_transaction, status=rejected, recipient=%s, err=%v", t.RecipientBlockchainAddress(), ErrUnknownContract)
+		return ErrUnknownContract
+	}
+	if n := bc.nextNonce(t.SenderBlockchainAddress()); t.HasNonce() && t.Nonce() != n {
+		err := fmt.Errorf("%w: %d, expected %d", transaction.ErrInvalidNonce, t.Nonce(), n)
+		log.Printf("action=add_transaction, status=rejected, sender=%s, err=%v", t.SenderBlockchainAddress(), err)
+		return err
+	}
+	if t.HTLC() != nil && t.HTLC().Preimage() == nil && !t.IsFinal(len(bc.chain), medianTimePast(bc.chain)) {
+		// A pooled refund would reserve the coins and keep the claim out until the timeout.
+		err := t.CheckFinal(len(bc.chain), medianTimePast(bc.chain))
+		log.Printf("action=add_transaction, status=rejected, sender=%s, err=%v", t.SenderBlockchainAddress(), err)
+		return err
+	}
+	if t.Token() != nil {
+		if err := bc.admitToken(t); err != nil {
+			log.Printf("action=add_transaction, status=rejected, sender=%s, err=%v", t.SenderBlockchainAddress(), err)
+			return err
+		}
+	}
+	if t.Asset() != nil {
+		if err := bc.admitAsset(t); err != nil {
+			log.Printf("action=add_transaction, status=rejected, sender=%s, err=%v", t.SenderBlockchainAddress(), err)
+			return err
+		}
+	}
+	cost, err := t.Cost()
+	if err != nil {
+		log.Printf("action=add_transaction, status=rejected, sender=%s, err=%v", t.SenderBlockchainAddress(), err)
+		return err
+	}
+	if bc.spendableAmount(t.SenderBlockchainAddress()) < cost {
+		log.Printf("action=add_transaction, status=rejected, sender=%s, err=%v", t.SenderBlockchainAddress(), ErrInsufficientBalance)
+		return ErrInsufficientBalance
+	}
+	if bc.maxPoolSize > 0 && len(bc.transactionPool) >= bc.maxPoolSize {
+		victim := bc.transactionPool[bc.evictionCandidate()]
+		// t's nonce follows the victim's when the victim is its sender's last, and would be left without it.
+		if t.Fee() <= victim.Fee() || (t.HasNonce() && victim.HasNonce() && victim.SenderBlockchainAddress() == t.SenderBlockchainAddress()) {
+			log.Printf("action=add_transaction, status=rejected, err=%v", ErrTransactionPoolFull)
+			return ErrTransactionPoolFull
+		}
+		for _, e := range bc.dropFromPool(victim) {
+			log.Printf("action=evict_transaction, id=%s, sender=%s, fee=%s", e.ID(), e.SenderBlockchainAddress(), e.Fee())
+		}
+	}
+	bc.transactionPool = append(bc.transactionPool, t)
+	bc.pooledAt[t.Hash()] = time.Now()
+	delete(bc.expired, t.Hash())
+	bc.events.Publish(Event{Type: EVENT_NEW_TRANSACTION, Data: TransactionInfo{TransactionID: t.ID(), Transaction: t}})
+	return nil
+}
+
+// hasTransaction reports whether a transaction with this hash is in the pool or in any block. Callers must hold mux.
+func (bc *Blockchain) hasTransaction(hash [32]byte) bool {
+	for _, t := range bc.transactionPool {
+		if t.Hash() == hash {
+			return true
+		}
+	}
+	return bc.inChain(hash)
+}
+
+// inChain reports whether a transaction with this hash is in any block. Callers must hold mux.
+func (bc *Blockchain) inChain(hash [32]byte) bool {
+	_, ok := bc.txIndex[hash]
+	return ok
+}
+
+// spendableAmount is the sender's confirmed balance minus the value and fees of its pending transactions.
+// Callers must hold mux.
+func (bc *Blockchain) spendableAmount(blockchainAddress string) transaction.Amount {
+	amount := bc.calculateTotalAmount(blockchainAddress)
+	for _, t := range bc.transactionPool {
+		if t.SenderBlockchainAddress() == blockchainAddress {
+			// Pooled transactions passed Cost when they were added.
+			cost, _ := t.Cost()
+			amount -= cost
+		}
+	}
+	return amount
+}
+
+// evictionCandidate returns the index of the pooled transaction to drop when the pool is full: the lowest
+// fee, and among equal fees the oldest, of those no other pooled transaction needs, each sender's highest
+// nonce and unnonced ones. Callers must hold mux and the pool must be non-empty.
+func (bc *Blockchain) evictionCandidate() int {
+	last := make(map[string]int) // the index of each sender's highest pooled nonce
+	for i, t := range bc.transactionPool {
+		if j, ok := last[t.SenderBlockchainAddress()]; t.HasNonce() && (!ok || t.Nonce() > bc.transactionPool[j].Nonce()) {
+			last[t.SenderBlockchainAddress()] = i
+		}
+	}
+	victim := -1
+	for i, t := range bc.transactionPool {
+		if t.HasNonce() && last[t.SenderBlockchainAddress()] != i {
+			continue
+		}
+		if victim < 0 || t.Fee() < bc.transactionPool[victim].Fee() {
+			victim = i
+		}
+	}
+	return victim
+}
+
+// SetHalvingInterval sets how many blocks pass between halvings of the block reward; zero or less disables halving.
+func (bc *Blockchain) SetHalvingInterval(n int) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.halvingInterval = n
+}
+
+// SetMaxSupply caps the coins coinbase transactions may ever issue; zero or less means no cap.
+func (bc *Blockchain) SetMaxSupply(a transaction.Amount) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.maxSupply = a
+}
+
+// SetMaxBlockTransactions limits how many transactions, coinbase included, a block may hold; zero or less
+// means no limit.
+func (bc *Blockchain) SetMaxBlockTransactions(n int) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.maxBlockTxs = n
+}
+
+// SetMaxBlockSize limits the JSON-serialized size of a block's transactions in bytes; zero or less means no limit.
+func (bc *Blockchain) SetMaxBlockSize(n int) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.maxBlockSize = n
+}
+
+// IssuedSupply returns the coins issued by coinbase transactions on the local chain, net of the fees they collect.
+func (bc *Blockchain) IssuedSupply() transaction.Amount {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.issued
+}
+
+// SetMaxTransactionPoolSize limits how many transactions the pool holds; zero or less means unbounded.
+func (bc *Blockchain) SetMaxTransactionPoolSize(n int) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.maxPoolSize = n
+}
+
+// SetSignatureCacheSize sets how many verified transactions the node remembers so that it does not verify
+// their signatures again; zero or less verifies every time. It must be called before Run.
+func (bc *Blockchain) SetSignatureCacheSize(n int) {
+	bc.signatures = NewSignatureCache(n)
+}
+
+// SetVerifyWorkers sets how many goroutines verify the signatures of a block's transactions in parallel;
+// zero or less means GOMAXPROCS. It must be called before Run.
+func (bc *Blockchain) SetVerifyWorkers(n int) {
+	bc.verifyWorkers = n
+}
+
+// CreateTransaction adds a signed transaction, submitted by a client or relayed by a neighbor, to the pool and
+// gossips it to every neighbor. A transaction already in the seen-cache fails with ErrDuplicateTransaction
+// without being verified again, which ends the relays once every node has it.
+func (bc *Blockchain) CreateTransaction(t *transaction.Transaction) error {
+	if !bc.seenTransactions.add(t.ID(), time.Now()) {
+		return ErrDuplicateTransaction
+	}
+	if err := bc.AddTransaction(t); err != nil {
+		if !errors.Is(err, ErrDuplicateTransaction) {
+			bc.seenTransactions.remove(t.ID())
+		}
+		return err
+	}
+	bc.gossipTransaction(t)
+	return nil
+}
+
+// ClearTransactionPool drops every pending transaction.
+func (bc *Blockchain) ClearTransactionPool() {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.transactionPool = []*transaction.Transaction{}
+	clear(bc.pooledAt)
+}
+
+// CopyTransactionPool creates a deep copy of the current transaction pool and returns it as a slice of transactions.
+func (bc *Blockchain) CopyTransactionPool() []*transaction.Transaction {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.copyTransactionPool()
+}
+
+// copyTransactionPool is CopyTransactionPool without locking; callers must hold mux.
+func (bc *Blockchain) copyTransactionPool() []*transaction.Transaction {
+	transactions := make([]*transaction.Transaction, 0)
+	for _, t := range bc.transactionPool {
+		tc := *t
+		transactions = append(transactions, &tc)
+	}
+	return transactions
+}
+
+// selectTransactions returns the pending transactions to include in the next block, highest fee first.
+// The sort is stable, so equal-fee transactions (including the zero-fee mining reward appended last) keep
+// their arrival order. Selection stops at the block limits; the rest stay pooled, as do transactions whose
+// lock time has not passed. Callers must hold mux.
+func (bc *Blockchain) selectTransactions() []*transaction.Transaction {
+	transactions := bc.copyTransactionPool()
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].Fee() > transactions[j].Fee()
+	})
+	// A sender's later nonce may pay more, but must follow the earlier ones.
+	sortNonces(transactions)
+	// Each transaction was affordable when it was pooled, but a block received since may have spent the
+	// sender's balance, and peers reject a block that overdraws an account.
+	spent := make(map[string]transaction.Amount)
+	tokens := newTokenSpends(bc.tokens)
+	assets := newAssetMoves(bc.assets)
+	nonces := newNonceSequence(bc.nonces)
+	selected := transactions[:0]
+	height, mtp := len(bc.chain), medianTimePast(bc.chain)
+	// Leave room for the coinbase, at its largest possible value.
+	size := transactionSize(transaction.NewTransaction(transaction.MINING_SENDER, bc.blockchainAddress, math.MaxInt64, 0))
+	for _, t := range transactions {
+		if bc.maxBlockTxs > 0 && len(selected)+1 >= bc.maxBlockTxs {
+			break
+		}
+		s := transactionSize(t)
+		if bc.maxBlockSize > 0 && size+s > bc.maxBlockSize {
+			// A smaller transaction further down may still fit.
+			continue
+		}
+		if !t.IsFinal(height, mtp) {
+			// Time-locked transactions wait in the pool for their height or time.
+			continue
+		}
+		if t.SenderBlockchainAddress() != transaction.MINING_SENDER {
+			cost, err := t.Cost()
+			if err != nil || bc.calculateTotalAmount(t.SenderBlockchainAddress())-spent[t.SenderBlockchainAddress()] < cost {
+				continue
+			}
+			if t.HasNonce() && t.Nonce() != nonces.expected(t.SenderBlockchainAddress()) {
+				// An earlier nonce was left out, so the later ones wait for the next block.
+				continue
+			}
+			if t.Token() != nil && tokens.admit(t) != nil || t.Asset() != nil && assets.admit(t) != nil {
+				continue
+			}
+			nonces.admit(t)
+			spent[t.SenderBlockchainAddress()] += cost
+		}
+		size += s
+		selected = append(selected, t)
+	}
+	return selected
+}
+
+// SetMiningWorkers sets how many goroutines search for a nonce in parallel when the consensus engine
+// is ProofOfWork; zero or less means GOMAXPROCS.
+func (bc *Blockchain) SetMiningWorkers(n int) {
+	if p, ok := bc.Consensus().(*block.ProofOfWork); ok {
+		p.SetWorkers(n)
+	}
+}
+
+// Mining executes the mining process, rewards the miner, and adds a new block to the blockchain. Returns true on success.
+// When the transaction pool is empty no block is produced and no reward is paid, so idle nodes don't inflate the chain.
+// Only one mining run happens at a time; a concurrent call returns false immediately.
+// If consensus adopts a new chain tip meanwhile, the nonce search is abandoned and restarted on the new tip.
+// Cancelling ctx abandons the nonce search and returns false.
+func (bc *Blockchain) Mining(ctx context.Context) bool {
+	if !bc.muxMine.TryLock() {
+		log.Println("action=mining, status=skipped, reason=already mining")
+		return false
+	}
+	defer bc.muxMine.Unlock()
+	ctx, span := trace.StartSpan(ctx, "mining", trace.SPAN_KIND_INTERNAL)
+	defer span.Finish()
+
+	var b *block.Block
+	for {
+		var err error
+		b, err = bc.mineBlock(ctx)
+		span.SetError(err)
+		if errors.Is(err, ErrStaleTip) && ctx.Err() == nil {
+			log.Println("action=mining, status=restarted, reason=new chain tip")
+			continue
+		}
+		if errors.Is(err, ErrEmptyTransactionPool) {
+			log.Println("action=mining, status=skipped, reason=empty transaction pool")
+			return false
+		}
+		if err != nil {
+			log.Printf("action=mining, status=aborted, err=%v", err)
+			return false
+		}
+		break
+	}
+	log.Println("action=mining, status=success")
+
+	// Neighbors may fetch our chain or ask for missing transactions, so this must run without holding mux.
+	bc.announceBlock(ctx, b)
+	return true
+}
+
+// mineBlock assembles a candidate block (pending transactions plus the reward) on the current tip, seals it
+// with the consensus engine without holding mux so the chain stays readable and replaceable, and appends the
+// block only if the tip is still the one it was built on. Otherwise it returns ErrStaleTip.
+func (bc *Blockchain) mineBlock(ctx context.Context) (_ *block.Block, err error) {
+	ctx, span := trace.StartSpan(ctx, "mine_block", trace.SPAN_KIND_INTERNAL)
+	defer func() {
+		span.SetError(err)
+		span.Finish()
+	}()
+	bc.mux.Lock()
+	if bc.closed {
+		bc.mux.Unlock()
+		return nil, ErrBlockchainClosed
+	}
+	if len(bc.transactionPool) == 0 {
+		bc.mux.Unlock()
+		return nil, ErrEmptyTransactionPool
+	}
+	b := bc.candidateBlock(bc.blockchainAddress)
+	height, previousHash := len(bc.chain), b.PreviousHash()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	bc.miningCancel = cancel
+	bc.mux.Unlock()
+	span.SetAttribute("block.height", height)
+	span.SetAttribute("block.transactions", len(b.Transactions()))
+
+	err = bc.Consensus().Seal(ctx, height, b)
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.miningCancel = nil
+	if bc.BlockHash(bc.lastBlock()) != previousHash {
+		return nil, ErrStaleTip
+	}
+	if err != nil {
+		return nil, err
+	}
+	bc.appendBlock(b)
+	bc.removeFromPool(b.Transactions())
+	return b, nil
+}
+
+// candidateBlock assembles an unsealed block on the current tip: the pending transactions selectTransactions
+// picks, then the reward and their fees paid to address. Callers must hold mux.
+func (bc *Blockchain) candidateBlock(address string) *block.Block {
+	transactions := bc.selectTransactions()
+	reward := CappedReward(BlockReward(len(bc.chain), bc.halvingInterval), bc.issued, bc.maxSupply)
+	for i, t := range transactions {
+		if t.Fee() > math.MaxInt64-reward {
+			// The coinbase cannot pay any more fees; the rest wait for a later block.
+			transactions = transactions[:i]
+			break
+		}
+		reward += t.Fee()
+	}
+	// The reward pays no fee, so appending it after the fee-ordered pool matches selectTransactions' order.
+	transactions = append(transactions, transaction.NewTransaction(transaction.MINING_SENDER, address, reward, 0))
+	b := block.NewBlock(0, bc.BlockHash(bc.lastBlock()), transactions)
+	// Peers' clocks may run ahead of ours; a block must still be later than the median time past.
+	b.SetTimestamp(max(b.Timestamp(), medianTimePast(bc.chain)+1))
+	b.SetRoots(blockStateRoot(bc.balances, bc.contracts, transactions), blockAccountsRoot(bc.accounts, bc.balances, bc.nonces, bc.contracts, transactions))
+	return b
+}
+
+// removeFromPool drops the given transactions from the pool, keeping any that arrived since, and those whose
+// nonce a confirmed transaction has used. Callers must hold mux.
+func (bc *Blockchain) removeFromPool(transactions []*transaction.Transaction) {
+	remove := make(map[[32]byte]bool, len(transactions))
+	for _, t := range transactions {
+		remove[t.Hash()] = true
+	}
+	pool := make([]*transaction.Transaction, 0, len(bc.transactionPool))
+	for _, t := range bc.transactionPool {
+		if !remove[t.Hash()] && !(t.HasNonce() && t.Nonce() < bc.nonces[t.SenderBlockchainAddress()]) {
+			pool = append(pool, t)
+		}
+	}
+	bc.transactionPool = pool
+}
+
+// StartMining mines a block every interval in the background until StopMining is called, which also
+// abandons a nonce search in progress. Calling it while mining is already running has no effect and returns false.
+func (bc *Blockchain) StartMining(interval time.Duration) bool {
+	bc.muxMining.Lock()
+	defer bc.muxMining.Unlock()
+	if bc.miningStop != nil {
+		return false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	bc.miningStop = cancel
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				bc.Mining(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	log.Printf("action=start_mining, interval=%s", interval)
+	return true
+}
+
+// StopMining halts background mining started by StartMining. Returns false if it was not running.
+func (bc *Blockchain) StopMining() bool {
+	bc.muxMining.Lock()
+	defer bc.muxMining.Unlock()
+	if bc.miningStop == nil {
+		return false
+	}
+	bc.miningStop()
+	bc.miningStop = nil
+	log.Println("action=stop_mining")
+	return true
+}
+
+// Close prepares the node to exit: it stops background mining, cancels the block being sealed, and makes
+// later mining fail with ErrBlockchainClosed. The chain needs no flushing, as each block is synced to disk
+// when it is added; the transaction pool is saved by SaveTransactionPool.
+func (bc *Blockchain) Close() {
+	bc.StopMining()
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.closed = true
+	if bc.miningCancel != nil {
+		bc.miningCancel()
+	}
+}
+
+// CloseStorage closes the block store, such as a Bolt file, which only one process may have open. The node
+// must not add blocks after it.
+func (bc *Blockchain) CloseStorage() error {
+	if bc.storage == nil {
+		return nil
+	}
+	return bc.storage.Close()
+}
+
+// SaveTransactionPool writes the pending transactions to storage, from which the next NewBlockchain
+// reloads them. It does nothing for an in-memory node.
+func (bc *Blockchain) SaveTransactionPool() error {
+	if bc.storage == nil {
+		return nil
+	}
+	pool := bc.TransactionPool()
+	if err := bc.storage.SaveTransactions(pool); err != nil {
+		return err
+	}
+	log.Printf("action=save_transaction_pool, status=success, path=%s, length=%d", bc.storage.PoolPath(), len(pool))
+	return nil
+}
+
+// loadTransactionPool re-adds the transactions saved by SaveTransactionPool, verifying each one again;
+// those confirmed or no longer affordable in the meantime are dropped.
+func (bc *Blockchain) loadTransactionPool() {
+	pool, err := bc.storage.LoadTransactions()
+	if err != nil {
+		log.Printf("action=load_transaction_pool, status=fail, path=%s, err=%v", bc.storage.PoolPath(), err)
+		return
+	}
+	loaded := 0
+	for _, t := range pool {
+		if bc.AddTransaction(t) == nil {
+			loaded++
+		}
+	}
+	if len(pool) > 0 {
+		log.Printf("action=load_transaction_pool, status=success, path=%s, loaded=%d, dropped=%d", bc.storage.PoolPath(), loaded, len(pool)-loaded)
+	}
+}
+
+// IsMining reports whether background mining is running.
+func (bc *Blockchain) IsMining() bool {
+	bc.muxMining.Lock()
+	defer bc.muxMining.Unlock()
+	return bc.miningStop != nil
+}
+
+// NodeStatus summarizes a node's state for GET /status and the dashboard.
+type NodeStatus struct {
+	Height              int                 `json:"height"`
+	TipHash             string              `json:"tip_hash"`
+	MedianTimePast      int64               `json:"median_time_past"`
+	TransactionPoolSize int                 `json:"transaction_pool_size"`
+	PoolTTL             string              `json:"pool_ttl"`
+	ExpiredTransactions int                 `json:"expired_transactions"`
+	Orphans             int                 `json:"orphans"`
+	OrphanTransactions  int                 `json:"orphan_transactions"`
+	Neighbors           []string            `json:"neighbors"`
+	Mining              bool                `json:"mining"`
+	Consensus           string              `json:"consensus"`
+	ChainID             string              `json:"chain_id"`
+	Hashes              uint64              `json:"hashes"`
+	SignatureCache      SignatureCacheStats `json:"signature_cache"`
+}
+
+// Status returns the tip, pool size and TTL, expired, orphan block, and orphan transaction counts, neighbors, mining state, chain ID, and
+// signature cache statistics. Hashes counts the nonces tried so far under ProofOfWork and stays zero under other engines.
+func (bc *Blockchain) Status() NodeStatus {
+	s := NodeStatus{
+		Neighbors:          bc.Neighbors(),
+		Mining:             bc.IsMining(),
+		Consensus:          bc.Consensus().Name(),
+		ChainID:            bc.ChainID(),
+		Orphans:            bc.Orphans(),
+		OrphanTransactions: bc.OrphanTransactions(),
+		SignatureCache:     bc.signatures.Stats(),
+	}
+	if pow, ok := bc.Consensus().(*block.ProofOfWork); ok {
+		s.Hashes = pow.Hashes()
+	}
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	s.Height = len(bc.chain) - 1
+	s.TipHash = fmt.Sprintf("%x", bc.BlockHash(bc.lastBlock()))
+	s.MedianTimePast = medianTimePast(bc.chain)
+	s.TransactionPoolSize = len(bc.transactionPool)
+	s.PoolTTL = bc.poolTTL.String()
+	s.ExpiredTransactions = len(bc.expired)
+	return s
+}
+
+// ValidChain walks the chain and reports whether every block links to its predecessor's hash
+// and every non-genesis block is validly sealed according to the consensus engine and pays no more than the
+// halving schedule and the supply cap allow. Any edit to a past block breaks the
+// link to its successor, so this also detects tampering with the local chain.
+func (bc *Blockchain) ValidChain(chain []*block.Block) bool {
+	if len(chain) == 0 {
+		return false
+	}
+	bc.mux.RLock()
+	rules, snapshot := bc.rules(), bc.snapshot
+	bc.mux.RUnlock()
+	if err := snapshot.checkPruned(chain, bc.BlockHash); err != nil {
+		log.Printf("action=valid_chain, status=invalid, err=%v", err)
+		return false
+	}
+	state := chainState{chainID: bc.ChainID(), replayProtection: bc.replayProtectionHeight(), balances: make(map[string]transaction.Amount), contracts: make(map[string]*Contract), tokens: make(map[string]*Token), assets: make(map[string]*Asset), nonces: make(map[string]uint64), confirmed: make(map[[32]byte]int)}
+	for height, b := range chain {
+		var err error
+		switch {
+		case height == 0:
+		case b.Pruned():
+			err = bc.checkHeader(chain[:height], b)
+		default:
+			err = bc.checkBlock(chain[:height], b, state, rules)
+		}
+		if err == nil {
+			err = b.CheckHash(bc.Hasher())
+		}
+		if err != nil {
+			log.Printf("action=valid_chain, status=invalid, height=%d, err=%v", height, err)
+			return false
+		}
+		state.apply(height, b)
+		if b.Pruned() && height == snapshot.Height {
+			// Pruned headers carry no transactions; the snapshot records the state they led to.
+			state.issued = snapshot.Issued
+			maps.Copy(state.balances, snapshot.Balances)
+			state.contracts = cloneContracts(snapshot.Contracts)
+			state.tokens = cloneTokens(snapshot.Tokens)
+			state.assets = cloneAssets(snapshot.Assets)
+			maps.Copy(state.nonces, snapshot.Nonces)
+			state.accounts = newAccountsTrie(state.balances, state.nonces)
+			if b.AccountsRoot() != nil && *b.AccountsRoot() != state.accounts.Root() {
+				log.Printf("action=valid_chain, status=invalid, height=%d, err=snapshot does not match the accounts root", height)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// chainState is what a block is validated against: the chain ID and the replay protection height, and the coins
+// issued, the balances, the contracts, the tokens, the assets, the account nonces, the accounts trie, and the
+// confirmed transactions after the blocks before it.
+type chainState struct {
+	chainID          string
+	replayProtection int
+	issued           transaction.Amount
+	balances         map[string]transaction.Amount
+	contracts        map[string]*Contract
+	tokens           map[string]*Token
+	assets           map[string]*Asset
+	nonces           map[string]uint64
+	accounts         *stateTrie
+	confirmed        map[[32]byte]int
+}
+
+// tipState returns the state after the local tip. It shares the node's indexes, so callers must hold mux
+// and must not keep it past releasing it.
+func (bc *Blockchain) tipState() chainState {
+	return chainState{chainID: bc.ChainID(), replayProtection: bc.replayProtectionHeight(), issued: bc.issued, balances: bc.balances, contracts: bc.contracts, tokens: bc.tokens, assets: bc.assets, nonces: bc.nonces, accounts: bc.accounts, confirmed: bc.txIndex}
+}
+
+// apply advances s past b at height.
+func (s *chainState) apply(height int, b *block.Block) {
+	s.issued += blockIssuance(b)
+	applyTransactions(s.balances, s.contracts, s.tokens, s.assets, b.Transactions())
+	applyNonces(s.nonces, b.Transactions())
+	s.accounts = s.accounts.withAccounts(s.balances, s.nonces, touchedAccounts(b.Transactions()))
+	for _, t := range b.Transactions() {
+		s.confirmed[t.Hash()] = height
+	}
+}
+
+// checkHeader returns why b's header cannot follow the blocks prev, genesis first, or nil if it can.
+func (bc *Blockchain) checkHeader(prev []*block.Block, b *block.Block) error {
+	height := len(prev)
+	if b.PreviousHash() != bc.BlockHash(prev[height-1]) {
+		return errors.New("previous hash mismatch")
+	}
+	if mtp := medianTimePast(prev); b.Timestamp() <= mtp {
+		return fmt.Errorf("timestamp not after the median time past %d", mtp)
+	}
+	if b.Timestamp() > time.Now().Add(MAX_FUTURE_BLOCK_TIME).UnixNano() {
+		return fmt.Errorf("timestamp more than %s in the future", MAX_FUTURE_BLOCK_TIME)
+	}
+	if prev[height-1].AccountsRoot() != nil && b.AccountsRoot() == nil {
+		// Once a chain commits to its accounts, every later block must, or a miner could drop the commitment.
+		return errors.New("missing accounts root")
+	}
+	if err := bc.checkCheckpoint(height, b); err != nil {
+		return err
+	}
+	return bc.Consensus().Verify(height, b)
+}
+
+// blockRules are the node's settings that decide whether a block is valid.
+type blockRules struct {
+	halvingInterval int
+	maxSupply       transaction.Amount
+	maxTransactions int
+	maxSize         int
+}
+
+// rules returns the node's block rules. Callers must hold mux.
+func (bc *Blockchain) rules() blockRules {
+	return blockRules{halvingInterval: bc.halvingInterval, maxSupply: bc.maxSupply, maxTransactions: bc.maxBlockTxs, maxSize: bc.maxBlockSize}
+}
+
+// checkBlock returns why b cannot follow the blocks prev, given the state after them, or nil if it can.
+func (bc *Blockchain) checkBlock(prev []*block.Block, b *block.Block, state chainState, rules blockRules) error {
+	height := len(prev)
+	if rules.maxTransactions > 0 && len(b.Transactions()) > rules.maxTransactions {
+		return fmt.Errorf("block has %d transactions, more than %d", len(b.Transactions()), rules.maxTransactions)
+	}
+	if size := transactionsSize(b.Transactions()); rules.maxSize > 0 && size > rules.maxSize {
+		return fmt.Errorf("block transactions take %d bytes, more than %d", size, rules.maxSize)
+	}
+	if b.Pruned() {
+		return errors.New("block is pruned")
+	}
+	if b.MerkleRoot() != block.MerkleRoot(b.Transactions()) {
+		return errors.New("merkle root mismatch")
+	}
+	if err := bc.checkHeader(prev, b); err != nil {
+		return err
+	}
+	if blockIssuance(b) > CappedReward(BlockReward(height, rules.halvingInterval), state.issued, rules.maxSupply) {
+		return errors.New("coinbase pays more than the block reward")
+	}
+	if err := checkTransactions(b.Transactions(), state, height, medianTimePast(prev), bc.signatures, bc.verifyWorkers); err != nil {
+		return err
+	}
+	if !sameStateRoot(b.StateRoot(), blockStateRoot(state.balances, state.contracts, b.Transactions())) {
+		return errors.New("state root mismatch")
+	}
+	if b.AccountsRoot() != nil && *b.AccountsRoot() != *blockAccountsRoot(state.accounts, state.balances, state.nonces, state.contracts, b.Transactions()) {
+		return errors.New("accounts root mismatch")
+	}
+	return nil
+}
+
+// checkTransactions returns why transactions cannot be confirmed in this order in the block at height, after
+// state and the median time past mtp, or nil if they can: every transaction but the coinbases must be signed
+// by its sender, not confirmed before or repeated, past its lock time, carry its sender's next nonce, and leave the sender's balance
+// non-negative. A sender may spend what an earlier transaction in the same block paid it, but not tokens or assets;
+// contract transactions run, as when they are applied, so a call's refund or payout counts too.
+// Signatures that signatures verified before are not verified again, and the rest are verified up front on
+// workers goroutines; the rules that depend on the transactions before run in order.
+func checkTransactions(transactions []*transaction.Transaction, state chainState, height int, mtp int64, signatures *SignatureCache, workers int) error {
+	signed := verifySignatures(transactions, signatures, workers)
+	seen := make(map[[32]byte]bool, len(transactions))
+	delta := make(map[string]transaction.Amount)
+	tokens := newTokenSpends(state.tokens)
+	assets := newAssetMoves(state.assets)
+	nonces := newNonceSequence(state.nonces)
+	var contracts map[string]*Contract // cloned from state on the first contract transaction
+	var minted transaction.Amount
+	for i, t := range transactions {
+		if err := t.CheckVersion(); err != nil {
+			return fmt.Errorf("transaction %d: %w", i, err)
+		}
+		if err := t.CheckChainID(state.chainID); err != nil {
+			return fmt.Errorf("transaction %d: %w", i, err)
+		}
+		if height >= state.replayProtection {
+			if err := t.CheckReplayProtected(state.chainID); err != nil {
+				return fmt.Errorf("transaction %d: %w", i, err)
+			}
+		}
+		if t.SenderBlockchainAddress() == transaction.MINING_SENDER {
+			// Coinbases are unsigned and alike for the same miner and reward, so they may repeat.
+			// The total must not wrap around either, or it would pass as less than the block reward.
+			if t.Value() < 0 || t.Fee() != 0 || t.Value() > math.MaxInt64-minted {
+				return fmt.Errorf("transaction %d: invalid coinbase", i)
+			}
+			minted += t.Value()
+			delta[t.RecipientBlockchainAddress()] += t.Value()
+			continue
+		}
+		h := t.Hash()
+		if _, ok := state.confirmed[h]; ok || seen[h] {
+			return fmt.Errorf("transaction %d: %w", i, ErrDuplicateTransaction)
+		}
+		seen[h] = true
+		if err := t.CheckAmounts(); err != nil {
+			return fmt.Errorf("transaction %d: %w", i, err)
+		}
+		if err := checkContractCode(t); err != nil {
+			return fmt.Errorf("transaction %d: %w", i, err)
+		}
+		if err := signed[i]; err != nil {
+			return fmt.Errorf("transaction %d: %w", i, err)
+		}
+		if err := t.CheckFinal(height, mtp); err != nil {
+			return fmt.Errorf("transaction %d: %w", i, err)
+		}
+		if err := nonces.admit(t); err != nil {
+			return fmt.Errorf("transaction %d: %w", i, err)
+		}
+		cost, err := t.Cost()
+		if err != nil {
+			return fmt.Errorf("transaction %d: %w", i, err)
+		}
+		if state.balances[t.SenderBlockchainAddress()]+delta[t.SenderBlockchainAddress()] < cost {
+			return fmt.Errorf("transaction %d: %w", i, ErrInsufficientBalance)
+		}
+		if t.Token() != nil {
+			if err := tokens.admit(t); err != nil {
+				return fmt.Errorf("transaction %d: %w", i, err)
+			}
+		}
+		if t.Asset() != nil {
+			if err := assets.admit(t); err != nil {
+				return fmt.Errorf("transaction %d: %w", i, err)
+			}
+		}
+		delta[t.SenderBlockchainAddress()] -= cost
+		if t.ContractType() != "" {
+			if contracts == nil {
+				// Calls change storage, which later calls in the block read; state must stay as it is.
+				contracts = cloneContracts(state.contracts)
+			}
+			applyContractDelta(state.balances, delta, contracts, t)
+			continue
+		}
+		delta[t.RecipientBlockchainAddress()] += t.Value()
+	}
+	return nil
+}
+
+// Chain returns the blocks of the local chain, genesis first.
+func (bc *Blockchain) Chain() []*block.Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return append([]*block.Block(nil), bc.chain...)
+}
+
+// fetchChain downloads neighbor n's GET /chain.
+func (bc *Blockchain) fetchChain(ctx context.Context, n string) (*Blockchain, error) {
+	ctx, span := trace.StartSpan(ctx, "fetch_chain", trace.SPAN_KIND_CLIENT)
+	defer span.Finish()
+	span.SetAttribute("neighbor", n)
+	req, client := bc.neighborRequest(ctx, http.MethodGet, n, "/chain", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	neighborChain := new(Blockchain)
+	if err := decodePeer(resp.Body, resp.Header.Get("Content-Type"), WIRE_MAX_RESPONSE_SIZE, neighborChain); err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttribute("chain.length", len(neighborChain.chain))
+	return neighborChain, nil
+}
+
+// validChainTraced runs ValidChain in a span, as it re-verifies every block and signature of a candidate chain.
+func (bc *Blockchain) validChainTraced(ctx context.Context, chain []*block.Block) bool {
+	_, span := trace.StartSpan(ctx, "validate_chain", trace.SPAN_KIND_INTERNAL)
+	defer span.Finish()
+	span.SetAttribute("chain.length", len(chain))
+	valid := bc.ValidChain(chain)
+	span.SetAttribute("chain.valid", valid)
+	return valid
+}
+
+// ResolveConflicts fetches the chain of every neighbor and replaces the local chain with the valid one the
+// consensus engine prefers (the longest, for ProofOfWork).
+// Returns true if the local chain was replaced.
+func (bc *Blockchain) ResolveConflicts(ctx context.Context) bool {
+	ctx, span := trace.StartSpan(ctx, "resolve_conflicts", trace.SPAN_KIND_INTERNAL)
+	defer span.Finish()
+	var bestChain []*block.Block
+	localChain := bc.Chain()
+
+	for _, n := range bc.Neighbors() {
+		neighborChain, err := bc.fetchChain(ctx, n)
+		if err != nil {
+			log.Printf("action=resolve_conflicts, neighbor=%s, err=%v", n, err)
+			continue
+		}
+		if neighborChain.Hasher().Name() != bc.Hasher().Name() {
+			log.Printf("action=resolve_conflicts, neighbor=%s, err=neighbor uses hasher %s", n, neighborChain.Hasher().Name())
+			continue
+		}
+		chain := neighborChain.Chain()
+		if bc.genesis != nil && (len(chain) == 0 || bc.BlockHash(chain[0]) != bc.BlockHash(localChain[0])) {
+			log.Printf("action=resolve_conflicts, neighbor=%s, err=neighbor has a different genesis block", n)
+			continue
+		}
+		current := localChain
+		if bestChain != nil {
+			current = bestChain
+		}
+		if bc.Consensus().ChooseChain(current, chain) && bc.validChainTraced(ctx, chain) {
+			bestChain = chain
+		}
+	}
+	span.SetAttribute("chain.replaced", bestChain != nil)
+
+	if bestChain != nil {
+		bc.mux.Lock()
+		defer bc.mux.Unlock()
+		if !bc.Consensus().ChooseChain(bc.chain, bestChain) {
+			log.Println("action=resolve_conflicts, status=not_replaced, reason=local chain grew meanwhile")
+			return false
+		}
+		r := bc.reorganize(bestChain)
+		if bc.miningCancel != nil {
+			// The block being mined no longer extends the tip; Mining restarts on the new one.
+			bc.miningCancel()
+		}
+		if bc.storage != nil {
+			if err := bc.storage.Blocks().ReplaceChain(bc.chain); err != nil {
+				log.Printf("action=save_chain, status=fail, err=%v", err)
+			}
+		}
+		log.Printf("action=resolve_conflicts, status=replaced, length=%d, fork_height=%d, abandoned=%d, readded=%d", len(bc.chain), r.ForkHeight, len(r.Abandoned), len(r.Readded))
+		last := bc.lastBlock()
+		bc.events.Publish(Event{Type: EVENT_CHAIN_REPLACED, Data: BlockInfo{Height: len(bc.chain) - 1, Hash: fmt.Sprintf("%x", bc.BlockHash(last)), Block: last}})
+		return true
+	}
+	log.Println("action=resolve_conflicts, status=not_replaced")
+	return false
+}
+
+// CalculateTotalAmount returns the confirmed balance of an address: everything received minus everything sent and paid in fees.
+// It reads the balance index instead of rescanning the chain.
+func (bc *Blockchain) CalculateTotalAmount(blockchainAddress string) transaction.Amount {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.calculateTotalAmount(blockchainAddress)
+}
+
+// calculateTotalAmount is CalculateTotalAmount without locking; callers must hold mux.
+func (bc *Blockchain) calculateTotalAmount(blockchainAddress string) transaction.Amount {
+	return bc.balances[blockchainAddress]
+}
+
+// BalanceAt returns the confirmed balance of an address after the block at height, read from the accounts
+// trie kept for that height, so it costs a trie lookup rather than a replay of the chain.
+func (bc *Blockchain) BalanceAt(blockchainAddress string, height int) (transaction.Amount, error) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	if height < 0 || height >= len(bc.chain) {
+		return 0, fmt.Errorf("no block at height %d", height)
+	}
+	accounts := bc.accountHistory[height]
+	if accounts == nil {
+		return 0, fmt.Errorf("blocks below height %d are pruned", bc.snapshot.Height)
+	}
+	value, ok := accounts.Get(accountKey(blockchainAddress))
+	if !ok {
+		return 0, nil
+	}
+	balance, _, err := decodeAccount(value)
+	return balance, err
+}
+
+// AddressBalance is an address's confirmed balance and its share of the issued supply, between 0 and 1.
+type AddressBalance struct {
+	Address string             `json:"blockchain_address"`
+	Amount  transaction.Amount `json:"amount_units"`
+	Share   float64            `json:"share"`
+}
+
+// TopAddresses returns the n addresses with the highest confirmed balances, richest first and ties by
+// address, read from the balance index. It also returns the issued supply the shares are of, and how many
+// addresses hold any coins.
+func (bc *Blockchain) TopAddresses(n int) ([]AddressBalance, transaction.Amount, int) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	holders := make([]AddressBalance, 0, len(bc.balances))
+	for address, amount := range bc.balances {
+		// MINING_SENDER only goes negative, paying out the coinbases.
+		if amount > 0 && address != transaction.MINING_SENDER {
+			holders = append(holders, AddressBalance{Address: address, Amount: amount})
+		}
+	}
+	slices.SortFunc(holders, func(a, b AddressBalance) int {
+		if a.Amount != b.Amount {
+			return cmp.Compare(b.Amount, a.Amount)
+		}
+		return strings.Compare(a.Address, b.Address)
+	})
+	top := holders[:min(n, len(holders))]
+	for i := range top {
+		if bc.issued > 0 {
+			top[i].Share = float64(top[i].Amount) / float64(bc.issued)
+		}
+	}
+	return top, bc.issued, len(holders)
+}
+
+// KnownAddress reports whether the address is the node's miner address or appears in any confirmed transaction.
+func (bc *Blockchain) KnownAddress(blockchainAddress string) bool {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	if blockchainAddress == bc.blockchainAddress {
+		return true
+	}
+	// Every sender and recipient of a confirmed transaction has an entry in the balance index.
+	_, ok := bc.balances[blockchainAddress]
+	return ok
+}
+
+// AddressTransaction is a confirmed transaction together with the height and timestamp of its block.
+type AddressTransaction struct {
+	Height      int
+	Timestamp   int64
+	Transaction *transaction.Transaction
+}
+
+// MarshalJSON provides a custom JSON representation for AddressTransaction fields.
+func (at AddressTransaction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Height        int                      `json:"height"`
+		Timestamp     int64                    `json:"timestamp"`
+		TransactionID string                   `json:"transaction_id"`
+		Transaction   *transaction.Transaction `json:"transaction"`
+	}{
+		Height:        at.Height,
+		Timestamp:     at.Timestamp,
+		TransactionID: at.Transaction.ID(),
+		Transaction:   at.Transaction,
+	})
+}
+
+// TransactionsFor returns every confirmed transaction sent or received by the address, oldest first.
+func (bc *Blockchain) TransactionsFor(blockchainAddress string) []AddressTransaction {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	history := make([]AddressTransaction, 0)
+	for height, b := range bc.chain {
+		for _, t := range b.Transactions() {
+			if blockchainAddress == t.SenderBlockchainAddress() || blockchainAddress == t.RecipientBlockchainAddress() {
+				history = append(history, AddressTransaction{Height: height, Timestamp: b.Timestamp(), Transaction: t})
+			}
+		}
+	}
+	return history
+}
+
+// TransactionByID finds a transaction by its hash in the chain, through the transaction index, or failing that in the pool; a pending
+// transaction is returned with Height -1 and no Timestamp.
+func (bc *Blockchain) TransactionByID(hash [32]byte) (AddressTransaction, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	if height, ok := bc.txIndex[hash]; ok && height < len(bc.chain) {
+		b := bc.chain[height]
+		for _, t := range b.Transactions() {
+			if t.Hash() == hash {
+				return AddressTransaction{Height: height, Timestamp: b.Timestamp(), Transaction: t}, true
+			}
+		}
+	}
+	for _, t := range bc.transactionPool {
+		if t.Hash() == hash {
+			return AddressTransaction{Height: -1, Transaction: t}, true
+		}
+	}
+	return AddressTransaction{}, false
+}
+
+const (
+	SEARCH_KIND_BLOCK       = "block"
+	SEARCH_KIND_TRANSACTION = "transaction"
+	SEARCH_KIND_ADDRESS     = "address"
+)
+
+// SearchResult says what a search query matched and the key to fetch it by: a block height for
+// SEARCH_KIND_BLOCK, a transaction ID, or a blockchain address.
+type SearchResult struct {
+	Kind string `json:"kind"`
+	Key  string `json:"key"`
+}
+
+// Search resolves a query typed into the explorer: a block height, a block hash, a transaction ID, or a
+// blockchain address (which matches even without any transactions).
+func (bc *Blockchain) Search(query string) (SearchResult, bool) {
+	query = strings.TrimSpace(query)
+	// Hashes come first: one made only of decimal digits would otherwise be read as a height.
+	if hash, err := transaction.HashFromString(query); err == nil {
+		if _, height, ok := bc.BlockByHash(hash); ok {
+			return SearchResult{Kind: SEARCH_KIND_BLOCK, Key: strconv.Itoa(height)}, true
+		}
+		if _, ok := bc.TransactionByID(hash); ok {
+			return SearchResult{Kind: SEARCH_KIND_TRANSACTION, Key: fmt.Sprintf("%x", hash)}, true
+		}
+		return SearchResult{}, false
+	}
+	if height, err := strconv.Atoi(query); err == nil {
+		if _, ok := bc.BlockByHeight(height); ok {
+			return SearchResult{Kind: SEARCH_KIND_BLOCK, Key: strconv.Itoa(height)}, true
+		}
+		return SearchResult{}, false
+	}
+	if transaction.ValidateAddress(query) {
+		return SearchResult{Kind: SEARCH_KIND_ADDRESS, Key: query}, true
+	}
+	return SearchResult{}, false
+}
+
+var (
+	ErrTransactionPoolFull  = errors.New("transaction pool is full and the fee is too low to evict another transaction")
+	ErrInsufficientBalance  = errors.New("insufficient balance for value and fee")
+	ErrDuplicateTransaction = errors.New("transaction is already pooled or confirmed")
+	ErrEmptyTransactionPool = errors.New("transaction pool is empty")
+	ErrStaleTip             = errors.New("chain tip changed while mining")
+	ErrBlockchainClosed     = errors.New("blockchain is closed")
+)
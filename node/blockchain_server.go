@@ -0,0 +1,1336 @@
+package node
+
+import (
+	"context"
+	"crypto/tls"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/internal/httputil"
+	"github.com/dikako/how-blockchain-works/internal/trace"
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+// SHUTDOWN_TIMEOUT bounds how long a shutting-down node waits for in-flight requests.
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+
+//go:embed templates/explorer.html
+var explorerHTML []byte
+
+// BlockchainServer exposes a Blockchain node over an HTTP JSON API.
+type BlockchainServer struct {
+	port           uint16
+	neighborRange  NeighborRange
+	dataDir        string
+	blockStore     string // which BlockStore keeps the chain under dataDir
+	compression    string // of the stored blocks
+	miningInterval time.Duration
+	hasher         block.Hasher
+	consensus      block.Consensus
+	genesis        *GenesisConfig
+	tlsCert        *tls.Certificate
+	auth           *Authenticator
+	limiter        *RateLimiter
+	cors           *CORS
+	minersWallet   *wallet.Wallet
+	blockchain     *Blockchain
+
+	stratumPort uint16 // 0 leaves the stratum server off
+	stratumDiff float64
+	poolFee     int
+	minPayout   transaction.Amount
+	stratum     *StratumServer
+}
+
+// NewBlockchainServer constructs a BlockchainServer that will listen on the given port
+// and look for neighbor nodes within neighborRange. Blocks are persisted under dataDir
+// (one subdirectory per port); an empty dataDir keeps the chain in memory only. miningInterval
+// is the block interval used by GET /mine/start, and hasher hashes block headers (nil for SHA-256).
+func NewBlockchainServer(port uint16, neighborRange NeighborRange, dataDir string, miningInterval time.Duration, hasher block.Hasher) *BlockchainServer {
+	return &BlockchainServer{
+		port:           port,
+		neighborRange:  neighborRange,
+		dataDir:        dataDir,
+		blockStore:     BLOCK_STORE_BOLT,
+		compression:    STORAGE_COMPRESSION_SNAPPY,
+		miningInterval: miningInterval,
+		hasher:         hasher,
+	}
+}
+
+// Port returns the TCP port the server listens on.
+func (bcs *BlockchainServer) Port() uint16 {
+	return bcs.port
+}
+
+// SetConsensus selects the consensus engine (nil for ProofOfWork). It must be called before GetBlockchain.
+func (bcs *BlockchainServer) SetConsensus(c block.Consensus) {
+	bcs.consensus = c
+}
+
+// SetBlockStore selects the BlockStore that keeps the chain under the data directory, BLOCK_STORE_BOLT (the
+// default), BLOCK_STORE_FILE, BLOCK_STORE_KV, or BLOCK_STORE_MEMORY. It must be called before GetBlockchain.
+func (bcs *BlockchainServer) SetBlockStore(kind string) {
+	bcs.blockStore = kind
+}
+
+// SetStorageCompression sets how blocks are compressed on disk, STORAGE_COMPRESSION_SNAPPY (the default) or
+// STORAGE_COMPRESSION_NONE. It must be called before GetBlockchain.
+func (bcs *BlockchainServer) SetStorageCompression(compression string) {
+	bcs.compression = compression
+}
+
+// SetGenesis sets the network's genesis block (nil lets the node create its own). It must be called before GetBlockchain.
+func (bcs *BlockchainServer) SetGenesis(g *GenesisConfig) {
+	bcs.genesis = g
+}
+
+// SetTLS makes Run serve HTTPS with cert (nil for plain HTTP).
+func (bcs *BlockchainServer) SetTLS(cert *tls.Certificate) {
+	bcs.tlsCert = cert
+}
+
+// SetAuth makes mutating endpoints require a credential accepted by a (nil leaves every endpoint public).
+func (bcs *BlockchainServer) SetAuth(a *Authenticator) {
+	bcs.auth = a
+}
+
+// requireAuth wraps h so that requests with any method other than publicMethods must pass bcs.auth;
+// the others get 401 Unauthorized.
+func (bcs *BlockchainServer) requireAuth(action string, h http.HandlerFunc, publicMethods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !slices.Contains(publicMethods, req.Method) {
+			if err := bcs.auth.Authenticate(req); err != nil {
+				log.Printf("action=%s, status=unauthorized, remote=%s, err=%v", action, req.RemoteAddr, err)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="blockchain"`)
+				httputil.WriteStatus(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+		}
+		h(w, req)
+	}
+}
+
+// SetStratum makes Run serve mining jobs to stratum workers on port, accepting shares that meet shareDifficulty
+// (0 for one less than the block difficulty), as a pool that keeps poolFeePercent of each
+// block it finds and pays the rest out to its workers in payouts of at least minPayout; port 0 disables it.
+func (bcs *BlockchainServer) SetStratum(port uint16, shareDifficulty float64, poolFeePercent int, minPayout transaction.Amount) {
+	bcs.stratumPort = port
+	bcs.stratumDiff = shareDifficulty
+	bcs.poolFee = poolFeePercent
+	bcs.minPayout = minPayout
+}
+
+// SetRateLimiter limits how often each client IP may submit transactions and mine (nil for no limit).
+func (bcs *BlockchainServer) SetRateLimiter(rl *RateLimiter) {
+	bcs.limiter = rl
+}
+
+// rateLimit wraps h so that requests with any method other than exemptMethods take a token from the client's
+// bucket, except relays (PUT) from authenticated peers; clients that run out get 429 Too Many Requests with a
+// Retry-After header.
+func (bcs *BlockchainServer) rateLimit(action string, h http.HandlerFunc, exemptMethods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !slices.Contains(exemptMethods, req.Method) && !(req.Method == http.MethodPut && bcs.isAuthenticatedPeer(req)) {
+			if ok, retryAfter := bcs.limiter.Allow(httputil.ClientIP(req), time.Now()); !ok {
+				log.Printf("action=%s, status=rate_limited, remote=%s", action, req.RemoteAddr)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				httputil.WriteStatus(w, http.StatusTooManyRequests, "too many requests")
+				return
+			}
+		}
+		h(w, req)
+	}
+}
+
+// isAuthenticatedPeer reports whether req carries a credential the node accepts, as neighbors configured with
+// the network's token do. With authentication off nobody is, since anyone could claim to be a neighbor.
+func (bcs *BlockchainServer) isAuthenticatedPeer(req *http.Request) bool {
+	return bcs.auth != nil && bcs.auth.Authenticate(req) == nil
+}
+
+// SetCORS lets browser pages from the origins c allows call the API (nil keeps them blocked).
+func (bcs *BlockchainServer) SetCORS(c *CORS) {
+	bcs.cors = c
+}
+
+// SetMinerWallet sets the wallet that receives mining rewards (and signs blocks under ProofOfAuthority).
+// It must be called before GetBlockchain; without it a fresh wallet is generated.
+func (bcs *BlockchainServer) SetMinerWallet(w *wallet.Wallet) {
+	bcs.minersWallet = w
+}
+
+// GetBlockchain returns the node's blockchain, creating it on first use with the configured miner wallet,
+// or a fresh one.
+func (bcs *BlockchainServer) GetBlockchain() *Blockchain {
+	if bcs.blockchain == nil {
+		minersWallet := bcs.minersWallet
+		var err error
+		if minersWallet == nil {
+			minersWallet, err = wallet.NewWallet()
+			if err != nil {
+				log.Fatalf("action=new_wallet, status=fail, err=%v", err)
+			}
+			bcs.minersWallet = minersWallet
+		}
+		var storage *FileStorage
+		if bcs.dataDir != "" {
+			dir := filepath.Join(bcs.dataDir, strconv.Itoa(int(bcs.port)))
+			var blocks BlockStore
+			blocks, err = OpenBlockStore(bcs.blockStore, dir, bcs.compression, bcs.hasher)
+			if err == nil {
+				storage, err = NewFileStorage(dir, blocks)
+			}
+			if err != nil {
+				log.Fatalf("action=open_storage, status=fail, err=%v", err)
+			}
+		}
+		bcs.blockchain = NewBlockchain(minersWallet.BlockchainAddress(), bcs.port, storage, bcs.hasher, bcs.consensus, bcs.genesis)
+		bcs.blockchain.SetNeighborRange(bcs.neighborRange)
+		log.Printf("action=new_blockchain, miner_address=%s, consensus=%s, chain_id=%q, genesis_hash=%x",
+			minersWallet.BlockchainAddress(), bcs.blockchain.Consensus().Name(), bcs.blockchain.ChainID(), bcs.blockchain.GenesisHash())
+		// Without a genesis config the miner holds the only coins on a fresh chain; paste these keys into the wallet page to spend them.
+		log.Printf("action=new_blockchain, miner_public_key=%s, miner_private_key=%s", minersWallet.PublicKeyStr(), minersWallet.PrivateKeyStr())
+	}
+	return bcs.blockchain
+}
+
+// GetChain handles GET /chain and returns the full chain.
+func (bcs *BlockchainServer) GetChain(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		writePeer(w, req, http.StatusOK, bcs.GetBlockchain())
+	default:
+		log.Printf("action=get_chain, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+	}
+}
+
+// Transactions handles the transaction pool: GET lists it, POST submits and relays a transaction,
+// PUT accepts a transaction relayed by a neighbor, and DELETE clears the pool.
+func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		bcs.getTransactions(w, req)
+	case http.MethodPost:
+		bcs.postTransactions(w, req)
+	case http.MethodPut:
+		bcs.putTransactions(w, req)
+	case http.MethodDelete:
+		bcs.GetBlockchain().ClearTransactionPool()
+		log.Println("action=delete_transactions, status=success")
+		httputil.WriteStatus(w, http.StatusOK, "success")
+	default:
+		log.Printf("action=transactions, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+	}
+}
+
+// getTransactions returns the pending transaction pool and its length.
+func (bcs *BlockchainServer) getTransactions(w http.ResponseWriter, req *http.Request) {
+	transactions := bcs.GetBlockchain().TransactionPool()
+	httputil.WriteJSON(w, http.StatusOK, struct {
+		Transactions []*transaction.Transaction `json:"transactions"`
+		Length       int                        `json:"length"`
+	}{
+		Transactions: transactions,
+		Length:       len(transactions),
+	})
+}
+
+// decodeTransaction reads a TransactionRequest from the request body and converts it into a Transaction.
+func decodeTransaction(req *http.Request) (*transaction.Transaction, error) {
+	var tr transaction.TransactionRequest
+	if err := decodePeer(req.Body, req.Header.Get("Content-Type"), RPC_MAX_BODY_SIZE, &tr); err != nil {
+		return nil, err
+	}
+	if !tr.Validate() {
+		return nil, errors.New("missing field(s)")
+	}
+	return tr.Transaction()
+}
+
+// postTransactions verifies a signed transaction from the request body, adds it to the pool, and relays it to neighbors.
+func (bcs *BlockchainServer) postTransactions(w http.ResponseWriter, req *http.Request) {
+	t, err := decodeTransaction(req)
+	if err != nil {
+		log.Printf("action=post_transactions, status=fail, err=%v", err)
+		httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+		return
+	}
+	err = bcs.GetBlockchain().CreateTransaction(t)
+	switch {
+	case errors.Is(err, ErrDuplicateTransaction):
+		// Resubmitting a known transaction is harmless, so clients can safely retry.
+		writeTransactionStatus(w, http.StatusOK, "already known", t)
+	case err != nil:
+		httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+	default:
+		writeTransactionStatus(w, http.StatusCreated, "success", t)
+	}
+}
+
+// writeTransactionStatus writes a {"message", "transaction_id"} JSON response with the given status code.
+func writeTransactionStatus(w http.ResponseWriter, status int, message string, t *transaction.Transaction) {
+	httputil.WriteJSON(w, status, struct {
+		Message       string `json:"message"`
+		TransactionID string `json:"transaction_id"`
+	}{
+		Message:       message,
+		TransactionID: t.ID(),
+	})
+}
+
+// putTransactions accepts a transaction relayed by a neighbor; it is verified, pooled, and gossiped on unless
+// the node has already seen it. One the sender cannot afford yet is held as an orphan and answered with 202.
+func (bcs *BlockchainServer) putTransactions(w http.ResponseWriter, req *http.Request) {
+	t, err := decodeTransaction(req)
+	if err != nil {
+		log.Printf("action=put_transactions, status=fail, err=%v", err)
+		httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+		return
+	}
+	err = bcs.GetBlockchain().ReceiveTransaction(t)
+	switch {
+	case errors.Is(err, ErrDuplicateTransaction):
+		writeTransactionStatus(w, http.StatusOK, "already known", t)
+	case errors.Is(err, ErrOrphanTransaction):
+		writeTransactionStatus(w, http.StatusAccepted, "orphan", t)
+	case err != nil:
+		httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+	default:
+		writeTransactionStatus(w, http.StatusOK, "success", t)
+	}
+}
+
+// Mine handles GET /mine and mines the pending transactions into a new block.
+// The nonce search is abandoned if the client disconnects first.
+func (bcs *BlockchainServer) Mine(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=mine, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	bc := bcs.GetBlockchain()
+	if len(bc.TransactionPool()) == 0 {
+		httputil.WriteStatus(w, http.StatusOK, "no pending transactions")
+		return
+	}
+	if !bc.Mining(req.Context()) {
+		httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+		return
+	}
+	httputil.WriteStatus(w, http.StatusOK, "success")
+}
+
+// StartMine handles GET /mine/start and starts mining a block every mining interval.
+func (bcs *BlockchainServer) StartMine(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=start_mine, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	if !bcs.GetBlockchain().StartMining(bcs.miningInterval) {
+		httputil.WriteStatus(w, http.StatusConflict, "already mining")
+		return
+	}
+	httputil.WriteStatus(w, http.StatusOK, "success")
+}
+
+// StopMine handles GET /mine/stop and halts background mining.
+func (bcs *BlockchainServer) StopMine(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=stop_mine, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	if !bcs.GetBlockchain().StopMining() {
+		httputil.WriteStatus(w, http.StatusConflict, "not mining")
+		return
+	}
+	httputil.WriteStatus(w, http.StatusOK, "success")
+}
+
+// Amount handles GET /amount?blockchain_address=... and returns the confirmed balance of the address.
+// Malformed addresses yield 400 and addresses never seen on the chain yield 404.
+func (bcs *BlockchainServer) Amount(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=amount, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	address := req.URL.Query().Get("blockchain_address")
+	if address == "" {
+		httputil.WriteStatus(w, http.StatusBadRequest, "missing blockchain_address")
+		return
+	}
+	if !transaction.ValidateAddress(address) {
+		httputil.WriteStatus(w, http.StatusBadRequest, "invalid blockchain_address")
+		return
+	}
+	bc := bcs.GetBlockchain()
+	if !bc.KnownAddress(address) {
+		httputil.WriteStatus(w, http.StatusNotFound, "unknown blockchain_address")
+		return
+	}
+	amount := bc.CalculateTotalAmount(address)
+	httputil.WriteJSON(w, http.StatusOK, struct {
+		BlockchainAddress string             `json:"blockchain_address"`
+		Amount            float64            `json:"amount"`
+		AmountUnits       transaction.Amount `json:"amount_units"`
+		Tokens            map[string]int64   `json:"tokens,omitempty"`
+	}{
+		BlockchainAddress: address,
+		Amount:            amount.Float64(),
+		AmountUnits:       amount,
+		Tokens:            bc.CalculateTokenAmounts(address),
+	})
+}
+
+// Nonce handles GET /nonce?blockchain_address=... and returns the nonce the address's next transaction must
+// carry, counting its pending transactions, and the chain ID it is signed for; an address never seen on the
+// chain starts at 0.
+func (bcs *BlockchainServer) Nonce(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=nonce, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	address := req.URL.Query().Get("blockchain_address")
+	if address == "" {
+		httputil.WriteStatus(w, http.StatusBadRequest, "missing blockchain_address")
+		return
+	}
+	if !transaction.ValidateAddress(address) {
+		httputil.WriteStatus(w, http.StatusBadRequest, "invalid blockchain_address")
+		return
+	}
+	bc := bcs.GetBlockchain()
+	httputil.WriteJSON(w, http.StatusOK, struct {
+		BlockchainAddress string `json:"blockchain_address"`
+		Nonce             uint64 `json:"nonce"`
+		ChainID           string `json:"chain_id"`
+	}{address, bc.NextNonce(address), bc.ChainID()})
+}
+
+// BalanceAt handles GET /balance_at?blockchain_address=...&height=... and returns the confirmed balance of the
+// address after the block at that height. Heights without a block, or pruned below a snapshot, yield 404.
+func (bcs *BlockchainServer) BalanceAt(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=balance_at, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	address := req.URL.Query().Get("blockchain_address")
+	if address == "" {
+		httputil.WriteStatus(w, http.StatusBadRequest, "missing blockchain_address")
+		return
+	}
+	if !transaction.ValidateAddress(address) {
+		httputil.WriteStatus(w, http.StatusBadRequest, "invalid blockchain_address")
+		return
+	}
+	height, err := strconv.Atoi(req.URL.Query().Get("height"))
+	if err != nil {
+		httputil.WriteStatus(w, http.StatusBadRequest, "invalid height")
+		return
+	}
+	amount, err := bcs.GetBlockchain().BalanceAt(address, height)
+	if err != nil {
+		httputil.WriteStatus(w, http.StatusNotFound, err.Error())
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, struct {
+		BlockchainAddress string             `json:"blockchain_address"`
+		Height            int                `json:"height"`
+		Amount            float64            `json:"amount"`
+		AmountUnits       transaction.Amount `json:"amount_units"`
+	}{address, height, amount.Float64(), amount})
+}
+
+// TopAddresses handles GET /addresses/top?n=... and returns the n addresses with the highest confirmed
+// balances and their shares of the issued supply. n defaults to TOP_ADDRESSES_DEFAULT_LIMIT and is capped at
+// TOP_ADDRESSES_MAX_LIMIT.
+func (bcs *BlockchainServer) TopAddresses(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=top_addresses, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	n := TOP_ADDRESSES_DEFAULT_LIMIT
+	if query := req.URL.Query(); query.Has("n") {
+		var err error
+		if n, err = strconv.Atoi(query.Get("n")); err != nil || n <= 0 {
+			httputil.WriteStatus(w, http.StatusBadRequest, "invalid n")
+			return
+		}
+	}
+	top, issued, holders := bcs.GetBlockchain().TopAddresses(min(n, TOP_ADDRESSES_MAX_LIMIT))
+	httputil.WriteJSON(w, http.StatusOK, struct {
+		Addresses    []AddressBalance   `json:"addresses"`
+		IssuedSupply transaction.Amount `json:"issued_supply"`
+		Holders      int                `json:"holders"`
+	}{top, issued, holders})
+}
+
+// History handles GET /history?blockchain_address=... and returns the confirmed transactions touching the address.
+func (bcs *BlockchainServer) History(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=history, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	address := req.URL.Query().Get("blockchain_address")
+	if address == "" {
+		httputil.WriteStatus(w, http.StatusBadRequest, "missing blockchain_address")
+		return
+	}
+	if !transaction.ValidateAddress(address) {
+		httputil.WriteStatus(w, http.StatusBadRequest, "invalid blockchain_address")
+		return
+	}
+	history := bcs.GetBlockchain().TransactionsFor(address)
+	httputil.WriteJSON(w, http.StatusOK, struct {
+		BlockchainAddress string               `json:"blockchain_address"`
+		Transactions      []AddressTransaction `json:"transactions"`
+		Length            int                  `json:"length"`
+	}{
+		BlockchainAddress: address,
+		Transactions:      history,
+		Length:            len(history),
+	})
+}
+
+// MerkleProof handles GET /merkle_proof?height=...&tx_hash=... and returns the Merkle inclusion
+// proof of a transaction in the block at that height, so light clients can verify it against the header.
+func (bcs *BlockchainServer) MerkleProof(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=merkle_proof, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	height, err := strconv.Atoi(req.URL.Query().Get("height"))
+	if err != nil {
+		httputil.WriteStatus(w, http.StatusBadRequest, "invalid height")
+		return
+	}
+	txHash, err := transaction.HashFromString(req.URL.Query().Get("tx_hash"))
+	if err != nil {
+		httputil.WriteStatus(w, http.StatusBadRequest, "invalid tx_hash")
+		return
+	}
+	b, ok := bcs.GetBlockchain().BlockByHeight(height)
+	if !ok {
+		httputil.WriteStatus(w, http.StatusNotFound, "block not found")
+		return
+	}
+	proof, ok := b.MerkleProof(txHash)
+	if !ok {
+		httputil.WriteStatus(w, http.StatusNotFound, "transaction not found in block")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, struct {
+		Height     int                     `json:"height"`
+		TxHash     string                  `json:"tx_hash"`
+		MerkleRoot string                  `json:"merkle_root"`
+		Proof      []block.MerkleProofStep `json:"proof"`
+	}{
+		Height:     height,
+		TxHash:     fmt.Sprintf("%x", txHash),
+		MerkleRoot: fmt.Sprintf("%x", b.MerkleRoot()),
+		Proof:      proof,
+	})
+}
+
+// AccountProof handles GET /account_proof?blockchain_address=... and returns the proof of the address's
+// balance and nonce against the accounts root of the tip, so light clients can check it without the chain.
+func (bcs *BlockchainServer) AccountProof(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=account_proof, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	address := req.URL.Query().Get("blockchain_address")
+	if address == "" {
+		httputil.WriteStatus(w, http.StatusBadRequest, "missing blockchain_address")
+		return
+	}
+	if !transaction.ValidateAddress(address) {
+		httputil.WriteStatus(w, http.StatusBadRequest, "invalid blockchain_address")
+		return
+	}
+	proof, err := bcs.GetBlockchain().AccountProof(address)
+	if errors.Is(err, ErrNoAccountsRoot) {
+		httputil.WriteStatus(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("action=account_proof, status=fail, err=%v", err)
+		httputil.WriteStatus(w, http.StatusInternalServerError, "fail")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, proof)
+}
+
+// GetBlocks handles GET /blocks?offset=...&limit=... and returns one page of the chain, genesis first.
+// limit defaults to BLOCKS_PAGE_DEFAULT_LIMIT and is capped at BLOCKS_PAGE_MAX_LIMIT; next_offset is null on the last page.
+func (bcs *BlockchainServer) GetBlocks(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=get_blocks, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	query := req.URL.Query()
+	offset, limit := 0, BLOCKS_PAGE_DEFAULT_LIMIT
+	var err error
+	if query.Has("offset") {
+		if offset, err = strconv.Atoi(query.Get("offset")); err != nil || offset < 0 {
+			httputil.WriteStatus(w, http.StatusBadRequest, "invalid offset")
+			return
+		}
+	}
+	if query.Has("limit") {
+		if limit, err = strconv.Atoi(query.Get("limit")); err != nil || limit <= 0 {
+			httputil.WriteStatus(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+	limit = min(limit, BLOCKS_PAGE_MAX_LIMIT)
+
+	bc := bcs.GetBlockchain()
+	blocks, length := bc.Blocks(offset, limit)
+	hashes := make([]string, len(blocks))
+	for i, b := range blocks {
+		hashes[i] = fmt.Sprintf("%x", bc.BlockHash(b))
+	}
+	var nextOffset *int
+	if next := offset + len(blocks); len(blocks) > 0 && next < length {
+		nextOffset = &next
+	}
+	writePeer(w, req, http.StatusOK, struct {
+		Blocks     []*block.Block `json:"blocks"`
+		Hashes     []string       `json:"hashes"`
+		Offset     int            `json:"offset"`
+		Limit      int            `json:"limit"`
+		Height     int            `json:"height"`
+		NextOffset *int           `json:"next_offset"`
+	}{
+		Blocks:     blocks,
+		Hashes:     hashes,
+		Offset:     offset,
+		Limit:      limit,
+		Height:     length - 1,
+		NextOffset: nextOffset,
+	})
+}
+
+// GetTransaction handles GET /transaction?id=... and returns a confirmed or pending transaction with
+// the height and timestamp of its block (height -1 while pending).
+func (bcs *BlockchainServer) GetTransaction(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=get_transaction, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	hash, err := transaction.HashFromString(req.URL.Query().Get("id"))
+	if err != nil {
+		httputil.WriteStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	t, ok := bcs.GetBlockchain().TransactionByID(hash)
+	if !ok {
+		if e, ok := bcs.GetBlockchain().ExpiredTransaction(hash); ok {
+			// The sender learns its transaction will not be mined unless submitted again.
+			httputil.WriteJSON(w, http.StatusGone, e)
+			return
+		}
+		httputil.WriteStatus(w, http.StatusNotFound, "transaction not found")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, t)
+}
+
+// Mempool handles the pool's administration: GET lists the pending transactions with their fees and ages,
+// or with ?id=... shows one, and DELETE evicts the transaction with ?id=... (and its sender's later nonces),
+// or without an id clears the pool.
+func (bcs *BlockchainServer) Mempool(w http.ResponseWriter, req *http.Request) {
+	var hash [32]byte
+	id := req.URL.Query().Get("id")
+	if id != "" {
+		var err error
+		if hash, err = transaction.HashFromString(id); err != nil {
+			httputil.WriteStatus(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+	}
+	now := time.Now()
+	switch {
+	case req.Method == http.MethodGet && id == "":
+		entries := bcs.GetBlockchain().PoolEntries(now)
+		httputil.WriteJSON(w, http.StatusOK, struct {
+			Transactions []PoolEntry `json:"transactions"`
+			Length       int         `json:"length"`
+		}{
+			Transactions: entries,
+			Length:       len(entries),
+		})
+	case req.Method == http.MethodGet:
+		e, ok := bcs.GetBlockchain().PoolEntry(hash, now)
+		if !ok {
+			httputil.WriteStatus(w, http.StatusNotFound, "transaction not pooled")
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, e)
+	case req.Method == http.MethodDelete && id == "":
+		bcs.GetBlockchain().ClearTransactionPool()
+		log.Println("action=clear_mempool, status=success")
+		httputil.WriteStatus(w, http.StatusOK, "success")
+	case req.Method == http.MethodDelete:
+		evicted, err := bcs.GetBlockchain().EvictTransaction(hash)
+		if err != nil {
+			log.Printf("action=evict_transaction, status=fail, id=%s, err=%v", id, err)
+			httputil.WriteStatus(w, http.StatusNotFound, "transaction not pooled")
+			return
+		}
+		ids := make([]string, 0, len(evicted))
+		for _, t := range evicted {
+			ids = append(ids, t.ID())
+		}
+		httputil.WriteJSON(w, http.StatusOK, struct {
+			Evicted []string `json:"evicted"`
+		}{Evicted: ids})
+	default:
+		log.Printf("action=mempool, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+	}
+}
+
+// Contract handles GET /contract?address=... and returns the contract deployed there: its code, as hex and
+// as assembly, its balance, and its storage.
+func (bcs *BlockchainServer) Contract(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=get_contract, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	address := req.URL.Query().Get("address")
+	if !transaction.ValidateAddress(address) {
+		httputil.WriteStatus(w, http.StatusBadRequest, "invalid address")
+		return
+	}
+	c, balance, ok := bcs.GetBlockchain().Contract(address)
+	if !ok {
+		httputil.WriteStatus(w, http.StatusNotFound, "contract not found")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, struct {
+		Address  string             `json:"address"`
+		Code     string             `json:"code"`
+		Assembly string             `json:"assembly"`
+		Balance  transaction.Amount `json:"balance"`
+		Storage  map[int64]int64    `json:"storage"`
+	}{address, hex.EncodeToString(c.Code()), DisassembleContract(c.Code()), balance, c.Storage()})
+}
+
+// Tokens handles GET /tokens and returns every registered token.
+func (bcs *BlockchainServer) Tokens(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=get_tokens, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, bcs.GetBlockchain().Tokens())
+}
+
+// Token handles GET /token?symbol=... and returns the token's issuer, supply, holders, and their units.
+func (bcs *BlockchainServer) Token(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=get_token, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	symbol := req.URL.Query().Get("symbol")
+	if !transaction.ValidTokenSymbol(symbol) {
+		httputil.WriteStatus(w, http.StatusBadRequest, "invalid symbol")
+		return
+	}
+	tok, ok := bcs.GetBlockchain().Token(symbol)
+	if !ok {
+		httputil.WriteStatus(w, http.StatusNotFound, "token not found")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, tok)
+}
+
+// Asset handles GET /asset?id=... and returns the asset's creator, owner, and metadata hash.
+func (bcs *BlockchainServer) Asset(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=get_asset, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	id := req.URL.Query().Get("id")
+	if !transaction.ValidAssetID(id) {
+		httputil.WriteStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	a, ok := bcs.GetBlockchain().Asset(id)
+	if !ok {
+		httputil.WriteStatus(w, http.StatusNotFound, "asset not found")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, a)
+}
+
+// Assets handles GET /assets?owner=... and returns the assets the address owns.
+func (bcs *BlockchainServer) Assets(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=get_assets, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	owner := req.URL.Query().Get("owner")
+	if !transaction.ValidateAddress(owner) {
+		httputil.WriteStatus(w, http.StatusBadRequest, "invalid owner")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, struct {
+		Owner  string  `json:"owner"`
+		Assets []Asset `json:"assets"`
+	}{owner, bcs.GetBlockchain().AssetsOf(owner)})
+}
+
+// Receipt handles GET /receipt?id=... and returns the receipt of a confirmed contract transaction.
+func (bcs *BlockchainServer) Receipt(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=get_receipt, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	hash, err := transaction.HashFromString(req.URL.Query().Get("id"))
+	if err != nil {
+		httputil.WriteStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	r, ok := bcs.GetBlockchain().Receipt(hash)
+	if !ok {
+		httputil.WriteStatus(w, http.StatusNotFound, "receipt not found")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, r)
+}
+
+// Search handles GET /search?q=... and reports whether the query is a block, a transaction, or an address.
+func (bcs *BlockchainServer) Search(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=search, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	result, ok := bcs.GetBlockchain().Search(req.URL.Query().Get("q"))
+	if !ok {
+		httputil.WriteStatus(w, http.StatusNotFound, "nothing found")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, result)
+}
+
+// GraphQL handles POST /graphql with a {"query", "variables"} body, or GET /graphql?query=...&variables=...,
+// and runs the read-only query with ExecuteGraphQL.
+func (bcs *BlockchainServer) GraphQL(w http.ResponseWriter, req *http.Request) {
+	var gr GraphQLRequest
+	switch req.Method {
+	case http.MethodGet:
+		gr.Query = req.URL.Query().Get("query")
+		if v := req.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &gr.Variables); err != nil {
+				httputil.WriteStatus(w, http.StatusBadRequest, "invalid variables")
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(req.Body).Decode(&gr); err != nil {
+			log.Printf("action=graphql, status=fail, err=%v", err)
+			httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+			return
+		}
+	default:
+		log.Printf("action=graphql, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, ExecuteGraphQL(bcs.GetBlockchain(), gr))
+}
+
+// RPC handles POST /rpc, a JSON-RPC 2.0 endpoint with bitcoind-style methods (see rpcMethods).
+// A request made only of notifications gets 204 No Content.
+func (bcs *BlockchainServer) RPC(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		log.Printf("action=rpc, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, RPC_MAX_BODY_SIZE))
+	if err != nil {
+		log.Printf("action=rpc, status=fail, err=%v", err)
+		httputil.WriteStatus(w, http.StatusRequestEntityTooLarge, "fail")
+		return
+	}
+	resp := HandleRPC(bcs.GetBlockchain(), body, func() *RPCError {
+		if ok, _ := bcs.limiter.Allow(httputil.ClientIP(req), time.Now()); !ok {
+			return &RPCError{RPC_LIMIT_EXCEEDED, "too many requests"}
+		}
+		if err := bcs.auth.Authenticate(req); err != nil {
+			return &RPCError{RPC_UNAUTHORIZED, err.Error()}
+		}
+		return nil
+	})
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// Explorer handles GET /explorer and serves the embedded block explorer page.
+func (bcs *BlockchainServer) Explorer(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=explorer, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(explorerHTML)
+}
+
+// Peers handles GET /peers and returns the node's current neighbors, from which other nodes learn peers.
+func (bcs *BlockchainServer) Peers(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=peers, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	peers := bcs.GetBlockchain().Neighbors()
+	if peers == nil {
+		peers = []string{}
+	}
+	writePeer(w, req, http.StatusOK, struct {
+		Peers []string `json:"peers"`
+	}{peers})
+}
+
+// DHTTable handles GET /dht and returns the node's DHT contact and its non-empty routing table buckets.
+func (bcs *BlockchainServer) DHTTable(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=dht_table, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	d := bcs.GetBlockchain().DHT()
+	if d == nil {
+		httputil.WriteStatus(w, http.StatusNotFound, "dht disabled")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, struct {
+		Self    Contact           `json:"self"`
+		Buckets map[int][]Contact `json:"buckets"`
+	}{d.Self(), d.Buckets()})
+}
+
+// DHTFindNode handles POST /dht/find_node, the DHT's FIND_NODE: it returns the node's contacts closest to the
+// target and records the sender in its routing table.
+func (bcs *BlockchainServer) DHTFindNode(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		log.Printf("action=dht_find_node, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	d := bcs.GetBlockchain().DHT()
+	if d == nil {
+		httputil.WriteStatus(w, http.StatusNotFound, "dht disabled")
+		return
+	}
+	var fr DHTFindNodeRequest
+	if err := decodePeer(req.Body, req.Header.Get("Content-Type"), RPC_MAX_BODY_SIZE, &fr); err != nil {
+		log.Printf("action=dht_find_node, status=fail, err=%v", err)
+		httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+		return
+	}
+	resp, err := d.HandleFindNode(fr)
+	if err != nil {
+		log.Printf("action=dht_find_node, status=fail, err=%v", err)
+		httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+		return
+	}
+	writePeer(w, req, http.StatusOK, resp)
+}
+
+// Status handles GET /status and returns the node's NodeStatus.
+func (bcs *BlockchainServer) Status(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=status, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, bcs.GetBlockchain().Status())
+}
+
+// Fees handles GET /fees and returns the suggested low, medium, and high fees of EstimateFees.
+func (bcs *BlockchainServer) Fees(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=fees, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, bcs.GetBlockchain().EstimateFees())
+}
+
+// GetBlock handles GET /block?height=... or GET /block?hash=... and returns a single block with its height and hash.
+func (bcs *BlockchainServer) GetBlock(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=get_block, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	bc := bcs.GetBlockchain()
+	query := req.URL.Query()
+	var (
+		b      *block.Block
+		height int
+		ok     bool
+	)
+	switch {
+	case query.Has("hash"):
+		hash, err := transaction.HashFromString(query.Get("hash"))
+		if err != nil {
+			httputil.WriteStatus(w, http.StatusBadRequest, "invalid hash")
+			return
+		}
+		b, height, ok = bc.BlockByHash(hash)
+	case query.Has("height"):
+		var err error
+		height, err = strconv.Atoi(query.Get("height"))
+		if err != nil {
+			httputil.WriteStatus(w, http.StatusBadRequest, "invalid height")
+			return
+		}
+		b, ok = bc.BlockByHeight(height)
+	default:
+		httputil.WriteStatus(w, http.StatusBadRequest, "missing height or hash")
+		return
+	}
+	if !ok {
+		httputil.WriteStatus(w, http.StatusNotFound, "block not found")
+		return
+	}
+	writePeer(w, req, http.StatusOK, BlockInfo{Height: height, Hash: fmt.Sprintf("%x", bc.BlockHash(b)), Block: b})
+}
+
+// WebSocket handles GET /ws: it upgrades the connection and streams every block, transaction,
+// and chain replacement event as a JSON text frame until the client disconnects.
+func (bcs *BlockchainServer) WebSocket(w http.ResponseWriter, req *http.Request) {
+	conn, err := UpgradeWebSocket(w, req)
+	if err != nil {
+		log.Printf("action=websocket, status=fail, err=%v", err)
+		httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+		return
+	}
+	defer conn.Close()
+	events, unsubscribe := bcs.GetBlockchain().Subscribe()
+	defer unsubscribe()
+	log.Printf("action=websocket, status=connected, remote=%s", req.RemoteAddr)
+
+	// Clients send control frames and, to filter what they receive, bloom filter messages; answer pings and
+	// stop when the client closes or goes away.
+	var filterMux sync.Mutex
+	var filter *BloomFilter
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			opcode, payload, err := conn.ReadFrame()
+			if err != nil || opcode == WEBSOCKET_OP_CLOSE {
+				return
+			}
+			switch opcode {
+			case WEBSOCKET_OP_PING:
+				conn.WriteFrame(WEBSOCKET_OP_PONG, payload)
+			case WEBSOCKET_OP_TEXT:
+				filterMux.Lock()
+				filter, err = applyFilterMessage(filter, payload)
+				filterMux.Unlock()
+				if err != nil {
+					log.Printf("action=websocket_filter, status=fail, remote=%s, err=%v", req.RemoteAddr, err)
+					m, _ := json.Marshal(Event{Type: "error", Data: err.Error()})
+					conn.WriteText(m)
+				}
+			}
+		}
+	}()
+
+	for {
+		select {
+		case e := <-events:
+			filterMux.Lock()
+			e, send := filterEvent(e, filter)
+			filterMux.Unlock()
+			if !send {
+				continue
+			}
+			m, err := json.Marshal(e)
+			if err != nil {
+				log.Printf("action=websocket, status=fail, err=%v", err)
+				continue
+			}
+			if err := conn.WriteText(m); err != nil {
+				return
+			}
+		case <-done:
+			log.Printf("action=websocket, status=disconnected, remote=%s", req.RemoteAddr)
+			return
+		}
+	}
+}
+
+// Consensus handles PUT /consensus, sent by a neighbor after it mines, and resolves chain conflicts.
+func (bcs *BlockchainServer) Consensus(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut {
+		log.Printf("action=consensus, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	replaced := bcs.GetBlockchain().ResolveConflicts(req.Context())
+	httputil.WriteJSON(w, http.StatusOK, struct {
+		Message  string `json:"message"`
+		Replaced bool   `json:"replaced"`
+	}{
+		Message:  "success",
+		Replaced: replaced,
+	})
+}
+
+// Snapshot handles GET /snapshot?height=...&headers=false and returns the balance snapshot after the block at
+// height (default the tip) with its hash, and unless headers=false, the headers up to it for fast sync.
+func (bcs *BlockchainServer) Snapshot(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=snapshot, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	bc := bcs.GetBlockchain()
+	height := len(bc.Chain()) - 1
+	if h := req.URL.Query().Get("height"); h != "" {
+		var err error
+		if height, err = strconv.Atoi(h); err != nil {
+			httputil.WriteStatus(w, http.StatusBadRequest, "invalid height")
+			return
+		}
+	}
+	s, err := bc.SnapshotAt(height, req.URL.Query().Get("headers") != "false")
+	if err != nil {
+		httputil.WriteStatus(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writePeer(w, req, http.StatusOK, struct {
+		Hash string `json:"hash"`
+		*Snapshot
+	}{fmt.Sprintf("%x", s.Hash()), s})
+}
+
+// BlockTemplate handles external miners: GET returns a BlockTemplate whose coinbase pays ?address=... (the
+// node's miner by default), and POST submits the template's block once solved.
+func (bcs *BlockchainServer) BlockTemplate(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		address := req.URL.Query().Get("address")
+		if address != "" && !transaction.ValidateAddress(address) {
+			httputil.WriteStatus(w, http.StatusBadRequest, "invalid address")
+			return
+		}
+		bt, err := bcs.GetBlockchain().NewBlockTemplate(address)
+		switch {
+		case errors.Is(err, ErrNotProofOfWork):
+			httputil.WriteStatus(w, http.StatusNotImplemented, err.Error())
+		case errors.Is(err, ErrEmptyTransactionPool), errors.Is(err, ErrBlockchainClosed):
+			httputil.WriteStatus(w, http.StatusServiceUnavailable, err.Error())
+		case err != nil:
+			log.Printf("action=block_template, status=fail, err=%v", err)
+			httputil.WriteStatus(w, http.StatusInternalServerError, "fail")
+		default:
+			httputil.WriteJSON(w, http.StatusOK, bt)
+		}
+	case http.MethodPost:
+		b := new(block.Block)
+		if err := json.NewDecoder(req.Body).Decode(b); err != nil {
+			log.Printf("action=submit_block, status=fail, err=%v", err)
+			httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+			return
+		}
+		bc := bcs.GetBlockchain()
+		err := bc.SubmitBlock(req.Context(), b)
+		switch {
+		case errors.Is(err, ErrStaleTip), errors.Is(err, ErrDuplicateBlock):
+			httputil.WriteStatus(w, http.StatusConflict, err.Error())
+		case err != nil:
+			log.Printf("action=submit_block, status=rejected, err=%v", err)
+			httputil.WriteStatus(w, http.StatusBadRequest, err.Error())
+		default:
+			_, height, _ := bc.BlockByHash(bc.BlockHash(b))
+			httputil.WriteJSON(w, http.StatusCreated, BlockInfo{Height: height, Hash: fmt.Sprintf("%x", bc.BlockHash(b)), Block: b})
+		}
+	default:
+		log.Printf("action=block_template, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+	}
+}
+
+// Stratum handles GET /stratum and returns the stratum server's share difficulty, the share counts of its
+// workers, and the pool's rounds and payouts; 404 if the node serves no stratum.
+func (bcs *BlockchainServer) Stratum(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=get_stratum, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	if bcs.stratum == nil {
+		httputil.WriteStatus(w, http.StatusNotFound, "stratum is off")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, struct {
+		Port            uint16               `json:"port"`
+		ShareDifficulty float64              `json:"share_difficulty"`
+		Workers         []StratumWorkerStats `json:"workers"`
+		Pool            PoolStatus           `json:"pool"`
+	}{
+		Port:            bcs.stratumPort,
+		ShareDifficulty: bcs.stratum.ShareDifficulty(),
+		Workers:         bcs.stratum.Workers(),
+		Pool:            bcs.stratum.Pool().Status(),
+	})
+}
+
+// CompactBlock handles POST /blocks/compact, a block announced by a neighbor as its header and transaction
+// IDs, and answers with a CompactBlockResponse.
+func (bcs *BlockchainServer) CompactBlock(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		log.Printf("action=compact_block, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	var cb CompactBlock
+	if err := decodePeer(req.Body, req.Header.Get("Content-Type"), RPC_MAX_BODY_SIZE, &cb); err != nil {
+		log.Printf("action=compact_block, status=fail, err=%v", err)
+		httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+		return
+	}
+	resp, err := bcs.GetBlockchain().ReceiveCompactBlock(req.Context(), &cb)
+	if err != nil {
+		log.Printf("action=compact_block, status=fail, err=%v", err)
+		httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+		return
+	}
+	writePeer(w, req, http.StatusOK, resp)
+}
+
+// Run registers the API routes and serves them until ctx is cancelled, then shuts the node down gracefully:
+// it stops mining (cancelling a block being sealed), stops accepting requests and waits up to
+// SHUTDOWN_TIMEOUT for those in flight, and saves the transaction pool before returning.
+func (bcs *BlockchainServer) Run(ctx context.Context) {
+	bcs.GetBlockchain().Run()
+	if bcs.stratumPort != 0 {
+		ln, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", bcs.stratumPort))
+		if err != nil {
+			log.Fatalf("action=stratum, status=fail, err=%v", err)
+		}
+		bcs.stratum = NewStratumServer(bcs.GetBlockchain(), bcs.stratumDiff)
+		bcs.stratum.SetPool(NewMiningPool(bcs.minersWallet, bcs.poolFee, bcs.minPayout))
+		go bcs.stratum.Serve(ctx, ln)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chain", bcs.GetChain)
+	mux.HandleFunc("/transactions", bcs.rateLimit("transactions", bcs.requireAuth("transactions", bcs.Transactions, http.MethodGet), http.MethodGet))
+	mux.HandleFunc("/mine", bcs.rateLimit("mine", bcs.requireAuth("mine", bcs.Mine)))
+	mux.HandleFunc("/mine/start", bcs.rateLimit("start_mine", bcs.requireAuth("start_mine", bcs.StartMine)))
+	mux.HandleFunc("/mine/stop", bcs.rateLimit("stop_mine", bcs.requireAuth("stop_mine", bcs.StopMine)))
+	mux.HandleFunc("/amount", bcs.Amount)
+	mux.HandleFunc("/nonce", bcs.Nonce)
+	mux.HandleFunc("/balance_at", bcs.BalanceAt)
+	mux.HandleFunc("/fees", bcs.Fees)
+	mux.HandleFunc("/consensus", bcs.requireAuth("consensus", bcs.Consensus))
+	mux.HandleFunc("/snapshot", bcs.Snapshot)
+	mux.HandleFunc("/blocks/compact", bcs.requireAuth("compact_block", bcs.CompactBlock))
+	mux.HandleFunc("/blocktemplate", bcs.requireAuth("submit_block", bcs.BlockTemplate, http.MethodGet))
+	mux.HandleFunc("/stratum", bcs.Stratum)
+	mux.HandleFunc("/merkle_proof", bcs.MerkleProof)
+	mux.HandleFunc("/account_proof", bcs.AccountProof)
+	mux.HandleFunc("/block", bcs.GetBlock)
+	mux.HandleFunc("/blocks", bcs.GetBlocks)
+	mux.HandleFunc("/ws", bcs.WebSocket)
+	mux.HandleFunc("/history", bcs.History)
+	mux.HandleFunc("/addresses/top", bcs.TopAddresses)
+	mux.HandleFunc("/status", bcs.Status)
+	mux.HandleFunc("/peers", bcs.Peers)
+	mux.HandleFunc("/dht", bcs.DHTTable)
+	mux.HandleFunc("/dht/find_node", bcs.DHTFindNode)
+	mux.HandleFunc("/transaction", bcs.GetTransaction)
+	// Pool administration is for operators, so even its listing needs a credential.
+	mux.HandleFunc("/mempool", bcs.requireAuth("mempool", bcs.Mempool))
+	mux.HandleFunc("/contract", bcs.Contract)
+	mux.HandleFunc("/receipt", bcs.Receipt)
+	mux.HandleFunc("/tokens", bcs.Tokens)
+	mux.HandleFunc("/token", bcs.Token)
+	mux.HandleFunc("/asset", bcs.Asset)
+	mux.HandleFunc("/assets", bcs.Assets)
+	mux.HandleFunc("/search", bcs.Search)
+	mux.HandleFunc("/explorer", bcs.Explorer)
+	mux.HandleFunc("/graphql", bcs.GraphQL)
+	mux.HandleFunc("/rpc", bcs.RPC)
+
+	addr := fmt.Sprintf("0.0.0.0:%d", bcs.port)
+	log.Printf("action=run, status=listening, addr=%s, tls=%t", addr, bcs.tlsCert != nil)
+	ln, err := bcs.GetBlockchain().Transport().Listen(addr)
+	if err != nil {
+		log.Fatalf("action=run, status=fail, err=%v", err)
+	}
+	server := httputil.NewHTTPServer(addr, bcs.cors.Handler(trace.TraceHandler(mux)), bcs.tlsCert)
+	errc := make(chan error, 1)
+	go func() { errc <- httputil.ServeHTTP(server, ln) }()
+	select {
+	case err := <-errc:
+		log.Fatalf("action=run, status=fail, err=%v", err)
+	case <-ctx.Done():
+	}
+
+	log.Println("action=shutdown, status=started")
+	bc := bcs.GetBlockchain()
+	bc.Close()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("action=shutdown, status=fail, err=%v", err)
+	}
+	if err := bc.SaveTransactionPool(); err != nil {
+		log.Printf("action=save_transaction_pool, status=fail, err=%v", err)
+	}
+	if err := bc.CloseStorage(); err != nil {
+		log.Printf("action=close_storage, status=fail, err=%v", err)
+	}
+	log.Println("action=shutdown, status=complete")
+}
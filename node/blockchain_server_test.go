@@ -0,0 +1,521 @@
+package node
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+// newTestServer returns a server around a chain from newTestBlockchain, and the chain's miner.
+func newTestServer(t *testing.T) (*BlockchainServer, *wallet.Wallet) {
+	t.Helper()
+	bc, miner := newTestBlockchain(t)
+	return &BlockchainServer{blockchain: bc, minersWallet: miner}, miner
+}
+
+// serve sends a request with body to handler and returns the response, decoding its JSON body into v
+// unless v is nil.
+func serve(t *testing.T, handler http.HandlerFunc, method, target string, body any, v any) *httptest.ResponseRecorder {
+	t.Helper()
+	var r *bytes.Reader
+	if body == nil {
+		r = bytes.NewReader(nil)
+	} else if b, ok := body.([]byte); ok {
+		r = bytes.NewReader(b)
+	} else {
+		m, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(m)
+	}
+	req := httptest.NewRequest(method, target, r)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %v in %s", method, target, err, rec.Body)
+		}
+	}
+	return rec
+}
+
+func TestChainAndAmountEndpoints(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	var chain struct {
+		Chain []json.RawMessage `json:"chain"`
+	}
+	if rec := serve(t, bcs.GetChain, http.MethodGet, "/chain", nil, &chain); rec.Code != http.StatusOK {
+		t.Fatalf("GET /chain: %d", rec.Code)
+	}
+	if len(chain.Chain) != 1 {
+		t.Fatalf("GET /chain returned %d blocks, want the genesis block", len(chain.Chain))
+	}
+	if rec := serve(t, bcs.GetChain, http.MethodPost, "/chain", nil, nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST /chain: %d, want 405", rec.Code)
+	}
+
+	var amount struct {
+		Amount      float64 `json:"amount"`
+		AmountUnits int64   `json:"amount_units"`
+	}
+	if rec := serve(t, bcs.Amount, http.MethodGet, "/amount?blockchain_address="+miner.BlockchainAddress(), nil, &amount); rec.Code != http.StatusOK {
+		t.Fatalf("GET /amount: %d", rec.Code)
+	}
+	if amount.AmountUnits != int64(MINING_REWARD) || amount.Amount != MINING_REWARD.Float64() {
+		t.Fatalf("GET /amount = %+v, want the genesis reward", amount)
+	}
+	for target, want := range map[string]int{
+		"/amount":                                 http.StatusBadRequest,
+		"/amount?blockchain_address=nope":         http.StatusBadRequest,
+		"/amount?blockchain_address=" + unseen(t): http.StatusNotFound,
+	} {
+		if rec := serve(t, bcs.Amount, http.MethodGet, target, nil, nil); rec.Code != want {
+			t.Errorf("GET %s: %d, want %d", target, rec.Code, want)
+		}
+	}
+}
+
+// unseen returns the address of a new wallet, which no block mentions.
+func unseen(t *testing.T) string {
+	t.Helper()
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.BlockchainAddress()
+}
+
+func TestTransactionPoolEndpoints(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	tx, err := miner.NewTransaction(unseen(t), transaction.COIN/2, 0, bc.NextNonce(miner.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created struct {
+		Message       string `json:"message"`
+		TransactionID string `json:"transaction_id"`
+	}
+	if rec := serve(t, bcs.Transactions, http.MethodPost, "/transactions", tx, &created); rec.Code != http.StatusCreated {
+		t.Fatalf("POST /transactions: %d %s", rec.Code, rec.Body)
+	}
+	if created.TransactionID != tx.ID() {
+		t.Fatalf("POST /transactions returned ID %s, want %s", created.TransactionID, tx.ID())
+	}
+	if rec := serve(t, bcs.Transactions, http.MethodPost, "/transactions", tx, &created); rec.Code != http.StatusOK || created.Message != "already known" {
+		t.Fatalf("POST /transactions again: %d %q", rec.Code, created.Message)
+	}
+	if rec := serve(t, bcs.Transactions, http.MethodPost, "/transactions", []byte(`{"value": 1}`), nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("POST /transactions with missing fields: %d, want 400", rec.Code)
+	}
+
+	var pool struct {
+		Transactions []*transaction.Transaction `json:"transactions"`
+		Length       int                        `json:"length"`
+	}
+	serve(t, bcs.Transactions, http.MethodGet, "/transactions", nil, &pool)
+	if pool.Length != 1 || pool.Transactions[0].Hash() != tx.Hash() {
+		t.Fatalf("GET /transactions = %d transactions, want the posted one", pool.Length)
+	}
+	if rec := serve(t, bcs.Transactions, http.MethodDelete, "/transactions", nil, nil); rec.Code != http.StatusOK {
+		t.Fatalf("DELETE /transactions: %d", rec.Code)
+	}
+	serve(t, bcs.Transactions, http.MethodGet, "/transactions", nil, &pool)
+	if pool.Length != 0 {
+		t.Fatalf("GET /transactions after DELETE = %d transactions", pool.Length)
+	}
+}
+
+func TestAmountCountsConfirmedTransfersOnly(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	w := fund(t, bc, miner, transaction.COIN/2)
+	send(t, bc, w, miner, transaction.COIN/10, transaction.COIN/100)
+	amount := func(address string) transaction.Amount {
+		var v struct {
+			AmountUnits transaction.Amount `json:"amount_units"`
+		}
+		if rec := serve(t, bcs.Amount, http.MethodGet, "/amount?blockchain_address="+address, nil, &v); rec.Code != http.StatusOK {
+			t.Fatalf("GET /amount: %d", rec.Code)
+		}
+		if got := bc.CalculateTotalAmount(address); got != v.AmountUnits {
+			t.Fatalf("GET /amount = %s, CalculateTotalAmount = %s", v.AmountUnits, got)
+		}
+		return v.AmountUnits
+	}
+	if got := amount(w.BlockchainAddress()); got != transaction.COIN/2 {
+		t.Fatalf("amount with a pending spend = %s, want %s", got, transaction.COIN/2)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	if got, want := amount(w.BlockchainAddress()), transaction.COIN/2-transaction.COIN/10-transaction.COIN/100; got != want {
+		t.Fatalf("amount after mining the spend = %s, want %s", got, want)
+	}
+	// The miner was paid three rewards and the fee, and sent half a coin.
+	if got, want := amount(miner.BlockchainAddress()), 3*MINING_REWARD-transaction.COIN/2+transaction.COIN/10+transaction.COIN/100; got != want {
+		t.Fatalf("miner's amount = %s, want %s", got, want)
+	}
+}
+
+func TestMerkleProofEndpoint(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	var sent []*transaction.Transaction
+	for range 3 {
+		sent = append(sent, send(t, bc, miner, miner, transaction.COIN/100, 0))
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	var v struct {
+		MerkleRoot string `json:"merkle_root"`
+		Proof      []struct {
+			Hash string `json:"hash"`
+			Left bool   `json:"left"`
+		} `json:"proof"`
+	}
+	target := fmt.Sprintf("/merkle_proof?height=1&tx_hash=%s", sent[1].ID())
+	if rec := serve(t, bcs.MerkleProof, http.MethodGet, target, nil, &v); rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d", target, rec.Code)
+	}
+	root, err := transaction.HashFromString(v.MerkleRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof := make([]block.MerkleProofStep, len(v.Proof))
+	for i, step := range v.Proof {
+		if proof[i].Hash, err = transaction.HashFromString(step.Hash); err != nil {
+			t.Fatal(err)
+		}
+		proof[i].Left = step.Left
+	}
+	if b, _ := bc.BlockByHeight(1); root != b.MerkleRoot() || !block.VerifyMerkleProof(sent[1].Hash(), proof, root) {
+		t.Fatal("the served proof does not verify against the block's root")
+	}
+
+	for target, want := range map[string]int{
+		"/merkle_proof?height=1&tx_hash=" + fmt.Sprintf("%x", [32]byte{}): http.StatusNotFound,
+		"/merkle_proof?height=9&tx_hash=" + sent[0].ID():                  http.StatusNotFound,
+		"/merkle_proof?height=x&tx_hash=" + sent[0].ID():                  http.StatusBadRequest,
+		"/merkle_proof?height=1&tx_hash=zz":                               http.StatusBadRequest,
+	} {
+		if rec := serve(t, bcs.MerkleProof, http.MethodGet, target, nil, nil); rec.Code != want {
+			t.Errorf("GET %s: %d, want %d", target, rec.Code, want)
+		}
+	}
+}
+
+func TestBlocksAreFoundByHeightAndHash(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	fund(t, bc, miner, transaction.COIN/2)
+	fund(t, bc, miner, transaction.COIN/2)
+	for height, b := range bc.Chain() {
+		hash := bc.BlockHash(b)
+		if got, ok := bc.BlockByHeight(height); !ok || got != b {
+			t.Fatalf("BlockByHeight(%d) did not find the block", height)
+		}
+		if got, h, ok := bc.BlockByHash(hash); !ok || got != b || h != height {
+			t.Fatalf("BlockByHash of block %d = height %d, %t", height, h, ok)
+		}
+		var info struct {
+			Height int    `json:"height"`
+			Hash   string `json:"hash"`
+		}
+		for _, target := range []string{fmt.Sprintf("/block?height=%d", height), fmt.Sprintf("/block?hash=%x", hash)} {
+			if rec := serve(t, bcs.GetBlock, http.MethodGet, target, nil, &info); rec.Code != http.StatusOK {
+				t.Fatalf("GET %s: %d", target, rec.Code)
+			}
+			if info.Height != height || info.Hash != fmt.Sprintf("%x", hash) {
+				t.Fatalf("GET %s = %+v", target, info)
+			}
+		}
+	}
+	for target, want := range map[string]int{
+		"/block":           http.StatusBadRequest,
+		"/block?height=3":  http.StatusNotFound,
+		"/block?height=-1": http.StatusNotFound,
+		"/block?hash=" + fmt.Sprintf("%x", [32]byte{}): http.StatusNotFound,
+		"/block?hash=xyz": http.StatusBadRequest,
+	} {
+		if rec := serve(t, bcs.GetBlock, http.MethodGet, target, nil, nil); rec.Code != want {
+			t.Errorf("GET %s: %d, want %d", target, rec.Code, want)
+		}
+	}
+
+	// A longer chain replaces the blocks, and the index forgets theirs.
+	abandoned := bc.BlockHash(bc.LastBlock())
+	longer, other := newTestBlockchain(t)
+	for range 3 {
+		fund(t, longer, other, transaction.COIN/2)
+	}
+	setNeighbors(bc, serveNeighbor(t, longer))
+	if !bc.ResolveConflicts(context.Background()) {
+		t.Fatal("the longer chain was not adopted")
+	}
+	if _, _, ok := bc.BlockByHash(abandoned); ok {
+		t.Fatal("BlockByHash found an abandoned block")
+	}
+	tip := longer.LastBlock()
+	if _, h, ok := bc.BlockByHash(longer.BlockHash(tip)); !ok || h != 3 {
+		t.Fatalf("BlockByHash of the adopted tip = %d, %t", h, ok)
+	}
+}
+
+func TestExplorerSearchesBlocksTransactionsAndAddresses(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	recipient := fund(t, bc, miner, transaction.COIN/2)
+	tip := bc.LastBlock()
+	tx := tip.Transactions()[0]
+	hash := fmt.Sprintf("%x", bc.BlockHash(tip))
+	stranger := unseen(t)
+
+	rec := serve(t, bcs.Explorer, http.MethodGet, "/explorer", nil, nil)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") || !strings.Contains(rec.Body.String(), "/search?q=") {
+		t.Fatalf("GET /explorer: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for query, want := range map[string]SearchResult{
+		"1":                           {SEARCH_KIND_BLOCK, "1"},
+		hash:                          {SEARCH_KIND_BLOCK, "1"},
+		" " + tx.ID() + " ":           {SEARCH_KIND_TRANSACTION, tx.ID()},
+		recipient.BlockchainAddress(): {SEARCH_KIND_ADDRESS, recipient.BlockchainAddress()},
+		stranger:                      {SEARCH_KIND_ADDRESS, stranger},
+	} {
+		var got SearchResult
+		if rec := serve(t, bcs.Search, http.MethodGet, "/search?q="+url.QueryEscape(query), nil, &got); rec.Code != http.StatusOK {
+			t.Fatalf("GET /search?q=%s: %d", query, rec.Code)
+		}
+		if got != want {
+			t.Errorf("GET /search?q=%s = %+v, want %+v", query, got, want)
+		}
+	}
+	for _, query := range []string{"2", "-1", fmt.Sprintf("%x", [32]byte{}), "nothing"} {
+		if rec := serve(t, bcs.Search, http.MethodGet, "/search?q="+query, nil, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("GET /search?q=%s: %d, want 404", query, rec.Code)
+		}
+	}
+}
+
+func TestHistoryListsTheConfirmedTransactionsOfAnAddress(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	w := fund(t, bc, miner, transaction.COIN/2)
+	back := send(t, bc, w, miner, transaction.COIN/10, 0)
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	send(t, bc, w, miner, transaction.COIN/10, 0)
+
+	var v struct {
+		Transactions []struct {
+			Height        int    `json:"height"`
+			TransactionID string `json:"transaction_id"`
+		} `json:"transactions"`
+		Length int `json:"length"`
+	}
+	if rec := serve(t, bcs.History, http.MethodGet, "/history?blockchain_address="+w.BlockchainAddress(), nil, &v); rec.Code != http.StatusOK {
+		t.Fatalf("GET /history: %d", rec.Code)
+	}
+	// Funding and the confirmed spend, oldest first; the pending spend is left out.
+	if v.Length != 2 || v.Transactions[0].Height != 1 || v.Transactions[1].Height != 2 || v.Transactions[1].TransactionID != back.ID() {
+		t.Fatalf("GET /history = %+v", v)
+	}
+	serve(t, bcs.History, http.MethodGet, "/history?blockchain_address="+unseen(t), nil, &v)
+	if v.Length != 0 {
+		t.Fatalf("history of an unseen address has %d transactions", v.Length)
+	}
+	for _, target := range []string{"/history", "/history?blockchain_address=nope"} {
+		if rec := serve(t, bcs.History, http.MethodGet, target, nil, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: %d, want 400", target, rec.Code)
+		}
+	}
+}
+
+func TestBlocksArePagedInOrder(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	for range 4 {
+		fund(t, bc, miner, transaction.COIN/10)
+	}
+	type page struct {
+		Hashes     []string `json:"hashes"`
+		Offset     int      `json:"offset"`
+		Limit      int      `json:"limit"`
+		Height     int      `json:"height"`
+		NextOffset *int     `json:"next_offset"`
+	}
+	var hashes []string
+	for target := "/blocks?limit=2"; target != ""; {
+		var p page
+		if rec := serve(t, bcs.GetBlocks, http.MethodGet, target, nil, &p); rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d", target, rec.Code)
+		}
+		if p.Height != 4 || p.Limit != 2 || len(p.Hashes) > 2 {
+			t.Fatalf("GET %s = %+v", target, p)
+		}
+		hashes = append(hashes, p.Hashes...)
+		target = ""
+		if p.NextOffset != nil {
+			target = fmt.Sprintf("/blocks?offset=%d&limit=2", *p.NextOffset)
+		}
+	}
+	chain := bc.Chain()
+	if len(hashes) != len(chain) {
+		t.Fatalf("paged through %d blocks, want %d", len(hashes), len(chain))
+	}
+	for i, b := range chain {
+		if hashes[i] != fmt.Sprintf("%x", bc.BlockHash(b)) {
+			t.Fatalf("page entry %d is not block %d", i, i)
+		}
+	}
+
+	var p page
+	serve(t, bcs.GetBlocks, http.MethodGet, "/blocks?limit=1000", nil, &p)
+	if p.Limit != BLOCKS_PAGE_MAX_LIMIT || p.NextOffset != nil {
+		t.Fatalf("GET /blocks?limit=1000 = %+v, want the limit capped and no next page", p)
+	}
+	serve(t, bcs.GetBlocks, http.MethodGet, "/blocks?offset=9", nil, &p)
+	if len(p.Hashes) != 0 || p.NextOffset != nil {
+		t.Fatalf("GET /blocks past the tip = %+v", p)
+	}
+	for _, target := range []string{"/blocks?offset=-1", "/blocks?limit=0", "/blocks?limit=x"} {
+		if rec := serve(t, bcs.GetBlocks, http.MethodGet, target, nil, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: %d, want 400", target, rec.Code)
+		}
+	}
+}
+
+// webSocketClient is the client end of a /ws connection.
+type webSocketClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialWebSocket opens a WebSocket to /ws on bcs, served on a local port until the test ends.
+func dialWebSocket(t *testing.T, bcs *BlockchainServer) *webSocketClient {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(bcs.WebSocket))
+	t.Cleanup(s.Close)
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	const key = "dGhlIHNhbXBsZSBub25jZQ=="
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: node\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: %s\r\n\r\n", key)
+	c := &webSocketClient{conn: conn, r: bufio.NewReader(conn)}
+	resp, err := http.ReadResponse(c.r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The accept value for this key from RFC 6455, section 1.3.
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake answered %d with accept %q", resp.StatusCode, resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	// The server answers pings once it has subscribed to events.
+	c.write(t, WEBSOCKET_OP_PING, []byte("ready"))
+	if opcode, payload := c.read(t); opcode != WEBSOCKET_OP_PONG || string(payload) != "ready" {
+		t.Fatalf("ping answered with opcode %d %q", opcode, payload)
+	}
+	return c
+}
+
+// write sends one masked frame, as clients must.
+func (c *webSocketClient) write(t *testing.T, opcode byte, payload []byte) {
+	t.Helper()
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// read returns the opcode and payload of the next server frame.
+func (c *webSocketClient) read(t *testing.T) (byte, []byte) {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.r, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.r, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0F, payload
+}
+
+// event returns the type and the transaction ID or block hash of the next event.
+func (c *webSocketClient) event(t *testing.T) (string, string) {
+	t.Helper()
+	opcode, payload := c.read(t)
+	if opcode != WEBSOCKET_OP_TEXT {
+		t.Fatalf("event frame with opcode %d", opcode)
+	}
+	var e struct {
+		Type string `json:"type"`
+		Data struct {
+			TransactionID string `json:"transaction_id"`
+			Hash          string `json:"hash"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &e); err != nil {
+		t.Fatal(err)
+	}
+	return e.Type, e.Data.TransactionID + e.Data.Hash
+}
+
+func TestWebSocketStreamsTransactionsAndBlocks(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	ws := dialWebSocket(t, bcs)
+	tx := send(t, bc, miner, miner, transaction.COIN/10, 0)
+	if kind, id := ws.event(t); kind != EVENT_NEW_TRANSACTION || id != tx.ID() {
+		t.Fatalf("event %s %s, want the pooled transaction", kind, id)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	if kind, hash := ws.event(t); kind != EVENT_NEW_BLOCK || hash != fmt.Sprintf("%x", bc.BlockHash(bc.LastBlock())) {
+		t.Fatalf("event %s %s, want the mined block", kind, hash)
+	}
+
+	rec := serve(t, bcs.WebSocket, http.MethodGet, "/ws", nil, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("GET /ws without a handshake: %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,1812 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+// newTestBlockchain returns a chain without storage at difficulty 1, its genesis reward paid to miner.
+func newTestBlockchain(t *testing.T) (*Blockchain, *wallet.Wallet) {
+	t.Helper()
+	miner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewBlockchain(miner.BlockchainAddress(), 0, nil, nil, block.NewProofOfWork(1, nil), nil), miner
+}
+
+// sealBlock returns a sealed block confirming transactions on bc's tip. The caller holds bc.mux.
+func sealBlock(t *testing.T, bc *Blockchain, transactions []*transaction.Transaction) *block.Block {
+	t.Helper()
+	b := block.NewBlock(0, bc.BlockHash(bc.lastBlock()), transactions)
+	b.SetTimestamp(max(b.Timestamp(), medianTimePast(bc.chain)+1))
+	b.SetRoots(nil, blockAccountsRoot(bc.accounts, bc.balances, bc.nonces, bc.contracts, b.Transactions()))
+	if err := bc.Consensus().Seal(context.Background(), len(bc.chain), b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// relay returns a copy of tx decoded from its JSON encoding after change edits it, as a relay could.
+func relay(t *testing.T, tx *transaction.Transaction, change func(map[string]any)) *transaction.Transaction {
+	t.Helper()
+	m, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]any
+	if err := json.Unmarshal(m, &v); err != nil {
+		t.Fatal(err)
+	}
+	change(v)
+	if m, err = json.Marshal(v); err != nil {
+		t.Fatal(err)
+	}
+	var c transaction.Transaction
+	if err := json.Unmarshal(m, &c); err != nil {
+		t.Fatal(err)
+	}
+	return &c
+}
+
+// malleate returns a copy of t whose signature has S replaced by N-S, which verifies just the same.
+func malleate(t *testing.T, tx *transaction.Transaction) *transaction.Transaction {
+	t.Helper()
+	c := relay(t, tx, func(v map[string]any) {
+		der, err := hex.DecodeString(v["signature"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(der, &sig); err != nil {
+			t.Fatal(err)
+		}
+		sig.S.Sub(elliptic.P256().Params().N, sig.S)
+		if der, err = asn1.Marshal(sig); err != nil {
+			t.Fatal(err)
+		}
+		v["signature"] = hex.EncodeToString(der)
+	})
+	if err := c.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// send pools a transfer of value from one wallet to another with the sender's next nonce.
+func send(t *testing.T, bc *Blockchain, from, to *wallet.Wallet, value, fee transaction.Amount) *transaction.Transaction {
+	t.Helper()
+	tx, err := from.NewTransaction(to.BlockchainAddress(), value, fee, bc.NextNonce(from.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// fund returns a new wallet holding value, confirmed in a block mined on bc.
+func fund(t *testing.T, bc *Blockchain, from *wallet.Wallet, value transaction.Amount) *wallet.Wallet {
+	t.Helper()
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(t, bc, from, w, value, 0)
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	return w
+}
+
+// checkPool fails t unless every pooled transaction's arrival is recorded, none of dropped's is, and each
+// sender's pooled nonces follow its confirmed ones without a gap.
+func checkPool(t *testing.T, bc *Blockchain, dropped ...[32]byte) {
+	t.Helper()
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	for _, h := range dropped {
+		if _, ok := bc.pooledAt[h]; ok && !slices.ContainsFunc(bc.transactionPool, func(tx *transaction.Transaction) bool { return tx.Hash() == h }) {
+			t.Fatalf("arrival time of dropped %x kept", h)
+		}
+	}
+	next := make(map[string]uint64)
+	for _, tx := range bc.transactionPool {
+		if _, ok := bc.pooledAt[tx.Hash()]; !ok {
+			t.Fatalf("no arrival time for pooled %s", tx.ID())
+		}
+		if !tx.HasNonce() {
+			continue
+		}
+		n, ok := next[tx.SenderBlockchainAddress()]
+		if !ok {
+			n = bc.nonces[tx.SenderBlockchainAddress()]
+		}
+		if tx.Nonce() != n {
+			t.Fatalf("pooled nonce %d of %s, expected %d", tx.Nonce(), tx.SenderBlockchainAddress(), n)
+		}
+		next[tx.SenderBlockchainAddress()] = n + 1
+	}
+}
+
+func TestAddTransactionRejectsOverflowingAmounts(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// value + fee wraps around to -2, which an unfunded wallet's balance of 0 would cover.
+	tx, err := w.NewTransaction(miner.BlockchainAddress(), math.MaxInt64, math.MaxInt64, bc.NextNonce(w.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(tx); !errors.Is(err, transaction.ErrInvalidAmount) {
+		t.Fatalf("AddTransaction = %v, want %v", err, transaction.ErrInvalidAmount)
+	}
+	if len(bc.TransactionPool()) != 0 {
+		t.Fatal("the transaction was pooled")
+	}
+}
+
+func TestCheckBlockRejectsOverflowingAmounts(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := w.NewTransaction(miner.BlockchainAddress(), math.MaxInt64, math.MaxInt64, 0, bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reward := transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)
+	// Two coinbases of MaxInt64 sum to -2, which would pass as less than the block reward.
+	minted := transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), math.MaxInt64, 0)
+	for _, c := range []struct {
+		name         string
+		transactions []*transaction.Transaction
+		want         string
+	}{
+		{"value plus fee", []*transaction.Transaction{tx, reward}, transaction.ErrInvalidAmount.Error()},
+		{"coinbase total", []*transaction.Transaction{minted, minted}, "invalid coinbase"},
+	} {
+		bc.mux.Lock()
+		b := sealBlock(t, bc, c.transactions)
+		err := bc.checkBlock(bc.chain, b, bc.tipState(), bc.rules())
+		bc.mux.Unlock()
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: checkBlock = %v, want %q", c.name, err, c.want)
+		}
+	}
+}
+
+func TestCheckBlockRejectsInvalidRelayedBlocks(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	poor, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reward := transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)
+	transfer, err := miner.NewTransaction(poor.BlockchainAddress(), transaction.COIN/2, 0, bc.NextNonce(miner.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	overdraft, err := poor.NewTransaction(miner.BlockchainAddress(), transaction.COIN, 0, 0, bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := relay(t, transfer, func(v map[string]any) { v["value"] = float64(transaction.COIN) })
+	overpaid := transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD+1, 0)
+
+	for _, c := range []struct {
+		name         string
+		transactions []*transaction.Transaction
+		edit         func(b *block.Block)
+		want         string
+	}{
+		{"valid", []*transaction.Transaction{transfer, reward}, nil, ""},
+		{"overdraft", []*transaction.Transaction{overdraft, reward}, nil, ErrInsufficientBalance.Error()},
+		{"bad signature", []*transaction.Transaction{forged, reward}, nil, "signature"},
+		{"excess coinbase", []*transaction.Transaction{overpaid}, nil, "more than the block reward"},
+		{"future timestamp", []*transaction.Transaction{reward}, func(b *block.Block) {
+			b.SetTimestamp(time.Now().Add(MAX_FUTURE_BLOCK_TIME + time.Minute).UnixNano())
+		}, "in the future"},
+		{"unsealed", []*transaction.Transaction{reward}, func(b *block.Block) {
+			for bc.Consensus().Verify(1, b) == nil {
+				b.SetNonce(b.Nonce() + 1)
+			}
+		}, block.ErrInvalidProofOfWork.Error()},
+		{"swapped transactions", []*transaction.Transaction{reward}, func(b *block.Block) {
+			b.SetTransactions([]*transaction.Transaction{transfer, reward})
+		}, "merkle root mismatch"},
+	} {
+		bc.mux.Lock()
+		b := sealBlock(t, bc, c.transactions)
+		if c.edit != nil {
+			c.edit(b)
+		}
+		err := bc.checkBlock(bc.chain, b, bc.tipState(), bc.rules())
+		bc.mux.Unlock()
+		if c.want == "" && err != nil {
+			t.Errorf("%s: checkBlock = %v", c.name, err)
+		}
+		if c.want != "" && (err == nil || !strings.Contains(err.Error(), c.want)) {
+			t.Errorf("%s: checkBlock = %v, want %q", c.name, err, c.want)
+		}
+	}
+
+	unlinked := block.NewBlock(0, [32]byte{1}, []*transaction.Transaction{reward})
+	if err := bc.Consensus().Seal(context.Background(), 1, unlinked); err != nil {
+		t.Fatal(err)
+	}
+	bc.mux.Lock()
+	err = bc.checkBlock(bc.chain, unlinked, bc.tipState(), bc.rules())
+	bc.mux.Unlock()
+	if err == nil || !strings.Contains(err.Error(), "previous hash mismatch") {
+		t.Errorf("unlinked: checkBlock = %v, want a previous hash mismatch", err)
+	}
+}
+
+func TestMedianTimePastTakesTheLastElevenBlocks(t *testing.T) {
+	if mtp := medianTimePast(nil); mtp != 0 {
+		t.Fatalf("medianTimePast(nil) = %d, want 0", mtp)
+	}
+	var chain []*block.Block
+	for _, ts := range []int64{5, 1, 9} {
+		b := block.NewBlock(0, [32]byte{}, nil)
+		b.SetTimestamp(ts)
+		chain = append(chain, b)
+	}
+	if mtp := medianTimePast(chain); mtp != 5 {
+		t.Fatalf("medianTimePast of 5, 1, 9 = %d, want 5", mtp)
+	}
+	// Twelve more blocks push the first three out of the span.
+	for ts := range int64(12) {
+		b := block.NewBlock(0, [32]byte{}, nil)
+		b.SetTimestamp(100 + ts)
+		chain = append(chain, b)
+	}
+	if mtp := medianTimePast(chain); mtp != 106 {
+		t.Fatalf("medianTimePast of the last %d = %d, want 106", MEDIAN_TIME_SPAN, mtp)
+	}
+}
+
+func TestBlocksMustBeLaterThanTheMedianTimePast(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	reward := transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)
+	mtp := bc.MedianTimePast()
+	for _, c := range []struct {
+		timestamp int64
+		valid     bool
+	}{{mtp - 1, false}, {mtp, false}, {mtp + 1, true}} {
+		bc.mux.Lock()
+		b := sealBlock(t, bc, []*transaction.Transaction{reward})
+		b.SetTimestamp(c.timestamp)
+		if err := bc.Consensus().Seal(context.Background(), 1, b); err != nil {
+			t.Fatal(err)
+		}
+		err := bc.checkBlock(bc.chain, b, bc.tipState(), bc.rules())
+		bc.mux.Unlock()
+		if valid := err == nil; valid != c.valid {
+			t.Errorf("checkBlock at median time past %+d = %v", c.timestamp-mtp, err)
+		}
+	}
+
+	// A tip from a clock an hour ahead makes the next block follow it rather than the node's own clock.
+	bc.mux.Lock()
+	ahead := sealBlock(t, bc, []*transaction.Transaction{reward})
+	ahead.SetTimestamp(time.Now().Add(time.Hour).UnixNano())
+	if err := bc.Consensus().Seal(context.Background(), 1, ahead); err != nil {
+		t.Fatal(err)
+	}
+	bc.appendBlock(ahead)
+	bc.mux.Unlock()
+	fund(t, bc, miner, transaction.COIN)
+	if got, want := bc.LastBlock().Timestamp(), ahead.Timestamp(); got <= want {
+		t.Fatalf("mined timestamp %d is not after the tip's %d", got, want)
+	}
+	if !bc.ValidChain(bc.Chain()) {
+		t.Fatal("the chain mined after a tip from the future is invalid")
+	}
+}
+
+func TestBlocksRespectTheTransactionCountAndSizeLimits(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	bc.SetMaxBlockTransactions(3)
+	var sent []*transaction.Transaction
+	for fee := range transaction.Amount(4) {
+		w, err := wallet.NewWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, send(t, bc, miner, w, transaction.COIN/8, fee))
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	if n := len(bc.LastBlock().Transactions()); n != 3 {
+		t.Fatalf("block holds %d transactions, want 3 with the coinbase", n)
+	}
+	// The sender's nonces must follow each other, so the last two wait for the next block.
+	for _, tx := range bc.TransactionPool() {
+		if tx.ID() != sent[2].ID() && tx.ID() != sent[3].ID() {
+			t.Fatalf("the pool kept nonce %d, not one of the last two", tx.Nonce())
+		}
+	}
+	if pool := bc.TransactionPool(); len(pool) != 2 {
+		t.Fatalf("%d transactions left in the pool, want 2", len(pool))
+	}
+
+	reward := transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)
+	bc.mux.Lock()
+	crowded := sealBlock(t, bc, append(bc.copyTransactionPool(), reward))
+	bc.mux.Unlock()
+	bc.SetMaxBlockTransactions(2)
+	bc.mux.Lock()
+	err := bc.checkBlock(bc.chain, crowded, bc.tipState(), bc.rules())
+	bc.mux.Unlock()
+	if err == nil || !strings.Contains(err.Error(), "more than 2") {
+		t.Fatalf("checkBlock of 3 transactions over a limit of 2 = %v", err)
+	}
+
+	bc.SetMaxBlockTransactions(0)
+	bc.SetMaxBlockSize(transactionsSize(crowded.Transactions()) - 1)
+	bc.mux.Lock()
+	err = bc.checkBlock(bc.chain, crowded, bc.tipState(), bc.rules())
+	bc.mux.Unlock()
+	if err == nil || !strings.Contains(err.Error(), "bytes") {
+		t.Fatalf("checkBlock over the size limit = %v", err)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining under the size limit failed")
+	}
+	if n := len(bc.LastBlock().Transactions()); n != 2 {
+		t.Fatalf("block under the size limit holds %d transactions, want 2 with the coinbase", n)
+	}
+	if size := transactionsSize(bc.LastBlock().Transactions()); size > transactionsSize(crowded.Transactions())-1 {
+		t.Fatalf("mined block takes %d bytes, over the limit", size)
+	}
+}
+
+func TestDataCarriersAnchorTheirDataForAFee(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w := fund(t, bc, miner, transaction.COIN)
+	data := []byte("sha256:0123456789abcdef")
+	fee := transaction.DataCarrierFee(len(data))
+	if _, err := w.NewDataCarrier(data, fee-1, bc.NextNonce(w.BlockchainAddress()), bc.ChainID()); !errors.Is(err, transaction.ErrInvalidDataCarrier) {
+		t.Fatalf("NewDataCarrier underpaying = %v, want %v", err, transaction.ErrInvalidDataCarrier)
+	}
+	tx, err := w.NewDataCarrier(data, fee, bc.NextNonce(w.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	anchored := false
+	for _, confirmed := range bc.LastBlock().Transactions() {
+		anchored = anchored || string(confirmed.Data()) == string(data)
+	}
+	if !anchored {
+		t.Fatal("the mined block does not anchor the data")
+	}
+	if got, want := bc.CalculateTotalAmount(w.BlockchainAddress()), transaction.COIN-fee; got != want {
+		t.Fatalf("balance after anchoring = %s, want %s", got, want)
+	}
+
+	// A relayed carrier paying less than its bytes cost is refused by the pool too.
+	cheap := relay(t, tx, func(v map[string]any) { v["fee"] = float64(fee - 1) })
+	if err := bc.AddTransaction(cheap); !errors.Is(err, transaction.ErrInvalidDataCarrier) {
+		t.Fatalf("AddTransaction of an underpaying carrier = %v, want %v", err, transaction.ErrInvalidDataCarrier)
+	}
+}
+
+func TestTimeLockedTransactionsWaitInThePoolForTheirHeight(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w := fund(t, bc, miner, transaction.COIN)
+	locked := transaction.NewTransaction(w.BlockchainAddress(), miner.BlockchainAddress(), transaction.COIN/4, 0)
+	locked.SetNonce(bc.NextNonce(w.BlockchainAddress()))
+	locked.SetChainID(bc.ChainID())
+	lockHeight := len(bc.Chain()) + 1
+	locked.SetLockTime(uint64(lockHeight))
+	if err := w.SignTransaction(locked); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(locked); err != nil {
+		t.Fatalf("AddTransaction of a time-locked transaction = %v", err)
+	}
+
+	reward := transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)
+	bc.mux.Lock()
+	early := sealBlock(t, bc, []*transaction.Transaction{locked, reward})
+	err := bc.checkBlock(bc.chain, early, bc.tipState(), bc.rules())
+	bc.mux.Unlock()
+	if !errors.Is(err, transaction.ErrTransactionLocked) {
+		t.Fatalf("checkBlock confirming it a block early = %v, want %v", err, transaction.ErrTransactionLocked)
+	}
+
+	fund(t, bc, miner, transaction.COIN/8)
+	if pool := bc.TransactionPool(); len(pool) != 1 || pool[0].ID() != locked.ID() {
+		t.Fatalf("pool after the block before the lock height = %v, want the locked transaction", pool)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining at the lock height failed")
+	}
+	if len(bc.Chain())-1 != lockHeight || len(bc.TransactionPool()) != 0 {
+		t.Fatalf("the locked transaction was not confirmed at height %d", lockHeight)
+	}
+}
+
+func TestCandidateBlockPaysFeesWithoutOverflow(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	// Bypass the pool's checks, as if transactions from an older node had been loaded into it.
+	big := transaction.NewTransaction(miner.BlockchainAddress(), miner.BlockchainAddress(), 1, math.MaxInt64-1)
+	bc.mux.Lock()
+	bc.balances[miner.BlockchainAddress()] = math.MaxInt64
+	bc.transactionPool = append(bc.transactionPool, big)
+	b := bc.candidateBlock(miner.BlockchainAddress())
+	bc.mux.Unlock()
+	for _, tx := range b.Transactions() {
+		if tx.SenderBlockchainAddress() == transaction.MINING_SENDER && tx.Value() < 0 {
+			t.Fatalf("coinbase pays %d", tx.Value())
+		}
+	}
+}
+
+func TestUnnoncedTransactionsCannotBeReplayed(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A version 11 transfer has no nonce, so only its hash, which covers the signature, told copies apart.
+	tx := transaction.NewTransaction(miner.BlockchainAddress(), w.BlockchainAddress(), transaction.COIN, 0)
+	tx.SetVersion(transaction.TRANSACTION_VERSION_11)
+	if err := tx.Sign(miner.PrivateKey()); err != nil {
+		t.Fatal(err)
+	}
+	replay := malleate(t, tx)
+	if replay.ID() == tx.ID() {
+		t.Fatal("the malleated copy has the same ID")
+	}
+	for _, c := range []*transaction.Transaction{tx, replay} {
+		if err := bc.AddTransaction(c); !errors.Is(err, transaction.ErrInvalidNonce) {
+			t.Fatalf("AddTransaction = %v, want %v", err, transaction.ErrInvalidNonce)
+		}
+	}
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	b := sealBlock(t, bc, []*transaction.Transaction{tx, transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)})
+	// Blocks below the replay protection height still confirm it, so chains from before it stay valid.
+	state := bc.tipState()
+	state.replayProtection = len(bc.chain) + 1
+	if err := bc.checkBlock(bc.chain, b, state, bc.rules()); err != nil {
+		t.Fatalf("checkBlock below the replay protection height = %v", err)
+	}
+	state.replayProtection = len(bc.chain)
+	if err := bc.checkBlock(bc.chain, b, state, bc.rules()); !errors.Is(err, transaction.ErrInvalidNonce) {
+		t.Fatalf("checkBlock = %v, want %v", err, transaction.ErrInvalidNonce)
+	}
+}
+
+func TestHighSSignaturesAreRejected(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := miner.NewTransaction(w.BlockchainAddress(), transaction.COIN, 0, bc.NextNonce(miner.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sign produces a low S, which survives a JSON round trip.
+	if err := relay(t, tx, func(map[string]any) {}).CheckReplayProtected(bc.ChainID()); err != nil {
+		t.Fatalf("checkReplayProtected after a JSON round trip = %v", err)
+	}
+	flipped := malleate(t, tx)
+	if err := bc.AddTransaction(flipped); !errors.Is(err, transaction.ErrInvalidSignature) {
+		t.Fatalf("AddTransaction = %v, want %v", err, transaction.ErrInvalidSignature)
+	}
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	b := sealBlock(t, bc, []*transaction.Transaction{flipped, transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)})
+	state := bc.tipState()
+	state.replayProtection = len(bc.chain) + 1
+	if err := bc.checkBlock(bc.chain, b, state, bc.rules()); err != nil {
+		t.Fatalf("checkBlock below the replay protection height = %v", err)
+	}
+	state.replayProtection = len(bc.chain)
+	if err := bc.checkBlock(bc.chain, b, state, bc.rules()); !errors.Is(err, transaction.ErrInvalidSignature) {
+		t.Fatalf("checkBlock = %v, want %v", err, transaction.ErrInvalidSignature)
+	}
+}
+
+func TestMultisigAddressesSpendWithTheRequiredSignatures(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	var keys []*ecdsa.PublicKey
+	var cosigners []*wallet.Wallet
+	for range 3 {
+		w, err := wallet.NewWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cosigners, keys = append(cosigners, w), append(keys, w.PublicKey())
+	}
+	m, err := transaction.NewMultisig(2, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deposit, err := miner.NewTransaction(m.Address(), transaction.COIN, 0, bc.NextNonce(miner.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(deposit); err != nil {
+		t.Fatal(err)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+
+	tx := transaction.NewMultisigTransaction(m, cosigners[0].BlockchainAddress(), transaction.COIN/2, 0)
+	tx.SetNonce(bc.NextNonce(m.Address()))
+	tx.SetChainID(bc.ChainID())
+	if err := tx.SignMultisig(cosigners[1].PrivateKey()); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(tx); !errors.Is(err, transaction.ErrInvalidMultisig) {
+		t.Fatalf("AddTransaction with 1 of 2 signatures = %v, want %v", err, transaction.ErrInvalidMultisig)
+	}
+	if err := tx.SignMultisig(cosigners[2].PrivateKey()); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatalf("AddTransaction with 2 of 2 signatures = %v", err)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	if got, want := bc.CalculateTotalAmount(m.Address()), transaction.COIN/2; got != want {
+		t.Fatalf("multisig balance = %s, want %s", got, want)
+	}
+	if got, want := bc.CalculateTotalAmount(cosigners[0].BlockchainAddress()), transaction.COIN/2; got != want {
+		t.Fatalf("recipient balance = %s, want %s", got, want)
+	}
+}
+
+func TestSurplusMultisigSignaturesAreRejected(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	var keys []*ecdsa.PublicKey
+	var cosigners []*wallet.Wallet
+	for range 3 {
+		w, err := wallet.NewWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cosigners, keys = append(cosigners, w), append(keys, w.PublicKey())
+	}
+	m, err := transaction.NewMultisig(2, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := transaction.NewMultisigTransaction(m, cosigners[0].BlockchainAddress(), transaction.COIN, 0)
+	tx.SetNonce(bc.NextNonce(m.Address()))
+	tx.SetChainID(bc.ChainID())
+	for _, w := range cosigners[:2] {
+		if err := tx.SignMultisig(w.PrivateKey()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.SignMultisig(cosigners[2].PrivateKey()); !errors.Is(err, transaction.ErrInvalidMultisig) {
+		t.Fatalf("SignMultisig past the threshold = %v, want %v", err, transaction.ErrInvalidMultisig)
+	}
+	if err := tx.CheckReplayProtected(bc.ChainID()); err != nil {
+		t.Fatalf("checkReplayProtected with the required signatures = %v", err)
+	}
+
+	// All three sign; a relay could blank any one and the rest would still verify, under another ID.
+	h := tx.SigningHash()
+	surplus, err := transaction.SignLowS(cosigners[2].PrivateKey(), h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx = relay(t, tx, func(v map[string]any) {
+		v["multisig"].(map[string]any)["signatures"].([]any)[2] = hex.EncodeToString(surplus)
+	})
+	if err := tx.Multisig().Verify(tx.SenderBlockchainAddress(), h); err != nil {
+		t.Fatalf("verify with a surplus signature = %v", err)
+	}
+	if err := bc.AddTransaction(tx); !errors.Is(err, transaction.ErrInvalidMultisig) {
+		t.Fatalf("AddTransaction with a surplus signature = %v, want %v", err, transaction.ErrInvalidMultisig)
+	}
+}
+
+func TestPaddedUnlockingScriptsAreRejected(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	locking, err := transaction.PayToPublicKeyHashScript(w.BlockchainAddress())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := transaction.NewScriptTransaction(locking, w.BlockchainAddress(), transaction.COIN, 0)
+	tx.SetNonce(bc.NextNonce(tx.SenderBlockchainAddress()))
+	tx.SetChainID(bc.ChainID())
+	h := tx.SigningHash()
+	signature, err := transaction.SignLowS(w.PrivateKey(), h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, _ := w.PublicKey().Bytes()
+	unlocking := transaction.PushScript(signature, publicKey)
+	tx.SetUnlockingScript(unlocking)
+	if err := tx.CheckReplayProtected(bc.ChainID()); err != nil {
+		t.Fatalf("checkReplayProtected with a canonical unlocking script = %v", err)
+	}
+
+	for name, script := range map[string][]byte{
+		"extra push":       append(transaction.PushScript([]byte{7}), unlocking...),
+		"non-minimal push": append(append([]byte{transaction.OP_PUSHDATA1, byte(len(signature))}, signature...), transaction.PushScript(publicKey)...),
+	} {
+		tx.SetUnlockingScript(script)
+		if err := tx.VerifyScript(); err != nil {
+			t.Fatalf("%s: verifyScript = %v; the script should still run", name, err)
+		}
+		if err := tx.CheckReplayProtected(bc.ChainID()); !errors.Is(err, transaction.ErrScriptFailed) {
+			t.Fatalf("%s: checkReplayProtected = %v, want %v", name, err, transaction.ErrScriptFailed)
+		}
+		if err := bc.AddTransaction(tx); !errors.Is(err, transaction.ErrScriptFailed) {
+			t.Fatalf("%s: AddTransaction = %v, want %v", name, err, transaction.ErrScriptFailed)
+		}
+	}
+}
+
+func TestTransactionsWithoutChainIDAreRejectedOnNetworksWithOne(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	bc.SetChainID("testnet")
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A version 12 transfer carries a nonce but no chain ID, so it is just as valid on any other network.
+	tx := transaction.NewTransaction(miner.BlockchainAddress(), w.BlockchainAddress(), transaction.COIN, 0)
+	tx.SetVersion(transaction.TRANSACTION_VERSION_12)
+	tx.SetNonce(bc.NextNonce(miner.BlockchainAddress()))
+	if err := tx.Sign(miner.PrivateKey()); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(tx); !errors.Is(err, transaction.ErrWrongChainID) {
+		t.Fatalf("AddTransaction = %v, want %v", err, transaction.ErrWrongChainID)
+	}
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	b := sealBlock(t, bc, []*transaction.Transaction{tx, transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)})
+	state := bc.tipState()
+	state.replayProtection = len(bc.chain) + 1
+	if err := bc.checkBlock(bc.chain, b, state, bc.rules()); err != nil {
+		t.Fatalf("checkBlock below the replay protection height = %v", err)
+	}
+	state.replayProtection = len(bc.chain)
+	if err := bc.checkBlock(bc.chain, b, state, bc.rules()); !errors.Is(err, transaction.ErrWrongChainID) {
+		t.Fatalf("checkBlock = %v, want %v", err, transaction.ErrWrongChainID)
+	}
+}
+
+func TestTransactionsForAnotherChainAreRejected(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	bc.SetChainID("mainnet")
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := miner.NewTransaction(w.BlockchainAddress(), transaction.COIN, 0, bc.NextNonce(miner.BlockchainAddress()), "testnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(tx); !errors.Is(err, transaction.ErrWrongChainID) {
+		t.Fatalf("AddTransaction = %v, want %v", err, transaction.ErrWrongChainID)
+	}
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	b := sealBlock(t, bc, []*transaction.Transaction{tx, transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)})
+	if err := bc.checkBlock(bc.chain, b, bc.tipState(), bc.rules()); !errors.Is(err, transaction.ErrWrongChainID) {
+		t.Fatalf("checkBlock = %v, want %v", err, transaction.ErrWrongChainID)
+	}
+}
+
+// unsealed returns a block on previousHash whose nonce does not satisfy bc's proof of work.
+func unsealed(t *testing.T, bc *Blockchain, previousHash [32]byte, transactions []*transaction.Transaction) *block.Block {
+	t.Helper()
+	b := block.NewBlock(0, previousHash, transactions)
+	b.SetRoots(nil, bc.LastBlock().AccountsRoot())
+	for bc.Consensus().Verify(1, b) == nil {
+		b.SetNonce(b.Nonce() + 1)
+	}
+	return b
+}
+
+func TestUnsealedOrphansAndForksAreRejected(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	reward := transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)
+	orphan := unsealed(t, bc, [32]byte{1}, []*transaction.Transaction{reward})
+	if _, err := bc.ReceiveCompactBlock(context.Background(), NewCompactBlock(orphan)); err == nil {
+		t.Fatal("ReceiveCompactBlock accepted an unsealed orphan")
+	}
+	if n := bc.Orphans(); n != 0 {
+		t.Fatalf("%d orphans stored", n)
+	}
+
+	// A block on the genesis block, now no longer the tip, would otherwise make the node fetch every
+	// neighbor's chain for free.
+	bc.mux.Lock()
+	genesis := bc.BlockHash(bc.chain[0])
+	bc.appendBlock(sealBlock(t, bc, []*transaction.Transaction{reward}))
+	bc.mux.Unlock()
+	fork := unsealed(t, bc, genesis, []*transaction.Transaction{reward})
+	resp, err := bc.ReceiveCompactBlock(context.Background(), NewCompactBlock(fork))
+	if !errors.Is(err, block.ErrInvalidProofOfWork) {
+		t.Fatalf("ReceiveCompactBlock = %+v, %v, want %v", resp, err, block.ErrInvalidProofOfWork)
+	}
+}
+
+func TestRateLimitCoversRelaysFromUnauthenticatedPeers(t *testing.T) {
+	ok := func(w http.ResponseWriter, req *http.Request) { w.WriteHeader(http.StatusOK) }
+	status := func(bcs *BlockchainServer, token string) int {
+		req := httptest.NewRequest(http.MethodPut, "/transactions", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		bcs.rateLimit("transactions", ok, http.MethodGet)(rec, req)
+		return rec.Code
+	}
+
+	open := &BlockchainServer{limiter: NewRateLimiter(1, 1)}
+	if got := status(open, ""); got != http.StatusOK {
+		t.Fatalf("first PUT: got %d, want 200", got)
+	}
+	if got := status(open, ""); got != http.StatusTooManyRequests {
+		t.Fatalf("second PUT without authentication: got %d, want 429", got)
+	}
+
+	authed := &BlockchainServer{limiter: NewRateLimiter(1, 1), auth: NewAuthenticator([]string{"peer-key"}, "")}
+	for i := 0; i < 3; i++ {
+		if got := status(authed, "peer-key"); got != http.StatusOK {
+			t.Fatalf("PUT %d from an authenticated peer: got %d, want 200", i+1, got)
+		}
+	}
+	if got := status(authed, "wrong"); got != http.StatusOK {
+		t.Fatalf("first PUT with a bad token: got %d, want 200", got)
+	}
+	if got := status(authed, "wrong"); got != http.StatusTooManyRequests {
+		t.Fatalf("second PUT with a bad token: got %d, want 429", got)
+	}
+}
+
+func TestPeerMessagesRoundTripThroughCBORWithinTheSizeCap(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	to, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := miner.NewTransaction(to.BlockchainAddress(), transaction.COIN, 0, bc.NextNonce(miner.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, contentType, err := marshalPeer(WIRE_FORMAT_CBOR, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != MIME_CBOR {
+		t.Fatalf("content type %q, want %q", contentType, MIME_CBOR)
+	}
+	var got transaction.Transaction
+	if err := decodePeer(bytes.NewReader(m), contentType, int64(len(m)), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Hash() != tx.Hash() {
+		t.Fatalf("round trip changed the transaction ID")
+	}
+
+	for _, format := range []string{WIRE_FORMAT_CBOR, WIRE_FORMAT_JSON} {
+		m, contentType, err := marshalPeer(format, tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = decodePeer(bytes.NewReader(m), contentType, int64(len(m))-1, &got)
+		if !errors.Is(err, ErrMessageTooLarge) {
+			t.Fatalf("%s body one byte over the cap: got %v, want ErrMessageTooLarge", format, err)
+		}
+	}
+
+	deep := append(bytes.Repeat([]byte{0x81}, WIRE_MAX_DEPTH+2), 0xf6)
+	if err := decodePeer(bytes.NewReader(deep), MIME_CBOR, RPC_MAX_BODY_SIZE, &got); !errors.Is(err, ErrInvalidCBOR) {
+		t.Fatalf("deeply nested CBOR: got %v, want ErrInvalidCBOR", err)
+	}
+}
+
+func TestDroppedTransactionsTakeTheirLaterNoncesAndArrivalTimes(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w := fund(t, bc, miner, transaction.COIN/2)
+	bc.SetMaxTransactionPoolSize(3)
+	var full [][32]byte
+	for _, fee := range []transaction.Amount{1, 5, 5} {
+		full = append(full, send(t, bc, miner, w, transaction.COIN/100, fee).Hash())
+	}
+	checkPool(t, bc)
+
+	// The pool is full: the richer fee displaces the miner's, and no later nonce may stay behind.
+	rich := send(t, bc, w, miner, transaction.COIN/100, 10)
+	checkPool(t, bc, full...)
+	if _, ok := bc.PoolEntry(rich.Hash(), time.Now()); !ok {
+		t.Fatal("the higher fee transaction was not pooled")
+	}
+
+	first := bc.PoolEntries(time.Now())
+	var head *transaction.Transaction
+	for _, e := range first {
+		if tx := e.Transaction; tx.SenderBlockchainAddress() == miner.BlockchainAddress() && (head == nil || tx.Nonce() < head.Nonce()) {
+			head = tx
+		}
+	}
+	if head != nil {
+		evicted, err := bc.EvictTransaction(head.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tx := range evicted {
+			if tx.SenderBlockchainAddress() != miner.BlockchainAddress() {
+				t.Fatalf("evicted %s of another sender", tx.ID())
+			}
+		}
+		checkPool(t, bc, full...)
+	}
+
+	send(t, bc, w, miner, transaction.COIN/100, 10)
+	bc.SetTransactionPoolTTL(time.Minute)
+	bc.mux.Lock()
+	bc.pooledAt[rich.Hash()] = time.Now().Add(-time.Hour)
+	later := bc.transactionPool[len(bc.transactionPool)-1].Hash()
+	bc.mux.Unlock()
+	if n := bc.ExpireTransactions(time.Now()); n != 2 {
+		t.Fatalf("ExpireTransactions = %d, want the stale transaction and its later nonce", n)
+	}
+	checkPool(t, bc, rich.Hash(), later)
+	if _, ok := bc.ExpiredTransaction(rich.Hash()); !ok {
+		t.Fatal("the expired transaction is not remembered")
+	}
+}
+
+func TestEvictionKeepsTransactionsOthersDependOn(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w1 := fund(t, bc, miner, transaction.COIN/2)
+	w2 := fund(t, bc, miner, transaction.COIN/2)
+	bc.SetMaxTransactionPoolSize(3)
+	cheap := send(t, bc, miner, w1, transaction.COIN/100, 1)
+	send(t, bc, miner, w1, transaction.COIN/100, 9)
+	middling := send(t, bc, w1, miner, transaction.COIN/100, 3)
+
+	// The miner's fee 1 transaction is the cheapest, but its fee 9 successor needs it.
+	send(t, bc, w2, miner, transaction.COIN/100, 4)
+	if _, ok := bc.PoolEntry(cheap.Hash(), time.Now()); !ok {
+		t.Fatal("evicted a transaction a later nonce needs")
+	}
+	if _, ok := bc.PoolEntry(middling.Hash(), time.Now()); ok {
+		t.Fatal("kept the cheapest transaction nothing needs")
+	}
+	checkPool(t, bc, middling.Hash())
+
+	// w2's own fee 4 transaction is now the cheapest candidate, and its next nonce needs it.
+	tx, err := w2.NewTransaction(miner.BlockchainAddress(), transaction.COIN/100, 20, bc.NextNonce(w2.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(tx); !errors.Is(err, ErrTransactionPoolFull) {
+		t.Fatalf("AddTransaction evicting its own predecessor = %v, want %v", err, ErrTransactionPoolFull)
+	}
+	if tx, err = w1.NewTransaction(miner.BlockchainAddress(), transaction.COIN/100, 2, bc.NextNonce(w1.BlockchainAddress()), bc.ChainID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(tx); !errors.Is(err, ErrTransactionPoolFull) {
+		t.Fatalf("AddTransaction with a fee below every candidate's = %v, want %v", err, ErrTransactionPoolFull)
+	}
+	checkPool(t, bc)
+}
+
+func TestEvictionDropsTheOldestOfTheCheapest(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	senders := []*wallet.Wallet{fund(t, bc, miner, transaction.COIN/4), fund(t, bc, miner, transaction.COIN/4), fund(t, bc, miner, transaction.COIN/4)}
+	bc.SetMaxTransactionPoolSize(3)
+	var pooled [][32]byte
+	for _, w := range senders {
+		pooled = append(pooled, send(t, bc, w, miner, transaction.COIN/100, 2).Hash())
+	}
+
+	// A transaction paying no more than the cheapest is turned away rather than displacing it.
+	tx, err := miner.NewTransaction(senders[0].BlockchainAddress(), transaction.COIN/100, 2, bc.NextNonce(miner.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(tx); !errors.Is(err, ErrTransactionPoolFull) {
+		t.Fatalf("AddTransaction with the cheapest fee = %v, want %v", err, ErrTransactionPoolFull)
+	}
+	for i, want := range pooled {
+		send(t, bc, miner, senders[0], transaction.COIN/100, transaction.Amount(3+i))
+		if _, ok := bc.PoolEntry(want, time.Now()); ok {
+			t.Fatalf("transaction %d of equal fees stayed while a later one was evicted", i)
+		}
+	}
+	if n := len(bc.TransactionPool()); n != 3 {
+		t.Fatalf("the pool holds %d transactions, want 3", n)
+	}
+}
+
+func TestBlocksMaySpendContractRefundsButNotMore(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w := fund(t, bc, miner, transaction.COIN/2)
+	fee := transaction.Amount(transaction.CONTRACT_CALL_GAS * transaction.GAS_PRICE)
+	call := transaction.NewContractCall(w.BlockchainAddress(), transaction.ScriptAddress([]byte{transaction.OP_1}), nil, transaction.COIN/2-fee, fee)
+	call.SetNonce(bc.NextNonce(w.BlockchainAddress()))
+	call.SetChainID(bc.ChainID())
+	if err := call.Sign(w.PrivateKey()); err != nil {
+		t.Fatal(err)
+	}
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	check := func(value transaction.Amount) error {
+		spend, err := w.NewTransaction(miner.BlockchainAddress(), value, 0, call.Nonce()+1, bc.ChainID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// No contract is deployed there, so the call refunds its value and keeps only the fee.
+		b := sealBlock(t, bc, []*transaction.Transaction{call, spend, transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)})
+		return bc.checkBlock(bc.chain, b, bc.tipState(), bc.rules())
+	}
+	if err := check(transaction.COIN/2 - fee); err != nil {
+		t.Fatalf("checkBlock spending the refund = %v", err)
+	}
+	if err := check(transaction.COIN/2 - fee + 1); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("checkBlock spending more than the refund = %v, want %v", err, ErrInsufficientBalance)
+	}
+}
+
+// openTestChain returns a chain stored in dir by the block store named kind, with miner's address.
+func openTestChain(t *testing.T, kind, dir string, miner *wallet.Wallet) *Blockchain {
+	t.Helper()
+	blocks, err := OpenBlockStore(kind, dir, STORAGE_COMPRESSION_SNAPPY, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := NewFileStorage(dir, blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewBlockchain(miner.BlockchainAddress(), 0, storage, nil, block.NewProofOfWork(1, nil), nil)
+}
+
+func TestBoltStoreKeepsTheChainAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	miner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := openTestChain(t, BLOCK_STORE_BOLT, dir, miner)
+	fund(t, bc, miner, transaction.COIN/2)
+	fund(t, bc, miner, transaction.COIN/4)
+	want := bc.BlockHash(bc.LastBlock())
+	if _, err := OpenBoltStore(filepath.Join(dir, BLOCKS_BOLT_FILE_NAME)); err == nil {
+		t.Fatal("opened the Bolt file twice")
+	}
+	if err := bc.CloseStorage(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openTestChain(t, BLOCK_STORE_BOLT, dir, miner)
+	defer reopened.CloseStorage()
+	if got := len(reopened.Chain()); got != 3 {
+		t.Fatalf("reloaded %d blocks, want 3", got)
+	}
+	if got := reopened.BlockHash(reopened.LastBlock()); got != want {
+		t.Fatalf("reloaded tip %x, want %x", got, want)
+	}
+}
+
+func TestBoltStoreImportsTheBlockFile(t *testing.T) {
+	dir := t.TempDir()
+	miner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := openTestChain(t, BLOCK_STORE_FILE, dir, miner)
+	fund(t, bc, miner, transaction.COIN/2)
+	want := bc.BlockHash(bc.LastBlock())
+
+	imported := openTestChain(t, BLOCK_STORE_BOLT, dir, miner)
+	defer imported.CloseStorage()
+	if got := imported.BlockHash(imported.LastBlock()); got != want {
+		t.Fatalf("tip after importing the block file %x, want %x", got, want)
+	}
+}
+
+func TestClosingStopsMiningAndKeepsThePoolForTheNextStart(t *testing.T) {
+	dir := t.TempDir()
+	miner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := openTestChain(t, BLOCK_STORE_BOLT, dir, miner)
+	w := fund(t, bc, miner, transaction.COIN/2)
+	stalling := &stallingConsensus{ProofOfWork: block.NewProofOfWork(1, nil), started: make(chan struct{})}
+	bc.SetConsensus(stalling)
+	pooled := send(t, bc, w, miner, transaction.COIN/10, 0)
+
+	mined := make(chan bool)
+	go func() { mined <- bc.Mining(context.Background()) }()
+	<-stalling.started
+	bc.Close()
+	if <-mined {
+		t.Fatal("the block being sealed was added after Close")
+	}
+	if bc.Mining(context.Background()) {
+		t.Fatal("mining succeeded after Close")
+	}
+	if err := bc.SaveTransactionPool(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.CloseStorage(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openTestChain(t, BLOCK_STORE_BOLT, dir, miner)
+	defer reopened.CloseStorage()
+	if got := len(reopened.Chain()); got != 2 {
+		t.Fatalf("reloaded %d blocks, want 2", got)
+	}
+	pool := reopened.TransactionPool()
+	if len(pool) != 1 || pool[0].Hash() != pooled.Hash() {
+		t.Fatalf("reloaded pool %v, want the saved transaction", pool)
+	}
+	if !reopened.Mining(context.Background()) {
+		t.Fatal("the reloaded transaction could not be mined")
+	}
+}
+
+func TestAddTransactionRejectsBadSignatures(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := miner.NewTransaction(w.BlockchainAddress(), transaction.COIN/2, 0, bc.NextNonce(miner.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	raised := relay(t, tx, func(v map[string]any) { v["value"] = float64(transaction.COIN) })
+	if err := bc.AddTransaction(raised); !errors.Is(err, transaction.ErrInvalidSignature) {
+		t.Fatalf("AddTransaction with a changed value = %v, want %v", err, transaction.ErrInvalidSignature)
+	}
+	stolen, err := w.NewTransaction(w.BlockchainAddress(), transaction.COIN/2, 0, bc.NextNonce(miner.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stolen = relay(t, stolen, func(v map[string]any) { v["sender_blockchain_address"] = miner.BlockchainAddress() })
+	if err := bc.AddTransaction(stolen); !errors.Is(err, transaction.ErrSenderKeyMismatch) {
+		t.Fatalf("AddTransaction signed by another key = %v, want %v", err, transaction.ErrSenderKeyMismatch)
+	}
+	if n := len(bc.TransactionPool()); n != 0 {
+		t.Fatalf("%d transactions pooled", n)
+	}
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatalf("AddTransaction = %v", err)
+	}
+}
+
+func TestFindNeighborsReturnsListeningPeersButNotItself(t *testing.T) {
+	self, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer self.Close()
+	peer, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	myPort := uint16(self.Addr().(*net.TCPAddr).Port)
+	peerPort := uint16(peer.Addr().(*net.TCPAddr).Port)
+
+	if got := FindNeighbors("127.0.0.1", myPort, NeighborRange{StartPort: myPort, EndPort: myPort}); len(got) != 0 {
+		t.Fatalf("FindNeighbors over its own port = %v", got)
+	}
+	got := FindNeighbors("127.0.0.1", myPort, NeighborRange{StartPort: peerPort, EndPort: peerPort})
+	if want := []string{peer.Addr().String()}; !slices.Equal(got, want) {
+		t.Fatalf("FindNeighbors = %v, want %v", got, want)
+	}
+	peer.Close()
+	if got := FindNeighbors("127.0.0.1", myPort, NeighborRange{StartPort: peerPort, EndPort: peerPort}); len(got) != 0 {
+		t.Fatalf("FindNeighbors after the peer stopped = %v", got)
+	}
+}
+
+func TestParsePeersChecksEachAddress(t *testing.T) {
+	got, err := ParsePeers(" 10.0.0.2:5000, ,node.example:5001,[::1]:5002")
+	if want := []string{"10.0.0.2:5000", "node.example:5001", "[::1]:5002"}; err != nil || !slices.Equal(got, want) {
+		t.Fatalf("ParsePeers = %v, %v, want %v", got, err, want)
+	}
+	for _, list := range []string{"10.0.0.2", "10.0.0.2:0", ":5000", "10.0.0.2:70000", "10.0.0.2:http"} {
+		if _, err := ParsePeers(list); err == nil {
+			t.Errorf("ParsePeers(%q) succeeded", list)
+		}
+	}
+}
+
+func TestResolveSeedsListsTheSeedsAddresses(t *testing.T) {
+	got := ResolveSeeds([]string{"localhost:6000", "localhost", "localhost", "seed.invalid"})
+	for _, want := range []string{"127.0.0.1:6000", net.JoinHostPort("127.0.0.1", strconv.Itoa(DNS_SEED_DEFAULT_PORT))} {
+		if !slices.Contains(got, want) {
+			t.Errorf("ResolveSeeds = %v, want it to contain %s", got, want)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, p := range got {
+		if seen[p] {
+			t.Fatalf("ResolveSeeds = %v lists %s twice", got, p)
+		}
+		seen[p] = true
+	}
+}
+
+func TestSyncNeighborsLearnsAndRemembersPeers(t *testing.T) {
+	// The bootstrap peer reports a second peer, which knows no others, through GET /peers.
+	second := httptest.NewServer(http.NotFoundHandler())
+	defer second.Close()
+	bootstrap, _ := newTestBlockchain(t)
+	setNeighbors(bootstrap, second.Listener.Addr().String())
+	bootstrapServer := httptest.NewServer(http.HandlerFunc((&BlockchainServer{blockchain: bootstrap}).Peers))
+	defer bootstrapServer.Close()
+	bootstrapAddress := bootstrapServer.Listener.Addr().String()
+
+	dir := t.TempDir()
+	miner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := openTestChain(t, BLOCK_STORE_FILE, dir, miner)
+	bc.SetNeighborRange(NeighborRange{StartPort: 1, EndPort: 1})
+	bc.SetBootstrapPeers([]string{bootstrapAddress, "127.0.0.1:1"})
+	bc.SyncNeighbors()
+	want := []string{bootstrapAddress, second.Listener.Addr().String()}
+	if got := bc.Neighbors(); !slices.Equal(got, want) {
+		t.Fatalf("neighbors %v, want %v", got, want)
+	}
+	if err := bc.CloseStorage(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted node without bootstrap peers still knows where the network is.
+	reopened := openTestChain(t, BLOCK_STORE_FILE, dir, miner)
+	defer reopened.CloseStorage()
+	reopened.SetNeighborRange(NeighborRange{StartPort: 1, EndPort: 1})
+	reopened.SyncNeighbors()
+	if got := reopened.Neighbors(); !slices.Equal(got, want) {
+		t.Fatalf("neighbors after a restart %v, want %v", got, want)
+	}
+}
+
+// serveNeighbor serves bc's node API on a local port until the test ends and returns its host:port.
+func serveNeighbor(t *testing.T, bc *Blockchain) string {
+	t.Helper()
+	bcs := &BlockchainServer{blockchain: bc}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chain", bcs.GetChain)
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s.Listener.Addr().String()
+}
+
+// setNeighbors makes addresses bc's neighbors, as a sync would.
+func setNeighbors(bc *Blockchain, addresses ...string) {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	bc.neighbors = addresses
+}
+
+func TestResolveConflictsAdoptsTheLongestValidChain(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	longer, other := newTestBlockchain(t)
+	fund(t, longer, other, transaction.COIN/2)
+	fund(t, longer, other, transaction.COIN/2)
+	setNeighbors(bc, serveNeighbor(t, longer))
+	if !bc.ResolveConflicts(context.Background()) {
+		t.Fatal("the longer chain was not adopted")
+	}
+	if got, want := bc.BlockHash(bc.LastBlock()), longer.BlockHash(longer.LastBlock()); got != want {
+		t.Fatalf("tip %x, want the neighbor's %x", got, want)
+	}
+	if got := bc.CalculateTotalAmount(miner.BlockchainAddress()); got != 0 {
+		t.Fatalf("the abandoned genesis reward still counts: %s", got)
+	}
+
+	shorter, _ := newTestBlockchain(t)
+	setNeighbors(bc, serveNeighbor(t, shorter))
+	if bc.ResolveConflicts(context.Background()) {
+		t.Fatal("a shorter chain replaced the local one")
+	}
+}
+
+// stallingConsensus is proof of work whose first Seal closes started and then waits for its context to be
+// cancelled, as if the nonce were too hard to find.
+type stallingConsensus struct {
+	*block.ProofOfWork
+	started chan struct{}
+	once    sync.Once
+}
+
+func (c *stallingConsensus) Seal(ctx context.Context, height int, b *block.Block) error {
+	stalled := false
+	c.once.Do(func() {
+		stalled = true
+		close(c.started)
+	})
+	if stalled {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return c.ProofOfWork.Seal(ctx, height, b)
+}
+
+func TestMiningRestartsOnANewChainTip(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	longer, other := newTestBlockchain(t)
+	w := fund(t, longer, other, transaction.COIN/2)
+	setNeighbors(bc, serveNeighbor(t, longer))
+	if !bc.ResolveConflicts(context.Background()) {
+		t.Fatal("the longer chain was not adopted")
+	}
+	stalling := &stallingConsensus{ProofOfWork: block.NewProofOfWork(1, nil), started: make(chan struct{})}
+	bc.SetConsensus(stalling)
+	recipient, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pooled := send(t, bc, w, recipient, transaction.COIN/10, 0)
+
+	mined := make(chan bool)
+	go func() { mined <- bc.Mining(context.Background()) }()
+	<-stalling.started
+	fund(t, longer, other, transaction.COIN/10)
+	if !bc.ResolveConflicts(context.Background()) {
+		t.Fatal("the neighbor's new block was not adopted")
+	}
+	if !<-mined {
+		t.Fatal("mining gave up instead of restarting on the new tip")
+	}
+
+	chain := bc.Chain()
+	if got, want := len(chain), len(longer.Chain())+1; got != want {
+		t.Fatalf("chain length %d, want %d", got, want)
+	}
+	tip := chain[len(chain)-1]
+	if got, want := tip.PreviousHash(), longer.BlockHash(longer.LastBlock()); got != want {
+		t.Fatalf("mined on %x, want the neighbor's tip %x", got, want)
+	}
+	if !slices.ContainsFunc(tip.Transactions(), func(tx *transaction.Transaction) bool { return tx.Hash() == pooled.Hash() }) {
+		t.Fatal("the restarted block lacks the pooled transaction")
+	}
+}
+
+// luckyConsensus seals every block with nonce 42, accepts only that nonce, and never switches chains.
+type luckyConsensus struct{}
+
+func (luckyConsensus) Name() string { return "lucky" }
+
+func (luckyConsensus) Seal(ctx context.Context, height int, b *block.Block) error {
+	b.SetNonce(42)
+	return nil
+}
+
+func (luckyConsensus) Verify(height int, b *block.Block) error {
+	if b.Nonce() != 42 {
+		return errors.New("unlucky nonce")
+	}
+	return nil
+}
+
+func (luckyConsensus) ChooseChain(local, candidate []*block.Block) bool { return false }
+
+func TestBlockchainDefersToItsConsensusEngine(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	bc.SetConsensus(luckyConsensus{})
+	fund(t, bc, miner, transaction.COIN/2)
+	chain := bc.Chain()
+	if got := chain[len(chain)-1].Nonce(); got != 42 {
+		t.Fatalf("mined nonce %d, want the engine's 42", got)
+	}
+	if !bc.ValidChain(chain) {
+		t.Fatal("the engine rejects its own chain")
+	}
+	tampered := cloneBlock(t, chain[1])
+	tampered.SetNonce(7)
+	if bc.ValidChain([]*block.Block{chain[0], tampered}) {
+		t.Fatal("a block the engine rejects passed validation")
+	}
+
+	longer, other := newTestBlockchain(t)
+	longer.SetConsensus(luckyConsensus{})
+	fund(t, longer, other, transaction.COIN/2)
+	fund(t, longer, other, transaction.COIN/2)
+	setNeighbors(bc, serveNeighbor(t, longer))
+	if bc.ResolveConflicts(context.Background()) {
+		t.Fatal("a chain the engine did not choose replaced the local one")
+	}
+}
+
+// cloneBlock returns a copy of b decoded from its JSON encoding.
+func cloneBlock(t *testing.T, b *block.Block) *block.Block {
+	t.Helper()
+	m, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c block.Block
+	if err := json.Unmarshal(m, &c); err != nil {
+		t.Fatal(err)
+	}
+	return &c
+}
+
+func TestValidChainDetectsTampering(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w := fund(t, bc, miner, transaction.COIN/2)
+	fund(t, bc, w, transaction.COIN/4)
+	chain := bc.Chain()
+	if !bc.ValidChain(chain) {
+		t.Fatal("the node's own chain is invalid")
+	}
+	if bc.ValidChain(nil) {
+		t.Fatal("an empty chain is valid")
+	}
+
+	// Paying the recipient more changes the transaction, and so the block's Merkle root and hash.
+	tampered := cloneBlock(t, chain[1])
+	transactions := tampered.Transactions()
+	for i, tx := range transactions {
+		if tx.RecipientBlockchainAddress() == w.BlockchainAddress() {
+			transactions[i] = relay(t, tx, func(v map[string]any) { v["value"] = float64(transaction.COIN) })
+		}
+	}
+	tampered.SetTransactions(transactions)
+	if bc.ValidChain([]*block.Block{chain[0], tampered, chain[2]}) {
+		t.Fatal("a chain with an edited transaction is valid")
+	}
+	if bc.ValidChain([]*block.Block{chain[0], chain[2], chain[1]}) {
+		t.Fatal("a chain with its blocks out of order is valid")
+	}
+	if !bc.ValidChain(chain[:2]) {
+		t.Fatal("a prefix of the chain is invalid")
+	}
+}
+
+func TestBlockchainRoundTripsThroughJSON(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	bc.SetChainID("testnet")
+	w := fund(t, bc, miner, transaction.COIN/2)
+	pending := send(t, bc, w, miner, transaction.COIN/100, 1)
+	m, err := json.Marshal(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Blockchain
+	if err := json.Unmarshal(m, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ChainID() != "testnet" || got.Hasher().Name() != bc.Hasher().Name() {
+		t.Fatalf("chain ID %q and hasher %s, want %q and %s", got.ChainID(), got.Hasher().Name(), "testnet", bc.Hasher().Name())
+	}
+	want := bc.Chain()
+	if len(got.Chain()) != len(want) {
+		t.Fatalf("decoded %d blocks, want %d", len(got.Chain()), len(want))
+	}
+	for i, b := range got.Chain() {
+		if got.BlockHash(b) != bc.BlockHash(want[i]) {
+			t.Fatalf("block %d decoded with another hash", i)
+		}
+	}
+	if !bc.ValidChain(got.Chain()) {
+		t.Fatal("the decoded chain is invalid")
+	}
+	if pool := got.TransactionPool(); len(pool) != 1 || pool[0].Hash() != pending.Hash() || pool[0].Verify() != nil {
+		t.Fatalf("decoded pool %v, want the pending transaction", pool)
+	}
+
+	if err := json.Unmarshal([]byte(`{"transaction_pool": []}`), &got); err == nil {
+		t.Fatal("decoded a blockchain without a chain")
+	}
+}
+
+func TestExportedChainsImportOnlyUntampered(t *testing.T) {
+	miner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An imported chain is checked at the default difficulty.
+	bc := NewBlockchain(miner.BlockchainAddress(), 0, nil, nil, nil, nil)
+	w := fund(t, bc, miner, transaction.COIN/2)
+	path := filepath.Join(t.TempDir(), "chain.json")
+	if err := bc.Export(path); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := ImportBlockchain(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := imported.BlockHash(imported.LastBlock()), bc.BlockHash(bc.LastBlock()); got != want {
+		t.Fatalf("imported tip %x, want %x", got, want)
+	}
+	if got := imported.CalculateTotalAmount(w.BlockchainAddress()); got != transaction.COIN/2 {
+		t.Fatalf("imported balance %s, want %s", got, transaction.COIN/2)
+	}
+
+	m, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]any
+	if err := json.Unmarshal(m, &v); err != nil {
+		t.Fatal(err)
+	}
+	genesis := v["chain"].([]any)[0].(map[string]any)
+	genesis["timestamp"] = genesis["timestamp"].(float64) + 1
+	if m, err = json.Marshal(v); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, m, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportBlockchain(path); !errors.Is(err, ErrInvalidChain) {
+		t.Fatalf("ImportBlockchain of an edited genesis block = %v, want %v", err, ErrInvalidChain)
+	}
+}
+
+func TestStartMiningMinesPendingTransactionsUntilStopped(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	if !bc.StartMining(time.Millisecond) {
+		t.Fatal("StartMining = false")
+	}
+	if bc.StartMining(time.Millisecond) {
+		t.Fatal("StartMining while mining = true")
+	}
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(t, bc, miner, w, transaction.COIN/2, 0)
+	for deadline := time.Now().Add(5 * time.Second); bc.CalculateTotalAmount(w.BlockchainAddress()) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the pending transaction was not mined")
+		}
+	}
+	if !bc.StopMining() {
+		t.Fatal("StopMining = false")
+	}
+	if bc.StopMining() {
+		t.Fatal("StopMining when stopped = true")
+	}
+	height := len(bc.Chain())
+	send(t, bc, miner, w, transaction.COIN/4, 0)
+	time.Sleep(20 * time.Millisecond)
+	if got := len(bc.Chain()); got != height {
+		t.Fatalf("a block was mined after StopMining: height %d, want %d", got, height)
+	}
+}
+
+func TestMiningSkipsEmptyBlocks(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	if bc.Mining(context.Background()) {
+		t.Fatal("Mining with an empty pool = true")
+	}
+	if got := len(bc.Chain()); got != 1 {
+		t.Fatalf("height %d after mining an empty pool, want only the genesis block", got)
+	}
+	if got := bc.CalculateTotalAmount(miner.BlockchainAddress()); got != MINING_REWARD {
+		t.Fatalf("miner holds %s, want only the genesis reward", got)
+	}
+}
+
+func TestConcurrentSubmissionsMiningAndReads(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	var senders []*wallet.Wallet
+	for range 4 {
+		w, err := wallet.NewWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		send(t, bc, miner, w, transaction.COIN/8, 0)
+		senders = append(senders, w)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	recipient, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const transfers = 5
+	done := make(chan struct{})
+	var miners, readers sync.WaitGroup
+	miners.Go(func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				bc.Mining(context.Background())
+			}
+		}
+	})
+	readers.Go(func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				bc.CalculateTotalAmount(recipient.BlockchainAddress())
+				bc.TransactionPool()
+				bc.ValidChain(bc.Chain())
+			}
+		}
+	})
+	var wg sync.WaitGroup
+	for _, w := range senders {
+		wg.Go(func() {
+			for range transfers {
+				tx, err := w.NewTransaction(recipient.BlockchainAddress(), transaction.COIN/100, 0, bc.NextNonce(w.BlockchainAddress()), bc.ChainID())
+				if err == nil {
+					err = bc.AddTransaction(tx)
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	close(done)
+	miners.Wait()
+	readers.Wait()
+	for len(bc.TransactionPool()) > 0 {
+		bc.Mining(context.Background())
+	}
+	if got, want := bc.CalculateTotalAmount(recipient.BlockchainAddress()), transaction.Amount(len(senders)*transfers)*transaction.COIN/100; got != want {
+		t.Fatalf("recipient holds %s, want %s", got, want)
+	}
+	if !bc.ValidChain(bc.Chain()) {
+		t.Fatal("the chain is invalid")
+	}
+}
+
+func TestBlocksTakeTheHighestFeesAndPayThemToTheMiner(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	var senders []*wallet.Wallet
+	for range 3 {
+		senders = append(senders, fund(t, bc, miner, transaction.COIN/10))
+	}
+	// Room for two transactions besides the coinbase.
+	bc.SetMaxBlockTransactions(3)
+	fees := []transaction.Amount{1, 500, 30}
+	var sent []*transaction.Transaction
+	for i, w := range senders {
+		sent = append(sent, send(t, bc, w, miner, transaction.COIN/100, fees[i]))
+	}
+	before := bc.CalculateTotalAmount(miner.BlockchainAddress())
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	transactions := bc.LastBlock().Transactions()
+	if len(transactions) != 3 || transactions[0].Hash() != sent[1].Hash() || transactions[1].Hash() != sent[2].Hash() {
+		t.Fatalf("block holds %d transactions, want the fee 500 and fee 30 ones first", len(transactions))
+	}
+	if coinbase := transactions[2]; coinbase.Value() != MINING_REWARD+530 {
+		t.Fatalf("coinbase pays %s, want the reward and the fees", coinbase.Value())
+	}
+	if got, want := bc.CalculateTotalAmount(miner.BlockchainAddress()), before+MINING_REWARD+530+2*transaction.COIN/100; got != want {
+		t.Fatalf("miner holds %s, want %s", got, want)
+	}
+	if got, want := bc.CalculateTotalAmount(senders[1].BlockchainAddress()), transaction.COIN/10-transaction.COIN/100-500; got != want {
+		t.Fatalf("sender holds %s, want %s after paying the fee", got, want)
+	}
+	if pool := bc.TransactionPool(); len(pool) != 1 || pool[0].Hash() != sent[0].Hash() {
+		t.Fatal("the lowest fee transaction did not stay pooled")
+	}
+}
+
+func TestAddTransactionRejectsOverdrafts(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w := fund(t, bc, miner, transaction.COIN/2)
+	overdraft := func(value, fee transaction.Amount) error {
+		t.Helper()
+		tx, err := w.NewTransaction(miner.BlockchainAddress(), value, fee, bc.NextNonce(w.BlockchainAddress()), bc.ChainID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bc.AddTransaction(tx)
+	}
+	if err := overdraft(transaction.COIN/2+1, 0); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("AddTransaction above the balance = %v, want %v", err, ErrInsufficientBalance)
+	}
+	if err := overdraft(transaction.COIN/2, 1); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("AddTransaction whose fee overdraws = %v, want %v", err, ErrInsufficientBalance)
+	}
+	// Pending spends count: the second transfer fits the balance alone, but not after the first.
+	if err := overdraft(transaction.COIN/4, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := overdraft(transaction.COIN/4+1, 0); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("AddTransaction overdrawing with a pending spend = %v, want %v", err, ErrInsufficientBalance)
+	}
+	if err := overdraft(transaction.COIN/4, 0); err != nil {
+		t.Fatalf("AddTransaction spending the rest = %v", err)
+	}
+}
+
+func TestDuplicateTransactionsAreRejected(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := send(t, bc, miner, w, transaction.COIN/2, 0)
+	if got, ok := bc.TransactionByID(tx.Hash()); !ok || got.Height != -1 {
+		t.Fatalf("TransactionByID of a pending transaction = %+v, %t", got, ok)
+	}
+	if err := bc.AddTransaction(tx); !errors.Is(err, ErrDuplicateTransaction) {
+		t.Fatalf("AddTransaction of a pooled transaction = %v, want %v", err, ErrDuplicateTransaction)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	if got, ok := bc.TransactionByID(tx.Hash()); !ok || got.Height != 1 {
+		t.Fatalf("TransactionByID of a mined transaction = %+v, %t", got, ok)
+	}
+	if err := bc.AddTransaction(tx); !errors.Is(err, ErrDuplicateTransaction) {
+		t.Fatalf("AddTransaction of a mined transaction = %v, want %v", err, ErrDuplicateTransaction)
+	}
+
+	// A block may not confirm a transaction twice, in itself or again after an earlier block.
+	next, err := miner.NewTransaction(w.BlockchainAddress(), transaction.COIN/4, 0, bc.NextNonce(miner.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	reward := transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)
+	for name, transactions := range map[string][]*transaction.Transaction{
+		"again":        {tx, reward},
+		"within block": {next, next, reward},
+	} {
+		b := sealBlock(t, bc, transactions)
+		if err := bc.checkBlock(bc.chain, b, bc.tipState(), bc.rules()); !errors.Is(err, ErrDuplicateTransaction) {
+			t.Errorf("%s: checkBlock = %v, want %v", name, err, ErrDuplicateTransaction)
+		}
+	}
+}
+
+func TestTransactionByIDFollowsTheChainThroughReorganizations(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var confirmed []*transaction.Transaction
+	for _, tx := range []func() *transaction.Transaction{
+		func() *transaction.Transaction { return send(t, bc, miner, w, transaction.COIN/2, 0) },
+		func() *transaction.Transaction { return send(t, bc, w, miner, transaction.COIN/4, 0) },
+	} {
+		confirmed = append(confirmed, tx())
+		if !bc.Mining(context.Background()) {
+			t.Fatal("mining failed")
+		}
+	}
+	first, second := confirmed[0], confirmed[1]
+	for i, tx := range confirmed {
+		got, ok := bc.TransactionByID(tx.Hash())
+		if height := i + 1; !ok || got.Height != height || got.Transaction.Hash() != tx.Hash() || got.Timestamp != bc.Chain()[height].Timestamp() {
+			t.Fatalf("TransactionByID of the transaction at height %d = %+v, %t", height, got, ok)
+		}
+	}
+
+	// A chain without the last block no longer confirms its transactions.
+	bc.mux.Lock()
+	bc.setChain(bc.chain[:2])
+	bc.mux.Unlock()
+	if got, ok := bc.TransactionByID(second.Hash()); ok {
+		t.Fatalf("TransactionByID of a transaction in a dropped block = %+v", got)
+	}
+	if got, ok := bc.TransactionByID(first.Hash()); !ok || got.Height != 1 {
+		t.Fatalf("TransactionByID of a transaction still confirmed = %+v, %t", got, ok)
+	}
+}
+
+// replayBalances sums the transfers of chain from scratch, as balances were computed before the index.
+func replayBalances(chain []*block.Block) map[string]transaction.Amount {
+	balances := make(map[string]transaction.Amount)
+	for _, b := range chain {
+		for _, tx := range b.Transactions() {
+			balances[tx.SenderBlockchainAddress()] -= tx.Value() + tx.Fee()
+			balances[tx.RecipientBlockchainAddress()] += tx.Value()
+		}
+	}
+	return balances
+}
+
+func TestBalanceIndexMatchesAReplayOfTheChain(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	check := func(when string) {
+		t.Helper()
+		for address, want := range replayBalances(bc.Chain()) {
+			if address == transaction.MINING_SENDER {
+				continue
+			}
+			if got := bc.CalculateTotalAmount(address); got != want {
+				t.Fatalf("%s: balance of %s is %s, a replay gives %s", when, address, got, want)
+			}
+		}
+	}
+	w := fund(t, bc, miner, transaction.COIN/2)
+	send(t, bc, w, miner, transaction.COIN/10, 7)
+	send(t, bc, miner, w, transaction.COIN/20, 3)
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	check("after mining")
+
+	longer, other := newTestBlockchain(t)
+	for range 3 {
+		fund(t, longer, other, transaction.COIN/3)
+	}
+	setNeighbors(bc, serveNeighbor(t, longer))
+	if !bc.ResolveConflicts(context.Background()) {
+		t.Fatal("the longer chain was not adopted")
+	}
+	check("after a reorganization")
+	if got := bc.CalculateTotalAmount(w.BlockchainAddress()); got != 0 {
+		t.Fatalf("an abandoned balance is still indexed: %s", got)
+	}
+}
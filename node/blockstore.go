@@ -0,0 +1,219 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+
+	"github.com/dikako/how-blockchain-works/block"
+)
+
+const (
+	BLOCK_STORE_BOLT   = "bolt"
+	BLOCK_STORE_FILE   = "file"
+	BLOCK_STORE_KV     = "kv"
+	BLOCK_STORE_MEMORY = "memory"
+)
+
+var (
+	ErrBlockNotStored     = errors.New("block not stored")
+	ErrUnknownBlockStore  = errors.New("unknown block store")
+	ErrCorruptStoredBlock = errors.New("corrupt stored block")
+)
+
+// BlockStore persists the chain, genesis first. The node keeps its chain in memory and writes through to
+// the store, PutBlock as blocks are added at the tip and ReplaceChain when consensus adopts another chain,
+// then reads it back with ForEach on start. Blocks are looked up by their hash under the chain's hasher.
+type BlockStore interface {
+	// PutBlock stores b as the block after the tip.
+	PutBlock(b *block.Block) error
+	// ReplaceChain atomically replaces every stored block with chain.
+	ReplaceChain(chain []*block.Block) error
+	// BlockByHeight returns the block at height, or ErrBlockNotStored.
+	BlockByHeight(height int) (*block.Block, error)
+	// BlockByHash returns the block with hash and its height, or ErrBlockNotStored.
+	BlockByHash(hash [32]byte) (*block.Block, int, error)
+	// Tip returns the last block and its height, or ErrBlockNotStored if the store is empty.
+	Tip() (*block.Block, int, error)
+	// ForEach calls fn with every block in order, stopping at the first error, which it returns.
+	ForEach(fn func(height int, b *block.Block) error) error
+	// Path returns where the blocks are kept, for logs; empty for a store in memory.
+	Path() string
+}
+
+// OpenBlockStore opens the block store named kind (BLOCK_STORE_BOLT, BLOCK_STORE_FILE, BLOCK_STORE_KV, or
+// BLOCK_STORE_MEMORY) in dataDir, storing blocks under compression and looking them up by their hash under
+// hasher.
+func OpenBlockStore(kind, dataDir, compression string, hasher block.Hasher) (BlockStore, error) {
+	switch kind {
+	case BLOCK_STORE_BOLT:
+		db, err := OpenBoltStore(filepath.Join(dataDir, BLOCKS_BOLT_FILE_NAME))
+		if err != nil {
+			return nil, err
+		}
+		store, err := NewKVBlockStore(db, compression, hasher)
+		if err == nil {
+			err = importBlockFile(store, dataDir, compression, hasher)
+		}
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		return store, nil
+	case BLOCK_STORE_FILE:
+		return NewFileBlockStore(dataDir, compression, hasher)
+	case BLOCK_STORE_KV:
+		kv, err := OpenLogStore(filepath.Join(dataDir, BLOCKS_KV_FILE_NAME))
+		if err != nil {
+			return nil, err
+		}
+		return NewKVBlockStore(kv, compression, hasher)
+	case BLOCK_STORE_MEMORY:
+		return NewMemoryBlockStore(hasher), nil
+	}
+	return nil, fmt.Errorf("%w %q (available: %s, %s, %s, %s)", ErrUnknownBlockStore, kind, BLOCK_STORE_BOLT, BLOCK_STORE_FILE, BLOCK_STORE_KV, BLOCK_STORE_MEMORY)
+}
+
+// importBlockFile copies the chain of the block file in dataDir into store if store is empty, so a node
+// that kept its chain in the file before Bolt became the default keeps it.
+func importBlockFile(store BlockStore, dataDir, compression string, hasher block.Hasher) error {
+	if _, _, err := store.Tip(); !errors.Is(err, ErrBlockNotStored) {
+		return err
+	}
+	file, err := NewFileBlockStore(dataDir, compression, hasher)
+	if err != nil {
+		return err
+	}
+	chain, err := readStoredChain(file)
+	if err != nil || len(chain) == 0 {
+		return err
+	}
+	log.Printf("action=import_block_file, from=%s, to=%s, length=%d", file.Path(), store.Path(), len(chain))
+	return store.ReplaceChain(chain)
+}
+
+// encodeStoredBlock appends to dst a byte saying whether b's JSON that follows is snappy-compressed, which
+// it is unless compression is STORAGE_COMPRESSION_NONE.
+func encodeStoredBlock(dst []byte, b *block.Block, compression string) ([]byte, error) {
+	m, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	if compression == STORAGE_COMPRESSION_NONE {
+		return append(append(dst, 0), m...), nil
+	}
+	return append(append(dst, 1), SnappyEncode(m)...), nil
+}
+
+// decodeStoredBlock returns the block encodeStoredBlock encoded in data.
+func decodeStoredBlock(data []byte) (*block.Block, error) {
+	if len(data) == 0 {
+		return nil, ErrCorruptStoredBlock
+	}
+	m := data[1:]
+	if data[0] == 1 {
+		var err error
+		if m, err = SnappyDecode(m); err != nil {
+			return nil, err
+		}
+	}
+	b := new(block.Block)
+	if err := json.Unmarshal(m, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readStoredChain reads every block of store in order.
+func readStoredChain(store BlockStore) ([]*block.Block, error) {
+	chain := make([]*block.Block, 0)
+	err := store.ForEach(func(height int, b *block.Block) error {
+		chain = append(chain, b)
+		return nil
+	})
+	return chain, err
+}
+
+// MemoryBlockStore keeps the chain in memory only, so a node using it starts from genesis again after a
+// restart. It serves tests and throwaway nodes, and shows the least a BlockStore has to do.
+type MemoryBlockStore struct {
+	hasher block.Hasher
+
+	mux     sync.Mutex
+	blocks  []*block.Block
+	heights map[[32]byte]int
+}
+
+// NewMemoryBlockStore returns an empty store that looks blocks up by their hash under hasher (nil for SHA-256).
+func NewMemoryBlockStore(hasher block.Hasher) *MemoryBlockStore {
+	if hasher == nil {
+		hasher = block.SHA256Hasher{}
+	}
+	return &MemoryBlockStore{hasher: hasher, heights: make(map[[32]byte]int)}
+}
+
+func (s *MemoryBlockStore) PutBlock(b *block.Block) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.heights[b.HashWithCache(s.hasher)] = len(s.blocks)
+	s.blocks = append(s.blocks, b)
+	return nil
+}
+
+func (s *MemoryBlockStore) ReplaceChain(chain []*block.Block) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.blocks = append([]*block.Block(nil), chain...)
+	s.heights = make(map[[32]byte]int, len(chain))
+	for height, b := range chain {
+		s.heights[b.HashWithCache(s.hasher)] = height
+	}
+	return nil
+}
+
+func (s *MemoryBlockStore) BlockByHeight(height int) (*block.Block, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if height < 0 || height >= len(s.blocks) {
+		return nil, ErrBlockNotStored
+	}
+	return s.blocks[height], nil
+}
+
+func (s *MemoryBlockStore) BlockByHash(hash [32]byte) (*block.Block, int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	height, ok := s.heights[hash]
+	if !ok {
+		return nil, 0, ErrBlockNotStored
+	}
+	return s.blocks[height], height, nil
+}
+
+func (s *MemoryBlockStore) Tip() (*block.Block, int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if len(s.blocks) == 0 {
+		return nil, 0, ErrBlockNotStored
+	}
+	return s.blocks[len(s.blocks)-1], len(s.blocks) - 1, nil
+}
+
+func (s *MemoryBlockStore) ForEach(fn func(height int, b *block.Block) error) error {
+	s.mux.Lock()
+	blocks := append([]*block.Block(nil), s.blocks...)
+	s.mux.Unlock()
+	for height, b := range blocks {
+		if err := fn(height, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryBlockStore) Path() string {
+	return ""
+}
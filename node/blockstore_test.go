@@ -0,0 +1,167 @@
+package node
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// closeStore closes store if it holds a file open.
+func closeStore(t *testing.T, store BlockStore) {
+	t.Helper()
+	if c, ok := store.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBlockStoresKeepTheChainInOrder(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	for range 3 {
+		fund(t, bc, miner, transaction.COIN/8)
+	}
+	chain := bc.Chain()
+	fork := cloneBlock(t, chain[2])
+	fork.SetTimestamp(fork.Timestamp() + 1)
+	forked := append(append([]*block.Block(nil), chain[:2]...), fork)
+	stop := errors.New("stop")
+
+	for _, kind := range []string{BLOCK_STORE_MEMORY, BLOCK_STORE_FILE, BLOCK_STORE_KV, BLOCK_STORE_BOLT} {
+		dir := t.TempDir()
+		store, err := OpenBlockStore(kind, dir, STORAGE_COMPRESSION_SNAPPY, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := store.Tip(); !errors.Is(err, ErrBlockNotStored) {
+			t.Errorf("%s: Tip of an empty store = %v, want %v", kind, err, ErrBlockNotStored)
+		}
+		for _, b := range chain {
+			if err := store.PutBlock(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for height, want := range chain {
+			if b, err := store.BlockByHeight(height); err != nil || b.Hash() != want.Hash() {
+				t.Errorf("%s: BlockByHeight(%d) = %v", kind, height, err)
+			}
+			if b, h, err := store.BlockByHash(want.Hash()); err != nil || h != height || b.Hash() != want.Hash() {
+				t.Errorf("%s: BlockByHash of block %d = height %d, %v", kind, height, h, err)
+			}
+		}
+		if _, err := store.BlockByHeight(len(chain)); !errors.Is(err, ErrBlockNotStored) {
+			t.Errorf("%s: BlockByHeight past the tip = %v, want %v", kind, err, ErrBlockNotStored)
+		}
+		var visited []int
+		err = store.ForEach(func(height int, b *block.Block) error {
+			visited = append(visited, height)
+			if height == 1 {
+				return stop
+			}
+			return nil
+		})
+		if !errors.Is(err, stop) || len(visited) != 2 {
+			t.Errorf("%s: ForEach stopping at height 1 = %v after %v", kind, err, visited)
+		}
+
+		// Replacing the chain with a shorter fork forgets the blocks it drops.
+		if err := store.ReplaceChain(forked); err != nil {
+			t.Fatal(err)
+		}
+		if kind != BLOCK_STORE_MEMORY {
+			closeStore(t, store)
+			if store, err = OpenBlockStore(kind, dir, STORAGE_COMPRESSION_SNAPPY, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if b, h, err := store.Tip(); err != nil || h != 2 || b.Hash() != fork.Hash() {
+			t.Errorf("%s: Tip after replacing the chain = height %d, %v; want the fork at 2", kind, h, err)
+		}
+		for _, dropped := range chain[2:] {
+			if _, _, err := store.BlockByHash(dropped.Hash()); !errors.Is(err, ErrBlockNotStored) {
+				t.Errorf("%s: BlockByHash of a replaced block = %v, want %v", kind, err, ErrBlockNotStored)
+			}
+		}
+		if got, err := readStoredChain(store); err != nil || len(got) != len(forked) {
+			t.Errorf("%s: read %d blocks, %v; want %d", kind, len(got), err, len(forked))
+		}
+		closeStore(t, store)
+	}
+
+	if _, err := OpenBlockStore("paper", t.TempDir(), STORAGE_COMPRESSION_SNAPPY, nil); !errors.Is(err, ErrUnknownBlockStore) {
+		t.Fatalf("OpenBlockStore of an unknown kind = %v, want %v", err, ErrUnknownBlockStore)
+	}
+}
+
+func TestLogStoreDropsARecordCutShort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), BLOCKS_KV_FILE_NAME)
+	kv, err := OpenLogStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Apply(map[string][]byte{"a": []byte("1"), "b": []byte("2")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Apply(map[string][]byte{"c": []byte("3")}, []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second batch is lost whole: "a" is still there and "c" is not.
+	kv, err = OpenLogStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	for key, want := range map[string]string{"a": "1", "b": "2", "c": ""} {
+		if value, ok, err := kv.Get(key); err != nil || string(value) != want || ok != (want != "") {
+			t.Errorf("Get(%q) = %q, %v, %v; want %q", key, value, ok, err, want)
+		}
+	}
+	if err := kv.Apply(map[string][]byte{"c": []byte("4")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if value, _, err := kv.Get("c"); err != nil || string(value) != "4" {
+		t.Fatalf("Get after writing past the dropped record = %q, %v", value, err)
+	}
+}
+
+func TestStoredBlocksDecodeBackAndRejectCorruption(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	genesis := bc.Chain()[0]
+	for _, compression := range []string{STORAGE_COMPRESSION_NONE, STORAGE_COMPRESSION_SNAPPY} {
+		data, err := encodeStoredBlock([]byte("prefix"), genesis, compression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data[:6]) != "prefix" || data[6] != map[string]byte{STORAGE_COMPRESSION_NONE: 0, STORAGE_COMPRESSION_SNAPPY: 1}[compression] {
+			t.Fatalf("%s: encodeStoredBlock = %x", compression, data[:7])
+		}
+		if b, err := decodeStoredBlock(data[6:]); err != nil || b.Hash() != genesis.Hash() {
+			t.Fatalf("%s: decodeStoredBlock = %v, want the genesis block", compression, err)
+		}
+		if _, err := decodeStoredBlock(data[6 : len(data)-1]); err == nil {
+			t.Fatalf("%s: decodeStoredBlock of a block cut short succeeded", compression)
+		}
+	}
+	if _, err := decodeStoredBlock(nil); !errors.Is(err, ErrCorruptStoredBlock) {
+		t.Fatalf("decodeStoredBlock of nothing = %v, want %v", err, ErrCorruptStoredBlock)
+	}
+	if _, _, err := decodeBlock([]byte{0x80}); !errors.Is(err, ErrCorruptStoredBlock) {
+		t.Fatalf("decodeBlock of a truncated height = %v, want %v", err, ErrCorruptStoredBlock)
+	}
+}
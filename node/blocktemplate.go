@@ -0,0 +1,91 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+var (
+	ErrNotProofOfWork = errors.New("block templates need proof-of-work consensus")
+	ErrDuplicateBlock = errors.New("block is already in the chain")
+)
+
+// BlockTemplate is the work for the next block handed to an external miner, in the spirit of Bitcoin's
+// getblocktemplate: Block is the unsealed candidate on the tip at Height, with the selected pending
+// transactions and, last, the coinbase paying CoinbaseValue (reward plus fees) to the miner's address.
+// The miner searches for a nonce whose proof-of-work hash, Hasher over HeaderPrefix + decimal nonce +
+// HeaderSuffix, is at most Target (in hex, so the two compare as strings), sets it in Block, and submits
+// Block back. The timestamp
+// is not part of the proof, so the miner may raise it, but it must stay at or after MinTime.
+type BlockTemplate struct {
+	Height        int                `json:"height"`
+	PreviousHash  string             `json:"previous_hash"`
+	Difficulty    float64            `json:"difficulty"`
+	Target        string             `json:"target"`
+	Hasher        string             `json:"hasher"`
+	MinTime       int64              `json:"min_time"`
+	CoinbaseValue transaction.Amount `json:"coinbase_value"`
+	HeaderPrefix  string             `json:"header_prefix"`
+	HeaderSuffix  string             `json:"header_suffix"`
+	Block         *block.Block       `json:"block"`
+}
+
+// NewBlockTemplate assembles the next block for an external miner whose coinbase pays address, or the
+// node's miner address if it is empty. Like Mining, it returns ErrEmptyTransactionPool rather than a block
+// of the reward alone.
+func (bc *Blockchain) NewBlockTemplate(address string) (*BlockTemplate, error) {
+	p, ok := bc.Consensus().(*block.ProofOfWork)
+	if !ok {
+		return nil, ErrNotProofOfWork
+	}
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	if bc.closed {
+		return nil, ErrBlockchainClosed
+	}
+	if len(bc.transactionPool) == 0 {
+		return nil, ErrEmptyTransactionPool
+	}
+	if address == "" {
+		address = bc.blockchainAddress
+	}
+	b := bc.candidateBlock(address)
+	prefix, suffix := p.ProofHeader(b)
+	return &BlockTemplate{
+		Height:        len(bc.chain),
+		PreviousHash:  fmt.Sprintf("%x", b.PreviousHash()),
+		Difficulty:    p.Difficulty(),
+		Target:        block.FormatTarget(p.Target()),
+		Hasher:        p.Hasher().Name(),
+		MinTime:       medianTimePast(bc.chain) + 1,
+		CoinbaseValue: b.Transactions()[len(b.Transactions())-1].Value(),
+		HeaderPrefix:  string(prefix),
+		HeaderSuffix:  string(suffix),
+		Block:         b,
+	}, nil
+}
+
+// SubmitBlock appends a block solved by an external miner if it extends the tip and is valid there, then
+// announces it to the neighbors like a mined block. A block on an older tip gets ErrStaleTip: the miner
+// should fetch a new template.
+func (bc *Blockchain) SubmitBlock(ctx context.Context, b *block.Block) error {
+	hash := bc.BlockHash(b)
+	if _, _, ok := bc.BlockByHash(hash); ok {
+		return ErrDuplicateBlock
+	}
+	if b.PreviousHash() != bc.BlockHash(bc.LastBlock()) {
+		return ErrStaleTip
+	}
+	if err := bc.appendValidBlock(b); err != nil {
+		return err
+	}
+	log.Printf("action=submit_block, status=accepted, hash=%x, transactions=%d", hash, len(b.Transactions()))
+	go bc.announceBlock(context.Background(), b)
+	bc.connectOrphans(hash)
+	return nil
+}
@@ -0,0 +1,103 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+// solveTemplate searches for the nonce of bt's block the way an external miner would, from the template's
+// header parts, hasher, and target alone.
+func solveTemplate(t *testing.T, bt *BlockTemplate) *block.Block {
+	t.Helper()
+	hasher, err := block.HasherByName(bt.Hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for nonce := 0; ; nonce++ {
+		if h := hasher.Sum256([]byte(bt.HeaderPrefix + strconv.Itoa(nonce) + bt.HeaderSuffix)); fmt.Sprintf("%x", h) <= bt.Target {
+			bt.Block.SetNonce(nonce)
+			return bt.Block
+		}
+	}
+}
+
+func TestExternalMinersSolveAndSubmitBlockTemplates(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	if rec := serve(t, bcs.BlockTemplate, http.MethodGet, "/blocktemplate", nil, nil); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("GET /blocktemplate with an empty pool: %d, want 503", rec.Code)
+	}
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := send(t, bc, miner, w, transaction.COIN/4, 7)
+	var bt BlockTemplate
+	if rec := serve(t, bcs.BlockTemplate, http.MethodGet, "/blocktemplate?address="+w.BlockchainAddress(), nil, &bt); rec.Code != http.StatusOK {
+		t.Fatalf("GET /blocktemplate: %d %s", rec.Code, rec.Body)
+	}
+	txs := bt.Block.Transactions()
+	coinbase := txs[len(txs)-1]
+	if bt.Height != 1 || bt.PreviousHash != fmt.Sprintf("%x", bc.BlockHash(bc.LastBlock())) || len(txs) != 2 || txs[0].ID() != tx.ID() {
+		t.Fatalf("template at height %d on %s with %d transactions, want the pooled one on the genesis block", bt.Height, bt.PreviousHash, len(txs))
+	}
+	if coinbase.RecipientBlockchainAddress() != w.BlockchainAddress() || bt.CoinbaseValue != MINING_REWARD+7 || coinbase.Value() != bt.CoinbaseValue {
+		t.Fatalf("coinbase pays %s to %s, want the reward and fee to the requested address", coinbase.Value(), coinbase.RecipientBlockchainAddress())
+	}
+	if rec := serve(t, bcs.BlockTemplate, http.MethodGet, "/blocktemplate?address=nope", nil, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("GET /blocktemplate with an invalid address: %d, want 400", rec.Code)
+	}
+
+	// Raising the coinbase breaks the block's Merkle root, so the node turns it away.
+	b := solveTemplate(t, &bt)
+	coinbase.SetValue(bt.CoinbaseValue + 1)
+	if rec := serve(t, bcs.BlockTemplate, http.MethodPost, "/blocktemplate", b, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("POST /blocktemplate of a tampered block: %d, want 400", rec.Code)
+	}
+	coinbase.SetValue(bt.CoinbaseValue)
+	if rec := serve(t, bcs.BlockTemplate, http.MethodPost, "/blocktemplate", b, nil); rec.Code != http.StatusCreated {
+		t.Fatalf("POST /blocktemplate: %d %s", rec.Code, rec.Body)
+	}
+	if got := bc.CalculateTotalAmount(w.BlockchainAddress()); got != transaction.COIN/4+bt.CoinbaseValue {
+		t.Fatalf("the miner's address holds %s, want the transfer and the coinbase", got)
+	}
+	if rec := serve(t, bcs.BlockTemplate, http.MethodPost, "/blocktemplate", b, nil); rec.Code != http.StatusConflict {
+		t.Fatalf("POST /blocktemplate of the block again: %d, want 409", rec.Code)
+	}
+
+	// A template the chain has moved past is stale.
+	send(t, bc, miner, w, transaction.COIN/4, 0)
+	stale, err := bc.NewBlockTemplate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	if err := bc.SubmitBlock(context.Background(), solveTemplate(t, stale)); !errors.Is(err, ErrStaleTip) {
+		t.Fatalf("SubmitBlock on an old tip = %v, want %v", err, ErrStaleTip)
+	}
+}
+
+func TestBlockTemplatesNeedProofOfWork(t *testing.T) {
+	signer, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	poa, err := block.NewProofOfAuthority([]string{signer.BlockchainAddress()}, signer.PrivateKey(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := NewBlockchain(signer.BlockchainAddress(), 0, nil, nil, poa, nil)
+	if _, err := bc.NewBlockTemplate(""); !errors.Is(err, ErrNotProofOfWork) {
+		t.Fatalf("NewBlockTemplate under proof of authority = %v, want %v", err, ErrNotProofOfWork)
+	}
+}
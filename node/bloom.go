@@ -0,0 +1,210 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+const (
+	// BLOOM_MAX_BYTES and BLOOM_MAX_HASHES bound a filter the way BIP 37 does, so a client cannot make the
+	// node spend unbounded memory or hashing per transaction.
+	BLOOM_MAX_BYTES  = 36000
+	BLOOM_MAX_HASHES = 50
+
+	EVENT_MERKLE_BLOCK = "merkle_block"
+
+	FILTER_LOAD  = "filterload"
+	FILTER_ADD   = "filteradd"
+	FILTER_CLEAR = "filterclear"
+)
+
+var ErrInvalidBloomFilter = errors.New("invalid bloom filter")
+
+// BloomFilter is a probabilistic set of addresses: Test never misses an added element, but also matches
+// others with a small false-positive rate. A light client loads one with the addresses it watches; the
+// false positives hide which of the matching transactions are really its own.
+type BloomFilter struct {
+	bits   []byte
+	hashes uint32
+	tweak  uint32
+}
+
+// NewBloomFilter sizes a filter for elements entries at the false-positive rate fpRate, within the BIP 37
+// limits. tweak varies the hash functions, so filters of different clients do not collide alike.
+func NewBloomFilter(elements int, fpRate float64, tweak uint32) *BloomFilter {
+	n := float64(max(elements, 1))
+	m := math.Ceil(-n * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	size := min(max(int(math.Ceil(m/8)), 1), BLOOM_MAX_BYTES)
+	k := min(max(uint32(math.Round(float64(size*8)/n*math.Ln2)), 1), BLOOM_MAX_HASHES)
+	return &BloomFilter{bits: make([]byte, size), hashes: k, tweak: tweak}
+}
+
+// positions returns the k bit positions of data. They come from one SHA-256 by enhanced double hashing,
+// x += y, y += i, which is as good as k independent hash functions for a Bloom filter. Plain double hashing,
+// h1 + i*h2, repeats positions within a few steps when h2 shares factors with the bit count, so a small
+// filter set far fewer than k bits and matched many times its false-positive rate.
+func (f *BloomFilter) positions(data []byte) []uint32 {
+	var seed [4]byte
+	binary.BigEndian.PutUint32(seed[:], f.tweak)
+	sum := sha256.Sum256(append(seed[:], data...))
+	x, y := binary.BigEndian.Uint64(sum[0:8]), binary.BigEndian.Uint64(sum[8:16])
+	m := uint64(len(f.bits) * 8)
+	positions := make([]uint32, f.hashes)
+	for i := range positions {
+		positions[i] = uint32(x % m)
+		x += y
+		y += uint64(i)
+	}
+	return positions
+}
+
+// Add inserts data into the filter.
+func (f *BloomFilter) Add(data []byte) {
+	for _, p := range f.positions(data) {
+		f.bits[p/8] |= 1 << (p % 8)
+	}
+}
+
+// Test reports whether data may have been added; false means it certainly was not.
+func (f *BloomFilter) Test(data []byte) bool {
+	for _, p := range f.positions(data) {
+		if f.bits[p/8]&(1<<(p%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesTransaction reports whether the sender or the recipient of t may be in the filter.
+func (f *BloomFilter) MatchesTransaction(t *transaction.Transaction) bool {
+	return f.Test([]byte(t.SenderBlockchainAddress())) || f.Test([]byte(t.RecipientBlockchainAddress()))
+}
+
+// MarshalJSON encodes the filter as {"bits": hex, "hashes", "tweak"}.
+func (f *BloomFilter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Bits   string `json:"bits"`
+		Hashes uint32 `json:"hashes"`
+		Tweak  uint32 `json:"tweak"`
+	}{hex.EncodeToString(f.bits), f.hashes, f.tweak})
+}
+
+// UnmarshalJSON decodes a filter written by MarshalJSON, rejecting one beyond the BIP 37 limits.
+func (f *BloomFilter) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Bits   string `json:"bits"`
+		Hashes uint32 `json:"hashes"`
+		Tweak  uint32 `json:"tweak"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	bits, err := hex.DecodeString(v.Bits)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBloomFilter, err)
+	}
+	if len(bits) == 0 || len(bits) > BLOOM_MAX_BYTES || v.Hashes == 0 || v.Hashes > BLOOM_MAX_HASHES {
+		return fmt.Errorf("%w: needs 1 to %d bytes and 1 to %d hashes", ErrInvalidBloomFilter, BLOOM_MAX_BYTES, BLOOM_MAX_HASHES)
+	}
+	f.bits, f.hashes, f.tweak = bits, v.Hashes, v.Tweak
+	return nil
+}
+
+// FilteredTransaction is a transaction that matched a filter, with its Merkle proof against the block header.
+type FilteredTransaction struct {
+	TransactionID string                   `json:"transaction_id"`
+	Transaction   *transaction.Transaction `json:"transaction"`
+	Proof         []block.MerkleProofStep  `json:"proof"`
+}
+
+// MerkleBlock is a block as a filtering light client receives it: the header, which it checks and chains
+// like any SPV client, and only the matching transactions, each provable against the header's Merkle root.
+type MerkleBlock struct {
+	Height           int                   `json:"height"`
+	Hash             string                `json:"hash"`
+	Header           *block.Block          `json:"header"`
+	TransactionCount int                   `json:"transaction_count"`
+	Matches          []FilteredTransaction `json:"matches"`
+}
+
+// NewMerkleBlock filters the block in info through f.
+func NewMerkleBlock(info BlockInfo, f *BloomFilter) MerkleBlock {
+	header := *info.Block
+	header.SetTransactions(nil)
+	mb := MerkleBlock{Height: info.Height, Hash: info.Hash, Header: &header, TransactionCount: len(info.Block.Transactions()), Matches: make([]FilteredTransaction, 0)}
+	for _, t := range info.Block.Transactions() {
+		if !f.MatchesTransaction(t) {
+			continue
+		}
+		proof, _ := info.Block.MerkleProof(t.Hash())
+		mb.Matches = append(mb.Matches, FilteredTransaction{TransactionID: t.ID(), Transaction: t, Proof: proof})
+	}
+	return mb
+}
+
+// applyFilterMessage applies a message a light client sent over /ws to its current filter f (nil if none)
+// and returns the new one:
+//   - {"type": "filterload", "data": {"bits", "hashes", "tweak"}} replaces the filter,
+//   - {"type": "filteradd", "data": "<address>"} adds an address to it,
+//   - {"type": "filterclear"} removes it, so every event is sent again.
+func applyFilterMessage(f *BloomFilter, message []byte) (*BloomFilter, error) {
+	var m struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(message, &m); err != nil {
+		return f, err
+	}
+	switch m.Type {
+	case FILTER_LOAD:
+		loaded := new(BloomFilter)
+		if err := json.Unmarshal(m.Data, loaded); err != nil {
+			return f, err
+		}
+		return loaded, nil
+	case FILTER_ADD:
+		if f == nil {
+			return f, errors.New("filteradd before filterload")
+		}
+		var address string
+		if err := json.Unmarshal(m.Data, &address); err != nil {
+			return f, err
+		}
+		f.Add([]byte(address))
+		return f, nil
+	case FILTER_CLEAR:
+		return nil, nil
+	default:
+		return f, fmt.Errorf("unknown message type %q", m.Type)
+	}
+}
+
+// filterEvent returns what a client with filter f receives for e: e itself without a filter; otherwise
+// transactions, new or expired, only when they match, and blocks as MerkleBlocks ("merkle_block" for new blocks, and
+// "chain_replaced" with the new tip as a MerkleBlock).
+func filterEvent(e Event, f *BloomFilter) (Event, bool) {
+	if f == nil {
+		return e, true
+	}
+	switch data := e.Data.(type) {
+	case TransactionInfo:
+		return e, f.MatchesTransaction(data.Transaction)
+	case ExpiredTransaction:
+		return e, f.MatchesTransaction(data.Transaction)
+	case BlockInfo:
+		if e.Type == EVENT_NEW_BLOCK {
+			return Event{Type: EVENT_MERKLE_BLOCK, Data: NewMerkleBlock(data, f)}, true
+		}
+		return Event{Type: e.Type, Data: NewMerkleBlock(data, f)}, true
+	default:
+		return e, true
+	}
+}
@@ -0,0 +1,145 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+func TestBloomFilterNeverMissesAndRarelyMatchesOthers(t *testing.T) {
+	const n = 500
+	f := NewBloomFilter(n, 0.01, 7)
+	for i := range n {
+		f.Add(fmt.Appendf(nil, "added-%d", i))
+	}
+	for i := range n {
+		if !f.Test(fmt.Appendf(nil, "added-%d", i)) {
+			t.Fatalf("the filter misses added-%d", i)
+		}
+	}
+	falsePositives := 0
+	for i := range 10000 {
+		if f.Test(fmt.Appendf(nil, "other-%d", i)) {
+			falsePositives++
+		}
+	}
+	// Sized for 1%; allow some slack so the test is not flaky.
+	if falsePositives > 300 {
+		t.Fatalf("%d of 10000 false positives, want about 100", falsePositives)
+	}
+
+	huge := NewBloomFilter(1<<30, 0.0001, 0)
+	if len(huge.bits) > BLOOM_MAX_BYTES || huge.hashes > BLOOM_MAX_HASHES {
+		t.Fatalf("a filter of %d bytes and %d hashes exceeds the limits", len(huge.bits), huge.hashes)
+	}
+}
+
+func TestBloomFilterPositionsDoNotCollapse(t *testing.T) {
+	// 3 bytes, the smallest filter sized for one address at 0.01%, has 24 bits and 17 hashes.
+	f := NewBloomFilter(1, 0.0001, 0)
+	for i := range 10000 {
+		distinct := make(map[uint32]bool)
+		for _, p := range f.positions(fmt.Appendf(nil, "address-%d", i)) {
+			distinct[p] = true
+		}
+		if len(distinct) < 8 {
+			t.Fatalf("address-%d takes %d distinct positions of %d hashes", i, len(distinct), f.hashes)
+		}
+	}
+}
+
+func TestBloomFilterJSONRoundTripsWithinTheLimits(t *testing.T) {
+	f := NewBloomFilter(10, 0.01, 3)
+	f.Add([]byte("addr"))
+	m, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded BloomFilter
+	if err := json.Unmarshal(m, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Test([]byte("addr")) || decoded.hashes != f.hashes || decoded.tweak != f.tweak {
+		t.Fatalf("decoded %s into %+v", m, decoded)
+	}
+
+	for _, invalid := range []string{
+		`{"bits": "zz", "hashes": 1}`,
+		`{"bits": "", "hashes": 1}`,
+		`{"bits": "ff", "hashes": 0}`,
+		fmt.Sprintf(`{"bits": "ff", "hashes": %d}`, BLOOM_MAX_HASHES+1),
+		fmt.Sprintf(`{"bits": "%s", "hashes": 1}`, strings.Repeat("ff", BLOOM_MAX_BYTES+1)),
+	} {
+		if err := json.Unmarshal([]byte(invalid), new(BloomFilter)); !errors.Is(err, ErrInvalidBloomFilter) {
+			t.Errorf("Unmarshal(%.40s) = %v, want %v", invalid, err, ErrInvalidBloomFilter)
+		}
+	}
+}
+
+func TestFilterMessagesLoadAddAndClear(t *testing.T) {
+	if _, err := applyFilterMessage(nil, []byte(`{"type": "filteradd", "data": "a"}`)); err == nil {
+		t.Fatal("filteradd before filterload succeeded")
+	}
+	if _, err := applyFilterMessage(nil, []byte(`{"type": "filterreset"}`)); err == nil {
+		t.Fatal("an unknown message type succeeded")
+	}
+	load, _ := json.Marshal(map[string]any{"type": FILTER_LOAD, "data": NewBloomFilter(10, 0.01, 0)})
+	f, err := applyFilterMessage(nil, load)
+	if err != nil || f == nil {
+		t.Fatalf("filterload = %v, %v", f, err)
+	}
+	if f, err = applyFilterMessage(f, []byte(`{"type": "filteradd", "data": "watched"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Test([]byte("watched")) {
+		t.Fatal("filteradd did not add the address")
+	}
+	if f, err = applyFilterMessage(f, []byte(`{"type": "filterclear"}`)); err != nil || f != nil {
+		t.Fatalf("filterclear = %v, %v, want no filter", f, err)
+	}
+}
+
+func TestFilteredEventsCarryOnlyMatchesWithTheirProofs(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	alice := fund(t, bc, miner, transaction.COIN)
+	watched := send(t, bc, alice, miner, transaction.COIN/4, 0)
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	b := bc.LastBlock()
+	info := BlockInfo{Height: len(bc.Chain()) - 1, Hash: fmt.Sprintf("%x", bc.BlockHash(b)), Block: b}
+
+	f := NewBloomFilter(1, 0.0001, 0)
+	f.Add([]byte(alice.BlockchainAddress()))
+	e, ok := filterEvent(Event{Type: EVENT_NEW_BLOCK, Data: info}, f)
+	if !ok || e.Type != EVENT_MERKLE_BLOCK {
+		t.Fatalf("filterEvent of a new block = %s, %v, want %s", e.Type, ok, EVENT_MERKLE_BLOCK)
+	}
+	mb := e.Data.(MerkleBlock)
+	if mb.Header.Transactions() != nil || mb.TransactionCount != len(b.Transactions()) {
+		t.Fatalf("merkle block header carries %d transactions of %d", len(mb.Header.Transactions()), mb.TransactionCount)
+	}
+	if len(mb.Matches) != 1 || mb.Matches[0].TransactionID != watched.ID() {
+		t.Fatalf("matches = %+v, want only alice's transfer", mb.Matches)
+	}
+	if !block.VerifyMerkleProof(watched.Hash(), mb.Matches[0].Proof, b.MerkleRoot()) {
+		t.Fatal("the match's proof does not verify against the header")
+	}
+
+	unrelated := transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), 1, 0)
+	if _, ok := filterEvent(Event{Type: EVENT_NEW_TRANSACTION, Data: TransactionInfo{Transaction: unrelated}}, f); ok {
+		t.Fatal("an unmatched transaction was sent")
+	}
+	if _, ok := filterEvent(Event{Type: EVENT_NEW_TRANSACTION, Data: TransactionInfo{Transaction: watched}}, f); !ok {
+		t.Fatal("a matching transaction was not sent")
+	}
+	if got, ok := filterEvent(Event{Type: EVENT_NEW_BLOCK, Data: info}, nil); !ok || got.Type != EVENT_NEW_BLOCK {
+		t.Fatal("without a filter the event changed")
+	}
+}
@@ -0,0 +1,90 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	BLOCKS_BOLT_FILE_NAME = "blocks.db"
+
+	// BOLT_OPEN_TIMEOUT is how long opening a Bolt file waits for another process to let go of it.
+	BOLT_OPEN_TIMEOUT = time.Second
+)
+
+// boltBucket is the bucket of a BoltStore that holds every key.
+var boltBucket = []byte("blocks")
+
+// BoltStore is a KeyValueStore in a Bolt (bbolt) file: a B+tree on disk that Bolt updates in
+// copy-on-write transactions, each synced before it commits, so a batch is applied whole or not at all
+// and a crash leaves the last committed state. Only one process may have the file open.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens the Bolt file at path, creating it and its directory if needed.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: BOLT_OPEN_TIMEOUT})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%s is open in another process: %w", path, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Get returns the value of key, or ok false if it has none.
+func (s *BoltStore) Get(key string) (value []byte, ok bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		// Bolt's slices are only valid during the transaction.
+		if v := tx.Bucket(boltBucket).Get([]byte(key)); v != nil {
+			value, ok = append([]byte(nil), v...), true
+		}
+		return nil
+	})
+	return value, ok, err
+}
+
+// Apply sets every key of puts and removes every key of deletes in one Bolt transaction.
+func (s *BoltStore) Apply(puts map[string][]byte, deletes []string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		for _, key := range deletes {
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		for key, value := range puts {
+			if err := b.Put([]byte(key), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Path returns the location of the Bolt file.
+func (s *BoltStore) Path() string {
+	return s.db.Path()
+}
+
+// Close closes the Bolt file, letting another process open it.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,73 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+var ErrCheckpointMismatch = errors.New("block contradicts a checkpoint")
+
+// Checkpoint pins the hash of the block at Height. A chain with another block there is invalid however
+// long it is, so nobody can rewrite the history before the latest checkpoint, not even by re-mining it
+// at a low difficulty.
+type Checkpoint struct {
+	Height int
+	Hash   [32]byte
+}
+
+// ParseCheckpoint parses "height:hash", with the hash in hex.
+func ParseCheckpoint(s string) (Checkpoint, error) {
+	heightStr, hashStr, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return Checkpoint{}, fmt.Errorf("checkpoint %q is not height:hash", s)
+	}
+	height, err := strconv.Atoi(heightStr)
+	if err != nil || height < 0 {
+		return Checkpoint{}, fmt.Errorf("checkpoint %q has an invalid height", s)
+	}
+	hash, err := transaction.HashFromString(hashStr)
+	if err != nil {
+		return Checkpoint{}, fmt.Errorf("checkpoint %q has an invalid hash: %v", s, err)
+	}
+	return Checkpoint{Height: height, Hash: hash}, nil
+}
+
+// ParseCheckpoints parses a comma-separated list of "height:hash" checkpoints; an empty list yields none.
+func ParseCheckpoints(list string) ([]Checkpoint, error) {
+	checkpoints := make([]Checkpoint, 0)
+	for _, s := range strings.Split(list, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		c, err := ParseCheckpoint(s)
+		if err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, c)
+	}
+	return checkpoints, nil
+}
+
+// SetCheckpoints pins block hashes at the given heights. Every block or header checked afterwards, whether
+// from a neighbor's chain in ResolveConflicts, a compact block, or fast sync, must match them. It must be called before Run.
+func (bc *Blockchain) SetCheckpoints(checkpoints []Checkpoint) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.checkpoints = make(map[int][32]byte, len(checkpoints))
+	for _, c := range checkpoints {
+		bc.checkpoints[c.Height] = c.Hash
+	}
+}
+
+// checkCheckpoint returns ErrCheckpointMismatch if a checkpoint pins another block at height.
+func (bc *Blockchain) checkCheckpoint(height int, b *block.Block) error {
+	if hash, ok := bc.checkpoints[height]; ok && bc.BlockHash(b) != hash {
+		return fmt.Errorf("%w at height %d", ErrCheckpointMismatch, height)
+	}
+	return nil
+}
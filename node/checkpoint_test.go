@@ -0,0 +1,55 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+func TestParseCheckpointsChecksHeightAndHash(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	checkpoints, err := ParseCheckpoints(fmt.Sprintf(" 10:%s, ,20:%s", hash, hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoints) != 2 || checkpoints[0].Height != 10 || checkpoints[1].Height != 20 || fmt.Sprintf("%x", checkpoints[0].Hash) != hash {
+		t.Fatalf("ParseCheckpoints = %+v", checkpoints)
+	}
+	if checkpoints, err := ParseCheckpoints(""); err != nil || len(checkpoints) != 0 {
+		t.Fatalf(`ParseCheckpoints("") = %v, %v, want none`, checkpoints, err)
+	}
+	for _, invalid := range []string{"10", "-1:" + hash, "x:" + hash, "10:abc"} {
+		if _, err := ParseCheckpoint(invalid); err == nil {
+			t.Errorf("ParseCheckpoint(%q) succeeded", invalid)
+		}
+	}
+}
+
+func TestResolveConflictsRefusesChainsThatContradictACheckpoint(t *testing.T) {
+	longer, other := newTestBlockchain(t)
+	fund(t, longer, other, transaction.COIN/2)
+	fund(t, longer, other, transaction.COIN/2)
+	pinned := longer.BlockHash(longer.Chain()[1])
+
+	bc, _ := newTestBlockchain(t)
+	setNeighbors(bc, serveNeighbor(t, longer))
+	bc.SetCheckpoints([]Checkpoint{{Height: 1, Hash: [32]byte{1}}})
+	if bc.ResolveConflicts(context.Background()) {
+		t.Fatal("ResolveConflicts adopted a chain that contradicts a checkpoint")
+	}
+	if len(bc.Chain()) != 1 {
+		t.Fatalf("chain length %d after refusing, want 1", len(bc.Chain()))
+	}
+
+	bc.SetCheckpoints([]Checkpoint{{Height: 1, Hash: pinned}})
+	if !bc.ResolveConflicts(context.Background()) {
+		t.Fatal("ResolveConflicts refused a chain that matches the checkpoint")
+	}
+	if err := bc.checkCheckpoint(1, longer.Chain()[2]); !errors.Is(err, ErrCheckpointMismatch) {
+		t.Fatalf("checkCheckpoint of another block at the pinned height = %v, want %v", err, ErrCheckpointMismatch)
+	}
+}
@@ -0,0 +1,204 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+const (
+	COMPACT_BLOCK_ACCEPTED  = "accepted"
+	COMPACT_BLOCK_KNOWN     = "known"
+	COMPACT_BLOCK_MISSING   = "missing"
+	COMPACT_BLOCK_RESOLVING = "resolving"
+	COMPACT_BLOCK_ORPHAN    = "orphan"
+)
+
+var ErrCompactBlockIncomplete = errors.New("compact block still lacks transactions")
+
+// CompactBlock announces a new block in the spirit of BIP 152: the header and the IDs of its transactions, in
+// order, instead of the transactions themselves. A neighbor that has gossip-relayed the transactions already
+// holds them in its pool, so a block costs it 64 hex characters per transaction instead of the whole transaction.
+type CompactBlock struct {
+	// Header is the block with its transactions left out.
+	Header         *block.Block `json:"header"`
+	TransactionIDs []string     `json:"transaction_ids"`
+	// Prefilled carries the transactions the receiver cannot have pooled: the coinbase, and any it reported missing.
+	Prefilled []*transaction.Transaction `json:"prefilled"`
+}
+
+// CompactBlockResponse answers POST /blocks/compact. Status is COMPACT_BLOCK_MISSING when the receiver lacks
+// the transactions at the Missing indexes, which the announcer then sends in Prefilled.
+type CompactBlockResponse struct {
+	Status  string `json:"status"`
+	Missing []int  `json:"missing,omitempty"`
+}
+
+// NewCompactBlock builds the announcement of b, prefilled with its coinbase transactions.
+func NewCompactBlock(b *block.Block) *CompactBlock {
+	header := *b
+	header.SetTransactions(nil)
+	cb := &CompactBlock{Header: &header, TransactionIDs: make([]string, len(b.Transactions())), Prefilled: make([]*transaction.Transaction, 0)}
+	for i, t := range b.Transactions() {
+		cb.TransactionIDs[i] = t.ID()
+		if t.SenderBlockchainAddress() == transaction.MINING_SENDER {
+			cb.Prefilled = append(cb.Prefilled, t)
+		}
+	}
+	return cb
+}
+
+// reconstruct rebuilds the full block from the prefilled transactions and pool. It returns the indexes of
+// the transactions found in neither, in which case the block is nil.
+func (cb *CompactBlock) reconstruct(pool []*transaction.Transaction) (*block.Block, []int) {
+	known := make(map[string]*transaction.Transaction, len(pool)+len(cb.Prefilled))
+	for _, t := range pool {
+		known[t.ID()] = t
+	}
+	for _, t := range cb.Prefilled {
+		known[t.ID()] = t
+	}
+	transactions := make([]*transaction.Transaction, len(cb.TransactionIDs))
+	var missing []int
+	for i, id := range cb.TransactionIDs {
+		t, ok := known[id]
+		if !ok {
+			missing = append(missing, i)
+			continue
+		}
+		transactions[i] = t
+	}
+	if len(missing) > 0 {
+		return nil, missing
+	}
+	b := *cb.Header
+	b.SetTransactions(transactions)
+	return &b, nil
+}
+
+// ReceiveCompactBlock handles a block announced by a neighbor. A block that extends the tip is rebuilt from
+// the pool, validated, appended, and announced to this node's neighbors in turn, followed by any orphans
+// waiting for it; if transactions are missing, their indexes are returned for the announcer to send. A block
+// whose parent is unknown arrived before it and goes into the orphan pool while the ancestors are requested.
+// A block on another known block means a fork, so it falls back to ResolveConflicts.
+func (bc *Blockchain) ReceiveCompactBlock(ctx context.Context, cb *CompactBlock) (CompactBlockResponse, error) {
+	if cb.Header == nil {
+		return CompactBlockResponse{}, errors.New("compact block has no header")
+	}
+	hash := bc.BlockHash(cb.Header)
+	if _, _, ok := bc.BlockByHash(hash); ok {
+		return CompactBlockResponse{Status: COMPACT_BLOCK_KNOWN}, nil
+	}
+	if _, ok := bc.orphans.get(hash); ok {
+		return CompactBlockResponse{Status: COMPACT_BLOCK_KNOWN}, nil
+	}
+	_, _, parentKnown := bc.BlockByHash(cb.Header.PreviousHash())
+	if parentKnown && cb.Header.PreviousHash() != bc.BlockHash(bc.LastBlock()) {
+		// Only a fork block that could follow its parent is worth fetching the neighbors' chains for.
+		if err := bc.checkForkHeader(cb.Header); err != nil {
+			return CompactBlockResponse{}, fmt.Errorf("invalid block %x: %w", hash, err)
+		}
+		bc.ResolveConflicts(ctx)
+		return CompactBlockResponse{Status: COMPACT_BLOCK_RESOLVING}, nil
+	}
+	b, missing := cb.reconstruct(bc.TransactionPool())
+	if b == nil {
+		log.Printf("action=receive_compact_block, status=missing, hash=%x, missing=%d/%d", hash, len(missing), len(cb.TransactionIDs))
+		return CompactBlockResponse{Status: COMPACT_BLOCK_MISSING, Missing: missing}, nil
+	}
+	if !parentKnown {
+		if err := bc.receiveOrphan(hash, b); err != nil {
+			return CompactBlockResponse{}, fmt.Errorf("invalid block %x: %w", hash, err)
+		}
+		return CompactBlockResponse{Status: COMPACT_BLOCK_ORPHAN}, nil
+	}
+
+	bc.mux.Lock()
+	preBlock := bc.lastBlock()
+	if bc.BlockHash(preBlock) != b.PreviousHash() {
+		// Another block arrived first; the announcer will hear "known" or resolve on its next announcement.
+		bc.mux.Unlock()
+		return CompactBlockResponse{Status: COMPACT_BLOCK_RESOLVING}, nil
+	}
+	if err := bc.checkBlock(bc.chain, b, bc.tipState(), bc.rules()); err != nil {
+		bc.mux.Unlock()
+		return CompactBlockResponse{}, fmt.Errorf("invalid block %x: %w", hash, err)
+	}
+	bc.appendBlock(b)
+	bc.removeFromPool(b.Transactions())
+	if bc.miningCancel != nil {
+		// The block being mined no longer extends the tip; Mining restarts on the new one.
+		bc.miningCancel()
+	}
+	height := len(bc.chain) - 1
+	bc.mux.Unlock()
+
+	log.Printf("action=receive_compact_block, status=accepted, height=%d, hash=%x, transactions=%d, prefilled=%d", height, hash, len(b.Transactions()), len(cb.Prefilled))
+	go bc.announceBlock(context.Background(), b)
+	bc.connectOrphans(hash)
+	return CompactBlockResponse{Status: COMPACT_BLOCK_ACCEPTED}, nil
+}
+
+// announceBlock sends b as a compact block to every neighbor. Neighbors that lack the /blocks/compact
+// endpoint get the older PUT /consensus, which makes them fetch the whole chain.
+func (bc *Blockchain) announceBlock(ctx context.Context, b *block.Block) {
+	for _, n := range bc.Neighbors() {
+		if err := bc.sendCompactBlock(ctx, n, b); err != nil {
+			log.Printf("action=announce_block, neighbor=%s, err=%v", n, err)
+		}
+	}
+}
+
+// sendCompactBlock announces b to neighbor n, answering one round of missing transactions.
+func (bc *Blockchain) sendCompactBlock(ctx context.Context, n string, b *block.Block) error {
+	cb := NewCompactBlock(b)
+	for range 2 {
+		m, contentType, err := bc.marshalPeer(cb)
+		if err != nil {
+			return err
+		}
+		req, client := bc.neighborRequest(ctx, http.MethodPost, n, "/blocks/compact", bytes.NewReader(m))
+		req.Header.Set("Content-Type", contentType)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		var cr CompactBlockResponse
+		err = decodePeer(resp.Body, resp.Header.Get("Content-Type"), WIRE_MAX_RESPONSE_SIZE, &cr)
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return bc.notifyConsensus(ctx, n)
+		case resp.StatusCode != http.StatusOK:
+			return fmt.Errorf("compact block answered %s", resp.Status)
+		case err != nil:
+			return err
+		case cr.Status != COMPACT_BLOCK_MISSING:
+			return nil
+		}
+		for _, i := range cr.Missing {
+			if i < 0 || i >= len(b.Transactions()) {
+				return fmt.Errorf("neighbor asked for transaction %d of %d", i, len(b.Transactions()))
+			}
+			cb.Prefilled = append(cb.Prefilled, b.Transactions()[i])
+		}
+	}
+	return ErrCompactBlockIncomplete
+}
+
+// notifyConsensus sends PUT /consensus to neighbor n, which answers by fetching the chain of every neighbor.
+func (bc *Blockchain) notifyConsensus(ctx context.Context, n string) error {
+	req, client := bc.neighborRequest(ctx, http.MethodPut, n, "/consensus", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
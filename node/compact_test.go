@@ -0,0 +1,66 @@
+package node
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+func TestCompactBlocksAreRebuiltFromThePool(t *testing.T) {
+	a, miner := newTestBlockchain(t)
+	alice := fund(t, a, miner, transaction.COIN)
+	b, _ := newTestBlockchain(t)
+	setNeighbors(b, serveNeighbor(t, a))
+	if !b.ResolveConflicts(context.Background()) {
+		t.Fatal("ResolveConflicts did not adopt the funded chain")
+	}
+	setNeighbors(b)
+
+	// b has pooled the first transfer but never heard of the second.
+	gossiped := send(t, a, alice, miner, transaction.COIN/4, 0)
+	if err := b.AddTransaction(gossiped); err != nil {
+		t.Fatal(err)
+	}
+	unheard := send(t, a, alice, miner, transaction.COIN/4, 0)
+	if !a.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	mined := a.LastBlock()
+
+	cb := NewCompactBlock(mined)
+	if len(cb.TransactionIDs) != len(mined.Transactions()) {
+		t.Fatalf("%d transaction IDs for %d transactions", len(cb.TransactionIDs), len(mined.Transactions()))
+	}
+	for _, p := range cb.Prefilled {
+		if p.SenderBlockchainAddress() != transaction.MINING_SENDER {
+			t.Fatalf("prefilled %s, which is not a coinbase", p.ID())
+		}
+	}
+	resp, err := b.ReceiveCompactBlock(context.Background(), cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != COMPACT_BLOCK_MISSING || len(resp.Missing) != 1 || cb.TransactionIDs[resp.Missing[0]] != unheard.ID() {
+		t.Fatalf("ReceiveCompactBlock = %+v, want just the unheard transaction missing", resp)
+	}
+
+	// Announced over HTTP, the announcer answers the missing indexes and b appends the block.
+	bcs := &BlockchainServer{blockchain: b}
+	s := httptest.NewServer(http.HandlerFunc(bcs.CompactBlock))
+	defer s.Close()
+	if err := a.sendCompactBlock(context.Background(), s.Listener.Addr().String(), mined); err != nil {
+		t.Fatalf("sendCompactBlock: %v", err)
+	}
+	if got, want := b.BlockHash(b.LastBlock()), a.BlockHash(mined); got != want {
+		t.Fatalf("b's tip = %x, want the announced %x", got, want)
+	}
+	if pool := b.TransactionPool(); len(pool) != 0 {
+		t.Fatalf("b's pool kept %d mined transactions", len(pool))
+	}
+	if resp, err := b.ReceiveCompactBlock(context.Background(), NewCompactBlock(mined)); err != nil || resp.Status != COMPACT_BLOCK_KNOWN {
+		t.Fatalf("ReceiveCompactBlock again = %+v, %v, want %s", resp, err, COMPACT_BLOCK_KNOWN)
+	}
+}
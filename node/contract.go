@@ -0,0 +1,215 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+var ErrUnknownContract = errors.New("no contract at the address")
+
+// Contract is a deployed contract: its code and its storage, which only its own calls change.
+type Contract struct {
+	code    []byte
+	storage map[int64]int64
+}
+
+// Code returns the contract's code.
+func (c *Contract) Code() []byte {
+	return c.code
+}
+
+// Storage returns a copy of the contract's storage; keys never written read as zero.
+func (c *Contract) Storage() map[int64]int64 {
+	return maps.Clone(c.storage)
+}
+
+// MarshalJSON encodes the contract as {"code": hex, "storage": {"key": value}}.
+func (c *Contract) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Code    string          `json:"code"`
+		Storage map[int64]int64 `json:"storage"`
+	}{hex.EncodeToString(c.code), c.storage})
+}
+
+// UnmarshalJSON decodes a contract written by MarshalJSON.
+func (c *Contract) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Code    string          `json:"code"`
+		Storage map[int64]int64 `json:"storage"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	code, err := hex.DecodeString(v.Code)
+	if err != nil {
+		return fmt.Errorf("invalid code: %w", err)
+	}
+	c.code, c.storage = code, v.Storage
+	if c.storage == nil {
+		c.storage = make(map[int64]int64)
+	}
+	return nil
+}
+
+// cloneContracts copies contracts deeply, so applying blocks to the copy leaves them as they are.
+func cloneContracts(contracts map[string]*Contract) map[string]*Contract {
+	clone := make(map[string]*Contract, len(contracts))
+	for a, c := range contracts {
+		clone[a] = &Contract{code: c.code, storage: maps.Clone(c.storage)}
+	}
+	return clone
+}
+
+// contractsRoot returns the hash that commits a block to the code and storage of every contract after
+// it, or nil while there are none, so blocks from before contracts keep their hashes.
+func contractsRoot(contracts map[string]*Contract) *[32]byte {
+	if len(contracts) == 0 {
+		return nil
+	}
+	// json.Marshal sorts map keys, so the encoding is canonical.
+	m, _ := json.Marshal(contracts)
+	root := sha256.Sum256(m)
+	return &root
+}
+
+// checkContractCode returns ErrInvalidContract if t deploys a WebAssembly module that does not decode.
+// Transaction.CheckAmounts only bounds the code's size, as the decoder is part of the runtime.
+func checkContractCode(t *transaction.Transaction) error {
+	if t.ContractType() == transaction.CONTRACT_DEPLOY && transaction.IsWasm(t.Code()) {
+		if _, err := ParseWasm(t.Code()); err != nil {
+			return fmt.Errorf("%w: %v", transaction.ErrInvalidContract, err)
+		}
+	}
+	return nil
+}
+
+// runContract runs code for call with at most gas, in the WebAssembly runtime if it is a module and in
+// the VM otherwise.
+func runContract(code []byte, call *vmCall, gas uint64) (int64, uint64, error) {
+	if transaction.IsWasm(code) {
+		return runWasm(code, call, gas)
+	}
+	return runVM(code, call, gas)
+}
+
+// CallerWord returns the word CALLER pushes for address: the first 8 bytes of its SHA-256, so contract code
+// can compare callers.
+func CallerWord(address string) int64 {
+	h := sha256.Sum256([]byte(address))
+	return int64(binary.BigEndian.Uint64(h[:8]))
+}
+
+// Receipt is the outcome of a confirmed contract transaction. A failed call keeps its fee but is otherwise
+// undone: its value goes back to the sender and the contract's storage stays as it was.
+type Receipt struct {
+	TransactionID string             `json:"transaction_id"`
+	Contract      string             `json:"contract"`
+	Success       bool               `json:"success"`
+	GasUsed       uint64             `json:"gas_used"`
+	Result        int64              `json:"result"`
+	Paid          transaction.Amount `json:"paid"`
+	Error         string             `json:"error,omitempty"`
+}
+
+// applyContract applies contract transaction t, whose sender has already paid value and fee, to balances
+// and contracts: a deployment creates the contract holding value, and a call runs its code with the gas
+// left after the intrinsic gas.
+func applyContract(balances map[string]transaction.Amount, contracts map[string]*Contract, t *transaction.Transaction) Receipt {
+	r := Receipt{TransactionID: t.ID(), GasUsed: t.IntrinsicGas()}
+	if t.ContractType() == transaction.CONTRACT_DEPLOY {
+		r.Contract = t.ContractAddress()
+		contracts[r.Contract] = &Contract{code: t.Code(), storage: make(map[int64]int64)}
+		balances[r.Contract] += t.Value()
+		r.Success = true
+		return r
+	}
+	r.Contract = t.RecipientBlockchainAddress()
+	c, ok := contracts[r.Contract]
+	if !ok {
+		balances[t.SenderBlockchainAddress()] += t.Value()
+		r.Error = ErrUnknownContract.Error()
+		return r
+	}
+	balances[r.Contract] += t.Value()
+	call := &vmCall{
+		input:   t.Input(),
+		caller:  CallerWord(t.SenderBlockchainAddress()),
+		value:   t.Value(),
+		balance: balances[r.Contract],
+		storage: maps.Clone(c.storage),
+	}
+	result, used, err := runContract(c.code, call, t.GasLimit()-r.GasUsed)
+	r.GasUsed += used
+	if err != nil {
+		balances[r.Contract] -= t.Value()
+		balances[t.SenderBlockchainAddress()] += t.Value()
+		r.Error = err.Error()
+		return r
+	}
+	c.storage = call.storage
+	balances[r.Contract] -= call.paid
+	balances[t.SenderBlockchainAddress()] += call.paid
+	r.Success, r.Result, r.Paid = true, result, call.paid
+	return r
+}
+
+// applyContractDelta applies contract transaction t, whose sender has already paid, to contracts and to delta,
+// the block's balance changes so far over balances, as applyContract applies it to the balances after them.
+func applyContractDelta(balances, delta map[string]transaction.Amount, contracts map[string]*Contract, t *transaction.Transaction) {
+	contract := t.RecipientBlockchainAddress()
+	if t.ContractType() == transaction.CONTRACT_DEPLOY {
+		contract = t.ContractAddress()
+	}
+	after := make(map[string]transaction.Amount, 2)
+	for _, a := range []string{t.SenderBlockchainAddress(), contract} {
+		after[a] = balances[a] + delta[a]
+	}
+	applyContract(after, contracts, t)
+	for a, b := range after {
+		delta[a] = b - balances[a]
+	}
+}
+
+// blockStateRoot returns the contracts root after transactions are applied to balances and contracts,
+// which it leaves as they are.
+func blockStateRoot(balances map[string]transaction.Amount, contracts map[string]*Contract, transactions []*transaction.Transaction) *[32]byte {
+	for _, t := range transactions {
+		if t.ContractType() != "" {
+			contracts = cloneContracts(contracts)
+			applyTransactions(maps.Clone(balances), contracts, nil, nil, transactions)
+			break
+		}
+	}
+	return contractsRoot(contracts)
+}
+
+// sameStateRoot reports whether state roots a and b are equal, both nil or both the same hash.
+func sameStateRoot(a, b *[32]byte) bool {
+	return a == b || a != nil && b != nil && *a == *b
+}
+
+// Contract returns a copy of the contract deployed at address and its balance, or false if there is none.
+func (bc *Blockchain) Contract(address string) (*Contract, transaction.Amount, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	c, ok := bc.contracts[address]
+	if !ok {
+		return nil, 0, false
+	}
+	return &Contract{code: c.code, storage: maps.Clone(c.storage)}, bc.balances[address], true
+}
+
+// Receipt returns the receipt of the confirmed contract transaction with this hash, or false if there is none.
+func (bc *Blockchain) Receipt(hash [32]byte) (Receipt, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	r, ok := bc.receipts[hash]
+	return r, ok
+}
@@ -0,0 +1,75 @@
+package node
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	CORS_ALLOWED_METHODS = "GET, POST, PUT, DELETE, OPTIONS"
+	CORS_ALLOWED_HEADERS = "Content-Type, Authorization, " + API_KEY_HEADER
+	CORS_MAX_AGE_SEC     = 600
+)
+
+// CORS adds Cross-Origin Resource Sharing headers so that pages served from the allowed origins can call the
+// API from the browser. A nil *CORS adds none, and browsers keep blocking cross-origin calls.
+type CORS struct {
+	origins   []string
+	anyOrigin bool
+}
+
+// NewCORS allows the given origins, such as "http://localhost:3000", or every origin with "*". It returns nil
+// when origins is empty.
+func NewCORS(origins []string) *CORS {
+	c := &CORS{}
+	for _, o := range origins {
+		o = strings.TrimSuffix(strings.TrimSpace(o), "/")
+		switch o {
+		case "":
+		case "*":
+			c.anyOrigin = true
+		default:
+			c.origins = append(c.origins, o)
+		}
+	}
+	if !c.anyOrigin && len(c.origins) == 0 {
+		return nil
+	}
+	return c
+}
+
+// Handler wraps next: responses to allowed origins carry Access-Control-Allow-Origin, and their preflight
+// OPTIONS requests are answered directly, before method checks, authentication, and rate limiting.
+func (c *CORS) Handler(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := req.Header.Get("Origin")
+		if origin == "" || !c.allowed(origin) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if c.anyOrigin {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "Retry-After, WWW-Authenticate")
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", CORS_ALLOWED_METHODS)
+			w.Header().Set("Access-Control-Allow-Headers", CORS_ALLOWED_HEADERS)
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(CORS_MAX_AGE_SEC))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (c *CORS) allowed(origin string) bool {
+	return c.anyOrigin || slices.Contains(c.origins, origin)
+}
@@ -0,0 +1,60 @@
+package node
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// corsRequest sends method with origin (if any) through c's handler and returns the response.
+func corsRequest(c *CORS, method, origin string, preflight bool) *httptest.ResponseRecorder {
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	req := httptest.NewRequest(method, "/transactions", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if preflight {
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	}
+	rec := httptest.NewRecorder()
+	c.Handler(next).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCORSAnswersAllowedOriginsOnly(t *testing.T) {
+	if NewCORS([]string{"", " "}) != nil {
+		t.Fatal("CORS without origins is not nil")
+	}
+	if rec := corsRequest(nil, http.MethodGet, "http://evil.example", false); rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("nil CORS allowed an origin")
+	}
+
+	c := NewCORS([]string{"http://localhost:3000/"})
+	rec := corsRequest(c, http.MethodGet, "http://localhost:3000", false)
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "http://localhost:3000" || rec.Header().Get("Vary") != "Origin" {
+		t.Fatalf("GET from the allowed origin: %d %v", rec.Code, rec.Header())
+	}
+	rec = corsRequest(c, http.MethodOptions, "http://localhost:3000", true)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Methods") != CORS_ALLOWED_METHODS ||
+		rec.Header().Get("Access-Control-Allow-Headers") != CORS_ALLOWED_HEADERS || rec.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Fatalf("preflight from the allowed origin: %d %v", rec.Code, rec.Header())
+	}
+	rec = corsRequest(c, http.MethodOptions, "http://evil.example", true)
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("preflight from another origin: %d %v", rec.Code, rec.Header())
+	}
+	if rec := corsRequest(c, http.MethodGet, "", false); rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("a same-origin request got CORS headers")
+	}
+
+	rec = corsRequest(NewCORS([]string{"*"}), http.MethodPost, "http://anywhere.example", false)
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("POST with every origin allowed: %d %v", rec.Code, rec.Header())
+	}
+}
@@ -0,0 +1,387 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/bits"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	DHT_ID_BITS = 160
+	// DHT_K is both the bucket size and how many closest contacts a lookup returns.
+	DHT_K = 8
+	// DHT_ALPHA is how many contacts a lookup queries in parallel.
+	DHT_ALPHA = 3
+	// DHT_REFRESH_INTERVAL is how long a bucket may go without a lookup before it is refreshed. Kademlia
+	// uses an hour; demo networks change faster.
+	DHT_REFRESH_INTERVAL = 60 * time.Second
+)
+
+var ErrInvalidContact = errors.New("contact ID does not match its address")
+
+// NodeID places a node in the DHT's 160-bit key space; the distance between two IDs is their XOR.
+type NodeID [DHT_ID_BITS / 8]byte
+
+// NewNodeID derives a node's ID from its advertised host:port, so other nodes can check it.
+func NewNodeID(address string) NodeID {
+	sum := sha256.Sum256([]byte(address))
+	var id NodeID
+	copy(id[:], sum[:])
+	return id
+}
+
+func (id NodeID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// MarshalJSON encodes the ID as a hex string.
+func (id NodeID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(id.String())
+}
+
+// UnmarshalJSON decodes a hex string written by MarshalJSON.
+func (id *NodeID) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(id) {
+		return fmt.Errorf("invalid node ID %q", s)
+	}
+	copy(id[:], b)
+	return nil
+}
+
+// bucketIndex returns which bucket of self's routing table holds other: i for an XOR distance in
+// [2^i, 2^(i+1)), or -1 when the IDs are equal.
+func bucketIndex(self, other NodeID) int {
+	for i := range self {
+		if x := self[i] ^ other[i]; x != 0 {
+			return (len(self)-i-1)*8 + bits.Len8(x) - 1
+		}
+	}
+	return -1
+}
+
+// closer reports whether a is closer to target than b.
+func closer(target, a, b NodeID) bool {
+	for i := range target {
+		da, db := a[i]^target[i], b[i]^target[i]
+		if da != db {
+			return da < db
+		}
+	}
+	return false
+}
+
+// byDistance orders contacts by their distance to target, closest first.
+func byDistance(target NodeID) func(a, b Contact) int {
+	return func(a, b Contact) int {
+		if closer(target, a.ID, b.ID) {
+			return -1
+		}
+		if closer(target, b.ID, a.ID) {
+			return 1
+		}
+		return 0
+	}
+}
+
+// randomIDInBucket returns a random ID that falls into bucket i of self's routing table.
+func randomIDInBucket(self NodeID, i int) NodeID {
+	var distance NodeID
+	rand.Read(distance[:])
+	byteIndex := len(self) - 1 - i/8
+	for j := 0; j < byteIndex; j++ {
+		distance[j] = 0
+	}
+	bit := byte(1) << (i % 8)
+	distance[byteIndex] = distance[byteIndex]&(bit-1) | bit
+	var id NodeID
+	for j := range id {
+		id[j] = self[j] ^ distance[j]
+	}
+	return id
+}
+
+// Contact is a DHT node: its ID and the host:port its API listens on.
+type Contact struct {
+	ID      NodeID `json:"id"`
+	Address string `json:"address"`
+}
+
+// Valid reports whether the contact's ID is the one its address derives.
+func (c Contact) Valid() bool {
+	return c.Address != "" && c.ID == NewNodeID(c.Address)
+}
+
+// routingTable keeps up to DHT_K contacts per bucket, least recently seen first.
+type routingTable struct {
+	self Contact
+
+	mux       sync.Mutex
+	buckets   [DHT_ID_BITS][]Contact
+	refreshed [DHT_ID_BITS]time.Time
+}
+
+// add records that c was seen: it moves to the tail of its bucket, or is appended if there is room.
+// When the bucket is full it returns the least recently seen contact, which the caller should ping.
+func (rt *routingTable) add(c Contact) (lru Contact, full bool) {
+	i := bucketIndex(rt.self.ID, c.ID)
+	if i < 0 {
+		return Contact{}, false
+	}
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+	b := rt.buckets[i]
+	if j := slices.IndexFunc(b, func(x Contact) bool { return x.ID == c.ID }); j >= 0 {
+		rt.buckets[i] = append(slices.Delete(b, j, j+1), c)
+		return Contact{}, false
+	}
+	if len(b) < DHT_K {
+		rt.buckets[i] = append(b, c)
+		return Contact{}, false
+	}
+	return b[0], true
+}
+
+// replace evicts old, if it is still in its bucket, in favour of c.
+func (rt *routingTable) replace(old, c Contact) {
+	i := bucketIndex(rt.self.ID, old.ID)
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+	if j := slices.IndexFunc(rt.buckets[i], func(x Contact) bool { return x.ID == old.ID }); j >= 0 {
+		rt.buckets[i] = append(slices.Delete(rt.buckets[i], j, j+1), c)
+	}
+}
+
+// remove drops the contact with id, for example after it failed to answer.
+func (rt *routingTable) remove(id NodeID) {
+	i := bucketIndex(rt.self.ID, id)
+	if i < 0 {
+		return
+	}
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+	rt.buckets[i] = slices.DeleteFunc(rt.buckets[i], func(x Contact) bool { return x.ID == id })
+}
+
+// closest returns up to n contacts ordered by distance to target.
+func (rt *routingTable) closest(target NodeID, n int) []Contact {
+	all := rt.contacts()
+	slices.SortFunc(all, byDistance(target))
+	return all[:min(n, len(all))]
+}
+
+// contacts returns every contact in the table.
+func (rt *routingTable) contacts() []Contact {
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+	all := make([]Contact, 0)
+	for _, b := range rt.buckets {
+		all = append(all, b...)
+	}
+	return all
+}
+
+// markRefreshed records a lookup of target, which refreshes the bucket target falls into.
+func (rt *routingTable) markRefreshed(target NodeID, now time.Time) {
+	if i := bucketIndex(rt.self.ID, target); i >= 0 {
+		rt.mux.Lock()
+		rt.refreshed[i] = now
+		rt.mux.Unlock()
+	}
+}
+
+// staleBuckets returns the buckets not looked up within DHT_REFRESH_INTERVAL, from the closest non-empty
+// bucket outward; the closer ones are empty and stay so in all but huge networks.
+func (rt *routingTable) staleBuckets(now time.Time) []int {
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+	stale := make([]int, 0)
+	first := slices.IndexFunc(rt.buckets[:], func(b []Contact) bool { return len(b) > 0 })
+	if first < 0 {
+		return stale
+	}
+	for i := first; i < DHT_ID_BITS; i++ {
+		if now.Sub(rt.refreshed[i]) >= DHT_REFRESH_INTERVAL {
+			stale = append(stale, i)
+		}
+	}
+	return stale
+}
+
+// DHTFindNodeRequest is the body of POST /dht/find_node.
+type DHTFindNodeRequest struct {
+	Sender Contact `json:"sender"`
+	Target NodeID  `json:"target"`
+}
+
+// DHTFindNodeResponse answers POST /dht/find_node with the responder and its DHT_K contacts closest to the target.
+type DHTFindNodeResponse struct {
+	Responder Contact   `json:"responder"`
+	Contacts  []Contact `json:"contacts"`
+}
+
+// DHT is a Kademlia-style routing layer over the node HTTP API: nodes find each other by iterative
+// FIND_NODE lookups, so a node learns of peers many hops away while remembering only O(log n) of them.
+type DHT struct {
+	table *routingTable
+	// request builds a request to a peer with the node's scheme, token, and trace; see Blockchain.neighborRequest.
+	request func(ctx context.Context, method, peer, path string, body io.Reader) (*http.Request, *http.Client)
+}
+
+// NewDHT creates the DHT of the node advertised at address.
+func NewDHT(address string, request func(ctx context.Context, method, peer, path string, body io.Reader) (*http.Request, *http.Client)) *DHT {
+	return &DHT{table: &routingTable{self: Contact{ID: NewNodeID(address), Address: address}}, request: request}
+}
+
+// Self returns this node's contact.
+func (d *DHT) Self() Contact {
+	return d.table.self
+}
+
+// Contacts returns every contact in the routing table.
+func (d *DHT) Contacts() []Contact {
+	return d.table.contacts()
+}
+
+// Buckets returns the non-empty buckets by index, for inspection.
+func (d *DHT) Buckets() map[int][]Contact {
+	d.table.mux.Lock()
+	defer d.table.mux.Unlock()
+	buckets := make(map[int][]Contact)
+	for i, b := range d.table.buckets {
+		if len(b) > 0 {
+			buckets[i] = append([]Contact(nil), b...)
+		}
+	}
+	return buckets
+}
+
+// HandleFindNode answers a FIND_NODE from sender and records sender as seen.
+func (d *DHT) HandleFindNode(fr DHTFindNodeRequest) (DHTFindNodeResponse, error) {
+	if !fr.Sender.Valid() {
+		return DHTFindNodeResponse{}, ErrInvalidContact
+	}
+	go d.addContact(fr.Sender)
+	contacts := slices.DeleteFunc(d.table.closest(fr.Target, DHT_K), func(c Contact) bool { return c.ID == fr.Sender.ID })
+	return DHTFindNodeResponse{Responder: d.Self(), Contacts: contacts}, nil
+}
+
+// addContact inserts c, following Kademlia's eviction rule when its bucket is full: the least recently seen
+// contact stays if it still answers, since long-lived nodes are the most likely to stay up.
+func (d *DHT) addContact(c Contact) {
+	lru, full := d.table.add(c)
+	if !full {
+		return
+	}
+	if _, err := d.findNode(context.Background(), lru.Address, d.Self().ID); err != nil {
+		d.table.replace(lru, c)
+	}
+}
+
+// findNode sends FIND_NODE for target to the node at address, which then knows this node too, and records
+// the responder as seen.
+func (d *DHT) findNode(ctx context.Context, address string, target NodeID) (*DHTFindNodeResponse, error) {
+	m, _ := json.Marshal(DHTFindNodeRequest{Sender: d.Self(), Target: target})
+	req, client := d.request(ctx, http.MethodPost, address, "/dht/find_node", bytes.NewReader(m))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("find_node answered %s", resp.Status)
+	}
+	var fr DHTFindNodeResponse
+	if err := decodePeer(resp.Body, resp.Header.Get("Content-Type"), WIRE_MAX_RESPONSE_SIZE, &fr); err != nil {
+		return nil, err
+	}
+	if !fr.Responder.Valid() {
+		return nil, ErrInvalidContact
+	}
+	d.addContact(fr.Responder)
+	return &fr, nil
+}
+
+// Bootstrap introduces this node to the nodes at addresses, such as its neighbors, then looks up its own ID,
+// which fills the buckets near it and announces it to the nodes it asks.
+func (d *DHT) Bootstrap(ctx context.Context, addresses []string) {
+	known := d.Contacts()
+	for _, a := range addresses {
+		if a == d.Self().Address || slices.ContainsFunc(known, func(c Contact) bool { return c.Address == a }) {
+			continue
+		}
+		if _, err := d.findNode(ctx, a, d.Self().ID); err != nil {
+			log.Printf("action=dht_bootstrap, peer=%s, err=%v", a, err)
+		}
+	}
+	d.Lookup(ctx, d.Self().ID)
+}
+
+// Lookup runs an iterative FIND_NODE: it repeatedly asks the DHT_ALPHA closest contacts not yet asked for
+// their contacts closest to target, until the DHT_K closest known have all answered. Contacts that fail
+// are dropped from the routing table.
+func (d *DHT) Lookup(ctx context.Context, target NodeID) []Contact {
+	d.table.markRefreshed(target, time.Now())
+	shortlist := d.table.closest(target, DHT_K)
+	asked := make(map[NodeID]bool)
+	for ctx.Err() == nil {
+		var batch []Contact
+		for _, c := range shortlist {
+			if !asked[c.ID] && len(batch) < DHT_ALPHA {
+				batch = append(batch, c)
+				asked[c.ID] = true
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+		var mux sync.Mutex
+		var wg sync.WaitGroup
+		for _, c := range batch {
+			wg.Go(func() {
+				fr, err := d.findNode(ctx, c.Address, target)
+				mux.Lock()
+				defer mux.Unlock()
+				if err != nil {
+					d.table.remove(c.ID)
+					shortlist = slices.DeleteFunc(shortlist, func(x Contact) bool { return x.ID == c.ID })
+					return
+				}
+				for _, found := range fr.Contacts {
+					if found.Valid() && found.ID != d.Self().ID && !slices.ContainsFunc(shortlist, func(x Contact) bool { return x.ID == found.ID }) {
+						shortlist = append(shortlist, found)
+					}
+				}
+			})
+		}
+		wg.Wait()
+		slices.SortFunc(shortlist, byDistance(target))
+		shortlist = shortlist[:min(DHT_K, len(shortlist))]
+	}
+	return shortlist
+}
+
+// Refresh looks up a random ID in every bucket that saw no lookup for DHT_REFRESH_INTERVAL, which finds
+// nodes that joined meanwhile and drops those that left.
+func (d *DHT) Refresh(ctx context.Context) {
+	for _, i := range d.table.staleBuckets(time.Now()) {
+		d.Lookup(ctx, randomIDInBucket(d.Self().ID, i))
+	}
+}
@@ -0,0 +1,193 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBucketIndexIsTheHighestDifferingBit(t *testing.T) {
+	var self NodeID
+	if i := bucketIndex(self, self); i != -1 {
+		t.Fatalf("bucketIndex(self, self) = %d, want -1", i)
+	}
+	tests := []struct {
+		byteIndex int
+		bit       byte
+		want      int
+	}{
+		{len(self) - 1, 0x01, 0},
+		{len(self) - 1, 0x80, 7},
+		{len(self) - 2, 0x01, 8},
+		{0, 0x80, DHT_ID_BITS - 1},
+	}
+	for _, tt := range tests {
+		other := self
+		other[tt.byteIndex] = tt.bit
+		if i := bucketIndex(self, other); i != tt.want {
+			t.Errorf("bucketIndex with byte %d = %#x is %d, want %d", tt.byteIndex, tt.bit, i, tt.want)
+		}
+	}
+	for _, i := range []int{0, 5, 63, DHT_ID_BITS - 1} {
+		if got := bucketIndex(self, randomIDInBucket(self, i)); got != i {
+			t.Errorf("randomIDInBucket(%d) fell into bucket %d", i, got)
+		}
+	}
+}
+
+func TestRoutingTableOrdersByXORDistance(t *testing.T) {
+	self := Contact{Address: "self"}
+	rt := &routingTable{self: self}
+	var near, mid, far NodeID
+	near[len(near)-1] = 1
+	mid[len(mid)-1] = 0x80
+	far[0] = 0x80
+	for _, id := range []NodeID{far, near, mid} {
+		rt.add(Contact{ID: id})
+	}
+	if !closer(self.ID, near, far) || closer(self.ID, far, near) || closer(self.ID, near, near) {
+		t.Fatal("closer does not order by XOR distance")
+	}
+	got := rt.closest(self.ID, 2)
+	if len(got) != 2 || got[0].ID != near || got[1].ID != mid {
+		t.Fatalf("closest(self, 2) = %v, want the near then the mid contact", got)
+	}
+	if got := rt.closest(far, 1); len(got) != 1 || got[0].ID != far {
+		t.Fatalf("closest(far, 1) = %v, want the far contact", got)
+	}
+
+	rt.remove(mid)
+	if n := len(rt.contacts()); n != 2 {
+		t.Fatalf("%d contacts after remove, want 2", n)
+	}
+	if _, full := rt.add(Contact{ID: self.ID}); full || len(rt.contacts()) != 2 {
+		t.Fatal("the table added its own ID")
+	}
+}
+
+func TestFullBucketKeepsItsLeastRecentlySeenContact(t *testing.T) {
+	rt := &routingTable{}
+	var contacts []Contact
+	for i := range DHT_K + 1 {
+		var id NodeID
+		id[0] = 0x80 | byte(i)
+		contacts = append(contacts, Contact{ID: id})
+	}
+	for _, c := range contacts[:DHT_K] {
+		if _, full := rt.add(c); full {
+			t.Fatalf("bucket full before %d contacts", DHT_K)
+		}
+	}
+	lru, full := rt.add(contacts[DHT_K])
+	if !full || lru != contacts[0] {
+		t.Fatalf("add to a full bucket = %v, %v, want the first contact, true", lru, full)
+	}
+
+	// Seeing the first contact again makes the second the least recently seen.
+	rt.add(contacts[0])
+	if lru, _ := rt.add(contacts[DHT_K]); lru != contacts[1] {
+		t.Fatalf("least recently seen = %v, want %v", lru, contacts[1])
+	}
+	rt.replace(contacts[1], contacts[DHT_K])
+	all := rt.contacts()
+	if len(all) != DHT_K || slices.Contains(all, contacts[1]) || !slices.Contains(all, contacts[DHT_K]) {
+		t.Fatalf("after replace the bucket holds %v", all)
+	}
+}
+
+// dhtNode is a DHT served over httptest, answering POST /dht/find_node like the node API.
+type dhtNode struct {
+	*DHT
+	srv *httptest.Server
+}
+
+// newDHTNode starts a DHT whose address is its test server's.
+func newDHTNode(t *testing.T) *dhtNode {
+	t.Helper()
+	n := &dhtNode{}
+	n.srv = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var fr DHTFindNodeRequest
+		if err := decodePeer(req.Body, req.Header.Get("Content-Type"), RPC_MAX_BODY_SIZE, &fr); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := n.HandleFindNode(fr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writePeer(w, req, http.StatusOK, resp)
+	}))
+	n.DHT = NewDHT(n.srv.Listener.Addr().String(), func(ctx context.Context, method, peer, path string, body io.Reader) (*http.Request, *http.Client) {
+		req, _ := http.NewRequestWithContext(ctx, method, "http://"+peer+path, body)
+		return req, http.DefaultClient
+	})
+	n.srv.Start()
+	t.Cleanup(n.srv.Close)
+	return n
+}
+
+// knows reports whether d has a contact at address.
+func knows(d *DHT, address string) bool {
+	return slices.ContainsFunc(d.Contacts(), func(c Contact) bool { return c.Address == address })
+}
+
+// waitUntilKnown waits for d to record the contact at address, which HandleFindNode does in the background.
+func waitUntilKnown(t *testing.T, d *DHT, address string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !knows(d, address); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s never learned of %s", d.Self().Address, address)
+		}
+	}
+}
+
+func TestDHTNodesFindEachOtherThroughLookups(t *testing.T) {
+	a, b, c := newDHTNode(t), newDHTNode(t), newDHTNode(t)
+	ctx := context.Background()
+
+	b.Bootstrap(ctx, []string{a.Self().Address})
+	if !knows(b.DHT, a.Self().Address) {
+		t.Fatal("b did not add its bootstrap node")
+	}
+	waitUntilKnown(t, a.DHT, b.Self().Address)
+
+	// c only knows b, but its self-lookup reaches a through b's contacts.
+	c.Bootstrap(ctx, []string{b.Self().Address})
+	if !knows(c.DHT, a.Self().Address) {
+		t.Fatalf("c's contacts %v lack a, two hops away", c.Contacts())
+	}
+	waitUntilKnown(t, a.DHT, c.Self().Address)
+
+	found := a.Lookup(ctx, c.Self().ID)
+	if len(found) == 0 || found[0] != c.Self() {
+		t.Fatalf("a.Lookup(c) = %v, want c first", found)
+	}
+
+	c.srv.Close()
+	a.Lookup(ctx, c.Self().ID)
+	if knows(a.DHT, c.Self().Address) {
+		t.Fatal("a kept a contact that stopped answering")
+	}
+}
+
+func TestFindNodeRejectsAContactWithAForgedID(t *testing.T) {
+	d := NewDHT("127.0.0.1:5000", nil)
+	forged := Contact{ID: NewNodeID("127.0.0.1:5001"), Address: "127.0.0.1:5002"}
+	if _, err := d.HandleFindNode(DHTFindNodeRequest{Sender: forged}); !errors.Is(err, ErrInvalidContact) {
+		t.Fatalf("HandleFindNode from a forged contact = %v, want %v", err, ErrInvalidContact)
+	}
+	sender := Contact{ID: NewNodeID("127.0.0.1:5001"), Address: "127.0.0.1:5001"}
+	resp, err := d.HandleFindNode(DHTFindNodeRequest{Sender: sender, Target: sender.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Responder != d.Self() || len(resp.Contacts) != 0 {
+		t.Fatalf("HandleFindNode = %+v, want the responder and no contacts", resp)
+	}
+}
@@ -0,0 +1,83 @@
+package node
+
+import (
+	"log"
+	"sync"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+const (
+	EVENT_BUFFER_SIZE = 64
+
+	EVENT_NEW_BLOCK       = "block"
+	EVENT_NEW_TRANSACTION = "transaction"
+	EVENT_CHAIN_REPLACED  = "chain_replaced"
+	// EVENT_TRANSACTION_EXPIRED carries the ExpiredTransaction of a transaction dropped from the pool unmined.
+	EVENT_TRANSACTION_EXPIRED = "transaction_expired"
+)
+
+// Event is a notification pushed to subscribers when the chain or the transaction pool changes.
+type Event struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// BlockInfo is a block together with its height and header hash, as returned by GET /block and block events.
+type BlockInfo struct {
+	Height int          `json:"height"`
+	Hash   string       `json:"hash"`
+	Block  *block.Block `json:"block"`
+}
+
+// TransactionInfo is a transaction together with its ID, as sent in transaction events.
+type TransactionInfo struct {
+	TransactionID string                   `json:"transaction_id"`
+	Transaction   *transaction.Transaction `json:"transaction"`
+}
+
+// EventHub fans events out to every subscriber. Publishing never blocks: a subscriber whose buffer
+// is full misses the event.
+type EventHub struct {
+	mux         sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+// NewEventHub constructs an EventHub with no subscribers.
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[chan Event]struct{})}
+}
+
+// Subscribe registers a new subscriber and returns its event channel and a function that unsubscribes it.
+func (h *EventHub) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, EVENT_BUFFER_SIZE)
+	h.mux.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mux.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mux.Lock()
+			delete(h.subscribers, ch)
+			h.mux.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish sends the event to every subscriber. A nil hub discards events.
+func (h *EventHub) Publish(e Event) {
+	if h == nil {
+		return
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+			log.Printf("action=publish_event, status=dropped, type=%s, err=subscriber buffer full", e.Type)
+		}
+	}
+}
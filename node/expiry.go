@@ -0,0 +1,110 @@
+package node
+
+import (
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+const (
+	// TRANSACTION_POOL_TTL is how long a transaction may wait in the pool before it is dropped.
+	TRANSACTION_POOL_TTL = time.Hour
+	// EXPIRY_CHECK_INTERVAL is how often the pool is swept for expired transactions.
+	EXPIRY_CHECK_INTERVAL = time.Minute
+	// MAX_EXPIRED_TRANSACTIONS bounds how many expired transactions the node remembers for GET /transaction.
+	MAX_EXPIRED_TRANSACTIONS = 1000
+)
+
+// ExpiredTransaction is a transaction dropped from the pool unmined, with when it arrived and when it expired.
+type ExpiredTransaction struct {
+	TransactionID string                   `json:"transaction_id"`
+	PooledAt      int64                    `json:"pooled_at"`
+	ExpiredAt     int64                    `json:"expired_at"`
+	Transaction   *transaction.Transaction `json:"transaction"`
+}
+
+// SetTransactionPoolTTL sets how long transactions may wait in the pool; zero or less keeps them until mined.
+func (bc *Blockchain) SetTransactionPoolTTL(ttl time.Duration) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.poolTTL = ttl
+}
+
+// StartExpireTransactions expires stale pool transactions now and reschedules itself every EXPIRY_CHECK_INTERVAL,
+// or every pool TTL if that is shorter.
+func (bc *Blockchain) StartExpireTransactions() {
+	bc.ExpireTransactions(time.Now())
+	bc.mux.RLock()
+	interval := EXPIRY_CHECK_INTERVAL
+	if bc.poolTTL > 0 {
+		interval = min(interval, bc.poolTTL)
+	}
+	bc.mux.RUnlock()
+	_ = time.AfterFunc(interval, bc.StartExpireTransactions)
+}
+
+// ExpireTransactions drops the pool transactions that arrived more than the pool TTL before now, with the
+// later nonces of their senders, which cannot be mined without them. Each is remembered for
+// ExpiredTransaction and announced to subscribers. It returns how many were dropped.
+func (bc *Blockchain) ExpireTransactions(now time.Time) int {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	arrived := make(map[[32]byte]time.Time, len(bc.transactionPool))
+	for _, t := range bc.transactionPool {
+		h := t.Hash()
+		// Transactions pooled without AddTransaction, e.g. returned by a reorganization, start their TTL now.
+		arrived[h] = now
+		if at, ok := bc.pooledAt[h]; ok {
+			arrived[h] = at
+		}
+	}
+	bc.pooledAt = maps.Clone(arrived)
+	if bc.poolTTL <= 0 {
+		return 0
+	}
+	var expired []*transaction.Transaction
+	for _, t := range slices.Clone(bc.transactionPool) {
+		h := t.Hash()
+		if _, pooled := bc.pooledAt[h]; !pooled {
+			continue // dropped with an earlier nonce of its sender
+		}
+		if t.SenderBlockchainAddress() == transaction.MINING_SENDER || now.Sub(arrived[h]) <= bc.poolTTL {
+			continue
+		}
+		expired = append(expired, bc.dropFromPool(t)...)
+	}
+	for _, t := range expired {
+		h := t.Hash()
+		at := arrived[h]
+		e := ExpiredTransaction{TransactionID: t.ID(), PooledAt: at.UnixNano(), ExpiredAt: now.UnixNano(), Transaction: t}
+		bc.rememberExpired(h, e)
+		log.Printf("action=expire_transaction, id=%s, sender=%s, pooled_at=%s", e.TransactionID, t.SenderBlockchainAddress(), at.Format(time.RFC3339))
+		bc.events.Publish(Event{Type: EVENT_TRANSACTION_EXPIRED, Data: e})
+	}
+	return len(expired)
+}
+
+// rememberExpired records e under hash, forgetting the oldest record past MAX_EXPIRED_TRANSACTIONS. Callers
+// must hold mux.
+func (bc *Blockchain) rememberExpired(hash [32]byte, e ExpiredTransaction) {
+	if _, ok := bc.expired[hash]; !ok {
+		bc.expiredOrder = append(bc.expiredOrder, hash)
+	}
+	bc.expired[hash] = e
+	for len(bc.expiredOrder) > MAX_EXPIRED_TRANSACTIONS {
+		delete(bc.expired, bc.expiredOrder[0])
+		bc.expiredOrder = bc.expiredOrder[1:]
+	}
+}
+
+// ExpiredTransaction returns the record of the transaction with this hash if it expired from the pool and
+// has not been submitted again since.
+func (bc *Blockchain) ExpiredTransaction(hash [32]byte) (ExpiredTransaction, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	e, ok := bc.expired[hash]
+	return e, ok
+}
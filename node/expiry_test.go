@@ -0,0 +1,80 @@
+package node
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+func TestStaleTransactionsExpireFromThePool(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w := fund(t, bc, miner, transaction.COIN)
+	bc.SetTransactionPoolTTL(0)
+	start := time.Now()
+	stale := send(t, bc, miner, w, transaction.COIN/100, 0)
+	next := send(t, bc, miner, w, transaction.COIN/100, 0)
+	fresh := send(t, bc, w, miner, transaction.COIN/100, 0)
+	if n := bc.ExpireTransactions(start.Add(24 * time.Hour)); n != 0 {
+		t.Fatalf("ExpireTransactions without a TTL = %d, want 0", n)
+	}
+
+	bc.SetTransactionPoolTTL(time.Hour)
+	if n := bc.ExpireTransactions(start.Add(30 * time.Minute)); n != 0 {
+		t.Fatalf("ExpireTransactions within the TTL = %d, want 0", n)
+	}
+	bc.mux.Lock()
+	bc.pooledAt[fresh.Hash()] = start.Add(time.Hour)
+	bc.mux.Unlock()
+	events, unsubscribe := bc.events.Subscribe()
+	defer unsubscribe()
+	now := start.Add(90 * time.Minute)
+	// next has not waited a TTL, but it cannot be mined without stale's nonce.
+	if n := bc.ExpireTransactions(now); n != 2 {
+		t.Fatalf("ExpireTransactions = %d, want the stale transaction and its sender's later nonce", n)
+	}
+	checkPool(t, bc, stale.Hash(), next.Hash())
+	if pool := bc.TransactionPool(); len(pool) != 1 || pool[0].ID() != fresh.ID() {
+		t.Fatalf("pool = %v, want only the fresh transaction", pool)
+	}
+	for _, tx := range []*transaction.Transaction{stale, next} {
+		e, ok := bc.ExpiredTransaction(tx.Hash())
+		if !ok || e.TransactionID != tx.ID() || e.ExpiredAt != now.UnixNano() || e.PooledAt > now.Add(-time.Hour).UnixNano() {
+			t.Fatalf("ExpiredTransaction(%s) = %+v, %v", tx.ID(), e, ok)
+		}
+		if e := <-events; e.Type != EVENT_TRANSACTION_EXPIRED {
+			t.Fatalf("event %s, want %s", e.Type, EVENT_TRANSACTION_EXPIRED)
+		}
+	}
+
+	// The sender learns of the expiry from GET /transaction.
+	bcs := &BlockchainServer{blockchain: bc, minersWallet: miner}
+	var e ExpiredTransaction
+	if rec := serve(t, bcs.GetTransaction, http.MethodGet, "/transaction?id="+stale.ID(), nil, &e); rec.Code != http.StatusGone || e.TransactionID != stale.ID() {
+		t.Fatalf("GET /transaction of an expired transaction = %d %+v, want 410", rec.Code, e)
+	}
+	// Submitted again, it is pending rather than expired.
+	if err := bc.AddTransaction(stale); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := bc.ExpiredTransaction(stale.Hash()); ok {
+		t.Fatal("a resubmitted transaction is still reported expired")
+	}
+}
+
+func TestExpiredTransactionRecordsAreBounded(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	bc.mux.Lock()
+	for i := range MAX_EXPIRED_TRANSACTIONS + 1 {
+		bc.rememberExpired(sha256.Sum256([]byte{byte(i), byte(i >> 8)}), ExpiredTransaction{})
+	}
+	bc.mux.Unlock()
+	if _, ok := bc.ExpiredTransaction(sha256.Sum256([]byte{0, 0})); ok {
+		t.Fatal("the oldest record was kept past MAX_EXPIRED_TRANSACTIONS")
+	}
+	if _, ok := bc.ExpiredTransaction(sha256.Sum256([]byte{1, 0})); !ok {
+		t.Fatal("the second oldest record was forgotten")
+	}
+}
@@ -0,0 +1,91 @@
+package node
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+const (
+	// FEE_ESTIMATE_BLOCKS is how many recent blocks fee estimates draw on.
+	FEE_ESTIMATE_BLOCKS = 20
+
+	// The fee levels aim at confirmation within these many blocks, given the pool ahead of them.
+	FEE_TARGET_HIGH   = 1
+	FEE_TARGET_MEDIUM = 3
+	FEE_TARGET_LOW    = 6
+)
+
+// FeeEstimate suggests fees for a new transaction: High to be mined in the next block, Medium within
+// FEE_TARGET_MEDIUM blocks, and Low within FEE_TARGET_LOW. Samples counts the confirmed transactions the
+// estimate drew on, and PoolSize the pending ones it had to outbid.
+type FeeEstimate struct {
+	Low      transaction.Amount `json:"low"`
+	Medium   transaction.Amount `json:"medium"`
+	High     transaction.Amount `json:"high"`
+	Blocks   int                `json:"blocks"`
+	Samples  int                `json:"samples"`
+	PoolSize int                `json:"pool_size"`
+}
+
+// EstimateFees suggests fee levels from the fees paid in the last FEE_ESTIMATE_BLOCKS blocks (their 10th,
+// 50th, and 90th percentiles) and from the pool: a level is raised to outbid the pending transactions that
+// would fill the blocks before its target, since blocks take the highest fees first.
+func (bc *Blockchain) EstimateFees() FeeEstimate {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	var e FeeEstimate
+	var fees []transaction.Amount
+	for _, b := range bc.chain[max(len(bc.chain)-FEE_ESTIMATE_BLOCKS, 1):] {
+		if b.Pruned() {
+			continue
+		}
+		e.Blocks++
+		for _, t := range b.Transactions() {
+			if t.SenderBlockchainAddress() != transaction.MINING_SENDER {
+				fees = append(fees, t.Fee())
+			}
+		}
+	}
+	slices.Sort(fees)
+	e.Samples = len(fees)
+	pool := slices.DeleteFunc(slices.Clone(bc.transactionPool), func(t *transaction.Transaction) bool { return t.SenderBlockchainAddress() == transaction.MINING_SENDER })
+	slices.SortStableFunc(pool, func(a, b *transaction.Transaction) int { return cmp.Compare(b.Fee(), a.Fee()) })
+	e.PoolSize = len(pool)
+	e.Low = max(feePercentile(fees, 10), bc.feeToOutbid(pool, FEE_TARGET_LOW))
+	e.Medium = max(feePercentile(fees, 50), bc.feeToOutbid(pool, FEE_TARGET_MEDIUM), e.Low)
+	e.High = max(feePercentile(fees, 90), bc.feeToOutbid(pool, FEE_TARGET_HIGH), e.Medium)
+	return e
+}
+
+// feePercentile returns the p-th percentile of sorted fees, 0 if there are none.
+func feePercentile(fees []transaction.Amount, p int) transaction.Amount {
+	if len(fees) == 0 {
+		return 0
+	}
+	return fees[(len(fees)-1)*p/100]
+}
+
+// feeToOutbid returns the least fee that ranks a new transaction within the first blocks blocks, filling
+// them with pool, sorted by fee, up to the block limits: one unit more than the first pending transaction
+// left out, or 0 if they all fit. Callers must hold mux.
+func (bc *Blockchain) feeToOutbid(pool []*transaction.Transaction, blocks int) transaction.Amount {
+	// Each block keeps room for the coinbase and for the new transaction, taken to be as large as the last.
+	coinbase := transactionSize(transaction.NewTransaction(transaction.MINING_SENDER, bc.blockchainAddress, math.MaxInt64, 0))
+	count, size := 0, 0
+	for _, t := range pool {
+		s := transactionSize(t)
+		full := bc.maxBlockTxs > 0 && count+2 >= bc.maxBlockTxs || bc.maxBlockSize > 0 && coinbase+size+2*s > bc.maxBlockSize
+		if full {
+			if blocks--; blocks == 0 {
+				return t.Fee() + 1
+			}
+			count, size = 0, 0
+		}
+		count++
+		size += s
+	}
+	return 0
+}
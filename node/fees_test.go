@@ -0,0 +1,49 @@
+package node
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+func TestFeeEstimatesFollowRecentBlocksAndThePool(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	if e := bc.EstimateFees(); e.Samples != 0 || e.Low != 0 || e.High != 0 {
+		t.Fatalf("EstimateFees without transactions = %+v, want no fees", e)
+	}
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for fee := transaction.Amount(1); fee <= 10; fee++ {
+		send(t, bc, miner, w, transaction.COIN/100, fee)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	// The 10th, 50th, and 90th percentiles of the fees 1 to 10.
+	e := bc.EstimateFees()
+	if e.Samples != 10 || e.PoolSize != 0 || e.Low != 1 || e.Medium != 5 || e.High != 9 {
+		t.Fatalf("EstimateFees = %+v, want 1, 5, and 9 from 10 samples", e)
+	}
+
+	// With room for one pending transaction per block, the highest bid fills the next block, so a high fee
+	// must outbid the second; the pool fits in FEE_TARGET_MEDIUM blocks, so the lower levels need not.
+	bc.SetMaxBlockTransactions(3)
+	for _, fee := range []transaction.Amount{100, 300, 200} {
+		send(t, bc, miner, w, transaction.COIN/100, fee)
+	}
+	e = bc.EstimateFees()
+	if e.PoolSize != 3 || e.Low != 1 || e.Medium != 5 || e.High != 201 {
+		t.Fatalf("EstimateFees with a full pool = %+v, want 1, 5, and 201", e)
+	}
+
+	var served FeeEstimate
+	bcs := &BlockchainServer{blockchain: bc, minersWallet: miner}
+	if rec := serve(t, bcs.Fees, http.MethodGet, "/fees", nil, &served); rec.Code != http.StatusOK || served != e {
+		t.Fatalf("GET /fees = %d %+v, want %+v", rec.Code, served, e)
+	}
+}
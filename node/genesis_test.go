@@ -0,0 +1,70 @@
+package node
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+// writeGenesis writes a genesis file for chainID allocating 10 coins to holder and returns its path.
+func writeGenesis(t *testing.T, chainID string, holder *wallet.Wallet) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	config := `{"chain_id": "` + chainID + `", "timestamp": "2024-01-01T00:00:00Z",
+		"allocations": [{"address": "` + holder.BlockchainAddress() + `", "amount": "10"}]}`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNodesLoadingOneGenesisShareTheirFirstBlock(t *testing.T) {
+	holder, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := LoadGenesisConfig(writeGenesis(t, "demo-net", holder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewBlockchain(holder.BlockchainAddress(), 0, nil, nil, block.NewProofOfWork(1, nil), genesis)
+	b := NewBlockchain(unseen(t), 0, nil, nil, block.NewProofOfWork(1, nil), genesis)
+	if a.GenesisHash() != b.GenesisHash() {
+		t.Fatalf("genesis hashes %x and %x differ", a.GenesisHash(), b.GenesisHash())
+	}
+	if got, want := a.CalculateTotalAmount(holder.BlockchainAddress()), 10*transaction.COIN; got != want {
+		t.Fatalf("premine %s, want %s", got, want)
+	}
+	if a.ChainID() != "demo-net" {
+		t.Fatalf("ChainID = %q, want demo-net", a.ChainID())
+	}
+
+	otherGenesis, err := LoadGenesisConfig(writeGenesis(t, "other-net", holder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := NewBlockchain(holder.BlockchainAddress(), 0, nil, nil, block.NewProofOfWork(1, nil), otherGenesis)
+	if other.GenesisHash() == a.GenesisHash() {
+		t.Fatal("different chain IDs produced the same genesis block")
+	}
+	fund(t, other, holder, transaction.COIN)
+	setNeighbors(a, serveNeighbor(t, other))
+	if a.ResolveConflicts(context.Background()) {
+		t.Fatal("a longer chain with another genesis was adopted")
+	}
+
+	for _, config := range []string{`{"timestamp": "2024-01-01T00:00:00Z"}`, `{"chain_id": "x"}`, `{"chain_id": "x", "timestamp": "2024-01-01T00:00:00Z", "allocations": [{"address": "nope", "amount": "1"}]}`} {
+		path := filepath.Join(t.TempDir(), "genesis.json")
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadGenesisConfig(path); err == nil {
+			t.Errorf("LoadGenesisConfig(%s) succeeded", config)
+		}
+	}
+}
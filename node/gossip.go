@@ -0,0 +1,85 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+const (
+	// GOSSIP_SEEN_CACHE_SIZE bounds how many transaction IDs the seen-cache keeps.
+	GOSSIP_SEEN_CACHE_SIZE = 10000
+	// GOSSIP_SEEN_TTL is how long a gossiped transaction is remembered; it only has to outlive one round of
+	// relays through the network.
+	GOSSIP_SEEN_TTL = 10 * time.Minute
+)
+
+// seenCache remembers the IDs of recently gossiped transactions, so that one coming back around a loop of
+// nodes is dropped before it is verified and relayed again.
+type seenCache struct {
+	mux  sync.Mutex
+	seen map[string]time.Time
+}
+
+func newSeenCache() *seenCache {
+	return &seenCache{seen: make(map[string]time.Time)}
+}
+
+// add records id as seen at now and reports whether it was new. When the cache is full, expired entries are
+// dropped first and then the oldest.
+func (c *seenCache) add(id string, now time.Time) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if at, ok := c.seen[id]; ok && now.Sub(at) < GOSSIP_SEEN_TTL {
+		return false
+	}
+	if len(c.seen) >= GOSSIP_SEEN_CACHE_SIZE {
+		oldest, oldestAt := "", now
+		for k, at := range c.seen {
+			if now.Sub(at) >= GOSSIP_SEEN_TTL {
+				delete(c.seen, k)
+			} else if at.Before(oldestAt) {
+				oldest, oldestAt = k, at
+			}
+		}
+		if len(c.seen) >= GOSSIP_SEEN_CACHE_SIZE {
+			delete(c.seen, oldest)
+		}
+	}
+	c.seen[id] = now
+	return true
+}
+
+// remove forgets id, so the transaction is handled again the next time it arrives.
+func (c *seenCache) remove(id string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.seen, id)
+}
+
+// gossipTransaction relays t to every neighbor via PUT /transactions, in the background. Each neighbor pools
+// it and relays it to its own neighbors in turn, until every node has seen it.
+func (bc *Blockchain) gossipTransaction(t *transaction.Transaction) {
+	m, contentType, err := bc.marshalPeer(t)
+	if err != nil {
+		log.Printf("action=relay_transaction, status=fail, err=%v", err)
+		return
+	}
+	for _, n := range bc.Neighbors() {
+		go func() {
+			req, client := bc.neighborRequest(context.Background(), http.MethodPut, n, "/transactions", bytes.NewReader(m))
+			req.Header.Set("Content-Type", contentType)
+			resp, err := client.Do(req)
+			if err != nil {
+				log.Printf("action=relay_transaction, neighbor=%s, err=%v", n, err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+}
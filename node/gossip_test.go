@@ -0,0 +1,92 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+func TestSeenCacheForgetsAfterItsTTLAndStaysBounded(t *testing.T) {
+	c := newSeenCache()
+	now := time.Now()
+	if !c.add("a", now) {
+		t.Fatal("add of a new ID = false")
+	}
+	if c.add("a", now.Add(GOSSIP_SEEN_TTL-time.Second)) {
+		t.Fatal("add of a seen ID within its TTL = true")
+	}
+	if !c.add("a", now.Add(GOSSIP_SEEN_TTL)) {
+		t.Fatal("add of an ID seen a TTL ago = false")
+	}
+	c.remove("a")
+	if !c.add("a", now.Add(GOSSIP_SEEN_TTL)) {
+		t.Fatal("add after remove = false")
+	}
+
+	c = newSeenCache()
+	for i := range GOSSIP_SEEN_CACHE_SIZE {
+		c.add(strconv.Itoa(i), now.Add(time.Duration(i)))
+	}
+	c.add("new", now.Add(GOSSIP_SEEN_CACHE_SIZE))
+	if len(c.seen) != GOSSIP_SEEN_CACHE_SIZE {
+		t.Fatalf("a full cache holds %d IDs, want %d", len(c.seen), GOSSIP_SEEN_CACHE_SIZE)
+	}
+	if _, ok := c.seen["0"]; ok {
+		t.Fatal("a full cache kept its oldest ID")
+	}
+}
+
+// serveTransactions serves bc's /transactions on a test server and returns its address.
+func serveTransactions(t *testing.T, bc *Blockchain) string {
+	t.Helper()
+	bcs := &BlockchainServer{blockchain: bc}
+	s := httptest.NewServer(http.HandlerFunc(bcs.Transactions))
+	t.Cleanup(s.Close)
+	return s.Listener.Addr().String()
+}
+
+func TestTransactionsGossipToEveryNodeAndStopAtTheSeenCache(t *testing.T) {
+	a, miner := newTestBlockchain(t)
+	alice := fund(t, a, miner, transaction.COIN)
+	b, _ := newTestBlockchain(t)
+	c, _ := newTestBlockchain(t)
+	for _, bc := range []*Blockchain{b, c} {
+		setNeighbors(bc, serveNeighbor(t, a))
+		if !bc.ResolveConflicts(context.Background()) {
+			t.Fatal("ResolveConflicts did not adopt the funded chain")
+		}
+	}
+
+	// a and c only reach each other through b, which relays back to a as well, closing a loop.
+	addrA, addrB, addrC := serveTransactions(t, a), serveTransactions(t, b), serveTransactions(t, c)
+	setNeighbors(a, addrB)
+	setNeighbors(b, addrC, addrA)
+	setNeighbors(c, addrB)
+
+	tx, err := alice.NewTransaction(miner.BlockchainAddress(), transaction.COIN/4, 0, a.NextNonce(alice.BlockchainAddress()), a.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.CreateTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(c.TransactionPool()) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the transaction did not reach the node two hops away")
+		}
+	}
+	for name, bc := range map[string]*Blockchain{"a": a, "b": b, "c": c} {
+		if pool := bc.TransactionPool(); len(pool) != 1 || pool[0].ID() != tx.ID() {
+			t.Fatalf("%s's pool = %v, want just the gossiped transaction", name, pool)
+		}
+	}
+	if err := b.CreateTransaction(tx); !errors.Is(err, ErrDuplicateTransaction) {
+		t.Fatalf("CreateTransaction of a gossiped transaction = %v, want %v", err, ErrDuplicateTransaction)
+	}
+}
@@ -0,0 +1,749 @@
+package node
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// This file implements the read-only subset of GraphQL served at /graphql: a single query operation with
+// optional variables, fields with aliases and arguments, and nested selection sets. Fragments, directives,
+// mutations, and subscriptions are rejected. The schema is
+//
+//	type Query {
+//	  height: Int
+//	  block(height: Int, hash: String): Block
+//	  blocks(from: Int = 0, to: Int = height): [Block]          # at most BLOCKS_PAGE_MAX_LIMIT blocks
+//	  transaction(id: String!): Transaction
+//	  transactions(address: String, fromHeight: Int, toHeight: Int): [Transaction]   # confirmed only
+//	  pool: [Transaction]
+//	  balance(address: String!, height: Int): String      # after the block at height; the tip by default
+//	}
+//	type Block {
+//	  height: Int  hash: String  previousHash: String  merkleRoot: String  stateRoot: String  accountsRoot: String  timestamp: Int  nonce: Int
+//	  transactionCount: Int  transactions(address: String): [Transaction]
+//	}
+//	type Transaction {
+//	  id: String  sender: String  recipient: String  value: String  fee: String  memo: String  data: String  lockTime: Int  nonce: Int  chainId: String  contract: String
+//	  tokenSymbol: String  tokenAmount: Int  assetId: String  confirmed: Boolean  height: Int  timestamp: Int  block: Block
+//	}
+//
+// Amounts are decimal coin strings, as in the rest of the API, so they stay exact.
+
+// GRAPHQL_MAX_DEPTH bounds how deeply selections may nest, since Transaction.block and Block.transactions
+// refer to each other.
+const GRAPHQL_MAX_DEPTH = 8
+
+var ErrGraphQLSyntax = errors.New("graphql syntax error")
+
+// GraphQLRequest is the body of POST /graphql.
+type GraphQLRequest struct {
+	Query     string         `json:"query"`
+	Variables map[string]any `json:"variables"`
+}
+
+// GraphQLError is one entry of the "errors" list of a response; Path names the field that failed.
+type GraphQLError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+// GraphQLResponse is the result of a query. A field whose resolver fails is null in Data and reported in Errors.
+type GraphQLResponse struct {
+	Data   any            `json:"data"`
+	Errors []GraphQLError `json:"errors,omitempty"`
+}
+
+// ExecuteGraphQL parses and runs a query against bc.
+func ExecuteGraphQL(bc *Blockchain, req GraphQLRequest) GraphQLResponse {
+	fields, defaults, err := parseGraphQL(req.Query)
+	if err != nil {
+		return GraphQLResponse{Errors: []GraphQLError{{Message: err.Error()}}}
+	}
+	variables := make(map[string]any, len(defaults)+len(req.Variables))
+	for name, v := range defaults {
+		variables[name] = v
+	}
+	for name, v := range req.Variables {
+		variables[name] = v
+	}
+	e := &gqlExecutor{variables: variables}
+	data := e.selectionSet(&gqlQuery{bc: bc}, fields, nil)
+	return GraphQLResponse{Data: data, Errors: e.errors}
+}
+
+// Lexer
+
+type gqlToken struct {
+	kind byte // 'n' name, 'i' int, 's' string, 'p' punctuator, 0 end of input
+	text string
+}
+
+// lexGraphQL splits src into tokens, dropping whitespace, commas, and comments as GraphQL does.
+func lexGraphQL(src string) ([]gqlToken, error) {
+	var tokens []gqlToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "..."):
+			tokens = append(tokens, gqlToken{'p', "..."})
+			i += 3
+		case strings.ContainsRune("{}():!$[]=@", rune(c)):
+			tokens = append(tokens, gqlToken{'p', string(c)})
+			i++
+		case c == '"':
+			quoted, err := strconv.QuotedPrefix(src[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%w: unterminated string", ErrGraphQLSyntax)
+			}
+			s, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrGraphQLSyntax, err)
+			}
+			tokens = append(tokens, gqlToken{'s', s})
+			i += len(quoted)
+		case c == '-' || unicode.IsDigit(rune(c)):
+			j := i + 1
+			for j < len(src) && unicode.IsDigit(rune(src[j])) {
+				j++
+			}
+			tokens = append(tokens, gqlToken{'i', src[i:j]})
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			tokens = append(tokens, gqlToken{'n', src[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("%w: unexpected character %q", ErrGraphQLSyntax, c)
+		}
+	}
+	return append(tokens, gqlToken{}), nil
+}
+
+// Parser
+
+// gqlField is one selected field; selections is empty for scalars.
+type gqlField struct {
+	alias      string
+	name       string
+	args       map[string]any
+	selections []*gqlField
+}
+
+// gqlVariable is an argument value that refers to a query variable.
+type gqlVariable string
+
+type gqlParser struct {
+	tokens []gqlToken
+	pos    int
+}
+
+// parseGraphQL parses a document holding one query and returns its top-level selections and the
+// default values of its variables.
+func parseGraphQL(src string) ([]*gqlField, map[string]any, error) {
+	tokens, err := lexGraphQL(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &gqlParser{tokens: tokens}
+	defaults := make(map[string]any)
+	if t := p.peek(); t.kind == 'n' {
+		if t.text != "query" {
+			return nil, nil, fmt.Errorf("%w: only queries are supported, not %q", ErrGraphQLSyntax, t.text)
+		}
+		p.pos++
+		if p.peek().kind == 'n' {
+			p.pos++ // operation name
+		}
+		if p.peek().text == "(" {
+			if err := p.variableDefinitions(defaults); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	fields, err := p.selectionSet()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.peek().kind != 0 {
+		return nil, nil, fmt.Errorf("%w: only one operation per document is supported", ErrGraphQLSyntax)
+	}
+	return fields, defaults, nil
+}
+
+func (p *gqlParser) peek() gqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *gqlParser) next() gqlToken {
+	t := p.tokens[p.pos]
+	if t.kind != 0 {
+		p.pos++
+	}
+	return t
+}
+
+// expect consumes the punctuator text or fails.
+func (p *gqlParser) expect(text string) error {
+	if t := p.next(); t.kind != 'p' || t.text != text {
+		return fmt.Errorf("%w: expected %q, found %q", ErrGraphQLSyntax, text, t.text)
+	}
+	return nil
+}
+
+// name consumes a name token.
+func (p *gqlParser) name() (string, error) {
+	t := p.next()
+	if t.kind != 'n' {
+		return "", fmt.Errorf("%w: expected a name, found %q", ErrGraphQLSyntax, t.text)
+	}
+	return t.text, nil
+}
+
+// variableDefinitions parses `($name: Type = default, ...)`. Types are not checked; defaults are recorded.
+func (p *gqlParser) variableDefinitions(defaults map[string]any) error {
+	p.pos++ // (
+	for p.peek().text != ")" {
+		if err := p.expect("$"); err != nil {
+			return err
+		}
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		for t := p.peek(); t.kind == 'n' || t.text == "[" || t.text == "]" || t.text == "!"; t = p.peek() {
+			p.pos++
+		}
+		if p.peek().text == "=" {
+			p.pos++
+			v, err := p.value()
+			if err != nil {
+				return err
+			}
+			defaults[name] = v
+		}
+	}
+	return p.expect(")")
+}
+
+// selectionSet parses `{ field ... }`.
+func (p *gqlParser) selectionSet() ([]*gqlField, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var fields []*gqlField
+	for p.peek().text != "}" {
+		switch t := p.peek(); {
+		case t.text == "...":
+			return nil, fmt.Errorf("%w: fragments are not supported", ErrGraphQLSyntax)
+		case t.text == "@":
+			return nil, fmt.Errorf("%w: directives are not supported", ErrGraphQLSyntax)
+		case t.kind == 0:
+			return nil, fmt.Errorf("%w: unexpected end of query", ErrGraphQLSyntax)
+		}
+		f, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	p.pos++ // }
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: empty selection set", ErrGraphQLSyntax)
+	}
+	return fields, nil
+}
+
+// field parses `[alias:] name [(arguments)] [selectionSet]`.
+func (p *gqlParser) field() (*gqlField, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	f := &gqlField{alias: name, name: name, args: make(map[string]any)}
+	if p.peek().text == ":" {
+		p.pos++
+		if f.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek().text == "(" {
+		p.pos++
+		for p.peek().text != ")" {
+			arg, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if f.args[arg], err = p.value(); err != nil {
+				return nil, err
+			}
+		}
+		p.pos++ // )
+	}
+	if p.peek().text == "{" {
+		if f.selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// value parses an argument value: a variable, int, string, boolean, null, enum name, or list.
+func (p *gqlParser) value() (any, error) {
+	t := p.next()
+	switch {
+	case t.text == "$" && t.kind == 'p':
+		name, err := p.name()
+		return gqlVariable(name), err
+	case t.kind == 'i':
+		n, err := strconv.Atoi(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrGraphQLSyntax, err)
+		}
+		return n, nil
+	case t.kind == 's':
+		return t.text, nil
+	case t.kind == 'n':
+		switch t.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return t.text, nil
+	case t.text == "[":
+		list := []any{}
+		for p.peek().text != "]" {
+			if p.peek().kind == 0 {
+				return nil, fmt.Errorf("%w: unterminated list", ErrGraphQLSyntax)
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		p.pos++ // ]
+		return list, nil
+	}
+	return nil, fmt.Errorf("%w: unexpected %q in argument", ErrGraphQLSyntax, t.text)
+}
+
+// Executor
+
+// gqlObject is a value with fields: resolveField returns a scalar, a gqlObject, a []gqlObject, or nil.
+type gqlObject interface {
+	typeName() string
+	resolveField(name string, args gqlArgs) (any, error)
+}
+
+type gqlExecutor struct {
+	variables map[string]any
+	errors    []GraphQLError
+}
+
+// gqlResult is an object in the response; it keeps fields in query order, which a Go map would not.
+type gqlResult struct {
+	keys   []string
+	values []any
+}
+
+// MarshalJSON writes the fields in the order they were selected.
+func (r *gqlResult) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range r.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		value, err := json.Marshal(r.values[i])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// selectionSet resolves fields on obj. path locates obj in the response, for error reporting.
+func (e *gqlExecutor) selectionSet(obj gqlObject, fields []*gqlField, path []any) *gqlResult {
+	result := &gqlResult{}
+	for _, f := range fields {
+		fieldPath := append(append([]any(nil), path...), f.alias)
+		value, err := e.field(obj, f, fieldPath)
+		if err != nil {
+			e.errors = append(e.errors, GraphQLError{Message: err.Error(), Path: fieldPath})
+			value = nil
+		}
+		result.keys = append(result.keys, f.alias)
+		result.values = append(result.values, value)
+	}
+	return result
+}
+
+// field resolves one field and completes its value with the field's selections.
+func (e *gqlExecutor) field(obj gqlObject, f *gqlField, path []any) (any, error) {
+	if f.name == "__typename" {
+		return obj.typeName(), nil
+	}
+	args := make(gqlArgs, len(f.args))
+	for name, v := range f.args {
+		if variable, ok := v.(gqlVariable); ok {
+			v = e.variables[string(variable)]
+		}
+		args[name] = v
+	}
+	value, err := obj.resolveField(f.name, args)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case gqlObject:
+		if len(f.selections) == 0 {
+			return nil, fmt.Errorf("field %q of type %s needs a selection of subfields", f.name, v.typeName())
+		}
+		if len(path) > GRAPHQL_MAX_DEPTH {
+			return nil, fmt.Errorf("query nests deeper than %d levels", GRAPHQL_MAX_DEPTH)
+		}
+		return e.selectionSet(v, f.selections, path), nil
+	case []gqlObject:
+		if len(f.selections) == 0 {
+			return nil, fmt.Errorf("field %q is a list of objects and needs a selection of subfields", f.name)
+		}
+		if len(path) > GRAPHQL_MAX_DEPTH {
+			return nil, fmt.Errorf("query nests deeper than %d levels", GRAPHQL_MAX_DEPTH)
+		}
+		list := make([]any, len(v))
+		for i, item := range v {
+			list[i] = e.selectionSet(item, f.selections, append(append([]any(nil), path...), i))
+		}
+		return list, nil
+	default:
+		if len(f.selections) > 0 {
+			return nil, fmt.Errorf("field %q is a scalar and has no subfields", f.name)
+		}
+		return value, nil
+	}
+}
+
+// gqlArgs are a field's arguments with variables substituted.
+type gqlArgs map[string]any
+
+// int returns the integer argument name, or def when it is absent or null. JSON variables arrive as float64.
+func (a gqlArgs) int(name string, def int) (int, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return def, nil
+	case int:
+		return v, nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("argument %q must be an Int", name)
+}
+
+// string returns the string argument name, or "" when it is absent or null.
+func (a gqlArgs) string(name string) (string, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("argument %q must be a String", name)
+}
+
+// Schema
+
+type gqlQuery struct {
+	bc *Blockchain
+}
+
+func (q *gqlQuery) typeName() string { return "Query" }
+
+func (q *gqlQuery) resolveField(name string, args gqlArgs) (any, error) {
+	switch name {
+	case "height":
+		_, length := q.bc.Blocks(0, 0)
+		return length - 1, nil
+	case "block":
+		return q.block(args)
+	case "blocks":
+		_, length := q.bc.Blocks(0, 0)
+		from, err := args.int("from", 0)
+		if err != nil {
+			return nil, err
+		}
+		to, err := args.int("to", length-1)
+		if err != nil {
+			return nil, err
+		}
+		if to-from+1 > BLOCKS_PAGE_MAX_LIMIT {
+			return nil, fmt.Errorf("blocks returns at most %d blocks per query", BLOCKS_PAGE_MAX_LIMIT)
+		}
+		blocks, _ := q.bc.Blocks(from, to-from+1)
+		list := make([]gqlObject, len(blocks))
+		for i, b := range blocks {
+			list[i] = &gqlBlock{bc: q.bc, height: from + i, block: b}
+		}
+		return list, nil
+	case "transaction":
+		id, err := args.string("id")
+		if err != nil {
+			return nil, err
+		}
+		hash, err := transaction.HashFromString(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transaction id: %v", err)
+		}
+		at, ok := q.bc.TransactionByID(hash)
+		if !ok {
+			return nil, nil
+		}
+		return &gqlTransaction{bc: q.bc, at: at}, nil
+	case "transactions":
+		return q.transactions(args)
+	case "pool":
+		pool := q.bc.TransactionPool()
+		list := make([]gqlObject, len(pool))
+		for i, t := range pool {
+			list[i] = &gqlTransaction{bc: q.bc, at: AddressTransaction{Height: -1, Transaction: t}}
+		}
+		return list, nil
+	case "balance":
+		address, err := args.string("address")
+		if err != nil {
+			return nil, err
+		}
+		if !transaction.ValidateAddress(address) {
+			return nil, fmt.Errorf("invalid address %q", address)
+		}
+		height, err := args.int("height", -1)
+		if err != nil {
+			return nil, err
+		}
+		if height < 0 {
+			return q.bc.CalculateTotalAmount(address).String(), nil
+		}
+		amount, err := q.bc.BalanceAt(address, height)
+		if err != nil {
+			return nil, err
+		}
+		return amount.String(), nil
+	}
+	return nil, fmt.Errorf("Query has no field %q", name)
+}
+
+// block resolves Query.block by height or by hash.
+func (q *gqlQuery) block(args gqlArgs) (any, error) {
+	hashStr, err := args.string("hash")
+	if err != nil {
+		return nil, err
+	}
+	if hashStr != "" {
+		hash, err := transaction.HashFromString(hashStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid block hash: %v", err)
+		}
+		b, height, ok := q.bc.BlockByHash(hash)
+		if !ok {
+			return nil, nil
+		}
+		return &gqlBlock{bc: q.bc, height: height, block: b}, nil
+	}
+	height, err := args.int("height", -1)
+	if err != nil {
+		return nil, err
+	}
+	if height < 0 {
+		return nil, errors.New("block needs a height or a hash")
+	}
+	b, ok := q.bc.BlockByHeight(height)
+	if !ok {
+		return nil, nil
+	}
+	return &gqlBlock{bc: q.bc, height: height, block: b}, nil
+}
+
+// transactions resolves Query.transactions: confirmed transactions in blocks fromHeight..toHeight
+// (the whole chain by default), optionally only those sending to or from address.
+func (q *gqlQuery) transactions(args gqlArgs) (any, error) {
+	address, err := args.string("address")
+	if err != nil {
+		return nil, err
+	}
+	_, length := q.bc.Blocks(0, 0)
+	from, err := args.int("fromHeight", 0)
+	if err != nil {
+		return nil, err
+	}
+	to, err := args.int("toHeight", length-1)
+	if err != nil {
+		return nil, err
+	}
+	from, to = max(from, 0), min(to, length-1)
+	blocks, _ := q.bc.Blocks(from, to-from+1)
+	list := make([]gqlObject, 0)
+	for i, b := range blocks {
+		for _, t := range b.Transactions() {
+			if address == "" || t.SenderBlockchainAddress() == address || t.RecipientBlockchainAddress() == address {
+				list = append(list, &gqlTransaction{bc: q.bc, at: AddressTransaction{Height: from + i, Timestamp: b.Timestamp(), Transaction: t}})
+			}
+		}
+	}
+	return list, nil
+}
+
+type gqlBlock struct {
+	bc     *Blockchain
+	height int
+	block  *block.Block
+}
+
+func (b *gqlBlock) typeName() string { return "Block" }
+
+func (b *gqlBlock) resolveField(name string, args gqlArgs) (any, error) {
+	switch name {
+	case "height":
+		return b.height, nil
+	case "hash":
+		return fmt.Sprintf("%x", b.bc.BlockHash(b.block)), nil
+	case "previousHash":
+		return fmt.Sprintf("%x", b.block.PreviousHash()), nil
+	case "merkleRoot":
+		return fmt.Sprintf("%x", b.block.MerkleRoot()), nil
+	case "stateRoot":
+		if b.block.StateRoot() == nil {
+			return nil, nil
+		}
+		return fmt.Sprintf("%x", *b.block.StateRoot()), nil
+	case "accountsRoot":
+		if b.block.AccountsRoot() == nil {
+			return nil, nil
+		}
+		return fmt.Sprintf("%x", *b.block.AccountsRoot()), nil
+	case "timestamp":
+		return b.block.Timestamp(), nil
+	case "nonce":
+		return b.block.Nonce(), nil
+	case "transactionCount":
+		return len(b.block.Transactions()), nil
+	case "transactions":
+		address, err := args.string("address")
+		if err != nil {
+			return nil, err
+		}
+		list := make([]gqlObject, 0, len(b.block.Transactions()))
+		for _, t := range b.block.Transactions() {
+			if address == "" || t.SenderBlockchainAddress() == address || t.RecipientBlockchainAddress() == address {
+				list = append(list, &gqlTransaction{bc: b.bc, at: AddressTransaction{Height: b.height, Timestamp: b.block.Timestamp(), Transaction: t}})
+			}
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("Block has no field %q", name)
+}
+
+type gqlTransaction struct {
+	bc *Blockchain
+	at AddressTransaction // Height -1 while pending
+}
+
+func (t *gqlTransaction) typeName() string { return "Transaction" }
+
+func (t *gqlTransaction) resolveField(name string, args gqlArgs) (any, error) {
+	tx := t.at.Transaction
+	pending := t.at.Height < 0
+	switch name {
+	case "id":
+		return tx.ID(), nil
+	case "sender":
+		return tx.SenderBlockchainAddress(), nil
+	case "recipient":
+		return tx.RecipientBlockchainAddress(), nil
+	case "value":
+		return tx.Value().String(), nil
+	case "fee":
+		return tx.Fee().String(), nil
+	case "memo":
+		return tx.Memo(), nil
+	case "data":
+		return hex.EncodeToString(tx.Data()), nil
+	case "lockTime":
+		return tx.LockTime(), nil
+	case "nonce":
+		return tx.Nonce(), nil
+	case "chainId":
+		return tx.ChainID(), nil
+	case "contract":
+		return tx.ContractType(), nil
+	case "tokenSymbol":
+		if tx.Token() == nil {
+			return nil, nil
+		}
+		return tx.Token().Symbol(), nil
+	case "tokenAmount":
+		if tx.Token() == nil {
+			return nil, nil
+		}
+		return tx.Token().Amount(), nil
+	case "assetId":
+		if tx.Asset() == nil {
+			return nil, nil
+		}
+		return tx.Asset().ID(), nil
+	case "confirmed":
+		return !pending, nil
+	case "height":
+		if pending {
+			return nil, nil
+		}
+		return t.at.Height, nil
+	case "timestamp":
+		if pending {
+			return nil, nil
+		}
+		return t.at.Timestamp, nil
+	case "block":
+		if pending {
+			return nil, nil
+		}
+		b, ok := t.bc.BlockByHeight(t.at.Height)
+		if !ok {
+			return nil, nil
+		}
+		return &gqlBlock{bc: t.bc, height: t.at.Height, block: b}, nil
+	}
+	return nil, fmt.Errorf("Transaction has no field %q", name)
+}
@@ -0,0 +1,77 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// graphQL runs query with variables through POST /graphql and returns the response's JSON.
+func graphQL(t *testing.T, bcs *BlockchainServer, query string, variables map[string]any) string {
+	t.Helper()
+	rec := serve(t, bcs.GraphQL, http.MethodPost, "/graphql", GraphQLRequest{Query: query, Variables: variables}, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /graphql: %d", rec.Code)
+	}
+	return strings.TrimSpace(rec.Body.String())
+}
+
+func TestGraphQLResolvesNestedSelections(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	recipient := fund(t, bc, miner, transaction.COIN/2)
+	tx := bc.LastBlock().Transactions()[0]
+
+	got := graphQL(t, bcs, `query Tip($id: String!, $who: String = "`+recipient.BlockchainAddress()+`") {
+		height
+		tip: block(height: 1) { height transactionCount transactions(address: $who) { value } }
+		transaction(id: $id) { sender value confirmed block { height } }
+		balance(address: $who)
+	}`, map[string]any{"id": tx.ID()})
+	want := fmt.Sprintf(`{"data":{"height":1,"tip":{"height":1,"transactionCount":2,"transactions":[{"value":"0.50000000"}]},`+
+		`"transaction":{"sender":"%s","value":"0.50000000","confirmed":true,"block":{"height":1}},"balance":"0.50000000"}}`, miner.BlockchainAddress())
+	if got != want {
+		t.Fatalf("POST /graphql =\n%s\nwant\n%s", got, want)
+	}
+
+	rec := serve(t, bcs.GraphQL, http.MethodGet, "/graphql?query="+url.QueryEscape("{ height }"), nil, nil)
+	if body := strings.TrimSpace(rec.Body.String()); rec.Code != http.StatusOK || body != `{"data":{"height":1}}` {
+		t.Fatalf("GET /graphql: %d %s", rec.Code, body)
+	}
+}
+
+func TestGraphQLReportsErrors(t *testing.T) {
+	bcs, _ := newTestServer(t)
+	var resp struct {
+		Data   map[string]any `json:"data"`
+		Errors []GraphQLError `json:"errors"`
+	}
+	decode := func(body string) {
+		t.Helper()
+		resp.Data, resp.Errors = nil, nil
+		if err := json.Unmarshal([]byte(body), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	decode(graphQL(t, bcs, `{ height block(height: 9) { hash } nope }`, nil))
+	if resp.Data["height"] != float64(0) || resp.Data["block"] != nil || len(resp.Errors) != 1 {
+		t.Fatalf("partial failure = %+v", resp)
+	}
+	if fmt.Sprint(resp.Errors[0].Path) != "[nope]" {
+		t.Fatalf("unknown field error = %+v", resp.Errors[0])
+	}
+
+	deep := "{ block(height: 0) { " + strings.Repeat("transactions { block { ", GRAPHQL_MAX_DEPTH) + "height" + strings.Repeat(" } }", GRAPHQL_MAX_DEPTH) + " } }"
+	for _, query := range []string{"{ height", "mutation { height }", "{ ...f }", deep, `query($id: String!) { transaction(id: $id) { id } }`} {
+		decode(graphQL(t, bcs, query, nil))
+		if len(resp.Errors) == 0 {
+			t.Errorf("%q reported no error", query)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package node
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+// spendHTLC returns the spend of h's coins to recipient on bc, signed by w.
+func spendHTLC(t *testing.T, bc *Blockchain, h *transaction.HTLC, w *wallet.Wallet, value transaction.Amount) *transaction.Transaction {
+	t.Helper()
+	tx := transaction.NewHTLCTransaction(h, w.BlockchainAddress(), value, 0)
+	tx.SetNonce(bc.NextNonce(h.Address()))
+	tx.SetChainID(bc.ChainID())
+	if err := tx.Sign(w.PrivateKey()); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestHTLCsPayTheRecipientForThePreimageOrRefundAfterTheTimeout(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	alice := fund(t, bc, miner, transaction.COIN)
+	bob, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("swap secret")
+	timeout := uint64(len(bc.Chain()) + 3)
+	lock := func(hashlock [32]byte) *transaction.HTLC {
+		h, err := transaction.NewHTLC(hashlock, bob.BlockchainAddress(), alice.BlockchainAddress(), timeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, err := alice.NewTransaction(h.Address(), transaction.COIN/4, 0, bc.NextNonce(alice.BlockchainAddress()), bc.ChainID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	claimed := lock(sha256.Sum256(secret))
+	refunded := lock(sha256.Sum256([]byte("never revealed")))
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+
+	if err := bc.AddTransaction(spendHTLC(t, bc, refunded, alice, transaction.COIN/4)); !errors.Is(err, transaction.ErrTransactionLocked) {
+		t.Fatalf("AddTransaction of a refund before the timeout = %v, want %v", err, transaction.ErrTransactionLocked)
+	}
+	if err := bc.AddTransaction(spendHTLC(t, bc, claimed.Claim([]byte("guess")), bob, transaction.COIN/4)); !errors.Is(err, transaction.ErrInvalidHTLC) {
+		t.Fatalf("AddTransaction of a claim with the wrong preimage = %v, want %v", err, transaction.ErrInvalidHTLC)
+	}
+	if err := bc.AddTransaction(spendHTLC(t, bc, claimed.Claim(secret), bob, transaction.COIN/4)); err != nil {
+		t.Fatalf("AddTransaction of a claim = %v", err)
+	}
+	// Blocks paying third parties carry the chain to the timeout.
+	for uint64(len(bc.Chain())) < timeout {
+		fund(t, bc, miner, 1)
+	}
+	if got := bc.CalculateTotalAmount(bob.BlockchainAddress()); got != transaction.COIN/4 {
+		t.Fatalf("bob holds %s after the claim, want %s", got, transaction.COIN/4)
+	}
+
+	if err := bc.AddTransaction(spendHTLC(t, bc, refunded, bob, transaction.COIN/4)); !errors.Is(err, transaction.ErrSenderKeyMismatch) {
+		t.Fatalf("AddTransaction of a refund by the recipient = %v, want %v", err, transaction.ErrSenderKeyMismatch)
+	}
+	if err := bc.AddTransaction(spendHTLC(t, bc, refunded, alice, transaction.COIN/4)); err != nil {
+		t.Fatalf("AddTransaction of a refund after the timeout = %v", err)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	for _, h := range []*transaction.HTLC{claimed, refunded} {
+		if got := bc.CalculateTotalAmount(h.Address()); got != 0 {
+			t.Errorf("the HTLC address still holds %s", got)
+		}
+	}
+	if got := bc.CalculateTotalAmount(alice.BlockchainAddress()); got != transaction.COIN*3/4 {
+		t.Fatalf("alice holds %s after the refund, want %s", got, transaction.COIN*3/4)
+	}
+}
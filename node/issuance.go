@@ -0,0 +1,51 @@
+package node
+
+import (
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+const (
+	// REWARD_HALVING_INTERVAL is how many blocks pass between halvings of the block reward.
+	// Bitcoin halves every 210,000 blocks; this demo chain uses a much shorter schedule.
+	REWARD_HALVING_INTERVAL = 210
+	// MAX_SUPPLY caps the coins ever issued by coinbase transactions. It sits just below the 420 coins the
+	// default halving schedule converges to, so the cap is reached after a little more than four halvings.
+	MAX_SUPPLY = 400 * transaction.COIN
+)
+
+// BlockReward returns the new coins paid to the miner of the block at height: MINING_REWARD halved once
+// every halvingInterval blocks, reaching zero after 63 halvings. A halvingInterval of zero or less never halves.
+func BlockReward(height int, halvingInterval int) transaction.Amount {
+	if halvingInterval <= 0 {
+		return MINING_REWARD
+	}
+	halvings := height / halvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return MINING_REWARD >> halvings
+}
+
+// CappedReward limits reward to what is left under maxSupply once issued coins exist, so mining past the cap
+// only collects fees. A maxSupply of zero or less means no cap.
+func CappedReward(reward, issued, maxSupply transaction.Amount) transaction.Amount {
+	if maxSupply <= 0 {
+		return reward
+	}
+	return max(min(reward, maxSupply-issued), 0)
+}
+
+// blockIssuance returns the new coins a block creates: what its coinbase transactions pay out minus the
+// fees collected from its other transactions.
+func blockIssuance(b *block.Block) transaction.Amount {
+	var issued transaction.Amount
+	for _, t := range b.Transactions() {
+		if t.SenderBlockchainAddress() == transaction.MINING_SENDER {
+			issued += t.Value()
+		} else {
+			issued -= t.Fee()
+		}
+	}
+	return issued
+}
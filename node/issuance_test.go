@@ -0,0 +1,90 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// coinbaseValue returns what the coinbase transactions of b pay.
+func coinbaseValue(b *block.Block) transaction.Amount {
+	var v transaction.Amount
+	for _, t := range b.Transactions() {
+		if t.SenderBlockchainAddress() == transaction.MINING_SENDER {
+			v += t.Value()
+		}
+	}
+	return v
+}
+
+func TestBlockRewardHalvesEveryInterval(t *testing.T) {
+	tests := []struct {
+		height, interval int
+		want             transaction.Amount
+	}{
+		{0, 10, MINING_REWARD},
+		{9, 10, MINING_REWARD},
+		{10, 10, MINING_REWARD / 2},
+		{25, 10, MINING_REWARD / 4},
+		{630, 10, 0},
+		{1000, 0, MINING_REWARD},
+	}
+	for _, tt := range tests {
+		if got := BlockReward(tt.height, tt.interval); got != tt.want {
+			t.Errorf("BlockReward(%d, %d) = %s, want %s", tt.height, tt.interval, got, tt.want)
+		}
+	}
+}
+
+func TestMiningPaysTheHalvedReward(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	fund(t, bc, miner, transaction.COIN/2)
+	full := bc.Chain()
+	if got := coinbaseValue(full[1]); got != MINING_REWARD {
+		t.Fatalf("reward before halving %s, want %s", got, MINING_REWARD)
+	}
+
+	bc.SetHalvingInterval(1)
+	if bc.ValidChain(full) {
+		t.Fatal("a block paying the unhalved reward is valid after halving")
+	}
+	fund(t, bc, miner, transaction.COIN/4)
+	chain := bc.Chain()
+	if got, want := coinbaseValue(chain[2]), MINING_REWARD/4; got != want {
+		t.Fatalf("reward at height 2 %s, want %s", got, want)
+	}
+}
+
+func TestMiningStopsIssuingAtTheMaxSupply(t *testing.T) {
+	if got := CappedReward(MINING_REWARD, 0, 0); got != MINING_REWARD {
+		t.Fatalf("uncapped reward %s, want %s", got, MINING_REWARD)
+	}
+	if got := CappedReward(MINING_REWARD, 2*MINING_REWARD, MINING_REWARD); got != 0 {
+		t.Fatalf("reward past the cap %s, want 0", got)
+	}
+
+	bc, miner := newTestBlockchain(t)
+	bc.SetMaxSupply(MINING_REWARD + transaction.COIN/4)
+	w := fund(t, bc, miner, transaction.COIN/2)
+	if got, want := coinbaseValue(bc.LastBlock()), transaction.COIN/4; got != want {
+		t.Fatalf("reward reaching the cap %s, want %s", got, want)
+	}
+	recipient := fund(t, bc, w, transaction.COIN/10)
+	send(t, bc, w, recipient, transaction.COIN/10, transaction.COIN/100)
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	if got, want := coinbaseValue(bc.LastBlock()), transaction.COIN/100; got != want {
+		t.Fatalf("coinbase at the cap %s, want only the fee %s", got, want)
+	}
+	if got, want := bc.IssuedSupply(), MINING_REWARD+transaction.COIN/4; got != want {
+		t.Fatalf("IssuedSupply = %s, want %s", got, want)
+	}
+
+	bc.SetMaxSupply(MINING_REWARD)
+	if bc.ValidChain(bc.Chain()) {
+		t.Fatal("a chain issuing past the cap is valid")
+	}
+}
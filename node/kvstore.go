@@ -0,0 +1,423 @@
+package node
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/dikako/how-blockchain-works/block"
+)
+
+const (
+	BLOCKS_KV_FILE_NAME = "blocks.kv"
+
+	// MAX_KV_RECORD_SIZE bounds one LogStore record, the writes of one Apply, when the log is replayed.
+	MAX_KV_RECORD_SIZE = 1 << 30
+)
+
+var errCorruptLogRecord = errors.New("corrupt record")
+
+// KeyValueStore is what a KVBlockStore needs from a key-value database: reading a key and applying a batch
+// of writes atomically. LogStore is built in, and BoltStore keeps the keys in a Bolt file.
+type KeyValueStore interface {
+	// Get returns the value of key, or ok false if it has none.
+	Get(key string) (value []byte, ok bool, err error)
+	// Apply sets every key of puts and removes every key of deletes in one atomic write.
+	Apply(puts map[string][]byte, deletes []string) error
+	// Path returns where the database is kept.
+	Path() string
+}
+
+// LogStore is a KeyValueStore in one append-only file, in the manner of Bitcask. Every Apply appends a
+// record of its writes with their CRC-32, and an index in memory maps each live key to where its value
+// lies in the file. Opening replays the file to rebuild the index and drops a last record cut short by a
+// crash, so a batch is applied whole or not at all. Overwritten values are not reclaimed.
+type LogStore struct {
+	path string
+
+	mux  sync.Mutex
+	file *os.File
+	size int64 // where the next record goes
+	keys map[string]logValue
+}
+
+// logValue is where a value lies in a LogStore's file.
+type logValue struct {
+	offset int64
+	length int
+}
+
+// OpenLogStore opens the log at path, creating it and its directory if needed.
+func OpenLogStore(path string) (*LogStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &LogStore{path: path, file: f, keys: make(map[string]logValue)}
+	if err := s.replay(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// replay rebuilds the index from the records in the file, truncating it after the last intact one.
+func (s *LogStore) replay() error {
+	r := bufio.NewReader(s.file)
+	for {
+		n, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return nil
+		}
+		payload := make([]byte, min(n, MAX_KV_RECORD_SIZE))
+		var sum [4]byte
+		if err == nil && n > MAX_KV_RECORD_SIZE {
+			err = errCorruptLogRecord
+		}
+		if err == nil {
+			_, err = io.ReadFull(r, payload)
+		}
+		if err == nil {
+			_, err = io.ReadFull(r, sum[:])
+		}
+		if err == nil && crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(sum[:]) {
+			err = errCorruptLogRecord
+		}
+		start := s.size + int64(len(binary.AppendUvarint(nil, n)))
+		if err == nil {
+			err = s.applyPayload(payload, start)
+		}
+		if err != nil {
+			log.Printf("action=replay_log, status=truncated, path=%s, offset=%d, err=%v", s.path, s.size, err)
+			return s.file.Truncate(s.size)
+		}
+		s.size = start + int64(n) + int64(len(sum))
+	}
+}
+
+// applyPayload updates the index with the writes of a record whose payload starts at offset in the file.
+func (s *LogStore) applyPayload(payload []byte, offset int64) error {
+	p := payload
+	next := func() ([]byte, error) {
+		n, k := binary.Uvarint(p)
+		if k <= 0 || n > uint64(len(p)-k) {
+			return nil, errCorruptLogRecord
+		}
+		field := p[k : k+int(n)]
+		p = p[k+int(n):]
+		return field, nil
+	}
+	count := func() (int, error) {
+		n, k := binary.Uvarint(p)
+		if k <= 0 || n > uint64(len(p)) {
+			return 0, errCorruptLogRecord
+		}
+		p = p[k:]
+		return int(n), nil
+	}
+	puts := make(map[string]logValue)
+	var deletes []string
+	n, err := count()
+	if err != nil {
+		return err
+	}
+	for range n {
+		key, err := next()
+		if err != nil {
+			return err
+		}
+		value, err := next()
+		if err != nil {
+			return err
+		}
+		puts[string(key)] = logValue{offset: offset + int64(len(payload)-len(p)-len(value)), length: len(value)}
+	}
+	if n, err = count(); err != nil {
+		return err
+	}
+	for range n {
+		key, err := next()
+		if err != nil {
+			return err
+		}
+		deletes = append(deletes, string(key))
+	}
+	if len(p) != 0 {
+		return errCorruptLogRecord
+	}
+	for _, key := range deletes {
+		delete(s.keys, key)
+	}
+	maps.Copy(s.keys, puts)
+	return nil
+}
+
+// Get returns the value of key, or ok false if it has none.
+func (s *LogStore) Get(key string) ([]byte, bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	v, ok := s.keys[key]
+	if !ok {
+		return nil, false, nil
+	}
+	value := make([]byte, v.length)
+	if _, err := s.file.ReadAt(value, v.offset); err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Apply appends one record with every key of puts set and every key of deletes removed, and syncs it to disk.
+func (s *LogStore) Apply(puts map[string][]byte, deletes []string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	payload := binary.AppendUvarint(nil, uint64(len(puts)))
+	for _, key := range slices.Sorted(maps.Keys(puts)) {
+		payload = binary.AppendUvarint(payload, uint64(len(key)))
+		payload = append(payload, key...)
+		payload = binary.AppendUvarint(payload, uint64(len(puts[key])))
+		payload = append(payload, puts[key]...)
+	}
+	payload = binary.AppendUvarint(payload, uint64(len(deletes)))
+	for _, key := range deletes {
+		payload = binary.AppendUvarint(payload, uint64(len(key)))
+		payload = append(payload, key...)
+	}
+	record := binary.AppendUvarint(nil, uint64(len(payload)))
+	start := s.size + int64(len(record))
+	record = binary.BigEndian.AppendUint32(append(record, payload...), crc32.ChecksumIEEE(payload))
+	if _, err := s.file.WriteAt(record, s.size); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	if err := s.applyPayload(payload, start); err != nil {
+		return err
+	}
+	s.size += int64(len(record))
+	return nil
+}
+
+// Path returns the location of the log file.
+func (s *LogStore) Path() string {
+	return s.path
+}
+
+// Close closes the log file.
+func (s *LogStore) Close() error {
+	return s.file.Close()
+}
+
+// KVBlockStore keeps the chain in a KeyValueStore under three kinds of keys: "length", the number of
+// blocks; "height/<n>", the hash of the block at height n; and "block/<hash in hex>", the block's height as a
+// uvarint, then the block as encodeStoredBlock stores it.
+type KVBlockStore struct {
+	kv          KeyValueStore
+	compression string
+	hasher      block.Hasher
+	mux         sync.Mutex
+}
+
+// NewKVBlockStore keeps blocks in kv, stored under compression and looked up by their hash under hasher (nil
+// for SHA-256).
+func NewKVBlockStore(kv KeyValueStore, compression string, hasher block.Hasher) (*KVBlockStore, error) {
+	if compression != STORAGE_COMPRESSION_SNAPPY && compression != STORAGE_COMPRESSION_NONE {
+		return nil, fmt.Errorf("%w %q (available: %s, %s)", ErrUnknownStorageCompression, compression, STORAGE_COMPRESSION_SNAPPY, STORAGE_COMPRESSION_NONE)
+	}
+	if hasher == nil {
+		hasher = block.SHA256Hasher{}
+	}
+	return &KVBlockStore{kv: kv, compression: compression, hasher: hasher}, nil
+}
+
+// kvHeightKey returns the key of the hash of the block at height.
+func kvHeightKey(height int) string {
+	return "height/" + strconv.Itoa(height)
+}
+
+// kvBlockKey returns the key of the block with hash.
+func kvBlockKey(hash [32]byte) string {
+	return fmt.Sprintf("block/%x", hash)
+}
+
+// length returns the number of stored blocks. Callers must hold mux.
+func (s *KVBlockStore) length() (int, error) {
+	m, ok, err := s.kv.Get("length")
+	if err != nil || !ok {
+		return 0, err
+	}
+	return strconv.Atoi(string(m))
+}
+
+// encodeBlock returns the value stored for b at height.
+func (s *KVBlockStore) encodeBlock(height int, b *block.Block) ([]byte, error) {
+	return encodeStoredBlock(binary.AppendUvarint(nil, uint64(height)), b, s.compression)
+}
+
+// decodeBlock returns the block and height of a value stored by encodeBlock.
+func decodeBlock(value []byte) (*block.Block, int, error) {
+	height, k := binary.Uvarint(value)
+	if k <= 0 {
+		return nil, 0, ErrCorruptStoredBlock
+	}
+	b, err := decodeStoredBlock(value[k:])
+	return b, int(height), err
+}
+
+func (s *KVBlockStore) PutBlock(b *block.Block) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	n, err := s.length()
+	if err != nil {
+		return err
+	}
+	hash := b.HashWithCache(s.hasher)
+	value, err := s.encodeBlock(n, b)
+	if err != nil {
+		return err
+	}
+	return s.kv.Apply(map[string][]byte{kvHeightKey(n): hash[:], kvBlockKey(hash): value, "length": []byte(strconv.Itoa(n + 1))}, nil)
+}
+
+// ReplaceChain writes only the blocks after the last one chain shares with the stored chain, and removes
+// the stored blocks after it, in one batch.
+func (s *KVBlockStore) ReplaceChain(chain []*block.Block) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	n, err := s.length()
+	if err != nil {
+		return err
+	}
+	fork := 0
+	for ; fork < min(n, len(chain)); fork++ {
+		stored, _, err := s.kv.Get(kvHeightKey(fork))
+		if err != nil {
+			return err
+		}
+		if hash := chain[fork].HashWithCache(s.hasher); string(stored) != string(hash[:]) {
+			break
+		}
+	}
+	puts := map[string][]byte{"length": []byte(strconv.Itoa(len(chain)))}
+	for height := fork; height < len(chain); height++ {
+		hash := chain[height].HashWithCache(s.hasher)
+		value, err := s.encodeBlock(height, chain[height])
+		if err != nil {
+			return err
+		}
+		puts[kvHeightKey(height)] = hash[:]
+		puts[kvBlockKey(hash)] = value
+	}
+	var deletes []string
+	for height := fork; height < n; height++ {
+		stored, ok, err := s.kv.Get(kvHeightKey(height))
+		if err != nil {
+			return err
+		}
+		if height >= len(chain) {
+			deletes = append(deletes, kvHeightKey(height))
+		}
+		if ok && len(stored) == 32 {
+			if key := kvBlockKey([32]byte(stored)); puts[key] == nil {
+				deletes = append(deletes, key)
+			}
+		}
+	}
+	return s.kv.Apply(puts, deletes)
+}
+
+func (s *KVBlockStore) BlockByHeight(height int) (*block.Block, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.blockByHeight(height)
+}
+
+// blockByHeight is BlockByHeight without locking; callers must hold mux.
+func (s *KVBlockStore) blockByHeight(height int) (*block.Block, error) {
+	hash, ok, err := s.kv.Get(kvHeightKey(height))
+	if err != nil {
+		return nil, err
+	}
+	if !ok || len(hash) != 32 {
+		return nil, ErrBlockNotStored
+	}
+	b, _, err := s.blockByHash([32]byte(hash))
+	return b, err
+}
+
+func (s *KVBlockStore) BlockByHash(hash [32]byte) (*block.Block, int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.blockByHash(hash)
+}
+
+// blockByHash is BlockByHash without locking; callers must hold mux.
+func (s *KVBlockStore) blockByHash(hash [32]byte) (*block.Block, int, error) {
+	value, ok, err := s.kv.Get(kvBlockKey(hash))
+	if err != nil {
+		return nil, 0, err
+	}
+	if !ok {
+		return nil, 0, ErrBlockNotStored
+	}
+	return decodeBlock(value)
+}
+
+func (s *KVBlockStore) Tip() (*block.Block, int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	n, err := s.length()
+	if err != nil {
+		return nil, 0, err
+	}
+	if n == 0 {
+		return nil, 0, ErrBlockNotStored
+	}
+	b, err := s.blockByHeight(n - 1)
+	return b, n - 1, err
+}
+
+func (s *KVBlockStore) ForEach(fn func(height int, b *block.Block) error) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	n, err := s.length()
+	if err != nil {
+		return err
+	}
+	for height := range n {
+		b, err := s.blockByHeight(height)
+		if err != nil {
+			return fmt.Errorf("stored block %d: %w", height, err)
+		}
+		if err := fn(height, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *KVBlockStore) Path() string {
+	return s.kv.Path()
+}
+
+// Close closes the key-value store, if it can be closed.
+func (s *KVBlockStore) Close() error {
+	if c, ok := s.kv.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
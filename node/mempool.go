@@ -0,0 +1,108 @@
+package node
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+var ErrTransactionNotPooled = errors.New("transaction is not in the pool")
+
+// PoolEntry is a pending transaction as operators see it: its fee and size, when it was pooled (Unix
+// nanoseconds), how long it has waited, and when it expires (0 without a pool TTL).
+type PoolEntry struct {
+	TransactionID string                   `json:"transaction_id"`
+	Fee           transaction.Amount       `json:"fee"`
+	Size          int                      `json:"size"`
+	PooledAt      int64                    `json:"pooled_at"`
+	Age           string                   `json:"age"`
+	ExpiresAt     int64                    `json:"expires_at,omitempty"`
+	Transaction   *transaction.Transaction `json:"transaction"`
+}
+
+// PoolEntries returns the pending transactions as of now, highest fee first and the oldest first among
+// equal fees, the order mining considers them in.
+func (bc *Blockchain) PoolEntries(now time.Time) []PoolEntry {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	entries := make([]PoolEntry, 0, len(bc.transactionPool))
+	for _, t := range bc.transactionPool {
+		entries = append(entries, bc.poolEntry(t, now))
+	}
+	slices.SortStableFunc(entries, func(a, b PoolEntry) int { return cmp.Compare(b.Fee, a.Fee) })
+	return entries
+}
+
+// PoolEntry returns the pending transaction with this hash as of now, if it is in the pool.
+func (bc *Blockchain) PoolEntry(hash [32]byte, now time.Time) (PoolEntry, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	for _, t := range bc.transactionPool {
+		if t.Hash() == hash {
+			return bc.poolEntry(t, now), true
+		}
+	}
+	return PoolEntry{}, false
+}
+
+// poolEntry describes pooled transaction t as of now; a transaction the expiry sweep has not seen yet
+// counts as pooled now. Callers must hold mux.
+func (bc *Blockchain) poolEntry(t *transaction.Transaction, now time.Time) PoolEntry {
+	at, ok := bc.pooledAt[t.Hash()]
+	if !ok {
+		at = now
+	}
+	e := PoolEntry{
+		TransactionID: t.ID(),
+		Fee:           t.Fee(),
+		Size:          transactionSize(t),
+		PooledAt:      at.UnixNano(),
+		Age:           now.Sub(at).Round(time.Second).String(),
+		Transaction:   t,
+	}
+	if bc.poolTTL > 0 {
+		e.ExpiresAt = at.Add(bc.poolTTL).UnixNano()
+	}
+	return e
+}
+
+// EvictTransaction drops the pending transaction with this hash, with the later nonces of its sender,
+// which cannot be mined without it, and returns what it dropped. It returns ErrTransactionNotPooled if
+// there is no such transaction. The sender may submit it again.
+func (bc *Blockchain) EvictTransaction(hash [32]byte) ([]*transaction.Transaction, error) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	i := slices.IndexFunc(bc.transactionPool, func(t *transaction.Transaction) bool { return t.Hash() == hash })
+	if i < 0 {
+		return nil, ErrTransactionNotPooled
+	}
+	evicted := bc.dropFromPool(bc.transactionPool[i])
+	for _, t := range evicted {
+		log.Printf("action=evict_transaction, id=%s, sender=%s, fee=%s", t.ID(), t.SenderBlockchainAddress(), t.Fee())
+	}
+	return evicted, nil
+}
+
+// dropFromPool removes victim from the pool with the later nonces of its sender, which cannot be mined
+// without it, forgets when they arrived, and returns them, victim first. Callers must hold mux.
+func (bc *Blockchain) dropFromPool(victim *transaction.Transaction) []*transaction.Transaction {
+	dropped := []*transaction.Transaction{victim}
+	bc.transactionPool = slices.DeleteFunc(bc.transactionPool, func(t *transaction.Transaction) bool {
+		if t == victim {
+			return true
+		}
+		if !victim.HasNonce() || !t.HasNonce() || t.SenderBlockchainAddress() != victim.SenderBlockchainAddress() || t.Nonce() < victim.Nonce() {
+			return false
+		}
+		dropped = append(dropped, t)
+		return true
+	})
+	for _, t := range dropped {
+		delete(bc.pooledAt, t.Hash())
+	}
+	return dropped
+}
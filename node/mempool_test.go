@@ -0,0 +1,90 @@
+package node
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+func TestMempoolEndpointsListAndEvictPendingTransactions(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	w := fund(t, bc, miner, transaction.COIN)
+	low := send(t, bc, miner, w, transaction.COIN/100, 1)
+	high := send(t, bc, miner, w, transaction.COIN/100, 5)
+	other := send(t, bc, w, miner, transaction.COIN/100, 3)
+
+	var list struct {
+		Transactions []PoolEntry `json:"transactions"`
+		Length       int         `json:"length"`
+	}
+	if rec := serve(t, bcs.Mempool, http.MethodGet, "/mempool", nil, &list); rec.Code != http.StatusOK {
+		t.Fatalf("GET /mempool: %d", rec.Code)
+	}
+	var ids []string
+	for _, e := range list.Transactions {
+		ids = append(ids, e.TransactionID)
+	}
+	if want := []string{high.ID(), other.ID(), low.ID()}; list.Length != 3 || !slices.Equal(ids, want) {
+		t.Fatalf("GET /mempool = %v, want the highest fee first: %v", ids, want)
+	}
+	var e PoolEntry
+	if rec := serve(t, bcs.Mempool, http.MethodGet, "/mempool?id="+other.ID(), nil, &e); rec.Code != http.StatusOK {
+		t.Fatalf("GET /mempool?id=: %d", rec.Code)
+	}
+	if e.TransactionID != other.ID() || e.Fee != 3 || e.Size != transactionSize(other) || e.ExpiresAt != e.PooledAt+int64(TRANSACTION_POOL_TTL) {
+		t.Fatalf("GET /mempool?id= = %+v", e)
+	}
+	for target, want := range map[string]int{
+		"/mempool?id=nope":                       http.StatusBadRequest,
+		"/mempool?id=" + strings.Repeat("0", 64): http.StatusNotFound,
+	} {
+		if rec := serve(t, bcs.Mempool, http.MethodGet, target, nil, nil); rec.Code != want {
+			t.Errorf("GET %s: %d, want %d", target, rec.Code, want)
+		}
+	}
+
+	// Evicting the miner's first transaction takes its later nonce with it.
+	var evicted struct {
+		Evicted []string `json:"evicted"`
+	}
+	if rec := serve(t, bcs.Mempool, http.MethodDelete, "/mempool?id="+low.ID(), nil, &evicted); rec.Code != http.StatusOK {
+		t.Fatalf("DELETE /mempool?id=: %d", rec.Code)
+	}
+	if want := []string{low.ID(), high.ID()}; !slices.Equal(evicted.Evicted, want) {
+		t.Fatalf("DELETE /mempool?id= evicted %v, want %v", evicted.Evicted, want)
+	}
+	checkPool(t, bc, low.Hash(), high.Hash())
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		if rec := serve(t, bcs.Mempool, method, "/mempool?id="+low.ID(), nil, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("%s /mempool?id= of an evicted transaction: %d, want 404", method, rec.Code)
+		}
+	}
+	if rec := serve(t, bcs.Mempool, http.MethodDelete, "/mempool", nil, nil); rec.Code != http.StatusOK || len(bc.TransactionPool()) != 0 {
+		t.Fatalf("DELETE /mempool: %d with %d transactions left", rec.Code, len(bc.TransactionPool()))
+	}
+	if rec := serve(t, bcs.Mempool, http.MethodPut, "/mempool", nil, nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("PUT /mempool: %d, want 405", rec.Code)
+	}
+}
+
+func TestMempoolEndpointsRequireACredentialToRead(t *testing.T) {
+	bcs, _ := newTestServer(t)
+	bcs.SetAuth(NewAuthenticator([]string{"key"}, ""))
+	handler := bcs.requireAuth("mempool", bcs.Mempool)
+	for _, key := range []string{"", "key"} {
+		req := httptest.NewRequest(http.MethodGet, "/mempool", nil)
+		if key != "" {
+			req.Header.Set(API_KEY_HEADER, key)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if want := map[string]int{"": http.StatusUnauthorized, "key": http.StatusOK}[key]; rec.Code != want {
+			t.Errorf("GET /mempool with key %q: %d, want %d", key, rec.Code, want)
+		}
+	}
+}
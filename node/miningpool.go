@@ -0,0 +1,167 @@
+package node
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+const (
+	// MINING_POOL_FEE_PERCENT is the share of each pool block's reward the operator keeps by default.
+	MINING_POOL_FEE_PERCENT = 1
+	// MINING_POOL_MIN_PAYOUT is the default balance at which the pool pays an address. It must exceed the block
+	// reward: every payout is a transaction the pool mines into a block, and smaller payouts would keep it mining.
+	MINING_POOL_MIN_PAYOUT = 2 * transaction.COIN
+	// MINING_POOL_ROUND_HISTORY bounds how many paid rounds the pool remembers for GET /stratum.
+	MINING_POOL_ROUND_HISTORY = 20
+)
+
+// PoolCredit is what one address earned in a round for its shares.
+type PoolCredit struct {
+	Address string             `json:"address"`
+	Shares  int                `json:"shares"`
+	Amount  transaction.Amount `json:"amount"`
+}
+
+// PoolPayout is a balance the pool sent to an address, by the transaction TransactionID.
+type PoolPayout struct {
+	Address       string             `json:"address"`
+	Amount        transaction.Amount `json:"amount"`
+	TransactionID string             `json:"transaction_id"`
+}
+
+// PoolRound is the split of one pool block's reward: the operator's fee, then the rest credited in
+// proportion to the shares each address found since the pool's previous block, and the payouts of the
+// balances that reached the minimum payout.
+type PoolRound struct {
+	Height    int                `json:"height"`
+	BlockHash string             `json:"block_hash"`
+	Reward    transaction.Amount `json:"reward"`
+	Fee       transaction.Amount `json:"fee"`
+	Shares    int                `json:"shares"`
+	Credits   []PoolCredit       `json:"credits"`
+	Payouts   []PoolPayout       `json:"payouts,omitempty"`
+}
+
+// PoolStatus is the pool's accounting as GET /stratum shows it: the shares of the round in progress, the
+// balances not paid yet and the totals paid, all by address, and the latest rounds, newest first.
+type PoolStatus struct {
+	Address     string                        `json:"address"`
+	FeePercent  int                           `json:"fee_percent"`
+	MinPayout   transaction.Amount            `json:"min_payout"`
+	RoundShares map[string]int                `json:"round_shares"`
+	Balances    map[string]transaction.Amount `json:"balances"`
+	Paid        map[string]transaction.Amount `json:"paid"`
+	Rounds      []PoolRound                   `json:"rounds"`
+}
+
+// MiningPool does the share accounting of a stratum server under proportional (PROP) rewards: every
+// accepted share counts for the address in its worker name, and when the pool finds a block, the reward,
+// minus the operator's fee, is credited to the round's addresses by their shares. Balances of at least
+// minPayout are paid from wallet, which the coinbase pays; the remainder of the integer division stays
+// with the operator.
+type MiningPool struct {
+	wallet     *wallet.Wallet
+	feePercent int
+	minPayout  transaction.Amount
+
+	mux      sync.Mutex
+	round    map[string]int
+	balances map[string]transaction.Amount
+	paid     map[string]transaction.Amount
+	rounds   []PoolRound
+}
+
+// NewMiningPool pays out from wallet, keeping feePercent of each block for the operator and paying an
+// address once its balance reaches minPayout.
+func NewMiningPool(wallet *wallet.Wallet, feePercent int, minPayout transaction.Amount) *MiningPool {
+	return &MiningPool{
+		wallet:     wallet,
+		feePercent: min(max(feePercent, 0), 100),
+		minPayout:  minPayout,
+		round:      make(map[string]int),
+		balances:   make(map[string]transaction.Amount),
+		paid:       make(map[string]transaction.Amount),
+	}
+}
+
+// payoutAddress returns the address a worker's shares are credited to: its name up to the first dot, as in
+// ADDRESS.rig1, which must be a valid address.
+func payoutAddress(worker string) (string, bool) {
+	address, _, _ := strings.Cut(worker, ".")
+	return address, transaction.ValidateAddress(address)
+}
+
+// addShare credits an accepted share to address.
+func (p *MiningPool) addShare(address string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.round[address]++
+}
+
+// payRound closes the round with the pool's block at height, whose coinbase paid reward to the pool's
+// wallet, credits each address its part, and sends the balances that reached the minimum payout as
+// transactions to bc. A new round starts.
+func (p *MiningPool) payRound(bc *Blockchain, height int, hash [32]byte, reward transaction.Amount) PoolRound {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	r := PoolRound{Height: height, BlockHash: fmt.Sprintf("%x", hash), Reward: reward, Fee: reward * transaction.Amount(p.feePercent) / 100}
+	for _, shares := range p.round {
+		r.Shares += shares
+	}
+	split := reward - r.Fee
+	for _, address := range slices.Sorted(maps.Keys(p.round)) {
+		c := PoolCredit{Address: address, Shares: p.round[address], Amount: split * transaction.Amount(p.round[address]) / transaction.Amount(r.Shares)}
+		if address != p.wallet.BlockchainAddress() {
+			p.balances[address] += c.Amount
+		}
+		r.Credits = append(r.Credits, c)
+	}
+	clear(p.round)
+	log.Printf("action=pool_round, height=%d, reward=%s, fee=%s, shares=%d, addresses=%d", height, reward, r.Fee, r.Shares, len(r.Credits))
+	// Balances that failed to be paid, e.g. while the wallet's coins are still in the pool, are retried next round.
+	for _, address := range slices.Sorted(maps.Keys(p.balances)) {
+		amount := p.balances[address]
+		if amount < max(p.minPayout, 1) {
+			continue
+		}
+		t, err := p.wallet.NewTransaction(address, amount, 0, bc.NextNonce(p.wallet.BlockchainAddress()), bc.ChainID())
+		if err == nil {
+			err = bc.CreateTransaction(t)
+		}
+		if err != nil {
+			log.Printf("action=pool_payout, status=fail, height=%d, address=%s, amount=%s, err=%v", height, address, amount, err)
+			continue
+		}
+		delete(p.balances, address)
+		p.paid[address] += amount
+		r.Payouts = append(r.Payouts, PoolPayout{Address: address, Amount: amount, TransactionID: t.ID()})
+		log.Printf("action=pool_payout, status=success, height=%d, address=%s, amount=%s, id=%s", height, address, amount, t.ID())
+	}
+	p.rounds = append([]PoolRound{r}, p.rounds...)
+	if len(p.rounds) > MINING_POOL_ROUND_HISTORY {
+		p.rounds = p.rounds[:MINING_POOL_ROUND_HISTORY]
+	}
+	return r
+}
+
+// Status returns the pool's accounting.
+func (p *MiningPool) Status() PoolStatus {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return PoolStatus{
+		Address:     p.wallet.BlockchainAddress(),
+		FeePercent:  p.feePercent,
+		MinPayout:   p.minPayout,
+		RoundShares: maps.Clone(p.round),
+		Balances:    maps.Clone(p.balances),
+		Paid:        maps.Clone(p.paid),
+		Rounds:      append([]PoolRound(nil), p.rounds...),
+	}
+}
@@ -0,0 +1,82 @@
+package node
+
+import (
+	"context"
+	"maps"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+func TestMiningPoolsSplitRewardsByShares(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	var a, b *wallet.Wallet
+	for _, w := range []**wallet.Wallet{&a, &b} {
+		var err error
+		if *w, err = wallet.NewWallet(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := NewMiningPool(miner, 1, transaction.COIN/2)
+	for range 3 {
+		p.addShare(a.BlockchainAddress())
+	}
+	p.addShare(b.BlockchainAddress())
+	if got := p.Status().RoundShares; got[a.BlockchainAddress()] != 3 || got[b.BlockchainAddress()] != 1 {
+		t.Fatalf("round shares = %v, want 3 and 1", got)
+	}
+
+	// The operator keeps 1%; a's three quarters of the rest reach the minimum payout, b's quarter does not.
+	split := transaction.COIN - transaction.COIN/100
+	r := p.payRound(bc, 1, [32]byte{1}, transaction.COIN)
+	if r.Fee != transaction.COIN/100 || r.Shares != 4 || len(r.Credits) != 2 {
+		t.Fatalf("round = %+v, want a fee of 1%% over 4 shares", r)
+	}
+	if len(r.Payouts) != 1 || r.Payouts[0].Address != a.BlockchainAddress() || r.Payouts[0].Amount != split*3/4 {
+		t.Fatalf("payouts = %+v, want %s to a", r.Payouts, split*3/4)
+	}
+	if pool := bc.TransactionPool(); len(pool) != 1 || pool[0].ID() != r.Payouts[0].TransactionID {
+		t.Fatalf("pool = %v, want a's payout", pool)
+	}
+	s := p.Status()
+	if len(s.RoundShares) != 0 || !maps.Equal(s.Balances, map[string]transaction.Amount{b.BlockchainAddress(): split / 4}) {
+		t.Fatalf("status = %+v, want a new round and b's quarter owed", s)
+	}
+
+	// The pool's own shares credit no balance, and b's payout waits until the pool's wallet can afford it.
+	p.addShare(b.BlockchainAddress())
+	p.addShare(miner.BlockchainAddress())
+	if r := p.payRound(bc, 2, [32]byte{2}, transaction.COIN); len(r.Payouts) != 0 {
+		t.Fatalf("payouts = %+v beyond the pool wallet's balance", r.Payouts)
+	}
+	owed := split/4 + split/2
+	if s := p.Status(); !maps.Equal(s.Balances, map[string]transaction.Amount{b.BlockchainAddress(): owed}) {
+		t.Fatalf("balances = %v, want %s owed to b", s.Balances, owed)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	r = p.payRound(bc, 3, [32]byte{3}, 0)
+	if len(r.Payouts) != 1 || r.Payouts[0].Address != b.BlockchainAddress() || r.Payouts[0].Amount != owed {
+		t.Fatalf("payouts = %+v, want %s to b", r.Payouts, owed)
+	}
+	s = p.Status()
+	if len(s.Balances) != 0 || s.Paid[a.BlockchainAddress()] != split*3/4 || s.Paid[b.BlockchainAddress()] != owed || len(s.Rounds) != 3 || s.Rounds[0].Height != 3 {
+		t.Fatalf("status = %+v, want everything paid and the rounds newest first", s)
+	}
+}
+
+func TestMiningPoolWorkersAreNamedByTheirPayoutAddress(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	s := NewStratumServer(bc, 0)
+	s.SetPool(NewMiningPool(miner, MINING_POOL_FEE_PERCENT, MINING_POOL_MIN_PAYOUT))
+	c := newStratumClient(t, s)
+	c.call("mining.subscribe")
+	if m := c.call("mining.authorize", "rig"); m.errorCode() != STRATUM_ERR_UNAUTHORIZED {
+		t.Fatalf("mining.authorize without an address: %+v, want error %d", m.Error, STRATUM_ERR_UNAUTHORIZED)
+	}
+	if m := c.call("mining.authorize", unseen(t)+".rig"); m.Error != nil {
+		t.Fatalf("mining.authorize of ADDRESS.rig: %+v", m.Error)
+	}
+}
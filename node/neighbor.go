@@ -0,0 +1,189 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NEIGHBOR_IP_RANGE_START           = 0
+	NEIGHBOR_IP_RANGE_END             = 1
+	BLOCKCHAIN_PORT_RANGE_START       = 5000
+	BLOCKCHAIN_PORT_RANGE_END         = 5003
+	BLOCKCHAIN_NEIGHBOR_SYNC_TIME_SEC = 20
+	NEIGHBOR_DIAL_TIMEOUT             = 1 * time.Second
+
+	// MAX_KNOWN_PEERS bounds the peers remembered across restarts, most recently seen first.
+	MAX_KNOWN_PEERS = 256
+
+	DNS_SEED_DEFAULT_PORT = BLOCKCHAIN_PORT_RANGE_START
+	DNS_SEED_TIMEOUT      = 5 * time.Second
+	// DNS_SEED_MAX_ADDRESSES bounds how many addresses of one seed are dialed per sync.
+	DNS_SEED_MAX_ADDRESSES = 32
+)
+
+// NeighborRange describes which hosts and ports are scanned for peer nodes.
+// IP offsets are added to the last octet of the node's own IPv4 address.
+type NeighborRange struct {
+	StartIP   uint8
+	EndIP     uint8
+	StartPort uint16
+	EndPort   uint16
+}
+
+// DefaultNeighborRange returns the scan range built from the NEIGHBOR_* and BLOCKCHAIN_PORT_* constants.
+func DefaultNeighborRange() NeighborRange {
+	return NeighborRange{
+		StartIP:   NEIGHBOR_IP_RANGE_START,
+		EndIP:     NEIGHBOR_IP_RANGE_END,
+		StartPort: BLOCKCHAIN_PORT_RANGE_START,
+		EndPort:   BLOCKCHAIN_PORT_RANGE_END,
+	}
+}
+
+// IsFoundHost reports whether a TCP connection can be opened to host:port.
+func IsFoundHost(host string, port uint16) bool {
+	target := net.JoinHostPort(host, fmt.Sprintf("%d", port))
+	conn, err := net.DialTimeout("tcp", target, NEIGHBOR_DIAL_TIMEOUT)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// FindNeighbors scans the given range around myHost and returns the reachable host:port pairs,
+// excluding this node itself.
+func FindNeighbors(myHost string, myPort uint16, r NeighborRange) []string {
+	ip := net.ParseIP(myHost).To4()
+	if ip == nil {
+		return nil
+	}
+	neighbors := make([]string, 0)
+	for port := r.StartPort; port <= r.EndPort; port++ {
+		for offset := r.StartIP; offset <= r.EndIP; offset++ {
+			guess := make(net.IP, len(ip))
+			copy(guess, ip)
+			guess[3] += offset
+			guessHost := guess.String()
+			if guessHost == myHost && port == myPort {
+				continue
+			}
+			if IsFoundHost(guessHost, port) {
+				neighbors = append(neighbors, net.JoinHostPort(guessHost, fmt.Sprintf("%d", port)))
+			}
+			if offset == r.EndIP {
+				break
+			}
+		}
+		if port == r.EndPort {
+			break
+		}
+	}
+	return neighbors
+}
+
+// ParsePeers splits a comma-separated list of host:port peer addresses, as given to -peers, and checks each one.
+func ParsePeers(list string) ([]string, error) {
+	peers := make([]string, 0)
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer %q: %w", p, err)
+		}
+		if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 || host == "" {
+			return nil, fmt.Errorf("invalid peer %q: want host:port", p)
+		}
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+// ResolveSeeds looks up each DNS seed, given as "name" or "name:port", and returns its A and AAAA records as
+// peer addresses on the seed's port (DNS_SEED_DEFAULT_PORT if none). Seeds that fail to resolve are logged and skipped.
+func ResolveSeeds(seeds []string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), DNS_SEED_TIMEOUT)
+	defer cancel()
+	peers := make([]string, 0)
+	for _, seed := range seeds {
+		name, port := seed, strconv.Itoa(DNS_SEED_DEFAULT_PORT)
+		if h, p, err := net.SplitHostPort(seed); err == nil {
+			name, port = h, p
+		}
+		addresses, err := net.DefaultResolver.LookupHost(ctx, name)
+		if err != nil {
+			log.Printf("action=resolve_seed, seed=%s, err=%v", seed, err)
+			continue
+		}
+		if len(addresses) > DNS_SEED_MAX_ADDRESSES {
+			addresses = addresses[:DNS_SEED_MAX_ADDRESSES]
+		}
+		for _, a := range addresses {
+			peers = mergePeers(peers, []string{net.JoinHostPort(a, port)})
+		}
+	}
+	return peers
+}
+
+// isSelf reports whether peer is this node's own address: its port on a loopback address or on myHost.
+func isSelf(peer, myHost string, myPort uint16) bool {
+	host, port, err := net.SplitHostPort(peer)
+	if err != nil || port != strconv.Itoa(int(myPort)) {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	return host == "localhost" || host == myHost
+}
+
+// ReachablePeers returns the peers, other than this node, that accept a connection opened by dial, checking
+// them in parallel.
+func ReachablePeers(peers []string, myHost string, myPort uint16, dial func(ctx context.Context, network, addr string) (net.Conn, error)) []string {
+	reachable := make([]bool, len(peers))
+	var wg sync.WaitGroup
+	for i, p := range peers {
+		if isSelf(p, myHost, myPort) {
+			continue
+		}
+		wg.Go(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), NEIGHBOR_DIAL_TIMEOUT)
+			defer cancel()
+			conn, err := dial(ctx, "tcp", p)
+			if err == nil {
+				conn.Close()
+				reachable[i] = true
+			}
+		})
+	}
+	wg.Wait()
+	found := make([]string, 0)
+	for i, p := range peers {
+		if reachable[i] {
+			found = append(found, p)
+		}
+	}
+	return found
+}
+
+// mergePeers returns a followed by the peers of b not already in a.
+func mergePeers(a, b []string) []string {
+	merged := append(make([]string, 0, len(a)+len(b)), a...)
+	for _, p := range b {
+		if !slices.Contains(merged, p) {
+			merged = append(merged, p)
+		}
+	}
+	return merged
+}
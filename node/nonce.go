@@ -0,0 +1,98 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// replayProtectionHeight returns the height from which blocks may confirm only nonced transactions: the
+// genesis file's, or 0 on a node without one.
+func (bc *Blockchain) replayProtectionHeight() int {
+	if bc.genesis == nil {
+		return 0
+	}
+	return bc.genesis.ReplayProtectionHeight
+}
+
+// applyNonces advances the nonce of the sender of every nonced transaction in transactions.
+func applyNonces(nonces map[string]uint64, transactions []*transaction.Transaction) {
+	for _, t := range transactions {
+		if t.HasNonce() {
+			nonces[t.SenderBlockchainAddress()]++
+		}
+	}
+}
+
+// nonceSequence checks that nonced transactions follow their senders' nonces in order, on top of the
+// confirmed nonces.
+type nonceSequence struct {
+	nonces map[string]uint64
+	next   map[string]uint64
+}
+
+func newNonceSequence(nonces map[string]uint64) *nonceSequence {
+	return &nonceSequence{nonces: nonces, next: make(map[string]uint64)}
+}
+
+// expected returns the nonce the next transaction from address must carry.
+func (s *nonceSequence) expected(address string) uint64 {
+	if n, ok := s.next[address]; ok {
+		return n
+	}
+	return s.nonces[address]
+}
+
+// admit returns ErrInvalidNonce unless t carries its sender's next nonce, or records it and returns nil.
+// Transactions without a nonce always pass.
+func (s *nonceSequence) admit(t *transaction.Transaction) error {
+	if !t.HasNonce() {
+		return nil
+	}
+	if n := s.expected(t.SenderBlockchainAddress()); t.Nonce() != n {
+		return fmt.Errorf("%w: %d, expected %d", transaction.ErrInvalidNonce, t.Nonce(), n)
+	}
+	s.next[t.SenderBlockchainAddress()] = t.Nonce() + 1
+	return nil
+}
+
+// sortNonces puts each sender's nonced transactions in nonce order, keeping the positions the sender's
+// transactions take, so the order across senders (by fee, say) is unchanged.
+func sortNonces(transactions []*transaction.Transaction) {
+	positions := make(map[string][]int)
+	for i, t := range transactions {
+		if t.HasNonce() {
+			positions[t.SenderBlockchainAddress()] = append(positions[t.SenderBlockchainAddress()], i)
+		}
+	}
+	for _, at := range positions {
+		sent := make([]*transaction.Transaction, len(at))
+		for i, p := range at {
+			sent[i] = transactions[p]
+		}
+		sort.SliceStable(sent, func(i, j int) bool { return sent[i].Nonce() < sent[j].Nonce() })
+		for i, p := range at {
+			transactions[p] = sent[i]
+		}
+	}
+}
+
+// NextNonce returns the nonce of the address's next transaction: its confirmed nonce plus its pending
+// nonced transactions.
+func (bc *Blockchain) NextNonce(blockchainAddress string) uint64 {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.nextNonce(blockchainAddress)
+}
+
+// nextNonce is NextNonce without locking; callers must hold mux.
+func (bc *Blockchain) nextNonce(blockchainAddress string) uint64 {
+	nonce := bc.nonces[blockchainAddress]
+	for _, t := range bc.transactionPool {
+		if t.HasNonce() && t.SenderBlockchainAddress() == blockchainAddress {
+			nonce++
+		}
+	}
+	return nonce
+}
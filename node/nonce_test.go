@@ -0,0 +1,88 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+func TestConfirmedTransactionsCannotBeReplayed(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := send(t, bc, miner, w, transaction.COIN/4, 0)
+	if n := bc.NextNonce(miner.BlockchainAddress()); n != first.Nonce()+1 {
+		t.Fatalf("NextNonce with one pooled transaction = %d, want %d", n, first.Nonce()+1)
+	}
+	skipped, err := miner.NewTransaction(w.BlockchainAddress(), transaction.COIN/4, 0, first.Nonce()+2, bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(skipped); !errors.Is(err, transaction.ErrInvalidNonce) {
+		t.Fatalf("AddTransaction skipping a nonce = %v, want %v", err, transaction.ErrInvalidNonce)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	if n := bc.NextNonce(miner.BlockchainAddress()); n != first.Nonce()+1 {
+		t.Fatalf("NextNonce after confirming = %d, want %d", n, first.Nonce()+1)
+	}
+	if err := bc.AddTransaction(first); !errors.Is(err, ErrDuplicateTransaction) {
+		t.Fatalf("AddTransaction of a confirmed transfer = %v, want %v", err, ErrDuplicateTransaction)
+	}
+
+	// Transfers distinct from those before, so only their nonces, not their IDs, can repeat.
+	reused, err := miner.NewTransaction(w.BlockchainAddress(), transaction.COIN/8, 0, first.Nonce(), bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := miner.NewTransaction(w.BlockchainAddress(), transaction.COIN/4, 0, first.Nonce()+1, bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := miner.NewTransaction(w.BlockchainAddress(), transaction.COIN/8, 0, first.Nonce()+1, bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	for _, tt := range []struct {
+		name string
+		txs  []*transaction.Transaction
+		want error
+	}{
+		{"the next nonce", []*transaction.Transaction{second, coinbase}, nil},
+		{"a used nonce", []*transaction.Transaction{reused, coinbase}, transaction.ErrInvalidNonce},
+		{"a nonce twice", []*transaction.Transaction{second, again, coinbase}, transaction.ErrInvalidNonce},
+		{"a gap", []*transaction.Transaction{skipped, coinbase}, transaction.ErrInvalidNonce},
+	} {
+		b := sealBlock(t, bc, tt.txs)
+		if err := bc.checkBlock(bc.chain, b, bc.tipState(), bc.rules()); !errors.Is(err, tt.want) {
+			t.Errorf("checkBlock with %s = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestSortNoncesOrdersEachSenderInItsOwnPositions(t *testing.T) {
+	tx := func(sender string, nonce uint64) *transaction.Transaction {
+		tx := transaction.NewTransaction(sender, "recipient", 1, 0)
+		tx.SetNonce(nonce)
+		return tx
+	}
+	a2, b0, a0, a1, b1 := tx("a", 2), tx("b", 0), tx("a", 0), tx("a", 1), tx("b", 1)
+	coinbase := transaction.NewTransaction(transaction.MINING_SENDER, "miner", 1, 0)
+	txs := []*transaction.Transaction{a2, b1, coinbase, a0, b0, a1}
+	sortNonces(txs)
+	want := []*transaction.Transaction{a0, b0, coinbase, a1, b1, a2}
+	for i := range want {
+		if txs[i] != want[i] {
+			t.Fatalf("position %d holds %s nonce %d, want %s nonce %d", i, txs[i].SenderBlockchainAddress(), txs[i].Nonce(), want[i].SenderBlockchainAddress(), want[i].Nonce())
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+const (
+	// ORPHAN_POOL_SIZE bounds how many blocks with an unknown parent a node holds; the oldest is evicted first.
+	ORPHAN_POOL_SIZE = 100
+	// ORPHAN_TTL is how long an orphan waits for its parent before it is dropped.
+	ORPHAN_TTL = 10 * time.Minute
+	// ORPHAN_MAX_ANCESTORS is how many missing ancestors a node requests one by one before it gives up and
+	// fetches whole chains with ResolveConflicts instead.
+	ORPHAN_MAX_ANCESTORS = 16
+
+	// ORPHAN_TRANSACTION_POOL_SIZE and ORPHAN_TRANSACTION_TTL bound the relayed transactions held until their
+	// sender can afford them, as Bitcoin Core does with 100 orphans for 20 minutes.
+	ORPHAN_TRANSACTION_POOL_SIZE = 100
+	ORPHAN_TRANSACTION_TTL       = 20 * time.Minute
+)
+
+var (
+	ErrBlockNotFound     = errors.New("no neighbor has the block")
+	ErrOrphanTransaction = errors.New("sender cannot afford the transaction yet; held until a new block")
+)
+
+type orphanBlock struct {
+	block *block.Block
+	added time.Time
+}
+
+// orphanPool holds blocks whose parent the node does not know yet, because they arrived before it, until
+// the parent is connected to the chain.
+type orphanPool struct {
+	mux    sync.Mutex
+	blocks map[[32]byte]orphanBlock
+}
+
+func newOrphanPool() *orphanPool {
+	return &orphanPool{blocks: make(map[[32]byte]orphanBlock)}
+}
+
+// add stores b under its hash at now and reports whether it was new. When the pool is full, expired
+// orphans are dropped first and then the oldest.
+func (p *orphanPool) add(hash [32]byte, b *block.Block, now time.Time) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if _, ok := p.blocks[hash]; ok {
+		return false
+	}
+	if len(p.blocks) >= ORPHAN_POOL_SIZE {
+		var oldest [32]byte
+		oldestAt := now
+		for h, o := range p.blocks {
+			if now.Sub(o.added) >= ORPHAN_TTL {
+				delete(p.blocks, h)
+			} else if o.added.Before(oldestAt) {
+				oldest, oldestAt = h, o.added
+			}
+		}
+		if len(p.blocks) >= ORPHAN_POOL_SIZE {
+			delete(p.blocks, oldest)
+		}
+	}
+	p.blocks[hash] = orphanBlock{block: b, added: now}
+	return true
+}
+
+// get returns the orphan with this hash, if held.
+func (p *orphanPool) get(hash [32]byte) (*block.Block, bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	o, ok := p.blocks[hash]
+	return o.block, ok
+}
+
+// takeChildren removes and returns the unexpired orphans whose parent is the block with hash parent.
+func (p *orphanPool) takeChildren(parent [32]byte, now time.Time) []*block.Block {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	children := make([]*block.Block, 0)
+	for h, o := range p.blocks {
+		if o.block.PreviousHash() != parent {
+			continue
+		}
+		delete(p.blocks, h)
+		if now.Sub(o.added) < ORPHAN_TTL {
+			children = append(children, o.block)
+		}
+	}
+	return children
+}
+
+// orphanTransactionPool holds relayed transactions whose sender cannot afford them yet, usually because the
+// block that pays the sender was announced to the relaying neighbor but has not reached this node.
+type orphanTransactionPool struct {
+	mux          sync.Mutex
+	transactions map[string]orphanTransaction
+}
+
+type orphanTransaction struct {
+	transaction *transaction.Transaction
+	added       time.Time
+}
+
+func newOrphanTransactionPool() *orphanTransactionPool {
+	return &orphanTransactionPool{transactions: make(map[string]orphanTransaction)}
+}
+
+// add holds t from added on and reports whether it was new. When the pool is full, expired transactions are
+// dropped first and then the oldest.
+func (p *orphanTransactionPool) add(t *transaction.Transaction, added time.Time) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	id := t.ID()
+	if _, ok := p.transactions[id]; ok {
+		return false
+	}
+	if len(p.transactions) >= ORPHAN_TRANSACTION_POOL_SIZE {
+		oldest, oldestAt := "", added
+		for k, o := range p.transactions {
+			if added.Sub(o.added) >= ORPHAN_TRANSACTION_TTL {
+				delete(p.transactions, k)
+			} else if o.added.Before(oldestAt) {
+				oldest, oldestAt = k, o.added
+			}
+		}
+		if len(p.transactions) >= ORPHAN_TRANSACTION_POOL_SIZE {
+			delete(p.transactions, oldest)
+		}
+	}
+	p.transactions[id] = orphanTransaction{transaction: t, added: added}
+	return true
+}
+
+// takeAll empties the pool and returns the unexpired transactions, oldest first.
+func (p *orphanTransactionPool) takeAll(now time.Time) []orphanTransaction {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	all := make([]orphanTransaction, 0, len(p.transactions))
+	for _, o := range p.transactions {
+		if now.Sub(o.added) < ORPHAN_TRANSACTION_TTL {
+			all = append(all, o)
+		}
+	}
+	p.transactions = make(map[string]orphanTransaction)
+	slices.SortFunc(all, func(a, b orphanTransaction) int { return a.added.Compare(b.added) })
+	return all
+}
+
+func (p *orphanTransactionPool) len() int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return len(p.transactions)
+}
+
+// ReceiveTransaction handles a transaction relayed by a neighbor like CreateTransaction, except that one the
+// sender cannot afford yet is held as an orphan, which fails with ErrOrphanTransaction, instead of dropped.
+// Each new block retries the orphans, so a transaction that outran the block funding it is still pooled.
+// Transactions submitted by clients are not held: their wallet should learn the balance is short.
+func (bc *Blockchain) ReceiveTransaction(t *transaction.Transaction) error {
+	err := bc.CreateTransaction(t)
+	if !errors.Is(err, ErrInsufficientBalance) {
+		return err
+	}
+	if bc.orphanTxs.add(t, time.Now()) {
+		log.Printf("action=receive_orphan_transaction, id=%s, sender=%s", t.ID(), t.SenderBlockchainAddress())
+	}
+	return ErrOrphanTransaction
+}
+
+// retryOrphanTransactions offers every held orphan transaction to the pool again, after a block changed the
+// balances. Those still unaffordable are held on with their original age; the rest are pooled and gossiped,
+// or dropped if invalid or known.
+func (bc *Blockchain) retryOrphanTransactions() {
+	if bc.orphanTxs.len() == 0 {
+		return
+	}
+	for _, o := range bc.orphanTxs.takeAll(time.Now()) {
+		err := bc.CreateTransaction(o.transaction)
+		switch {
+		case errors.Is(err, ErrInsufficientBalance):
+			bc.orphanTxs.add(o.transaction, o.added)
+		case err != nil:
+			log.Printf("action=retry_orphan_transaction, status=dropped, id=%s, err=%v", o.transaction.ID(), err)
+		default:
+			log.Printf("action=retry_orphan_transaction, status=pooled, id=%s", o.transaction.ID())
+		}
+	}
+}
+
+// OrphanTransactions returns how many relayed transactions wait for their sender's balance.
+func (bc *Blockchain) OrphanTransactions() int {
+	return bc.orphanTxs.len()
+}
+
+// Orphans returns how many blocks wait for their parent.
+func (bc *Blockchain) Orphans() int {
+	bc.orphans.mux.Lock()
+	defer bc.orphans.mux.Unlock()
+	return len(bc.orphans.blocks)
+}
+
+// receiveOrphan checks b, whose parent is unknown, stores it, and requests the missing ancestors from the
+// neighbors in the background.
+func (bc *Blockchain) receiveOrphan(hash [32]byte, b *block.Block) error {
+	if err := bc.checkOrphan(b); err != nil {
+		return err
+	}
+	if !bc.orphans.add(hash, b, time.Now()) {
+		return nil
+	}
+	log.Printf("action=receive_orphan_block, hash=%x, parent=%x", hash, b.PreviousHash())
+	go bc.fetchAncestors(context.Background(), b)
+	return nil
+}
+
+// checkOrphan returns why b, whose parent is unknown, cannot be a block the node would connect, as far as
+// that can be told without the parent: its Merkle root, its timestamp, and its seal at one of the heights
+// fetchAncestors can connect it at. Only a sealed orphan costs the node requests to its neighbors.
+func (bc *Blockchain) checkOrphan(b *block.Block) error {
+	if b.MerkleRoot() != block.MerkleRoot(b.Transactions()) {
+		return errors.New("merkle root mismatch")
+	}
+	if b.Timestamp() > time.Now().Add(MAX_FUTURE_BLOCK_TIME).UnixNano() {
+		return fmt.Errorf("timestamp more than %s in the future", MAX_FUTURE_BLOCK_TIME)
+	}
+	bc.mux.RLock()
+	tip := len(bc.chain) - 1
+	bc.mux.RUnlock()
+	var err error
+	for height := tip + 2; height <= tip+1+ORPHAN_MAX_ANCESTORS; height++ {
+		if err = bc.Consensus().Verify(height, b); err == nil {
+			return nil
+		}
+		if _, ok := bc.Consensus().(*block.ProofOfWork); ok {
+			// A proof of work does not depend on the height.
+			break
+		}
+	}
+	return err
+}
+
+// checkForkHeader returns why b, whose parent is a known block other than the tip, cannot follow that
+// block. Only a block that can is worth fetching the neighbors' chains for with ResolveConflicts.
+func (bc *Blockchain) checkForkHeader(b *block.Block) error {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	height, ok := bc.blockIndex[b.PreviousHash()]
+	if !ok {
+		return errors.New("parent not in the chain")
+	}
+	return bc.checkHeader(bc.chain[:height+1], b)
+}
+
+// fetchAncestors walks back from orphan b, fetching each missing ancestor from the neighbors into the
+// orphan pool, until it reaches a block the node knows. If that is the tip, the orphans are connected to
+// it; if it is an older block, the orphans belong to a fork and, if the first of them can follow that block,
+// ResolveConflicts decides between the chains. After ORPHAN_MAX_ANCESTORS blocks the node is too far behind
+// and also falls back to ResolveConflicts.
+func (bc *Blockchain) fetchAncestors(ctx context.Context, b *block.Block) {
+	for range ORPHAN_MAX_ANCESTORS {
+		parent := b.PreviousHash()
+		if _, _, ok := bc.BlockByHash(parent); ok {
+			if parent == bc.BlockHash(bc.LastBlock()) {
+				bc.connectOrphans(parent)
+			} else if err := bc.checkForkHeader(b); err != nil {
+				log.Printf("action=fetch_ancestors, status=dropped, hash=%x, err=%v", bc.BlockHash(b), err)
+			} else {
+				bc.ResolveConflicts(ctx)
+			}
+			return
+		}
+		ancestor, ok := bc.orphans.get(parent)
+		if !ok {
+			var err error
+			if ancestor, err = bc.fetchBlockFromNeighbors(ctx, parent); err != nil {
+				log.Printf("action=fetch_ancestors, hash=%x, err=%v", parent, err)
+				return
+			}
+			if err := bc.checkOrphan(ancestor); err != nil {
+				log.Printf("action=fetch_ancestors, status=dropped, hash=%x, err=%v", parent, err)
+				return
+			}
+			bc.orphans.add(parent, ancestor, time.Now())
+		}
+		b = ancestor
+	}
+	log.Printf("action=fetch_ancestors, status=too_far_behind, max=%d", ORPHAN_MAX_ANCESTORS)
+	bc.ResolveConflicts(ctx)
+}
+
+// connectOrphans appends the orphans descending from the block with hash parent, which must be the tip,
+// and announces each to the neighbors. Of sibling orphans only the first valid one is connected.
+func (bc *Blockchain) connectOrphans(parent [32]byte) {
+	queue := [][32]byte{parent}
+	for len(queue) > 0 {
+		children := bc.orphans.takeChildren(queue[0], time.Now())
+		queue = queue[1:]
+		for _, b := range children {
+			if err := bc.appendValidBlock(b); err != nil {
+				log.Printf("action=connect_orphan_block, status=dropped, hash=%x, err=%v", bc.BlockHash(b), err)
+				continue
+			}
+			hash := bc.BlockHash(b)
+			log.Printf("action=connect_orphan_block, status=connected, hash=%x", hash)
+			go bc.announceBlock(context.Background(), b)
+			queue = append(queue, hash)
+		}
+	}
+}
+
+// fetchBlockFromNeighbors asks each neighbor in turn for the block with hash through GET /block?hash=.
+func (bc *Blockchain) fetchBlockFromNeighbors(ctx context.Context, hash [32]byte) (*block.Block, error) {
+	for _, n := range bc.Neighbors() {
+		b, err := bc.fetchBlock(ctx, n, hash)
+		if err != nil {
+			log.Printf("action=fetch_block, neighbor=%s, err=%v", n, err)
+			continue
+		}
+		return b, nil
+	}
+	return nil, ErrBlockNotFound
+}
+
+// fetchBlock downloads the block with hash from neighbor n and checks that it is the one asked for.
+func (bc *Blockchain) fetchBlock(ctx context.Context, n string, hash [32]byte) (*block.Block, error) {
+	req, client := bc.neighborRequest(ctx, http.MethodGet, n, fmt.Sprintf("/block?hash=%x", hash), nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("block answered %s", resp.Status)
+	}
+	var info struct {
+		Block *block.Block `json:"block"`
+	}
+	if err := decodePeer(resp.Body, resp.Header.Get("Content-Type"), WIRE_MAX_RESPONSE_SIZE, &info); err != nil {
+		return nil, err
+	}
+	if info.Block == nil || bc.BlockHash(info.Block) != hash {
+		return nil, errors.New("neighbor sent a different block")
+	}
+	return info.Block, nil
+}
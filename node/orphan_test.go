@@ -0,0 +1,154 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+func TestOrphanPoolEvictsExpiredThenOldest(t *testing.T) {
+	p := newOrphanPool()
+	now := time.Now()
+	parent := [32]byte{0xff}
+	for i := range ORPHAN_POOL_SIZE {
+		b := block.NewBlock(i, parent, nil)
+		if !p.add([32]byte{byte(i)}, b, now.Add(time.Duration(i))) {
+			t.Fatalf("add of orphan %d = false", i)
+		}
+	}
+	if p.add([32]byte{0}, block.NewBlock(0, parent, nil), now) {
+		t.Fatal("add of a held orphan = true")
+	}
+	p.add([32]byte{0xee}, block.NewBlock(0, parent, nil), now.Add(ORPHAN_POOL_SIZE))
+	if len(p.blocks) != ORPHAN_POOL_SIZE {
+		t.Fatalf("a full pool holds %d orphans, want %d", len(p.blocks), ORPHAN_POOL_SIZE)
+	}
+	if _, ok := p.get([32]byte{0}); ok {
+		t.Fatal("a full pool kept its oldest orphan")
+	}
+
+	// Past their TTL, orphans are dropped to make room and are not connected.
+	late := now.Add(ORPHAN_TTL + time.Hour)
+	p.add([32]byte{0xdd}, block.NewBlock(0, [32]byte{}, nil), late)
+	if len(p.blocks) != 1 {
+		t.Fatalf("%d orphans after the rest expired, want 1", len(p.blocks))
+	}
+	if children := p.takeChildren(parent, late); len(children) != 0 {
+		t.Fatalf("takeChildren returned %d expired orphans", len(children))
+	}
+	if children := p.takeChildren([32]byte{}, late); len(children) != 1 || len(p.blocks) != 0 {
+		t.Fatalf("takeChildren = %d orphans, leaving %d", len(children), len(p.blocks))
+	}
+}
+
+func TestOrphanBlocksConnectOnceTheirAncestorsArrive(t *testing.T) {
+	ahead, miner := newTestBlockchain(t)
+	fund(t, ahead, miner, transaction.COIN)
+	behind, _ := newTestBlockchain(t)
+	setNeighbors(behind, serveNeighbor(t, ahead))
+	if !behind.ResolveConflicts(context.Background()) {
+		t.Fatal("ResolveConflicts did not adopt the funded chain")
+	}
+
+	fund(t, ahead, miner, transaction.COIN)
+	fund(t, ahead, miner, transaction.COIN)
+	tip := ahead.LastBlock()
+
+	// behind only hears of the newest block and must fetch its parent.
+	bcs := &BlockchainServer{blockchain: ahead}
+	s := httptest.NewServer(http.HandlerFunc(bcs.GetBlock))
+	defer s.Close()
+	setNeighbors(behind, s.Listener.Addr().String())
+	cb := NewCompactBlock(tip)
+	cb.Prefilled = tip.Transactions()
+	resp, err := behind.ReceiveCompactBlock(context.Background(), cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != COMPACT_BLOCK_ORPHAN {
+		t.Fatalf("ReceiveCompactBlock of a block two ahead = %s, want %s", resp.Status, COMPACT_BLOCK_ORPHAN)
+	}
+	for deadline := time.Now().Add(5 * time.Second); behind.BlockHash(behind.LastBlock()) != ahead.BlockHash(tip); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the orphan was not connected; chain length %d, want %d", len(behind.Chain()), len(ahead.Chain()))
+		}
+	}
+	if n := behind.Orphans(); n != 0 {
+		t.Fatalf("%d orphans left after connecting", n)
+	}
+}
+
+func TestOrphanTransactionPoolEvictsExpiredThenOldest(t *testing.T) {
+	p := newOrphanTransactionPool()
+	now := time.Now()
+	txs := make([]*transaction.Transaction, ORPHAN_TRANSACTION_POOL_SIZE+1)
+	for i := range txs {
+		txs[i] = transaction.NewTransaction("sender", "recipient", transaction.Amount(i+1), 0)
+	}
+	for i, tx := range txs[:ORPHAN_TRANSACTION_POOL_SIZE] {
+		p.add(tx, now.Add(time.Duration(i)))
+	}
+	if p.add(txs[0], now) {
+		t.Fatal("add of a held transaction = true")
+	}
+	p.add(txs[ORPHAN_TRANSACTION_POOL_SIZE], now.Add(ORPHAN_TRANSACTION_POOL_SIZE))
+	if n := p.len(); n != ORPHAN_TRANSACTION_POOL_SIZE {
+		t.Fatalf("a full pool holds %d transactions, want %d", n, ORPHAN_TRANSACTION_POOL_SIZE)
+	}
+	all := p.takeAll(now.Add(ORPHAN_TRANSACTION_POOL_SIZE))
+	if len(all) != ORPHAN_TRANSACTION_POOL_SIZE || all[0].transaction != txs[1] || p.len() != 0 {
+		t.Fatalf("takeAll = %d transactions, want %d without the evicted oldest, oldest first", len(all), ORPHAN_TRANSACTION_POOL_SIZE)
+	}
+	p.add(txs[0], now)
+	if all := p.takeAll(now.Add(ORPHAN_TRANSACTION_TTL)); len(all) != 0 {
+		t.Fatalf("takeAll returned %d expired transactions", len(all))
+	}
+}
+
+func TestRelayedTransactionsWaitForTheBlockThatFundsThem(t *testing.T) {
+	ahead, miner := newTestBlockchain(t)
+	fund(t, ahead, miner, transaction.COIN)
+	behind, _ := newTestBlockchain(t)
+	setNeighbors(behind, serveNeighbor(t, ahead))
+	if !behind.ResolveConflicts(context.Background()) {
+		t.Fatal("ResolveConflicts did not adopt the longer chain")
+	}
+	setNeighbors(behind)
+
+	// The block funding alice outruns alice's own transfer on its way to behind.
+	alice := fund(t, ahead, miner, transaction.COIN)
+	funding := ahead.LastBlock()
+	tx, err := alice.NewTransaction(miner.BlockchainAddress(), transaction.COIN/2, 0, ahead.NextNonce(alice.BlockchainAddress()), ahead.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := behind.CreateTransaction(tx); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("CreateTransaction from a client = %v, want %v", err, ErrInsufficientBalance)
+	}
+	if err := behind.ReceiveTransaction(tx); !errors.Is(err, ErrOrphanTransaction) {
+		t.Fatalf("ReceiveTransaction = %v, want %v", err, ErrOrphanTransaction)
+	}
+	if n := behind.OrphanTransactions(); n != 1 {
+		t.Fatalf("%d orphan transactions, want 1", n)
+	}
+
+	cb := NewCompactBlock(funding)
+	cb.Prefilled = funding.Transactions()
+	if resp, err := behind.ReceiveCompactBlock(context.Background(), cb); err != nil || resp.Status != COMPACT_BLOCK_ACCEPTED {
+		t.Fatalf("ReceiveCompactBlock = %+v, %v", resp, err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(behind.TransactionPool()) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the orphan transaction was not pooled after its funding block")
+		}
+	}
+	if pool := behind.TransactionPool(); pool[0].ID() != tx.ID() || behind.OrphanTransactions() != 0 {
+		t.Fatalf("pool %v with %d orphans left, want just the former orphan", pool, behind.OrphanTransactions())
+	}
+}
@@ -0,0 +1,74 @@
+package node
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	RATE_LIMIT_PER_SEC = 5
+	RATE_LIMIT_BURST   = 20
+
+	// RATE_LIMIT_MAX_CLIENTS bounds how many buckets are kept before idle ones are dropped.
+	RATE_LIMIT_MAX_CLIENTS = 10000
+)
+
+// tokenBucket holds up to burst tokens and regains rate tokens per second; each request takes one.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a per-client token bucket: a client may make burst requests at once and perSecond
+// requests per second after that. A nil *RateLimiter allows every request.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mux     sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// NewRateLimiter returns a limiter allowing perSecond requests per second with bursts of burst (at least one)
+// per client, or nil, which disables limiting, if perSecond is zero or less.
+func NewRateLimiter(perSecond float64, burst int) *RateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{rate: perSecond, burst: float64(max(burst, 1)), buckets: make(map[string]*tokenBucket)}
+}
+
+// Allow takes a token from client's bucket at now. When the bucket is empty it returns false and how long
+// until the next token.
+func (rl *RateLimiter) Allow(client string, now time.Time) (bool, time.Duration) {
+	if rl == nil {
+		return true, 0
+	}
+	rl.mux.Lock()
+	defer rl.mux.Unlock()
+	b, ok := rl.buckets[client]
+	if !ok {
+		if len(rl.buckets) >= RATE_LIMIT_MAX_CLIENTS {
+			rl.evictIdle(now)
+		}
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[client] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// evictIdle drops the buckets that have refilled completely, which behave exactly like new ones.
+func (rl *RateLimiter) evictIdle(now time.Time) {
+	for client, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, client)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package node
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefillsEachClientsBucket(t *testing.T) {
+	if ok, _ := (*RateLimiter)(nil).Allow("10.0.0.1", time.Now()); !ok {
+		t.Fatal("a nil limiter refused a request")
+	}
+	if NewRateLimiter(0, 5) != nil {
+		t.Fatal("a limiter without a rate is not nil")
+	}
+
+	rl := NewRateLimiter(2, 3)
+	now := time.Now()
+	for i := range 3 {
+		if ok, _ := rl.Allow("10.0.0.1", now); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	ok, retryAfter := rl.Allow("10.0.0.1", now)
+	if ok || retryAfter != 500*time.Millisecond {
+		t.Fatalf("request past the burst = %t, retry after %v; want false, 500ms", ok, retryAfter)
+	}
+	if ok, _ := rl.Allow("10.0.0.2", now); !ok {
+		t.Fatal("another client shares the first one's bucket")
+	}
+	if ok, _ := rl.Allow("10.0.0.1", now.Add(500*time.Millisecond)); !ok {
+		t.Fatal("no token regained after half a second at 2 per second")
+	}
+	if ok, _ := rl.Allow("10.0.0.1", now.Add(500*time.Millisecond)); ok {
+		t.Fatal("more than one token regained")
+	}
+	// An hour idle refills the bucket only up to the burst.
+	later := now.Add(time.Hour)
+	for i := range 4 {
+		if ok, _ := rl.Allow("10.0.0.1", later); ok != (i < 3) {
+			t.Fatalf("request %d after an idle hour allowed = %t", i+1, ok)
+		}
+	}
+}
+
+func TestRateLimitedEndpointsAnswer429WithRetryAfter(t *testing.T) {
+	ok := func(w http.ResponseWriter, req *http.Request) { w.WriteHeader(http.StatusOK) }
+	bcs := &BlockchainServer{limiter: NewRateLimiter(0.5, 1)}
+	handler := bcs.rateLimit("mine", ok, http.MethodGet)
+	request := func(method, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/mine", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	if rec := request(http.MethodPost, "10.0.0.1:40000"); rec.Code != http.StatusOK {
+		t.Fatalf("first POST: %d, want 200", rec.Code)
+	}
+	// Another connection from the same address draws on the same bucket.
+	rec := request(http.MethodPost, "10.0.0.1:40001")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Fatalf("second POST: %d, Retry-After %q; want 429, 2", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := request(http.MethodGet, "10.0.0.1:40002"); rec.Code != http.StatusOK {
+		t.Fatalf("exempt GET: %d, want 200", rec.Code)
+	}
+	if rec := request(http.MethodPost, "10.0.0.2:40000"); rec.Code != http.StatusOK {
+		t.Fatalf("POST from another address: %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,120 @@
+package node
+
+import (
+	"log"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// Reorg describes a chain replacement: the height of the first block the chains disagree on, the blocks
+// abandoned from there, and the transactions of those blocks that went back into the pool.
+type Reorg struct {
+	ForkHeight int
+	Abandoned  []*block.Block
+	Readded    []*transaction.Transaction
+}
+
+// forkHeight returns the height of the first block in which chains a and b differ, or the length of the
+// shorter one if it is a prefix of the other.
+func (bc *Blockchain) forkHeight(a, b []*block.Block) int {
+	n := min(len(a), len(b))
+	for height := range n {
+		if bc.BlockHash(a[height]) != bc.BlockHash(b[height]) {
+			return height
+		}
+	}
+	return n
+}
+
+// reorganize switches to chain, which consensus preferred. It rolls back the local blocks from the fork,
+// rebuilds the balance index from the new blocks, and puts the abandoned blocks' transactions back into the
+// pool, except coinbases, those the new chain already confirmed, and those it left unaffordable, such as
+// the loser of a double spend. Pooled transactions that became unaffordable are dropped likewise.
+// Callers must hold mux.
+func (bc *Blockchain) reorganize(chain []*block.Block) Reorg {
+	fork := bc.forkHeight(bc.chain, chain)
+	r := Reorg{ForkHeight: fork, Abandoned: bc.chain[fork:], Readded: make([]*transaction.Transaction, 0)}
+	bc.setChain(chain)
+
+	confirmed := make(map[[32]byte]bool)
+	for _, b := range chain[fork:] {
+		for _, t := range b.Transactions() {
+			confirmed[t.Hash()] = true
+		}
+	}
+	pooled := make(map[[32]byte]bool, len(bc.transactionPool))
+	for _, t := range bc.transactionPool {
+		pooled[t.Hash()] = true
+	}
+	// Abandoned transactions go first: they were accepted before anything still in the pool.
+	candidates := make([]*transaction.Transaction, 0, len(bc.transactionPool))
+	for _, b := range r.Abandoned {
+		for _, t := range b.Transactions() {
+			if t.SenderBlockchainAddress() != transaction.MINING_SENDER && !pooled[t.Hash()] {
+				candidates = append(candidates, t)
+			}
+		}
+	}
+	candidates = append(candidates, bc.transactionPool...)
+
+	spent := make(map[string]transaction.Amount)
+	tokens := newTokenSpends(bc.tokens)
+	assets := newAssetMoves(bc.assets)
+	nonces := newNonceSequence(bc.nonces)
+	pool := make([]*transaction.Transaction, 0, len(candidates))
+	for _, t := range candidates {
+		h := t.Hash()
+		if confirmed[h] {
+			continue
+		}
+		if err := t.CheckReplayProtected(bc.ChainID()); err != nil {
+			// An abandoned block may predate replay protection, which the pool requires.
+			log.Printf("action=reorganize, status=dropped, id=%s, err=%v", t.ID(), err)
+			continue
+		}
+		cost, err := t.Cost()
+		if err != nil {
+			log.Printf("action=reorganize, status=dropped, id=%s, err=%v", t.ID(), err)
+			continue
+		}
+		if bc.calculateTotalAmount(t.SenderBlockchainAddress())-spent[t.SenderBlockchainAddress()] < cost {
+			log.Printf("action=reorganize, status=dropped, id=%s, err=%v", t.ID(), ErrInsufficientBalance)
+			continue
+		}
+		if n := nonces.expected(t.SenderBlockchainAddress()); t.HasNonce() && t.Nonce() != n {
+			log.Printf("action=reorganize, status=dropped, id=%s, err=%v: %d, expected %d", t.ID(), transaction.ErrInvalidNonce, t.Nonce(), n)
+			continue
+		}
+		if t.Token() != nil {
+			if err := tokens.admit(t); err != nil {
+				log.Printf("action=reorganize, status=dropped, id=%s, err=%v", t.ID(), err)
+				continue
+			}
+		}
+		if t.Asset() != nil {
+			if err := assets.admit(t); err != nil {
+				log.Printf("action=reorganize, status=dropped, id=%s, err=%v", t.ID(), err)
+				continue
+			}
+		}
+		nonces.admit(t)
+		confirmed[h] = true
+		spent[t.SenderBlockchainAddress()] += cost
+		pool = append(pool, t)
+	}
+	bc.transactionPool = pool
+	for bc.maxPoolSize > 0 && len(bc.transactionPool) > bc.maxPoolSize {
+		for _, e := range bc.dropFromPool(bc.transactionPool[bc.evictionCandidate()]) {
+			log.Printf("action=reorganize, status=evicted, id=%s, fee=%s", e.ID(), e.Fee())
+		}
+	}
+	for _, t := range bc.transactionPool {
+		if !pooled[t.Hash()] {
+			r.Readded = append(r.Readded, t)
+			bc.events.Publish(Event{Type: EVENT_NEW_TRANSACTION, Data: TransactionInfo{TransactionID: t.ID(), Transaction: t}})
+		}
+	}
+	go bc.retryOrphanTransactions()
+	return r
+}
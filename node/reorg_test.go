@@ -0,0 +1,62 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+func TestReorgReturnsAbandonedTransactionsToThePool(t *testing.T) {
+	winner, miner := newTestBlockchain(t)
+	alice := fund(t, winner, miner, transaction.COIN)
+	loser, loserMiner := newTestBlockchain(t)
+	setNeighbors(loser, serveNeighbor(t, winner))
+	if !loser.ResolveConflicts(context.Background()) {
+		t.Fatal("ResolveConflicts did not adopt the funded chain")
+	}
+	bob, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both chains confirm shared at height 2, but only the loser confirms lost.
+	shared := send(t, winner, alice, bob, transaction.COIN/4, 0)
+	if err := loser.AddTransaction(shared); err != nil {
+		t.Fatal(err)
+	}
+	lost := send(t, loser, alice, bob, transaction.COIN/4, 0)
+	if !loser.Mining(context.Background()) {
+		t.Fatal("mining on the losing chain failed")
+	}
+	if loser.CalculateTotalAmount(loserMiner.BlockchainAddress()) == 0 {
+		t.Fatal("the losing chain's miner got no reward")
+	}
+	if !winner.Mining(context.Background()) {
+		t.Fatal("mining on the winning chain failed")
+	}
+	fund(t, winner, miner, transaction.COIN/2)
+
+	before := loser.Chain()
+	if !loser.ResolveConflicts(context.Background()) {
+		t.Fatal("ResolveConflicts did not switch to the longer chain")
+	}
+	if got, want := loser.BlockHash(loser.LastBlock()), winner.BlockHash(winner.LastBlock()); got != want {
+		t.Fatalf("tip after the reorg = %x, want %x", got, want)
+	}
+	if fork := loser.forkHeight(before, loser.Chain()); fork != 2 {
+		t.Fatalf("forkHeight = %d, want 2", fork)
+	}
+	pool := loser.TransactionPool()
+	if len(pool) != 1 || pool[0].ID() != lost.ID() {
+		t.Fatalf("pool after the reorg = %v, want only the abandoned transfer", pool)
+	}
+	if got, want := loser.CalculateTotalAmount(bob.BlockchainAddress()), transaction.COIN/4; got != want {
+		t.Fatalf("bob's balance after the reorg = %v, want %v from the winning chain only", got, want)
+	}
+	if got := loser.CalculateTotalAmount(loserMiner.BlockchainAddress()); got != 0 {
+		t.Fatalf("the abandoned block's miner still holds %v", got)
+	}
+	checkPool(t, loser)
+}
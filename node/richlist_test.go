@@ -0,0 +1,87 @@
+package node
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+func TestTopAddressesRankHoldersByBalance(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	alice := fund(t, bc, miner, transaction.COIN/2)
+	bob := fund(t, bc, miner, transaction.COIN/4)
+	carol := fund(t, bc, miner, transaction.COIN/4)
+
+	top, issued, holders := bc.TopAddresses(10)
+	if issued != 4*MINING_REWARD || holders != 4 || len(top) != 4 {
+		t.Fatalf("TopAddresses(10) = %d addresses of %d holders of %s, want 4 of 4 of %s", len(top), holders, issued, 4*MINING_REWARD)
+	}
+	// Bob and Carol hold the same, so the lower address goes first.
+	tied := []string{bob.BlockchainAddress(), carol.BlockchainAddress()}
+	if strings.Compare(tied[0], tied[1]) > 0 {
+		tied[0], tied[1] = tied[1], tied[0]
+	}
+	want := []string{miner.BlockchainAddress(), alice.BlockchainAddress(), tied[0], tied[1]}
+	var total transaction.Amount
+	for i, holder := range top {
+		if holder.Address != want[i] || holder.Amount != bc.CalculateTotalAmount(want[i]) {
+			t.Errorf("holder %d = %+v, want %s with %s", i, holder, want[i], bc.CalculateTotalAmount(want[i]))
+		}
+		if holder.Share != float64(holder.Amount)/float64(issued) {
+			t.Errorf("holder %d has a share of %v", i, holder.Share)
+		}
+		total += holder.Amount
+	}
+	if total != issued {
+		t.Fatalf("the holders hold %s of the %s issued", total, issued)
+	}
+
+	var page struct {
+		Addresses    []AddressBalance   `json:"addresses"`
+		IssuedSupply transaction.Amount `json:"issued_supply"`
+		Holders      int                `json:"holders"`
+	}
+	if rec := serve(t, bcs.TopAddresses, http.MethodGet, "/addresses/top?n=2", nil, &page); rec.Code != http.StatusOK {
+		t.Fatalf("GET /addresses/top?n=2 = %d", rec.Code)
+	}
+	if len(page.Addresses) != 2 || page.Addresses[1] != top[1] || page.Holders != 4 || page.IssuedSupply != issued {
+		t.Fatalf("GET /addresses/top?n=2 = %+v", page)
+	}
+	for _, query := range []string{"?n=0", "?n=-1", "?n=ten"} {
+		if rec := serve(t, bcs.TopAddresses, http.MethodGet, "/addresses/top"+query, nil, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET /addresses/top%s = %d, want 400", query, rec.Code)
+		}
+	}
+	if rec := serve(t, bcs.TopAddresses, http.MethodPost, "/addresses/top", nil, nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST /addresses/top = %d, want 405", rec.Code)
+	}
+}
+
+func TestTopAddressesListTheDefaultNumberUnlessAsked(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	for range TOP_ADDRESSES_DEFAULT_LIMIT + 1 {
+		w, err := wallet.NewWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		send(t, bc, miner, w, transaction.COIN/100, 0)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	var page struct {
+		Addresses []AddressBalance `json:"addresses"`
+		Holders   int              `json:"holders"`
+	}
+	for query, want := range map[string]int{"": TOP_ADDRESSES_DEFAULT_LIMIT, "?n=1000": TOP_ADDRESSES_DEFAULT_LIMIT + 2} {
+		if rec := serve(t, bcs.TopAddresses, http.MethodGet, "/addresses/top"+query, nil, &page); rec.Code != http.StatusOK || len(page.Addresses) != want || page.Holders != TOP_ADDRESSES_DEFAULT_LIMIT+2 {
+			t.Errorf("GET /addresses/top%s = %d with %d of %d holders, want %d", query, rec.Code, len(page.Addresses), page.Holders, want)
+		}
+	}
+}
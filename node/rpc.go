@@ -0,0 +1,359 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// JSON-RPC 2.0 error codes: the first five are defined by the specification, the rest follow bitcoind
+// so existing scripts can tell the failures apart.
+const (
+	RPC_PARSE_ERROR      = -32700
+	RPC_INVALID_REQUEST  = -32600
+	RPC_METHOD_NOT_FOUND = -32601
+	RPC_INVALID_PARAMS   = -32602
+	RPC_INTERNAL_ERROR   = -32603
+	RPC_UNAUTHORIZED     = -32001
+	RPC_LIMIT_EXCEEDED   = -32005
+
+	RPC_MISC_ERROR             = -1
+	RPC_INVALID_ADDRESS_OR_KEY = -5
+	RPC_INVALID_PARAMETER      = -8
+	RPC_VERIFY_REJECTED        = -26
+
+	RPC_MAX_BODY_SIZE = 1 << 20
+)
+
+// RPCError is the error member of a JSON-RPC response.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// RPCRequest is one JSON-RPC 2.0 call. A request without an id is a notification and gets no response.
+type RPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// RPCResponse carries either Result or Error for the request with the same ID.
+type RPCResponse struct {
+	JSONRPC string
+	Result  any
+	Error   *RPCError
+	ID      json.RawMessage
+}
+
+// MarshalJSON writes "result" on success and "error" on failure, never both, as the specification requires;
+// omitempty would also drop legitimate results such as 0.
+func (r *RPCResponse) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			Error   *RPCError       `json:"error"`
+			ID      json.RawMessage `json:"id"`
+		}{r.JSONRPC, r.Error, r.ID})
+	}
+	return json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Result  any             `json:"result"`
+		ID      json.RawMessage `json:"id"`
+	}{r.JSONRPC, r.Result, r.ID})
+}
+
+// rpcMethod describes a method: its parameter names in positional order (so named params can be mapped)
+// and the handler receiving them positionally.
+type rpcMethod struct {
+	params []string
+	call   func(bc *Blockchain, params []json.RawMessage) (any, *RPCError)
+}
+
+// rpcMethods are named after their bitcoind counterparts.
+var rpcMethods = map[string]rpcMethod{
+	"getblockcount": {nil, func(bc *Blockchain, _ []json.RawMessage) (any, *RPCError) {
+		return bc.Status().Height, nil
+	}},
+	"getbestblockhash": {nil, func(bc *Blockchain, _ []json.RawMessage) (any, *RPCError) {
+		return bc.Status().TipHash, nil
+	}},
+	"getblockhash": {[]string{"height"}, func(bc *Blockchain, params []json.RawMessage) (any, *RPCError) {
+		var height int
+		if err := rpcParam(params, 0, &height); err != nil {
+			return nil, err
+		}
+		b, ok := bc.BlockByHeight(height)
+		if !ok {
+			return nil, &RPCError{RPC_INVALID_PARAMETER, "block height out of range"}
+		}
+		return fmt.Sprintf("%x", bc.BlockHash(b)), nil
+	}},
+	"getblock": {[]string{"blockhash"}, func(bc *Blockchain, params []json.RawMessage) (any, *RPCError) {
+		hash, err := rpcHashParam(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		b, height, ok := bc.BlockByHash(hash)
+		if !ok {
+			return nil, &RPCError{RPC_INVALID_ADDRESS_OR_KEY, "block not found"}
+		}
+		return BlockInfo{Height: height, Hash: fmt.Sprintf("%x", hash), Block: b}, nil
+	}},
+	"getrawmempool": {nil, func(bc *Blockchain, _ []json.RawMessage) (any, *RPCError) {
+		ids := make([]string, 0)
+		for _, t := range bc.TransactionPool() {
+			ids = append(ids, t.ID())
+		}
+		return ids, nil
+	}},
+	"getrawtransaction": {[]string{"txid"}, func(bc *Blockchain, params []json.RawMessage) (any, *RPCError) {
+		hash, err := rpcHashParam(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		at, ok := bc.TransactionByID(hash)
+		if !ok {
+			return nil, &RPCError{RPC_INVALID_ADDRESS_OR_KEY, "no such transaction"}
+		}
+		return at, nil
+	}},
+	"sendrawtransaction": {[]string{"hexstring"}, func(bc *Blockchain, params []json.RawMessage) (any, *RPCError) {
+		t, err := rpcTransactionParam(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err := bc.CreateTransaction(t); err != nil && !errors.Is(err, ErrDuplicateTransaction) {
+			return nil, &RPCError{RPC_VERIFY_REJECTED, err.Error()}
+		}
+		return t.ID(), nil
+	}},
+	"getbalance": {[]string{"address"}, func(bc *Blockchain, params []json.RawMessage) (any, *RPCError) {
+		var address string
+		if err := rpcParam(params, 0, &address); err != nil {
+			return nil, err
+		}
+		if !transaction.ValidateAddress(address) {
+			return nil, &RPCError{RPC_INVALID_ADDRESS_OR_KEY, "invalid address"}
+		}
+		// A JSON number with exactly AMOUNT_DECIMALS places, like bitcoind's amounts.
+		return json.Number(bc.CalculateTotalAmount(address).String()), nil
+	}},
+	"getblocktemplate": {[]string{"address"}, func(bc *Blockchain, params []json.RawMessage) (any, *RPCError) {
+		var address string
+		if len(params) > 0 {
+			if err := rpcParam(params, 0, &address); err != nil {
+				return nil, err
+			}
+			if !transaction.ValidateAddress(address) {
+				return nil, &RPCError{RPC_INVALID_ADDRESS_OR_KEY, "invalid address"}
+			}
+		}
+		bt, err := bc.NewBlockTemplate(address)
+		if err != nil {
+			return nil, &RPCError{RPC_MISC_ERROR, err.Error()}
+		}
+		return bt, nil
+	}},
+	"submitblock": {[]string{"block"}, func(bc *Blockchain, params []json.RawMessage) (any, *RPCError) {
+		b, err := rpcBlockParam(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err := bc.SubmitBlock(context.Background(), b); err != nil {
+			return nil, &RPCError{RPC_VERIFY_REJECTED, err.Error()}
+		}
+		return fmt.Sprintf("%x", bc.BlockHash(b)), nil
+	}},
+}
+
+// rpcMutatingMethods change node state, so HandleRPC asks its authorize function before running them.
+var rpcMutatingMethods = map[string]bool{"sendrawtransaction": true, "submitblock": true}
+
+// rpcParam decodes positional parameter i into v.
+func rpcParam(params []json.RawMessage, i int, v any) *RPCError {
+	if i >= len(params) {
+		return &RPCError{RPC_INVALID_PARAMS, fmt.Sprintf("missing parameter %d", i)}
+	}
+	if err := json.Unmarshal(params[i], v); err != nil {
+		return &RPCError{RPC_INVALID_PARAMS, fmt.Sprintf("parameter %d: %v", i, err)}
+	}
+	return nil
+}
+
+// rpcHashParam decodes positional parameter i as a hex block hash or transaction ID.
+func rpcHashParam(params []json.RawMessage, i int) ([32]byte, *RPCError) {
+	var s string
+	if err := rpcParam(params, i, &s); err != nil {
+		return [32]byte{}, err
+	}
+	hash, err := transaction.HashFromString(s)
+	if err != nil {
+		return [32]byte{}, &RPCError{RPC_INVALID_PARAMETER, err.Error()}
+	}
+	return hash, nil
+}
+
+// rpcTransactionParam decodes a signed transaction given either as the POST /transactions JSON object or,
+// as a "raw" transaction, as that JSON hex-encoded in a string.
+func rpcTransactionParam(params []json.RawMessage, i int) (*transaction.Transaction, *RPCError) {
+	if i >= len(params) {
+		return nil, &RPCError{RPC_INVALID_PARAMS, fmt.Sprintf("missing parameter %d", i)}
+	}
+	raw := []byte(params[i])
+	var hexString string
+	if json.Unmarshal(raw, &hexString) == nil {
+		var err error
+		if raw, err = hex.DecodeString(hexString); err != nil {
+			return nil, &RPCError{RPC_INVALID_PARAMETER, "transaction is not valid hex: " + err.Error()}
+		}
+	}
+	var tr transaction.TransactionRequest
+	if err := json.Unmarshal(raw, &tr); err != nil {
+		return nil, &RPCError{RPC_INVALID_PARAMETER, "transaction decode failed: " + err.Error()}
+	}
+	if !tr.Validate() {
+		return nil, &RPCError{RPC_INVALID_PARAMETER, "transaction is missing field(s)"}
+	}
+	t, err := tr.Transaction()
+	if err != nil {
+		return nil, &RPCError{RPC_INVALID_PARAMETER, err.Error()}
+	}
+	return t, nil
+}
+
+// rpcBlockParam decodes a block given either as the JSON of a BlockTemplate's block or hex-encoded like a
+// raw transaction.
+func rpcBlockParam(params []json.RawMessage, i int) (*block.Block, *RPCError) {
+	if i >= len(params) {
+		return nil, &RPCError{RPC_INVALID_PARAMS, fmt.Sprintf("missing parameter %d", i)}
+	}
+	raw := []byte(params[i])
+	var hexString string
+	if json.Unmarshal(raw, &hexString) == nil {
+		var err error
+		if raw, err = hex.DecodeString(hexString); err != nil {
+			return nil, &RPCError{RPC_INVALID_PARAMETER, "block is not valid hex: " + err.Error()}
+		}
+	}
+	b := new(block.Block)
+	if err := json.Unmarshal(raw, b); err != nil {
+		return nil, &RPCError{RPC_INVALID_PARAMETER, "block decode failed: " + err.Error()}
+	}
+	return b, nil
+}
+
+// HandleRPC runs a JSON-RPC 2.0 request body, which is a single call or a batch, and returns the encoded
+// response, or nil when only notifications were sent. Each call of a mutating method first asks authorize,
+// which returns the error to fail it with, or nil to run it; a nil authorize allows every call.
+func HandleRPC(bc *Blockchain, body []byte, authorize func() *RPCError) []byte {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return rpcEncode(rpcErrorResponse(nil, RPC_PARSE_ERROR, err.Error()))
+		}
+		if len(batch) == 0 {
+			return rpcEncode(rpcErrorResponse(nil, RPC_INVALID_REQUEST, "empty batch"))
+		}
+		responses := make([]*RPCResponse, 0, len(batch))
+		for _, call := range batch {
+			if resp := handleRPCCall(bc, call, authorize); resp != nil {
+				responses = append(responses, resp)
+			}
+		}
+		if len(responses) == 0 {
+			return nil
+		}
+		return rpcEncode(responses)
+	}
+	if !json.Valid(body) {
+		return rpcEncode(rpcErrorResponse(nil, RPC_PARSE_ERROR, "invalid JSON"))
+	}
+	if resp := handleRPCCall(bc, body, authorize); resp != nil {
+		return rpcEncode(resp)
+	}
+	return nil
+}
+
+// handleRPCCall runs one call; it returns nil for a notification.
+func handleRPCCall(bc *Blockchain, body json.RawMessage, authorize func() *RPCError) *RPCResponse {
+	var req RPCRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcErrorResponse(req.ID, RPC_INVALID_REQUEST, "invalid JSON-RPC 2.0 request")
+	}
+	method, ok := rpcMethods[req.Method]
+	var result any
+	var rpcErr *RPCError
+	if !ok {
+		rpcErr = &RPCError{RPC_METHOD_NOT_FOUND, "method not found: " + req.Method}
+	} else if rpcMutatingMethods[req.Method] && authorize != nil {
+		rpcErr = authorize()
+	}
+	if rpcErr == nil {
+		var params []json.RawMessage
+		if params, rpcErr = rpcParams(method, req.Params); rpcErr == nil {
+			result, rpcErr = method.call(bc, params)
+		}
+	}
+	if req.ID == nil {
+		return nil
+	}
+	if rpcErr != nil {
+		return &RPCResponse{JSONRPC: "2.0", Error: rpcErr, ID: req.ID}
+	}
+	return &RPCResponse{JSONRPC: "2.0", Result: result, ID: req.ID}
+}
+
+// rpcParams turns by-position (array) or by-name (object) params into positional order.
+func rpcParams(method rpcMethod, raw json.RawMessage) ([]json.RawMessage, *RPCError) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var params []json.RawMessage
+	if raw[0] == '[' {
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, &RPCError{RPC_INVALID_PARAMS, err.Error()}
+		}
+		return params, nil
+	}
+	var named map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &named); err != nil {
+		return nil, &RPCError{RPC_INVALID_PARAMS, "params must be an array or an object"}
+	}
+	for _, name := range method.params {
+		v, ok := named[name]
+		if !ok {
+			break
+		}
+		params = append(params, v)
+	}
+	return params, nil
+}
+
+func rpcErrorResponse(id json.RawMessage, code int, message string) *RPCResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &RPCResponse{JSONRPC: "2.0", Error: &RPCError{code, message}, ID: id}
+}
+
+func rpcEncode(v any) []byte {
+	m, err := json.Marshal(v)
+	if err != nil {
+		m, _ = json.Marshal(rpcErrorResponse(nil, RPC_INTERNAL_ERROR, err.Error()))
+	}
+	return m
+}
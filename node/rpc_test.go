@@ -0,0 +1,94 @@
+package node
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+func TestRPCAnswersCallsAndBatches(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	fund(t, bc, miner, transaction.COIN/2)
+	tip := fmt.Sprintf("%x", bc.BlockHash(bc.LastBlock()))
+
+	for body, want := range map[string]string{
+		`{"jsonrpc": "2.0", "method": "getblockcount", "id": 1}`:                                               `{"jsonrpc":"2.0","result":1,"id":1}`,
+		`{"jsonrpc": "2.0", "method": "getblockhash", "params": {"height": 1}, "id": "a"}`:                     `{"jsonrpc":"2.0","result":"` + tip + `","id":"a"}`,
+		`{"jsonrpc": "2.0", "method": "getbestblockhash", "params": [], "id": 2}`:                              `{"jsonrpc":"2.0","result":"` + tip + `","id":2}`,
+		`{"jsonrpc": "2.0", "method": "getbalance", "params": ["` + miner.BlockchainAddress() + `"], "id": 3}`: `{"jsonrpc":"2.0","result":1.50000000,"id":3}`,
+		`{"jsonrpc": "2.0", "method": "getblockhash", "params": [5], "id": 4}`:                                 `{"jsonrpc":"2.0","error":{"code":-8,"message":"block height out of range"},"id":4}`,
+		`{"jsonrpc": "2.0", "method": "getblockhash", "id": 5}`:                                                `{"jsonrpc":"2.0","error":{"code":-32602,"message":"missing parameter 0"},"id":5}`,
+		`{"jsonrpc": "2.0", "method": "stop", "id": 6}`:                                                        `{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found: stop"},"id":6}`,
+		`{"method": "getblockcount", "id": 7}`:                                                                 `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid JSON-RPC 2.0 request"},"id":7}`,
+		`{"jsonrpc": "2.0", "method": `:                                                                        `{"jsonrpc":"2.0","error":{"code":-32700,"message":"invalid JSON"},"id":null}`,
+		`[]`:                                                                                                   `{"jsonrpc":"2.0","error":{"code":-32600,"message":"empty batch"},"id":null}`,
+		`[{"jsonrpc": "2.0", "method": "getblockcount", "id": 1}, {"jsonrpc": "2.0", "method": "getblockcount"}, {"jsonrpc": "2.0", "method": "nope", "id": 2}]`: `[{"jsonrpc":"2.0","result":1,"id":1},{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found: nope"},"id":2}]`,
+	} {
+		if got := string(HandleRPC(bc, []byte(body), nil)); got != want {
+			t.Errorf("HandleRPC(%s) =\n%s\nwant\n%s", body, got, want)
+		}
+	}
+	if got := HandleRPC(bc, []byte(`[{"jsonrpc": "2.0", "method": "getblockcount"}]`), nil); got != nil {
+		t.Fatalf("a batch of notifications got %s", got)
+	}
+	if rec := serve(t, bcs.RPC, http.MethodPost, "/rpc", []byte(`{"jsonrpc": "2.0", "method": "getblockcount"}`), nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("POST /rpc with a notification: %d, want 204", rec.Code)
+	}
+	if rec := serve(t, bcs.RPC, http.MethodGet, "/rpc", nil, nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET /rpc: %d, want 405", rec.Code)
+	}
+}
+
+func TestRPCSubmitsRawTransactionsWhenAuthorized(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	recipient, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := miner.NewTransaction(recipient.BlockchainAddress(), transaction.COIN/4, 0, bc.NextNonce(miner.BlockchainAddress()), bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := tx.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"jsonrpc": "2.0", "method": "sendrawtransaction", "params": ["` + hex.EncodeToString(m) + `"], "id": 1}`)
+
+	deny := func() *RPCError { return &RPCError{RPC_UNAUTHORIZED, "missing credentials"} }
+	if got, want := string(HandleRPC(bc, body, deny)), `{"jsonrpc":"2.0","error":{"code":-32001,"message":"missing credentials"},"id":1}`; got != want {
+		t.Fatalf("unauthorized sendrawtransaction = %s, want %s", got, want)
+	}
+	if len(bc.TransactionPool()) != 0 {
+		t.Fatal("an unauthorized call pooled its transaction")
+	}
+	// Reads stay open to everyone.
+	if got := string(HandleRPC(bc, []byte(`{"jsonrpc": "2.0", "method": "getrawmempool", "id": 1}`), deny)); got != `{"jsonrpc":"2.0","result":[],"id":1}` {
+		t.Fatalf("getrawmempool = %s", got)
+	}
+
+	if got, want := string(HandleRPC(bc, body, nil)), `{"jsonrpc":"2.0","result":"`+tx.ID()+`","id":1}`; got != want {
+		t.Fatalf("sendrawtransaction = %s, want %s", got, want)
+	}
+	if got, want := string(HandleRPC(bc, []byte(`{"jsonrpc": "2.0", "method": "getrawmempool", "id": 1}`), nil)), `{"jsonrpc":"2.0","result":["`+tx.ID()+`"],"id":1}`; got != want {
+		t.Fatalf("getrawmempool = %s, want %s", got, want)
+	}
+	overdraft, err := recipient.NewTransaction(miner.BlockchainAddress(), transaction.COIN, 0, 0, bc.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = overdraft.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected := string(HandleRPC(bc, []byte(`{"jsonrpc": "2.0", "method": "sendrawtransaction", "params": [`+string(m)+`], "id": 2}`), nil))
+	if want := `{"jsonrpc":"2.0","error":{"code":-26,"message":"` + ErrInsufficientBalance.Error() + `"},"id":2}`; rejected != want {
+		t.Fatalf("sendrawtransaction of an overdraft = %s, want %s", rejected, want)
+	}
+}
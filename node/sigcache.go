@@ -0,0 +1,92 @@
+package node
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// SIGNATURE_CACHE_SIZE is how many verified transactions the node remembers by default.
+const SIGNATURE_CACHE_SIZE = 50000
+
+// SignatureCacheStats reports how a SignatureCache is doing: its entries and capacity, and how many
+// verifications it answered (hits) or passed on to Transaction.Verify (misses).
+type SignatureCacheStats struct {
+	Entries  int    `json:"entries"`
+	Capacity int    `json:"capacity"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+}
+
+// SignatureCache remembers the IDs of transactions whose signatures verified, so a transaction is verified
+// once, on arrival, and not again when it is mined, arrives in a block, or is validated again after a
+// reorganization. The ID hashes the whole transaction, signatures and scripts included, so an entry cannot
+// vouch for a transaction signed differently, such as a copy with its signature's S flipped; such a copy
+// verifies on its own and is then rejected as non-canonical. Only valid results are kept, so invalid transactions cannot
+// flush it, and the least recently used entry makes room for a new one.
+type SignatureCache struct {
+	mux      sync.Mutex
+	capacity int
+	entries  map[[32]byte]*list.Element
+	order    *list.List // of [32]byte IDs, most recently used first
+	hits     uint64
+	misses   uint64
+}
+
+// NewSignatureCache returns a cache of capacity entries; zero or less caches nothing.
+func NewSignatureCache(capacity int) *SignatureCache {
+	return &SignatureCache{capacity: capacity, entries: make(map[[32]byte]*list.Element), order: list.New()}
+}
+
+// Verify returns t.Verify(), or nil at once if t verified before. A nil cache always verifies.
+func (c *SignatureCache) Verify(t *transaction.Transaction) error {
+	if c == nil {
+		return t.Verify()
+	}
+	h := t.Hash()
+	c.mux.Lock()
+	if e, ok := c.entries[h]; ok {
+		c.order.MoveToFront(e)
+		c.hits++
+		c.mux.Unlock()
+		return nil
+	}
+	c.misses++
+	c.mux.Unlock()
+	// Verification runs unlocked, so transactions of a block can be verified in parallel.
+	if err := t.Verify(); err != nil {
+		return err
+	}
+	c.add(h)
+	return nil
+}
+
+// add remembers h as verified, evicting the least recently used entries past the capacity.
+func (c *SignatureCache) add(h [32]byte) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.capacity <= 0 {
+		return
+	}
+	if e, ok := c.entries[h]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[h] = c.order.PushFront(h)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.([32]byte))
+	}
+}
+
+// Stats returns the cache's current statistics, all zero for a nil cache.
+func (c *SignatureCache) Stats() SignatureCacheStats {
+	if c == nil {
+		return SignatureCacheStats{}
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return SignatureCacheStats{Entries: len(c.entries), Capacity: c.capacity, Hits: c.hits, Misses: c.misses}
+}
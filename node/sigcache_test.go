@@ -0,0 +1,74 @@
+package node
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+func TestSignatureCacheKeepsTheRecentlyVerified(t *testing.T) {
+	_, miner := newTestBlockchain(t)
+	var txs []*transaction.Transaction
+	for nonce := range uint64(3) {
+		tx, err := miner.NewTransaction(unseen(t), transaction.COIN, 0, nonce, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+	}
+	a, b, c := txs[0], txs[1], txs[2]
+	cache := NewSignatureCache(2)
+	// a is used more recently than b, so c takes b's place.
+	for _, tx := range []*transaction.Transaction{a, a, b, a, c, b} {
+		if err := cache.Verify(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := cache.Stats(); s != (SignatureCacheStats{Entries: 2, Capacity: 2, Hits: 2, Misses: 4}) {
+		t.Fatalf("Stats = %+v, want 2 hits, 4 misses, and the cache full", s)
+	}
+
+	// Invalid signatures are verified every time and never take an entry.
+	tampered, err := miner.NewTransaction(unseen(t), transaction.COIN, 0, 3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered.SetValue(2 * transaction.COIN)
+	for range 2 {
+		if err := cache.Verify(tampered); !errors.Is(err, transaction.ErrInvalidSignature) {
+			t.Fatalf("Verify of a tampered transaction = %v, want %v", err, transaction.ErrInvalidSignature)
+		}
+	}
+	if s := cache.Stats(); s.Entries != 2 || s.Misses != 6 {
+		t.Fatalf("Stats = %+v after two invalid transactions, want 2 entries and 6 misses", s)
+	}
+
+	for _, c := range []*SignatureCache{nil, NewSignatureCache(0)} {
+		if err := c.Verify(a); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Verify(tampered); !errors.Is(err, transaction.ErrInvalidSignature) {
+			t.Fatalf("Verify of a tampered transaction without a cache = %v, want %v", err, transaction.ErrInvalidSignature)
+		}
+		if s := c.Stats(); s.Entries != 0 || s.Hits != 0 {
+			t.Fatalf("Stats = %+v, want nothing cached", s)
+		}
+	}
+}
+
+func TestBlocksDoNotVerifyPooledTransactionsAgain(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	tx := send(t, bc, miner, miner, transaction.COIN/2, 0)
+	before := bc.signatures.Stats()
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	// A block relayed with the pooled transaction in it.
+	b := sealBlock(t, bc, []*transaction.Transaction{tx, transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)})
+	if err := bc.checkBlock(bc.chain, b, bc.tipState(), bc.rules()); err != nil {
+		t.Fatal(err)
+	}
+	if s := bc.signatures.Stats(); s.Hits != before.Hits+1 || s.Misses != before.Misses {
+		t.Fatalf("Stats after checking the block = %+v, before %+v; want the pooled transaction to hit", s, before)
+	}
+}
@@ -0,0 +1,152 @@
+package node
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The standard library does not ship snappy, so this file carries its block format, the one
+// github.com/golang/snappy's Encode and Decode use, for compressing stored blocks.
+
+const (
+	// snappyMaxOffset is how far back a copy may reach; matches are looked for within it.
+	snappyMaxOffset = 1<<16 - 1
+	// snappyTableBits sizes the encoder's hash table of recent 4-byte sequences.
+	snappyTableBits = 14
+)
+
+var ErrSnappyCorrupt = errors.New("snappy: corrupt input")
+
+// SnappyEncode compresses src: its length as a uvarint, then literals and copies of earlier bytes. Snappy
+// trades ratio for speed, finding matches with one hash table lookup per position.
+func SnappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)/2+16), uint64(len(src)))
+	var table [1 << snappyTableBits]int32 // position+1 of the last sequence with each hash
+	literal := 0
+	for i := 0; i+4 <= len(src); {
+		seq := binary.LittleEndian.Uint32(src[i:])
+		h := (seq * 0x1e35a7bd) >> (32 - snappyTableBits)
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if candidate < 0 || i-candidate > snappyMaxOffset || binary.LittleEndian.Uint32(src[candidate:]) != seq {
+			i++
+			continue
+		}
+		n := 4
+		for i+n < len(src) && src[candidate+n] == src[i+n] {
+			n++
+		}
+		dst = snappyLiteral(dst, src[literal:i])
+		dst = snappyCopy(dst, i-candidate, n)
+		i += n
+		literal = i
+	}
+	return snappyLiteral(dst, src[literal:])
+}
+
+// snappyLiteral appends the element that copies lit as is.
+func snappyLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	n := uint32(len(lit) - 1)
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2)
+	case n < 1<<8:
+		dst = append(dst, 60<<2, byte(n))
+	case n < 1<<16:
+		dst = binary.LittleEndian.AppendUint16(append(dst, 61<<2), uint16(n))
+	case n < 1<<24:
+		dst = append(dst, 62<<2, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = binary.LittleEndian.AppendUint32(append(dst, 63<<2), n)
+	}
+	return append(dst, lit...)
+}
+
+// snappyCopy appends the elements that repeat length bytes from offset bytes back, at most 64 per element.
+func snappyCopy(dst []byte, offset, length int) []byte {
+	for length >= 68 {
+		dst = binary.LittleEndian.AppendUint16(append(dst, 63<<2|2), uint16(offset))
+		length -= 64
+	}
+	if length > 64 {
+		// Leave at least 4 for the last element, the shortest a 1-byte-offset copy can be.
+		dst = binary.LittleEndian.AppendUint16(append(dst, 59<<2|2), uint16(offset))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return binary.LittleEndian.AppendUint16(append(dst, byte(length-1)<<2|2), uint16(offset))
+	}
+	return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|1, byte(offset))
+}
+
+// SnappyDecode decompresses src, which SnappyEncode or any snappy block encoder produced.
+func SnappyDecode(src []byte) ([]byte, error) {
+	length, k := binary.Uvarint(src)
+	// No element expands more than 64 bytes from 2, so longer claims are corrupt, not just big.
+	if k <= 0 || length > 32*uint64(len(src)) {
+		return nil, ErrSnappyCorrupt
+	}
+	dst := make([]byte, 0, length)
+	for s := k; s < len(src); {
+		tag := src[s]
+		s++
+		var offset, n int
+		switch tag & 3 {
+		case 0:
+			n = int(tag >> 2)
+			if n >= 60 {
+				size := n - 59
+				if len(src)-s < size {
+					return nil, ErrSnappyCorrupt
+				}
+				n = 0
+				for i := range size {
+					n |= int(src[s+i]) << (8 * i)
+				}
+				s += size
+			}
+			n++
+			if n > len(src)-s {
+				return nil, ErrSnappyCorrupt
+			}
+			dst = append(dst, src[s:s+n]...)
+			s += n
+			continue
+		case 1:
+			if len(src)-s < 1 {
+				return nil, ErrSnappyCorrupt
+			}
+			n = 4 + int(tag>>2&7)
+			offset = int(tag>>5)<<8 | int(src[s])
+			s++
+		case 2:
+			if len(src)-s < 2 {
+				return nil, ErrSnappyCorrupt
+			}
+			n = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s:]))
+			s += 2
+		case 3:
+			if len(src)-s < 4 {
+				return nil, ErrSnappyCorrupt
+			}
+			n = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s:]))
+			s += 4
+		}
+		if offset <= 0 || offset > len(dst) {
+			return nil, ErrSnappyCorrupt
+		}
+		// The copy may overlap what it appends, repeating a short run, so it goes byte by byte.
+		for i := len(dst) - offset; n > 0; i, n = i+1, n-1 {
+			dst = append(dst, dst[i])
+		}
+	}
+	if uint64(len(dst)) != length {
+		return nil, ErrSnappyCorrupt
+	}
+	return dst, nil
+}
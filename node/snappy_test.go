@@ -0,0 +1,137 @@
+package node
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+func TestSnappyRoundTrips(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(r.Uint32())
+		}
+		return b
+	}
+	far := random(4096)
+	inputs := map[string][]byte{
+		"empty":               nil,
+		"one byte":            {'a'},
+		"overlapping copy":    bytes.Repeat([]byte("ab"), 1000),
+		"long literal":        random(70_000),
+		"long match":          bytes.Repeat(random(100), 50),
+		"far match":           append(append(append([]byte(nil), far...), random(3000)...), far...),
+		"three byte sequence": []byte("abcabcabcabc"),
+	}
+	for name, src := range inputs {
+		c := SnappyEncode(src)
+		got, err := SnappyDecode(c)
+		if err != nil || !bytes.Equal(got, src) {
+			t.Errorf("%s: SnappyDecode(SnappyEncode) = %d bytes, %v; want the %d bytes back", name, len(got), err, len(src))
+		}
+		if name == "overlapping copy" && len(c) > len(src)/10 {
+			t.Errorf("%s: %d bytes compressed to %d", name, len(src), len(c))
+		}
+	}
+
+	// Elements other encoders emit: 20 'a's as a literal and a copy with a 2-byte, then a 4-byte offset.
+	for _, c := range [][]byte{{20, 0, 'a', 18<<2 | 2, 1, 0}, {20, 0, 'a', 18<<2 | 3, 1, 0, 0, 0}} {
+		if got, err := SnappyDecode(c); err != nil || string(got) != "aaaaaaaaaaaaaaaaaaaa" {
+			t.Errorf("SnappyDecode(%x) = %q, %v", c, got, err)
+		}
+	}
+}
+
+func TestSnappyRejectsCorruptInput(t *testing.T) {
+	tests := []struct {
+		name string
+		src  []byte
+	}{
+		{"empty", nil},
+		{"length beyond any expansion", []byte{0xff, 0xff, 0x03, 0}},
+		{"literal past the end", []byte{5, 4 << 2, 'a'}},
+		{"copy before the start", []byte{5, 0, 'a', 1, 2}},
+		{"copy of offset 0", []byte{5, 0, 'a', 1, 0}},
+		{"truncated copy", []byte{5, 0, 'a', 2}},
+		{"short of its length", []byte{5, 2 << 2, 'a', 'b', 'c'}},
+	}
+	for _, tt := range tests {
+		if _, err := SnappyDecode(tt.src); !errors.Is(err, ErrSnappyCorrupt) {
+			t.Errorf("%s: SnappyDecode = %v, want %v", tt.name, err, ErrSnappyCorrupt)
+		}
+	}
+}
+
+func TestFileBlockStoresMigrateBetweenCompressions(t *testing.T) {
+	if _, err := NewFileBlockStore(t.TempDir(), "zstd", nil); !errors.Is(err, ErrUnknownStorageCompression) {
+		t.Fatalf("NewFileBlockStore with zstd = %v, want %v", err, ErrUnknownStorageCompression)
+	}
+	bc, miner := newTestBlockchain(t)
+	for range 3 {
+		fund(t, bc, miner, transaction.COIN/8)
+	}
+	chain := bc.Chain()
+
+	dir := t.TempDir()
+	plain, err := NewFileBlockStore(dir, STORAGE_COMPRESSION_NONE, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.ReplaceChain(chain); err != nil {
+		t.Fatal(err)
+	}
+	plainInfo, err := os.Stat(plain.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A snappy store finds the plain block file, rewrites it compressed, and removes it.
+	compressed, err := NewFileBlockStore(dir, STORAGE_COMPRESSION_SNAPPY, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []*block.Block
+	if err := compressed.ForEach(func(_ int, b *block.Block) error { got = append(got, b); return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(chain) || got[len(got)-1].Hash() != chain[len(chain)-1].Hash() {
+		t.Fatalf("read %d blocks after migrating, want the %d stored", len(got), len(chain))
+	}
+	if _, err := os.Stat(filepath.Join(dir, BLOCKS_FILE_NAME)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("the plain block file is left after migrating: %v", err)
+	}
+	info, err := os.Stat(compressed.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() >= plainInfo.Size() {
+		t.Fatalf("the compressed file takes %d bytes, the plain one %d", info.Size(), plainInfo.Size())
+	}
+	if b, err := compressed.BlockByHeight(2); err != nil || b.Hash() != chain[2].Hash() {
+		t.Fatalf("BlockByHeight(2) = %v, want the stored block", err)
+	}
+
+	// A record cut short fails the read rather than losing the blocks before it silently.
+	m, err := os.ReadFile(compressed.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(compressed.Path(), m[:len(m)-1], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewFileBlockStore(dir, STORAGE_COMPRESSION_SNAPPY, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.ForEach(func(int, *block.Block) error { return nil }); err == nil {
+		t.Fatal("ForEach of a truncated block file succeeded")
+	}
+}
@@ -0,0 +1,251 @@
+package node
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// FAST_SYNC_PAGE_SIZE is how many blocks after the snapshot fast sync requests per GET /blocks.
+const FAST_SYNC_PAGE_SIZE = 100
+
+var (
+	ErrSnapshotMismatch = errors.New("snapshot does not match the trusted checkpoint")
+	ErrNotFreshNode     = errors.New("fast sync needs a node with only its genesis block")
+)
+
+// Snapshot is the balance state right after the block at Height, so a new node can start from it instead of
+// replaying every block since genesis. Headers holds every block header up to Height, which the node checks
+// and keeps as pruned blocks so the chain still links back to genesis.
+type Snapshot struct {
+	Height    int                           `json:"height"`
+	BlockHash string                        `json:"block_hash"`
+	Issued    transaction.Amount            `json:"issued"`
+	Balances  map[string]transaction.Amount `json:"balances"`
+	Contracts map[string]*Contract          `json:"contracts,omitempty"`
+	Tokens    map[string]*Token             `json:"tokens,omitempty"`
+	Assets    map[string]*Asset             `json:"assets,omitempty"`
+	Nonces    map[string]uint64             `json:"nonces,omitempty"`
+	Headers   []*block.Block                `json:"headers,omitempty"`
+}
+
+// Hash commits to the state: the height, block hash, issued supply, balances, contracts, tokens, assets, and nonces, but not the headers, which
+// are checked against BlockHash instead. Nodes that agree on the chain compute the same hash.
+func (s *Snapshot) Hash() [32]byte {
+	// json.Marshal sorts map keys, so the encoding is canonical.
+	m, _ := json.Marshal(struct {
+		Height    int                           `json:"height"`
+		BlockHash string                        `json:"block_hash"`
+		Issued    transaction.Amount            `json:"issued"`
+		Balances  map[string]transaction.Amount `json:"balances"`
+		Contracts map[string]*Contract          `json:"contracts,omitempty"`
+		Tokens    map[string]*Token             `json:"tokens,omitempty"`
+		Assets    map[string]*Asset             `json:"assets,omitempty"`
+		Nonces    map[string]uint64             `json:"nonces,omitempty"`
+	}{s.Height, s.BlockHash, s.Issued, s.Balances, s.Contracts, s.Tokens, s.Assets, s.Nonces})
+	return sha256.Sum256(m)
+}
+
+// checkPruned returns why chain's pruned headers do not match snapshot s: they must run from genesis up to
+// s.Height at most, and if they reach it, the header there must be the snapshot's block. A nil s allows
+// no pruned headers.
+func (s *Snapshot) checkPruned(chain []*block.Block, blockHash func(*block.Block) [32]byte) error {
+	for height, b := range chain {
+		switch {
+		case !b.Pruned():
+			if slices.ContainsFunc(chain[height:], func(b *block.Block) bool { return b.Pruned() }) {
+				return errors.New("pruned block after a full one")
+			}
+			return nil
+		case s == nil:
+			return errors.New("pruned block without a snapshot")
+		case height > s.Height:
+			return fmt.Errorf("pruned block at height %d beyond the snapshot at %d", height, s.Height)
+		case height == s.Height && fmt.Sprintf("%x", blockHash(b)) != s.BlockHash:
+			return errors.New("pruned block differs from the snapshot's block")
+		}
+	}
+	return nil
+}
+
+// ParseSnapshotCheckpoint parses "height:hash", the height of a trusted snapshot and its hex Snapshot.Hash.
+func ParseSnapshotCheckpoint(s string) (int, [32]byte, error) {
+	c, err := ParseCheckpoint(s)
+	return c.Height, c.Hash, err
+}
+
+// SnapshotAt computes the snapshot after the block at height, replaying the blocks from genesis, or from
+// the node's own snapshot if it was fast-synced. withHeaders includes the headers up to height.
+func (bc *Blockchain) SnapshotAt(height int, withHeaders bool) (*Snapshot, error) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	if height < 0 || height >= len(bc.chain) {
+		return nil, fmt.Errorf("no block at height %d", height)
+	}
+	s := &Snapshot{Height: height, BlockHash: fmt.Sprintf("%x", bc.BlockHash(bc.chain[height])), Balances: make(map[string]transaction.Amount), Contracts: make(map[string]*Contract), Tokens: make(map[string]*Token), Assets: make(map[string]*Asset), Nonces: make(map[string]uint64)}
+	start := 0
+	if base := bc.snapshot; base != nil && bc.chain[0].Pruned() {
+		if height < base.Height {
+			return nil, fmt.Errorf("blocks below height %d are pruned", base.Height)
+		}
+		maps.Copy(s.Balances, base.Balances)
+		s.Contracts = cloneContracts(base.Contracts)
+		s.Tokens = cloneTokens(base.Tokens)
+		s.Assets = cloneAssets(base.Assets)
+		maps.Copy(s.Nonces, base.Nonces)
+		s.Issued = base.Issued
+		start = base.Height + 1
+	}
+	for _, b := range bc.chain[start : height+1] {
+		s.Issued += blockIssuance(b)
+		applyTransactions(s.Balances, s.Contracts, s.Tokens, s.Assets, b.Transactions())
+		applyNonces(s.Nonces, b.Transactions())
+	}
+	for a, v := range s.Balances {
+		if v == 0 {
+			// Replays from genesis and from a snapshot leave different zero entries; neither may change the hash.
+			delete(s.Balances, a)
+		}
+	}
+	if withHeaders {
+		s.Headers = make([]*block.Block, height+1)
+		for i, b := range bc.chain[:height+1] {
+			header := *b
+			header.Prune()
+			s.Headers[i] = &header
+		}
+	}
+	return s, nil
+}
+
+// FastSync bootstraps a fresh node from neighbor n: it fetches the snapshot at the trusted height, checks
+// that its hash is trusted and that its headers link up to the snapshot's block under the consensus
+// rules, starts the chain from the snapshot, and then downloads and validates only the blocks after it.
+func (bc *Blockchain) FastSync(ctx context.Context, n string, height int, trusted [32]byte) error {
+	if len(bc.Chain()) > 1 {
+		return ErrNotFreshNode
+	}
+	s, err := bc.fetchSnapshot(ctx, n, height)
+	if err != nil {
+		return err
+	}
+	if s.Height != height || s.Hash() != trusted {
+		return ErrSnapshotMismatch
+	}
+	if len(s.Headers) != height+1 {
+		return fmt.Errorf("snapshot has %d headers, want %d", len(s.Headers), height+1)
+	}
+	if bc.genesis != nil && bc.BlockHash(s.Headers[0]) != bc.GenesisHash() {
+		return errors.New("snapshot starts from a different genesis block")
+	}
+	for i, h := range s.Headers {
+		h.Prune()
+		if i == 0 {
+			continue
+		}
+		if err := bc.checkHeader(s.Headers[:i], h); err != nil {
+			return fmt.Errorf("snapshot header %d: %w", i, err)
+		}
+	}
+	if fmt.Sprintf("%x", bc.BlockHash(s.Headers[height])) != s.BlockHash {
+		return ErrSnapshotMismatch
+	}
+
+	bc.mux.Lock()
+	headers := s.Headers
+	s.Headers = nil
+	bc.snapshot = s
+	bc.setChain(headers)
+	if bc.storage != nil {
+		if err := bc.storage.SaveSnapshot(s); err != nil {
+			log.Printf("action=save_snapshot, status=fail, err=%v", err)
+		}
+		if err := bc.storage.Blocks().ReplaceChain(bc.chain); err != nil {
+			log.Printf("action=save_chain, status=fail, err=%v", err)
+		}
+	}
+	bc.mux.Unlock()
+	log.Printf("action=fast_sync, status=snapshot_loaded, neighbor=%s, height=%d, balances=%d", n, height, len(s.Balances))
+
+	for offset := height + 1; ; {
+		blocks, next, err := bc.fetchBlocks(ctx, n, offset)
+		if err != nil {
+			return err
+		}
+		for _, b := range blocks {
+			if err := bc.appendValidBlock(b); err != nil {
+				return err
+			}
+		}
+		if next == nil {
+			break
+		}
+		offset = *next
+	}
+	log.Printf("action=fast_sync, status=success, neighbor=%s, height=%d", n, len(bc.Chain())-1)
+	return nil
+}
+
+// appendValidBlock appends b at the tip if it is valid there.
+func (bc *Blockchain) appendValidBlock(b *block.Block) error {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	if err := bc.checkBlock(bc.chain, b, bc.tipState(), bc.rules()); err != nil {
+		return fmt.Errorf("block %d: %w", len(bc.chain), err)
+	}
+	bc.appendBlock(b)
+	bc.removeFromPool(b.Transactions())
+	if bc.miningCancel != nil {
+		bc.miningCancel()
+	}
+	return nil
+}
+
+// fetchSnapshot downloads neighbor n's GET /snapshot at height, with headers.
+func (bc *Blockchain) fetchSnapshot(ctx context.Context, n string, height int) (*Snapshot, error) {
+	req, client := bc.neighborRequest(ctx, http.MethodGet, n, fmt.Sprintf("/snapshot?height=%d", height), nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("snapshot answered %s", resp.Status)
+	}
+	s := new(Snapshot)
+	if err := decodePeer(resp.Body, resp.Header.Get("Content-Type"), WIRE_MAX_RESPONSE_SIZE, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// fetchBlocks downloads one page of neighbor n's GET /blocks from offset and the offset of the next page,
+// nil after the last.
+func (bc *Blockchain) fetchBlocks(ctx context.Context, n string, offset int) ([]*block.Block, *int, error) {
+	req, client := bc.neighborRequest(ctx, http.MethodGet, n, fmt.Sprintf("/blocks?offset=%d&limit=%d", offset, FAST_SYNC_PAGE_SIZE), nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("blocks answered %s", resp.Status)
+	}
+	var page struct {
+		Blocks     []*block.Block `json:"blocks"`
+		NextOffset *int           `json:"next_offset"`
+	}
+	if err := decodePeer(resp.Body, resp.Header.Get("Content-Type"), WIRE_MAX_RESPONSE_SIZE, &page); err != nil {
+		return nil, nil, err
+	}
+	return page.Blocks, page.NextOffset, nil
+}
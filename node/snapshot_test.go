@@ -0,0 +1,78 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// serveSnapshots serves bc's /snapshot and /blocks, what fast sync requests, and returns the address.
+func serveSnapshots(t *testing.T, bc *Blockchain) string {
+	t.Helper()
+	bcs := &BlockchainServer{blockchain: bc}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/snapshot", bcs.Snapshot)
+	mux.HandleFunc("/blocks", bcs.GetBlocks)
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s.Listener.Addr().String()
+}
+
+func TestFastSyncStartsFromATrustedSnapshot(t *testing.T) {
+	full, miner := newTestBlockchain(t)
+	alice := fund(t, full, miner, transaction.COIN)
+	bob := fund(t, full, alice, transaction.COIN/4)
+	fund(t, full, miner, transaction.COIN)
+	addr := serveSnapshots(t, full)
+
+	s, err := full.SnapshotAt(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Balances[alice.BlockchainAddress()] != transaction.COIN {
+		t.Fatalf("snapshot balance of alice = %v, want %v", s.Balances[alice.BlockchainAddress()], transaction.COIN)
+	}
+	if _, err := full.SnapshotAt(len(full.Chain()), false); err == nil {
+		t.Fatal("SnapshotAt past the tip succeeded")
+	}
+
+	fresh, _ := newTestBlockchain(t)
+	if err := fresh.FastSync(context.Background(), addr, 1, [32]byte{1}); !errors.Is(err, ErrSnapshotMismatch) {
+		t.Fatalf("FastSync to an untrusted snapshot = %v, want %v", err, ErrSnapshotMismatch)
+	}
+	if err := fresh.FastSync(context.Background(), addr, 1, s.Hash()); err != nil {
+		t.Fatalf("FastSync: %v", err)
+	}
+	if got, want := fresh.BlockHash(fresh.LastBlock()), full.BlockHash(full.LastBlock()); got != want {
+		t.Fatalf("fast-synced tip = %x, want %x", got, want)
+	}
+	if !fresh.Chain()[0].Pruned() || fresh.Chain()[2].Pruned() {
+		t.Fatal("fast sync should prune the blocks up to the snapshot and keep those after it")
+	}
+	for _, w := range []string{miner.BlockchainAddress(), alice.BlockchainAddress(), bob.BlockchainAddress()} {
+		if got, want := fresh.CalculateTotalAmount(w), full.CalculateTotalAmount(w); got != want {
+			t.Fatalf("fast-synced balance of %s = %v, want %v", w, got, want)
+		}
+	}
+
+	// The fast-synced node computes later snapshots from its own, agreeing with the full node.
+	tip := len(full.Chain()) - 1
+	want, _ := full.SnapshotAt(tip, false)
+	got, err := fresh.SnapshotAt(tip, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Hash() != want.Hash() {
+		t.Fatal("the fast-synced node's snapshot at the tip differs from the full node's")
+	}
+	if _, err := fresh.SnapshotAt(0, false); err == nil {
+		t.Fatal("SnapshotAt below the fast-sync snapshot succeeded")
+	}
+	if err := fresh.FastSync(context.Background(), addr, 1, s.Hash()); !errors.Is(err, ErrNotFreshNode) {
+		t.Fatalf("FastSync again = %v, want %v", err, ErrNotFreshNode)
+	}
+}
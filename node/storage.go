@@ -0,0 +1,574 @@
+package node
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+const (
+	BLOCKS_FILE_NAME        = "blocks.jsonl"
+	BLOCKS_SNAPPY_FILE_NAME = "blocks.snappy"
+	POOL_FILE_NAME          = "transaction_pool.json"
+	PEERS_FILE_NAME         = "peers.json"
+
+	SNAPSHOT_FILE_NAME = "snapshot.json"
+
+	STORAGE_COMPRESSION_SNAPPY = "snappy"
+	STORAGE_COMPRESSION_NONE   = "none"
+
+	// MAX_STORED_BLOCK_SIZE bounds one stored block, compressed or not, when the chain is read back.
+	MAX_STORED_BLOCK_SIZE = 64 * 1024 * 1024
+)
+
+var (
+	ErrInvalidChain              = errors.New("invalid chain")
+	ErrUnknownStorageCompression = errors.New("unknown storage compression")
+)
+
+// FileStorage keeps a node's data directory: the blocks, in a BlockStore, and next to them as JSON files the
+// transaction pool, saved at shutdown, the peers seen, and the snapshot of a fast-synced node.
+type FileStorage struct {
+	blocks    BlockStore
+	poolPath  string
+	peersPath string
+	// snapshotPath holds the snapshot a fast-synced node started from, which its pruned headers rely on.
+	snapshotPath string
+	mux          sync.Mutex
+}
+
+// NewFileStorage opens (creating if needed) the data directory dataDir, whose blocks blocks keeps.
+func NewFileStorage(dataDir string, blocks BlockStore) (*FileStorage, error) {
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStorage{
+		blocks:       blocks,
+		poolPath:     filepath.Join(dataDir, POOL_FILE_NAME),
+		peersPath:    filepath.Join(dataDir, PEERS_FILE_NAME),
+		snapshotPath: filepath.Join(dataDir, SNAPSHOT_FILE_NAME),
+	}, nil
+}
+
+// Blocks returns the store that keeps the chain.
+func (s *FileStorage) Blocks() BlockStore {
+	return s.blocks
+}
+
+// Close closes the block store, if it can be closed.
+func (s *FileStorage) Close() error {
+	if c, ok := s.blocks.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// PoolPath returns the location of the transaction pool file.
+func (s *FileStorage) PoolPath() string {
+	return s.poolPath
+}
+
+// SaveTransactions atomically replaces the transaction pool file with transactions.
+func (s *FileStorage) SaveTransactions(transactions []*transaction.Transaction) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	m, err := json.Marshal(transactions)
+	if err != nil {
+		return err
+	}
+	tmp := s.poolPath + ".tmp"
+	if err := os.WriteFile(tmp, m, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.poolPath)
+}
+
+// LoadTransactions reads the transaction pool file. A missing file yields no transactions.
+func (s *FileStorage) LoadTransactions() ([]*transaction.Transaction, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	m, err := os.ReadFile(s.poolPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var transactions []*transaction.Transaction
+	if err := json.Unmarshal(m, &transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// SavePeers atomically replaces the peers file with the addresses of the peers seen so far.
+func (s *FileStorage) SavePeers(peers []string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	m, err := json.Marshal(peers)
+	if err != nil {
+		return err
+	}
+	tmp := s.peersPath + ".tmp"
+	if err := os.WriteFile(tmp, m, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.peersPath)
+}
+
+// LoadPeers reads the peers file. A missing file yields no peers.
+func (s *FileStorage) LoadPeers() ([]string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	m, err := os.ReadFile(s.peersPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var peers []string
+	if err := json.Unmarshal(m, &peers); err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+// SaveSnapshot atomically replaces the snapshot file with s, without its headers, which the block file holds.
+func (s *FileStorage) SaveSnapshot(snapshot *Snapshot) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	stored := *snapshot
+	stored.Headers = nil
+	m, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+	tmp := s.snapshotPath + ".tmp"
+	if err := os.WriteFile(tmp, m, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.snapshotPath)
+}
+
+// LoadSnapshot reads the snapshot file. A missing file yields nil.
+func (s *FileStorage) LoadSnapshot() (*Snapshot, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	m, err := os.ReadFile(s.snapshotPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshot := new(Snapshot)
+	if err := json.Unmarshal(m, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// FileBlockStore keeps the chain in one append-only block file. Under STORAGE_COMPRESSION_NONE it is
+// blocks.jsonl, one JSON-encoded block per line; under STORAGE_COMPRESSION_SNAPPY it is blocks.snappy, where
+// each block's JSON is snappy-compressed and prefixed with its length as a uvarint. The first read of the
+// file indexes where each block starts, so lookups by height or hash read one record.
+type FileBlockStore struct {
+	path        string
+	compression string
+	hasher      block.Hasher
+
+	mux     sync.Mutex
+	indexed bool
+	offsets []int64          // of each block's record, by height
+	heights map[[32]byte]int // of each block, by hash
+}
+
+// NewFileBlockStore opens the block file inside dataDir, creating the directory if needed, storing blocks
+// under compression and looking them up by their hash under hasher (nil for SHA-256).
+func NewFileBlockStore(dataDir, compression string, hasher block.Hasher) (*FileBlockStore, error) {
+	if compression != STORAGE_COMPRESSION_SNAPPY && compression != STORAGE_COMPRESSION_NONE {
+		return nil, fmt.Errorf("%w %q (available: %s, %s)", ErrUnknownStorageCompression, compression, STORAGE_COMPRESSION_SNAPPY, STORAGE_COMPRESSION_NONE)
+	}
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return nil, err
+	}
+	if hasher == nil {
+		hasher = block.SHA256Hasher{}
+	}
+	return &FileBlockStore{path: filepath.Join(dataDir, blocksFileName(compression)), compression: compression, hasher: hasher}, nil
+}
+
+// blocksFileName returns the name of the block file under compression.
+func blocksFileName(compression string) string {
+	if compression == STORAGE_COMPRESSION_SNAPPY {
+		return BLOCKS_SNAPPY_FILE_NAME
+	}
+	return BLOCKS_FILE_NAME
+}
+
+// Path returns the location of the block file.
+func (s *FileBlockStore) Path() string {
+	return s.path
+}
+
+// appendBlockRecord appends b to buf as the block file stores it under compression.
+func appendBlockRecord(buf []byte, b *block.Block, compression string) ([]byte, error) {
+	m, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	if compression == STORAGE_COMPRESSION_NONE {
+		return append(append(buf, m...), '\n'), nil
+	}
+	c := SnappyEncode(m)
+	return append(binary.AppendUvarint(buf, uint64(len(c))), c...), nil
+}
+
+// readBlockRecord reads the next record of a block file stored under compression, returning the block's
+// JSON and how many bytes the record took, or io.EOF after the last one.
+func readBlockRecord(r *bufio.Reader, compression string) ([]byte, int64, error) {
+	if compression == STORAGE_COMPRESSION_NONE {
+		var read int64
+		for {
+			line, err := r.ReadBytes('\n')
+			read += int64(len(line))
+			// Empty lines are skipped; a last line without its newline is still read, and fails if it was cut short.
+			if m := bytes.TrimSuffix(line, []byte("\n")); len(m) > 0 {
+				if len(m) > MAX_STORED_BLOCK_SIZE {
+					return nil, 0, fmt.Errorf("stored block takes %d bytes, more than %d", len(m), MAX_STORED_BLOCK_SIZE)
+				}
+				return m, read, nil
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n > MAX_STORED_BLOCK_SIZE {
+		return nil, 0, fmt.Errorf("stored block takes %d bytes, more than %d", n, MAX_STORED_BLOCK_SIZE)
+	}
+	c := make([]byte, n)
+	if _, err := io.ReadFull(r, c); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	m, err := SnappyDecode(c)
+	if err != nil {
+		return nil, 0, err
+	}
+	return m, int64(len(binary.AppendUvarint(nil, n))) + int64(n), nil
+}
+
+// resetIndex empties the index, for a file about to be read or written from the start. Callers must hold mux.
+func (s *FileBlockStore) resetIndex() {
+	s.indexed = true
+	s.offsets = nil
+	s.heights = make(map[[32]byte]int)
+}
+
+// index records b, whose record starts at offset, as the next block. Callers must hold mux.
+func (s *FileBlockStore) index(b *block.Block, offset int64) {
+	s.heights[b.HashWithCache(s.hasher)] = len(s.offsets)
+	s.offsets = append(s.offsets, offset)
+}
+
+// PutBlock appends a block to the end of the block file and syncs it to disk.
+func (s *FileBlockStore) PutBlock(b *block.Block) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	m, err := appendBlockRecord(nil, b, s.compression)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(m); err != nil {
+		return err
+	}
+	if s.indexed {
+		s.index(b, offset)
+	}
+	return f.Sync()
+}
+
+// ForEach calls fn with every stored block in order, stopping at the first error, which it returns.
+// fn must not call the store.
+func (s *FileBlockStore) ForEach(fn func(height int, b *block.Block) error) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	chain, err := s.load()
+	if err != nil {
+		return err
+	}
+	for height, b := range chain {
+		if err := fn(height, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load reads and indexes every stored block. A missing file holds none. A block file left by the other
+// compression, as after changing -storage_compression, is read instead and rewritten in this one.
+// Callers must hold mux.
+func (s *FileBlockStore) load() ([]*block.Block, error) {
+	chain, err := s.readFile(s.path, s.compression)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return chain, err
+	}
+	other := STORAGE_COMPRESSION_NONE
+	if s.compression == STORAGE_COMPRESSION_NONE {
+		other = STORAGE_COMPRESSION_SNAPPY
+	}
+	otherPath := filepath.Join(filepath.Dir(s.path), blocksFileName(other))
+	chain, err = s.readFile(otherPath, other)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.resetIndex()
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.writeChain(chain); err != nil {
+		return nil, err
+	}
+	log.Printf("action=migrate_storage, from=%s, to=%s, blocks=%d", otherPath, s.path, len(chain))
+	return chain, os.Remove(otherPath)
+}
+
+// readFile reads every block of the block file at path, stored under compression, indexing them.
+// Callers must hold mux.
+func (s *FileBlockStore) readFile(path, compression string) ([]*block.Block, error) {
+	s.indexed = false
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s.resetIndex()
+	chain := make([]*block.Block, 0)
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		m, n, err := readBlockRecord(r, compression)
+		if err == io.EOF {
+			return chain, nil
+		}
+		b := new(block.Block)
+		if err == nil {
+			err = json.Unmarshal(m, b)
+		}
+		if err != nil {
+			s.indexed = false
+			return nil, fmt.Errorf("stored block %d: %w", len(chain), err)
+		}
+		s.index(b, offset)
+		offset += n
+		chain = append(chain, b)
+	}
+}
+
+// ensureIndexed reads the block file if it has not been indexed yet. Callers must hold mux.
+func (s *FileBlockStore) ensureIndexed() error {
+	if s.indexed {
+		return nil
+	}
+	_, err := s.load()
+	return err
+}
+
+// readAt reads the block whose record starts at offset. Callers must hold mux.
+func (s *FileBlockStore) readAt(offset int64) (*block.Block, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	m, _, err := readBlockRecord(bufio.NewReader(f), s.compression)
+	if err != nil {
+		return nil, err
+	}
+	b := new(block.Block)
+	if err := json.Unmarshal(m, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// BlockByHeight returns the stored block at height, or ErrBlockNotStored.
+func (s *FileBlockStore) BlockByHeight(height int) (*block.Block, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := s.ensureIndexed(); err != nil {
+		return nil, err
+	}
+	if height < 0 || height >= len(s.offsets) {
+		return nil, ErrBlockNotStored
+	}
+	return s.readAt(s.offsets[height])
+}
+
+// BlockByHash returns the stored block with hash and its height, or ErrBlockNotStored.
+func (s *FileBlockStore) BlockByHash(hash [32]byte) (*block.Block, int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := s.ensureIndexed(); err != nil {
+		return nil, 0, err
+	}
+	height, ok := s.heights[hash]
+	if !ok {
+		return nil, 0, ErrBlockNotStored
+	}
+	b, err := s.readAt(s.offsets[height])
+	return b, height, err
+}
+
+// Tip returns the last stored block and its height, or ErrBlockNotStored if there is none.
+func (s *FileBlockStore) Tip() (*block.Block, int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := s.ensureIndexed(); err != nil {
+		return nil, 0, err
+	}
+	if len(s.offsets) == 0 {
+		return nil, 0, ErrBlockNotStored
+	}
+	b, err := s.readAt(s.offsets[len(s.offsets)-1])
+	return b, len(s.offsets) - 1, err
+}
+
+// ReplaceChain atomically rewrites the block file with chain, used when consensus adopts a neighbor's chain.
+func (s *FileBlockStore) ReplaceChain(chain []*block.Block) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.writeChain(chain)
+}
+
+// writeChain is ReplaceChain without locking; callers must hold mux.
+func (s *FileBlockStore) writeChain(chain []*block.Block) error {
+	// Until the new file is in place the index matches neither file, so a failure leaves it to be rebuilt.
+	s.indexed = false
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	offsets := make([]int64, 0, len(chain))
+	var offset int64
+	var m []byte
+	for _, b := range chain {
+		var err error
+		if m, err = appendBlockRecord(m[:0], b, s.compression); err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(m)
+		offsets = append(offsets, offset)
+		offset += int64(len(m))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.resetIndex()
+	for i, b := range chain {
+		s.index(b, offsets[i])
+	}
+	return nil
+}
+
+// Export writes the full blockchain (chain, pending pool, and miner address) to path as indented JSON.
+func (bc *Blockchain) Export(path string) error {
+	m, err := json.MarshalIndent(bc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, m, 0o644)
+}
+
+// ImportBlockchain reads a blockchain written by Export, re-validating every block and every
+// pending transaction before returning it. The imported chain is kept in memory only.
+func ImportBlockchain(path string) (*Blockchain, error) {
+	m, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := DecodeBlockchain(m)
+	if err != nil {
+		return nil, err
+	}
+	if !bc.ValidChain(bc.chain) {
+		return nil, ErrInvalidChain
+	}
+	for _, t := range bc.transactionPool {
+		if err := bc.signatures.Verify(t); err != nil {
+			return nil, fmt.Errorf("invalid pending transaction: %w", err)
+		}
+	}
+	return bc, nil
+}
+
+// DecodeBlockchain parses a blockchain written by Export or served by GET /chain, with the remaining
+// settings at their defaults. It does not validate the chain.
+func DecodeBlockchain(m []byte) (*Blockchain, error) {
+	bc := new(Blockchain)
+	if err := json.Unmarshal(m, bc); err != nil {
+		return nil, err
+	}
+	bc.neighborRange = DefaultNeighborRange()
+	bc.neighborScheme = "http"
+	bc.transport = &TCPTransport{}
+	bc.neighborClient = newPeerClient(bc.transport, false)
+	bc.seenTransactions = newSeenCache()
+	bc.orphans = newOrphanPool()
+	bc.orphanTxs = newOrphanTransactionPool()
+	bc.halvingInterval = REWARD_HALVING_INTERVAL
+	bc.maxSupply = MAX_SUPPLY
+	bc.maxBlockTxs = MAX_BLOCK_TRANSACTIONS
+	bc.maxBlockSize = MAX_BLOCK_SIZE
+	bc.poolTTL = TRANSACTION_POOL_TTL
+	bc.pooledAt = make(map[[32]byte]time.Time)
+	bc.expired = make(map[[32]byte]ExpiredTransaction)
+	return bc, nil
+}
@@ -0,0 +1,592 @@
+package node
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/block"
+)
+
+const (
+	// STRATUM_JOB_INTERVAL is how often workers get a fresh job with the transactions pooled since the last.
+	STRATUM_JOB_INTERVAL = 30 * time.Second
+	// STRATUM_MAX_JOBS bounds how many jobs on the current tip still accept shares.
+	STRATUM_MAX_JOBS = 8
+	// STRATUM_NONCE_RANGE is how many nonces each session searches per job, starting at its session ID times it.
+	STRATUM_NONCE_RANGE = 1 << 32
+	// STRATUM_MAX_LINE bounds a message from a worker.
+	STRATUM_MAX_LINE = 16 << 10
+	// STRATUM_WRITE_TIMEOUT bounds how long a message to a worker may take to send.
+	STRATUM_WRITE_TIMEOUT = 10 * time.Second
+
+	// Error codes of mining.submit and mining.authorize, as in Stratum V1.
+	STRATUM_ERR_OTHER          = 20
+	STRATUM_ERR_JOB_NOT_FOUND  = 21
+	STRATUM_ERR_DUPLICATE      = 22
+	STRATUM_ERR_LOW_DIFFICULTY = 23
+	STRATUM_ERR_UNAUTHORIZED   = 24
+	STRATUM_ERR_NOT_SUBSCRIBED = 25
+)
+
+// StratumMessage is one line of the mining protocol: a request or notification (Method and Params) or a
+// response (Result and Error) to the request with the same ID. Notifications carry a null ID.
+type StratumMessage struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method,omitempty"`
+	Params []json.RawMessage `json:"params,omitempty"`
+	Result any               `json:"result,omitempty"`
+	Error  *RPCError         `json:"error,omitempty"`
+}
+
+// StratumSubscription answers mining.subscribe: the session's ID and the nonces it searches, [NonceStart,
+// NonceEnd), so that no two workers hash the same header, and the hash function of the proof of work.
+type StratumSubscription struct {
+	Session    uint64 `json:"session"`
+	NonceStart int    `json:"nonce_start"`
+	NonceEnd   int    `json:"nonce_end"`
+	Hasher     string `json:"hasher"`
+}
+
+// StratumWorkerStats counts the shares of one worker name across its connections.
+type StratumWorkerStats struct {
+	Worker    string `json:"worker"`
+	Accepted  int    `json:"accepted"`
+	Rejected  int    `json:"rejected"`
+	Blocks    int    `json:"blocks"`
+	LastShare int64  `json:"last_share,omitempty"`
+}
+
+// StratumServer hands out mining jobs over a line-delimited JSON protocol modeled on Stratum V1, the one
+// mining pools use: workers subscribe, authorize under a worker name, receive jobs from the node's block
+// templates, and submit shares, nonces that meet the easier share difficulty. A share that also meets the
+// block difficulty completes the job's block, which the node assembles and submits as its own.
+type StratumServer struct {
+	bc              *Blockchain
+	hasher          block.Hasher
+	shareDifficulty float64
+	shareTarget     *big.Int
+	pool            *MiningPool
+
+	mux         sync.Mutex
+	jobs        map[string]*stratumJob
+	current     *stratumJob
+	nextJob     uint64
+	sessions    map[*stratumSession]bool
+	nextSession uint64
+	workers     map[string]*StratumWorkerStats
+}
+
+// stratumJob is a block template handed to workers, with the shares found for it.
+type stratumJob struct {
+	id       string
+	template *BlockTemplate
+	target   *big.Int
+	shares   map[int]bool
+}
+
+// stratumSession is one worker connection; mux guards writes to conn.
+type stratumSession struct {
+	conn       net.Conn
+	id         uint64
+	mux        sync.Mutex
+	subscribed bool
+	workers    map[string]bool
+}
+
+// NewStratumServer serves jobs for bc's next blocks, accepting shares that meet shareDifficulty (see
+// DifficultyTarget); zero or less means one less than the block difficulty, but at least 1 unless the
+// block difficulty is lower.
+func NewStratumServer(bc *Blockchain, shareDifficulty float64) *StratumServer {
+	hasher, difficulty := bc.Hasher(), 2.0
+	if p, ok := bc.Consensus().(*block.ProofOfWork); ok {
+		hasher, difficulty = p.Hasher(), p.Difficulty()
+	}
+	if shareDifficulty <= 0 {
+		shareDifficulty = max(difficulty-1, min(difficulty, 1))
+	}
+	return &StratumServer{
+		bc:              bc,
+		hasher:          hasher,
+		shareDifficulty: shareDifficulty,
+		shareTarget:     block.DifficultyTarget(shareDifficulty),
+		jobs:            make(map[string]*stratumJob),
+		sessions:        make(map[*stratumSession]bool),
+		workers:         make(map[string]*StratumWorkerStats),
+	}
+}
+
+// SetPool makes the server a pool: worker names must start with the address their shares are credited to,
+// as in ADDRESS.rig1, and the rewards of the blocks its workers find are paid out by p.
+func (s *StratumServer) SetPool(p *MiningPool) {
+	s.pool = p
+}
+
+// Pool returns the server's share accounting, or nil if it pays no one.
+func (s *StratumServer) Pool() *MiningPool {
+	return s.pool
+}
+
+// ShareDifficulty returns the difficulty a share must meet.
+func (s *StratumServer) ShareDifficulty() float64 {
+	return s.shareDifficulty
+}
+
+// Workers returns the share counts of every worker name seen, sorted by name.
+func (s *StratumServer) Workers() []StratumWorkerStats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	stats := make([]StratumWorkerStats, 0, len(s.workers))
+	for _, w := range s.workers {
+		stats = append(stats, *w)
+	}
+	slices.SortFunc(stats, func(a, b StratumWorkerStats) int { return cmp.Compare(a.Worker, b.Worker) })
+	return stats
+}
+
+// Serve accepts workers on ln until ctx is cancelled, then closes ln and every worker connection.
+func (s *StratumServer) Serve(ctx context.Context, ln net.Listener) {
+	events, unsubscribe := s.bc.Subscribe()
+	defer unsubscribe()
+	go s.dispatchJobs(ctx, events)
+	context.AfterFunc(ctx, func() {
+		ln.Close()
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		for sess := range s.sessions {
+			sess.conn.Close()
+		}
+	})
+	log.Printf("action=stratum, status=listening, addr=%s, share_difficulty=%g", ln.Addr(), s.shareDifficulty)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("action=stratum, status=fail, err=%v", err)
+			}
+			return
+		}
+		go s.serveSession(conn)
+	}
+}
+
+// dispatchJobs sends workers a clean job whenever the tip changes, one when transactions arrive while
+// there is no job, and a fresh one every STRATUM_JOB_INTERVAL with the transactions pooled since.
+func (s *StratumServer) dispatchJobs(ctx context.Context, events <-chan Event) {
+	ticker := time.NewTicker(STRATUM_JOB_INTERVAL)
+	defer ticker.Stop()
+	s.newJob(true)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-events:
+			switch e.Type {
+			case EVENT_NEW_BLOCK, EVENT_CHAIN_REPLACED:
+				s.newJob(true)
+			case EVENT_NEW_TRANSACTION:
+				s.mux.Lock()
+				idle := s.current == nil
+				s.mux.Unlock()
+				if idle {
+					s.newJob(true)
+				}
+			}
+		case <-ticker.C:
+			s.mux.Lock()
+			idle := len(s.sessions) == 0
+			s.mux.Unlock()
+			if !idle {
+				s.newJob(false)
+			}
+		}
+	}
+}
+
+// newJob makes a job of a new block template and notifies every authorized session. A clean job replaces
+// all earlier ones, whose block no longer extends the tip; without a template (an empty pool) there is no
+// job until transactions arrive, and workers are told to wait for one.
+func (s *StratumServer) newJob(clean bool) {
+	bt, err := s.bc.NewBlockTemplate("")
+	s.mux.Lock()
+	if err != nil {
+		if !clean || s.current == nil {
+			s.mux.Unlock()
+			return
+		}
+		clear(s.jobs)
+		s.current = nil
+		authorized := s.authorizedSessions()
+		s.mux.Unlock()
+		log.Printf("action=stratum_job, status=waiting, reason=%v", err)
+		for _, sess := range authorized {
+			sess.notify("mining.wait")
+		}
+		return
+	}
+	if s.current != nil && s.current.template.PreviousHash != bt.PreviousHash {
+		clean = true
+	}
+	if clean {
+		clear(s.jobs)
+	}
+	s.nextJob++
+	job := &stratumJob{id: fmt.Sprintf("%x", s.nextJob), template: bt, target: block.DifficultyTarget(bt.Difficulty), shares: make(map[int]bool)}
+	s.jobs[job.id] = job
+	s.current = job
+	// Job IDs count up, so the job STRATUM_MAX_JOBS before this one is the oldest left.
+	delete(s.jobs, fmt.Sprintf("%x", s.nextJob-STRATUM_MAX_JOBS))
+	authorized := s.authorizedSessions()
+	s.mux.Unlock()
+	log.Printf("action=stratum_job, job=%s, height=%d, transactions=%d, clean=%t, sessions=%d", job.id, bt.Height, len(bt.Block.Transactions()), clean, len(authorized))
+	for _, sess := range authorized {
+		sess.notify("mining.notify", job.params(clean)...)
+	}
+}
+
+// authorizedSessions returns the sessions with an authorized worker. Callers must hold mux.
+func (s *StratumServer) authorizedSessions() []*stratumSession {
+	var authorized []*stratumSession
+	for sess := range s.sessions {
+		if len(sess.workers) > 0 {
+			authorized = append(authorized, sess)
+		}
+	}
+	return authorized
+}
+
+// params returns the mining.notify parameters of the job: job ID, previous hash, header prefix, header
+// suffix, height, and whether workers must drop their earlier jobs.
+func (j *stratumJob) params(clean bool) []any {
+	t := j.template
+	return []any{j.id, t.PreviousHash, t.HeaderPrefix, t.HeaderSuffix, t.Height, clean}
+}
+
+// serveSession reads the requests of one worker connection until it closes.
+func (s *StratumServer) serveSession(conn net.Conn) {
+	s.mux.Lock()
+	sess := &stratumSession{conn: conn, id: s.nextSession, workers: make(map[string]bool)}
+	s.nextSession++
+	s.sessions[sess] = true
+	s.mux.Unlock()
+	log.Printf("action=stratum_connect, session=%d, remote=%s", sess.id, conn.RemoteAddr())
+	defer func() {
+		s.mux.Lock()
+		delete(s.sessions, sess)
+		s.mux.Unlock()
+		conn.Close()
+		log.Printf("action=stratum_disconnect, session=%d", sess.id)
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), STRATUM_MAX_LINE)
+	for scanner.Scan() {
+		var req StratumMessage
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil || req.Method == "" {
+			sess.reply(nil, nil, &RPCError{RPC_PARSE_ERROR, "invalid message"})
+			continue
+		}
+		result, rpcErr := s.handle(sess, req)
+		sess.reply(req.ID, result, rpcErr)
+		if rpcErr == nil && req.Method == "mining.authorize" {
+			s.sendWork(sess)
+		}
+	}
+}
+
+// handle runs one request of sess and returns its result or error.
+func (s *StratumServer) handle(sess *stratumSession, req StratumMessage) (any, *RPCError) {
+	switch req.Method {
+	case "mining.subscribe":
+		s.mux.Lock()
+		sess.subscribed = true
+		s.mux.Unlock()
+		return StratumSubscription{
+			Session:    sess.id,
+			NonceStart: int(sess.id) * STRATUM_NONCE_RANGE,
+			NonceEnd:   int(sess.id+1) * STRATUM_NONCE_RANGE,
+			Hasher:     s.hasher.Name(),
+		}, nil
+	case "mining.authorize":
+		var worker string
+		if err := rpcParam(req.Params, 0, &worker); err != nil {
+			return nil, err
+		}
+		if worker = strings.TrimSpace(worker); worker == "" {
+			return nil, &RPCError{STRATUM_ERR_UNAUTHORIZED, "empty worker name"}
+		}
+		if _, ok := payoutAddress(worker); s.pool != nil && !ok {
+			return nil, &RPCError{STRATUM_ERR_UNAUTHORIZED, "worker name must be a payout address, optionally followed by .NAME"}
+		}
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		if !sess.subscribed {
+			return nil, &RPCError{STRATUM_ERR_NOT_SUBSCRIBED, "not subscribed"}
+		}
+		sess.workers[worker] = true
+		if s.workers[worker] == nil {
+			s.workers[worker] = &StratumWorkerStats{Worker: worker}
+		}
+		log.Printf("action=stratum_authorize, session=%d, worker=%s", sess.id, worker)
+		return true, nil
+	case "mining.submit":
+		return s.submit(sess, req.Params)
+	default:
+		return nil, &RPCError{RPC_METHOD_NOT_FOUND, "method not found: " + req.Method}
+	}
+}
+
+// sendWork sends a newly authorized session the share difficulty and the current job, if any.
+func (s *StratumServer) sendWork(sess *stratumSession) {
+	sess.notify("mining.set_difficulty", s.shareDifficulty)
+	s.mux.Lock()
+	job := s.current
+	s.mux.Unlock()
+	if job != nil {
+		sess.notify("mining.notify", job.params(true)...)
+	}
+}
+
+// submit checks the share [worker, job ID, nonce] of sess and, if it meets the block difficulty too,
+// completes the job's block and submits it.
+func (s *StratumServer) submit(sess *stratumSession, params []json.RawMessage) (any, *RPCError) {
+	var worker, jobID string
+	var nonce int
+	for i, v := range []any{&worker, &jobID, &nonce} {
+		if err := rpcParam(params, i, v); err != nil {
+			return nil, err
+		}
+	}
+	s.mux.Lock()
+	if !sess.workers[worker] {
+		s.mux.Unlock()
+		return nil, &RPCError{STRATUM_ERR_UNAUTHORIZED, "unauthorized worker"}
+	}
+	stats := s.workers[worker]
+	reject := func(code int, message string) (any, *RPCError) {
+		stats.Rejected++
+		s.mux.Unlock()
+		log.Printf("action=stratum_share, status=rejected, worker=%s, job=%s, nonce=%d, err=%s", worker, jobID, nonce, message)
+		return nil, &RPCError{code, message}
+	}
+	job, ok := s.jobs[jobID]
+	if !ok {
+		return reject(STRATUM_ERR_JOB_NOT_FOUND, "job not found")
+	}
+	if start := int(sess.id) * STRATUM_NONCE_RANGE; nonce < start || nonce >= start+STRATUM_NONCE_RANGE {
+		return reject(STRATUM_ERR_OTHER, "nonce outside the session's range")
+	}
+	if job.shares[nonce] {
+		return reject(STRATUM_ERR_DUPLICATE, "duplicate share")
+	}
+	b := *job.template.Block
+	b.SetNonce(nonce)
+	hash := b.ProofHash(s.hasher)
+	if !block.MeetsTarget(hash, s.shareTarget) {
+		return reject(STRATUM_ERR_LOW_DIFFICULTY, "low difficulty share")
+	}
+	job.shares[nonce] = true
+	stats.Accepted++
+	stats.LastShare = time.Now().UnixNano()
+	solved := block.MeetsTarget(hash, job.target)
+	s.mux.Unlock()
+	if s.pool != nil {
+		address, _ := payoutAddress(worker)
+		s.pool.addShare(address)
+	}
+	log.Printf("action=stratum_share, status=accepted, worker=%s, job=%s, nonce=%d, block=%t", worker, jobID, nonce, solved)
+	if !solved {
+		return true, nil
+	}
+	if err := s.bc.SubmitBlock(context.Background(), &b); err != nil {
+		log.Printf("action=stratum_block, status=rejected, worker=%s, job=%s, err=%v", worker, jobID, err)
+		return true, nil
+	}
+	s.mux.Lock()
+	stats.Blocks++
+	s.mux.Unlock()
+	log.Printf("action=stratum_block, status=accepted, worker=%s, height=%d, hash=%x", worker, job.template.Height, s.bc.BlockHash(&b))
+	if s.pool != nil {
+		s.pool.payRound(s.bc, job.template.Height, s.bc.BlockHash(&b), job.template.CoinbaseValue)
+	}
+	return true, nil
+}
+
+// reply sends the response to the request with id.
+func (sess *stratumSession) reply(id json.RawMessage, result any, rpcErr *RPCError) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	if rpcErr == nil && result == nil {
+		result = true
+	}
+	sess.send(StratumMessage{ID: id, Result: result, Error: rpcErr})
+}
+
+// notify sends the notification method with params.
+func (sess *stratumSession) notify(method string, params ...any) {
+	raw := make([]json.RawMessage, len(params))
+	for i, p := range params {
+		raw[i], _ = json.Marshal(p)
+	}
+	sess.send(StratumMessage{ID: json.RawMessage("null"), Method: method, Params: raw})
+}
+
+// send writes m as one line; a worker that cannot keep up is disconnected.
+func (sess *stratumSession) send(m StratumMessage) {
+	line, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("action=stratum_send, status=fail, session=%d, err=%v", sess.id, err)
+		return
+	}
+	sess.mux.Lock()
+	defer sess.mux.Unlock()
+	sess.conn.SetWriteDeadline(time.Now().Add(STRATUM_WRITE_TIMEOUT))
+	if _, err := sess.conn.Write(append(line, '\n')); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("action=stratum_send, status=fail, session=%d, err=%v", sess.id, err)
+		sess.conn.Close()
+	}
+}
+
+// RunStratumWorker mines for the stratum server at addr as worker, searching each job's nonces on threads
+// goroutines and submitting every share found, until ctx is cancelled or the server closes the connection.
+func RunStratumWorker(ctx context.Context, addr, worker string, threads int) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var writeMux sync.Mutex
+	nextID := 0
+	send := func(method string, params ...any) error {
+		raw := make([]json.RawMessage, len(params))
+		for i, p := range params {
+			raw[i], _ = json.Marshal(p)
+		}
+		writeMux.Lock()
+		defer writeMux.Unlock()
+		nextID++
+		line, _ := json.Marshal(StratumMessage{ID: json.RawMessage(fmt.Sprint(nextID)), Method: method, Params: raw})
+		_, err := conn.Write(append(line, '\n'))
+		return err
+	}
+	if err := send("mining.subscribe"); err != nil {
+		return err
+	}
+	if err := send("mining.authorize", worker); err != nil {
+		return err
+	}
+
+	var sub StratumSubscription
+	var hasher block.Hasher
+	shareDifficulty := 1.0
+	cancelSearch := func() {}
+	defer func() { cancelSearch() }()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), STRATUM_MAX_LINE)
+	for scanner.Scan() {
+		var m struct {
+			ID     json.RawMessage   `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+			Result json.RawMessage   `json:"result"`
+			Error  *RPCError         `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			if ctx.Err() != nil {
+				return nil // the scanner returns the line cut short by closing the connection
+			}
+			return fmt.Errorf("invalid message from server: %w", err)
+		}
+		switch {
+		case m.Method == "mining.set_difficulty":
+			if err := rpcParam(m.Params, 0, &shareDifficulty); err != nil {
+				return err
+			}
+		case m.Method == "mining.wait":
+			cancelSearch()
+			log.Println("action=stratum_worker_job, status=waiting")
+		case m.Method == "mining.notify":
+			var jobID, prefix, suffix string
+			var height int
+			for i, v := range []any{&jobID, nil, &prefix, &suffix, &height} {
+				if v == nil {
+					continue
+				}
+				if err := rpcParam(m.Params, i, v); err != nil {
+					return err
+				}
+			}
+			if hasher == nil {
+				return errors.New("job before subscription")
+			}
+			cancelSearch()
+			search, cancel := context.WithCancel(ctx)
+			cancelSearch = cancel
+			log.Printf("action=stratum_worker_job, job=%s, height=%d, share_difficulty=%g", jobID, height, shareDifficulty)
+			go searchShares(search, hasher, []byte(prefix), []byte(suffix), sub.NonceStart, sub.NonceEnd, threads, block.DifficultyTarget(shareDifficulty), func(nonce int) {
+				if err := send("mining.submit", worker, jobID, nonce); err != nil {
+					log.Printf("action=stratum_worker_submit, status=fail, err=%v", err)
+				}
+			})
+		case string(m.ID) == "1":
+			if m.Error != nil {
+				return m.Error
+			}
+			if err := json.Unmarshal(m.Result, &sub); err != nil {
+				return fmt.Errorf("invalid subscription: %w", err)
+			}
+			if hasher, err = block.ProofOfWorkHasherByName(sub.Hasher); err != nil {
+				return err
+			}
+		case string(m.ID) == "2":
+			if m.Error != nil {
+				return fmt.Errorf("authorize %s: %w", worker, m.Error)
+			}
+			log.Printf("action=stratum_worker_authorize, worker=%s, session=%d, nonces=%d..%d", worker, sub.Session, sub.NonceStart, sub.NonceEnd)
+		case m.Error != nil:
+			log.Printf("action=stratum_worker_share, status=rejected, err=%s", m.Error.Message)
+		default:
+			log.Println("action=stratum_worker_share, status=accepted")
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("server closed the connection")
+}
+
+// searchShares hashes prefix + nonce + suffix for the nonces in [start, end), split across threads
+// goroutines, and calls found for each hash at most target, until ctx is cancelled.
+func searchShares(ctx context.Context, hasher block.Hasher, prefix, suffix []byte, start, end, threads int, target *big.Int, found func(nonce int)) {
+	var wg sync.WaitGroup
+	for i := range max(threads, 1) {
+		wg.Go(func() {
+			header := make([]byte, 0, len(prefix)+20+len(suffix))
+			for nonce := start + i; nonce < end && ctx.Err() == nil; nonce += max(threads, 1) {
+				header = append(strconv.AppendInt(append(header[:0], prefix...), int64(nonce), 10), suffix...)
+				if block.MeetsTarget(hasher.Sum256(header), target) {
+					found(nonce)
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
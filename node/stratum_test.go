@@ -0,0 +1,210 @@
+package node
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+// stratumClient speaks the mining protocol to one session of a StratumServer over an in-memory connection.
+type stratumClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID int
+}
+
+// stratumReply is a StratumMessage as a worker decodes it, with the result left raw.
+type stratumReply struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Result json.RawMessage   `json:"result"`
+	Error  *RPCError         `json:"error"`
+}
+
+// newStratumClient connects a client to a new session of s.
+func newStratumClient(t *testing.T, s *StratumServer) *stratumClient {
+	t.Helper()
+	server, client := net.Pipe()
+	go s.serveSession(server)
+	t.Cleanup(func() { client.Close() })
+	return &stratumClient{t: t, conn: client, reader: bufio.NewReader(client)}
+}
+
+// call sends the request method with params and returns the reply.
+func (c *stratumClient) call(method string, params ...any) stratumReply {
+	c.t.Helper()
+	c.nextID++
+	raw := make([]json.RawMessage, len(params))
+	for i, p := range params {
+		raw[i], _ = json.Marshal(p)
+	}
+	line, _ := json.Marshal(StratumMessage{ID: json.RawMessage(strconv.Itoa(c.nextID)), Method: method, Params: raw})
+	if _, err := c.conn.Write(append(line, '\n')); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.read()
+}
+
+// read returns the next message from the server.
+func (c *stratumClient) read() stratumReply {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var m stratumReply
+	if err := json.Unmarshal(line, &m); err != nil {
+		c.t.Fatalf("%v in %s", err, line)
+	}
+	return m
+}
+
+// errorCode returns the code of m's error, 0 if it succeeded.
+func (m stratumReply) errorCode() int {
+	if m.Error == nil {
+		return 0
+	}
+	return m.Error.Code
+}
+
+func TestStratumSessionsSubscribeAuthorizeAndSubmitShares(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(t, bc, miner, w, transaction.COIN/4, 0)
+	// Shares are easier than blocks, so some accepted shares complete no block.
+	s := NewStratumServer(bc, 0.5)
+	s.newJob(true)
+	c := newStratumClient(t, s)
+
+	if _, err := c.conn.Write([]byte("nope\n")); err != nil {
+		t.Fatal(err)
+	}
+	if m := c.read(); m.errorCode() != RPC_PARSE_ERROR {
+		t.Fatalf("reply to an invalid message: %+v, want error %d", m.Error, RPC_PARSE_ERROR)
+	}
+	if m := c.call("mining.authorize", "rig"); m.errorCode() != STRATUM_ERR_NOT_SUBSCRIBED {
+		t.Fatalf("mining.authorize before mining.subscribe: %+v, want error %d", m.Error, STRATUM_ERR_NOT_SUBSCRIBED)
+	}
+	var sub StratumSubscription
+	if err := json.Unmarshal(c.call("mining.subscribe").Result, &sub); err != nil {
+		t.Fatal(err)
+	}
+	if want := (StratumSubscription{Session: 0, NonceStart: 0, NonceEnd: STRATUM_NONCE_RANGE, Hasher: bc.Hasher().Name()}); sub != want {
+		t.Fatalf("mining.subscribe = %+v, want %+v", sub, want)
+	}
+	if m := c.call("mining.authorize", " "); m.errorCode() != STRATUM_ERR_UNAUTHORIZED {
+		t.Fatalf("mining.authorize of an empty name: %+v, want error %d", m.Error, STRATUM_ERR_UNAUTHORIZED)
+	}
+	if m := c.call("mining.authorize", "rig"); m.Error != nil {
+		t.Fatalf("mining.authorize: %+v", m.Error)
+	}
+	var difficulty float64
+	if m := c.read(); m.Method != "mining.set_difficulty" || json.Unmarshal(m.Params[0], &difficulty) != nil || difficulty != 0.5 {
+		t.Fatalf("after authorizing: %s %s, want the share difficulty", m.Method, m.Params)
+	}
+	var jobID string
+	if m := c.read(); m.Method != "mining.notify" || json.Unmarshal(m.Params[0], &jobID) != nil || string(m.Params[5]) != "true" {
+		t.Fatalf("after authorizing: %s %s, want a clean job", m.Method, m.Params)
+	}
+
+	// Find a nonce below the share difficulty, a share that solves no block, and one that does.
+	s.mux.Lock()
+	job := s.jobs[jobID]
+	s.mux.Unlock()
+	low, share, solution := -1, -1, -1
+	for nonce := 0; low < 0 || share < 0 || solution < 0; nonce++ {
+		b := *job.template.Block
+		b.SetNonce(nonce)
+		switch h := b.ProofHash(s.hasher); {
+		case block.MeetsTarget(h, job.target):
+			solution = nonce
+		case block.MeetsTarget(h, s.shareTarget):
+			share = nonce
+		default:
+			low = nonce
+		}
+	}
+	tests := []struct {
+		name   string
+		worker string
+		job    string
+		nonce  int
+		want   int
+	}{
+		{"an unauthorized worker", "other", jobID, share, STRATUM_ERR_UNAUTHORIZED},
+		{"an unknown job", "rig", "nope", share, STRATUM_ERR_JOB_NOT_FOUND},
+		{"a nonce of another session", "rig", jobID, STRATUM_NONCE_RANGE, STRATUM_ERR_OTHER},
+		{"a low difficulty share", "rig", jobID, low, STRATUM_ERR_LOW_DIFFICULTY},
+		{"a share", "rig", jobID, share, 0},
+		{"the share again", "rig", jobID, share, STRATUM_ERR_DUPLICATE},
+	}
+	for _, tt := range tests {
+		if m := c.call("mining.submit", tt.worker, tt.job, tt.nonce); m.errorCode() != tt.want {
+			t.Errorf("mining.submit of %s: %+v, want error %d", tt.name, m.Error, tt.want)
+		}
+	}
+	if n := len(bc.Chain()); n != 1 {
+		t.Fatalf("%d blocks before a share met the block difficulty, want 1", n)
+	}
+	if m := c.call("mining.submit", "rig", jobID, solution); m.Error != nil {
+		t.Fatalf("mining.submit of a block: %+v", m.Error)
+	}
+	if n := len(bc.Chain()); n != 2 || len(bc.TransactionPool()) != 0 {
+		t.Fatalf("%d blocks with %d pooled transactions after the block was found, want 2 and none", n, len(bc.TransactionPool()))
+	}
+	if m := c.call("mining.nope"); m.errorCode() != RPC_METHOD_NOT_FOUND {
+		t.Fatalf("unknown method: %+v, want error %d", m.Error, RPC_METHOD_NOT_FOUND)
+	}
+	if stats := s.Workers(); len(stats) != 1 || stats[0].Accepted != 2 || stats[0].Rejected != 4 || stats[0].Blocks != 1 {
+		t.Fatalf("Workers = %+v, want rig with 2 accepted shares, 4 rejected, and 1 block", stats)
+	}
+}
+
+func TestStratumWorkersMineBlocksForTheNode(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(t, bc, miner, w, transaction.COIN/4, 0)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s := NewStratumServer(bc, 0)
+	go s.Serve(ctx, ln)
+	done := make(chan error, 1)
+	go func() { done <- RunStratumWorker(ctx, ln.Addr().String(), "rig", 2) }()
+	for len(bc.Chain()) < 2 {
+		select {
+		case err := <-done:
+			t.Fatalf("the worker stopped before finding a block: %v", err)
+		case <-ctx.Done():
+			t.Fatal("no block within 10s")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("RunStratumWorker = %v after cancelling", err)
+	}
+	if stats := s.Workers(); len(stats) != 1 || stats[0].Blocks != 1 {
+		t.Fatalf("Workers = %+v, want rig with the block", stats)
+	}
+}
@@ -0,0 +1,172 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+var (
+	ErrUnknownToken             = errors.New("no such token")
+	ErrTokenExists              = errors.New("token symbol already registered")
+	ErrInsufficientTokenBalance = errors.New("insufficient token balance")
+)
+
+// Token is a registered token: its issuer, the supply it created, and every holder's balance.
+type Token struct {
+	symbol   string
+	issuer   string
+	supply   int64
+	balances map[string]int64
+}
+
+// MarshalJSON encodes the token as {"symbol", "issuer", "supply", "balances"}.
+func (tok *Token) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Symbol   string           `json:"symbol"`
+		Issuer   string           `json:"issuer"`
+		Supply   int64            `json:"supply"`
+		Balances map[string]int64 `json:"balances"`
+	}{tok.symbol, tok.issuer, tok.supply, tok.balances})
+}
+
+// UnmarshalJSON decodes a token written by MarshalJSON.
+func (tok *Token) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Symbol   string           `json:"symbol"`
+		Issuer   string           `json:"issuer"`
+		Supply   int64            `json:"supply"`
+		Balances map[string]int64 `json:"balances"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	tok.symbol, tok.issuer, tok.supply, tok.balances = v.Symbol, v.Issuer, v.Supply, v.Balances
+	if tok.balances == nil {
+		tok.balances = make(map[string]int64)
+	}
+	return nil
+}
+
+// cloneTokens copies tokens deeply, so applying blocks to the copy leaves them as they are.
+func cloneTokens(tokens map[string]*Token) map[string]*Token {
+	clone := make(map[string]*Token, len(tokens))
+	for s, tok := range tokens {
+		c := *tok
+		c.balances = maps.Clone(tok.balances)
+		clone[s] = &c
+	}
+	return clone
+}
+
+// applyToken applies token transaction t to tokens.
+func applyToken(tokens map[string]*Token, t *transaction.Transaction) {
+	op := t.Token()
+	if op.Kind() == transaction.TOKEN_CREATE {
+		tokens[op.Symbol()] = &Token{symbol: op.Symbol(), issuer: t.SenderBlockchainAddress(), supply: op.Amount(), balances: map[string]int64{t.SenderBlockchainAddress(): op.Amount()}}
+		return
+	}
+	tok := tokens[op.Symbol()]
+	tok.balances[t.RecipientBlockchainAddress()] += op.Amount()
+	if tok.balances[t.SenderBlockchainAddress()] -= op.Amount(); tok.balances[t.SenderBlockchainAddress()] == 0 {
+		delete(tok.balances, t.SenderBlockchainAddress())
+	}
+}
+
+// tokenSpends tracks the symbols that earlier transactions of a block, or of the pool, register and the
+// units they spend, on top of the confirmed tokens. Units received in the same block are not spendable
+// until it is confirmed, nor is a token registered in it.
+type tokenSpends struct {
+	tokens  map[string]*Token
+	created map[string]bool
+	spent   map[[2]string]int64
+}
+
+func newTokenSpends(tokens map[string]*Token) *tokenSpends {
+	return &tokenSpends{tokens: tokens, created: make(map[string]bool), spent: make(map[[2]string]int64)}
+}
+
+// admit returns why token transaction t cannot follow the ones admitted before, or records it and returns nil.
+func (s *tokenSpends) admit(t *transaction.Transaction) error {
+	op := t.Token()
+	if op.Kind() == transaction.TOKEN_CREATE {
+		if _, ok := s.tokens[op.Symbol()]; ok || s.created[op.Symbol()] {
+			return fmt.Errorf("%w: %s", ErrTokenExists, op.Symbol())
+		}
+		s.created[op.Symbol()] = true
+		return nil
+	}
+	tok, ok := s.tokens[op.Symbol()]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownToken, op.Symbol())
+	}
+	key := [2]string{op.Symbol(), t.SenderBlockchainAddress()}
+	if tok.balances[t.SenderBlockchainAddress()]-s.spent[key] < op.Amount() {
+		return ErrInsufficientTokenBalance
+	}
+	s.spent[key] += op.Amount()
+	return nil
+}
+
+// admitToken returns why token transaction t cannot join the pool after the token transactions already in
+// it, or nil. Callers must hold mux.
+func (bc *Blockchain) admitToken(t *transaction.Transaction) error {
+	spends := newTokenSpends(bc.tokens)
+	for _, p := range bc.transactionPool {
+		if p.Token() != nil {
+			spends.admit(p)
+		}
+	}
+	return spends.admit(t)
+}
+
+// TokenInfo is a token's registration and how many addresses hold it.
+type TokenInfo struct {
+	Symbol  string `json:"symbol"`
+	Issuer  string `json:"issuer"`
+	Supply  int64  `json:"supply"`
+	Holders int    `json:"holders"`
+}
+
+// Tokens returns every registered token, by symbol.
+func (bc *Blockchain) Tokens() []TokenInfo {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	infos := make([]TokenInfo, 0, len(bc.tokens))
+	for _, symbol := range slices.Sorted(maps.Keys(bc.tokens)) {
+		tok := bc.tokens[symbol]
+		infos = append(infos, TokenInfo{Symbol: symbol, Issuer: tok.issuer, Supply: tok.supply, Holders: len(tok.balances)})
+	}
+	return infos
+}
+
+// Token returns a copy of the token registered with symbol, holders included, or false if there is none.
+func (bc *Blockchain) Token(symbol string) (*Token, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	tok, ok := bc.tokens[symbol]
+	if !ok {
+		return nil, false
+	}
+	c := *tok
+	c.balances = maps.Clone(tok.balances)
+	return &c, true
+}
+
+// CalculateTokenAmounts returns the confirmed units of every token the address holds, by symbol, the
+// token counterpart of CalculateTotalAmount.
+func (bc *Blockchain) CalculateTokenAmounts(blockchainAddress string) map[string]int64 {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	amounts := make(map[string]int64)
+	for symbol, tok := range bc.tokens {
+		if units, ok := tok.balances[blockchainAddress]; ok {
+			amounts[symbol] = units
+		}
+	}
+	return amounts
+}
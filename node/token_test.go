@@ -0,0 +1,111 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+// signed signs tx from w with its next nonce on bc, without adding it to the pool.
+func signed(t *testing.T, bc *Blockchain, w *wallet.Wallet, tx *transaction.Transaction) *transaction.Transaction {
+	t.Helper()
+	tx.SetNonce(bc.NextNonce(w.BlockchainAddress()))
+	tx.SetChainID(bc.ChainID())
+	if err := w.SignTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestTokensAreIssuedAndTransferredAlongsideCoins(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	issuer := fund(t, bc, miner, transaction.COIN)
+	bob, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(signed(t, bc, issuer, transaction.NewTokenCreation(issuer.BlockchainAddress(), "GOLD", 1000, transaction.TOKEN_CREATE_FEE))); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(signed(t, bc, miner, transaction.NewTokenCreation(miner.BlockchainAddress(), "GOLD", 5, transaction.TOKEN_CREATE_FEE))); !errors.Is(err, ErrTokenExists) {
+		t.Fatalf("AddTransaction registering a pending symbol = %v, want %v", err, ErrTokenExists)
+	}
+	// Units of a token registered in the same block are not spendable until it is confirmed.
+	if err := bc.AddTransaction(signed(t, bc, issuer, transaction.NewTokenTransfer(issuer.BlockchainAddress(), bob.BlockchainAddress(), "GOLD", 1, 0))); !errors.Is(err, ErrUnknownToken) {
+		t.Fatalf("AddTransaction of an unconfirmed token = %v, want %v", err, ErrUnknownToken)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	if err := bc.AddTransaction(signed(t, bc, miner, transaction.NewTokenCreation(miner.BlockchainAddress(), "GOLD", 5, transaction.TOKEN_CREATE_FEE))); !errors.Is(err, ErrTokenExists) {
+		t.Fatalf("AddTransaction registering a taken symbol = %v, want %v", err, ErrTokenExists)
+	}
+
+	if err := bc.AddTransaction(signed(t, bc, issuer, transaction.NewTokenTransfer(issuer.BlockchainAddress(), bob.BlockchainAddress(), "GOLD", 600, 0))); err != nil {
+		t.Fatal(err)
+	}
+	// The pending transfer counts against the issuer's 1000 units.
+	if err := bc.AddTransaction(signed(t, bc, issuer, transaction.NewTokenTransfer(issuer.BlockchainAddress(), bob.BlockchainAddress(), "GOLD", 401, 0))); !errors.Is(err, ErrInsufficientTokenBalance) {
+		t.Fatalf("AddTransaction overspending pending units = %v, want %v", err, ErrInsufficientTokenBalance)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	for _, tt := range []struct {
+		holder *wallet.Wallet
+		want   map[string]int64
+	}{{issuer, map[string]int64{"GOLD": 400}}, {bob, map[string]int64{"GOLD": 600}}, {miner, map[string]int64{}}} {
+		if got := bc.CalculateTokenAmounts(tt.holder.BlockchainAddress()); !maps.Equal(got, tt.want) {
+			t.Errorf("CalculateTokenAmounts = %v, want %v", got, tt.want)
+		}
+	}
+	if got := bc.CalculateTotalAmount(issuer.BlockchainAddress()); got != transaction.COIN-transaction.TOKEN_CREATE_FEE {
+		t.Fatalf("issuer coins = %s; only the creation fee should be spent", got)
+	}
+
+	if err := bc.AddTransaction(signed(t, bc, issuer, transaction.NewTokenTransfer(issuer.BlockchainAddress(), bob.BlockchainAddress(), "GOLD", 400, 0))); err != nil {
+		t.Fatal(err)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	tokens := bc.Tokens()
+	if want := (TokenInfo{Symbol: "GOLD", Issuer: issuer.BlockchainAddress(), Supply: 1000, Holders: 1}); len(tokens) != 1 || tokens[0] != want {
+		t.Fatalf("Tokens = %+v, want only %+v once the issuer holds none", tokens, want)
+	}
+}
+
+func TestCheckBlockRejectsTokenOverspends(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	issuer := fund(t, bc, miner, transaction.COIN)
+	if err := bc.AddTransaction(signed(t, bc, issuer, transaction.NewTokenCreation(issuer.BlockchainAddress(), "GOLD", 10, transaction.TOKEN_CREATE_FEE))); err != nil {
+		t.Fatal(err)
+	}
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+
+	first := signed(t, bc, issuer, transaction.NewTokenTransfer(issuer.BlockchainAddress(), miner.BlockchainAddress(), "GOLD", 6, 0))
+	second := transaction.NewTokenTransfer(issuer.BlockchainAddress(), miner.BlockchainAddress(), "GOLD", 5, 0)
+	second.SetNonce(first.Nonce() + 1)
+	second.SetChainID(bc.ChainID())
+	if err := issuer.SignTransaction(second); err != nil {
+		t.Fatal(err)
+	}
+	coinbase := transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	b := sealBlock(t, bc, []*transaction.Transaction{first, second, coinbase})
+	if err := bc.checkBlock(bc.chain, b, bc.tipState(), bc.rules()); !errors.Is(err, ErrInsufficientTokenBalance) {
+		t.Fatalf("checkBlock spending 11 of 10 units = %v, want %v", err, ErrInsufficientTokenBalance)
+	}
+	b = sealBlock(t, bc, []*transaction.Transaction{first, coinbase})
+	if err := bc.checkBlock(bc.chain, b, bc.tipState(), bc.rules()); err != nil {
+		t.Fatalf("checkBlock spending 6 of 10 units = %v", err)
+	}
+}
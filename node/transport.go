@@ -0,0 +1,77 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/dikako/how-blockchain-works/internal/httputil"
+)
+
+const TRANSPORT_TCP = "tcp"
+
+var ErrUnknownTransport = errors.New("unknown transport")
+
+// PeerTransport carries the node API between peers. Node-to-node traffic stays HTTP requests whatever the
+// transport; only how connections are accepted and opened changes.
+type PeerTransport interface {
+	// Listen returns the listener the node API is served on; addr is the node's host:port.
+	Listen(addr string) (net.Listener, error)
+	// DialContext opens a connection to the peer at addr, the host:port of a neighbor URL.
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// TCPTransport is the default transport: plain TCP connections to host:port addresses.
+type TCPTransport struct {
+	dialer net.Dialer
+}
+
+func (t *TCPTransport) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+func (t *TCPTransport) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return t.dialer.DialContext(ctx, network, addr)
+}
+
+var (
+	transportsMux sync.Mutex
+	transports    = map[string]func() (PeerTransport, error){
+		TRANSPORT_TCP: func() (PeerTransport, error) { return &TCPTransport{}, nil },
+	}
+)
+
+// RegisterTransport makes a transport selectable by name with -transport. Backends register themselves from
+// an init function in a file of their own.
+func RegisterTransport(name string, factory func() (PeerTransport, error)) {
+	transportsMux.Lock()
+	defer transportsMux.Unlock()
+	transports[name] = factory
+}
+
+// NewTransportByName creates the transport registered under name.
+func NewTransportByName(name string) (PeerTransport, error) {
+	transportsMux.Lock()
+	factory, ok := transports[name]
+	names := make([]string, 0, len(transports))
+	for n := range transports {
+		names = append(names, n)
+	}
+	transportsMux.Unlock()
+	if !ok {
+		sort.Strings(names)
+		return nil, fmt.Errorf("%w %q (available: %v)", ErrUnknownTransport, name, names)
+	}
+	return factory()
+}
+
+// newPeerClient returns a client for requests to neighbors that opens its connections through t.
+func newPeerClient(t PeerTransport, insecureSkipVerify bool) *http.Client {
+	client := httputil.NewHTTPClient(NEIGHBOR_REQUEST_TIMEOUT, insecureSkipVerify)
+	client.Transport.(*http.Transport).DialContext = t.DialContext
+	return client
+}
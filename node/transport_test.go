@@ -0,0 +1,69 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// countingTransport is a TCPTransport that counts the connections it opens.
+type countingTransport struct {
+	TCPTransport
+	dials atomic.Int32
+}
+
+func (t *countingTransport) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	t.dials.Add(1)
+	return t.TCPTransport.DialContext(ctx, network, addr)
+}
+
+func TestTransportsAreSelectedByName(t *testing.T) {
+	if tr, err := NewTransportByName(TRANSPORT_TCP); err != nil {
+		t.Fatal(err)
+	} else if _, ok := tr.(*TCPTransport); !ok {
+		t.Fatalf("NewTransportByName(%q) = %T, want *TCPTransport", TRANSPORT_TCP, tr)
+	}
+	if _, err := NewTransportByName("carrier-pigeon"); !errors.Is(err, ErrUnknownTransport) {
+		t.Fatalf("NewTransportByName of an unknown name = %v, want %v", err, ErrUnknownTransport)
+	}
+
+	counting := &countingTransport{}
+	RegisterTransport("counting", func() (PeerTransport, error) { return counting, nil })
+	if tr, err := NewTransportByName("counting"); err != nil || tr != counting {
+		t.Fatalf(`NewTransportByName("counting") = %v, %v, want the registered transport`, tr, err)
+	}
+}
+
+func TestNeighborRequestsDialThroughTheTransport(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	longer, other := newTestBlockchain(t)
+	fund(t, longer, other, transaction.COIN/2)
+
+	counting := &countingTransport{}
+	bc.SetTransport(counting)
+	if bc.Transport() != counting {
+		t.Fatal("Transport does not return the transport set")
+	}
+	setNeighbors(bc, serveNeighbor(t, longer))
+	if !bc.ResolveConflicts(context.Background()) {
+		t.Fatal("ResolveConflicts did not adopt the neighbor's chain")
+	}
+	if counting.dials.Load() == 0 {
+		t.Fatal("the neighbor request did not dial through the transport")
+	}
+
+	ln, err := counting.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn, err := counting.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial the transport's own listener: %v", err)
+	}
+	conn.Close()
+}
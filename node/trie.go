@@ -0,0 +1,552 @@
+package node
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// Trie node kinds, the first byte of a node's encoding.
+const (
+	TRIE_LEAF      byte = 0
+	TRIE_EXTENSION byte = 1
+	TRIE_BRANCH    byte = 2
+)
+
+var (
+	ErrInvalidStateProof = errors.New("invalid state proof")
+	ErrNoAccountsRoot    = errors.New("block has no accounts root")
+)
+
+// trieNode is a node of a stateTrie, in the manner of Ethereum's Merkle Patricia trie. Paths are in
+// nibbles, half-bytes of the key. A leaf holds the rest of its key's path and the value; an extension, a
+// run of nibbles that every key below it shares; and a branch, one child per next nibble. Every node is
+// hashed, and a node commits to its children by their hashes, so the root hash commits to every key and value.
+type trieNode struct {
+	kind     byte
+	path     []byte
+	value    []byte
+	child    *trieNode     // an extension's
+	children [16]*trieNode // a branch's, by nibble
+	hash     [32]byte
+}
+
+// stateTrie is a Merkle Patricia trie from 32-byte keys to values. It never changes: put and remove return
+// a new trie that shares every node off the changed key's path with the old one, so keeping the trie after
+// each block costs only the nodes each block changes. The nil trie is empty.
+type stateTrie struct {
+	root *trieNode
+}
+
+// encodeTrieNode returns the bytes a node's hash is taken over: its kind, then for a leaf or an extension
+// its path as a uvarint length and one byte per nibble, followed by the leaf's value or the extension's
+// child hash; for a branch, a 16-bit big-endian mask of the nibbles it has children for and their hashes.
+func encodeTrieNode(n *trieNode) []byte {
+	m := []byte{n.kind}
+	switch n.kind {
+	case TRIE_LEAF:
+		m = append(binary.AppendUvarint(m, uint64(len(n.path))), n.path...)
+		return append(m, n.value...)
+	case TRIE_EXTENSION:
+		m = append(binary.AppendUvarint(m, uint64(len(n.path))), n.path...)
+		return append(m, n.child.hash[:]...)
+	}
+	var mask uint16
+	for i, c := range n.children {
+		if c != nil {
+			mask |= 1 << i
+		}
+	}
+	m = binary.BigEndian.AppendUint16(m, mask)
+	for _, c := range n.children {
+		if c != nil {
+			m = append(m, c.hash[:]...)
+		}
+	}
+	return m
+}
+
+// hashed returns n with its hash set.
+func hashed(n *trieNode) *trieNode {
+	n.hash = sha256.Sum256(encodeTrieNode(n))
+	return n
+}
+
+func newTrieLeaf(path, value []byte) *trieNode {
+	return hashed(&trieNode{kind: TRIE_LEAF, path: path, value: value})
+}
+
+// newTrieExtension returns child under path, or child itself if path is empty.
+func newTrieExtension(path []byte, child *trieNode) *trieNode {
+	if len(path) == 0 {
+		return child
+	}
+	return hashed(&trieNode{kind: TRIE_EXTENSION, path: path, child: child})
+}
+
+func newTrieBranch(children [16]*trieNode) *trieNode {
+	return hashed(&trieNode{kind: TRIE_BRANCH, children: children})
+}
+
+// joinTriePath returns a new slice of a followed by b.
+func joinTriePath(a, b []byte) []byte {
+	return append(append(make([]byte, 0, len(a)+len(b)), a...), b...)
+}
+
+// triePrefixLen returns how many nibbles a and b share at their start.
+func triePrefixLen(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// trieNibbles returns the nibbles of key, high half first.
+func trieNibbles(key [32]byte) []byte {
+	nibbles := make([]byte, 0, 2*len(key))
+	for _, b := range key {
+		nibbles = append(nibbles, b>>4, b&0x0f)
+	}
+	return nibbles
+}
+
+// Root returns the root hash, all zeros for the empty trie.
+func (t *stateTrie) Root() [32]byte {
+	if t == nil || t.root == nil {
+		return [32]byte{}
+	}
+	return t.root.hash
+}
+
+// Get returns the value of key, or false if the trie has none.
+func (t *stateTrie) Get(key [32]byte) ([]byte, bool) {
+	var n *trieNode
+	if t != nil {
+		n = t.root
+	}
+	path := trieNibbles(key)
+	for n != nil {
+		switch n.kind {
+		case TRIE_LEAF:
+			if !bytes.Equal(path, n.path) {
+				return nil, false
+			}
+			return n.value, true
+		case TRIE_EXTENSION:
+			if !bytes.HasPrefix(path, n.path) {
+				return nil, false
+			}
+			path, n = path[len(n.path):], n.child
+		default:
+			path, n = path[1:], n.children[path[0]]
+		}
+	}
+	return nil, false
+}
+
+// Put returns the trie with key set to value.
+func (t *stateTrie) Put(key [32]byte, value []byte) *stateTrie {
+	var root *trieNode
+	if t != nil {
+		root = t.root
+	}
+	return &stateTrie{root: triePut(root, trieNibbles(key), value)}
+}
+
+// Remove returns the trie without key.
+func (t *stateTrie) Remove(key [32]byte) *stateTrie {
+	if t == nil {
+		return nil
+	}
+	return &stateTrie{root: trieRemove(t.root, trieNibbles(key))}
+}
+
+// triePut returns n with the key at path below it set to value. All keys are as long, so no key's path
+// ends inside another's.
+func triePut(n *trieNode, path, value []byte) *trieNode {
+	if n == nil {
+		return newTrieLeaf(path, value)
+	}
+	switch n.kind {
+	case TRIE_LEAF:
+		if bytes.Equal(path, n.path) {
+			return newTrieLeaf(path, value)
+		}
+		c := triePrefixLen(path, n.path)
+		var children [16]*trieNode
+		children[n.path[c]] = newTrieLeaf(n.path[c+1:], n.value)
+		children[path[c]] = newTrieLeaf(path[c+1:], value)
+		return newTrieExtension(path[:c], newTrieBranch(children))
+	case TRIE_EXTENSION:
+		c := triePrefixLen(path, n.path)
+		if c == len(n.path) {
+			return newTrieExtension(n.path, triePut(n.child, path[c:], value))
+		}
+		var children [16]*trieNode
+		children[n.path[c]] = newTrieExtension(n.path[c+1:], n.child)
+		children[path[c]] = newTrieLeaf(path[c+1:], value)
+		return newTrieExtension(path[:c], newTrieBranch(children))
+	}
+	children := n.children
+	children[path[0]] = triePut(children[path[0]], path[1:], value)
+	return newTrieBranch(children)
+}
+
+// trieRemove returns n without the key at path below it, or nil if nothing is left. A branch left with one
+// child merges into it, so the trie has the same shape, and root, as one built with only the keys left.
+func trieRemove(n *trieNode, path []byte) *trieNode {
+	if n == nil {
+		return nil
+	}
+	switch n.kind {
+	case TRIE_LEAF:
+		if bytes.Equal(path, n.path) {
+			return nil
+		}
+		return n
+	case TRIE_EXTENSION:
+		if !bytes.HasPrefix(path, n.path) {
+			return n
+		}
+		child := trieRemove(n.child, path[len(n.path):])
+		if child == n.child {
+			return n
+		}
+		return trieJoin(n.path, child)
+	}
+	child := trieRemove(n.children[path[0]], path[1:])
+	if child == n.children[path[0]] {
+		return n
+	}
+	children := n.children
+	children[path[0]] = child
+	only, count := 0, 0
+	for i, c := range children {
+		if c != nil {
+			only, count = i, count+1
+		}
+	}
+	if count > 1 {
+		return newTrieBranch(children)
+	}
+	return trieJoin([]byte{byte(only)}, children[only])
+}
+
+// trieJoin returns child under the nibbles prefix, merging them into its own path unless it is a branch.
+func trieJoin(prefix []byte, child *trieNode) *trieNode {
+	switch {
+	case child == nil:
+		return nil
+	case child.kind == TRIE_LEAF:
+		return newTrieLeaf(joinTriePath(prefix, child.path), child.value)
+	case child.kind == TRIE_EXTENSION:
+		return newTrieExtension(joinTriePath(prefix, child.path), child.child)
+	}
+	return newTrieExtension(prefix, child)
+}
+
+// Prove returns the encodings of the nodes from the root down to key's leaf, or down to where key's path
+// leaves the trie if it has no value, which VerifyStateProof checks against the root.
+func (t *stateTrie) Prove(key [32]byte) [][]byte {
+	proof := make([][]byte, 0)
+	var n *trieNode
+	if t != nil {
+		n = t.root
+	}
+	path := trieNibbles(key)
+	for n != nil {
+		proof = append(proof, encodeTrieNode(n))
+		switch n.kind {
+		case TRIE_LEAF:
+			return proof
+		case TRIE_EXTENSION:
+			if !bytes.HasPrefix(path, n.path) {
+				return proof
+			}
+			path, n = path[len(n.path):], n.child
+		default:
+			path, n = path[1:], n.children[path[0]]
+		}
+	}
+	return proof
+}
+
+// VerifyStateProof checks proof, from Prove, against root and returns the value of key, or false if the
+// proof shows the trie has none. It returns ErrInvalidStateProof if the proof does not hash up to root.
+func VerifyStateProof(root [32]byte, key [32]byte, proof [][]byte) ([]byte, bool, error) {
+	if root == ([32]byte{}) {
+		if len(proof) != 0 {
+			return nil, false, fmt.Errorf("%w: nodes for an empty trie", ErrInvalidStateProof)
+		}
+		return nil, false, nil
+	}
+	path := trieNibbles(key)
+	want := root
+	for i, m := range proof {
+		if sha256.Sum256(m) != want || len(m) == 0 {
+			return nil, false, fmt.Errorf("%w: node %d does not match its hash", ErrInvalidStateProof, i)
+		}
+		last := i == len(proof)-1
+		switch m[0] {
+		case TRIE_LEAF, TRIE_EXTENSION:
+			length, k := binary.Uvarint(m[1:])
+			if k <= 0 || length > uint64(len(m)-1-k) {
+				return nil, false, fmt.Errorf("%w: malformed node %d", ErrInvalidStateProof, i)
+			}
+			nodePath, rest := m[1+k:1+k+int(length)], m[1+k+int(length):]
+			if m[0] == TRIE_LEAF {
+				if !last {
+					return nil, false, fmt.Errorf("%w: nodes after a leaf", ErrInvalidStateProof)
+				}
+				if !bytes.Equal(path, nodePath) {
+					return nil, false, nil
+				}
+				return rest, true, nil
+			}
+			if len(rest) != 32 {
+				return nil, false, fmt.Errorf("%w: malformed node %d", ErrInvalidStateProof, i)
+			}
+			if !bytes.HasPrefix(path, nodePath) {
+				if !last {
+					return nil, false, fmt.Errorf("%w: nodes after the key leaves the trie", ErrInvalidStateProof)
+				}
+				return nil, false, nil
+			}
+			path, want = path[len(nodePath):], [32]byte(rest)
+		case TRIE_BRANCH:
+			if len(m) < 3 || len(path) == 0 {
+				return nil, false, fmt.Errorf("%w: malformed node %d", ErrInvalidStateProof, i)
+			}
+			mask := binary.BigEndian.Uint16(m[1:])
+			hashes := m[3:]
+			if len(hashes) != 32*bits.OnesCount16(mask) {
+				return nil, false, fmt.Errorf("%w: malformed node %d", ErrInvalidStateProof, i)
+			}
+			nibble := path[0]
+			if mask&(1<<nibble) == 0 {
+				if !last {
+					return nil, false, fmt.Errorf("%w: nodes after the key leaves the trie", ErrInvalidStateProof)
+				}
+				return nil, false, nil
+			}
+			at := 32 * bits.OnesCount16(mask&(1<<nibble-1))
+			path, want = path[1:], [32]byte(hashes[at:at+32])
+		default:
+			return nil, false, fmt.Errorf("%w: unknown node kind %d", ErrInvalidStateProof, m[0])
+		}
+	}
+	return nil, false, fmt.Errorf("%w: the proof ends before the key's leaf", ErrInvalidStateProof)
+}
+
+// accountKey returns the trie key of address, its SHA-256, so keys spread evenly and are all as long.
+func accountKey(address string) [32]byte {
+	return sha256.Sum256([]byte(address))
+}
+
+// encodeAccount returns the trie value of an account: its balance and nonce, 8 bytes big-endian each.
+func encodeAccount(balance transaction.Amount, nonce uint64) []byte {
+	return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, uint64(balance)), nonce)
+}
+
+// decodeAccount returns the balance and nonce encodeAccount encoded in value.
+func decodeAccount(value []byte) (transaction.Amount, uint64, error) {
+	if len(value) != 16 {
+		return 0, 0, fmt.Errorf("%w: account value of %d bytes", ErrInvalidStateProof, len(value))
+	}
+	return transaction.Amount(binary.BigEndian.Uint64(value)), binary.BigEndian.Uint64(value[8:]), nil
+}
+
+// withAccounts returns t with the accounts of addresses set to their balances and nonces. An account with
+// neither coins nor a nonce is left out, as is MINING_SENDER, which pays the coinbases and so only goes
+// negative.
+func (t *stateTrie) withAccounts(balances map[string]transaction.Amount, nonces map[string]uint64, addresses []string) *stateTrie {
+	for _, address := range addresses {
+		if address == transaction.MINING_SENDER {
+			continue
+		}
+		if balance, nonce := balances[address], nonces[address]; balance != 0 || nonce != 0 {
+			t = t.Put(accountKey(address), encodeAccount(balance, nonce))
+		} else {
+			t = t.Remove(accountKey(address))
+		}
+	}
+	return t
+}
+
+// newAccountsTrie returns the trie of every account in balances and nonces, as after a snapshot.
+func newAccountsTrie(balances map[string]transaction.Amount, nonces map[string]uint64) *stateTrie {
+	addresses := make([]string, 0, len(balances)+len(nonces))
+	for address := range balances {
+		addresses = append(addresses, address)
+	}
+	for address := range nonces {
+		if _, ok := balances[address]; !ok {
+			addresses = append(addresses, address)
+		}
+	}
+	return (*stateTrie)(nil).withAccounts(balances, nonces, addresses)
+}
+
+// touchedAccounts returns the addresses whose balance or nonce transactions change: their senders and
+// recipients, and the contracts they deploy.
+func touchedAccounts(transactions []*transaction.Transaction) []string {
+	seen := make(map[string]bool)
+	addresses := make([]string, 0, 2*len(transactions))
+	for _, t := range transactions {
+		for _, address := range []string{t.SenderBlockchainAddress(), t.RecipientBlockchainAddress()} {
+			if !seen[address] {
+				seen[address] = true
+				addresses = append(addresses, address)
+			}
+		}
+		if t.ContractType() == transaction.CONTRACT_DEPLOY && !seen[t.ContractAddress()] {
+			seen[t.ContractAddress()] = true
+			addresses = append(addresses, t.ContractAddress())
+		}
+	}
+	return addresses
+}
+
+// blockAccountsRoot returns the root of accounts after transactions are applied to it and to the state
+// after it, balances, nonces, and contracts, which it leaves as they are. Only the accounts the
+// transactions touch are copied.
+func blockAccountsRoot(accounts *stateTrie, balances map[string]transaction.Amount, nonces map[string]uint64, contracts map[string]*Contract, transactions []*transaction.Transaction) *[32]byte {
+	touched := touchedAccounts(transactions)
+	touchedBalances := make(map[string]transaction.Amount, len(touched))
+	touchedNonces := make(map[string]uint64, len(touched))
+	for _, address := range touched {
+		touchedBalances[address] = balances[address]
+		touchedNonces[address] = nonces[address]
+	}
+	for _, t := range transactions {
+		if t.ContractType() != "" {
+			// Calls pay out what their code decides, which depends on the contracts' storage.
+			contracts = cloneContracts(contracts)
+			break
+		}
+	}
+	applyTransactions(touchedBalances, contracts, nil, nil, transactions)
+	applyNonces(touchedNonces, transactions)
+	root := accounts.withAccounts(touchedBalances, touchedNonces, touched).Root()
+	return &root
+}
+
+// AccountProof proves an address's balance and nonce after the block at Height, against the block's
+// accounts root: Nodes are the trie nodes from the root down to the account, or down to where its key
+// leaves the trie if the address has no account, in which case Exists is false.
+type AccountProof struct {
+	Address      string
+	Height       int
+	BlockHash    [32]byte
+	AccountsRoot [32]byte
+	Exists       bool
+	Balance      transaction.Amount
+	Nonce        uint64
+	Nodes        [][]byte
+}
+
+// Verify checks that p's nodes hash up to its accounts root and hold its balance and nonce. It does not
+// check that the root is the block's; compare AccountsRoot with the header of a block you trust.
+func (p *AccountProof) Verify() error {
+	value, ok, err := VerifyStateProof(p.AccountsRoot, accountKey(p.Address), p.Nodes)
+	if err != nil {
+		return err
+	}
+	if ok != p.Exists {
+		return fmt.Errorf("%w: the account's existence differs", ErrInvalidStateProof)
+	}
+	if !ok {
+		if p.Balance != 0 || p.Nonce != 0 {
+			return fmt.Errorf("%w: balance or nonce without an account", ErrInvalidStateProof)
+		}
+		return nil
+	}
+	balance, nonce, err := decodeAccount(value)
+	if err != nil {
+		return err
+	}
+	if balance != p.Balance || nonce != p.Nonce {
+		return fmt.Errorf("%w: the account holds %s with nonce %d", ErrInvalidStateProof, balance, nonce)
+	}
+	return nil
+}
+
+// AccountProof returns the proof of address's balance and nonce after the tip, or ErrNoAccountsRoot if the
+// tip commits to no accounts, as on chains from before the accounts trie.
+func (bc *Blockchain) AccountProof(address string) (*AccountProof, error) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	tip := bc.lastBlock()
+	if tip.AccountsRoot() == nil {
+		return nil, ErrNoAccountsRoot
+	}
+	key := accountKey(address)
+	p := &AccountProof{Address: address, Height: len(bc.chain) - 1, BlockHash: bc.BlockHash(tip), AccountsRoot: *tip.AccountsRoot(), Nodes: bc.accounts.Prove(key)}
+	if value, ok := bc.accounts.Get(key); ok {
+		balance, nonce, err := decodeAccount(value)
+		if err != nil {
+			return nil, err
+		}
+		p.Exists, p.Balance, p.Nonce = true, balance, nonce
+	}
+	return p, nil
+}
+
+// MarshalJSON provides a custom JSON representation for AccountProof fields, with hashes and nodes in hex.
+func (p *AccountProof) MarshalJSON() ([]byte, error) {
+	nodes := make([]string, len(p.Nodes))
+	for i, n := range p.Nodes {
+		nodes[i] = hex.EncodeToString(n)
+	}
+	return json.Marshal(struct {
+		Address      string             `json:"blockchain_address"`
+		Height       int                `json:"height"`
+		BlockHash    string             `json:"block_hash"`
+		AccountsRoot string             `json:"accounts_root"`
+		Exists       bool               `json:"exists"`
+		Balance      transaction.Amount `json:"balance"`
+		Nonce        uint64             `json:"nonce"`
+		Nodes        []string           `json:"nodes"`
+	}{p.Address, p.Height, fmt.Sprintf("%x", p.BlockHash), fmt.Sprintf("%x", p.AccountsRoot), p.Exists, p.Balance, p.Nonce, nodes})
+}
+
+// UnmarshalJSON restores an AccountProof from the representation produced by MarshalJSON.
+func (p *AccountProof) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Address      string             `json:"blockchain_address"`
+		Height       int                `json:"height"`
+		BlockHash    string             `json:"block_hash"`
+		AccountsRoot string             `json:"accounts_root"`
+		Exists       bool               `json:"exists"`
+		Balance      transaction.Amount `json:"balance"`
+		Nonce        uint64             `json:"nonce"`
+		Nodes        []string           `json:"nodes"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	blockHash, err := transaction.HashFromString(v.BlockHash)
+	if err != nil {
+		return fmt.Errorf("invalid block_hash: %w", err)
+	}
+	root, err := transaction.HashFromString(v.AccountsRoot)
+	if err != nil {
+		return fmt.Errorf("invalid accounts_root: %w", err)
+	}
+	nodes := make([][]byte, len(v.Nodes))
+	for i, n := range v.Nodes {
+		if nodes[i], err = hex.DecodeString(n); err != nil {
+			return fmt.Errorf("invalid node %d: %w", i, err)
+		}
+	}
+	*p = AccountProof{Address: v.Address, Height: v.Height, BlockHash: blockHash, AccountsRoot: root, Exists: v.Exists, Balance: v.Balance, Nonce: v.Nonce, Nodes: nodes}
+	return nil
+}
@@ -0,0 +1,176 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// trieKeys returns n distinct keys.
+func trieKeys(n int) [][32]byte {
+	keys := make([][32]byte, n)
+	for i := range keys {
+		keys[i] = sha256.Sum256(fmt.Appendf(nil, "key-%d", i))
+	}
+	return keys
+}
+
+func TestStateTrieRootsCommitToTheContentsAlone(t *testing.T) {
+	keys := trieKeys(200)
+	var forward, backward *stateTrie
+	for i := range keys {
+		forward = forward.Put(keys[i], []byte{byte(i)})
+		backward = backward.Put(keys[len(keys)-1-i], []byte{byte(len(keys) - 1 - i)})
+	}
+	if forward.Root() != backward.Root() {
+		t.Fatal("the root depends on the order the keys were put in")
+	}
+	for i, key := range keys {
+		if value, ok := forward.Get(key); !ok || !bytes.Equal(value, []byte{byte(i)}) {
+			t.Fatalf("Get(key %d) = %x, %t", i, value, ok)
+		}
+	}
+
+	// Removing keys leaves the trie it was put in untouched and matches a trie built without them.
+	changed := forward.Put(keys[0], []byte("changed"))
+	if value, _ := forward.Get(keys[0]); !bytes.Equal(value, []byte{0}) || changed.Root() == forward.Root() {
+		t.Fatal("Put changed the trie it was called on")
+	}
+	removed, kept := forward, (*stateTrie)(nil)
+	for i, key := range keys {
+		if i%3 == 0 {
+			removed = removed.Remove(key)
+		} else {
+			kept = kept.Put(key, []byte{byte(i)})
+		}
+	}
+	if removed.Root() != kept.Root() {
+		t.Fatal("removing keys gives another root than never putting them")
+	}
+	if _, ok := removed.Get(keys[0]); ok {
+		t.Fatal("Get of a removed key found it")
+	}
+	if _, ok := forward.Get(keys[0]); !ok {
+		t.Fatal("Remove changed the trie it was called on")
+	}
+	for _, key := range keys {
+		removed = removed.Remove(key)
+	}
+	if removed.Root() != ([32]byte{}) {
+		t.Fatalf("root of a trie emptied = %x, want zeros", removed.Root())
+	}
+}
+
+func TestStateProofsProveValuesAndAbsence(t *testing.T) {
+	keys := trieKeys(50)
+	var trie *stateTrie
+	for i, key := range keys[:40] {
+		trie = trie.Put(key, []byte{byte(i)})
+	}
+	root := trie.Root()
+	for i, key := range keys {
+		value, ok, err := VerifyStateProof(root, key, trie.Prove(key))
+		if err != nil || ok != (i < 40) || (ok && !bytes.Equal(value, []byte{byte(i)})) {
+			t.Fatalf("VerifyStateProof of key %d = %x, %t, %v", i, value, ok, err)
+		}
+	}
+	if _, ok, err := VerifyStateProof([32]byte{}, keys[0], nil); ok || err != nil {
+		t.Fatalf("VerifyStateProof in the empty trie = %t, %v; want absent", ok, err)
+	}
+
+	proof := trie.Prove(keys[0])
+	tampered := func(f func(p [][]byte) [][]byte) [][]byte {
+		p := make([][]byte, len(proof))
+		for i := range proof {
+			p[i] = bytes.Clone(proof[i])
+		}
+		return f(p)
+	}
+	tests := []struct {
+		name  string
+		root  [32]byte
+		proof [][]byte
+	}{
+		{"another root", sha256.Sum256([]byte("root")), proof},
+		{"a changed value", root, tampered(func(p [][]byte) [][]byte { p[len(p)-1][len(p[len(p)-1])-1] ^= 1; return p })},
+		{"a missing leaf", root, proof[:len(proof)-1]},
+		{"a node after the leaf", root, append(tampered(func(p [][]byte) [][]byte { return p }), proof[0])},
+		{"nodes for the empty trie", [32]byte{}, proof},
+	}
+	for _, tt := range tests {
+		if _, _, err := VerifyStateProof(tt.root, keys[0], tt.proof); !errors.Is(err, ErrInvalidStateProof) {
+			t.Errorf("%s: VerifyStateProof = %v, want %v", tt.name, err, ErrInvalidStateProof)
+		}
+	}
+}
+
+func TestAccountProofsVerifyAgainstTheTip(t *testing.T) {
+	bcs, miner := newTestServer(t)
+	bc := bcs.GetBlockchain()
+	w := fund(t, bc, miner, transaction.COIN/2)
+	send(t, bc, w, miner, transaction.COIN/8, 0)
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+
+	var proof AccountProof
+	if rec := serve(t, bcs.AccountProof, http.MethodGet, "/account_proof?blockchain_address="+w.BlockchainAddress(), nil, &proof); rec.Code != http.StatusOK {
+		t.Fatalf("GET /account_proof = %d", rec.Code)
+	}
+	if err := proof.Verify(); err != nil {
+		t.Fatalf("Verify = %v", err)
+	}
+	tip := bc.LastBlock()
+	if proof.AccountsRoot != *tip.AccountsRoot() || proof.BlockHash != bc.BlockHash(tip) || proof.Height != 2 {
+		t.Fatalf("proof against root %x of height %d, want the tip's", proof.AccountsRoot, proof.Height)
+	}
+	if !proof.Exists || proof.Balance != transaction.COIN/2-transaction.COIN/8 || proof.Nonce != 1 {
+		t.Fatalf("proof holds %s with nonce %d, want %s with nonce 1", proof.Balance, proof.Nonce, transaction.COIN/2-transaction.COIN/8)
+	}
+	proof.Balance++
+	if err := proof.Verify(); !errors.Is(err, ErrInvalidStateProof) {
+		t.Fatalf("Verify of an inflated balance = %v, want %v", err, ErrInvalidStateProof)
+	}
+
+	absent, err := bc.AccountProof(unseen(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := absent.Verify(); err != nil || absent.Exists {
+		t.Fatalf("proof of an unseen address exists %t, Verify = %v", absent.Exists, err)
+	}
+	m, err := json.Marshal(absent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded AccountProof
+	if err := json.Unmarshal(m, &decoded); err != nil || decoded.Verify() != nil || decoded.AccountsRoot != absent.AccountsRoot {
+		t.Fatalf("proof did not round-trip through JSON: %v", err)
+	}
+	if rec := serve(t, bcs.AccountProof, http.MethodGet, "/account_proof?blockchain_address=nope", nil, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("GET /account_proof of an invalid address = %d, want 400", rec.Code)
+	}
+
+	// A block whose header commits to other balances is rejected.
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	b := sealBlock(t, bc, []*transaction.Transaction{transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)})
+	if err := bc.checkBlock(bc.chain, b, bc.tipState(), bc.rules()); err != nil {
+		t.Fatal(err)
+	}
+	wrong := sha256.Sum256([]byte("root"))
+	b.SetRoots(b.StateRoot(), &wrong)
+	if err := bc.Consensus().Seal(context.Background(), len(bc.chain), b); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.checkBlock(bc.chain, b, bc.tipState(), bc.rules()); err == nil || err.Error() != "accounts root mismatch" {
+		t.Fatalf("checkBlock of a block with a wrong accounts root = %v", err)
+	}
+}
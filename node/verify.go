@@ -0,0 +1,33 @@
+package node
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// verifySignatures verifies the signatures of transactions, all but the coinbases, through signatures on up to
+// workers goroutines (zero or less for GOMAXPROCS), and returns each one's error by index. Signatures depend
+// on nothing but their transaction, unlike balances and nonces, which depend on the transactions before
+// them, so they are the part of a block's validation that can run in parallel.
+func verifySignatures(transactions []*transaction.Transaction, signatures *SignatureCache, workers int) []error {
+	errs := make([]error, len(transactions))
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range min(workers, len(transactions)) {
+		wg.Go(func() {
+			for i := int(next.Add(1) - 1); i < len(transactions); i = int(next.Add(1) - 1) {
+				if t := transactions[i]; t.SenderBlockchainAddress() != transaction.MINING_SENDER {
+					errs[i] = signatures.Verify(t)
+				}
+			}
+		})
+	}
+	wg.Wait()
+	return errs
+}
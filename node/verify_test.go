@@ -0,0 +1,64 @@
+package node
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+func TestVerifySignaturesReportsEachTransactionOnAnyWorkerCount(t *testing.T) {
+	_, miner := newTestBlockchain(t)
+	var txs []*transaction.Transaction
+	for nonce := range uint64(20) {
+		tx, err := miner.NewTransaction(unseen(t), transaction.COIN/100, 0, nonce, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nonce == 3 || nonce == 11 {
+			tx.SetValue(transaction.COIN)
+		}
+		txs = append(txs, tx)
+	}
+	// Coinbases carry no signature and are not verified.
+	txs = append(txs, transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0))
+	for _, workers := range []int{1, 4, 0, 100} {
+		errs := verifySignatures(txs, nil, workers)
+		for i, err := range errs {
+			var want error
+			if i == 3 || i == 11 {
+				want = transaction.ErrInvalidSignature
+			}
+			if !errors.Is(err, want) {
+				t.Errorf("%d workers: transaction %d = %v, want %v", workers, i, err, want)
+			}
+		}
+	}
+}
+
+func TestCheckBlockReportsTheFirstBadSignatureWithParallelWorkers(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	bc.SetVerifyWorkers(4)
+	next := bc.NextNonce(miner.BlockchainAddress())
+	var txs []*transaction.Transaction
+	for i := range uint64(6) {
+		tx, err := miner.NewTransaction(unseen(t), transaction.COIN/100, 0, next+i, bc.ChainID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+	}
+	coinbase := transaction.NewTransaction(transaction.MINING_SENDER, miner.BlockchainAddress(), MINING_REWARD, 0)
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	if err := bc.checkBlock(bc.chain, sealBlock(t, bc, append(txs, coinbase)), bc.tipState(), bc.rules()); err != nil {
+		t.Fatalf("checkBlock = %v", err)
+	}
+	txs[2].SetValue(transaction.COIN / 50)
+	txs[4].SetValue(transaction.COIN / 50)
+	err := bc.checkBlock(bc.chain, sealBlock(t, bc, append(txs, coinbase)), bc.tipState(), bc.rules())
+	if !errors.Is(err, transaction.ErrInvalidSignature) || !strings.HasPrefix(err.Error(), "transaction 2:") {
+		t.Fatalf("checkBlock = %v, want %v at transaction 2", err, transaction.ErrInvalidSignature)
+	}
+}
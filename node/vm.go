@@ -0,0 +1,328 @@
+package node
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// Instructions of the contract VM. Every word on its stack and in storage is an int64; PUSH is followed by
+// its operand as 8 big-endian bytes.
+const (
+	VM_STOP      = 0x00
+	VM_PUSH      = 0x01
+	VM_POP       = 0x02
+	VM_DUP       = 0x03
+	VM_SWAP      = 0x04
+	VM_ADD       = 0x10
+	VM_SUB       = 0x11
+	VM_MUL       = 0x12
+	VM_DIV       = 0x13
+	VM_MOD       = 0x14
+	VM_LT        = 0x15
+	VM_GT        = 0x16
+	VM_EQ        = 0x17
+	VM_ISZERO    = 0x18
+	VM_JUMP      = 0x20
+	VM_JUMPI     = 0x21
+	VM_JUMPDEST  = 0x22
+	VM_SLOAD     = 0x30
+	VM_SSTORE    = 0x31
+	VM_INPUT     = 0x40
+	VM_INPUTSIZE = 0x41
+	VM_CALLVALUE = 0x42
+	VM_CALLER    = 0x43
+	VM_BALANCE   = 0x44
+	VM_PAY       = 0x45
+	VM_RETURN    = 0x50
+	VM_REVERT    = 0x51
+
+	// VM_MAX_STACK bounds the stack; gas bounds everything else a call can cost.
+	VM_MAX_STACK = 256
+)
+
+var (
+	ErrOutOfGas = errors.New("out of gas")
+	ErrReverted = errors.New("execution reverted")
+	ErrVMFault  = errors.New("invalid execution")
+)
+
+// vmInstruction is an instruction's name, the stack words it pops, and the gas it costs.
+type vmInstruction struct {
+	name string
+	pops int
+	gas  uint64
+}
+
+var vmInstructions = map[byte]vmInstruction{
+	VM_STOP:      {"STOP", 0, 0},
+	VM_PUSH:      {"PUSH", 0, 1},
+	VM_POP:       {"POP", 1, 1},
+	VM_DUP:       {"DUP", 1, 1},
+	VM_SWAP:      {"SWAP", 2, 1},
+	VM_ADD:       {"ADD", 2, 1},
+	VM_SUB:       {"SUB", 2, 1},
+	VM_MUL:       {"MUL", 2, 2},
+	VM_DIV:       {"DIV", 2, 2},
+	VM_MOD:       {"MOD", 2, 2},
+	VM_LT:        {"LT", 2, 1},
+	VM_GT:        {"GT", 2, 1},
+	VM_EQ:        {"EQ", 2, 1},
+	VM_ISZERO:    {"ISZERO", 1, 1},
+	VM_JUMP:      {"JUMP", 1, 2},
+	VM_JUMPI:     {"JUMPI", 2, 2},
+	VM_JUMPDEST:  {"JUMPDEST", 0, 1},
+	VM_SLOAD:     {"SLOAD", 1, 20},
+	VM_SSTORE:    {"SSTORE", 2, 100},
+	VM_INPUT:     {"INPUT", 1, 1},
+	VM_INPUTSIZE: {"INPUTSIZE", 0, 1},
+	VM_CALLVALUE: {"CALLVALUE", 0, 1},
+	VM_CALLER:    {"CALLER", 0, 1},
+	VM_BALANCE:   {"BALANCE", 0, 5},
+	VM_PAY:       {"PAY", 1, 50},
+	VM_RETURN:    {"RETURN", 1, 0},
+	VM_REVERT:    {"REVERT", 0, 0},
+}
+
+// vmCall is what a contract call sees and changes: its input words, the caller and the coins it sent, the
+// contract's balance with them, and a working copy of the storage, kept only if the call succeeds.
+type vmCall struct {
+	input   []int64
+	caller  int64
+	value   transaction.Amount
+	balance transaction.Amount
+	storage map[int64]int64
+	paid    transaction.Amount
+}
+
+// jumpDests returns the offsets of the JUMPDEST instructions in code, skipping PUSH operands, so a jump
+// cannot land inside one.
+func jumpDests(code []byte) map[int]bool {
+	dests := make(map[int]bool)
+	for pc := 0; pc < len(code); pc++ {
+		switch code[pc] {
+		case VM_JUMPDEST:
+			dests[pc] = true
+		case VM_PUSH:
+			pc += 8
+		}
+	}
+	return dests
+}
+
+// runVM executes code for call with at most gas, returning the RETURN value (zero after STOP) and the gas
+// used. Any error, including ErrReverted and ErrOutOfGas, means the call's changes must be discarded.
+func runVM(code []byte, call *vmCall, gas uint64) (int64, uint64, error) {
+	dests := jumpDests(code)
+	stack := make([]int64, 0, 16)
+	pop := func() int64 {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return top
+	}
+	var used uint64
+	for pc := 0; pc < len(code); pc++ {
+		op := code[pc]
+		in, ok := vmInstructions[op]
+		if !ok {
+			return 0, used, fmt.Errorf("%w: unknown instruction 0x%02x at %d", ErrVMFault, op, pc)
+		}
+		if used += in.gas; used > gas {
+			return 0, gas, ErrOutOfGas
+		}
+		if len(stack) < in.pops {
+			return 0, used, fmt.Errorf("%w: %s on a stack of %d at %d", ErrVMFault, in.name, len(stack), pc)
+		}
+		switch op {
+		case VM_STOP:
+			return 0, used, nil
+		case VM_PUSH:
+			if pc+8 >= len(code) {
+				return 0, used, fmt.Errorf("%w: truncated PUSH at %d", ErrVMFault, pc)
+			}
+			stack = append(stack, int64(binary.BigEndian.Uint64(code[pc+1:])))
+			pc += 8
+		case VM_POP:
+			pop()
+		case VM_DUP:
+			stack = append(stack, stack[len(stack)-1])
+		case VM_SWAP:
+			n := len(stack)
+			stack[n-1], stack[n-2] = stack[n-2], stack[n-1]
+		case VM_ADD, VM_SUB, VM_MUL, VM_DIV, VM_MOD, VM_LT, VM_GT, VM_EQ:
+			a, b := pop(), pop()
+			stack = append(stack, vmArithmetic(op, a, b))
+		case VM_ISZERO:
+			stack = append(stack, vmBool(pop() == 0))
+		case VM_JUMP, VM_JUMPI:
+			dest := pop()
+			if op == VM_JUMPI && pop() == 0 {
+				continue
+			}
+			if dest < 0 || dest >= int64(len(code)) || !dests[int(dest)] {
+				return 0, used, fmt.Errorf("%w: jump to %d, not a JUMPDEST", ErrVMFault, dest)
+			}
+			// The loop's increment steps over the JUMPDEST, which costs nothing when jumped to.
+			pc = int(dest)
+		case VM_JUMPDEST:
+		case VM_SLOAD:
+			stack = append(stack, call.storage[pop()])
+		case VM_SSTORE:
+			key, value := pop(), pop()
+			if value == 0 {
+				delete(call.storage, key)
+			} else {
+				call.storage[key] = value
+			}
+		case VM_INPUT:
+			i := pop()
+			var word int64
+			if i >= 0 && i < int64(len(call.input)) {
+				word = call.input[i]
+			}
+			stack = append(stack, word)
+		case VM_INPUTSIZE:
+			stack = append(stack, int64(len(call.input)))
+		case VM_CALLVALUE:
+			stack = append(stack, int64(call.value))
+		case VM_CALLER:
+			stack = append(stack, call.caller)
+		case VM_BALANCE:
+			stack = append(stack, int64(call.balance-call.paid))
+		case VM_PAY:
+			amount := transaction.Amount(pop())
+			if amount < 0 || amount > call.balance-call.paid {
+				return 0, used, fmt.Errorf("%w: PAY of %s with %s left", ErrVMFault, amount, call.balance-call.paid)
+			}
+			call.paid += amount
+		case VM_RETURN:
+			return pop(), used, nil
+		case VM_REVERT:
+			return 0, used, ErrReverted
+		}
+		if len(stack) > VM_MAX_STACK {
+			return 0, used, fmt.Errorf("%w: stack above %d words", ErrVMFault, VM_MAX_STACK)
+		}
+	}
+	return 0, used, nil
+}
+
+// vmArithmetic applies a two-operand instruction to a, the top of the stack, and b, the word below it.
+// Division and modulo by zero give zero, as in the EVM, so they cannot fault.
+func vmArithmetic(op byte, a, b int64) int64 {
+	switch op {
+	case VM_ADD:
+		return a + b
+	case VM_SUB:
+		return a - b
+	case VM_MUL:
+		return a * b
+	case VM_DIV:
+		if b == 0 {
+			return 0
+		}
+		return a / b
+	case VM_MOD:
+		if b == 0 {
+			return 0
+		}
+		return a % b
+	case VM_LT:
+		return vmBool(a < b)
+	case VM_GT:
+		return vmBool(a > b)
+	default:
+		return vmBool(a == b)
+	}
+}
+
+func vmBool(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// AssembleContract translates contract assembly into code: instruction names, "PUSH n" with a decimal
+// operand or "PUSH @label", and "label:" to mark a JUMPDEST, e.g.
+// "PUSH 0 SLOAD PUSH 1 ADD PUSH 0 SSTORE STOP".
+func AssembleContract(asm string) ([]byte, error) {
+	names := make(map[string]byte, len(vmInstructions))
+	for op, in := range vmInstructions {
+		names[in.name] = op
+	}
+	words := strings.Fields(asm)
+	// The first pass places the labels, whose offsets the second pass pushes.
+	labels := make(map[string]int)
+	for pass := range 2 {
+		code := make([]byte, 0, len(words))
+		for i := 0; i < len(words); i++ {
+			word := strings.ToUpper(words[i])
+			if label, ok := strings.CutSuffix(words[i], ":"); ok {
+				labels[label] = len(code)
+				code = append(code, VM_JUMPDEST)
+				continue
+			}
+			op, ok := names[word]
+			if !ok {
+				return nil, fmt.Errorf("unknown instruction %q", words[i])
+			}
+			code = append(code, op)
+			if op != VM_PUSH {
+				continue
+			}
+			if i++; i == len(words) {
+				return nil, errors.New("PUSH without an operand")
+			}
+			var n int64
+			if label, ok := strings.CutPrefix(words[i], "@"); ok {
+				offset, known := labels[label]
+				if !known && pass == 1 {
+					return nil, fmt.Errorf("unknown label %q", label)
+				}
+				n = int64(offset)
+			} else {
+				var err error
+				if n, err = strconv.ParseInt(words[i], 10, 64); err != nil {
+					return nil, fmt.Errorf("invalid PUSH operand %q", words[i])
+				}
+			}
+			code = binary.BigEndian.AppendUint64(code, uint64(n))
+		}
+		if pass == 1 {
+			return code, nil
+		}
+	}
+	return nil, nil
+}
+
+// init lets Transaction.Print show contract code as assembly.
+func init() {
+	transaction.DisassembleCode = DisassembleContract
+}
+
+// DisassembleContract returns the assembly of code, with jump destinations as JUMPDEST. WebAssembly modules
+// have none here.
+func DisassembleContract(code []byte) string {
+	if transaction.IsWasm(code) {
+		return fmt.Sprintf("(WebAssembly module, %d bytes)", len(code))
+	}
+	words := make([]string, 0, len(code))
+	for pc := 0; pc < len(code); pc++ {
+		in, ok := vmInstructions[code[pc]]
+		switch {
+		case !ok:
+			words = append(words, fmt.Sprintf("0x%02x", code[pc]))
+		case code[pc] == VM_PUSH && pc+8 < len(code):
+			words = append(words, "PUSH", strconv.FormatInt(int64(binary.BigEndian.Uint64(code[pc+1:])), 10))
+			pc += 8
+		default:
+			words = append(words, in.name)
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,247 @@
+package node
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+	"github.com/dikako/how-blockchain-works/wallet"
+)
+
+// counter increments storage key 0 and returns its new value.
+const counter = "PUSH 0 SLOAD PUSH 1 ADD DUP PUSH 0 SSTORE RETURN"
+
+// assemble returns the code of asm, failing t if it does not assemble.
+func assemble(t *testing.T, asm string) []byte {
+	t.Helper()
+	code, err := AssembleContract(asm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// newCall returns a call of a contract holding balance, with empty storage.
+func newCall(balance transaction.Amount, input ...int64) *vmCall {
+	return &vmCall{input: input, balance: balance, storage: make(map[int64]int64)}
+}
+
+func TestVMRunsAndMetersCode(t *testing.T) {
+	call := newCall(0)
+	call.storage[0] = 41
+	result, used, err := runVM(assemble(t, counter), call, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// PUSH, SLOAD, PUSH, ADD, DUP, PUSH, SSTORE, and RETURN.
+	if result != 42 || used != 125 || call.storage[0] != 42 {
+		t.Fatalf("runVM = %d using %d gas, storage %v; want 42 using 125", result, used, call.storage)
+	}
+
+	// Counts 3 down to 0, jumping back twice; a jump steps over the JUMPDEST it lands on for free.
+	countdown := assemble(t, "PUSH 3 loop: PUSH 1 SWAP SUB DUP PUSH @loop JUMPI RETURN")
+	if result, used, err := runVM(countdown, newCall(0), 1000); err != nil || result != 0 || used != 1+8+7+7 {
+		t.Fatalf("countdown = %d using %d gas, %v; want 0 using 23", result, used, err)
+	}
+
+	for _, tt := range []struct {
+		op   string
+		a, b int64
+		want int64
+	}{
+		{"ADD", 7, 2, 9},
+		{"SUB", 7, 2, 5},
+		{"MUL", 7, 2, 14},
+		{"DIV", 7, 2, 3},
+		{"DIV", 7, 0, 0},
+		{"MOD", 7, 4, 3},
+		{"MOD", 7, 0, 0},
+		{"LT", 1, 2, 1},
+		{"GT", 1, 2, 0},
+		{"EQ", 2, 2, 1},
+	} {
+		// The first operand is the top of the stack, so it is pushed last.
+		code := assemble(t, fmt.Sprintf("PUSH %d PUSH %d %s RETURN", tt.b, tt.a, tt.op))
+		if got, _, err := runVM(code, newCall(0), 100); err != nil || got != tt.want {
+			t.Errorf("%d %s %d = %d, %v, want %d", tt.a, tt.op, tt.b, got, err, tt.want)
+		}
+	}
+}
+
+func TestVMSeesItsCallAndPaysFromItsBalance(t *testing.T) {
+	call := newCall(10, 5, 6)
+	call.caller, call.value = 99, 3
+	for asm, want := range map[string]int64{
+		// Input words past the end read as zero.
+		"PUSH 1 INPUT PUSH 9 INPUT ADD RETURN": 6,
+		"INPUTSIZE RETURN":                     2,
+		"CALLER RETURN":                        99,
+		"CALLVALUE RETURN":                     3,
+	} {
+		if got, _, err := runVM(assemble(t, asm), call, 100); err != nil || got != want {
+			t.Errorf("%s = %d, %v, want %d", asm, got, err, want)
+		}
+	}
+	result, _, err := runVM(assemble(t, "CALLVALUE PAY BALANCE RETURN"), call, 100)
+	if err != nil || result != 7 || call.paid != 3 {
+		t.Fatalf("paying back the value = %d, %v with %s paid; want 7 left and 3 paid", result, err, call.paid)
+	}
+}
+
+func TestVMFaultsRevertsAndRunsOutOfGas(t *testing.T) {
+	// A PUSH operand that holds a JUMPDEST byte at offset 2 is not a jump destination.
+	intoOperand := []byte{VM_PUSH, 0, VM_JUMPDEST, 0, 0, 0, 0, 0, 0, VM_POP}
+	intoOperand = append(binary.BigEndian.AppendUint64(append(intoOperand, VM_PUSH), 2), VM_JUMP)
+	overflow := make([]byte, VM_MAX_STACK+1)
+	for i := range overflow {
+		overflow[i] = VM_INPUTSIZE
+	}
+	tests := []struct {
+		name string
+		code []byte
+		want error
+	}{
+		{"unknown instruction", []byte{0xff}, ErrVMFault},
+		{"stack underflow", []byte{VM_ADD}, ErrVMFault},
+		{"truncated PUSH", []byte{VM_PUSH, 0, 0}, ErrVMFault},
+		{"jump past the end", assemble(t, "PUSH 100 JUMP"), ErrVMFault},
+		{"jump to a non-JUMPDEST", assemble(t, "PUSH 0 JUMP"), ErrVMFault},
+		{"jump into a PUSH operand", intoOperand, ErrVMFault},
+		{"stack overflow", overflow, ErrVMFault},
+		{"paying more than the balance", assemble(t, "PUSH 11 PAY STOP"), ErrVMFault},
+		{"paying a negative amount", assemble(t, "PUSH -1 PAY STOP"), ErrVMFault},
+		{"revert", assemble(t, "PUSH 7 PUSH 0 SSTORE REVERT"), ErrReverted},
+		{"endless loop", assemble(t, "loop: PUSH @loop JUMP"), ErrOutOfGas},
+	}
+	for _, tt := range tests {
+		_, used, err := runVM(tt.code, newCall(10), 1000)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: runVM = %v, want %v", tt.name, err, tt.want)
+		}
+		if used > 1000 {
+			t.Errorf("%s: used %d gas of 1000", tt.name, used)
+		}
+	}
+	if _, used, _ := runVM(assemble(t, "loop: PUSH @loop JUMP"), newCall(0), 1000); used != 1000 {
+		t.Fatalf("running out of gas used %d, want all 1000", used)
+	}
+}
+
+func TestAssembleContractRoundTripsThroughTheDisassembler(t *testing.T) {
+	code := assemble(t, "PUSH -5 start: DUP push @start pop STOP")
+	if got, want := DisassembleContract(code), "PUSH -5 JUMPDEST DUP PUSH 9 POP STOP"; got != want {
+		t.Fatalf("DisassembleContract = %q, want %q", got, want)
+	}
+	for _, asm := range []string{"NOPE", "PUSH", "PUSH x", "PUSH @nowhere"} {
+		if _, err := AssembleContract(asm); err == nil {
+			t.Errorf("AssembleContract(%q) succeeded", asm)
+		}
+	}
+}
+
+// sendContract signs contract transaction tx from w with its next nonce and adds it to bc's pool.
+func sendContract(t *testing.T, bc *Blockchain, w *wallet.Wallet, tx *transaction.Transaction) *transaction.Transaction {
+	t.Helper()
+	tx.SetNonce(bc.NextNonce(w.BlockchainAddress()))
+	tx.SetChainID(bc.ChainID())
+	if err := w.SignTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestContractsKeepTheirStorageAcrossBlocks(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w := fund(t, bc, miner, transaction.COIN)
+	code := assemble(t, counter)
+	deployFee := transaction.Amount(transaction.CONTRACT_DEPLOY_GAS+transaction.CONTRACT_CODE_BYTE_GAS*len(code)) * transaction.GAS_PRICE
+	deploy := sendContract(t, bc, w, transaction.NewContractDeployment(w.BlockchainAddress(), code, 1000, deployFee))
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	root := bc.LastBlock().StateRoot()
+	if root == nil {
+		t.Fatal("a block with a contract has no state root")
+	}
+	address := deploy.ContractAddress()
+	callFee := 1000 * transaction.GAS_PRICE
+	first := sendContract(t, bc, w, transaction.NewContractCall(w.BlockchainAddress(), address, nil, 0, callFee))
+	second := sendContract(t, bc, w, transaction.NewContractCall(w.BlockchainAddress(), address, nil, 0, callFee))
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	if sameStateRoot(bc.LastBlock().StateRoot(), root) {
+		t.Fatal("the state root did not commit to the changed storage")
+	}
+
+	c, balance, ok := bc.Contract(address)
+	if !ok || c.Storage()[0] != 2 || balance != 1000 {
+		t.Fatalf("Contract = %v holding %s, %v; want the counter at 2 holding 1000", c, balance, ok)
+	}
+	for i, tx := range []*transaction.Transaction{first, second} {
+		r, ok := bc.Receipt(tx.Hash())
+		if want := uint64(transaction.CONTRACT_CALL_GAS + 125); !ok || !r.Success || r.Result != int64(i+1) || r.GasUsed != want {
+			t.Fatalf("receipt of call %d = %+v, want result %d using %d gas", i+1, r, i+1, want)
+		}
+	}
+	// The sender paid both fees in full, whatever gas the calls used.
+	if got, want := bc.CalculateTotalAmount(w.BlockchainAddress()), transaction.COIN-1000-deployFee-2*callFee; got != want {
+		t.Fatalf("sender balance = %s, want %s", got, want)
+	}
+}
+
+func TestFailedCallsKeepTheirFeeAndUndoEverythingElse(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w := fund(t, bc, miner, transaction.COIN)
+	code := assemble(t, "PUSH 7 PUSH 0 SSTORE CALLVALUE PUSH 1 SSTORE PUSH 1 INPUT ISZERO PUSH @ok JUMPI REVERT ok: STOP")
+	deployFee := transaction.Amount(transaction.CONTRACT_DEPLOY_GAS+transaction.CONTRACT_CODE_BYTE_GAS*len(code)) * transaction.GAS_PRICE
+	address := sendContract(t, bc, w, transaction.NewContractDeployment(w.BlockchainAddress(), code, 0, deployFee)).ContractAddress()
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+
+	callFee := 1000 * transaction.GAS_PRICE
+	before := bc.CalculateTotalAmount(w.BlockchainAddress())
+	reverted := sendContract(t, bc, w, transaction.NewContractCall(w.BlockchainAddress(), address, []int64{0, 1}, 500, callFee))
+	// The fee buys too little gas for the two SSTOREs.
+	starved := sendContract(t, bc, w, transaction.NewContractCall(w.BlockchainAddress(), address, nil, 500, (transaction.CONTRACT_CALL_GAS+150)*transaction.GAS_PRICE))
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+
+	for _, tt := range []struct {
+		tx   *transaction.Transaction
+		want error
+	}{{reverted, ErrReverted}, {starved, ErrOutOfGas}} {
+		r, ok := bc.Receipt(tt.tx.Hash())
+		if !ok || r.Success || r.Error != tt.want.Error() {
+			t.Errorf("receipt = %+v, %v, want a failure with %q", r, ok, tt.want)
+		}
+	}
+	if r, _ := bc.Receipt(starved.Hash()); r.GasUsed != starved.GasLimit() {
+		t.Fatalf("the starved call used %d gas, want all %d", r.GasUsed, starved.GasLimit())
+	}
+	c, balance, _ := bc.Contract(address)
+	if len(c.Storage()) != 0 || balance != 0 {
+		t.Fatalf("failed calls left storage %v and balance %s", c.Storage(), balance)
+	}
+	if got, want := bc.CalculateTotalAmount(w.BlockchainAddress()), before-reverted.Fee()-starved.Fee(); got != want {
+		t.Fatalf("sender balance = %s, want %s, less only the fees", got, want)
+	}
+
+	missing := transaction.NewContractCall(w.BlockchainAddress(), transaction.ScriptAddress([]byte{transaction.OP_1}), nil, 500, callFee)
+	missing.SetNonce(bc.NextNonce(w.BlockchainAddress()))
+	missing.SetChainID(bc.ChainID())
+	if err := w.SignTransaction(missing); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(missing); !errors.Is(err, ErrUnknownContract) {
+		t.Fatalf("AddTransaction of a call to no contract = %v, want %v", err, ErrUnknownContract)
+	}
+}
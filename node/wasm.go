@@ -0,0 +1,1018 @@
+package node
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+const (
+	// WASM_MAX_PAGES bounds a module's linear memory, in 64 KiB pages, and WASM_PAGE_GAS is the fuel each
+	// page costs, whether the module declares it or grows into it.
+	WASM_MAX_PAGES = 16
+	WASM_PAGE_GAS  = 100
+	// WASM_MAX_CALL_DEPTH and WASM_MAX_STACK bound nested calls and the values on the operand stack, so a
+	// module runs out of them the same way on every node.
+	WASM_MAX_CALL_DEPTH = 128
+	WASM_MAX_STACK      = 4096
+
+	// WASM_ENTRY is the export a call runs, a function without parameters returning nothing or an i64.
+	WASM_ENTRY = "call"
+
+	wasmPageSize = 65536
+	wasmI32      = 0x7f
+	wasmI64      = 0x7e
+	wasmEmpty    = 0x40
+)
+
+var ErrInvalidWasm = errors.New("invalid WebAssembly module")
+
+// wasmHost is a function of the "env" module a contract may import: its signature and the fuel it costs.
+type wasmHost struct {
+	params  int
+	results int
+	gas     uint64
+}
+
+// wasmHosts gives contracts what the VM's instructions give VM code; all values are i64.
+var wasmHosts = map[string]wasmHost{
+	"storage_load":  {1, 1, 20},
+	"storage_store": {2, 0, 100},
+	"input":         {1, 1, 1},
+	"input_size":    {0, 1, 1},
+	"caller":        {0, 1, 1},
+	"value":         {0, 1, 1},
+	"balance":       {0, 1, 5},
+	"pay":           {1, 0, 50},
+	"revert":        {0, 0, 0},
+}
+
+type wasmFuncType struct {
+	params  []byte
+	results []byte
+}
+
+// wasmBlock is where the block, loop, or if at some offset of a function body has its else (-1 if none)
+// and its end, and how many values it leaves.
+type wasmBlock struct {
+	elsePC int
+	endPC  int
+	arity  int
+}
+
+type wasmFunc struct {
+	typeIndex uint32
+	locals    int
+	body      []byte
+	blocks    map[int]wasmBlock
+	elseEnds  map[int]int
+}
+
+type wasmGlobal struct {
+	mutable bool
+	init    uint64
+}
+
+type wasmData struct {
+	offset uint32
+	bytes  []byte
+}
+
+// wasmModule is a parsed contract module. Only integer code is supported: no floats, tables, indirect
+// calls, or start function, so every module runs the same on every node.
+type wasmModule struct {
+	types     []wasmFuncType
+	imports   []string // host function names, the first function indexes
+	funcTypes []uint32 // the type of every function, imports first
+	funcs     []wasmFunc
+	memory    bool
+	pages     uint32
+	maxPages  uint32
+	globals   []wasmGlobal
+	data      []wasmData
+	entry     uint32
+}
+
+// wasmReader decodes the binary format; the first error sticks and later reads return zero.
+type wasmReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *wasmReader) fail(format string, args ...any) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: %s at byte %d", ErrInvalidWasm, fmt.Sprintf(format, args...), r.pos)
+	}
+}
+
+func (r *wasmReader) done() bool {
+	return r.err != nil || r.pos >= len(r.b)
+}
+
+func (r *wasmReader) byte() byte {
+	if r.err != nil || r.pos >= len(r.b) {
+		r.fail("unexpected end")
+		return 0
+	}
+	r.pos++
+	return r.b[r.pos-1]
+}
+
+func (r *wasmReader) bytes(n uint32) []byte {
+	if r.err != nil || uint64(r.pos)+uint64(n) > uint64(len(r.b)) {
+		r.fail("unexpected end")
+		return nil
+	}
+	r.pos += int(n)
+	return r.b[r.pos-int(n) : r.pos]
+}
+
+// leb reads a LEB128 number of at most size bits, sign-extending it if signed.
+func (r *wasmReader) leb(size uint, signed bool) uint64 {
+	var v uint64
+	var shift uint
+	for {
+		b := r.byte()
+		if r.err != nil {
+			return 0
+		}
+		if shift >= size {
+			r.fail("LEB128 number too long")
+			return 0
+		}
+		v |= uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if signed && shift < 64 && b&0x40 != 0 {
+				v |= ^uint64(0) << shift
+			}
+			return v
+		}
+	}
+}
+
+func (r *wasmReader) u32() uint32 {
+	v := r.leb(35, false)
+	if v > math.MaxUint32 {
+		r.fail("u32 out of range")
+	}
+	return uint32(v)
+}
+
+// count reads the length of a vector, which cannot have more items than bytes remain.
+func (r *wasmReader) count() uint32 {
+	n := r.u32()
+	if uint64(n) > uint64(len(r.b)-r.pos) {
+		r.fail("vector of %d items in %d bytes", n, len(r.b)-r.pos)
+		return 0
+	}
+	return n
+}
+
+func (r *wasmReader) name() string {
+	return string(r.bytes(r.u32()))
+}
+
+func (r *wasmReader) valType() byte {
+	t := r.byte()
+	if t != wasmI32 && t != wasmI64 {
+		r.fail("unsupported value type 0x%02x", t)
+	}
+	return t
+}
+
+// constExpr reads an i32.const or i64.const initializer, the only ones supported.
+func (r *wasmReader) constExpr() uint64 {
+	var v uint64
+	switch op := r.byte(); op {
+	case 0x41:
+		v = uint64(uint32(r.leb(35, true)))
+	case 0x42:
+		v = r.leb(70, true)
+	default:
+		r.fail("unsupported initializer 0x%02x", op)
+	}
+	if r.byte() != 0x0b {
+		r.fail("initializer without end")
+	}
+	return v
+}
+
+// ParseWasm decodes and checks a contract module: its sections, that its instructions are supported and
+// their indexes in range, that its imports are host functions with their signatures, and that it exports
+// WASM_ENTRY. Operand types are not checked ahead; a mistyped module faults or misbehaves alike everywhere.
+func ParseWasm(code []byte) (*wasmModule, error) {
+	if !transaction.IsWasm(code) {
+		return nil, fmt.Errorf("%w: missing magic number and version 1", ErrInvalidWasm)
+	}
+	m := &wasmModule{entry: math.MaxUint32}
+	r := &wasmReader{b: code, pos: len(transaction.WasmMagic)}
+	var bodies [][]byte
+	var last byte
+	for !r.done() {
+		id := r.byte()
+		s := &wasmReader{b: r.bytes(r.u32())}
+		if r.err != nil {
+			break
+		}
+		if id != 0 {
+			if id <= last {
+				r.fail("section %d out of order", id)
+				break
+			}
+			last = id
+		}
+		switch id {
+		case 0: // custom: names and debug information, ignored
+			continue
+		case 1:
+			for range s.count() {
+				if s.byte() != 0x60 {
+					s.fail("function type expected")
+				}
+				var t wasmFuncType
+				for range s.count() {
+					t.params = append(t.params, s.valType())
+				}
+				for range s.count() {
+					t.results = append(t.results, s.valType())
+				}
+				if len(t.results) > 1 {
+					s.fail("multiple results")
+				}
+				if s.err != nil {
+					break
+				}
+				m.types = append(m.types, t)
+			}
+		case 2:
+			for range s.count() {
+				module, name := s.name(), s.name()
+				if s.byte() != 0x00 {
+					s.fail("only function imports are supported")
+				}
+				ti := s.u32()
+				if s.err != nil {
+					break
+				}
+				host, ok := wasmHosts[name]
+				if module != "env" || !ok {
+					s.fail("unknown import %s.%s", module, name)
+					break
+				}
+				if int(ti) >= len(m.types) || len(m.types[ti].params) != host.params || len(m.types[ti].results) != host.results ||
+					slices.ContainsFunc(append(m.types[ti].params, m.types[ti].results...), func(t byte) bool { return t != wasmI64 }) {
+					s.fail("import %s has the wrong signature", name)
+					break
+				}
+				m.imports = append(m.imports, name)
+				m.funcTypes = append(m.funcTypes, ti)
+			}
+		case 3:
+			for range s.count() {
+				ti := s.u32()
+				if int(ti) >= len(m.types) {
+					s.fail("function type %d out of range", ti)
+					break
+				}
+				m.funcTypes = append(m.funcTypes, ti)
+				m.funcs = append(m.funcs, wasmFunc{typeIndex: ti})
+			}
+		case 5:
+			if s.u32() != 1 {
+				s.fail("exactly one memory expected")
+				break
+			}
+			flags := s.byte()
+			m.memory, m.pages, m.maxPages = true, s.u32(), WASM_MAX_PAGES
+			if flags == 1 {
+				m.maxPages = min(s.u32(), WASM_MAX_PAGES)
+			} else if flags != 0 {
+				s.fail("unsupported memory flags")
+			}
+			if m.pages > m.maxPages {
+				s.fail("memory of %d pages (at most %d)", m.pages, m.maxPages)
+			}
+		case 6:
+			for range s.count() {
+				s.valType()
+				mutable := s.byte()
+				if mutable > 1 {
+					s.fail("invalid global mutability")
+				}
+				m.globals = append(m.globals, wasmGlobal{mutable: mutable == 1, init: s.constExpr()})
+			}
+		case 7:
+			for range s.count() {
+				name, kind, index := s.name(), s.byte(), s.u32()
+				if name == WASM_ENTRY && kind == 0x00 {
+					m.entry = index
+				}
+			}
+		case 10:
+			n := s.count()
+			if int(n) != len(m.funcs) {
+				s.fail("%d bodies for %d functions", n, len(m.funcs))
+				break
+			}
+			for range n {
+				bodies = append(bodies, s.bytes(s.u32()))
+			}
+		case 11:
+			for range s.count() {
+				if s.u32() != 0 {
+					s.fail("only active data segments in memory 0 are supported")
+					break
+				}
+				offset := s.constExpr()
+				m.data = append(m.data, wasmData{offset: uint32(offset), bytes: s.bytes(s.u32())})
+			}
+		case 12: // data count, only needed by bulk memory instructions
+			s.u32()
+		default:
+			s.fail("unsupported section %d (tables, elements, and start functions are not)", id)
+		}
+		if s.err == nil && !s.done() {
+			s.fail("trailing bytes")
+		}
+		if s.err != nil {
+			return nil, fmt.Errorf("section %d: %w", id, s.err)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(bodies) != len(m.funcs) {
+		return nil, fmt.Errorf("%w: %d bodies for %d functions", ErrInvalidWasm, len(bodies), len(m.funcs))
+	}
+	for i, body := range bodies {
+		if err := m.parseBody(&m.funcs[i], body); err != nil {
+			return nil, fmt.Errorf("function %d: %w", len(m.imports)+i, err)
+		}
+	}
+	for _, d := range m.data {
+		if !m.memory || uint64(d.offset)+uint64(len(d.bytes)) > uint64(m.pages)*wasmPageSize {
+			return nil, fmt.Errorf("%w: data segment outside memory", ErrInvalidWasm)
+		}
+	}
+	if int(m.entry) < len(m.imports) || int(m.entry) >= len(m.funcTypes) {
+		return nil, fmt.Errorf("%w: no exported function %q", ErrInvalidWasm, WASM_ENTRY)
+	}
+	if t := m.types[m.funcTypes[m.entry]]; len(t.params) > 0 || len(t.results) == 1 && t.results[0] != wasmI64 {
+		return nil, fmt.Errorf("%w: %q must take nothing and return nothing or an i64", ErrInvalidWasm, WASM_ENTRY)
+	}
+	return m, nil
+}
+
+// parseBody reads f's locals and scans its instructions, recording where each block's else and end are.
+func (m *wasmModule) parseBody(f *wasmFunc, body []byte) error {
+	r := &wasmReader{b: body}
+	for range r.count() {
+		n := r.u32()
+		r.valType()
+		f.locals += int(n)
+		if f.locals > math.MaxUint16 {
+			r.fail("too many locals")
+		}
+	}
+	f.body = body[r.pos:]
+	f.blocks = make(map[int]wasmBlock)
+	f.elseEnds = make(map[int]int)
+	locals := len(m.types[f.typeIndex].params) + f.locals
+	r = &wasmReader{b: f.body}
+	open := make([]int, 0)
+	needMemory := func() {
+		if !m.memory {
+			r.fail("memory instruction without a memory")
+		}
+	}
+	for !r.done() {
+		pc := r.pos
+		op := r.byte()
+		switch {
+		case op == 0x02 || op == 0x03 || op == 0x04:
+			var arity int
+			switch bt := r.byte(); bt {
+			case wasmEmpty:
+			case wasmI32, wasmI64:
+				arity = 1
+			default:
+				r.fail("unsupported block type 0x%02x", bt)
+			}
+			open = append(open, pc)
+			f.blocks[pc] = wasmBlock{elsePC: -1, arity: arity}
+		case op == 0x05:
+			if len(open) == 0 || f.body[open[len(open)-1]] != 0x04 || f.blocks[open[len(open)-1]].elsePC >= 0 {
+				r.fail("else outside an if")
+				break
+			}
+			b := f.blocks[open[len(open)-1]]
+			b.elsePC = pc
+			f.blocks[open[len(open)-1]] = b
+		case op == 0x0b:
+			if len(open) == 0 {
+				if r.pos != len(f.body) {
+					r.fail("code after the function's end")
+				}
+				return r.err
+			}
+			top := open[len(open)-1]
+			open = open[:len(open)-1]
+			b := f.blocks[top]
+			b.endPC = pc
+			f.blocks[top] = b
+			if b.elsePC >= 0 {
+				f.elseEnds[b.elsePC] = pc
+			}
+		case op == 0x0c || op == 0x0d:
+			if r.u32() > uint32(len(open)) {
+				r.fail("branch to an unknown label")
+			}
+		case op == 0x0e:
+			for range r.count() + 1 {
+				if r.u32() > uint32(len(open)) {
+					r.fail("branch to an unknown label")
+				}
+			}
+		case op == 0x10:
+			if r.u32() >= uint32(len(m.funcTypes)) {
+				r.fail("call of an unknown function")
+			}
+		case op >= 0x20 && op <= 0x22:
+			if r.u32() >= uint32(locals) {
+				r.fail("unknown local")
+			}
+		case op == 0x23 || op == 0x24:
+			i := r.u32()
+			if i >= uint32(len(m.globals)) || op == 0x24 && !m.globals[i].mutable {
+				r.fail("unknown or immutable global")
+			}
+		case op >= 0x28 && op <= 0x3e && op != 0x2a && op != 0x2b && op != 0x38 && op != 0x39:
+			needMemory()
+			r.u32()
+			r.u32()
+		case op == 0x3f || op == 0x40:
+			needMemory()
+			if r.byte() != 0x00 {
+				r.fail("memory index must be 0")
+			}
+		case op == 0x41:
+			r.leb(35, true)
+		case op == 0x42:
+			r.leb(70, true)
+		case op <= 0x01 || op == 0x0f || op == 0x1a || op == 0x1b ||
+			op >= 0x45 && op <= 0x5a || op >= 0x67 && op <= 0x8a ||
+			op == 0xa7 || op == 0xac || op == 0xad || op >= 0xc0 && op <= 0xc4:
+		default:
+			r.fail("unsupported instruction 0x%02x (floating point and tables are not supported)", op)
+		}
+	}
+	if r.err == nil {
+		r.fail("function without end")
+	}
+	return r.err
+}
+
+// wasmInstance is a module running one contract call: its memory and globals start fresh every call,
+// and only the contract's storage, through the host functions, persists.
+type wasmInstance struct {
+	m       *wasmModule
+	call    *vmCall
+	memory  []byte
+	globals []uint64
+	gas     uint64
+	used    uint64
+	depth   int
+	stacked int
+}
+
+// runWasm runs code's WASM_ENTRY for call with at most gas fuel, like runVM: it returns the entry's result
+// (zero if it returns nothing) and the fuel used, and any error means the call's changes must be discarded.
+func runWasm(code []byte, call *vmCall, gas uint64) (int64, uint64, error) {
+	m, err := ParseWasm(code)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %v", ErrVMFault, err)
+	}
+	in := &wasmInstance{m: m, call: call, gas: gas, globals: make([]uint64, len(m.globals))}
+	for i, g := range m.globals {
+		in.globals[i] = g.init
+	}
+	if m.memory {
+		if err := in.charge(uint64(m.pages) * WASM_PAGE_GAS); err != nil {
+			return 0, in.used, err
+		}
+		in.memory = make([]byte, int(m.pages)*wasmPageSize)
+		for _, d := range m.data {
+			copy(in.memory[d.offset:], d.bytes)
+		}
+	}
+	results, err := in.invoke(m.entry, nil)
+	if err != nil {
+		return 0, in.used, err
+	}
+	if len(results) == 0 {
+		return 0, in.used, nil
+	}
+	return int64(results[0]), in.used, nil
+}
+
+func (in *wasmInstance) charge(gas uint64) error {
+	if in.used += gas; in.used > in.gas {
+		in.used = in.gas
+		return ErrOutOfGas
+	}
+	return nil
+}
+
+func wasmFault(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrVMFault, fmt.Sprintf(format, args...))
+}
+
+// host runs the imported host function name with args.
+func (in *wasmInstance) host(name string, args []uint64) ([]uint64, error) {
+	if err := in.charge(wasmHosts[name].gas); err != nil {
+		return nil, err
+	}
+	c := in.call
+	switch name {
+	case "storage_load":
+		return []uint64{uint64(c.storage[int64(args[0])])}, nil
+	case "storage_store":
+		if args[1] == 0 {
+			delete(c.storage, int64(args[0]))
+		} else {
+			c.storage[int64(args[0])] = int64(args[1])
+		}
+	case "input":
+		var word int64
+		if i := int64(args[0]); i >= 0 && i < int64(len(c.input)) {
+			word = c.input[i]
+		}
+		return []uint64{uint64(word)}, nil
+	case "input_size":
+		return []uint64{uint64(len(c.input))}, nil
+	case "caller":
+		return []uint64{uint64(c.caller)}, nil
+	case "value":
+		return []uint64{uint64(c.value)}, nil
+	case "balance":
+		return []uint64{uint64(c.balance - c.paid)}, nil
+	case "pay":
+		amount := transaction.Amount(args[0])
+		if amount < 0 || amount > c.balance-c.paid {
+			return nil, wasmFault("pay of %s with %s left", amount, c.balance-c.paid)
+		}
+		c.paid += amount
+	case "revert":
+		return nil, ErrReverted
+	}
+	return nil, nil
+}
+
+type wasmLabel struct {
+	pc     int // where a branch continues: a loop's first instruction, or a block's end
+	loop   bool
+	height int
+	arity  int
+}
+
+// invoke calls function fi with args and returns its results.
+func (in *wasmInstance) invoke(fi uint32, args []uint64) ([]uint64, error) {
+	if int(fi) < len(in.m.imports) {
+		return in.host(in.m.imports[fi], args)
+	}
+	if in.depth++; in.depth > WASM_MAX_CALL_DEPTH {
+		return nil, wasmFault("call depth above %d", WASM_MAX_CALL_DEPTH)
+	}
+	defer func() { in.depth-- }()
+	f := &in.m.funcs[int(fi)-len(in.m.imports)]
+	t := in.m.types[f.typeIndex]
+	if err := in.charge(1 + uint64(f.locals)/16); err != nil {
+		return nil, err
+	}
+	locals := make([]uint64, len(t.params)+f.locals)
+	copy(locals, args)
+	stack := make([]uint64, 0, 16)
+	defer func() { in.stacked -= len(stack) }()
+	labels := []wasmLabel{{pc: len(f.body) - 1, arity: len(t.results)}}
+
+	push := func(v uint64) error {
+		if in.stacked++; in.stacked > WASM_MAX_STACK {
+			return wasmFault("stack above %d values", WASM_MAX_STACK)
+		}
+		stack = append(stack, v)
+		return nil
+	}
+	var underflow bool
+	pop := func() uint64 {
+		if len(stack) == 0 {
+			underflow = true
+			return 0
+		}
+		in.stacked--
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	// branch leaves label depth l, keeping its values, and continues after it.
+	branch := func(l uint32) int {
+		target := labels[len(labels)-1-int(l)]
+		if len(stack) < target.height+target.arity {
+			underflow = true
+			return len(f.body)
+		}
+		results := stack[len(stack)-target.arity:]
+		in.stacked -= len(stack) - target.height - target.arity
+		stack = append(stack[:target.height], results...)
+		if target.loop {
+			labels = labels[:len(labels)-int(l)]
+			return target.pc
+		}
+		labels = labels[:len(labels)-1-int(l)]
+		return target.pc + 1
+	}
+	memory := func(offset uint32, size uint64) (uint64, error) {
+		addr := uint64(uint32(pop())) + uint64(offset)
+		if addr+size > uint64(len(in.memory)) {
+			return 0, wasmFault("memory access at %d out of bounds", addr)
+		}
+		return addr, nil
+	}
+
+	r := &wasmReader{b: f.body}
+	for r.pos < len(f.body) {
+		if err := in.charge(1); err != nil {
+			return nil, err
+		}
+		pc := r.pos
+		op := r.byte()
+		var err error
+		switch {
+		case op == 0x00:
+			return nil, wasmFault("unreachable executed")
+		case op == 0x01:
+		case op == 0x02 || op == 0x03:
+			r.byte()
+			b := f.blocks[pc]
+			if op == 0x03 {
+				labels = append(labels, wasmLabel{pc: r.pos, loop: true, height: len(stack)})
+			} else {
+				labels = append(labels, wasmLabel{pc: b.endPC, height: len(stack), arity: b.arity})
+			}
+		case op == 0x04:
+			r.byte()
+			b := f.blocks[pc]
+			cond := pop()
+			labels = append(labels, wasmLabel{pc: b.endPC, height: len(stack), arity: b.arity})
+			switch {
+			case cond != 0:
+			case b.elsePC >= 0:
+				r.pos = b.elsePC + 1
+			default:
+				r.pos = b.endPC + 1
+				labels = labels[:len(labels)-1]
+			}
+		case op == 0x05:
+			// The then branch is done; its end pops the label.
+			r.pos = f.elseEnds[pc]
+		case op == 0x0b:
+			labels = labels[:len(labels)-1]
+		case op == 0x0c:
+			r.pos = branch(r.u32())
+		case op == 0x0d:
+			l := r.u32()
+			if pop() != 0 {
+				r.pos = branch(l)
+			}
+		case op == 0x0e:
+			targets := make([]uint32, r.count()+1)
+			for i := range targets {
+				targets[i] = r.u32()
+			}
+			i := uint32(pop())
+			r.pos = branch(targets[min(i, uint32(len(targets)-1))])
+		case op == 0x0f:
+			r.pos = branch(uint32(len(labels) - 1))
+		case op == 0x10:
+			callee := r.u32()
+			ct := in.m.types[in.m.funcTypes[callee]]
+			if len(stack) < len(ct.params) {
+				underflow = true
+				break
+			}
+			args := append([]uint64(nil), stack[len(stack)-len(ct.params):]...)
+			in.stacked -= len(ct.params)
+			stack = stack[:len(stack)-len(ct.params)]
+			results, err := in.invoke(callee, args)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range results {
+				if err := push(v); err != nil {
+					return nil, err
+				}
+			}
+		case op == 0x1a:
+			pop()
+		case op == 0x1b:
+			cond, b, a := pop(), pop(), pop()
+			if cond == 0 {
+				a = b
+			}
+			err = push(a)
+		case op == 0x20:
+			err = push(locals[r.u32()])
+		case op == 0x21:
+			locals[r.u32()] = pop()
+		case op == 0x22:
+			i := r.u32()
+			locals[i] = pop()
+			err = push(locals[i])
+		case op == 0x23:
+			err = push(in.globals[r.u32()])
+		case op == 0x24:
+			in.globals[r.u32()] = pop()
+		case op >= 0x28 && op <= 0x35:
+			r.u32()
+			size, signed := wasmLoadSize(op)
+			var addr uint64
+			if addr, err = memory(r.u32(), size); err == nil {
+				err = push(wasmLoad(in.memory[addr:addr+size], signed, op == 0x28 || op == 0x2c || op == 0x2d || op == 0x2e || op == 0x2f))
+			}
+		case op >= 0x36 && op <= 0x3e:
+			r.u32()
+			offset := r.u32()
+			v := pop()
+			size := wasmStoreSize(op)
+			var addr uint64
+			if addr, err = memory(offset, size); err == nil {
+				var word [8]byte
+				binary.LittleEndian.PutUint64(word[:], v)
+				copy(in.memory[addr:addr+size], word[:size])
+			}
+		case op == 0x3f:
+			r.byte()
+			err = push(uint64(len(in.memory) / wasmPageSize))
+		case op == 0x40:
+			r.byte()
+			pages, old := uint64(uint32(pop())), uint64(len(in.memory)/wasmPageSize)
+			if old+pages > uint64(in.m.maxPages) {
+				err = push(uint64(uint32(math.MaxUint32)))
+				break
+			}
+			if err = in.charge(pages * WASM_PAGE_GAS); err == nil {
+				in.memory = append(in.memory, make([]byte, pages*wasmPageSize)...)
+				err = push(old)
+			}
+		case op == 0x41:
+			err = push(uint64(uint32(r.leb(35, true))))
+		case op == 0x42:
+			err = push(r.leb(70, true))
+		case op == 0x45:
+			err = push(wasmBool(uint32(pop()) == 0))
+		case op == 0x50:
+			err = push(wasmBool(pop() == 0))
+		case op >= 0x46 && op <= 0x4f:
+			b, a := pop(), pop()
+			err = push(wasmCompare(op-0x46, a, b, 32))
+		case op >= 0x51 && op <= 0x5a:
+			b, a := pop(), pop()
+			err = push(wasmCompare(op-0x51, a, b, 64))
+		case op >= 0x67 && op <= 0x69:
+			err = push(wasmUnary(op-0x67, pop(), 32))
+		case op >= 0x79 && op <= 0x7b:
+			err = push(wasmUnary(op-0x79, pop(), 64))
+		case op >= 0x6a && op <= 0x78:
+			b, a := pop(), pop()
+			var v uint64
+			if v, err = wasmBinary(op-0x6a, a, b, 32); err == nil {
+				err = push(v)
+			}
+		case op >= 0x7c && op <= 0x8a:
+			b, a := pop(), pop()
+			var v uint64
+			if v, err = wasmBinary(op-0x7c, a, b, 64); err == nil {
+				err = push(v)
+			}
+		case op == 0xa7:
+			err = push(uint64(uint32(pop())))
+		case op == 0xac:
+			err = push(uint64(int64(int32(uint32(pop())))))
+		case op == 0xad:
+			err = push(uint64(uint32(pop())))
+		case op == 0xc0:
+			err = push(uint64(uint32(int32(int8(pop())))))
+		case op == 0xc1:
+			err = push(uint64(uint32(int32(int16(pop())))))
+		case op == 0xc2:
+			err = push(uint64(int64(int8(pop()))))
+		case op == 0xc3:
+			err = push(uint64(int64(int16(pop()))))
+		case op == 0xc4:
+			err = push(uint64(int64(int32(pop()))))
+		default:
+			err = wasmFault("unsupported instruction 0x%02x", op)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if underflow {
+			return nil, wasmFault("operand stack underflow at %d", pc)
+		}
+	}
+	if len(stack) < len(t.results) {
+		return nil, wasmFault("function %d returned too few values", fi)
+	}
+	return append([]uint64(nil), stack[len(stack)-len(t.results):]...), nil
+}
+
+// wasmLoadSize returns the bytes a load reads and whether it sign-extends them.
+func wasmLoadSize(op byte) (uint64, bool) {
+	switch op {
+	case 0x28:
+		return 4, false
+	case 0x29:
+		return 8, false
+	case 0x2c, 0x30:
+		return 1, true
+	case 0x2d, 0x31:
+		return 1, false
+	case 0x2e, 0x32:
+		return 2, true
+	case 0x2f, 0x33:
+		return 2, false
+	case 0x34:
+		return 4, true
+	default:
+		return 4, false
+	}
+}
+
+// wasmLoad decodes little-endian b, sign-extending it if signed, as an i32 result if i32.
+func wasmLoad(b []byte, signed, i32 bool) uint64 {
+	var word [8]byte
+	copy(word[:], b)
+	v := binary.LittleEndian.Uint64(word[:])
+	if signed {
+		shift := 64 - 8*uint(len(b))
+		v = uint64(int64(v<<shift) >> shift)
+	}
+	if i32 {
+		v = uint64(uint32(v))
+	}
+	return v
+}
+
+// wasmStoreSize returns the bytes a store writes.
+func wasmStoreSize(op byte) uint64 {
+	switch op {
+	case 0x36, 0x3e:
+		return 4
+	case 0x37:
+		return 8
+	case 0x3a, 0x3c:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func wasmBool(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// wasmSigned interprets v as a signed integer of size bits.
+func wasmSigned(v uint64, size int) int64 {
+	if size == 32 {
+		return int64(int32(uint32(v)))
+	}
+	return int64(v)
+}
+
+// wasmCompare applies comparison k, counted from eq (eq, ne, lt_s, lt_u, gt_s, gt_u, le_s, le_u, ge_s,
+// ge_u), to a and b of size bits.
+func wasmCompare(k byte, a, b uint64, size int) uint64 {
+	if size == 32 {
+		a, b = uint64(uint32(a)), uint64(uint32(b))
+	}
+	sa, sb := wasmSigned(a, size), wasmSigned(b, size)
+	switch k {
+	case 0:
+		return wasmBool(a == b)
+	case 1:
+		return wasmBool(a != b)
+	case 2:
+		return wasmBool(sa < sb)
+	case 3:
+		return wasmBool(a < b)
+	case 4:
+		return wasmBool(sa > sb)
+	case 5:
+		return wasmBool(a > b)
+	case 6:
+		return wasmBool(sa <= sb)
+	case 7:
+		return wasmBool(a <= b)
+	case 8:
+		return wasmBool(sa >= sb)
+	default:
+		return wasmBool(a >= b)
+	}
+}
+
+// wasmUnary applies clz, ctz, or popcnt (k 0, 1, 2) to v of size bits.
+func wasmUnary(k byte, v uint64, size int) uint64 {
+	if size == 32 {
+		switch k {
+		case 0:
+			return uint64(bits.LeadingZeros32(uint32(v)))
+		case 1:
+			return uint64(bits.TrailingZeros32(uint32(v)))
+		default:
+			return uint64(bits.OnesCount32(uint32(v)))
+		}
+	}
+	switch k {
+	case 0:
+		return uint64(bits.LeadingZeros64(v))
+	case 1:
+		return uint64(bits.TrailingZeros64(v))
+	default:
+		return uint64(bits.OnesCount64(v))
+	}
+}
+
+// wasmBinary applies operation k, counted from add (add, sub, mul, div_s, div_u, rem_s, rem_u, and, or,
+// xor, shl, shr_s, shr_u, rotl, rotr), to a and b of size bits. Division by zero and signed overflow trap,
+// as the specification requires.
+func wasmBinary(k byte, a, b uint64, size int) (uint64, error) {
+	mask := uint64(math.MaxUint64)
+	if size == 32 {
+		mask = math.MaxUint32
+	}
+	a, b = a&mask, b&mask
+	sa, sb := wasmSigned(a, size), wasmSigned(b, size)
+	shift := uint(b) % uint(size)
+	var v uint64
+	switch k {
+	case 0:
+		v = a + b
+	case 1:
+		v = a - b
+	case 2:
+		v = a * b
+	case 3, 4, 5, 6:
+		if b == 0 {
+			return 0, wasmFault("integer division by zero")
+		}
+		switch k {
+		case 3:
+			if sb == -1 && sa == wasmSigned(1<<(size-1), size) {
+				return 0, wasmFault("integer overflow")
+			}
+			v = uint64(sa / sb)
+		case 4:
+			v = a / b
+		case 5:
+			if sb == -1 {
+				v = 0
+			} else {
+				v = uint64(sa % sb)
+			}
+		default:
+			v = a % b
+		}
+	case 7:
+		v = a & b
+	case 8:
+		v = a | b
+	case 9:
+		v = a ^ b
+	case 10:
+		v = a << shift
+	case 11:
+		v = uint64(sa >> shift)
+	case 12:
+		v = a >> shift
+	case 13, 14:
+		n := int(shift)
+		if k == 14 {
+			n = -n
+		}
+		if size == 32 {
+			v = uint64(bits.RotateLeft32(uint32(a), n))
+		} else {
+			v = bits.RotateLeft64(a, n)
+		}
+	}
+	return v & mask, nil
+}
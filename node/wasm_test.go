@@ -0,0 +1,191 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// wasmSection encodes a module section of kind id holding payload.
+func wasmSection(id byte, payload ...byte) []byte {
+	return append(binary.AppendUvarint([]byte{id}, uint64(len(payload))), payload...)
+}
+
+// wasmI64Const encodes i64.const v, whose operand is signed LEB128.
+func wasmI64Const(v int64) []byte {
+	b := []byte{0x42}
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 && c&0x40 == 0 || v == -1 && c&0x40 != 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// wasmContract returns a module that imports the host functions imports, in order, and exports as
+// WASM_ENTRY a function returning an i64 that runs body, which ends with 0x0b. The entry is the function
+// after the imports; with pages above zero the module has a memory of that many pages.
+func wasmContract(imports []string, pages uint32, body ...byte) []byte {
+	// Type 0 is the entry's; each import gets its own.
+	types := []byte{byte(1 + len(imports)), 0x60, 0, 1, wasmI64}
+	importSection := []byte{byte(len(imports))}
+	for i, name := range imports {
+		h := wasmHosts[name]
+		types = append(types, 0x60, byte(h.params))
+		for range h.params {
+			types = append(types, wasmI64)
+		}
+		types = append(types, byte(h.results))
+		for range h.results {
+			types = append(types, wasmI64)
+		}
+		importSection = append(importSection, 3, 'e', 'n', 'v', byte(len(name)))
+		importSection = append(append(importSection, name...), 0x00, byte(1+i))
+	}
+	module := append([]byte(nil), transaction.WasmMagic...)
+	module = append(module, wasmSection(1, types...)...)
+	if len(imports) > 0 {
+		module = append(module, wasmSection(2, importSection...)...)
+	}
+	module = append(module, wasmSection(3, 1, 0)...)
+	if pages > 0 {
+		module = append(module, wasmSection(5, 1, 0, byte(pages))...)
+	}
+	module = append(module, wasmSection(7, append(append([]byte{1, byte(len(WASM_ENTRY))}, WASM_ENTRY...), 0x00, byte(len(imports)))...)...)
+	code := append([]byte{0}, body...) // no locals
+	return append(module, wasmSection(10, append(binary.AppendUvarint([]byte{1}, uint64(len(code))), code...)...)...)
+}
+
+// wasmCounter increments storage key 0 and returns its new value, like the VM's counter.
+func wasmCounter() []byte {
+	var body []byte
+	body = append(append(body, wasmI64Const(0)...), wasmI64Const(0)...)
+	body = append(append(body, 0x10, 0), wasmI64Const(1)...)       // storage_load
+	body = append(append(body, 0x7c, 0x10, 1), wasmI64Const(0)...) // i64.add, storage_store
+	return wasmContract([]string{"storage_load", "storage_store"}, 0, append(body, 0x10, 0, 0x0b)...)
+}
+
+func TestWasmContractsRunWithMeteredFuel(t *testing.T) {
+	call := newCall(0)
+	call.storage[0] = 41
+	result, used, err := runWasm(wasmCounter(), call, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The entry's invocation, 9 instructions, and the host functions: two loads and a store.
+	if result != 42 || used != 1+9+20+100+20 || call.storage[0] != 42 {
+		t.Fatalf("runWasm = %d using %d fuel, storage %v; want 42 using 150", result, used, call.storage)
+	}
+	if _, used, err := runWasm(wasmCounter(), newCall(0), 149); !errors.Is(err, ErrOutOfGas) || used != 149 {
+		t.Fatalf("runWasm with 149 fuel = %v using %d, want %v using all of it", err, used, ErrOutOfGas)
+	}
+
+	// Stores 42 at address 8 of its page and loads it back; the page costs WASM_PAGE_GAS.
+	memory := append(append([]byte{0x41, 8}, wasmI64Const(42)...), 0x37, 3, 0, 0x41, 8, 0x29, 3, 0, 0x0b)
+	if result, used, err := runWasm(wasmContract(nil, 1, memory...), newCall(0), 1000); err != nil || result != 42 || used != WASM_PAGE_GAS+1+6 {
+		t.Fatalf("memory round trip = %d using %d fuel, %v; want 42 using %d", result, used, err, WASM_PAGE_GAS+7)
+	}
+	// memory.grow past the limit returns -1 without charging for the pages.
+	grow := []byte{0x41, WASM_MAX_PAGES, 0x40, 0, 0xad, 0x0b}
+	if result, used, err := runWasm(wasmContract(nil, 1, grow...), newCall(0), 1000); err != nil || result != math.MaxUint32 || used != WASM_PAGE_GAS+1+4 {
+		t.Fatalf("memory.grow past the limit = %d using %d fuel, %v", result, used, err)
+	}
+}
+
+func TestWasmTrapsFailTheCall(t *testing.T) {
+	// The entry calls itself until the call depth runs out.
+	recurse := []byte{0x10, 0, 0x0b}
+	loop := append([]byte{0x03, wasmEmpty, 0x0c, 0, 0x0b}, append(wasmI64Const(0), 0x0b)...)
+	tests := []struct {
+		name    string
+		code    []byte
+		imports []string
+		pages   uint32
+		want    error
+	}{
+		{"unreachable", []byte{0x00, 0x0b}, nil, 0, ErrVMFault},
+		{"division by zero", append(append(wasmI64Const(1), wasmI64Const(0)...), 0x7f, 0x0b), nil, 0, ErrVMFault},
+		{"signed overflow", append(append(wasmI64Const(math.MinInt64), wasmI64Const(-1)...), 0x7f, 0x0b), nil, 0, ErrVMFault},
+		{"load out of bounds", []byte{0x41, 0x80, 0x80, 0x04, 0x29, 3, 0, 0x0b}, nil, 1, ErrVMFault},
+		{"stack underflow", []byte{0x7c, 0x0b}, nil, 0, ErrVMFault},
+		{"call depth", recurse, nil, 0, ErrVMFault},
+		{"paying more than the balance", append(wasmI64Const(11), 0x10, 0, 0x42, 0, 0x0b), []string{"pay"}, 0, ErrVMFault},
+		{"revert", []byte{0x10, 0, 0x42, 0, 0x0b}, []string{"revert"}, 0, ErrReverted},
+		{"endless loop", loop, nil, 0, ErrOutOfGas},
+	}
+	for _, tt := range tests {
+		_, used, err := runWasm(wasmContract(tt.imports, tt.pages, tt.code...), newCall(10), 10_000)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: runWasm = %v, want %v", tt.name, err, tt.want)
+		}
+		if used > 10_000 {
+			t.Errorf("%s: used %d fuel of 10000", tt.name, used)
+		}
+	}
+}
+
+func TestParseWasmRejectsUnsupportedModules(t *testing.T) {
+	valid := wasmContract(nil, 0, 0x42, 0, 0x0b)
+	if _, err := ParseWasm(valid); err != nil {
+		t.Fatalf("ParseWasm of a valid module = %v", err)
+	}
+	tests := []struct {
+		name string
+		code []byte
+	}{
+		{"no header", valid[4:]},
+		{"truncated", valid[:len(valid)-1]},
+		{"floating point", wasmContract(nil, 0, 0x44, 0, 0, 0, 0, 0, 0, 0, 0, 0x1a, 0x42, 0, 0x0b)},
+		{"unknown import", wasmContract([]string{"nope"}, 0, 0x42, 0, 0x0b)},
+		{"too much memory", wasmContract(nil, WASM_MAX_PAGES+1, 0x42, 0, 0x0b)},
+		{"memory instruction without a memory", wasmContract(nil, 0, 0x41, 0, 0x29, 3, 0, 0x0b)},
+		{"branch to an unknown label", wasmContract(nil, 0, 0x0c, 1, 0x42, 0, 0x0b)},
+		{"function without end", wasmContract(nil, 0, 0x42, 0)},
+		{"no entry", bytes.Replace(valid, []byte("\x04call"), []byte("\x04Call"), 1)},
+		{"section out of order", append(append([]byte(nil), valid...), wasmSection(8, 0)...)},
+	}
+	for _, tt := range tests {
+		if _, err := ParseWasm(tt.code); !errors.Is(err, ErrInvalidWasm) {
+			t.Errorf("%s: ParseWasm = %v, want %v", tt.name, err, ErrInvalidWasm)
+		}
+	}
+}
+
+func TestWasmContractsDeployAndRunOnChain(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	w := fund(t, bc, miner, transaction.COIN)
+	code := wasmCounter()
+	deployFee := transaction.Amount(transaction.CONTRACT_DEPLOY_GAS+transaction.CONTRACT_CODE_BYTE_GAS*len(code)) * transaction.GAS_PRICE
+	invalid := transaction.NewContractDeployment(w.BlockchainAddress(), code[:len(code)-1], 0, deployFee)
+	invalid.SetNonce(bc.NextNonce(w.BlockchainAddress()))
+	invalid.SetChainID(bc.ChainID())
+	if err := w.SignTransaction(invalid); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(invalid); !errors.Is(err, transaction.ErrInvalidContract) {
+		t.Fatalf("AddTransaction of a truncated module = %v, want %v", err, transaction.ErrInvalidContract)
+	}
+
+	address := sendContract(t, bc, w, transaction.NewContractDeployment(w.BlockchainAddress(), code, 0, deployFee)).ContractAddress()
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	call := sendContract(t, bc, w, transaction.NewContractCall(w.BlockchainAddress(), address, nil, 0, 1000*transaction.GAS_PRICE))
+	if !bc.Mining(context.Background()) {
+		t.Fatal("mining failed")
+	}
+	r, ok := bc.Receipt(call.Hash())
+	if want := uint64(transaction.CONTRACT_CALL_GAS + 150); !ok || !r.Success || r.Result != 1 || r.GasUsed != want {
+		t.Fatalf("receipt = %+v, %v; want result 1 using %d gas", r, ok, want)
+	}
+	if c, _, _ := bc.Contract(address); c.Storage()[0] != 1 {
+		t.Fatalf("storage = %v, want the counter at 1", c.Storage())
+	}
+}
@@ -0,0 +1,163 @@
+package node
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	// WEBSOCKET_GUID is the fixed key suffix from RFC 6455 used to compute Sec-WebSocket-Accept.
+	WEBSOCKET_GUID             = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	WEBSOCKET_MAX_PAYLOAD_SIZE = 1 << 20
+
+	WEBSOCKET_OP_CONTINUATION = 0x0
+	WEBSOCKET_OP_TEXT         = 0x1
+	WEBSOCKET_OP_BINARY       = 0x2
+	WEBSOCKET_OP_CLOSE        = 0x8
+	WEBSOCKET_OP_PING         = 0x9
+	WEBSOCKET_OP_PONG         = 0xA
+)
+
+var (
+	ErrNotWebSocket         = errors.New("not a websocket handshake")
+	ErrWebSocketUnmasked    = errors.New("client websocket frame is not masked")
+	ErrWebSocketFrameTooBig = errors.New("websocket frame too large")
+)
+
+// WebSocketConn is a minimal server side RFC 6455 connection: it writes unfragmented frames and reads
+// masked client frames. It is safe for one reader and concurrent writers.
+type WebSocketConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	mux  sync.Mutex
+}
+
+// UpgradeWebSocket performs the opening handshake and takes over the HTTP connection.
+// On error nothing has been written, so the caller can still reply with a normal HTTP status.
+func UpgradeWebSocket(w http.ResponseWriter, req *http.Request) (*WebSocketConn, error) {
+	if req.Method != http.MethodGet ||
+		!headerContains(req.Header, "Connection", "upgrade") ||
+		!headerContains(req.Header, "Upgrade", "websocket") ||
+		req.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, ErrNotWebSocket
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, ErrNotWebSocket
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("connection cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	h := sha1.Sum([]byte(key + WEBSOCKET_GUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(h[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &WebSocketConn{conn: conn, rw: rw}, nil
+}
+
+// headerContains reports whether the comma-separated header contains token, ignoring case.
+func headerContains(header http.Header, name, token string) bool {
+	for _, v := range header.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WriteText sends payload as a single text frame.
+func (c *WebSocketConn) WriteText(payload []byte) error {
+	return c.WriteFrame(WEBSOCKET_OP_TEXT, payload)
+}
+
+// WriteFrame sends one final, unmasked frame with the given opcode.
+func (c *WebSocketConn) WriteFrame(opcode byte, payload []byte) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if _, err := c.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(payload); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// ReadFrame reads one client frame and returns its opcode and unmasked payload.
+// Fragmented messages are returned frame by frame.
+func (c *WebSocketConn) ReadFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.rw, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0F
+	if head[1]&0x80 == 0 {
+		return 0, nil, ErrWebSocketUnmasked
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > WEBSOCKET_MAX_PAYLOAD_SIZE {
+		return 0, nil, ErrWebSocketFrameTooBig
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// Close sends a close frame and closes the underlying connection.
+func (c *WebSocketConn) Close() error {
+	c.WriteFrame(WEBSOCKET_OP_CLOSE, nil)
+	return c.conn.Close()
+}
@@ -0,0 +1,449 @@
+package node
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"math/big"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dikako/how-blockchain-works/internal/httputil"
+)
+
+// The standard library has no CBOR, so this file carries the small part of it (RFC 8949) node-to-node
+// messages need. Messages keep their JSON types: the binary form is their JSON document in CBOR, so every
+// message decodes through the same UnmarshalJSON checks whichever format it came in.
+
+const (
+	WIRE_FORMAT_JSON = "json"
+	WIRE_FORMAT_CBOR = "cbor"
+
+	MIME_JSON = "application/json"
+	MIME_CBOR = "application/cbor"
+
+	// WIRE_MAX_DEPTH bounds how deeply a received CBOR message may nest.
+	WIRE_MAX_DEPTH = 256
+	// WIRE_MAX_RESPONSE_SIZE bounds a neighbor's response, which may carry the whole chain; requests neighbors
+	// send are bounded by RPC_MAX_BODY_SIZE.
+	WIRE_MAX_RESPONSE_SIZE = 64 * 1024 * 1024
+	// cborTagUint8Array marks a byte string that stands for a JSON array of small integers (RFC 8746).
+	cborTagUint8Array = 64
+)
+
+var (
+	ErrUnknownWireFormat = errors.New("unknown wire format")
+	ErrInvalidCBOR       = errors.New("invalid CBOR message")
+	ErrMessageTooLarge   = errors.New("message too large")
+)
+
+// wireKeys are the JSON keys sent as small integers instead of text: the position of a key is its code.
+// Transactions and blocks come first, so their keys take one byte. Append new keys only: codes are shared
+// by every node on the network.
+var wireKeys = []string{
+	"sender_blockchain_address", "recipient_blockchain_address", "value", "fee", "nonce", "chain_id", "version",
+	"sender_public_key", "signature", "timestamp", "previous_hash", "merkle_root", "state_root", "transactions",
+	"signer_public_key", "pruned", "memo", "data", "lock_time", "multisig", "locking_script", "unlocking_script",
+	"contract", "code", "input", "token", "asset", "htlc", "chain", "transaction_pool", "blockchain_address",
+	"hasher", "header", "transaction_ids", "prefilled", "status", "missing", "block", "height", "hash", "blocks",
+	"next_offset", "peers", "block_hash", "issued", "balances", "headers", "contracts", "tokens", "assets",
+	"nonces", "hashes", "offset", "limit", "responder", "contacts", "id", "address", "accounts_root",
+}
+
+// wireKeyCodes maps each of wireKeys to its code.
+var wireKeyCodes = func() map[string]uint64 {
+	codes := make(map[string]uint64, len(wireKeys))
+	for i, k := range wireKeys {
+		codes[k] = uint64(i)
+	}
+	return codes
+}()
+
+// ValidateWireFormat returns ErrUnknownWireFormat unless format is WIRE_FORMAT_JSON or WIRE_FORMAT_CBOR.
+func ValidateWireFormat(format string) error {
+	if format != WIRE_FORMAT_JSON && format != WIRE_FORMAT_CBOR {
+		return fmt.Errorf("%w %q (available: %s, %s)", ErrUnknownWireFormat, format, WIRE_FORMAT_CBOR, WIRE_FORMAT_JSON)
+	}
+	return nil
+}
+
+// marshalPeer encodes v in format, returning the body and its content type.
+func marshalPeer(format string, v any) ([]byte, string, error) {
+	if format == WIRE_FORMAT_CBOR {
+		m, err := marshalCBOR(v)
+		return m, MIME_CBOR, err
+	}
+	m, err := json.Marshal(v)
+	return m, MIME_JSON, err
+}
+
+// decodePeer decodes the body r of a request or response into v by its contentType, CBOR or JSON. It returns
+// ErrMessageTooLarge, without decoding, if the body is longer than limit bytes.
+func decodePeer(r io.Reader, contentType string, limit int64, v any) error {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > limit {
+		return fmt.Errorf("%w: over %d bytes", ErrMessageTooLarge, limit)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == MIME_CBOR {
+		return unmarshalCBOR(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writePeer is httputil.WriteJSON for the endpoints neighbors call: it answers in CBOR when req accepts it.
+func writePeer(w http.ResponseWriter, req *http.Request, status int, v any) {
+	if !strings.Contains(req.Header.Get("Accept"), MIME_CBOR) {
+		httputil.WriteJSON(w, status, v)
+		return
+	}
+	m, err := marshalCBOR(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", MIME_CBOR)
+	w.WriteHeader(status)
+	w.Write(m)
+}
+
+// marshalCBOR encodes v's JSON document in CBOR. Lowercase hex strings become byte strings and arrays of
+// integers 0-255, such as hashes, become uint8 typed arrays, both half their JSON size or less, and keys
+// in wireKeys become their codes.
+func marshalCBOR(v any) ([]byte, error) {
+	m, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(m))
+	d.UseNumber()
+	var doc any
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return appendCBOR(nil, doc)
+}
+
+// appendCBOR appends the CBOR encoding of doc, a JSON value decoded with UseNumber, to b.
+func appendCBOR(b []byte, doc any) ([]byte, error) {
+	switch v := doc.(type) {
+	case nil:
+		return append(b, 0xf6), nil
+	case bool:
+		if v {
+			return append(b, 0xf5), nil
+		}
+		return append(b, 0xf4), nil
+	case json.Number:
+		return appendCBORNumber(b, v)
+	case string:
+		if isWireHex(v) {
+			s, _ := hex.DecodeString(v)
+			return append(appendCBORHead(b, 2, uint64(len(s))), s...), nil
+		}
+		return append(appendCBORHead(b, 3, uint64(len(v))), v...), nil
+	case []any:
+		if s, ok := wireBytes(v); ok {
+			b = appendCBORHead(b, 6, cborTagUint8Array)
+			return append(appendCBORHead(b, 2, uint64(len(s))), s...), nil
+		}
+		b = appendCBORHead(b, 4, uint64(len(v)))
+		for _, e := range v {
+			var err error
+			if b, err = appendCBOR(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]any:
+		b = appendCBORHead(b, 5, uint64(len(v)))
+		// Sorted keys make the encoding of a message deterministic.
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			if code, ok := wireKeyCodes[k]; ok {
+				b = appendCBORHead(b, 0, code)
+			} else {
+				b = append(appendCBORHead(b, 3, uint64(len(k))), k...)
+			}
+			var err error
+			if b, err = appendCBOR(b, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("cannot encode %T in CBOR", doc)
+}
+
+// appendCBORNumber appends n as a CBOR integer when it is one and as a float64 otherwise.
+func appendCBORNumber(b []byte, n json.Number) ([]byte, error) {
+	if i, err := n.Int64(); err == nil {
+		if i < 0 {
+			return appendCBORHead(b, 1, uint64(-1-i)), nil
+		}
+		return appendCBORHead(b, 0, uint64(i)), nil
+	}
+	if u, err := strconv.ParseUint(n.String(), 10, 64); err == nil {
+		return appendCBORHead(b, 0, u), nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil, err
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xfb), math.Float64bits(f)), nil
+}
+
+// appendCBORHead appends the head of a CBOR item: its major type and n, a value, length, or tag, in the
+// fewest bytes.
+func appendCBORHead(b []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major|27), n)
+}
+
+// isWireHex reports whether s is lowercase hex of whole bytes, which decodes back to the same string.
+func isWireHex(s string) bool {
+	if s == "" || len(s)%2 != 0 {
+		return false
+	}
+	for i := range len(s) {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// wireBytes returns a as bytes if it holds at least two integers, all from 0 to 255.
+func wireBytes(a []any) ([]byte, bool) {
+	if len(a) < 2 {
+		return nil, false
+	}
+	s := make([]byte, len(a))
+	for i, e := range a {
+		n, ok := e.(json.Number)
+		if !ok {
+			return nil, false
+		}
+		// Only the canonical form, so that decoding gives back the same text.
+		u, err := strconv.ParseUint(n.String(), 10, 8)
+		if err != nil || strconv.FormatUint(u, 10) != n.String() {
+			return nil, false
+		}
+		s[i] = byte(u)
+	}
+	return s, true
+}
+
+// unmarshalCBOR decodes data, a message encoded by marshalCBOR, into v through v's JSON decoding.
+func unmarshalCBOR(data []byte, v any) error {
+	d := cborDecoder{data: data}
+	var doc bytes.Buffer
+	if err := d.value(&doc, 0); err != nil {
+		return err
+	}
+	if d.off != len(data) {
+		return fmt.Errorf("%w: %d bytes after the message", ErrInvalidCBOR, len(data)-d.off)
+	}
+	return json.Unmarshal(doc.Bytes(), v)
+}
+
+// cborDecoder turns a CBOR message back into the JSON document it encodes.
+type cborDecoder struct {
+	data []byte
+	off  int
+}
+
+// head reads the head of the next item: its major type and argument, or for major type 7, simple values and
+// floats, its additional information.
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.off >= len(d.data) {
+		return 0, 0, fmt.Errorf("%w: unexpected end", ErrInvalidCBOR)
+	}
+	major, info := d.data[d.off]>>5, uint64(d.data[d.off]&0x1f)
+	d.off++
+	size := 0
+	switch {
+	case info < 24, major == 7:
+		return major, info, nil
+	case info <= 27:
+		size = 1 << (info - 24)
+	default:
+		return 0, 0, fmt.Errorf("%w: unsupported additional information %d", ErrInvalidCBOR, info)
+	}
+	if len(d.data)-d.off < size {
+		return 0, 0, fmt.Errorf("%w: unexpected end", ErrInvalidCBOR)
+	}
+	var n uint64
+	for _, c := range d.data[d.off : d.off+size] {
+		n = n<<8 | uint64(c)
+	}
+	d.off += size
+	return major, n, nil
+}
+
+// bytes reads the n bytes of a string.
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.off) {
+		return nil, fmt.Errorf("%w: unexpected end", ErrInvalidCBOR)
+	}
+	s := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return s, nil
+}
+
+// value writes the JSON text of the next item to doc.
+func (d *cborDecoder) value(doc *bytes.Buffer, depth int) error {
+	if depth > WIRE_MAX_DEPTH {
+		return fmt.Errorf("%w: nested more than %d deep", ErrInvalidCBOR, WIRE_MAX_DEPTH)
+	}
+	major, n, err := d.head()
+	if err != nil {
+		return err
+	}
+	switch major {
+	case 0:
+		doc.WriteString(strconv.FormatUint(n, 10))
+	case 1:
+		doc.WriteString(new(big.Int).Not(new(big.Int).SetUint64(n)).String())
+	case 2:
+		s, err := d.bytes(n)
+		if err != nil {
+			return err
+		}
+		doc.WriteByte('"')
+		doc.Write(hex.AppendEncode(doc.AvailableBuffer(), s))
+		doc.WriteByte('"')
+	case 3:
+		s, err := d.bytes(n)
+		if err != nil {
+			return err
+		}
+		writeJSONString(doc, s)
+	case 4:
+		doc.WriteByte('[')
+		for i := range n {
+			if i > 0 {
+				doc.WriteByte(',')
+			}
+			if err := d.value(doc, depth+1); err != nil {
+				return err
+			}
+		}
+		doc.WriteByte(']')
+	case 5:
+		doc.WriteByte('{')
+		for i := range n {
+			if i > 0 {
+				doc.WriteByte(',')
+			}
+			if err := d.key(doc); err != nil {
+				return err
+			}
+			doc.WriteByte(':')
+			if err := d.value(doc, depth+1); err != nil {
+				return err
+			}
+		}
+		doc.WriteByte('}')
+	case 6:
+		if n != cborTagUint8Array {
+			return fmt.Errorf("%w: unsupported tag %d", ErrInvalidCBOR, n)
+		}
+		if major, n, err = d.head(); err != nil {
+			return err
+		}
+		if major != 2 {
+			return fmt.Errorf("%w: typed array is not a byte string", ErrInvalidCBOR)
+		}
+		s, err := d.bytes(n)
+		if err != nil {
+			return err
+		}
+		doc.WriteByte('[')
+		for i, c := range s {
+			if i > 0 {
+				doc.WriteByte(',')
+			}
+			doc.WriteString(strconv.Itoa(int(c)))
+		}
+		doc.WriteByte(']')
+	case 7:
+		switch n {
+		case 20:
+			doc.WriteString("false")
+		case 21:
+			doc.WriteString("true")
+		case 22:
+			doc.WriteString("null")
+		case 27:
+			s, err := d.bytes(8)
+			if err != nil {
+				return err
+			}
+			f := math.Float64frombits(binary.BigEndian.Uint64(s))
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return fmt.Errorf("%w: %v is not a JSON number", ErrInvalidCBOR, f)
+			}
+			doc.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+		default:
+			return fmt.Errorf("%w: unsupported simple value %d", ErrInvalidCBOR, n)
+		}
+	}
+	return nil
+}
+
+// key writes the JSON text of the next map key, a text string or a code from wireKeys, to doc.
+func (d *cborDecoder) key(doc *bytes.Buffer) error {
+	major, n, err := d.head()
+	if err != nil {
+		return err
+	}
+	switch {
+	case major == 0 && n < uint64(len(wireKeys)):
+		writeJSONString(doc, []byte(wireKeys[n]))
+	case major == 3:
+		s, err := d.bytes(n)
+		if err != nil {
+			return err
+		}
+		writeJSONString(doc, s)
+	default:
+		return fmt.Errorf("%w: unsupported map key", ErrInvalidCBOR)
+	}
+	return nil
+}
+
+// writeJSONString writes s to doc as a JSON string. Addresses, keys, and most other strings are printable
+// ASCII that needs no escaping and is copied as is; json.Marshal escapes the rest.
+func writeJSONString(doc *bytes.Buffer, s []byte) {
+	for _, c := range s {
+		if c < 0x20 || c >= 0x7f || c == '"' || c == '\\' {
+			m, _ := json.Marshal(string(s))
+			doc.Write(m)
+			return
+		}
+	}
+	doc.WriteByte('"')
+	doc.Write(s)
+	doc.WriteByte('"')
+}
@@ -0,0 +1,111 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/block"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+func TestCBORRoundTripsJSONDocuments(t *testing.T) {
+	// Each document is compact with sorted keys, the form it decodes back to.
+	docs := []string{
+		`null`, `true`, `false`, `0`, `23`, `24`, `65536`, `-1`, `-9223372036854775808`, `18446744073709551615`, `1.5`,
+		`""`, `"abcd"`, `"ABCD"`, `"abc"`, `"a\"b\n"`, `"é"`,
+		`[]`, `[1]`, `[0,255]`, `[255,256]`, `[1,-1]`, `[[1,2],{"zzz":null}]`,
+		`{"chain_id":"test","nonce":7,"unknown_key":[3,4]}`,
+	}
+	for _, doc := range docs {
+		m, err := marshalCBOR(json.RawMessage(doc))
+		if err != nil {
+			t.Fatalf("marshalCBOR(%s) = %v", doc, err)
+		}
+		var got json.RawMessage
+		if err := unmarshalCBOR(m, &got); err != nil {
+			t.Fatalf("unmarshalCBOR of %s = %v", doc, err)
+		}
+		if string(got) != doc {
+			t.Errorf("%s round trips to %s", doc, got)
+		}
+	}
+	// Hex strings travel as bytes, and the first wire keys as one byte codes.
+	if m, _ := marshalCBOR(map[string]string{"previous_hash": "00ff"}); !bytes.Equal(m, []byte{0xa1, byte(wireKeyCodes["previous_hash"]), 0x42, 0x00, 0xff}) {
+		t.Fatalf("marshalCBOR of a previous hash = %x", m)
+	}
+}
+
+func TestBlocksRoundTripThroughCBORSmallerThanJSON(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	fund(t, bc, miner, transaction.COIN/2)
+	b := bc.LastBlock()
+	m, contentType, err := marshalPeer(WIRE_FORMAT_CBOR, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got block.Block
+	if err := decodePeer(bytes.NewReader(m), contentType, int64(len(m)), &got); err != nil {
+		t.Fatal(err)
+	}
+	if bc.BlockHash(&got) != bc.BlockHash(b) || len(got.Transactions()) != len(b.Transactions()) || got.Transactions()[0].Hash() != b.Transactions()[0].Hash() {
+		t.Fatal("the block changed in its CBOR round trip")
+	}
+	if j, _ := json.Marshal(b); len(m) >= len(j)*2/3 {
+		t.Fatalf("the block takes %d bytes in CBOR and %d in JSON, want at least a third less", len(m), len(j))
+	}
+	if !bc.ValidChain(append(bc.Chain()[:1], &got)) {
+		t.Fatal("the decoded block is not valid on the chain")
+	}
+}
+
+func TestMalformedCBORIsRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated head", []byte{0x19, 0x01}},
+		{"truncated string", []byte{0x62, 'a'}},
+		{"trailing bytes", []byte{0xf6, 0xf6}},
+		{"indefinite length", []byte{0x9f, 0xff}},
+		{"unknown tag", []byte{0xc1, 0x00}},
+		{"typed array of integers", []byte{0xd8, cborTagUint8Array, 0x01}},
+		{"undefined", []byte{0xf7}},
+		{"NaN", []byte{0xfb, 0x7f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"unknown key code", []byte{0xa1, 0x18, 0xff, 0xf6}},
+		{"array key", []byte{0xa1, 0x80, 0xf6}},
+	}
+	for _, tt := range tests {
+		var v any
+		if err := unmarshalCBOR(tt.data, &v); !errors.Is(err, ErrInvalidCBOR) {
+			t.Errorf("%s: unmarshalCBOR = %v, want %v", tt.name, err, ErrInvalidCBOR)
+		}
+	}
+}
+
+func TestPeersGetCBOROnlyWhenTheyAcceptIt(t *testing.T) {
+	if err := ValidateWireFormat("xml"); !errors.Is(err, ErrUnknownWireFormat) {
+		t.Fatalf("ValidateWireFormat(xml) = %v, want %v", err, ErrUnknownWireFormat)
+	}
+	for accept, want := range map[string]string{"": MIME_JSON, MIME_JSON: MIME_JSON, MIME_CBOR + ", " + MIME_JSON: MIME_CBOR} {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/chain", nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		writePeer(rec, req, http.StatusOK, map[string]int{"height": 1})
+		var got struct {
+			Height int `json:"height"`
+		}
+		if err := decodePeer(rec.Body, rec.Header().Get("Content-Type"), RPC_MAX_BODY_SIZE, &got); err != nil || got.Height != 1 {
+			t.Errorf("Accept %q: %v, height %d", accept, err, got.Height)
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, want) {
+			t.Errorf("Accept %q: content type %q, want %q", accept, ct, want)
+		}
+	}
+}
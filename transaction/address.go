@@ -0,0 +1,103 @@
+package transaction
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"math/big"
+)
+
+const (
+	ADDRESS_VERSION = 0x00
+	// SCRIPT_ADDRESS_VERSION marks addresses that hash a spending condition, a multisig or a script, which
+	// start with "3" as Bitcoin's P2SH addresses do.
+	SCRIPT_ADDRESS_VERSION = 0x05
+	ADDRESS_CHECKSUM_BYTES = 4
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// NewAddress derives a Base58Check blockchain address from a public key:
+// version byte + RIPEMD160(SHA256(pubkey)), followed by a 4-byte double-SHA256 checksum.
+func NewAddress(publicKey *ecdsa.PublicKey) string {
+	b, _ := publicKey.Bytes()
+	return hashAddress(ADDRESS_VERSION, b)
+}
+
+// hashAddress encodes version + RIPEMD160(SHA256(data)) with its checksum in Base58Check.
+func hashAddress(version byte, data []byte) string {
+	h := sha256.Sum256(data)
+	hash := Ripemd160(h[:])
+
+	payload := append([]byte{version}, hash[:]...)
+	return Base58Encode(append(payload, addressChecksum(payload)...))
+}
+
+// ValidateAddress reports whether address is well-formed Base58Check with a known version, of a single key
+// or of a multisig condition, and a matching checksum.
+func ValidateAddress(address string) bool {
+	decoded, ok := Base58Decode(address)
+	if !ok || len(decoded) != 1+20+ADDRESS_CHECKSUM_BYTES {
+		return false
+	}
+	payload := decoded[:len(decoded)-ADDRESS_CHECKSUM_BYTES]
+	checksum := decoded[len(decoded)-ADDRESS_CHECKSUM_BYTES:]
+	return (payload[0] == ADDRESS_VERSION || payload[0] == SCRIPT_ADDRESS_VERSION) && bytes.Equal(checksum, addressChecksum(payload))
+}
+
+// addressHash returns the 20-byte hash and the version byte a valid address encodes.
+func addressHash(address string) ([]byte, byte, bool) {
+	if !ValidateAddress(address) {
+		return nil, 0, false
+	}
+	decoded, _ := Base58Decode(address)
+	return decoded[1 : 1+20], decoded[0], true
+}
+
+// addressChecksum returns the first ADDRESS_CHECKSUM_BYTES of SHA256(SHA256(payload)).
+func addressChecksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return second[:ADDRESS_CHECKSUM_BYTES]
+}
+
+// Base58Encode encodes b using the Bitcoin Base58 alphabet, keeping leading zero bytes as '1'.
+func Base58Encode(b []byte) string {
+	x := new(big.Int).SetBytes(b)
+	base := big.NewInt(58)
+	mod := new(big.Int)
+	var out []byte
+	for x.Sign() > 0 {
+		x.DivMod(x, base, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+// Base58Decode decodes a Base58 string, returning false if it contains characters outside the alphabet.
+func Base58Decode(s string) ([]byte, bool) {
+	x := new(big.Int)
+	base := big.NewInt(58)
+	for _, c := range []byte(s) {
+		i := bytes.IndexByte([]byte(base58Alphabet), c)
+		if i < 0 {
+			return nil, false
+		}
+		x.Mul(x, base)
+		x.Add(x, big.NewInt(int64(i)))
+	}
+	var leading int
+	for leading < len(s) && s[leading] == base58Alphabet[0] {
+		leading++
+	}
+	return append(make([]byte, leading), x.Bytes()...), true
+}
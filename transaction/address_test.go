@@ -0,0 +1,37 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestAddressesAreBase58CheckOfThePublicKeyHash(t *testing.T) {
+	if got := Ripemd160([]byte("abc")); hex.EncodeToString(got[:]) != "8eb208f7e05d987a9b044a8e98c6b087f15a0bfc" {
+		t.Fatalf("RIPEMD-160(abc) = %x", got)
+	}
+	// The example from the Bitcoin wiki's technical background of version 1 addresses.
+	raw, _ := hex.DecodeString("00010966776006953D5567439E5E39F86A0D273BEED61967F6")
+	const address = "16UwLL9Risc3QfPqBUvKofHmBQ7wMtjvM"
+	if got := Base58Encode(raw); got != address {
+		t.Fatalf("Base58Encode = %s, want %s", got, address)
+	}
+	if decoded, ok := Base58Decode(address); !ok || !bytes.Equal(decoded, raw) {
+		t.Fatalf("Base58Decode = %x, %t", decoded, ok)
+	}
+	if !ValidateAddress(address) {
+		t.Fatal("the wiki's address is invalid")
+	}
+	if ValidateAddress(strings.Replace(address, "U", "V", 1)) {
+		t.Fatal("an address with a bad checksum is valid")
+	}
+
+	key, sender := newKey(t)
+	if !strings.HasPrefix(sender, "1") || !ValidateAddress(sender) {
+		t.Fatalf("NewAddress = %s", sender)
+	}
+	if NewAddress(&key.PublicKey) != sender {
+		t.Fatal("NewAddress is not deterministic")
+	}
+}
@@ -0,0 +1,80 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Amount is a quantity of coins expressed in the smallest indivisible unit, like satoshis.
+// Integer arithmetic keeps balances exact, unlike float32.
+type Amount int64
+
+const (
+	AMOUNT_DECIMALS        = 8
+	COIN            Amount = 100_000_000
+	// MAX_MONEY bounds a transaction's value and fee, like Bitcoin's MAX_MONEY, so that sums of a few
+	// amounts cannot overflow an Amount. It is far above any supply the node issues.
+	MAX_MONEY Amount = 21_000_000 * COIN
+)
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// AmountFromFloat converts a coin value such as 1.5 into an Amount, rounding to the nearest unit.
+func AmountFromFloat(f float64) Amount {
+	return Amount(math.Round(f * float64(COIN)))
+}
+
+// ParseAmount parses a decimal coin string such as "1.5" or "0.00000001" exactly, without going through float.
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return 0, ErrInvalidAmount
+	}
+	if strings.Trim(whole+frac, "0123456789") != "" {
+		return 0, fmt.Errorf("%w: %q is not a decimal number", ErrInvalidAmount, s)
+	}
+	if len(frac) > AMOUNT_DECIMALS {
+		return 0, fmt.Errorf("%w: more than %d decimal places", ErrInvalidAmount, AMOUNT_DECIMALS)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	frac += strings.Repeat("0", AMOUNT_DECIMALS-len(frac))
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidAmount, err)
+	}
+	f, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidAmount, err)
+	}
+	if w > (math.MaxInt64-f)/int64(COIN) {
+		return 0, fmt.Errorf("%w: out of range", ErrInvalidAmount)
+	}
+	a := Amount(w)*COIN + Amount(f)
+	if negative {
+		a = -a
+	}
+	return a, nil
+}
+
+// Float64 returns the amount in coins; use it only for display or float-based APIs.
+func (a Amount) Float64() float64 {
+	return float64(a) / float64(COIN)
+}
+
+// String formats the amount in coins with all AMOUNT_DECIMALS decimal places, e.g. "1.50000000".
+func (a Amount) String() string {
+	sign := ""
+	if a < 0 {
+		sign = "-"
+		a = -a
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, a/COIN, AMOUNT_DECIMALS, a%COIN)
+}
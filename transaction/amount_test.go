@@ -0,0 +1,39 @@
+package transaction
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseAmountIsExact(t *testing.T) {
+	for s, want := range map[string]Amount{
+		"1.5":        3 * COIN / 2,
+		"0.00000001": 1,
+		".1":         COIN / 10,
+		"2":          2 * COIN,
+		" 7.0 ":      7 * COIN,
+		"-0.5":       -COIN / 2,
+		// 0.1 + 0.2 is not 0.3 in floating point, but it is in units.
+		"0.3": 30_000_000,
+	} {
+		got, err := ParseAmount(s)
+		if err != nil || got != want {
+			t.Errorf("ParseAmount(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", ".", "abc", "1.123456789", "1.-5", "1.+5", "--1", "1e3", "92233720369"} {
+		if got, err := ParseAmount(s); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("ParseAmount(%q) = %d, %v, want %v", s, got, err, ErrInvalidAmount)
+		}
+	}
+
+	if got := AmountFromFloat(0.1) + AmountFromFloat(0.2); got != 30_000_000 {
+		t.Fatalf("AmountFromFloat(0.1) + AmountFromFloat(0.2) = %d", got)
+	}
+	if got := (3 * COIN / 2).String(); got != "1.50000000" {
+		t.Fatalf("String = %s", got)
+	}
+	if got := Amount(-1).String(); got != "-0.00000001" {
+		t.Fatalf("String of -1 = %s", got)
+	}
+}
@@ -0,0 +1,138 @@
+package transaction
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	ASSET_MINT     = "mint"
+	ASSET_TRANSFER = "transfer"
+
+	// MAX_ASSET_ID is the longest asset ID; IDs are letters, digits, and "-", "_", ".", or ":".
+	MAX_ASSET_ID = 64
+	// ASSET_MINT_FEE is the least fee minting an asset pays, so IDs are not claimed for free.
+	ASSET_MINT_FEE Amount = 100_000
+)
+
+var ErrInvalidAsset = errors.New("invalid asset transaction")
+
+// AssetOp is what an asset transaction does: mint the unique asset id, owned by the sender, whose metadata
+// (an image, a document) hashes to metadata, or transfer it to the recipient.
+type AssetOp struct {
+	kind     string
+	id       string
+	metadata [32]byte
+}
+
+// MarshalJSON encodes the operation as {"type", "id", "metadata"}, the metadata hash hex-encoded and left
+// out of transfers.
+func (op *AssetOp) MarshalJSON() ([]byte, error) {
+	var metadata string
+	if op.metadata != ([32]byte{}) {
+		metadata = hex.EncodeToString(op.metadata[:])
+	}
+	return json.Marshal(struct {
+		Type     string `json:"type"`
+		ID       string `json:"id"`
+		Metadata string `json:"metadata,omitempty"`
+	}{op.kind, op.id, metadata})
+}
+
+// UnmarshalJSON decodes an operation written by MarshalJSON.
+func (op *AssetOp) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Type     string `json:"type"`
+		ID       string `json:"id"`
+		Metadata string `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	op.kind, op.id, op.metadata = v.Type, v.ID, [32]byte{}
+	if v.Metadata != "" {
+		h, err := HashFromString(v.Metadata)
+		if err != nil {
+			return fmt.Errorf("invalid metadata: %w", err)
+		}
+		op.metadata = h
+	}
+	return nil
+}
+
+// Kind returns ASSET_MINT or ASSET_TRANSFER.
+func (op *AssetOp) Kind() string {
+	return op.kind
+}
+
+// ID returns the ID of the asset the operation mints or transfers.
+func (op *AssetOp) ID() string {
+	return op.id
+}
+
+// Metadata returns the hash of the asset's metadata, zero for a transfer.
+func (op *AssetOp) Metadata() [32]byte {
+	return op.metadata
+}
+
+// Asset returns what asset transaction t does, or nil for other transactions.
+func (t *Transaction) Asset() *AssetOp {
+	return t.asset
+}
+
+// NewAssetMint constructs an unsigned transaction from owner that mints the asset id with the hash of its
+// metadata, paying fee, at least ASSET_MINT_FEE, to the miner.
+func NewAssetMint(owner string, id string, metadata [32]byte, fee Amount) *Transaction {
+	t := NewTransaction(owner, "", 0, fee)
+	t.asset = &AssetOp{kind: ASSET_MINT, id: id, metadata: metadata}
+	return t
+}
+
+// NewAssetTransfer constructs an unsigned transaction that hands the asset id from its owner, sender, to
+// recipient, paying fee in coins to the miner.
+func NewAssetTransfer(sender string, recipient string, id string, fee Amount) *Transaction {
+	t := NewTransaction(sender, recipient, 0, fee)
+	t.asset = &AssetOp{kind: ASSET_TRANSFER, id: id}
+	return t
+}
+
+// ValidAssetID reports whether id is 1 to MAX_ASSET_ID letters, digits, and "-", "_", ".", or ":".
+func ValidAssetID(id string) bool {
+	if id == "" || len(id) > MAX_ASSET_ID {
+		return false
+	}
+	for _, c := range id {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' && c != '_' && c != '.' && c != ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckAsset returns why asset transaction t is malformed, or nil: it moves a valid asset ID and no coins, a
+// mint has no recipient, a metadata hash, and pays ASSET_MINT_FEE, and a transfer has a valid recipient and
+// no metadata. Whether the ID is free or the sender owns it depends on the chain; see assetMoves.
+func (t *Transaction) CheckAsset() error {
+	op := t.asset
+	switch {
+	case op.kind != ASSET_MINT && op.kind != ASSET_TRANSFER:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidAsset, op.kind)
+	case !ValidAssetID(op.id):
+		return fmt.Errorf("%w: ID %q is not 1 to %d letters, digits, and -_.:", ErrInvalidAsset, op.id, MAX_ASSET_ID)
+	case t.value != 0 || t.fee < 0 || t.IsDataCarrier() || t.contract != "" || t.token != nil:
+		return fmt.Errorf("%w: it moves no coins, data, contracts, or tokens", ErrInvalidAsset)
+	case op.kind == ASSET_MINT && t.recipientBlockchainAddress != "":
+		return fmt.Errorf("%w: a mint has no recipient", ErrInvalidAsset)
+	case op.kind == ASSET_MINT && op.metadata == [32]byte{}:
+		return fmt.Errorf("%w: a mint needs a metadata hash", ErrInvalidAsset)
+	case op.kind == ASSET_MINT && t.fee < ASSET_MINT_FEE:
+		return fmt.Errorf("%w: fee %s below %s", ErrInvalidAsset, t.fee, ASSET_MINT_FEE)
+	case op.kind == ASSET_TRANSFER && !ValidateAddress(t.recipientBlockchainAddress):
+		return fmt.Errorf("%w: invalid recipient", ErrInvalidAsset)
+	case op.kind == ASSET_TRANSFER && op.metadata != [32]byte{}:
+		return fmt.Errorf("%w: a transfer carries no metadata", ErrInvalidAsset)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package transaction
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckAssetRejectsMalformedOperations(t *testing.T) {
+	_, owner := newKey(t)
+	_, recipient := newKey(t)
+	metadata := sha256.Sum256([]byte("artwork.png"))
+	tests := []struct {
+		name string
+		tx   func() *Transaction
+		want error
+	}{
+		{"mint", func() *Transaction { return NewAssetMint(owner, "art:1", metadata, ASSET_MINT_FEE) }, nil},
+		{"transfer", func() *Transaction { return NewAssetTransfer(owner, recipient, "art:1", 0) }, nil},
+		{"empty ID", func() *Transaction { return NewAssetMint(owner, "", metadata, ASSET_MINT_FEE) }, ErrInvalidAsset},
+		{"long ID", func() *Transaction {
+			return NewAssetMint(owner, strings.Repeat("a", MAX_ASSET_ID+1), metadata, ASSET_MINT_FEE)
+		}, ErrInvalidAsset},
+		{"ID with a space", func() *Transaction { return NewAssetMint(owner, "art 1", metadata, ASSET_MINT_FEE) }, ErrInvalidAsset},
+		{"mint without metadata", func() *Transaction { return NewAssetMint(owner, "art:1", [32]byte{}, ASSET_MINT_FEE) }, ErrInvalidAsset},
+		{"cheap mint", func() *Transaction { return NewAssetMint(owner, "art:1", metadata, ASSET_MINT_FEE-1) }, ErrInvalidAsset},
+		{"transfer to nobody", func() *Transaction { return NewAssetTransfer(owner, "nobody", "art:1", 0) }, ErrInvalidAsset},
+		{"mint with a recipient", func() *Transaction {
+			tx := NewAssetMint(owner, "art:1", metadata, ASSET_MINT_FEE)
+			tx.recipientBlockchainAddress = recipient
+			return tx
+		}, ErrInvalidAsset},
+		{"transfer with metadata", func() *Transaction {
+			tx := NewAssetTransfer(owner, recipient, "art:1", 0)
+			tx.asset.metadata = metadata
+			return tx
+		}, ErrInvalidAsset},
+		{"transfer with coins", func() *Transaction {
+			tx := NewAssetTransfer(owner, recipient, "art:1", 0)
+			tx.SetValue(1)
+			return tx
+		}, ErrInvalidAsset},
+	}
+	for _, tt := range tests {
+		if err := tt.tx().CheckAsset(); !errors.Is(err, tt.want) {
+			t.Errorf("%s: CheckAsset = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestAssetOperationsRoundTrip(t *testing.T) {
+	_, owner := newKey(t)
+	_, recipient := newKey(t)
+	metadata := sha256.Sum256([]byte("artwork.png"))
+	for _, tx := range []*Transaction{NewAssetMint(owner, "art:1", metadata, ASSET_MINT_FEE), NewAssetTransfer(owner, recipient, "art:1", 0)} {
+		m, err := json.Marshal(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx.Asset().Kind() == ASSET_TRANSFER && strings.Contains(string(m), "metadata") {
+			t.Fatalf("a transfer encodes metadata: %s", m)
+		}
+		var decoded Transaction
+		if err := json.Unmarshal(m, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if *decoded.Asset() != *tx.Asset() || decoded.Hash() != tx.Hash() {
+			t.Fatalf("decoded %+v, want %+v", decoded.Asset(), tx.Asset())
+		}
+	}
+	var op AssetOp
+	if err := json.Unmarshal([]byte(`{"type": "mint", "id": "art:1", "metadata": "zz"}`), &op); err == nil {
+		t.Fatal("decoded invalid metadata")
+	}
+}
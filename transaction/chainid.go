@@ -0,0 +1,42 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrWrongChainID = errors.New("transaction is for another chain")
+
+// ChainID returns the chain ID t is signed for: the genesis chain ID of the network it may be confirmed on.
+func (t *Transaction) ChainID() string {
+	return t.chainID
+}
+
+// SetChainID sets the chain ID of unsigned transaction t, the network's from GET /nonce.
+func (t *Transaction) SetChainID(chainID string) {
+	t.chainID = chainID
+}
+
+// hasChainID reports whether t is bound to a chain ID: every transaction from TRANSACTION_VERSION_13 on
+// except coinbases, which each chain's miners create themselves.
+func (t *Transaction) hasChainID() bool {
+	return t.version >= TRANSACTION_VERSION_13 && t.senderBlockchainAddress != MINING_SENDER
+}
+
+// CheckChainID returns ErrWrongChainID unless t is signed for chainID, the chain ID of the node's genesis,
+// "" for a node using its own genesis block. Transactions that are not bound to a chain ID always pass.
+func (t *Transaction) CheckChainID(chainID string) error {
+	if t.hasChainID() && t.chainID != chainID {
+		return fmt.Errorf("%w: signed for %q, this is %q", ErrWrongChainID, t.chainID, chainID)
+	}
+	return nil
+}
+
+// checkChainBound returns ErrWrongChainID if chainID is set but t, not a coinbase, is from a version before
+// TRANSACTION_VERSION_13 and so is not bound to it: it would be just as valid on any other network.
+func (t *Transaction) checkChainBound(chainID string) error {
+	if chainID != "" && t.senderBlockchainAddress != MINING_SENDER && !t.hasChainID() {
+		return fmt.Errorf("%w: version %d transactions carry no chain ID, this is %q", ErrWrongChainID, t.version, chainID)
+	}
+	return nil
+}
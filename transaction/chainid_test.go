@@ -0,0 +1,33 @@
+package transaction
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestChainIDIsBoundIntoTheSignature(t *testing.T) {
+	key, sender := newKey(t)
+	_, recipient := newKey(t)
+	tx := NewTransaction(sender, recipient, COIN, 0)
+	tx.SetChainID("testnet")
+	if err := tx.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.CheckChainID("testnet"); err != nil {
+		t.Fatalf("CheckChainID on its own chain = %v", err)
+	}
+	if err := tx.CheckChainID("mainnet"); !errors.Is(err, ErrWrongChainID) {
+		t.Fatalf("CheckChainID on another chain = %v, want %v", err, ErrWrongChainID)
+	}
+	// Relabelling a captured transaction for another chain invalidates its signature.
+	tx.SetChainID("mainnet")
+	if err := tx.Verify(); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Verify after changing the chain ID = %v, want %v", err, ErrInvalidSignature)
+	}
+
+	// Coinbases are not bound to a chain: each chain's miners create their own.
+	coinbase := NewTransaction(MINING_SENDER, recipient, COIN, 0)
+	if err := coinbase.CheckChainID("mainnet"); err != nil {
+		t.Fatalf("CheckChainID of a coinbase = %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+const (
+	CONTRACT_DEPLOY = "deploy"
+	CONTRACT_CALL   = "call"
+
+	// GAS_PRICE is what a unit of gas costs in smallest units: a contract transaction's fee buys
+	// fee/GAS_PRICE gas, all of which goes to the miner whether the transaction uses it or not.
+	GAS_PRICE Amount = 100
+	// CONTRACT_CALL_GAS and CONTRACT_DEPLOY_GAS are the gas a call or a deployment uses before any
+	// instruction runs; a deployment also pays CONTRACT_CODE_BYTE_GAS per byte of code it stores.
+	CONTRACT_CALL_GAS      = 100
+	CONTRACT_DEPLOY_GAS    = 500
+	CONTRACT_CODE_BYTE_GAS = 10
+
+	// MAX_CONTRACT_CODE_SIZE bounds VM code; WebAssembly modules have MAX_WASM_CODE_SIZE.
+	MAX_CONTRACT_CODE_SIZE = 4096
+	MAX_CONTRACT_INPUT     = 16
+)
+
+var ErrInvalidContract = errors.New("invalid contract transaction")
+
+// DisassembleCode formats the code of a deployment for Print, as hex unless the node package sets it to
+// its VM's disassembler.
+var DisassembleCode = hex.EncodeToString
+
+// NewContractDeployment constructs an unsigned transaction from sender that deploys code as a new
+// contract, funding it with value and paying fee for the gas.
+func NewContractDeployment(sender string, code []byte, value Amount, fee Amount) *Transaction {
+	t := NewTransaction(sender, "", value, fee)
+	t.contract = CONTRACT_DEPLOY
+	t.code = code
+	return t
+}
+
+// NewContractCall constructs an unsigned transaction from sender that calls the contract at address with
+// input, sending it value and paying fee for the gas.
+func NewContractCall(sender string, address string, input []int64, value Amount, fee Amount) *Transaction {
+	t := NewTransaction(sender, address, value, fee)
+	t.contract = CONTRACT_CALL
+	t.input = input
+	return t
+}
+
+// ContractType returns CONTRACT_DEPLOY or CONTRACT_CALL, or "" unless t is a contract transaction.
+func (t *Transaction) ContractType() string {
+	return t.contract
+}
+
+// Code returns the code a deployment stores.
+func (t *Transaction) Code() []byte {
+	return t.code
+}
+
+// Input returns the words a call passes to the contract.
+func (t *Transaction) Input() []int64 {
+	return t.input
+}
+
+// ContractAddress returns the address of the contract deployment t creates, derived from its hash.
+func (t *Transaction) ContractAddress() string {
+	h := t.Hash()
+	return hashAddress(SCRIPT_ADDRESS_VERSION, append([]byte("contract"), h[:]...))
+}
+
+// IntrinsicGas returns the gas contract transaction t uses before its code runs.
+func (t *Transaction) IntrinsicGas() uint64 {
+	if t.contract == CONTRACT_DEPLOY {
+		return CONTRACT_DEPLOY_GAS + CONTRACT_CODE_BYTE_GAS*uint64(len(t.code))
+	}
+	return CONTRACT_CALL_GAS
+}
+
+// GasLimit returns the gas contract transaction t's fee buys.
+func (t *Transaction) GasLimit() uint64 {
+	return uint64(max(t.fee, 0) / GAS_PRICE)
+}
+
+// checkContract returns why contract transaction t is invalid, or nil: a deployment has no recipient and
+// at most MAX_CONTRACT_CODE_SIZE bytes of code, a call names a contract address and passes at most
+// MAX_CONTRACT_INPUT words, neither carries data or a negative value, and the fee buys the intrinsic gas.
+func (t *Transaction) checkContract() error {
+	switch {
+	case t.contract != CONTRACT_DEPLOY && t.contract != CONTRACT_CALL:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidContract, t.contract)
+	case t.value < 0 || t.IsDataCarrier():
+		return fmt.Errorf("%w: negative value or data", ErrInvalidContract)
+	case t.contract == CONTRACT_DEPLOY && (t.recipientBlockchainAddress != "" || len(t.input) > 0):
+		return fmt.Errorf("%w: a deployment has no recipient or input", ErrInvalidContract)
+	case t.contract == CONTRACT_DEPLOY && (len(t.code) == 0 || len(t.code) > maxCodeSize(t.code)):
+		return fmt.Errorf("%w: %d bytes of code (1 to %d)", ErrInvalidContract, len(t.code), maxCodeSize(t.code))
+	case t.contract == CONTRACT_CALL && len(t.code) > 0:
+		return fmt.Errorf("%w: a call has no code", ErrInvalidContract)
+	case t.contract == CONTRACT_CALL && len(t.input) > MAX_CONTRACT_INPUT:
+		return fmt.Errorf("%w: %d input words (at most %d)", ErrInvalidContract, len(t.input), MAX_CONTRACT_INPUT)
+	case t.GasLimit() < t.IntrinsicGas():
+		return fmt.Errorf("%w: fee %s buys %d gas, below the intrinsic %d", ErrInvalidContract, t.fee, t.GasLimit(), t.IntrinsicGas())
+	}
+	if t.contract == CONTRACT_CALL {
+		if _, version, ok := addressHash(t.recipientBlockchainAddress); !ok || version != SCRIPT_ADDRESS_VERSION {
+			return fmt.Errorf("%w: %q is not a contract address", ErrInvalidContract, t.recipientBlockchainAddress)
+		}
+	}
+	return nil
+}
+
+// maxCodeSize returns how large code may be, depending on whether it is VM code or a WebAssembly module.
+func maxCodeSize(code []byte) int {
+	if IsWasm(code) {
+		return MAX_WASM_CODE_SIZE
+	}
+	return MAX_CONTRACT_CODE_SIZE
+}
+
+// MAX_WASM_CODE_SIZE bounds a WebAssembly contract, as EIP-170 bounds EVM code; modules compiled from
+// other languages are larger than VM assembly.
+const MAX_WASM_CODE_SIZE = 24_576
+
+// WasmMagic starts every WebAssembly module: "\0asm" and version 1.
+var WasmMagic = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+// IsWasm reports whether code is a WebAssembly module rather than VM code.
+func IsWasm(code []byte) bool {
+	return bytes.HasPrefix(code, WasmMagic)
+}
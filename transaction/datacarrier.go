@@ -0,0 +1,54 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// MAX_DATA_CARRIER_SIZE bounds the bytes a data-carrier transaction anchors, enough for a few document
+	// hashes, like Bitcoin's OP_RETURN outputs.
+	MAX_DATA_CARRIER_SIZE = 256
+	// DATA_CARRIER_FEE_PER_BYTE is the least fee per anchored byte, in smallest units, so block space for
+	// data is paid for by its size.
+	DATA_CARRIER_FEE_PER_BYTE Amount = 10_000
+)
+
+var ErrInvalidDataCarrier = errors.New("invalid data-carrier transaction")
+
+// NewDataCarrier constructs an unsigned transaction from sender that moves no value and anchors data in
+// the block that confirms it, paying fee to the miner.
+func NewDataCarrier(sender string, data []byte, fee Amount) *Transaction {
+	t := NewTransaction(sender, "", 0, fee)
+	t.data = data
+	return t
+}
+
+// DataCarrierFee returns the least fee a data-carrier transaction anchoring size bytes pays.
+func DataCarrierFee(size int) Amount {
+	return Amount(size) * DATA_CARRIER_FEE_PER_BYTE
+}
+
+// IsDataCarrier reports whether t anchors data instead of transferring value.
+func (t *Transaction) IsDataCarrier() bool {
+	return len(t.data) > 0
+}
+
+// Data returns the bytes t anchors, nil unless it is a data carrier.
+func (t *Transaction) Data() []byte {
+	return t.data
+}
+
+// CheckDataCarrier returns why data-carrier transaction t is invalid, or nil: it has no recipient and no
+// value, anchors at most MAX_DATA_CARRIER_SIZE bytes, and pays at least DataCarrierFee for them.
+func (t *Transaction) CheckDataCarrier() error {
+	switch {
+	case t.recipientBlockchainAddress != "" || t.value != 0:
+		return fmt.Errorf("%w: it moves no value and has no recipient", ErrInvalidDataCarrier)
+	case len(t.data) > MAX_DATA_CARRIER_SIZE:
+		return fmt.Errorf("%w: %d bytes of data (at most %d)", ErrInvalidDataCarrier, len(t.data), MAX_DATA_CARRIER_SIZE)
+	case t.fee < DataCarrierFee(len(t.data)):
+		return fmt.Errorf("%w: fee %s below %s for %d bytes", ErrInvalidDataCarrier, t.fee, DataCarrierFee(len(t.data)), len(t.data))
+	}
+	return nil
+}
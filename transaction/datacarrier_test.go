@@ -0,0 +1,57 @@
+package transaction
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDataCarriersPayForTheirBytesAndMoveNoValue(t *testing.T) {
+	_, sender := newKey(t)
+	_, recipient := newKey(t)
+	data := []byte("sha256:0123456789abcdef")
+	fee := DataCarrierFee(len(data))
+	if fee != Amount(len(data))*DATA_CARRIER_FEE_PER_BYTE {
+		t.Fatalf("DataCarrierFee(%d) = %s", len(data), fee)
+	}
+
+	tests := []struct {
+		name string
+		tx   func() *Transaction
+		want error
+	}{
+		{"valid", func() *Transaction { return NewDataCarrier(sender, data, fee) }, nil},
+		{"underpaid", func() *Transaction { return NewDataCarrier(sender, data, fee-1) }, ErrInvalidDataCarrier},
+		{"too large", func() *Transaction {
+			big := make([]byte, MAX_DATA_CARRIER_SIZE+1)
+			return NewDataCarrier(sender, big, DataCarrierFee(len(big)))
+		}, ErrInvalidDataCarrier},
+		{"with a recipient", func() *Transaction {
+			tx := NewDataCarrier(sender, data, fee)
+			tx.recipientBlockchainAddress = recipient
+			return tx
+		}, ErrInvalidDataCarrier},
+		{"with a value", func() *Transaction {
+			tx := NewDataCarrier(sender, data, fee)
+			tx.SetValue(1)
+			return tx
+		}, ErrInvalidDataCarrier},
+	}
+	for _, tt := range tests {
+		tx := tt.tx()
+		if !tx.IsDataCarrier() {
+			t.Fatalf("%s: IsDataCarrier = false", tt.name)
+		}
+		if err := tx.CheckAmounts(); !errors.Is(err, tt.want) {
+			t.Errorf("%s: CheckAmounts = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	old := NewDataCarrier(sender, data, fee)
+	old.SetVersion(TRANSACTION_VERSION_3)
+	if err := old.CheckVersion(); !errors.Is(err, ErrInvalidDataCarrier) {
+		t.Fatalf("CheckVersion of data at version 3 = %v, want %v", err, ErrInvalidDataCarrier)
+	}
+	if NewTransaction(sender, recipient, COIN, 0).IsDataCarrier() {
+		t.Fatal("a transfer is a data carrier")
+	}
+}
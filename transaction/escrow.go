@@ -0,0 +1,55 @@
+package transaction
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+)
+
+// ESCROW_REQUIRED is how many of the buyer, the seller, and the arbiter must sign to release an escrow.
+const ESCROW_REQUIRED = 2
+
+// Escrow is a payment held between a buyer and a seller: the buyer funds the 2-of-3 multisig address of
+// the buyer's, the seller's, and the arbiter's keys. Buyer and seller release it together when the deal
+// goes through; in a dispute the arbiter signs with whichever party it sides with.
+type Escrow struct {
+	Buyer   *ecdsa.PublicKey
+	Seller  *ecdsa.PublicKey
+	Arbiter *ecdsa.PublicKey
+}
+
+// Multisig returns the escrow's condition, its keys in the order buyer, seller, arbiter.
+func (e *Escrow) Multisig() (*Multisig, error) {
+	return NewMultisig(ESCROW_REQUIRED, []*ecdsa.PublicKey{e.Buyer, e.Seller, e.Arbiter})
+}
+
+// Address returns the multisig address the buyer funds.
+func (e *Escrow) Address() (string, error) {
+	m, err := e.Multisig()
+	if err != nil {
+		return "", err
+	}
+	return m.Address(), nil
+}
+
+// Role returns which party of the escrow publicKey is: "buyer", "seller", or "arbiter".
+func (e *Escrow) Role(publicKey *ecdsa.PublicKey) (string, error) {
+	switch {
+	case e.Buyer.Equal(publicKey):
+		return "buyer", nil
+	case e.Seller.Equal(publicKey):
+		return "seller", nil
+	case e.Arbiter.Equal(publicKey):
+		return "arbiter", nil
+	}
+	return "", fmt.Errorf("%w: key is not a party to the escrow", ErrInvalidMultisig)
+}
+
+// NewEscrowRelease constructs a transaction that releases value from the escrow to recipient, the seller's
+// address when the deal goes through or the buyer's for a refund. Two parties sign it with SignMultisig.
+func NewEscrowRelease(e *Escrow, recipient string, value Amount, fee Amount) (*Transaction, error) {
+	m, err := e.Multisig()
+	if err != nil {
+		return nil, err
+	}
+	return NewMultisigTransaction(m, recipient, value, fee), nil
+}
@@ -0,0 +1,50 @@
+package transaction
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEscrowReleasesWithAnyTwoOfItsThreeParties(t *testing.T) {
+	keys, publicKeys := newCosigners(t, 3)
+	e := &Escrow{Buyer: publicKeys[0], Seller: publicKeys[1], Arbiter: publicKeys[2]}
+	m, err := e.Multisig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address, err := e.Address(); err != nil || address != m.Address() || m.Required() != ESCROW_REQUIRED {
+		t.Fatalf("Address = %s, %v, want the 2-of-3 address %s", address, err, m.Address())
+	}
+	for i, want := range []string{"buyer", "seller", "arbiter"} {
+		if role, err := e.Role(publicKeys[i]); err != nil || role != want {
+			t.Errorf("Role of key %d = %q, %v, want %q", i, role, err, want)
+		}
+	}
+	outsider, _ := newKey(t)
+	if _, err := e.Role(&outsider.PublicKey); !errors.Is(err, ErrInvalidMultisig) {
+		t.Fatalf("Role of an outsider = %v, want %v", err, ErrInvalidMultisig)
+	}
+
+	seller := NewAddress(e.Seller)
+	for _, pair := range [][2]int{{0, 1}, {2, 1}, {2, 0}} {
+		release, err := NewEscrowRelease(e, seller, COIN, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if release.SenderBlockchainAddress() != m.Address() {
+			t.Fatalf("release sender = %s, want the escrow address", release.SenderBlockchainAddress())
+		}
+		if err := release.SignMultisig(keys[pair[0]]); err != nil {
+			t.Fatal(err)
+		}
+		if err := release.Verify(); err == nil {
+			t.Fatalf("a release signed by key %d alone verifies", pair[0])
+		}
+		if err := release.SignMultisig(keys[pair[1]]); err != nil {
+			t.Fatal(err)
+		}
+		if err := release.Verify(); err != nil {
+			t.Errorf("Verify of a release signed by keys %v = %v", pair, err)
+		}
+	}
+}
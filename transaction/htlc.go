@@ -0,0 +1,164 @@
+package transaction
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// MAX_HTLC_PREIMAGE bounds the secret that claims an HTLC; swaps use 32 random bytes.
+const MAX_HTLC_PREIMAGE = 64
+
+var ErrInvalidHTLC = errors.New("invalid HTLC")
+
+// HTLC is a hash time-locked condition: coins sent to its address move to whoever signs for recipient and
+// reveals the preimage of hashlock, or, once timeout has passed, back to whoever signs for refund. Timeout
+// is a height or a Unix time, like a lock time. A transaction spending from the address carries the
+// condition, with the preimage on the claim path.
+type HTLC struct {
+	hashlock  [32]byte
+	recipient string
+	refund    string
+	timeout   uint64
+	preimage  []byte
+}
+
+// NewHTLC returns the condition that pays recipient for the preimage of hashlock, or refund from timeout on.
+func NewHTLC(hashlock [32]byte, recipient string, refund string, timeout uint64) (*HTLC, error) {
+	h := &HTLC{hashlock: hashlock, recipient: recipient, refund: refund, timeout: timeout}
+	if err := h.check(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// check returns why h is malformed: it needs valid recipient and refund addresses, a timeout, and a
+// preimage of at most MAX_HTLC_PREIMAGE bytes.
+func (h *HTLC) check() error {
+	switch {
+	case !ValidateAddress(h.recipient) || !ValidateAddress(h.refund):
+		return fmt.Errorf("%w: invalid recipient or refund address", ErrInvalidHTLC)
+	case h.timeout == 0:
+		return fmt.Errorf("%w: no timeout", ErrInvalidHTLC)
+	case len(h.preimage) > MAX_HTLC_PREIMAGE:
+		return fmt.Errorf("%w: preimage of %d bytes, more than %d", ErrInvalidHTLC, len(h.preimage), MAX_HTLC_PREIMAGE)
+	}
+	return nil
+}
+
+// Address derives the condition's blockchain address from the hashlock, both addresses, and the timeout,
+// so changing any of them gives another address.
+func (h *HTLC) Address() string {
+	redeem := append([]byte("htlc"), h.hashlock[:]...)
+	redeem = append(redeem, h.recipient...)
+	redeem = append(redeem, 0)
+	redeem = append(redeem, h.refund...)
+	redeem = binary.BigEndian.AppendUint64(append(redeem, 0), h.timeout)
+	return hashAddress(SCRIPT_ADDRESS_VERSION, redeem)
+}
+
+// Claim returns a copy of the condition that reveals preimage, for the recipient's spend.
+func (h *HTLC) Claim(preimage []byte) *HTLC {
+	c := *h
+	c.preimage = preimage
+	return &c
+}
+
+// Preimage returns the secret a claim reveals, nil for a refund.
+func (h *HTLC) Preimage() []byte {
+	return h.preimage
+}
+
+// verify checks that h is the condition of t's sender, and that t either reveals the preimage and is signed
+// by the recipient's key, or is signed by the refund key with a lock time no earlier than the timeout, so it
+// cannot be confirmed before it.
+func (h *HTLC) verify(t *Transaction) error {
+	if err := h.check(); err != nil {
+		return err
+	}
+	if h.Address() != t.senderBlockchainAddress {
+		return ErrSenderKeyMismatch
+	}
+	if t.senderPublicKey == nil || len(t.signature) == 0 {
+		return ErrUnsignedTransaction
+	}
+	signer := NewAddress(t.senderPublicKey)
+	if h.preimage != nil {
+		if sha256.Sum256(h.preimage) != h.hashlock {
+			return fmt.Errorf("%w: preimage does not match the hashlock", ErrInvalidHTLC)
+		}
+		if signer != h.recipient {
+			return ErrSenderKeyMismatch
+		}
+	} else {
+		if signer != h.refund {
+			return ErrSenderKeyMismatch
+		}
+		if t.lockTime < h.timeout || (t.lockTime < LOCK_TIME_THRESHOLD) != (h.timeout < LOCK_TIME_THRESHOLD) {
+			return fmt.Errorf("%w: a refund needs a lock time of at least the timeout %d", ErrInvalidHTLC, h.timeout)
+		}
+	}
+	hash := t.SigningHash()
+	if !ecdsa.VerifyASN1(t.senderPublicKey, hash[:], t.signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// NewHTLCTransaction constructs an unsigned transaction from the address of h: a claim if h carries the
+// preimage, and otherwise a refund, locked until the timeout. The recipient or refund key signs it with Sign.
+func NewHTLCTransaction(h *HTLC, recipient string, value Amount, fee Amount) *Transaction {
+	t := NewTransaction(h.Address(), recipient, value, fee)
+	t.htlc = h
+	if h.preimage == nil {
+		t.lockTime = h.timeout
+	}
+	return t
+}
+
+// HTLC returns the condition a transaction from an HTLC address carries, nil for other senders.
+func (t *Transaction) HTLC() *HTLC {
+	return t.htlc
+}
+
+// MarshalJSON encodes the condition as {"hashlock", "recipient", "refund", "timeout", "preimage"}, the
+// hashlock and preimage hex-encoded and the preimage left out of refunds.
+func (h *HTLC) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Hashlock  string `json:"hashlock"`
+		Recipient string `json:"recipient"`
+		Refund    string `json:"refund"`
+		Timeout   uint64 `json:"timeout"`
+		Preimage  string `json:"preimage,omitempty"`
+	}{hex.EncodeToString(h.hashlock[:]), h.recipient, h.refund, h.timeout, hex.EncodeToString(h.preimage)})
+}
+
+// UnmarshalJSON decodes a condition written by MarshalJSON.
+func (h *HTLC) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Hashlock  string `json:"hashlock"`
+		Recipient string `json:"recipient"`
+		Refund    string `json:"refund"`
+		Timeout   uint64 `json:"timeout"`
+		Preimage  string `json:"preimage"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	hashlock, err := HashFromString(v.Hashlock)
+	if err != nil {
+		return fmt.Errorf("invalid hashlock: %w", err)
+	}
+	var preimage []byte
+	if v.Preimage != "" {
+		if preimage, err = hex.DecodeString(v.Preimage); err != nil {
+			return fmt.Errorf("invalid preimage: %w", err)
+		}
+	}
+	h.hashlock, h.recipient, h.refund, h.timeout, h.preimage = hashlock, v.Recipient, v.Refund, v.Timeout, preimage
+	return nil
+}
@@ -0,0 +1,116 @@
+package transaction
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestHTLCAddressCommitsToEveryTerm(t *testing.T) {
+	_, recipient := newKey(t)
+	_, refund := newKey(t)
+	hashlock := sha256.Sum256([]byte("secret"))
+	h, err := NewHTLC(hashlock, recipient, refund, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ValidateAddress(h.Address()) {
+		t.Fatalf("invalid HTLC address %s", h.Address())
+	}
+	for _, other := range []*HTLC{
+		{hashlock: sha256.Sum256([]byte("other")), recipient: recipient, refund: refund, timeout: 100},
+		{hashlock: hashlock, recipient: refund, refund: recipient, timeout: 100},
+		{hashlock: hashlock, recipient: recipient, refund: refund, timeout: 101},
+	} {
+		if other.Address() == h.Address() {
+			t.Fatalf("%+v has the address of %+v", other, h)
+		}
+	}
+	if h.Claim([]byte("secret")).Address() != h.Address() {
+		t.Fatal("revealing the preimage changed the address")
+	}
+
+	for _, invalid := range []struct {
+		recipient, refund string
+		timeout           uint64
+	}{{"nobody", refund, 100}, {recipient, "nobody", 100}, {recipient, refund, 0}} {
+		if _, err := NewHTLC(hashlock, invalid.recipient, invalid.refund, invalid.timeout); !errors.Is(err, ErrInvalidHTLC) {
+			t.Errorf("NewHTLC(%+v) = %v, want %v", invalid, err, ErrInvalidHTLC)
+		}
+	}
+}
+
+func TestHTLCClaimsNeedThePreimageAndRefundsTheTimeout(t *testing.T) {
+	recipientKey, recipient := newKey(t)
+	refundKey, refund := newKey(t)
+	h, err := NewHTLC(sha256.Sum256([]byte("secret")), recipient, refund, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claim := NewHTLCTransaction(h.Claim([]byte("secret")), recipient, COIN, 0)
+	if claim.LockTime() != 0 {
+		t.Fatalf("a claim is locked until %d", claim.LockTime())
+	}
+	if err := claim.Verify(); !errors.Is(err, ErrUnsignedTransaction) {
+		t.Fatalf("Verify of an unsigned claim = %v, want %v", err, ErrUnsignedTransaction)
+	}
+	if err := claim.Sign(refundKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := claim.Verify(); !errors.Is(err, ErrSenderKeyMismatch) {
+		t.Fatalf("Verify of a claim signed by the refund key = %v, want %v", err, ErrSenderKeyMismatch)
+	}
+	if err := claim.Sign(recipientKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := claim.Verify(); err != nil {
+		t.Fatalf("Verify of a claim = %v", err)
+	}
+	m, err := json.Marshal(claim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Transaction
+	if err := json.Unmarshal(m, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded.HTLC().Preimage()) != "secret" || decoded.Verify() != nil {
+		t.Fatalf("decoded claim reveals %q, Verify = %v", decoded.HTLC().Preimage(), decoded.Verify())
+	}
+	wrong := NewHTLCTransaction(h.Claim([]byte("guess")), recipient, COIN, 0)
+	if err := wrong.Sign(recipientKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := wrong.Verify(); !errors.Is(err, ErrInvalidHTLC) {
+		t.Fatalf("Verify of a claim with the wrong preimage = %v, want %v", err, ErrInvalidHTLC)
+	}
+
+	refundTx := NewHTLCTransaction(h, refund, COIN, 0)
+	if refundTx.LockTime() != 100 {
+		t.Fatalf("refund lock time = %d, want the timeout 100", refundTx.LockTime())
+	}
+	if err := refundTx.Sign(recipientKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := refundTx.Verify(); !errors.Is(err, ErrSenderKeyMismatch) {
+		t.Fatalf("Verify of a refund signed by the recipient = %v, want %v", err, ErrSenderKeyMismatch)
+	}
+	if err := refundTx.Sign(refundKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := refundTx.Verify(); err != nil {
+		t.Fatalf("Verify of a refund = %v", err)
+	}
+	// A refund locked before the timeout, or by time instead of height, cannot be signed valid.
+	for _, lockTime := range []uint64{99, LOCK_TIME_THRESHOLD + 100} {
+		refundTx.SetLockTime(lockTime)
+		if err := refundTx.Sign(refundKey); err != nil {
+			t.Fatal(err)
+		}
+		if err := refundTx.Verify(); !errors.Is(err, ErrInvalidHTLC) {
+			t.Errorf("Verify of a refund locked until %d = %v, want %v", lockTime, err, ErrInvalidHTLC)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package transaction
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
+	"fmt"
+)
+
+// PublicKeyFromString parses a hex-encoded uncompressed P-256 public key.
+func PublicKeyFromString(s string) (*ecdsa.PublicKey, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), b)
+}
+
+// PrivateKeyFromString parses a hex-encoded P-256 private key scalar.
+func PrivateKeyFromString(s string) (*ecdsa.PrivateKey, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return ecdsa.ParseRawPrivateKey(elliptic.P256(), b)
+}
+
+// SignatureFromString parses a hex-encoded ASN.1 ECDSA signature.
+func SignatureFromString(s string) ([]byte, error) {
+	return hex.DecodeString(s)
+}
+
+// HashFromString parses a hex-encoded 32-byte hash.
+func HashFromString(s string) ([32]byte, error) {
+	var h [32]byte
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return h, err
+	}
+	if len(b) != len(h) {
+		return h, fmt.Errorf("hash must be %d bytes, got %d", len(h), len(b))
+	}
+	copy(h[:], b)
+	return h, nil
+}
@@ -0,0 +1,48 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LOCK_TIME_THRESHOLD splits lock times as Bitcoin does: below it a lock time is a block height, from it
+// on a Unix time in seconds.
+const LOCK_TIME_THRESHOLD = 500_000_000
+
+var ErrTransactionLocked = errors.New("transaction is time-locked")
+
+// LockTime returns the height or Unix time before which t cannot be confirmed, zero if it is not locked.
+func (t *Transaction) LockTime() uint64 {
+	return t.lockTime
+}
+
+// SetLockTime sets the height or Unix time before which t cannot be confirmed; zero leaves it unlocked.
+func (t *Transaction) SetLockTime(lockTime uint64) {
+	t.lockTime = lockTime
+}
+
+// IsFinal reports whether t may be confirmed in the block at height, given the median time past of the
+// blocks before it. A time lock is compared with the median time past rather than the block's own
+// timestamp, which its miner chooses, as in BIP 113.
+func (t *Transaction) IsFinal(height int, mtp int64) bool {
+	switch {
+	case t.lockTime == 0:
+		return true
+	case t.lockTime < LOCK_TIME_THRESHOLD:
+		return uint64(height) >= t.lockTime
+	default:
+		return uint64(mtp/int64(time.Second)) >= t.lockTime
+	}
+}
+
+// CheckFinal returns ErrTransactionLocked unless t may be confirmed in the block at height after mtp.
+func (t *Transaction) CheckFinal(height int, mtp int64) error {
+	if t.IsFinal(height, mtp) {
+		return nil
+	}
+	if t.lockTime < LOCK_TIME_THRESHOLD {
+		return fmt.Errorf("%w until height %d", ErrTransactionLocked, t.lockTime)
+	}
+	return fmt.Errorf("%w until %s", ErrTransactionLocked, time.Unix(int64(t.lockTime), 0).UTC().Format(time.RFC3339))
+}
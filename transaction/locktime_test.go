@@ -0,0 +1,33 @@
+package transaction
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLockTimesAreHeightsOrUnixTimes(t *testing.T) {
+	noon := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		lockTime uint64
+		height   int
+		mtp      time.Time
+		final    bool
+	}{
+		{0, 0, time.Time{}, true},
+		{10, 9, noon, false},
+		{10, 10, time.Time{}, true},
+		{uint64(noon.Unix()), 1 << 30, noon.Add(-time.Second), false},
+		{uint64(noon.Unix()), 0, noon, true},
+	}
+	for _, tt := range tests {
+		tx := NewTransaction("sender", "recipient", COIN, 0)
+		tx.SetLockTime(tt.lockTime)
+		if final := tx.IsFinal(tt.height, tt.mtp.UnixNano()); final != tt.final {
+			t.Errorf("lock time %d at height %d, time %s: IsFinal = %v, want %v", tt.lockTime, tt.height, tt.mtp, final, tt.final)
+		}
+		if err := tx.CheckFinal(tt.height, tt.mtp.UnixNano()); (err == nil) != tt.final || err != nil && !errors.Is(err, ErrTransactionLocked) {
+			t.Errorf("lock time %d at height %d: CheckFinal = %v", tt.lockTime, tt.height, err)
+		}
+	}
+}
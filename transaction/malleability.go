@@ -0,0 +1,134 @@
+package transaction
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+)
+
+// A transaction's ID hashes its signatures and scripts, which its signature cannot cover, so anyone relaying it
+// could change its ID without the sender's key wherever there is more than one valid way to write them:
+//   - an ECDSA signature (R, S) verifies just as well as (R, N-S), so S must be the lower of the two, as
+//     Bitcoin's BIP 62 requires;
+//   - a multisig spend with surplus signatures stays valid with some blanked, so it must carry exactly the
+//     required number;
+//   - an unlocking script stays valid with extra pushes under its items or pushes written longer, so it must
+//     use the shortest push for each item and leave only the locking script's result on the stack.
+// A locking script that ignores one of its inputs, such as "OP_DROP OP_1", still lets a relay change that
+// input; its author chose to accept any value there.
+
+// halfOrder is half the order N of P256, the largest S a signature may have.
+var halfOrder = new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+
+// ecdsaSignature is the DER encoding of an ECDSA signature.
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// SignLowS signs hash with privateKey and returns the DER-encoded signature with the lower S.
+func SignLowS(privateKey *ecdsa.PrivateKey, hash []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, hash)
+	if err != nil {
+		return nil, err
+	}
+	if s.Cmp(halfOrder) > 0 {
+		s.Sub(privateKey.Params().N, s)
+	}
+	return asn1.Marshal(ecdsaSignature{R: r, S: s})
+}
+
+// isHighS reports whether signature is a DER-encoded ECDSA signature with S above half the order.
+func isHighS(signature []byte) bool {
+	var sig ecdsaSignature
+	if rest, err := asn1.Unmarshal(signature, &sig); err != nil || len(rest) > 0 || sig.S == nil {
+		return false
+	}
+	return sig.S.Cmp(halfOrder) > 0
+}
+
+// checkCanonical returns why a relay could rewrite t's witness, its signatures and unlocking script, into
+// another valid one: a signature with the higher S, surplus multisig signatures, or a non-canonical unlocking
+// script.
+func (t *Transaction) checkCanonical() error {
+	if err := t.checkLowS(); err != nil {
+		return err
+	}
+	if t.multisig != nil {
+		if signed := t.multisig.Signed(); signed != t.multisig.required {
+			return fmt.Errorf("%w: %d signatures where exactly %d are required", ErrInvalidMultisig, signed, t.multisig.required)
+		}
+	}
+	if len(t.lockingScript) > 0 {
+		return checkCanonicalScript(t.unlockingScript, t.lockingScript, t.SigningHash())
+	}
+	return nil
+}
+
+// checkCanonicalScript returns ErrScriptFailed unless unlocking pushes each item in the shortest form and
+// leaves, once locking has run, nothing on the stack but its result.
+func checkCanonicalScript(unlocking, locking []byte, hash [32]byte) error {
+	for pc := 0; pc < len(unlocking); {
+		op, data, next, err := nextOp(unlocking, pc)
+		if err != nil {
+			return err
+		}
+		if isPush(op) && !isMinimalPush(op, data) {
+			return fmt.Errorf("%w: push of %d bytes not in its shortest form", ErrScriptFailed, len(data))
+		}
+		pc = next
+	}
+	stack, err := evalScript(unlocking, nil, hash)
+	if err != nil {
+		return err
+	}
+	if stack, err = evalScript(locking, stack, hash); err != nil {
+		return err
+	}
+	if len(stack) != 1 {
+		return fmt.Errorf("%w: %d items left on the stack (exactly 1)", ErrScriptFailed, len(stack))
+	}
+	return nil
+}
+
+// isMinimalPush reports whether op is the push PushScript would write for data.
+func isMinimalPush(op byte, data []byte) bool {
+	switch n := len(data); {
+	case op == OP_1:
+		return true
+	case n == 0:
+		return op == OP_0
+	case n == 1 && data[0] == 1:
+		return false
+	case n < OP_PUSHDATA1:
+		return op == byte(n)
+	case n <= 0xff:
+		return op == OP_PUSHDATA1
+	}
+	return op == OP_PUSHDATA2
+}
+
+// checkLowS returns ErrInvalidSignature if a signature of t has the higher S: its own, a cosigner's, or one
+// its unlocking script pushes.
+func (t *Transaction) checkLowS() error {
+	signatures := [][]byte{t.signature}
+	if t.multisig != nil {
+		signatures = append(signatures, t.multisig.signatures...)
+	}
+	for pc := 0; pc < len(t.unlockingScript); {
+		_, data, next, err := nextOp(t.unlockingScript, pc)
+		if err != nil {
+			break
+		}
+		signatures = append(signatures, data)
+		pc = next
+	}
+	for _, s := range signatures {
+		if isHighS(s) {
+			return fmt.Errorf("%w: S above half the curve order", ErrInvalidSignature)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// MAX_MEMO_LENGTH bounds a transaction's memo in bytes: enough for an invoice reference or a short
+// message, too little to store files on the chain.
+const MAX_MEMO_LENGTH = 80
+
+var ErrInvalidMemo = errors.New("invalid memo")
+
+// Memo returns the message the sender attached to the transaction, empty if none.
+func (t *Transaction) Memo() string {
+	return t.memo
+}
+
+// SetMemo attaches message to the transaction; it must be signed after the memo changes.
+func (t *Transaction) SetMemo(message string) {
+	t.memo = message
+}
+
+// checkMemo returns why t's memo is not valid UTF-8 of at most MAX_MEMO_LENGTH bytes, or nil.
+func (t *Transaction) checkMemo() error {
+	if len(t.memo) > MAX_MEMO_LENGTH {
+		return fmt.Errorf("%w: %d bytes (at most %d)", ErrInvalidMemo, len(t.memo), MAX_MEMO_LENGTH)
+	}
+	if !utf8.ValidString(t.memo) {
+		return fmt.Errorf("%w: not UTF-8", ErrInvalidMemo)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package transaction
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMemoIsSignedAndRoundTrips(t *testing.T) {
+	key, sender := newKey(t)
+	_, recipient := newKey(t)
+	tx := NewTransaction(sender, recipient, COIN, 0)
+	tx.SetMemo("invoice #42 ✓")
+	if err := tx.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	m, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Transaction
+	if err := json.Unmarshal(m, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Memo() != tx.Memo() || decoded.Hash() != tx.Hash() {
+		t.Fatalf("decoded memo %q, want %q with the same hash", decoded.Memo(), tx.Memo())
+	}
+	if err := decoded.Verify(); err != nil {
+		t.Fatalf("Verify = %v", err)
+	}
+	decoded.SetMemo("invoice #43 ✓")
+	if err := decoded.Verify(); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Verify after changing the memo = %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestMemoMustBeShortUTF8(t *testing.T) {
+	_, sender := newKey(t)
+	_, recipient := newKey(t)
+	for _, tt := range []struct {
+		memo  string
+		valid bool
+	}{
+		{"", true},
+		{strings.Repeat("a", MAX_MEMO_LENGTH), true},
+		{strings.Repeat("a", MAX_MEMO_LENGTH+1), false},
+		// Multi-byte characters count by their bytes.
+		{strings.Repeat("é", MAX_MEMO_LENGTH/2+1), false},
+		{"\xff", false},
+	} {
+		tx := NewTransaction(sender, recipient, COIN, 0)
+		tx.SetMemo(tt.memo)
+		err := tx.CheckVersion()
+		if tt.valid && err != nil {
+			t.Errorf("CheckVersion of a %d-byte memo = %v", len(tt.memo), err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidMemo) {
+			t.Errorf("CheckVersion of memo %.10q = %v, want %v", tt.memo, err, ErrInvalidMemo)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package transaction
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MAX_MULTISIG_KEYS bounds the keys of a multisig condition, as Bitcoin's P2SH multisig does.
+const MAX_MULTISIG_KEYS = 15
+
+var ErrInvalidMultisig = errors.New("invalid multisig")
+
+// Multisig is an m-of-n spending condition: coins sent to its address move only with signatures of
+// required of its public keys. A transaction spending from the address carries the condition and the
+// signatures collected so far, aligned with the keys.
+type Multisig struct {
+	required   int
+	publicKeys []*ecdsa.PublicKey
+	signatures [][]byte
+}
+
+// NewMultisig returns the condition that required of publicKeys sign, with no signatures yet.
+func NewMultisig(required int, publicKeys []*ecdsa.PublicKey) (*Multisig, error) {
+	m := &Multisig{required: required, publicKeys: publicKeys, signatures: make([][]byte, len(publicKeys))}
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// check returns why m is malformed: it needs 1 to MAX_MULTISIG_KEYS distinct keys, a threshold between one
+// and their number, and a signature slot per key.
+func (m *Multisig) check() error {
+	n := len(m.publicKeys)
+	switch {
+	case n == 0 || n > MAX_MULTISIG_KEYS:
+		return fmt.Errorf("%w: %d keys (1 to %d)", ErrInvalidMultisig, n, MAX_MULTISIG_KEYS)
+	case m.required < 1 || m.required > n:
+		return fmt.Errorf("%w: %d of %d keys required", ErrInvalidMultisig, m.required, n)
+	case len(m.signatures) != n:
+		return fmt.Errorf("%w: %d signatures for %d keys", ErrInvalidMultisig, len(m.signatures), n)
+	}
+	seen := make(map[string]bool, n)
+	for _, k := range m.publicKeys {
+		b, _ := k.Bytes()
+		if seen[string(b)] {
+			return fmt.Errorf("%w: duplicate key", ErrInvalidMultisig)
+		}
+		seen[string(b)] = true
+	}
+	return nil
+}
+
+// Address derives the condition's blockchain address from the threshold and the keys in order, so the same
+// keys with another threshold or order give another address.
+func (m *Multisig) Address() string {
+	redeem := []byte{byte(m.required)}
+	for _, k := range m.publicKeys {
+		b, _ := k.Bytes()
+		redeem = append(redeem, b...)
+	}
+	redeem = append(redeem, byte(len(m.publicKeys)))
+	return hashAddress(SCRIPT_ADDRESS_VERSION, redeem)
+}
+
+// Signed returns how many of the keys have signed.
+func (m *Multisig) Signed() int {
+	n := 0
+	for _, s := range m.signatures {
+		if len(s) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Required returns how many signatures spending from the address needs.
+func (m *Multisig) Required() int {
+	return m.required
+}
+
+// PublicKeys returns the keys of the condition, in the order its address commits to.
+func (m *Multisig) PublicKeys() []*ecdsa.PublicKey {
+	return m.publicKeys
+}
+
+// Verify checks that m is the condition of address sender and that at least required of its keys signed
+// hash. Every signature given must be valid, not only enough of them.
+func (m *Multisig) Verify(sender string, hash [32]byte) error {
+	if err := m.check(); err != nil {
+		return err
+	}
+	if m.Address() != sender {
+		return ErrSenderKeyMismatch
+	}
+	for i, s := range m.signatures {
+		if len(s) > 0 && !ecdsa.VerifyASN1(m.publicKeys[i], hash[:], s) {
+			return fmt.Errorf("%w: key %d", ErrInvalidSignature, i)
+		}
+	}
+	if signed := m.Signed(); signed < m.required {
+		return fmt.Errorf("%w: %d of %d required signatures", ErrUnsignedTransaction, signed, m.required)
+	}
+	return nil
+}
+
+// MarshalJSON encodes the condition as {"required", "public_keys", "signatures"}, hex-encoded, with an
+// empty signature for each key that has not signed.
+func (m *Multisig) MarshalJSON() ([]byte, error) {
+	publicKeys := make([]string, len(m.publicKeys))
+	for i, k := range m.publicKeys {
+		b, _ := k.Bytes()
+		publicKeys[i] = hex.EncodeToString(b)
+	}
+	signatures := make([]string, len(m.signatures))
+	for i, s := range m.signatures {
+		signatures[i] = hex.EncodeToString(s)
+	}
+	return json.Marshal(struct {
+		Required   int      `json:"required"`
+		PublicKeys []string `json:"public_keys"`
+		Signatures []string `json:"signatures"`
+	}{m.required, publicKeys, signatures})
+}
+
+// UnmarshalJSON decodes a condition written by MarshalJSON; a missing signature list means none yet.
+func (m *Multisig) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Required   int      `json:"required"`
+		PublicKeys []string `json:"public_keys"`
+		Signatures []string `json:"signatures"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	publicKeys, err := ParsePublicKeys(v.PublicKeys)
+	if err != nil {
+		return err
+	}
+	if v.Signatures == nil {
+		v.Signatures = make([]string, len(publicKeys))
+	}
+	signatures := make([][]byte, len(v.Signatures))
+	for i, s := range v.Signatures {
+		if signatures[i], err = SignatureFromString(s); err != nil {
+			return fmt.Errorf("invalid signature %d: %w", i, err)
+		}
+	}
+	m.required, m.publicKeys, m.signatures = v.Required, publicKeys, signatures
+	return nil
+}
+
+// ParsePublicKeys parses hex-encoded public keys, e.g. the comma-separated -public_keys flag.
+func ParsePublicKeys(list []string) ([]*ecdsa.PublicKey, error) {
+	publicKeys := make([]*ecdsa.PublicKey, 0, len(list))
+	for _, s := range list {
+		k, err := PublicKeyFromString(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %q: %w", s, err)
+		}
+		publicKeys = append(publicKeys, k)
+	}
+	return publicKeys, nil
+}
+
+// NewMultisigTransaction constructs a transaction from the address of m, which collects the signatures
+// with SignMultisig.
+func NewMultisigTransaction(m *Multisig, recipient string, value Amount, fee Amount) *Transaction {
+	t := NewTransaction(m.Address(), recipient, value, fee)
+	t.multisig = m
+	return t
+}
+
+// Multisig returns the condition a transaction from a multisig address carries, nil for other senders.
+func (t *Transaction) Multisig() *Multisig {
+	return t.multisig
+}
+
+// SignMultisig adds the signature of privateKey, which must be one of the condition's keys, to a
+// transaction from a multisig address. Cosigners sign in any order; each signs the same hash. Once the
+// required number have signed no one else may, since nodes accept exactly that many.
+func (t *Transaction) SignMultisig(privateKey *ecdsa.PrivateKey) error {
+	if t.multisig == nil {
+		return fmt.Errorf("%w: not a multisig transaction", ErrInvalidMultisig)
+	}
+	for i, k := range t.multisig.publicKeys {
+		if !k.Equal(&privateKey.PublicKey) {
+			continue
+		}
+		if len(t.multisig.signatures[i]) == 0 && t.multisig.Signed() >= t.multisig.required {
+			return fmt.Errorf("%w: already signed by the %d required keys", ErrInvalidMultisig, t.multisig.required)
+		}
+		h := t.SigningHash()
+		signature, err := SignLowS(privateKey, h[:])
+		if err != nil {
+			return err
+		}
+		t.multisig.signatures[i] = signature
+		return nil
+	}
+	return fmt.Errorf("%w: key is not a cosigner", ErrInvalidMultisig)
+}
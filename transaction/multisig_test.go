@@ -0,0 +1,101 @@
+package transaction
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// newCosigners returns n fresh keys and their public keys.
+func newCosigners(t *testing.T, n int) ([]*ecdsa.PrivateKey, []*ecdsa.PublicKey) {
+	t.Helper()
+	keys := make([]*ecdsa.PrivateKey, n)
+	publicKeys := make([]*ecdsa.PublicKey, n)
+	for i := range keys {
+		keys[i], _ = newKey(t)
+		publicKeys[i] = &keys[i].PublicKey
+	}
+	return keys, publicKeys
+}
+
+func TestMultisigNeedsTheRequiredSignatures(t *testing.T) {
+	keys, publicKeys := newCosigners(t, 3)
+	_, recipient := newKey(t)
+	m, err := NewMultisig(2, publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := NewMultisigTransaction(m, recipient, COIN, 0)
+	if tx.SenderBlockchainAddress() != m.Address() {
+		t.Fatalf("sender = %s, want the multisig address %s", tx.SenderBlockchainAddress(), m.Address())
+	}
+	if err := tx.SignMultisig(keys[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Verify(); !errors.Is(err, ErrUnsignedTransaction) {
+		t.Fatalf("Verify with 1 of 2 signatures = %v, want %v", err, ErrUnsignedTransaction)
+	}
+	if err := tx.SignMultisig(keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Verify(); err != nil {
+		t.Fatalf("Verify with 2 of 2 signatures = %v", err)
+	}
+	outsider, _ := newKey(t)
+	if err := tx.SignMultisig(outsider); !errors.Is(err, ErrInvalidMultisig) {
+		t.Fatalf("SignMultisig by an outsider = %v, want %v", err, ErrInvalidMultisig)
+	}
+
+	m2, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Transaction
+	if err := json.Unmarshal(m2, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.Verify(); err != nil || decoded.Multisig().Signed() != 2 {
+		t.Fatalf("decoded Verify = %v with %d signatures", err, decoded.Multisig().Signed())
+	}
+	decoded.SetValue(2 * COIN)
+	if err := decoded.Verify(); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Verify after changing the value = %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestMultisigAddressCommitsToThresholdAndKeyOrder(t *testing.T) {
+	_, publicKeys := newCosigners(t, 3)
+	twoOfThree, err := NewMultisig(2, publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	threeOfThree, _ := NewMultisig(3, publicKeys)
+	reordered, _ := NewMultisig(2, []*ecdsa.PublicKey{publicKeys[1], publicKeys[0], publicKeys[2]})
+	if twoOfThree.Address() == threeOfThree.Address() || twoOfThree.Address() == reordered.Address() {
+		t.Fatal("another threshold or key order gives the same address")
+	}
+	if !ValidateAddress(twoOfThree.Address()) {
+		t.Fatal("the multisig address is invalid")
+	}
+
+	many := make([]*ecdsa.PublicKey, MAX_MULTISIG_KEYS+1)
+	for i := range many {
+		_, k := newCosigners(t, 1)
+		many[i] = k[0]
+	}
+	for name, c := range map[string]struct {
+		required int
+		keys     []*ecdsa.PublicKey
+	}{
+		"no keys":        {1, nil},
+		"too many keys":  {1, many},
+		"zero required":  {0, publicKeys},
+		"excess":         {4, publicKeys},
+		"duplicate keys": {1, []*ecdsa.PublicKey{publicKeys[0], publicKeys[0]}},
+	} {
+		if _, err := NewMultisig(c.required, c.keys); !errors.Is(err, ErrInvalidMultisig) {
+			t.Errorf("%s: NewMultisig = %v, want %v", name, err, ErrInvalidMultisig)
+		}
+	}
+}
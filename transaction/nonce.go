@@ -0,0 +1,46 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidNonce = errors.New("invalid nonce")
+
+// Nonce returns the account nonce of t: how many nonced transactions its sender confirmed before it.
+func (t *Transaction) Nonce() uint64 {
+	return t.nonce
+}
+
+// SetNonce sets the account nonce of unsigned transaction t, the sender's next one from GET /nonce.
+func (t *Transaction) SetNonce(nonce uint64) {
+	t.nonce = nonce
+}
+
+// HasNonce reports whether t counts against its sender's nonce: every transaction from TRANSACTION_VERSION_12
+// on except coinbases. Older ones keep validating as before, without replay protection beyond their hash.
+func (t *Transaction) HasNonce() bool {
+	return t.version >= TRANSACTION_VERSION_12 && t.senderBlockchainAddress != MINING_SENDER
+}
+
+// checkNonced returns ErrInvalidNonce if t is from a version before TRANSACTION_VERSION_12 and so has no
+// nonce, unless it is a coinbase. Without one, only its hash tells a copy apart, and a copy with a
+// re-encoded signature hashes differently, so it could be confirmed again.
+func (t *Transaction) checkNonced() error {
+	if t.senderBlockchainAddress != MINING_SENDER && !t.HasNonce() {
+		return fmt.Errorf("%w: version %d transactions carry none, so they could be replayed", ErrInvalidNonce, t.version)
+	}
+	return nil
+}
+
+// CheckReplayProtected returns why t could be confirmed again, under another ID, or on another network: it has
+// no nonce, a witness a relay could rewrite, or, on a network with a chain ID, no chain ID.
+func (t *Transaction) CheckReplayProtected(chainID string) error {
+	if err := t.checkNonced(); err != nil {
+		return err
+	}
+	if err := t.checkCanonical(); err != nil {
+		return err
+	}
+	return t.checkChainBound(chainID)
+}
@@ -0,0 +1,122 @@
+package transaction
+
+import (
+	"crypto/ecdsa"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+)
+
+// TransactionRequest is the JSON body accepted by POST /transactions.
+type TransactionRequest struct {
+	Version                    *uint32   `json:"version"`
+	SenderBlockchainAddress    *string   `json:"sender_blockchain_address"`
+	RecipientBlockchainAddress *string   `json:"recipient_blockchain_address"`
+	SenderPublicKey            *string   `json:"sender_public_key"`
+	Value                      *Amount   `json:"value"`
+	Fee                        *Amount   `json:"fee"`
+	Memo                       *string   `json:"memo"`
+	Data                       *string   `json:"data"`
+	LockTime                   *uint64   `json:"lock_time"`
+	Nonce                      *uint64   `json:"nonce"`
+	ChainID                    *string   `json:"chain_id"`
+	Multisig                   *Multisig `json:"multisig"`
+	LockingScript              *string   `json:"locking_script"`
+	UnlockingScript            *string   `json:"unlocking_script"`
+	Contract                   *string   `json:"contract"`
+	Code                       *string   `json:"code"`
+	Input                      []int64   `json:"input"`
+	Token                      *TokenOp  `json:"token"`
+	Asset                      *AssetOp  `json:"asset"`
+	HTLC                       *HTLC     `json:"htlc"`
+	Signature                  *string   `json:"signature"`
+}
+
+// Validate reports whether all required fields of the request are present. A data carrier, a contract
+// deployment, a token creation, and an asset mint need no recipient or value, a contract call and a token or asset transfer no value, and a multisig or script spend carries its signatures in the multisig condition or
+// the unlocking script.
+func (tr *TransactionRequest) Validate() bool {
+	return tr.SenderBlockchainAddress != nil &&
+		(tr.Data != nil || tr.Contract != nil && (*tr.Contract == CONTRACT_DEPLOY || tr.RecipientBlockchainAddress != nil) ||
+			tr.Token != nil && (tr.Token.kind == TOKEN_CREATE || tr.RecipientBlockchainAddress != nil) ||
+			tr.Asset != nil && (tr.Asset.kind == ASSET_MINT || tr.RecipientBlockchainAddress != nil) ||
+			tr.RecipientBlockchainAddress != nil && tr.Value != nil) &&
+		(tr.Multisig != nil || tr.LockingScript != nil || tr.SenderPublicKey != nil && tr.Signature != nil)
+}
+
+// Transaction converts the request into a signed Transaction, parsing the hex-encoded key and signature.
+func (tr *TransactionRequest) Transaction() (*Transaction, error) {
+	var publicKey *ecdsa.PublicKey
+	var signature []byte
+	var err error
+	if tr.SenderPublicKey != nil {
+		if publicKey, err = PublicKeyFromString(*tr.SenderPublicKey); err != nil {
+			return nil, fmt.Errorf("invalid sender_public_key: %w", err)
+		}
+	}
+	if tr.Signature != nil {
+		if signature, err = SignatureFromString(*tr.Signature); err != nil {
+			return nil, fmt.Errorf("invalid signature: %w", err)
+		}
+	}
+	var recipient string
+	var value, fee Amount
+	if tr.RecipientBlockchainAddress != nil {
+		recipient = *tr.RecipientBlockchainAddress
+	}
+	if tr.Value != nil {
+		value = *tr.Value
+	}
+	if tr.Fee != nil {
+		fee = *tr.Fee
+	}
+	t := NewTransaction(*tr.SenderBlockchainAddress, recipient, value, fee)
+	// Clients from before versioning send no version, and their signatures do not cover one.
+	t.version = TRANSACTION_VERSION_1
+	if tr.Version != nil {
+		t.version = *tr.Version
+	}
+	if tr.Memo != nil {
+		t.memo = *tr.Memo
+	}
+	if tr.LockTime != nil {
+		t.lockTime = *tr.LockTime
+	}
+	if tr.Nonce != nil {
+		t.nonce = *tr.Nonce
+	}
+	if tr.ChainID != nil {
+		t.chainID = *tr.ChainID
+	}
+	t.multisig = tr.Multisig
+	if tr.LockingScript != nil {
+		if t.lockingScript, err = hex.DecodeString(*tr.LockingScript); err != nil {
+			return nil, fmt.Errorf("invalid locking_script: %w", err)
+		}
+	}
+	if tr.UnlockingScript != nil {
+		if t.unlockingScript, err = hex.DecodeString(*tr.UnlockingScript); err != nil {
+			return nil, fmt.Errorf("invalid unlocking_script: %w", err)
+		}
+	}
+	if tr.Data != nil {
+		if t.data, err = hex.DecodeString(*tr.Data); err != nil {
+			return nil, fmt.Errorf("invalid data: %w", err)
+		}
+	}
+	if tr.Contract != nil {
+		t.contract = *tr.Contract
+	}
+	if tr.Code != nil {
+		if t.code, err = hex.DecodeString(*tr.Code); err != nil {
+			return nil, fmt.Errorf("invalid code: %w", err)
+		}
+	}
+	t.input = tr.Input
+	t.token = tr.Token
+	t.asset = tr.Asset
+	t.htlc = tr.HTLC
+	t.senderPublicKey = publicKey
+	t.signature = signature
+	return t, nil
+}
@@ -0,0 +1,313 @@
+package transaction
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Opcodes of the script language, with Bitcoin Script's byte values. Bytes 0x01 to 0x4b push that many
+// following bytes.
+const (
+	OP_0           = 0x00
+	OP_PUSHDATA1   = 0x4c
+	OP_PUSHDATA2   = 0x4d
+	OP_1           = 0x51
+	OP_VERIFY      = 0x69
+	OP_DROP        = 0x75
+	OP_DUP         = 0x76
+	OP_EQUAL       = 0x87
+	OP_EQUALVERIFY = 0x88
+	OP_SHA256      = 0xa8
+	OP_HASH160     = 0xa9
+	OP_CHECKSIG    = 0xac
+
+	// MAX_SCRIPT_SIZE, MAX_SCRIPT_ELEMENT_SIZE, and MAX_SCRIPT_STACK bound what a script costs a node to run.
+	MAX_SCRIPT_SIZE         = 1000
+	MAX_SCRIPT_ELEMENT_SIZE = 520
+	MAX_SCRIPT_STACK        = 100
+)
+
+var ErrScriptFailed = errors.New("script failed")
+
+var opcodeNames = map[byte]string{
+	OP_0:           "OP_0",
+	OP_1:           "OP_1",
+	OP_VERIFY:      "OP_VERIFY",
+	OP_DROP:        "OP_DROP",
+	OP_DUP:         "OP_DUP",
+	OP_EQUAL:       "OP_EQUAL",
+	OP_EQUALVERIFY: "OP_EQUALVERIFY",
+	OP_SHA256:      "OP_SHA256",
+	OP_HASH160:     "OP_HASH160",
+	OP_CHECKSIG:    "OP_CHECKSIG",
+}
+
+// opcodeInputs is how many stack items each opcode consumes.
+var opcodeInputs = map[byte]int{
+	OP_VERIFY: 1, OP_DROP: 1, OP_DUP: 1, OP_SHA256: 1, OP_HASH160: 1,
+	OP_EQUAL: 2, OP_EQUALVERIFY: 2, OP_CHECKSIG: 2,
+}
+
+// PushScript returns a script that pushes each of items onto the stack, each in its shortest form.
+func PushScript(items ...[]byte) []byte {
+	var script []byte
+	for _, data := range items {
+		switch n := len(data); {
+		case n == 0:
+			script = append(script, OP_0)
+		case n == 1 && data[0] == 1:
+			script = append(script, OP_1)
+			continue
+		case n < OP_PUSHDATA1:
+			script = append(script, byte(n))
+		case n <= 0xff:
+			script = append(script, OP_PUSHDATA1, byte(n))
+		default:
+			script = binary.LittleEndian.AppendUint16(append(script, OP_PUSHDATA2), uint16(n))
+		}
+		script = append(script, data...)
+	}
+	return script
+}
+
+// nextOp decodes the instruction at pc: its opcode, the data it pushes, and where the next one starts.
+func nextOp(script []byte, pc int) (byte, []byte, int, error) {
+	op := script[pc]
+	pc++
+	n := 0
+	switch {
+	case op > OP_0 && op < OP_PUSHDATA1:
+		n = int(op)
+	case op == OP_PUSHDATA1:
+		if pc+1 > len(script) {
+			return 0, nil, 0, fmt.Errorf("%w: truncated push", ErrScriptFailed)
+		}
+		n, pc = int(script[pc]), pc+1
+	case op == OP_PUSHDATA2:
+		if pc+2 > len(script) {
+			return 0, nil, 0, fmt.Errorf("%w: truncated push", ErrScriptFailed)
+		}
+		n, pc = int(binary.LittleEndian.Uint16(script[pc:])), pc+2
+	default:
+		return op, nil, pc, nil
+	}
+	if n > MAX_SCRIPT_ELEMENT_SIZE || pc+n > len(script) {
+		return 0, nil, 0, fmt.Errorf("%w: push of %d bytes", ErrScriptFailed, n)
+	}
+	return op, script[pc : pc+n], pc + n, nil
+}
+
+// isPush reports whether op only pushes data.
+func isPush(op byte) bool {
+	return op <= OP_PUSHDATA2 || op == OP_1
+}
+
+// truthy reports whether a stack item counts as true: any non-zero byte.
+func truthy(item []byte) bool {
+	for _, b := range item {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// evalScript runs script on stack and returns the resulting stack. OP_CHECKSIG checks signatures against
+// hash, the signing hash of the spending transaction.
+func evalScript(script []byte, stack [][]byte, hash [32]byte) ([][]byte, error) {
+	if len(script) > MAX_SCRIPT_SIZE {
+		return nil, fmt.Errorf("%w: script of %d bytes (at most %d)", ErrScriptFailed, len(script), MAX_SCRIPT_SIZE)
+	}
+	pop := func() []byte {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return top
+	}
+	for pc := 0; pc < len(script); {
+		op, data, next, err := nextOp(script, pc)
+		if err != nil {
+			return nil, err
+		}
+		pc = next
+		if len(stack) < opcodeInputs[op] {
+			return nil, fmt.Errorf("%w: %s on a stack of %d", ErrScriptFailed, opcodeNames[op], len(stack))
+		}
+		switch op {
+		case OP_1:
+			stack = append(stack, []byte{1})
+		case OP_VERIFY:
+			if !truthy(pop()) {
+				return nil, fmt.Errorf("%w: OP_VERIFY", ErrScriptFailed)
+			}
+		case OP_DROP:
+			pop()
+		case OP_DUP:
+			stack = append(stack, stack[len(stack)-1])
+		case OP_EQUAL, OP_EQUALVERIFY:
+			equal := bytes.Equal(pop(), pop())
+			if op == OP_EQUALVERIFY {
+				if !equal {
+					return nil, fmt.Errorf("%w: OP_EQUALVERIFY", ErrScriptFailed)
+				}
+				continue
+			}
+			stack = append(stack, scriptBool(equal))
+		case OP_SHA256:
+			h := sha256.Sum256(pop())
+			stack = append(stack, h[:])
+		case OP_HASH160:
+			h := sha256.Sum256(pop())
+			h160 := Ripemd160(h[:])
+			stack = append(stack, h160[:])
+		case OP_CHECKSIG:
+			publicKey, signature := pop(), pop()
+			k, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), publicKey)
+			stack = append(stack, scriptBool(err == nil && ecdsa.VerifyASN1(k, hash[:], signature)))
+		default:
+			if !isPush(op) {
+				return nil, fmt.Errorf("%w: unknown opcode 0x%02x", ErrScriptFailed, op)
+			}
+			stack = append(stack, data)
+		}
+		if len(stack) > MAX_SCRIPT_STACK {
+			return nil, fmt.Errorf("%w: stack above %d items", ErrScriptFailed, MAX_SCRIPT_STACK)
+		}
+	}
+	return stack, nil
+}
+
+func scriptBool(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return []byte{}
+}
+
+// VerifyScript runs the unlocking script and then the locking script on the stack it left, and returns nil
+// if the top item is then true. The unlocking script may only push data, so it supplies the inputs, such
+// as signatures, but cannot change how the locking script judges them.
+func VerifyScript(unlocking, locking []byte, hash [32]byte) error {
+	for pc := 0; pc < len(unlocking); {
+		op, _, next, err := nextOp(unlocking, pc)
+		if err != nil {
+			return err
+		}
+		if !isPush(op) {
+			return fmt.Errorf("%w: unlocking script does more than push data", ErrScriptFailed)
+		}
+		pc = next
+	}
+	stack, err := evalScript(unlocking, nil, hash)
+	if err != nil {
+		return err
+	}
+	if stack, err = evalScript(locking, stack, hash); err != nil {
+		return err
+	}
+	if len(stack) == 0 || !truthy(stack[len(stack)-1]) {
+		return fmt.Errorf("%w: ended false", ErrScriptFailed)
+	}
+	return nil
+}
+
+// ParseScript assembles a script from its text form: opcode names and hex data to push, separated by
+// spaces, e.g. "OP_SHA256 <hex> OP_EQUAL".
+func ParseScript(asm string) ([]byte, error) {
+	var script []byte
+	for _, word := range strings.Fields(asm) {
+		if op, ok := opcodeByName(word); ok {
+			script = append(script, op)
+			continue
+		}
+		data, err := hex.DecodeString(word)
+		if err != nil {
+			return nil, fmt.Errorf("neither an opcode nor hex data: %q", word)
+		}
+		script = append(script, PushScript(data)...)
+	}
+	return script, nil
+}
+
+func opcodeByName(name string) (byte, bool) {
+	for op, n := range opcodeNames {
+		if n == name {
+			return op, true
+		}
+	}
+	return 0, false
+}
+
+// DisassembleScript returns the text form of script that ParseScript reads.
+func DisassembleScript(script []byte) string {
+	words := make([]string, 0)
+	for pc := 0; pc < len(script); {
+		op, data, next, err := nextOp(script, pc)
+		if err != nil {
+			return strings.Join(append(words, "[error]"), " ")
+		}
+		pc = next
+		if name, ok := opcodeNames[op]; ok {
+			words = append(words, name)
+		} else if isPush(op) {
+			words = append(words, hex.EncodeToString(data))
+		} else {
+			words = append(words, fmt.Sprintf("OP_UNKNOWN_0x%02x", op))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// PayToPublicKeyHashScript returns the standard locking script of a single-key address:
+// OP_DUP OP_HASH160 <key hash> OP_EQUALVERIFY OP_CHECKSIG, unlocked by <signature> <public key>.
+func PayToPublicKeyHashScript(address string) ([]byte, error) {
+	hash, version, ok := addressHash(address)
+	if !ok || version != ADDRESS_VERSION {
+		return nil, fmt.Errorf("not a single-key address: %q", address)
+	}
+	script := []byte{OP_DUP, OP_HASH160}
+	script = append(script, PushScript(hash)...)
+	return append(script, OP_EQUALVERIFY, OP_CHECKSIG), nil
+}
+
+// ScriptAddress returns the address of coins locked by the locking script, which spending them reveals.
+func ScriptAddress(locking []byte) string {
+	return hashAddress(SCRIPT_ADDRESS_VERSION, locking)
+}
+
+// NewScriptTransaction constructs a transaction from the address of the locking script, to be unlocked
+// with SetUnlockingScript.
+func NewScriptTransaction(locking []byte, recipient string, value Amount, fee Amount) *Transaction {
+	t := NewTransaction(ScriptAddress(locking), recipient, value, fee)
+	t.lockingScript = locking
+	return t
+}
+
+// LockingScript returns the script a transaction from a script address reveals, nil for other senders.
+func (t *Transaction) LockingScript() []byte {
+	return t.lockingScript
+}
+
+// SetUnlockingScript sets the data that satisfies the locking script. It is not signed, as it holds the
+// signatures itself.
+func (t *Transaction) SetUnlockingScript(unlocking []byte) {
+	t.unlockingScript = unlocking
+}
+
+// VerifyScript checks that a transaction from a script address reveals the script the address hashes and
+// satisfies it.
+func (t *Transaction) VerifyScript() error {
+	if t.senderPublicKey != nil || len(t.signature) > 0 || t.multisig != nil {
+		return fmt.Errorf("%w: also signed by keys", ErrScriptFailed)
+	}
+	if ScriptAddress(t.lockingScript) != t.senderBlockchainAddress {
+		return ErrSenderKeyMismatch
+	}
+	return VerifyScript(t.unlockingScript, t.lockingScript, t.SigningHash())
+}
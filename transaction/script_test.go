@@ -0,0 +1,130 @@
+package transaction
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestPushScriptRoundTripsThroughTheDisassembler(t *testing.T) {
+	items := [][]byte{{}, {1}, {2}, bytes.Repeat([]byte{0xab}, OP_PUSHDATA1-1), bytes.Repeat([]byte{0xcd}, 0xff), bytes.Repeat([]byte{0xef}, MAX_SCRIPT_ELEMENT_SIZE)}
+	script := PushScript(items...)
+	stack, err := evalScript(script, nil, [32]byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stack) != len(items) {
+		t.Fatalf("the script pushed %d items, want %d", len(stack), len(items))
+	}
+	for i := range items {
+		if !bytes.Equal(stack[i], items[i]) {
+			t.Errorf("item %d = %x, want %x", i, stack[i], items[i])
+		}
+	}
+
+	reparsed, err := ParseScript(DisassembleScript(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reparsed, script) {
+		t.Fatalf("ParseScript(DisassembleScript(s)) = %x, want %x", reparsed, script)
+	}
+	if _, err := ParseScript("OP_DUP OP_NOPE"); err == nil {
+		t.Fatal("ParseScript of an unknown word succeeded")
+	}
+	if got := DisassembleScript([]byte{OP_PUSHDATA1}); got != "[error]" {
+		t.Fatalf("DisassembleScript of a truncated push = %q", got)
+	}
+}
+
+func TestHashLockedScriptsNeedThePreimage(t *testing.T) {
+	secret := []byte("open sesame")
+	h := sha256.Sum256(secret)
+	locking, err := ParseScript("OP_SHA256 " + hex.EncodeToString(h[:]) + " OP_EQUAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyScript(PushScript(secret), locking, [32]byte{}); err != nil {
+		t.Fatalf("VerifyScript with the preimage = %v", err)
+	}
+	if err := VerifyScript(PushScript([]byte("guess")), locking, [32]byte{}); !errors.Is(err, ErrScriptFailed) {
+		t.Fatalf("VerifyScript with a wrong preimage = %v, want %v", err, ErrScriptFailed)
+	}
+	// The unlocking script may not run opcodes of its own, such as one that leaves a true on the stack.
+	cheat := append(PushScript([]byte("guess")), OP_DUP)
+	if err := VerifyScript(cheat, append(locking, OP_DROP, OP_1), [32]byte{}); !errors.Is(err, ErrScriptFailed) {
+		t.Fatalf("VerifyScript with an opcode in the unlocking script = %v, want %v", err, ErrScriptFailed)
+	}
+}
+
+func TestScriptsFailCleanlyOnBadInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		script []byte
+	}{
+		{"empty stack", []byte{OP_DUP}},
+		{"one item for two", []byte{OP_1, OP_EQUAL}},
+		{"unknown opcode", []byte{OP_1, 0xff}},
+		{"truncated push", []byte{5, 1, 2}},
+		{"truncated length", []byte{OP_PUSHDATA2, 1}},
+		{"oversized element", PushScript(make([]byte, MAX_SCRIPT_ELEMENT_SIZE+1))},
+		{"oversized script", bytes.Repeat([]byte{OP_1, OP_DROP}, MAX_SCRIPT_SIZE/2+1)},
+		{"stack overflow", bytes.Repeat([]byte{OP_1}, MAX_SCRIPT_STACK+1)},
+		{"failed verify", []byte{OP_0, OP_VERIFY, OP_1}},
+		{"unequal", append(PushScript([]byte{2}, []byte{3}), OP_EQUALVERIFY, OP_1)},
+		{"ends false", []byte{OP_0}},
+		{"ends empty", nil},
+	}
+	for _, tt := range tests {
+		if err := VerifyScript(nil, tt.script, [32]byte{}); !errors.Is(err, ErrScriptFailed) {
+			t.Errorf("%s: VerifyScript = %v, want %v", tt.name, err, ErrScriptFailed)
+		}
+	}
+}
+
+func TestScriptTransactionsSpendWithPayToPublicKeyHash(t *testing.T) {
+	key, owner := newKey(t)
+	_, recipient := newKey(t)
+	locking, err := PayToPublicKeyHashScript(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PayToPublicKeyHashScript(ScriptAddress(locking)); err == nil {
+		t.Fatal("PayToPublicKeyHashScript of a script address succeeded")
+	}
+	tx := NewScriptTransaction(locking, recipient, COIN, 0)
+	if tx.SenderBlockchainAddress() != ScriptAddress(locking) || !bytes.Equal(tx.LockingScript(), locking) {
+		t.Fatalf("sender = %s, want the script address %s", tx.SenderBlockchainAddress(), ScriptAddress(locking))
+	}
+	h := tx.SigningHash()
+	signature, err := SignLowS(key, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, _ := key.PublicKey.Bytes()
+	tx.SetUnlockingScript(PushScript(signature, publicKey))
+	if err := tx.Verify(); err != nil {
+		t.Fatalf("Verify = %v", err)
+	}
+
+	other, _ := newKey(t)
+	otherKey, _ := other.PublicKey.Bytes()
+	tx.SetUnlockingScript(PushScript(signature, otherKey))
+	if err := tx.Verify(); !errors.Is(err, ErrScriptFailed) {
+		t.Fatalf("Verify with another key = %v, want %v", err, ErrScriptFailed)
+	}
+	tx.SetUnlockingScript(PushScript(signature, publicKey))
+	tx.SetValue(2 * COIN)
+	if err := tx.Verify(); !errors.Is(err, ErrScriptFailed) {
+		t.Fatalf("Verify after changing the value = %v, want %v", err, ErrScriptFailed)
+	}
+
+	// The locking script must be the one the sender address hashes.
+	swapped := NewScriptTransaction([]byte{OP_1}, recipient, COIN, 0)
+	swapped.senderBlockchainAddress = ScriptAddress(locking)
+	if err := swapped.Verify(); !errors.Is(err, ErrSenderKeyMismatch) {
+		t.Fatalf("Verify with another locking script = %v, want %v", err, ErrSenderKeyMismatch)
+	}
+}
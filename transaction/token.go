@@ -0,0 +1,124 @@
+package transaction
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	TOKEN_CREATE   = "create"
+	TOKEN_TRANSFER = "transfer"
+
+	// MIN_TOKEN_SYMBOL and MAX_TOKEN_SYMBOL bound a symbol's length; symbols are upper-case letters and digits.
+	MIN_TOKEN_SYMBOL = 3
+	MAX_TOKEN_SYMBOL = 8
+	// TOKEN_CREATE_FEE is the least fee registering a token pays, so symbols are not claimed for free.
+	TOKEN_CREATE_FEE Amount = 1_000_000
+)
+
+var ErrInvalidTokenTransaction = errors.New("invalid token transaction")
+
+// TokenOp is what a token transaction does: register the token symbol with amount units, all held by the
+// issuer, or transfer amount units of it to the recipient. Token units are whole and have no decimals.
+type TokenOp struct {
+	kind   string
+	symbol string
+	amount int64
+}
+
+// MarshalJSON encodes the operation as {"type", "symbol", "amount"}.
+func (op *TokenOp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type   string `json:"type"`
+		Symbol string `json:"symbol"`
+		Amount int64  `json:"amount"`
+	}{op.kind, op.symbol, op.amount})
+}
+
+// UnmarshalJSON decodes an operation written by MarshalJSON.
+func (op *TokenOp) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Type   string `json:"type"`
+		Symbol string `json:"symbol"`
+		Amount int64  `json:"amount"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	op.kind, op.symbol, op.amount = v.Type, v.Symbol, v.Amount
+	return nil
+}
+
+// NewTokenCreation constructs an unsigned transaction from issuer that registers symbol with supply units,
+// paying fee, at least TOKEN_CREATE_FEE, to the miner.
+func NewTokenCreation(issuer string, symbol string, supply int64, fee Amount) *Transaction {
+	t := NewTransaction(issuer, "", 0, fee)
+	t.token = &TokenOp{kind: TOKEN_CREATE, symbol: symbol, amount: supply}
+	return t
+}
+
+// NewTokenTransfer constructs an unsigned transaction that moves amount units of symbol from sender to
+// recipient, paying fee in coins to the miner.
+func NewTokenTransfer(sender string, recipient string, symbol string, amount int64, fee Amount) *Transaction {
+	t := NewTransaction(sender, recipient, 0, fee)
+	t.token = &TokenOp{kind: TOKEN_TRANSFER, symbol: symbol, amount: amount}
+	return t
+}
+
+// Kind returns TOKEN_CREATE or TOKEN_TRANSFER.
+func (op *TokenOp) Kind() string {
+	return op.kind
+}
+
+// Symbol returns the symbol of the token the operation registers or transfers.
+func (op *TokenOp) Symbol() string {
+	return op.symbol
+}
+
+// Amount returns how many units the operation creates or transfers.
+func (op *TokenOp) Amount() int64 {
+	return op.amount
+}
+
+// Token returns what token transaction t does, or nil for other transactions.
+func (t *Transaction) Token() *TokenOp {
+	return t.token
+}
+
+// ValidTokenSymbol reports whether s is MIN_TOKEN_SYMBOL to MAX_TOKEN_SYMBOL upper-case letters and digits.
+func ValidTokenSymbol(s string) bool {
+	if len(s) < MIN_TOKEN_SYMBOL || len(s) > MAX_TOKEN_SYMBOL {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckToken returns why token transaction t is malformed, or nil: it moves units of a valid symbol and no
+// coins, and a creation has no recipient and pays TOKEN_CREATE_FEE while a transfer has a valid recipient.
+// Whether the symbol is free or the sender holds the units depends on the chain; see tokenSpends.
+func (t *Transaction) CheckToken() error {
+	op := t.token
+	switch {
+	case op.kind != TOKEN_CREATE && op.kind != TOKEN_TRANSFER:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidTokenTransaction, op.kind)
+	case !ValidTokenSymbol(op.symbol):
+		return fmt.Errorf("%w: symbol %q is not %d to %d upper-case letters and digits", ErrInvalidTokenTransaction, op.symbol, MIN_TOKEN_SYMBOL, MAX_TOKEN_SYMBOL)
+	case op.amount <= 0:
+		return fmt.Errorf("%w: amount %d", ErrInvalidTokenTransaction, op.amount)
+	case t.value != 0 || t.fee < 0 || t.IsDataCarrier() || t.contract != "" || t.asset != nil:
+		return fmt.Errorf("%w: it moves no coins, data, contracts, or assets", ErrInvalidTokenTransaction)
+	case op.kind == TOKEN_CREATE && t.recipientBlockchainAddress != "":
+		return fmt.Errorf("%w: a creation has no recipient", ErrInvalidTokenTransaction)
+	case op.kind == TOKEN_CREATE && t.fee < TOKEN_CREATE_FEE:
+		return fmt.Errorf("%w: fee %s below %s", ErrInvalidTokenTransaction, t.fee, TOKEN_CREATE_FEE)
+	case op.kind == TOKEN_TRANSFER && !ValidateAddress(t.recipientBlockchainAddress):
+		return fmt.Errorf("%w: invalid recipient", ErrInvalidTokenTransaction)
+	}
+	return nil
+}
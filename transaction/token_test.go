@@ -0,0 +1,67 @@
+package transaction
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestCheckTokenRejectsMalformedOperations(t *testing.T) {
+	_, issuer := newKey(t)
+	_, recipient := newKey(t)
+	tests := []struct {
+		name string
+		tx   func() *Transaction
+		want error
+	}{
+		{"creation", func() *Transaction { return NewTokenCreation(issuer, "GOLD", 1000, TOKEN_CREATE_FEE) }, nil},
+		{"transfer", func() *Transaction { return NewTokenTransfer(issuer, recipient, "GOLD", 1, 0) }, nil},
+		{"short symbol", func() *Transaction { return NewTokenCreation(issuer, "AU", 1000, TOKEN_CREATE_FEE) }, ErrInvalidTokenTransaction},
+		{"long symbol", func() *Transaction { return NewTokenCreation(issuer, "GOLDCOINS", 1000, TOKEN_CREATE_FEE) }, ErrInvalidTokenTransaction},
+		{"lower-case symbol", func() *Transaction { return NewTokenCreation(issuer, "gold", 1000, TOKEN_CREATE_FEE) }, ErrInvalidTokenTransaction},
+		{"no supply", func() *Transaction { return NewTokenCreation(issuer, "GOLD", 0, TOKEN_CREATE_FEE) }, ErrInvalidTokenTransaction},
+		{"negative transfer", func() *Transaction { return NewTokenTransfer(issuer, recipient, "GOLD", -1, 0) }, ErrInvalidTokenTransaction},
+		{"cheap creation", func() *Transaction { return NewTokenCreation(issuer, "GOLD", 1000, TOKEN_CREATE_FEE-1) }, ErrInvalidTokenTransaction},
+		{"transfer to nobody", func() *Transaction { return NewTokenTransfer(issuer, "nobody", "GOLD", 1, 0) }, ErrInvalidTokenTransaction},
+		{"transfer with coins", func() *Transaction {
+			tx := NewTokenTransfer(issuer, recipient, "GOLD", 1, 0)
+			tx.SetValue(1)
+			return tx
+		}, ErrInvalidTokenTransaction},
+		{"creation with a recipient", func() *Transaction {
+			tx := NewTokenCreation(issuer, "GOLD", 1000, TOKEN_CREATE_FEE)
+			tx.recipientBlockchainAddress = recipient
+			return tx
+		}, ErrInvalidTokenTransaction},
+	}
+	for _, tt := range tests {
+		if err := tt.tx().CheckToken(); !errors.Is(err, tt.want) {
+			t.Errorf("%s: CheckToken = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestTokenOperationsAreSignedAndRoundTrip(t *testing.T) {
+	key, sender := newKey(t)
+	_, recipient := newKey(t)
+	tx := NewTokenTransfer(sender, recipient, "GOLD", 5, 0)
+	if err := tx.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	m, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Transaction
+	if err := json.Unmarshal(m, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	op := decoded.Token()
+	if op == nil || op.Kind() != TOKEN_TRANSFER || op.Symbol() != "GOLD" || op.Amount() != 5 || decoded.Verify() != nil {
+		t.Fatalf("decoded token %+v, Verify = %v", op, decoded.Verify())
+	}
+	decoded.token.amount = 500
+	if err := decoded.Verify(); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Verify after changing the amount = %v, want %v", err, ErrInvalidSignature)
+	}
+}
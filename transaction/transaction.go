@@ -0,0 +1,413 @@
+// Package transaction defines transactions: their fields and encodings, how they are signed, and the rules
+// a transaction must meet on its own, before any chain state is consulted.
+package transaction
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const MINING_SENDER = "THE BLOCKCHAIN"
+
+var (
+	ErrUnsignedTransaction = errors.New("transaction is not signed")
+	ErrSenderKeyMismatch   = errors.New("sender public key does not match sender address")
+	ErrInvalidSignature    = errors.New("invalid transaction signature")
+)
+
+// Transaction represents a transfer of value between two blockchain addresses.
+type Transaction struct {
+	version                    uint32
+	senderBlockchainAddress    string
+	recipientBlockchainAddress string
+	value                      Amount
+	fee                        Amount
+	memo                       string
+	data                       []byte
+	lockTime                   uint64
+	multisig                   *Multisig
+	lockingScript              []byte
+	unlockingScript            []byte
+	contract                   string
+	code                       []byte
+	input                      []int64
+	token                      *TokenOp
+	asset                      *AssetOp
+	htlc                       *HTLC
+	nonce                      uint64
+	chainID                    string
+	senderPublicKey            *ecdsa.PublicKey
+	signature                  []byte
+}
+
+// NewTransaction constructs a new unsigned Transaction with sender, recipient, value, and the fee paid to the miner.
+func NewTransaction(sender string, recipient string, value Amount, fee Amount) *Transaction {
+	return &Transaction{
+		version:                    TRANSACTION_VERSION,
+		senderBlockchainAddress:    sender,
+		recipientBlockchainAddress: recipient,
+		value:                      value,
+		fee:                        fee,
+	}
+}
+
+// Fee returns the fee the sender pays to the miner that includes this transaction.
+func (t *Transaction) Fee() Amount {
+	return t.fee
+}
+
+// SetFee sets the fee; the transaction must be signed after it changes.
+func (t *Transaction) SetFee(fee Amount) {
+	t.fee = fee
+}
+
+// SenderBlockchainAddress returns the address the transaction spends from, MINING_SENDER for a reward.
+func (t *Transaction) SenderBlockchainAddress() string {
+	return t.senderBlockchainAddress
+}
+
+// RecipientBlockchainAddress returns the address the value goes to.
+func (t *Transaction) RecipientBlockchainAddress() string {
+	return t.recipientBlockchainAddress
+}
+
+// Value returns the amount the transaction transfers.
+func (t *Transaction) Value() Amount {
+	return t.value
+}
+
+// SetValue sets the amount the transaction transfers; the transaction must be signed after it changes.
+func (t *Transaction) SetValue(value Amount) {
+	t.value = value
+}
+
+// SigningHash returns the SHA-256 hash of the transaction fields covered by the signature.
+func (t *Transaction) SigningHash() [32]byte {
+	m, _ := json.Marshal(struct {
+		Version   uint32   `json:"version,omitempty"`
+		Sender    string   `json:"sender_blockchain_address"`
+		Recipient string   `json:"recipient_blockchain_address"`
+		Value     Amount   `json:"value"`
+		Fee       Amount   `json:"fee"`
+		Memo      string   `json:"memo,omitempty"`
+		Data      string   `json:"data,omitempty"`
+		LockTime  uint64   `json:"lock_time,omitempty"`
+		Nonce     uint64   `json:"nonce,omitempty"`
+		ChainID   string   `json:"chain_id,omitempty"`
+		Contract  string   `json:"contract,omitempty"`
+		Code      string   `json:"code,omitempty"`
+		Input     []int64  `json:"input,omitempty"`
+		Token     *TokenOp `json:"token,omitempty"`
+		Asset     *AssetOp `json:"asset,omitempty"`
+	}{
+		Version:   t.encodedVersion(),
+		Sender:    t.senderBlockchainAddress,
+		Recipient: t.recipientBlockchainAddress,
+		Value:     t.value,
+		Fee:       t.fee,
+		Memo:      t.memo,
+		Data:      hex.EncodeToString(t.data),
+		LockTime:  t.lockTime,
+		Nonce:     t.nonce,
+		ChainID:   t.chainID,
+		Contract:  t.contract,
+		Code:      hex.EncodeToString(t.code),
+		Input:     t.input,
+		Token:     t.token,
+		Asset:     t.asset,
+	})
+	return sha256.Sum256(m)
+}
+
+// Hash returns the SHA-256 hash of the transaction's full JSON encoding, signature included.
+// It identifies the transaction as a Merkle tree leaf.
+func (t *Transaction) Hash() [32]byte {
+	m, _ := json.Marshal(t)
+	return sha256.Sum256(m)
+}
+
+// ID returns the hex-encoded Hash, the identifier used by the HTTP API and for duplicate detection. Only
+// canonical witnesses are accepted (see malleability.go), so a relay cannot give a transaction another ID.
+func (t *Transaction) ID() string {
+	return fmt.Sprintf("%x", t.Hash())
+}
+
+// Sign signs the transaction with the sender's private key and attaches the matching public key.
+func (t *Transaction) Sign(privateKey *ecdsa.PrivateKey) error {
+	h := t.SigningHash()
+	signature, err := SignLowS(privateKey, h[:])
+	if err != nil {
+		return err
+	}
+	t.senderPublicKey = &privateKey.PublicKey
+	t.signature = signature
+	return nil
+}
+
+// Cost returns what t takes from its sender's balance, its value plus its fee, or ErrInvalidAmount if either
+// is negative or the sum does not fit in an Amount, which would wrap around to a small or negative cost.
+func (t *Transaction) Cost() (Amount, error) {
+	if t.value < 0 || t.fee < 0 || t.fee > math.MaxInt64-t.value {
+		return 0, fmt.Errorf("%w: value %d plus fee %d", ErrInvalidAmount, t.value, t.fee)
+	}
+	return t.value + t.fee, nil
+}
+
+// CheckAmounts returns ErrInvalidAmount unless t transfers a positive value and pays a non-negative fee,
+// neither above MAX_MONEY, or, for a token, asset, or contract transaction or a data carrier, why it breaks
+// their rules.
+func (t *Transaction) CheckAmounts() error {
+	if t.value > MAX_MONEY || t.fee > MAX_MONEY {
+		return fmt.Errorf("%w: value or fee above %s", ErrInvalidAmount, MAX_MONEY)
+	}
+	if t.asset != nil {
+		return t.CheckAsset()
+	}
+	if t.token != nil {
+		return t.CheckToken()
+	}
+	if t.contract != "" {
+		return t.checkContract()
+	}
+	if t.IsDataCarrier() {
+		return t.CheckDataCarrier()
+	}
+	if t.value <= 0 || t.fee < 0 {
+		return ErrInvalidAmount
+	}
+	return nil
+}
+
+// Verify checks that the transaction is signed by the key that owns the sender address, for a multisig
+// sender by enough of the keys of its condition, for a script sender that it satisfies the script, and
+// for an HTLC sender that it takes the claim or the refund path.
+func (t *Transaction) Verify() error {
+	if t.htlc != nil {
+		if t.multisig != nil || len(t.lockingScript) > 0 || len(t.unlockingScript) > 0 {
+			return fmt.Errorf("%w: combined with a multisig or a script", ErrInvalidHTLC)
+		}
+		return t.htlc.verify(t)
+	}
+	if len(t.lockingScript) > 0 {
+		return t.VerifyScript()
+	}
+	if len(t.unlockingScript) > 0 {
+		return fmt.Errorf("%w: unlocking script without a locking script", ErrScriptFailed)
+	}
+	if t.multisig != nil {
+		if t.senderPublicKey != nil || len(t.signature) > 0 {
+			return fmt.Errorf("%w: signed by a single key", ErrInvalidMultisig)
+		}
+		return t.multisig.Verify(t.senderBlockchainAddress, t.SigningHash())
+	}
+	if t.senderPublicKey == nil || len(t.signature) == 0 {
+		return ErrUnsignedTransaction
+	}
+	if NewAddress(t.senderPublicKey) != t.senderBlockchainAddress {
+		return ErrSenderKeyMismatch
+	}
+	// A single-key transaction runs the standard pay-to-public-key-hash script, unlocked by its signature
+	// and key.
+	locking, _ := PayToPublicKeyHashScript(t.senderBlockchainAddress)
+	publicKey, _ := t.senderPublicKey.Bytes()
+	if VerifyScript(PushScript(t.signature, publicKey), locking, t.SigningHash()) != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Print outputs the transaction details to stdout.
+func (t *Transaction) Print() {
+	fmt.Printf("%s\n", strings.Repeat("_", 40))
+	fmt.Printf(" version: %d\n", t.version)
+	fmt.Printf(" sender_blockchain_address: %s\n", t.senderBlockchainAddress)
+	fmt.Printf(" recipient_blockchain_address: %s\n", t.recipientBlockchainAddress)
+	fmt.Printf(" value: %s\n", t.value)
+	fmt.Printf(" fee: %s\n", t.fee)
+	if t.memo != "" {
+		fmt.Printf(" memo: %q\n", t.memo)
+	}
+	if t.IsDataCarrier() {
+		fmt.Printf(" data: %x\n", t.data)
+	}
+	if t.lockTime != 0 {
+		fmt.Printf(" lock_time: %d\n", t.lockTime)
+	}
+	if t.HasNonce() {
+		fmt.Printf(" nonce: %d\n", t.nonce)
+	}
+	if t.hasChainID() {
+		fmt.Printf(" chain_id: %q\n", t.chainID)
+	}
+	if t.multisig != nil {
+		fmt.Printf(" multisig: %d of %d, %d signed\n", t.multisig.required, len(t.multisig.publicKeys), t.multisig.Signed())
+	}
+	if len(t.lockingScript) > 0 {
+		fmt.Printf(" locking_script: %s\n", DisassembleScript(t.lockingScript))
+		fmt.Printf(" unlocking_script: %s\n", DisassembleScript(t.unlockingScript))
+	}
+	if t.contract != "" {
+		fmt.Printf(" contract: %s\n", t.contract)
+	}
+	if len(t.code) > 0 {
+		fmt.Printf(" code: %s\n", DisassembleCode(t.code))
+	}
+	if len(t.input) > 0 {
+		fmt.Printf(" input: %v\n", t.input)
+	}
+	if t.token != nil {
+		fmt.Printf(" token: %s %d %s\n", t.token.kind, t.token.amount, t.token.symbol)
+	}
+	if t.asset != nil {
+		fmt.Printf(" asset: %s %s\n", t.asset.kind, t.asset.id)
+	}
+	if t.htlc != nil {
+		fmt.Printf(" htlc: hashlock %x, timeout %d, preimage %x\n", t.htlc.hashlock, t.htlc.timeout, t.htlc.preimage)
+	}
+}
+
+// MarshalJSON provides a custom JSON representation for Transaction fields.
+func (t *Transaction) MarshalJSON() ([]byte, error) {
+	var publicKey string
+	if t.senderPublicKey != nil {
+		b, _ := t.senderPublicKey.Bytes()
+		publicKey = fmt.Sprintf("%x", b)
+	}
+	return json.Marshal(struct {
+		Version   uint32    `json:"version,omitempty"`
+		Sender    string    `json:"sender_blockchain_address"`
+		Recipient string    `json:"recipient_blockchain_address"`
+		Value     Amount    `json:"value"`
+		Fee       Amount    `json:"fee"`
+		Memo      string    `json:"memo,omitempty"`
+		Data      string    `json:"data,omitempty"`
+		LockTime  uint64    `json:"lock_time,omitempty"`
+		Nonce     uint64    `json:"nonce,omitempty"`
+		ChainID   string    `json:"chain_id,omitempty"`
+		Multisig  *Multisig `json:"multisig,omitempty"`
+		Locking   string    `json:"locking_script,omitempty"`
+		Unlocking string    `json:"unlocking_script,omitempty"`
+		Contract  string    `json:"contract,omitempty"`
+		Code      string    `json:"code,omitempty"`
+		Input     []int64   `json:"input,omitempty"`
+		Token     *TokenOp  `json:"token,omitempty"`
+		Asset     *AssetOp  `json:"asset,omitempty"`
+		HTLC      *HTLC     `json:"htlc,omitempty"`
+		PublicKey string    `json:"sender_public_key,omitempty"`
+		Signature string    `json:"signature,omitempty"`
+	}{
+		Version:   t.encodedVersion(),
+		Sender:    t.senderBlockchainAddress,
+		Recipient: t.recipientBlockchainAddress,
+		Value:     t.value,
+		Fee:       t.fee,
+		Memo:      t.memo,
+		Data:      hex.EncodeToString(t.data),
+		LockTime:  t.lockTime,
+		Nonce:     t.nonce,
+		ChainID:   t.chainID,
+		Multisig:  t.multisig,
+		Locking:   hex.EncodeToString(t.lockingScript),
+		Unlocking: hex.EncodeToString(t.unlockingScript),
+		Contract:  t.contract,
+		Code:      hex.EncodeToString(t.code),
+		Input:     t.input,
+		Token:     t.token,
+		Asset:     t.asset,
+		HTLC:      t.htlc,
+		PublicKey: publicKey,
+		Signature: fmt.Sprintf("%x", t.signature),
+	})
+}
+
+// UnmarshalJSON restores a Transaction from the representation produced by MarshalJSON.
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Version   *uint32   `json:"version"`
+		Sender    *string   `json:"sender_blockchain_address"`
+		Recipient *string   `json:"recipient_blockchain_address"`
+		Value     *Amount   `json:"value"`
+		Fee       Amount    `json:"fee"`
+		Memo      string    `json:"memo"`
+		Data      string    `json:"data"`
+		LockTime  uint64    `json:"lock_time"`
+		Nonce     uint64    `json:"nonce"`
+		ChainID   string    `json:"chain_id"`
+		Multisig  *Multisig `json:"multisig"`
+		Locking   string    `json:"locking_script"`
+		Unlocking string    `json:"unlocking_script"`
+		Contract  string    `json:"contract"`
+		Code      string    `json:"code"`
+		Input     []int64   `json:"input"`
+		Token     *TokenOp  `json:"token"`
+		Asset     *AssetOp  `json:"asset"`
+		HTLC      *HTLC     `json:"htlc"`
+		PublicKey string    `json:"sender_public_key"`
+		Signature string    `json:"signature"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Sender == nil || v.Recipient == nil || v.Value == nil {
+		return errors.New("transaction is missing sender, recipient, or value")
+	}
+	t.version = TRANSACTION_VERSION_1
+	if v.Version != nil {
+		t.version = *v.Version
+	}
+	t.senderBlockchainAddress = *v.Sender
+	t.recipientBlockchainAddress = *v.Recipient
+	t.value = *v.Value
+	t.fee = v.Fee
+	t.memo = v.Memo
+	t.data = nil
+	t.lockTime = v.LockTime
+	t.nonce = v.Nonce
+	t.chainID = v.ChainID
+	t.multisig = v.Multisig
+	t.contract = v.Contract
+	t.input = v.Input
+	t.token = v.Token
+	t.asset = v.Asset
+	t.htlc = v.HTLC
+	t.senderPublicKey = nil
+	t.signature = nil
+	if v.Data != "" {
+		data, err := hex.DecodeString(v.Data)
+		if err != nil {
+			return fmt.Errorf("invalid data: %w", err)
+		}
+		t.data = data
+	}
+	var err error
+	if t.lockingScript, err = hex.DecodeString(v.Locking); err != nil {
+		return fmt.Errorf("invalid locking_script: %w", err)
+	}
+	if t.unlockingScript, err = hex.DecodeString(v.Unlocking); err != nil {
+		return fmt.Errorf("invalid unlocking_script: %w", err)
+	}
+	if t.code, err = hex.DecodeString(v.Code); err != nil {
+		return fmt.Errorf("invalid code: %w", err)
+	}
+	if v.PublicKey != "" {
+		publicKey, err := PublicKeyFromString(v.PublicKey)
+		if err != nil {
+			return err
+		}
+		t.senderPublicKey = publicKey
+	}
+	if v.Signature != "" {
+		signature, err := SignatureFromString(v.Signature)
+		if err != nil {
+			return err
+		}
+		t.signature = signature
+	}
+	return nil
+}
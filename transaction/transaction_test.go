@@ -0,0 +1,45 @@
+package transaction
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// newKey returns a fresh P-256 key and the address derived from it.
+func newKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKey, NewAddress(&privateKey.PublicKey)
+}
+
+func TestVerifyChecksTheSenderSignature(t *testing.T) {
+	key, sender := newKey(t)
+	other, recipient := newKey(t)
+	tx := NewTransaction(sender, recipient, COIN, 0)
+	if err := tx.Verify(); !errors.Is(err, ErrUnsignedTransaction) {
+		t.Fatalf("Verify unsigned = %v, want %v", err, ErrUnsignedTransaction)
+	}
+	if err := tx.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Verify(); err != nil {
+		t.Fatalf("Verify = %v", err)
+	}
+
+	tx.SetValue(2 * COIN)
+	if err := tx.Verify(); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Verify after changing the value = %v, want %v", err, ErrInvalidSignature)
+	}
+	if err := tx.Sign(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Verify(); !errors.Is(err, ErrSenderKeyMismatch) {
+		t.Fatalf("Verify signed by another key = %v, want %v", err, ErrSenderKeyMismatch)
+	}
+}
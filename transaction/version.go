@@ -0,0 +1,103 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// TRANSACTION_VERSION_1 is the original format: its JSON has no version field and its signature does not
+	// cover the version, so blocks from before versioning keep their hashes and signatures.
+	TRANSACTION_VERSION_1 = 1
+	// TRANSACTION_VERSION_2 encodes the version and signs it, so a signed transaction cannot be replayed
+	// under the rules of another version.
+	TRANSACTION_VERSION_2 = 2
+	// TRANSACTION_VERSION_3 adds the optional memo, which the hash and the signature cover.
+	TRANSACTION_VERSION_3 = 3
+	// TRANSACTION_VERSION_4 adds data-carrier transactions, which anchor data instead of moving value.
+	TRANSACTION_VERSION_4 = 4
+	// TRANSACTION_VERSION_5 adds the lock time, before which a transaction cannot be confirmed.
+	TRANSACTION_VERSION_5 = 5
+	// TRANSACTION_VERSION_6 adds spending from m-of-n multisig addresses.
+	TRANSACTION_VERSION_6 = 6
+	// TRANSACTION_VERSION_7 adds spending from script addresses with a locking and an unlocking script.
+	TRANSACTION_VERSION_7 = 7
+	// TRANSACTION_VERSION_8 adds contract deployments and calls, run by the contract VM.
+	TRANSACTION_VERSION_8 = 8
+	// TRANSACTION_VERSION_9 adds creating and transferring native tokens.
+	TRANSACTION_VERSION_9 = 9
+	// TRANSACTION_VERSION_10 adds minting and transferring unique assets.
+	TRANSACTION_VERSION_10 = 10
+	// TRANSACTION_VERSION_11 adds spending from hash time-locked (HTLC) addresses.
+	TRANSACTION_VERSION_11 = 11
+	// TRANSACTION_VERSION_12 adds the account nonce, so a signed transaction is confirmed at most once.
+	TRANSACTION_VERSION_12 = 12
+	// TRANSACTION_VERSION_13 adds the chain ID, so a signed transaction is valid on one network only.
+	TRANSACTION_VERSION_13 = 13
+
+	// TRANSACTION_VERSION is the version NewTransaction creates; nodes reject higher versions until they
+	// learn their rules.
+	TRANSACTION_VERSION = TRANSACTION_VERSION_13
+)
+
+var ErrUnsupportedTransactionVersion = errors.New("unsupported transaction version")
+
+// Version returns the format version the transaction is encoded, signed, and validated by.
+func (t *Transaction) Version() uint32 {
+	return t.version
+}
+
+// SetVersion sets the format version; the transaction must be signed after it changes.
+func (t *Transaction) SetVersion(version uint32) {
+	t.version = version
+}
+
+// encodedVersion returns the version for the transaction's JSON, zero (left out) for TRANSACTION_VERSION_1.
+func (t *Transaction) encodedVersion() uint32 {
+	if t.version == TRANSACTION_VERSION_1 {
+		return 0
+	}
+	return t.version
+}
+
+// CheckVersion returns why t breaks the rules of its version, or nil. Each version that adds fields to the
+// format adds a check that older versions leave them unset.
+func (t *Transaction) CheckVersion() error {
+	if t.version < TRANSACTION_VERSION_1 || t.version > TRANSACTION_VERSION {
+		return fmt.Errorf("%w %d (this node supports up to %d)", ErrUnsupportedTransactionVersion, t.version, TRANSACTION_VERSION)
+	}
+	if t.version < TRANSACTION_VERSION_3 && t.memo != "" {
+		return fmt.Errorf("%w: version %d has no memo", ErrInvalidMemo, t.version)
+	}
+	if t.version < TRANSACTION_VERSION_4 && t.IsDataCarrier() {
+		return fmt.Errorf("%w: version %d has no data", ErrInvalidDataCarrier, t.version)
+	}
+	if t.version < TRANSACTION_VERSION_5 && t.lockTime != 0 {
+		return fmt.Errorf("%w: version %d has no lock time", ErrUnsupportedTransactionVersion, t.version)
+	}
+	if t.version < TRANSACTION_VERSION_6 && t.multisig != nil {
+		return fmt.Errorf("%w: version %d has no multisig", ErrInvalidMultisig, t.version)
+	}
+	if t.version < TRANSACTION_VERSION_7 && (len(t.lockingScript) > 0 || len(t.unlockingScript) > 0) {
+		return fmt.Errorf("%w: version %d has no scripts", ErrScriptFailed, t.version)
+	}
+	if t.version < TRANSACTION_VERSION_8 && (t.contract != "" || len(t.code) > 0 || len(t.input) > 0) {
+		return fmt.Errorf("%w: version %d has no contracts", ErrInvalidContract, t.version)
+	}
+	if t.version < TRANSACTION_VERSION_9 && t.token != nil {
+		return fmt.Errorf("%w: version %d has no tokens", ErrInvalidTokenTransaction, t.version)
+	}
+	if t.version < TRANSACTION_VERSION_10 && t.asset != nil {
+		return fmt.Errorf("%w: version %d has no assets", ErrInvalidAsset, t.version)
+	}
+	if t.version < TRANSACTION_VERSION_11 && t.htlc != nil {
+		return fmt.Errorf("%w: version %d has no HTLCs", ErrInvalidHTLC, t.version)
+	}
+	if t.version < TRANSACTION_VERSION_12 && t.nonce != 0 {
+		return fmt.Errorf("%w: version %d has no nonce", ErrInvalidNonce, t.version)
+	}
+	if t.version < TRANSACTION_VERSION_13 && t.chainID != "" {
+		return fmt.Errorf("%w: version %d has no chain ID", ErrWrongChainID, t.version)
+	}
+	return t.checkMemo()
+}
@@ -0,0 +1,96 @@
+package transaction
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVersionOneKeepsTheUnversionedEncoding(t *testing.T) {
+	key, sender := newKey(t)
+	_, recipient := newKey(t)
+	tx := NewTransaction(sender, recipient, COIN, 0)
+	if tx.Version() != TRANSACTION_VERSION {
+		t.Fatalf("NewTransaction version = %d, want %d", tx.Version(), TRANSACTION_VERSION)
+	}
+	tx.SetVersion(TRANSACTION_VERSION_1)
+	if err := tx.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	m, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(m), `"version"`) {
+		t.Fatalf("version 1 encodes its version: %s", m)
+	}
+	var decoded Transaction
+	if err := json.Unmarshal(m, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Version() != TRANSACTION_VERSION_1 || decoded.Verify() != nil {
+		t.Fatalf("decoded version %d, Verify = %v", decoded.Version(), decoded.Verify())
+	}
+}
+
+func TestTheSignatureCoversTheVersion(t *testing.T) {
+	key, sender := newKey(t)
+	_, recipient := newKey(t)
+	tx := NewTransaction(sender, recipient, COIN, 0)
+	tx.SetVersion(TRANSACTION_VERSION_2)
+	if err := tx.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	m, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Transaction
+	if err := json.Unmarshal(m, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Version() != TRANSACTION_VERSION_2 {
+		t.Fatalf("decoded version = %d, want %d", decoded.Version(), TRANSACTION_VERSION_2)
+	}
+	decoded.SetVersion(TRANSACTION_VERSION_3)
+	if err := decoded.Verify(); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Verify under another version = %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestCheckVersionRejectsFieldsNewerThanTheVersion(t *testing.T) {
+	_, sender := newKey(t)
+	_, recipient := newKey(t)
+	for _, version := range []uint32{0, TRANSACTION_VERSION + 1} {
+		tx := NewTransaction(sender, recipient, COIN, 0)
+		tx.SetVersion(version)
+		if err := tx.CheckVersion(); !errors.Is(err, ErrUnsupportedTransactionVersion) {
+			t.Errorf("CheckVersion of version %d = %v, want %v", version, err, ErrUnsupportedTransactionVersion)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		version uint32
+		set     func(*Transaction)
+		want    error
+	}{
+		{"memo", TRANSACTION_VERSION_3, func(tx *Transaction) { tx.memo = "hi" }, ErrInvalidMemo},
+		{"lock time", TRANSACTION_VERSION_5, func(tx *Transaction) { tx.lockTime = 1 }, ErrUnsupportedTransactionVersion},
+		{"nonce", TRANSACTION_VERSION_12, func(tx *Transaction) { tx.nonce = 1 }, ErrInvalidNonce},
+		{"chain ID", TRANSACTION_VERSION_13, func(tx *Transaction) { tx.chainID = "test" }, ErrWrongChainID},
+	}
+	for _, tt := range tests {
+		tx := NewTransaction(sender, recipient, COIN, 0)
+		tt.set(tx)
+		tx.SetVersion(tt.version - 1)
+		if err := tx.CheckVersion(); !errors.Is(err, tt.want) {
+			t.Errorf("%s at version %d: CheckVersion = %v, want %v", tt.name, tt.version-1, err, tt.want)
+		}
+		tx.SetVersion(tt.version)
+		if err := tx.CheckVersion(); err != nil {
+			t.Errorf("%s at version %d: CheckVersion = %v", tt.name, tt.version, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Package wallet holds the key pairs that sign transactions and the web wallet that submits them to a node.
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+// Wallet holds an ECDSA key pair and the blockchain address derived from its public key.
+type Wallet struct {
+	privateKey        *ecdsa.PrivateKey
+	publicKey         *ecdsa.PublicKey
+	blockchainAddress string
+}
+
+// NewWallet generates a fresh P-256 key pair and derives the wallet's blockchain address from the public key.
+func NewWallet() (*Wallet, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	w := &Wallet{
+		privateKey: privateKey,
+		publicKey:  &privateKey.PublicKey,
+	}
+	w.blockchainAddress = transaction.NewAddress(w.publicKey)
+	return w, nil
+}
+
+// WalletFromPrivateKey rebuilds a wallet around an existing key, e.g. a node's configured authority key.
+func WalletFromPrivateKey(privateKey *ecdsa.PrivateKey) *Wallet {
+	return &Wallet{
+		privateKey:        privateKey,
+		publicKey:         &privateKey.PublicKey,
+		blockchainAddress: transaction.NewAddress(&privateKey.PublicKey),
+	}
+}
+
+// PrivateKey returns the wallet's ECDSA private key.
+func (w *Wallet) PrivateKey() *ecdsa.PrivateKey {
+	return w.privateKey
+}
+
+// PrivateKeyStr returns the private key scalar encoded as a hex string.
+func (w *Wallet) PrivateKeyStr() string {
+	b, _ := w.privateKey.Bytes()
+	return fmt.Sprintf("%x", b)
+}
+
+// PublicKey returns the wallet's ECDSA public key.
+func (w *Wallet) PublicKey() *ecdsa.PublicKey {
+	return w.publicKey
+}
+
+// PublicKeyStr returns the uncompressed public key point encoded as a hex string.
+func (w *Wallet) PublicKeyStr() string {
+	b, _ := w.publicKey.Bytes()
+	return fmt.Sprintf("%x", b)
+}
+
+// BlockchainAddress returns the address derived from the wallet's public key.
+func (w *Wallet) BlockchainAddress() string {
+	return w.blockchainAddress
+}
+
+// NewTransaction creates a transaction from this wallet's address to the recipient with the wallet's next
+// nonce, paying fee to the miner, and signs it for chainID with the wallet's private key.
+func (w *Wallet) NewTransaction(recipient string, value transaction.Amount, fee transaction.Amount, nonce uint64, chainID string) (*transaction.Transaction, error) {
+	t := transaction.NewTransaction(w.blockchainAddress, recipient, value, fee)
+	t.SetNonce(nonce)
+	t.SetChainID(chainID)
+	if err := t.Sign(w.privateKey); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// SignTransaction signs t, a transaction from this wallet's address with its optional fields such as the
+// memo set, after checking them against the rules of its version.
+func (w *Wallet) SignTransaction(t *transaction.Transaction) error {
+	if t.SenderBlockchainAddress() != w.blockchainAddress {
+		return transaction.ErrSenderKeyMismatch
+	}
+	if err := t.CheckVersion(); err != nil {
+		return err
+	}
+	return t.Sign(w.privateKey)
+}
+
+// NewDataCarrier creates a transaction from this wallet's address with its next nonce that anchors data,
+// paying fee to the miner, and signs it for chainID with the wallet's private key.
+func (w *Wallet) NewDataCarrier(data []byte, fee transaction.Amount, nonce uint64, chainID string) (*transaction.Transaction, error) {
+	t := transaction.NewDataCarrier(w.blockchainAddress, data, fee)
+	t.SetNonce(nonce)
+	t.SetChainID(chainID)
+	if err := t.CheckDataCarrier(); err != nil {
+		return nil, err
+	}
+	if err := t.Sign(w.privateKey); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// MarshalJSON provides a custom JSON representation for Wallet fields.
+func (w *Wallet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		PrivateKey        string `json:"private_key"`
+		PublicKey         string `json:"public_key"`
+		BlockchainAddress string `json:"blockchain_address"`
+	}{
+		PrivateKey:        w.PrivateKeyStr(),
+		PublicKey:         w.PublicKeyStr(),
+		BlockchainAddress: w.blockchainAddress,
+	})
+}
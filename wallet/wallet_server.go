@@ -0,0 +1,257 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/tls"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/dikako/how-blockchain-works/internal/httputil"
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+//go:embed templates/index.html
+var walletIndexHTML []byte
+
+// WalletServer serves a browser wallet and forwards signed transactions to a blockchain node.
+type WalletServer struct {
+	port    uint16
+	gateway string
+	client  *http.Client
+	token   string
+	tlsCert *tls.Certificate
+}
+
+// NewWalletServer constructs a WalletServer listening on port and talking to the node at gateway.
+func NewWalletServer(port uint16, gateway string) *WalletServer {
+	return &WalletServer{port: port, gateway: gateway, client: http.DefaultClient}
+}
+
+// SetTLS makes Run serve HTTPS with cert (nil for plain HTTP).
+func (ws *WalletServer) SetTLS(cert *tls.Certificate) {
+	ws.tlsCert = cert
+}
+
+// SetGatewayToken sets the bearer token sent when submitting transactions to a gateway that requires authentication.
+func (ws *WalletServer) SetGatewayToken(token string) {
+	ws.token = token
+}
+
+// SetGatewayClient sets the client used to reach the gateway, for example one that accepts its self-signed certificate.
+func (ws *WalletServer) SetGatewayClient(c *http.Client) {
+	ws.client = c
+}
+
+// Port returns the TCP port the server listens on.
+func (ws *WalletServer) Port() uint16 {
+	return ws.port
+}
+
+// Gateway returns the base URL of the blockchain node the wallet talks to.
+func (ws *WalletServer) Gateway() string {
+	return ws.gateway
+}
+
+// WalletTransactionRequest is the JSON body accepted by POST /transaction from the wallet page.
+type WalletTransactionRequest struct {
+	SenderPrivateKey           *string `json:"sender_private_key"`
+	SenderPublicKey            *string `json:"sender_public_key"`
+	SenderBlockchainAddress    *string `json:"sender_blockchain_address"`
+	RecipientBlockchainAddress *string `json:"recipient_blockchain_address"`
+	Value                      *string `json:"value"`
+	Fee                        *string `json:"fee"`
+	Memo                       *string `json:"memo"`
+	LockTime                   *uint64 `json:"lock_time"`
+}
+
+// Validate reports whether all required fields of the request are present.
+func (tr *WalletTransactionRequest) Validate() bool {
+	return tr.SenderPrivateKey != nil &&
+		tr.SenderPublicKey != nil &&
+		tr.SenderBlockchainAddress != nil &&
+		tr.RecipientBlockchainAddress != nil &&
+		tr.Value != nil
+}
+
+// Index handles GET / and serves the wallet page.
+func (ws *WalletServer) Index(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodGet {
+		log.Printf("action=index, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(walletIndexHTML)
+}
+
+// Wallet handles POST /wallet and returns a newly generated wallet.
+func (ws *WalletServer) Wallet(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		log.Printf("action=wallet, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	myWallet, err := NewWallet()
+	if err != nil {
+		log.Printf("action=wallet, status=fail, err=%v", err)
+		httputil.WriteStatus(w, http.StatusInternalServerError, "fail")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, myWallet)
+}
+
+// CreateTransaction handles POST /transaction: it signs the transaction with the submitted
+// private key, the sender's next nonce, and the chain ID from the gateway, and forwards it to the gateway's POST /transactions.
+func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		log.Printf("action=create_transaction, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	var tr WalletTransactionRequest
+	if err := json.NewDecoder(req.Body).Decode(&tr); err != nil {
+		log.Printf("action=create_transaction, status=fail, err=%v", err)
+		httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+		return
+	}
+	if !tr.Validate() {
+		log.Println("action=create_transaction, status=fail, err=missing field(s)")
+		httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+		return
+	}
+
+	privateKey, err := transaction.PrivateKeyFromString(*tr.SenderPrivateKey)
+	if err != nil {
+		log.Printf("action=create_transaction, status=fail, err=invalid private key: %v", err)
+		httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+		return
+	}
+	value, err := transaction.ParseAmount(*tr.Value)
+	if err != nil {
+		log.Printf("action=create_transaction, status=fail, err=invalid value: %v", err)
+		httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+		return
+	}
+
+	var fee transaction.Amount
+	if tr.Fee != nil && *tr.Fee != "" {
+		fee, err = transaction.ParseAmount(*tr.Fee)
+		if err != nil {
+			log.Printf("action=create_transaction, status=fail, err=invalid fee: %v", err)
+			httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+			return
+		}
+	}
+
+	t := transaction.NewTransaction(*tr.SenderBlockchainAddress, *tr.RecipientBlockchainAddress, value, fee)
+	if tr.Memo != nil {
+		t.SetMemo(*tr.Memo)
+	}
+	if tr.LockTime != nil {
+		t.SetLockTime(*tr.LockTime)
+	}
+	nonce, chainID, err := ws.gatewayNonce(t.SenderBlockchainAddress())
+	if err != nil {
+		log.Printf("action=create_transaction, status=fail, err=%v", err)
+		httputil.WriteStatus(w, http.StatusBadGateway, "fail")
+		return
+	}
+	t.SetNonce(nonce)
+	t.SetChainID(chainID)
+	if err := t.CheckVersion(); err != nil {
+		log.Printf("action=create_transaction, status=fail, err=%v", err)
+		httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+		return
+	}
+	if err := t.Sign(privateKey); err != nil {
+		log.Printf("action=create_transaction, status=fail, err=%v", err)
+		httputil.WriteStatus(w, http.StatusInternalServerError, "fail")
+		return
+	}
+
+	m, _ := json.Marshal(t)
+	gatewayReq, _ := http.NewRequest(http.MethodPost, ws.gateway+"/transactions", bytes.NewBuffer(m))
+	gatewayReq.Header.Set("Content-Type", "application/json")
+	httputil.SetBearerToken(gatewayReq, ws.token)
+	resp, err := ws.client.Do(gatewayReq)
+	if err != nil {
+		log.Printf("action=create_transaction, status=fail, err=%v", err)
+		httputil.WriteStatus(w, http.StatusBadGateway, "fail")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		httputil.WriteStatus(w, http.StatusBadRequest, "fail")
+		return
+	}
+	httputil.WriteStatus(w, http.StatusCreated, "success")
+}
+
+// gatewayNonce asks the gateway's GET /nonce for the nonce of the address's next transaction and the chain
+// ID to sign it for.
+func (ws *WalletServer) gatewayNonce(address string) (uint64, string, error) {
+	resp, err := ws.client.Get(ws.gateway + "/nonce?blockchain_address=" + url.QueryEscape(address))
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, "", fmt.Errorf("gateway answered GET /nonce with %d", resp.StatusCode)
+	}
+	var v struct {
+		Nonce   uint64 `json:"nonce"`
+		ChainID string `json:"chain_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return 0, "", err
+	}
+	return v.Nonce, v.ChainID, nil
+}
+
+// WalletAmount handles GET /wallet/amount?blockchain_address=... by asking the gateway for the balance.
+func (ws *WalletServer) WalletAmount(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Printf("action=wallet_amount, status=fail, err=invalid HTTP method %s", req.Method)
+		httputil.WriteStatus(w, http.StatusMethodNotAllowed, "fail")
+		return
+	}
+	address := req.URL.Query().Get("blockchain_address")
+	resp, err := ws.client.Get(ws.gateway + "/amount?blockchain_address=" + url.QueryEscape(address))
+	if err != nil {
+		log.Printf("action=wallet_amount, status=fail, err=%v", err)
+		httputil.WriteStatus(w, http.StatusBadGateway, "fail")
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body)
+}
+
+// Run registers the wallet routes and serves them until the process exits.
+func (ws *WalletServer) Run() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", ws.Index)
+	mux.HandleFunc("/wallet", ws.Wallet)
+	mux.HandleFunc("/transaction", ws.CreateTransaction)
+	mux.HandleFunc("/wallet/amount", ws.WalletAmount)
+
+	addr := fmt.Sprintf("0.0.0.0:%d", ws.port)
+	log.Printf("action=run, status=listening, addr=%s, tls=%t, gateway=%s", addr, ws.tlsCert != nil, ws.gateway)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(httputil.ServeHTTP(httputil.NewHTTPServer(addr, mux, ws.tlsCert), ln))
+}
@@ -0,0 +1,80 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+func TestWalletServerSignsForTheGatewayAndForwards(t *testing.T) {
+	var forwarded *transaction.Transaction
+	gateway := http.NewServeMux()
+	gateway.HandleFunc("/nonce", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"nonce": 3, "chain_id": "testnet"}`))
+	})
+	gateway.HandleFunc("/transactions", func(w http.ResponseWriter, req *http.Request) {
+		forwarded = new(transaction.Transaction)
+		if err := json.NewDecoder(req.Body).Decode(forwarded); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	node := httptest.NewServer(gateway)
+	defer node.Close()
+	ws := NewWalletServer(0, node.URL)
+
+	rec := httptest.NewRecorder()
+	ws.Index(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("GET /: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	rec = httptest.NewRecorder()
+	ws.Wallet(rec, httptest.NewRequest(http.MethodPost, "/wallet", nil))
+	var keys map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &keys); err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]any{
+		"sender_private_key":           keys["private_key"],
+		"sender_public_key":            keys["public_key"],
+		"sender_blockchain_address":    keys["blockchain_address"],
+		"recipient_blockchain_address": recipient.BlockchainAddress(),
+		"value":                        "1.5",
+		"fee":                          "0.01",
+		"memo":                         "rent",
+	})
+	rec = httptest.NewRecorder()
+	ws.CreateTransaction(rec, httptest.NewRequest(http.MethodPost, "/transaction", bytes.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /transaction: %d %s", rec.Code, rec.Body)
+	}
+	if forwarded == nil {
+		t.Fatal("nothing was forwarded to the gateway")
+	}
+	if err := forwarded.Verify(); err != nil {
+		t.Fatalf("forwarded transaction: %v", err)
+	}
+	if forwarded.Nonce() != 3 || forwarded.ChainID() != "testnet" {
+		t.Fatalf("forwarded nonce %d and chain ID %q, want the gateway's", forwarded.Nonce(), forwarded.ChainID())
+	}
+	if forwarded.Value() != 3*transaction.COIN/2 || forwarded.Fee() != transaction.COIN/100 || forwarded.Memo() != "rent" {
+		t.Fatalf("forwarded value %s, fee %s, memo %q", forwarded.Value(), forwarded.Fee(), forwarded.Memo())
+	}
+
+	body, _ = json.Marshal(map[string]any{"sender_public_key": keys["public_key"], "value": "1"})
+	rec = httptest.NewRecorder()
+	ws.CreateTransaction(rec, httptest.NewRequest(http.MethodPost, "/transaction", bytes.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("POST /transaction with missing fields: %d, want 400", rec.Code)
+	}
+}
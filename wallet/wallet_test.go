@@ -0,0 +1,56 @@
+package wallet
+
+import (
+	"crypto/elliptic"
+	"encoding/json"
+	"testing"
+
+	"github.com/dikako/how-blockchain-works/transaction"
+)
+
+func TestNewWalletKeysRoundTripThroughTheirHexEncodings(t *testing.T) {
+	w, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.PublicKey().Curve != elliptic.P256() {
+		t.Fatalf("curve %s, want P-256", w.PublicKey().Curve.Params().Name)
+	}
+	privateKey, err := transaction.PrivateKeyFromString(w.PrivateKeyStr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !privateKey.Equal(w.PrivateKey()) {
+		t.Fatal("the private key did not survive its hex encoding")
+	}
+	publicKey, err := transaction.PublicKeyFromString(w.PublicKeyStr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !publicKey.Equal(w.PublicKey()) {
+		t.Fatal("the public key did not survive its hex encoding")
+	}
+	if got := WalletFromPrivateKey(privateKey).BlockchainAddress(); got != w.BlockchainAddress() {
+		t.Fatalf("rebuilt wallet's address %s, want %s", got, w.BlockchainAddress())
+	}
+
+	other, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.PrivateKeyStr() == w.PrivateKeyStr() || other.BlockchainAddress() == w.BlockchainAddress() {
+		t.Fatal("two wallets share a key")
+	}
+
+	m, err := json.Marshal(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]string
+	if err := json.Unmarshal(m, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v["private_key"] != w.PrivateKeyStr() || v["public_key"] != w.PublicKeyStr() || v["blockchain_address"] != w.BlockchainAddress() {
+		t.Fatalf("JSON %s does not match the wallet", m)
+	}
+}